@@ -4,8 +4,10 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 const (
@@ -13,6 +15,10 @@ const (
 	// any BasicAuth information).
 	AllUsers = "*"
 
+	// AllResources is the resource which indicates all resources, such as every
+	// table in the database.
+	AllResources = "*"
+
 	// PermAll means all actions permitted.
 	PermAll = "all"
 	// PermJoin means user is permitted to join cluster.
@@ -33,8 +39,35 @@ const (
 	PermBackup = "backup"
 	// PermLoad means user can load a SQLite dump into a node.
 	PermLoad = "load"
+	// PermSnapshot means user can trigger a Raft snapshot.
+	PermSnapshot = "snapshot"
+)
+
+const (
+	// QueryLimitOff means queries are run as written.
+	QueryLimitOff = "off"
+	// QueryLimitReject means SELECT statements without a LIMIT clause are rejected.
+	QueryLimitReject = "reject"
+	// QueryLimitAppend means a LIMIT clause is added to SELECT statements without one.
+	QueryLimitAppend = "append"
 )
 
+// ValidQueryLimit returns whether the given mode is a known query limit mode.
+func ValidQueryLimit(mode string) bool {
+	return mode == QueryLimitOff || mode == QueryLimitReject || mode == QueryLimitAppend
+}
+
+// RateLimit is the rate at which the requests of a user are admitted. Reads
+// and writes are limited separately, so that a flood of one does not starve
+// the other.
+type RateLimit struct {
+	// Reads is the number of read requests admitted per second. 0 means no limit.
+	Reads float64 `json:"reads,omitempty"`
+
+	// Writes is the number of write requests admitted per second. 0 means no limit.
+	Writes float64 `json:"writes,omitempty"`
+}
+
 // BasicAuther is the interface an object must support to return basic auth information.
 type BasicAuther interface {
 	BasicAuth() (string, string, bool)
@@ -45,19 +78,46 @@ type Credential struct {
 	Username string   `json:"username,omitempty"`
 	Password string   `json:"password,omitempty"`
 	Perms    []string `json:"perms,omitempty"`
+	MaxRows  int64    `json:"max_rows,omitempty"`
+
+	// QueryLimit, if set, is how queries by this user which lack a LIMIT
+	// clause are treated, in place of the server default.
+	QueryLimit string `json:"query_limit,omitempty"`
+
+	// Params are bound to any statement, executed by this user, which
+	// references them by name.
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Tables restricts, for each perm, the tables this user may access with
+	// that perm. Access with perms not listed is not restricted.
+	Tables map[string][]string `json:"tables,omitempty"`
+
+	// RateLimit, if set, is the rate at which this user's requests are
+	// admitted, in place of the server default.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
 }
 
 // CredentialsStore stores authentication and authorization information for all users.
 type CredentialsStore struct {
-	store map[string]string
-	perms map[string]map[string]bool
+	store   map[string]string
+	perms   map[string]map[string]bool
+	maxRows map[string]int64
+	qLimit  map[string]string
+	params  map[string]map[string]interface{}
+	tables  map[string]map[string]map[string]bool
+	rates   map[string]RateLimit
 }
 
 // NewCredentialsStore returns a new instance of a CredentialStore.
 func NewCredentialsStore() *CredentialsStore {
 	return &CredentialsStore{
-		store: make(map[string]string),
-		perms: make(map[string]map[string]bool),
+		store:   make(map[string]string),
+		perms:   make(map[string]map[string]bool),
+		maxRows: make(map[string]int64),
+		qLimit:  make(map[string]string),
+		params:  make(map[string]map[string]interface{}),
+		tables:  make(map[string]map[string]map[string]bool),
+		rates:   make(map[string]RateLimit),
 	}
 }
 
@@ -76,14 +136,15 @@ func NewCredentialsStoreFromFile(path string) (*CredentialsStore, error) {
 // Load loads credential information from a reader.
 func (c *CredentialsStore) Load(r io.Reader) error {
 	dec := json.NewDecoder(r)
+	dec.UseNumber()
 	// Read open bracket
 	_, err := dec.Token()
 	if err != nil {
 		return err
 	}
 
-	var cred Credential
 	for dec.More() {
+		var cred Credential
 		err := dec.Decode(&cred)
 		if err != nil {
 			return err
@@ -93,6 +154,33 @@ func (c *CredentialsStore) Load(r io.Reader) error {
 		for _, p := range cred.Perms {
 			c.perms[cred.Username][p] = true
 		}
+		if cred.MaxRows > 0 {
+			c.maxRows[cred.Username] = cred.MaxRows
+		}
+		if cred.QueryLimit != "" {
+			if !ValidQueryLimit(cred.QueryLimit) {
+				return fmt.Errorf("invalid query_limit %q for user %s", cred.QueryLimit, cred.Username)
+			}
+			c.qLimit[cred.Username] = cred.QueryLimit
+		}
+		if len(cred.Params) > 0 {
+			c.params[cred.Username] = cred.Params
+		}
+		if cred.RateLimit != nil {
+			if cred.RateLimit.Reads < 0 || cred.RateLimit.Writes < 0 {
+				return fmt.Errorf("invalid rate_limit for user %s, rates must not be negative", cred.Username)
+			}
+			c.rates[cred.Username] = *cred.RateLimit
+		}
+		if len(cred.Tables) > 0 {
+			c.tables[cred.Username] = make(map[string]map[string]bool, len(cred.Tables))
+			for perm, tables := range cred.Tables {
+				c.tables[cred.Username][perm] = make(map[string]bool, len(tables))
+				for _, t := range tables {
+					c.tables[cred.Username][perm][strings.ToLower(t)] = true
+				}
+			}
+		}
 	}
 
 	// Read closing bracket.
@@ -116,6 +204,57 @@ func (c *CredentialsStore) Password(username string) (string, bool) {
 	return pw, ok
 }
 
+// MaxRows returns the maximum number of rows a query may return for the given
+// user, either as set directly or via AllUsers. ok is false if no limit is
+// configured for the user.
+func (c *CredentialsStore) MaxRows(username string) (n int64, ok bool) {
+	if c == nil {
+		return 0, false
+	}
+	if n, ok = c.maxRows[username]; ok {
+		return n, true
+	}
+	n, ok = c.maxRows[AllUsers]
+	return n, ok
+}
+
+// QueryLimit returns how queries by the given user which lack a LIMIT clause
+// are treated, either as set directly or via AllUsers. ok is false if no mode
+// is configured for the user.
+func (c *CredentialsStore) QueryLimit(username string) (mode string, ok bool) {
+	if c == nil {
+		return "", false
+	}
+	if mode, ok = c.qLimit[username]; ok {
+		return mode, true
+	}
+	mode, ok = c.qLimit[AllUsers]
+	return mode, ok
+}
+
+// RateLimit returns the rate at which the requests of the given user are
+// admitted, either as set directly or via AllUsers. ok is false if no rate
+// is configured for the user.
+func (c *CredentialsStore) RateLimit(username string) (rl RateLimit, ok bool) {
+	if c == nil {
+		return RateLimit{}, false
+	}
+	if rl, ok = c.rates[username]; ok {
+		return rl, true
+	}
+	rl, ok = c.rates[AllUsers]
+	return rl, ok
+}
+
+// Params returns the default parameters for the given user. Numeric values
+// are returned as json.Number.
+func (c *CredentialsStore) Params(username string) map[string]interface{} {
+	if c == nil {
+		return nil
+	}
+	return c.params[username]
+}
+
 // CheckRequest returns true if b contains a valid username and password.
 func (c *CredentialsStore) CheckRequest(b BasicAuther) bool {
 	username, password, ok := b.BasicAuth()
@@ -185,6 +324,47 @@ func (c *CredentialsStore) AA(username, password, perm string) bool {
 	return c.HasAnyPerm(username, perm, PermAll)
 }
 
+// Authorized checks authorization, for the given perm, of a user who has
+// already been authenticated by other means, such as a verified TLS client
+// certificate. If the credential store is nil, then this function always
+// returns true.
+func (c *CredentialsStore) Authorized(username, perm string) bool {
+	if c == nil {
+		return true
+	}
+
+	if c.HasAnyPerm(AllUsers, perm, PermAll) {
+		return true
+	}
+
+	if username == "" {
+		return false
+	}
+	return c.HasAnyPerm(username, perm, PermAll)
+}
+
+// HasPermForResource returns true if the access of username, with the given
+// perm, extends to the given resource, such as a table. Access granted to a
+// user directly is restricted by the tables of that user, and access granted
+// via AllUsers by the tables of AllUsers. Unrestricted access extends to every
+// resource, including AllResources. It does not check that the user has perm,
+// nor perform any password checking. If the credential store is nil, then
+// this function always returns true.
+func (c *CredentialsStore) HasPermForResource(username, perm, resource string) bool {
+	if c == nil {
+		return true
+	}
+
+	scope, ok := c.tables[username][perm]
+	if !ok && !c.perms[username][perm] && !c.perms[username][PermAll] {
+		scope, ok = c.tables[AllUsers][perm]
+	}
+	if !ok {
+		return true
+	}
+	return scope[AllResources] || scope[strings.ToLower(resource)]
+}
+
 // HasPermRequest returns true if the username returned by b has the givem perm.
 // It does not perform any password checking, but if there is no username
 // in the request, it returns false.
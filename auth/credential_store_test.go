@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -300,6 +301,111 @@ func Test_AuthPermsAA(t *testing.T) {
 	}
 }
 
+func Test_AuthPermsAuthorized(t *testing.T) {
+	var nilStore *CredentialsStore
+	if !nilStore.Authorized("username1", "foo") {
+		t.Fatalf("nil store didn't authorize")
+	}
+
+	const jsonStream = `
+		[
+			{
+				"username": "username1",
+				"perms": ["foo"]
+			},
+			{
+				"username": "admin",
+				"password": "password2",
+				"perms": ["all"]
+			},
+			{
+				"username": "*",
+				"perms": ["qux"]
+			}
+		]
+	`
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+
+	if !store.Authorized("username1", "foo") {
+		t.Fatalf("username1 not authorized for foo")
+	}
+	if store.Authorized("username1", "bar") {
+		t.Fatalf("username1 authorized for bar")
+	}
+	if !store.Authorized("username1", "qux") {
+		t.Fatalf("username1 not authorized for qux granted to all users")
+	}
+	if !store.Authorized("admin", "bar") {
+		t.Fatalf("admin not authorized for bar")
+	}
+	if store.Authorized("nonexistent", "foo") {
+		t.Fatalf("nonexistent authorized for foo")
+	}
+	if store.Authorized("", "foo") {
+		t.Fatalf("anonymous authorized for foo")
+	}
+}
+
+func Test_AuthPermsForResource(t *testing.T) {
+	var nilStore *CredentialsStore
+	if !nilStore.HasPermForResource("username1", "query", "foo") {
+		t.Fatalf("nil store didn't permit resource")
+	}
+
+	const jsonStream = `
+		[
+			{
+				"username": "analyst",
+				"password": "password1",
+				"perms": ["query", "execute"],
+				"tables": {"query": ["orders", "Customers"], "execute": ["scratch"]}
+			},
+			{
+				"username": "admin",
+				"password": "password2",
+				"perms": ["all"]
+			},
+			{
+				"username": "*",
+				"perms": ["query"],
+				"tables": {"query": ["public"]}
+			}
+		]
+	`
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		username string
+		perm     string
+		resource string
+		exp      bool
+	}{
+		{"analyst", "query", "orders", true},
+		{"analyst", "query", "ORDERS", true},
+		{"analyst", "query", "customers", true},
+		{"analyst", "query", "scratch", false},
+		{"analyst", "query", AllResources, false},
+		{"analyst", "execute", "scratch", true},
+		{"analyst", "execute", "orders", false},
+		{"analyst", "backup", "orders", true},
+		{"admin", "query", "orders", true},
+		{"admin", "query", AllResources, true},
+		{"", "query", "public", true},
+		{"", "query", "orders", false},
+		{"nonexistent", "query", "orders", false},
+	} {
+		if got := store.HasPermForResource(tt.username, tt.perm, tt.resource); got != tt.exp {
+			t.Fatalf("wrong result for %s %s on %s, exp %v, got %v", tt.username, tt.perm, tt.resource, tt.exp, got)
+		}
+	}
+}
+
 func Test_AuthPermsRequestLoadSingle(t *testing.T) {
 	const jsonStream = `
 		[
@@ -481,6 +587,195 @@ func Test_AuthPermsAllUsers(t *testing.T) {
 	}
 }
 
+func Test_AuthMaxRows(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"max_rows": 10
+			},
+			{
+				"username": "username2",
+				"password": "password2"
+			}
+		]
+	`
+
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+
+	if n, ok := store.MaxRows("username1"); !ok || n != 10 {
+		t.Fatalf("wrong max rows for username1, got %d, %v", n, ok)
+	}
+	if _, ok := store.MaxRows("username2"); ok {
+		t.Fatalf("username2 should have no max rows")
+	}
+	if _, ok := store.MaxRows("nonexistent"); ok {
+		t.Fatalf("nonexistent user should have no max rows")
+	}
+
+	const jsonStreamAllUsers = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"max_rows": 10
+			},
+			{
+				"username": "*",
+				"max_rows": 100
+			}
+		]
+	`
+	store = NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStreamAllUsers)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+	if n, ok := store.MaxRows("username1"); !ok || n != 10 {
+		t.Fatalf("wrong max rows for username1, got %d, %v", n, ok)
+	}
+	if n, ok := store.MaxRows("username2"); !ok || n != 100 {
+		t.Fatalf("wrong max rows for username2 via *, got %d, %v", n, ok)
+	}
+
+	var nilStore *CredentialsStore
+	if _, ok := nilStore.MaxRows("username1"); ok {
+		t.Fatalf("nil store should have no max rows")
+	}
+}
+
+func Test_AuthRateLimit(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"rate_limit": {"reads": 100, "writes": 10}
+			},
+			{
+				"username": "username2",
+				"password": "password2"
+			}
+		]
+	`
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+	if rl, ok := store.RateLimit("username1"); !ok || rl.Reads != 100 || rl.Writes != 10 {
+		t.Fatalf("wrong rate limit for username1, got %v, %v", rl, ok)
+	}
+	if _, ok := store.RateLimit("username2"); ok {
+		t.Fatalf("username2 should have no rate limit")
+	}
+
+	const jsonStreamAllUsers = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"rate_limit": {"writes": 10}
+			},
+			{
+				"username": "*",
+				"rate_limit": {"reads": 5, "writes": 1}
+			}
+		]
+	`
+	store = NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStreamAllUsers)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+	if rl, ok := store.RateLimit("username1"); !ok || rl.Reads != 0 || rl.Writes != 10 {
+		t.Fatalf("wrong rate limit for username1, got %v, %v", rl, ok)
+	}
+	if rl, ok := store.RateLimit("username2"); !ok || rl.Reads != 5 || rl.Writes != 1 {
+		t.Fatalf("wrong rate limit for username2 via *, got %v, %v", rl, ok)
+	}
+
+	store = NewCredentialsStore()
+	if err := store.Load(strings.NewReader(`[{"username": "u", "rate_limit": {"reads": -1}}]`)); err == nil {
+		t.Fatalf("expected error loading negative rate limit")
+	}
+
+	var nilStore *CredentialsStore
+	if _, ok := nilStore.RateLimit("username1"); ok {
+		t.Fatalf("nil store should have no rate limit")
+	}
+}
+
+func Test_AuthQueryLimit(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"query_limit": "append"
+			},
+			{
+				"username": "*",
+				"query_limit": "reject"
+			}
+		]
+	`
+
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+	if m, ok := store.QueryLimit("username1"); !ok || m != QueryLimitAppend {
+		t.Fatalf("wrong query limit for username1, got %s, %v", m, ok)
+	}
+	if m, ok := store.QueryLimit("username2"); !ok || m != QueryLimitReject {
+		t.Fatalf("wrong query limit for username2 via *, got %s, %v", m, ok)
+	}
+
+	store = NewCredentialsStore()
+	if err := store.Load(strings.NewReader(`[{"username": "username1", "query_limit": "sometimes"}]`)); err == nil {
+		t.Fatalf("invalid query limit loaded")
+	}
+
+	var nilStore *CredentialsStore
+	if _, ok := nilStore.QueryLimit("username1"); ok {
+		t.Fatalf("nil store should have no query limit")
+	}
+}
+
+func Test_AuthParams(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "username1",
+				"password": "password1",
+				"params": {"tenant_id": 42, "region": "eu"}
+			},
+			{
+				"username": "username2",
+				"password": "password2"
+			}
+		]
+	`
+
+	store := NewCredentialsStore()
+	if err := store.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err.Error())
+	}
+
+	params := store.Params("username1")
+	if exp, got := json.Number("42"), params["tenant_id"]; exp != got {
+		t.Fatalf("wrong tenant_id param, exp %v, got %v", exp, got)
+	}
+	if exp, got := "eu", params["region"]; exp != got {
+		t.Fatalf("wrong region param, exp %v, got %v", exp, got)
+	}
+	if params := store.Params("username2"); len(params) != 0 {
+		t.Fatalf("username2 should have no params, got %v", params)
+	}
+}
+
 func mustWriteTempFile(t *testing.T, s string) string {
 	f, err := os.CreateTemp(t.TempDir(), "rqlite-test")
 	if err != nil {
@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenMalformed is returned when a token is not a well-formed JWT.
+	ErrTokenMalformed = errors.New("malformed token")
+
+	// ErrTokenSignature is returned when no key verifies the signature of a token.
+	ErrTokenSignature = errors.New("invalid token signature")
+
+	// ErrTokenExpired is returned when a token has expired, or is not yet valid.
+	ErrTokenExpired = errors.New("token expired or not yet valid")
+)
+
+const (
+	// DefaultUsernameClaim is the claim which names the user, by default.
+	DefaultUsernameClaim = "sub"
+
+	// DefaultRolesClaim is the claim which lists the roles of the user, by default.
+	DefaultRolesClaim = "roles"
+)
+
+// jwtKey is a public key which may verify the signature of a token.
+type jwtKey struct {
+	id  string
+	key crypto.PublicKey
+}
+
+// JWTValidator validates JSON Web Tokens signed with an RSA, ECDSA, or Ed25519
+// key, and returns the user each names and the perms it grants. Tokens signed
+// with a shared secret, or not signed at all, are rejected.
+type JWTValidator struct {
+	keys []jwtKey
+
+	// Issuer, if set, must match the iss claim of a token.
+	Issuer string
+
+	// Audience, if set, must be among the aud claim of a token.
+	Audience string
+
+	// UsernameClaim is the claim naming the user.
+	UsernameClaim string
+
+	// RolesClaim is the claim listing the roles of the user, either as an
+	// array or a space-separated string.
+	RolesClaim string
+
+	// RolePerms maps each role to the perms it grants. If nil, each role is
+	// itself a perm.
+	RolePerms map[string][]string
+
+	// Leeway is the clock skew tolerated when checking the exp and nbf claims.
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// NewJWTValidator returns a JWTValidator which verifies tokens using any of
+// the public keys read from r. r contains either a JSON Web Key Set, or one or
+// more PEM-encoded public keys or certificates.
+func NewJWTValidator(r io.Reader) (*JWTValidator, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jwtKey
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		keys, err = parseJWKS(b)
+	} else {
+		keys, err = parsePEMKeys(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+
+	return &JWTValidator{
+		keys:          keys,
+		UsernameClaim: DefaultUsernameClaim,
+		RolesClaim:    DefaultRolesClaim,
+		Leeway:        time.Minute,
+		now:           time.Now,
+	}, nil
+}
+
+// NewJWTValidatorFromFile returns a JWTValidator using the public keys in the
+// file at path.
+func NewJWTValidatorFromFile(path string) (*JWTValidator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewJWTValidator(f)
+}
+
+// LoadRolePerms loads, from the file at path, a JSON object mapping each role
+// to the perms it grants.
+func LoadRolePerms(path string) (map[string][]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string][]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate verifies the signature and claims of the token, and returns the
+// user it names and the perms it grants.
+func (v *JWTValidator) Validate(token string) (string, []string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", nil, ErrTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, ErrTokenMalformed
+	}
+	if err := v.verify(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return "", nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", nil, ErrTokenMalformed
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return "", nil, err
+	}
+
+	username, _ := claims[v.UsernameClaim].(string)
+	if username == "" {
+		return "", nil, fmt.Errorf("token has no %s claim", v.UsernameClaim)
+	}
+	return username, v.perms(claims[v.RolesClaim]), nil
+}
+
+// verify checks that one of the keys, with the given ID if set, verifies the
+// signature using the given algorithm.
+func (v *JWTValidator) verify(alg, kid string, signed, sig []byte) error {
+	for _, k := range v.keys {
+		if kid != "" && k.id != "" && k.id != kid {
+			continue
+		}
+		ok, err := verifySignature(alg, k.key, signed, sig)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrTokenSignature
+}
+
+// checkClaims checks the registered claims of a token. A token must expire.
+func (v *JWTValidator) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrTokenExpired
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return fmt.Errorf("token not issued by %s", v.Issuer)
+		}
+	}
+	if v.Audience != "" && !containsString(claimStrings(claims["aud"]), v.Audience) {
+		return fmt.Errorf("token not intended for audience %s", v.Audience)
+	}
+	return nil
+}
+
+// perms returns the perms granted by the given roles claim.
+func (v *JWTValidator) perms(roles interface{}) []string {
+	var perms []string
+	for _, role := range claimStrings(roles) {
+		if v.RolePerms == nil {
+			perms = append(perms, role)
+			continue
+		}
+		perms = append(perms, v.RolePerms[role]...)
+	}
+	return perms
+}
+
+// verifySignature returns whether key verifies the signature using the given
+// algorithm. It returns an error if the algorithm is not supported.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) (bool, error) {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, signed, sig), nil
+	default:
+		return false, fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	digest := hashSum(hash, signed)
+
+	switch alg[0] {
+	case 'R':
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil, nil
+	case 'P':
+		k, ok := key.(*rsa.PublicKey)
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		return ok && rsa.VerifyPSS(k, hash, digest, sig, opts) == nil, nil
+	default:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false, nil
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s), nil
+	}
+}
+
+func hashSum(hash crypto.Hash, b []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		h := sha512.Sum384(b)
+		return h[:]
+	case crypto.SHA512:
+		h := sha512.Sum512(b)
+		return h[:]
+	default:
+		h := sha256.Sum256(b)
+		return h[:]
+	}
+}
+
+// parsePEMKeys returns the public keys, and those of any certificates, in b.
+func parsePEMKeys(b []byte) ([]jwtKey, error) {
+	var keys []jwtKey
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return keys, nil
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwtKey{key: key})
+	}
+}
+
+// parseJWKS returns the signing keys of the JSON Web Key Set in b.
+func parseJWKS(b []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", k.Kid, err.Error())
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %s: invalid exponent", k.Kid)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("key %s: unsupported curve %s", k.Kid, k.Crv)
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", k.Kid, err.Error())
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", k.Kid, err.Error())
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %s: point not on curve", k.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("key %s: invalid Ed25519 key", k.Kid)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		keys = append(keys, jwtKey{id: k.Kid, key: key})
+	}
+	return keys, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// claimStrings returns the strings of a claim which is either a string of
+// space-separated values, or an array of strings.
+func claimStrings(c interface{}) []string {
+	switch v := c.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var ss []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
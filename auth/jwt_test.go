@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_JWTValidatorRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	v := mustNewJWTValidator(t, mustPEMPublicKey(t, &key.PublicKey))
+	v.Issuer = "https://idp.example.com"
+	v.Audience = "rqlite"
+
+	exp := time.Now().Add(time.Hour).Unix()
+	for _, tt := range []struct {
+		name   string
+		claims map[string]interface{}
+		alg    string
+		user   string
+		perms  []string
+		err    bool
+	}{
+		{
+			name:   "valid",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "rqlite", "exp": exp, "roles": []string{"query", "status"}},
+			alg:    "RS256",
+			user:   "alice",
+			perms:  []string{"query", "status"},
+		},
+		{
+			name:   "valid PSS, audience array, space-separated roles",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": []string{"other", "rqlite"}, "exp": exp, "roles": "execute query"},
+			alg:    "PS384",
+			user:   "alice",
+			perms:  []string{"execute", "query"},
+		},
+		{
+			name:   "expired",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "rqlite", "exp": time.Now().Add(-time.Hour).Unix()},
+			alg:    "RS256",
+			err:    true,
+		},
+		{
+			name:   "not yet valid",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "rqlite", "exp": exp, "nbf": exp},
+			alg:    "RS256",
+			err:    true,
+		},
+		{
+			name:   "no expiry",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "rqlite"},
+			alg:    "RS256",
+			err:    true,
+		},
+		{
+			name:   "wrong issuer",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://evil.example.com", "aud": "rqlite", "exp": exp},
+			alg:    "RS256",
+			err:    true,
+		},
+		{
+			name:   "wrong audience",
+			claims: map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "other", "exp": exp},
+			alg:    "RS256",
+			err:    true,
+		},
+		{
+			name:   "no username",
+			claims: map[string]interface{}{"iss": "https://idp.example.com", "aud": "rqlite", "exp": exp},
+			alg:    "RS256",
+			err:    true,
+		},
+	} {
+		token := mustSignJWT(t, tt.alg, "", key, tt.claims)
+		user, perms, err := v.Validate(token)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("%s: expected error validating token", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to validate token: %s", tt.name, err)
+		}
+		if user != tt.user {
+			t.Fatalf("%s: wrong user, exp %s, got %s", tt.name, tt.user, user)
+		}
+		if !reflect.DeepEqual(perms, tt.perms) {
+			t.Fatalf("%s: wrong perms, exp %v, got %v", tt.name, tt.perms, perms)
+		}
+	}
+}
+
+func Test_JWTValidatorRejects(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	v := mustNewJWTValidator(t, mustPEMPublicKey(t, &key.PublicKey))
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if _, _, err := v.Validate(mustSignJWT(t, "RS256", "", otherKey, claims)); err != ErrTokenSignature {
+		t.Fatalf("expected signature error for token signed by other key, got %v", err)
+	}
+
+	token := mustSignJWT(t, "RS256", "", key, claims)
+	parts := strings.Split(token, ".")
+	tampered, _ := json.Marshal(map[string]interface{}{"sub": "admin", "exp": claims["exp"]})
+	parts[1] = base64.RawURLEncoding.EncodeToString(tampered)
+	if _, _, err := v.Validate(strings.Join(parts, ".")); err != ErrTokenSignature {
+		t.Fatalf("expected signature error for tampered token, got %v", err)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "."
+	if _, _, err := v.Validate(unsigned); err == nil {
+		t.Fatalf("expected error for unsigned token")
+	}
+
+	for _, token := range []string{"", "abc", "a.b.c", "a.b"} {
+		if _, _, err := v.Validate(token); err == nil {
+			t.Fatalf("expected error for malformed token %q", token)
+		}
+	}
+}
+
+func Test_JWTValidatorJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": %q, "e": %q},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": %q},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": %q, "e": %q}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))),
+		b64(edPub),
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()))
+	v := mustNewJWTValidator(t, []byte(jwks))
+	if len(v.keys) != 3 {
+		t.Fatalf("expected 3 signing keys, got %d", len(v.keys))
+	}
+	v.RolePerms = map[string][]string{
+		"admin":  {PermAll},
+		"reader": {PermQuery, PermStatus},
+	}
+
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"reader", "unknown"}}
+	for _, tt := range []struct {
+		alg string
+		kid string
+		key crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey},
+		{"EdDSA", "ed", edKey},
+		{"ES256", "", ecKey},
+	} {
+		user, perms, err := v.Validate(mustSignJWT(t, tt.alg, tt.kid, tt.key, claims))
+		if err != nil {
+			t.Fatalf("%s: failed to validate token: %s", tt.alg, err)
+		}
+		if user != "alice" {
+			t.Fatalf("%s: wrong user, got %s", tt.alg, user)
+		}
+		if exp := []string{PermQuery, PermStatus}; !reflect.DeepEqual(perms, exp) {
+			t.Fatalf("%s: wrong perms, exp %v, got %v", tt.alg, exp, perms)
+		}
+	}
+
+	// A token naming one key must not be verified by another.
+	if _, _, err := v.Validate(mustSignJWT(t, "RS256", "ec", rsaKey, claims)); err != ErrTokenSignature {
+		t.Fatalf("expected signature error for token with wrong key ID, got %v", err)
+	}
+}
+
+func mustNewJWTValidator(t *testing.T, b []byte) *JWTValidator {
+	t.Helper()
+	v, err := NewJWTValidator(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to create JWT validator: %s", err)
+	}
+	return v
+}
+
+func mustPEMPublicKey(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func mustSignJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "EdDSA":
+		sig, err = key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	case "ES256":
+		digest := hashSum(crypto.SHA256, []byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest)
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "RS256":
+		sig, err = key.Sign(rand.Reader, hashSum(crypto.SHA256, []byte(signed)), crypto.SHA256)
+	case "PS384":
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}
+		sig, err = key.Sign(rand.Reader, hashSum(crypto.SHA384, []byte(signed)), opts)
+	default:
+		t.Fatalf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
@@ -127,6 +127,78 @@ func (c *Client) GetNodeAPIAddr(nodeAddr string, timeout time.Duration) (string,
 	return a.Url, nil
 }
 
+// GetNodeMeta retrieves the metadata, including the API Address and Raft
+// indexes, for the node at nodeAddr.
+func (c *Client) GetNodeMeta(nodeAddr string, timeout time.Duration) (*proto.NodeMeta, error) {
+	c.lMu.RLock()
+	defer c.lMu.RUnlock()
+	if c.localNodeAddr == nodeAddr && c.localServ != nil {
+		// Serve it locally!
+		stats.Add(numGetNodeAPIRequestLocal, 1)
+		return c.localServ.nodeMeta()
+	}
+
+	command := &proto.Command{
+		Type: proto.Command_COMMAND_TYPE_GET_NODE_API_URL,
+	}
+	p, nr, err := c.retry(command, nodeAddr, timeout, defaultMaxRetries)
+	stats.Add(numGetNodeAPIRequestRetries, int64(nr))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &proto.NodeMeta{}
+	err = pb.Unmarshal(p, a)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf unmarshal: %w", err)
+	}
+	return a, nil
+}
+
+// GetNodeFeatures retrieves the features of the Raft log commands supported
+// by the node at nodeAddr.
+func (c *Client) GetNodeFeatures(nodeAddr string, timeout time.Duration) ([]string, error) {
+	meta, err := c.GetNodeMeta(nodeAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Features, nil
+}
+
+// GetNodeTime retrieves the current time on the node at nodeAddr. It also
+// returns the round-trip time of the request, which callers can use to
+// bound the accuracy of any clock comparison.
+func (c *Client) GetNodeTime(nodeAddr string, timeout time.Duration) (time.Time, time.Duration, error) {
+	c.lMu.RLock()
+	defer c.lMu.RUnlock()
+	if c.localNodeAddr == nodeAddr && c.localServ != nil {
+		// Serve it locally!
+		stats.Add(numGetNodeAPIRequestLocal, 1)
+		return time.Now(), 0, nil
+	}
+
+	command := &proto.Command{
+		Type: proto.Command_COMMAND_TYPE_GET_NODE_API_URL,
+	}
+	start := time.Now()
+	p, nr, err := c.retry(command, nodeAddr, timeout, 0)
+	rtt := time.Since(start)
+	stats.Add(numGetNodeAPIRequestRetries, int64(nr))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	a := &proto.NodeMeta{}
+	err = pb.Unmarshal(p, a)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("protobuf unmarshal: %w", err)
+	}
+	if a.Time == 0 {
+		return time.Time{}, 0, fmt.Errorf("node at %s did not report its time", nodeAddr)
+	}
+	return time.Unix(0, a.Time), rtt, nil
+}
+
 // Execute performs an Execute on a remote node. If username is an empty string
 // no credential information will be included in the Execute request to the
 // remote node.
@@ -290,6 +362,33 @@ func (c *Client) Load(lr *command.LoadRequest, nodeAddr string, creds *proto.Cre
 	return nil
 }
 
+// LoadChunk loads a chunk of a SQLite file into the database. A chunk is not
+// retried, since the node may have applied it before the failure.
+func (c *Client) LoadChunk(lcr *command.LoadChunkRequest, nodeAddr string, creds *proto.Credentials, timeout time.Duration) error {
+	command := &proto.Command{
+		Type: proto.Command_COMMAND_TYPE_LOAD_CHUNK,
+		Request: &proto.Command_LoadChunkRequest{
+			LoadChunkRequest: lcr,
+		},
+		Credentials: creds,
+	}
+	p, _, err := c.retry(command, nodeAddr, timeout, 0)
+	if err != nil {
+		return err
+	}
+
+	a := &proto.CommandLoadChunkResponse{}
+	err = pb.Unmarshal(p, a)
+	if err != nil {
+		return err
+	}
+
+	if a.Error != "" {
+		return errors.New(a.Error)
+	}
+	return nil
+}
+
 // RemoveNode removes a node from the cluster
 func (c *Client) RemoveNode(rn *command.RemoveNodeRequest, nodeAddr string, creds *proto.Credentials, timeout time.Duration) error {
 	conn, err := c.dial(nodeAddr, c.timeout)
@@ -57,6 +57,46 @@ func Test_ClientGetNodeAPIAddr(t *testing.T) {
 	}
 }
 
+func Test_ClientGetNodeTime(t *testing.T) {
+	remote := time.Unix(1700000000, 0)
+	srv := servicetest.NewService()
+	srv.Handler = func(conn net.Conn) {
+		var p []byte
+		var err error
+		c := readCommand(conn)
+		if c == nil {
+			// Error on connection, so give up, as normal
+			// test exit can cause that too.
+			return
+		}
+		if c.Type != proto.Command_COMMAND_TYPE_GET_NODE_API_URL {
+			t.Fatalf("unexpected command type: %d", c.Type)
+		}
+		p, err = pb.Marshal(&proto.NodeMeta{
+			Url:  "http://localhost:1234",
+			Time: remote.UnixNano(),
+		})
+		if err != nil {
+			conn.Close()
+		}
+		writeBytesWithLength(conn, p)
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := NewClient(&simpleDialer{}, 0)
+	tm, rtt, err := c.GetNodeTime(srv.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Equal(remote) {
+		t.Fatalf("unexpected time, got %s, exp: %s", tm, remote)
+	}
+	if rtt <= 0 {
+		t.Fatalf("expected positive RTT, got %s", rtt)
+	}
+}
+
 func Test_ClientExecute(t *testing.T) {
 	srv := servicetest.NewService()
 	srv.Handler = func(conn net.Conn) {
@@ -31,6 +31,7 @@ const (
 	numRequestRequest     = "num_request_req"
 	numBackupRequest      = "num_backup_req"
 	numLoadRequest        = "num_load_req"
+	numLoadChunkRequest   = "num_load_chunk_req"
 	numRemoveNodeRequest  = "num_remove_node_req"
 	numNotifyRequest      = "num_notify_req"
 	numJoinRequest        = "num_join_req"
@@ -63,6 +64,7 @@ func init() {
 	stats.Add(numRequestRequest, 0)
 	stats.Add(numBackupRequest, 0)
 	stats.Add(numLoadRequest, 0)
+	stats.Add(numLoadChunkRequest, 0)
 	stats.Add(numRemoveNodeRequest, 0)
 	stats.Add(numGetNodeAPIRequestLocal, 0)
 	stats.Add(numNotifyRequest, 0)
@@ -99,6 +101,9 @@ type Database interface {
 
 	// Loads an entire SQLite file into the database
 	Load(lr *command.LoadRequest) error
+
+	// LoadChunk loads a chunk of a SQLite file into the database.
+	LoadChunk(lcr *command.LoadChunkRequest) error
 }
 
 // Manager is the interface node-management systems must implement
@@ -109,6 +114,9 @@ type Manager interface {
 	// CommitIndex returns the Raft commit index of the cluster.
 	CommitIndex() (uint64, error)
 
+	// AppliedIndex returns the index of the last Raft log applied by this node.
+	AppliedIndex() (uint64, error)
+
 	// Remove removes the node, given by id, from the cluster
 	Remove(rn *command.RemoveNodeRequest) error
 
@@ -118,6 +126,10 @@ type Manager interface {
 
 	// Join joins a remote node to the cluster.
 	Join(n *command.JoinRequest) error
+
+	// Features returns the features of the Raft log commands this node
+	// supports.
+	Features() []string
 }
 
 // CredentialStore is the interface credential stores must support.
@@ -137,8 +149,9 @@ type Service struct {
 	credentialStore CredentialStore
 
 	mu      sync.RWMutex
-	https   bool   // Serving HTTPS?
-	apiAddr string // host:port this node serves the HTTP API.
+	https   bool              // Serving HTTPS?
+	apiAddr string            // host:port this node serves the HTTP API.
+	labels  map[string]string // Labels, such as role, describing this node.
 
 	logger *log.Logger
 }
@@ -187,6 +200,13 @@ func (s *Service) SetAPIAddr(addr string) {
 	s.apiAddr = addr
 }
 
+// SetLabels sets the labels the cluster service reports for this node.
+func (s *Service) SetLabels(labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.labels = labels
+}
+
 // GetAPIAddr returns the previously-set API address
 func (s *Service) GetAPIAddr() string {
 	s.mu.RLock()
@@ -207,6 +227,33 @@ func (s *Service) GetNodeAPIURL() string {
 	return fmt.Sprintf("%s://%s", scheme, s.apiAddr)
 }
 
+// nodeMeta returns metadata about this node.
+func (s *Service) nodeMeta() (*proto.NodeMeta, error) {
+	ci, err := s.mgr.CommitIndex()
+	if err != nil {
+		return nil, err
+	}
+	ai, err := s.mgr.AppliedIndex()
+	if err != nil {
+		return nil, err
+	}
+	return &proto.NodeMeta{
+		Url:          s.GetNodeAPIURL(),
+		CommitIndex:  ci,
+		AppliedIndex: ai,
+		Time:         time.Now().UnixNano(),
+		Labels:       s.getLabels(),
+		Features:     s.mgr.Features(),
+	}, nil
+}
+
+// getLabels returns the labels set for this node.
+func (s *Service) getLabels() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.labels
+}
+
 // Stats returns status of the Service.
 func (s *Service) Stats() (map[string]interface{}, error) {
 	st := map[string]interface{}{
@@ -214,6 +261,9 @@ func (s *Service) Stats() (map[string]interface{}, error) {
 		"https":    strconv.FormatBool(s.https),
 		"api_addr": s.apiAddr,
 	}
+	if labels := s.getLabels(); len(labels) > 0 {
+		st["labels"] = labels
+	}
 
 	return st, nil
 }
@@ -288,15 +338,12 @@ func (s *Service) handleConn(conn net.Conn) {
 		switch c.Type {
 		case proto.Command_COMMAND_TYPE_GET_NODE_API_URL:
 			stats.Add(numGetNodeAPIRequest, 1)
-			ci, err := s.mgr.CommitIndex()
+			nm, err := s.nodeMeta()
 			if err != nil {
 				conn.Close()
 				return
 			}
-			p, err = pb.Marshal(&proto.NodeMeta{
-				Url:         s.GetNodeAPIURL(),
-				CommitIndex: ci,
-			})
+			p, err = pb.Marshal(nm)
 			if err != nil {
 				conn.Close()
 			}
@@ -438,8 +485,18 @@ func (s *Service) handleConn(conn net.Conn) {
 			marshalAndWrite(conn, resp)
 
 		case proto.Command_COMMAND_TYPE_LOAD_CHUNK:
-			resp := &proto.CommandLoadChunkResponse{
-				Error: "unsupported",
+			stats.Add(numLoadChunkRequest, 1)
+			resp := &proto.CommandLoadChunkResponse{}
+
+			lcr := c.GetLoadChunkRequest()
+			if lcr == nil {
+				resp.Error = "LoadChunkRequest is nil"
+			} else if !s.checkCommandPerm(c, auth.PermLoad) {
+				resp.Error = "unauthorized"
+			} else {
+				if err := s.db.LoadChunk(lcr); err != nil {
+					resp.Error = fmt.Sprintf("remote node failed to load chunk: %s", err.Error())
+				}
 			}
 			marshalAndWrite(conn, resp)
 
@@ -358,6 +358,60 @@ func Test_ServiceLoad(t *testing.T) {
 	}
 }
 
+func Test_ServiceLoadChunk(t *testing.T) {
+	ln, mux := mustNewMux()
+	go mux.Serve()
+	tn := mux.Listen(1) // Could be any byte value.
+	db := mustNewMockDatabase()
+	mgr := mustNewMockManager()
+	cred := mustNewMockCredentialStore()
+	s := New(tn, db, mgr, cred)
+	if s == nil {
+		t.Fatalf("failed to create cluster service")
+	}
+
+	c := NewClient(mustNewDialer(1, false, false), 30*time.Second)
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service: %s", err.Error())
+	}
+
+	var chunks []*command.LoadChunkRequest
+	db.loadChunkFn = func(lcr *command.LoadChunkRequest) error {
+		chunks = append(chunks, lcr)
+		if lcr.SequenceNum == 2 {
+			return fmt.Errorf("chunk rejected")
+		}
+		return nil
+	}
+
+	lcr := &command.LoadChunkRequest{
+		StreamId:    "stream",
+		SequenceNum: 1,
+		Data:        []byte("this is SQLite data"),
+	}
+	if err := c.LoadChunk(lcr, s.Addr(), NO_CREDS, longWait); err != nil {
+		t.Fatalf("failed to load chunk: %s", err.Error())
+	}
+	if len(chunks) != 1 || chunks[0].StreamId != "stream" || !bytes.Equal(chunks[0].Data, lcr.Data) {
+		t.Fatalf("chunk not loaded as sent: %v", chunks)
+	}
+
+	lcr.SequenceNum = 2
+	err := c.LoadChunk(lcr, s.Addr(), NO_CREDS, longWait)
+	if err == nil || !strings.Contains(err.Error(), "chunk rejected") {
+		t.Fatalf("expected error from rejected chunk, got %v", err)
+	}
+
+	// Clean up resources.
+	if err := ln.Close(); err != nil {
+		t.Fatalf("failed to close Mux's listener: %s", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close cluster service")
+	}
+}
+
 func Test_ServiceRemoveNode(t *testing.T) {
 	ln, mux := mustNewMux()
 	go mux.Serve()
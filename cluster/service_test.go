@@ -137,6 +137,61 @@ func Test_NewServiceSetGetNodeAPIAddrLocal(t *testing.T) {
 	}
 }
 
+func Test_NewServiceGetNodeMeta(t *testing.T) {
+	ml := mustNewMockTransport()
+	mgr := mustNewMockManager()
+	mgr.commitIndex = 100
+	mgr.appliedIndex = 90
+	mgr.features = []string{"execute_batch"}
+	s := New(ml, mustNewMockDatabase(), mgr, mustNewMockCredentialStore())
+	if s == nil {
+		t.Fatalf("failed to create cluster service")
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service")
+	}
+	defer s.Close()
+	s.SetAPIAddr("foo")
+	s.SetLabels(map[string]string{"role": "analytics-replica"})
+
+	c := NewClient(ml, 30*time.Second)
+	nm, err := c.GetNodeMeta(s.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get node meta: %s", err)
+	}
+	if nm.Url != "http://foo" {
+		t.Fatalf("wrong node API address, exp %s, got %s", "http://foo", nm.Url)
+	}
+	if nm.CommitIndex != 100 {
+		t.Fatalf("wrong commit index, exp 100, got %d", nm.CommitIndex)
+	}
+	if nm.AppliedIndex != 90 {
+		t.Fatalf("wrong applied index, exp 90, got %d", nm.AppliedIndex)
+	}
+	if exp, got := "analytics-replica", nm.Labels["role"]; exp != got {
+		t.Fatalf("wrong role label, exp %s, got %s", exp, got)
+	}
+	fs, err := c.GetNodeFeatures(s.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get node features: %s", err)
+	}
+	if len(fs) != 1 || fs[0] != "execute_batch" {
+		t.Fatalf("wrong node features, got %v", fs)
+	}
+
+	// Test fetch via local call.
+	if err := c.SetLocal(s.Addr(), s); err != nil {
+		t.Fatalf("failed to set cluster client local parameters: %s", err)
+	}
+	nm, err = c.GetNodeMeta(s.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get node meta locally: %s", err)
+	}
+	if nm.AppliedIndex != 90 {
+		t.Fatalf("wrong applied index, exp 90, got %d", nm.AppliedIndex)
+	}
+}
+
 func Test_NewServiceSetGetNodeAPIAddrTLS(t *testing.T) {
 	ml := mustNewMockTLSTransport()
 	s := New(ml, mustNewMockDatabase(), mustNewMockManager(), mustNewMockCredentialStore())
@@ -434,11 +489,12 @@ func mustNewMockTLSTransport() *mockTransport {
 }
 
 type mockDatabase struct {
-	executeFn func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
-	queryFn   func(qr *command.QueryRequest) ([]*command.QueryRows, error)
-	requestFn func(rr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error)
-	backupFn  func(br *command.BackupRequest, dst io.Writer) error
-	loadFn    func(lr *command.LoadRequest) error
+	executeFn   func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+	queryFn     func(qr *command.QueryRequest) ([]*command.QueryRows, error)
+	requestFn   func(rr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error)
+	backupFn    func(br *command.BackupRequest, dst io.Writer) error
+	loadFn      func(lr *command.LoadRequest) error
+	loadChunkFn func(lcr *command.LoadChunkRequest) error
 }
 
 func (m *mockDatabase) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
@@ -470,6 +526,13 @@ func (m *mockDatabase) Load(lr *command.LoadRequest) error {
 	return m.loadFn(lr)
 }
 
+func (m *mockDatabase) LoadChunk(lcr *command.LoadChunkRequest) error {
+	if m.loadChunkFn == nil {
+		return nil
+	}
+	return m.loadChunkFn(lcr)
+}
+
 func mustNewMockDatabase() *mockDatabase {
 	e := func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
 		return []*command.ExecuteResult{}, nil
@@ -486,6 +549,8 @@ type MockManager struct {
 	joinFn       func(j *command.JoinRequest) error
 	leaderAddrFn func() (string, error)
 	commitIndex  uint64
+	appliedIndex uint64
+	features     []string
 }
 
 func (m *MockManager) Remove(rn *command.RemoveNodeRequest) error {
@@ -520,6 +585,14 @@ func (m *MockManager) CommitIndex() (uint64, error) {
 	return m.commitIndex, nil
 }
 
+func (m *MockManager) AppliedIndex() (uint64, error) {
+	return m.appliedIndex, nil
+}
+
+func (m *MockManager) Features() []string {
+	return m.features
+}
+
 func mustNewMockManager() *MockManager {
 	return &MockManager{}
 }
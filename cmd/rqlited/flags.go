@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -14,6 +16,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rqlite/rqlite/v8/auth"
+	"github.com/rqlite/rqlite/v8/db"
+	httpd "github.com/rqlite/rqlite/v8/http"
+	"github.com/rqlite/rqlite/v8/rtls"
 )
 
 const (
@@ -47,12 +54,148 @@ type Config struct {
 	// HTTPAdv is the advertised HTTP server network.
 	HTTPAdv string
 
-	// HTTPAllowOrigin is the value to set for Access-Control-Allow-Origin HTTP header.
+	// HTTPAllowOrigin is the comma-separated list of origins from which
+	// browsers may make cross-origin requests, or * for any origin. May not be
+	// set, disabling CORS.
 	HTTPAllowOrigin string
 
+	// HTTPAllowMethods is the comma-separated list of methods allowed in
+	// cross-origin requests. May not be set.
+	HTTPAllowMethods string
+
+	// HTTPAllowHeaders is the comma-separated list of request headers allowed
+	// in cross-origin requests. May not be set.
+	HTTPAllowHeaders string
+
+	// HTTPAllowCredentials allows cross-origin requests to carry credentials.
+	HTTPAllowCredentials bool
+
+	// HTTPCompress enables compression of HTTP responses for clients which
+	// accept it.
+	HTTPCompress bool
+
+	// HTTPCompressMinSize is the minimum size, in bytes, of a compressed
+	// HTTP response.
+	HTTPCompressMinSize int
+
+	// HTTPLogSampleRate is the fraction of HTTP requests which are logged.
+	HTTPLogSampleRate float64
+
+	// HTTPStrictQuery rejects statements which modify the database on the query endpoint.
+	HTTPStrictQuery bool
+
+	// HTTPRedactParams removes bound parameter values from errors returned
+	// to clients, and from logs.
+	HTTPRedactParams bool
+
+	// HTTPMaxStatementBytes is the maximum length of the SQL of any one
+	// statement. 0 means no limit.
+	HTTPMaxStatementBytes int
+
+	// HTTPMaxStatements is the maximum number of statements in a single
+	// request. 0 means no limit.
+	HTTPMaxStatements int
+
+	// HTTPRateLimitReads is the number of read requests admitted per second
+	// from each user or client IP address. 0 means no limit.
+	HTTPRateLimitReads float64
+
+	// HTTPRateLimitWrites is the number of write requests admitted per second
+	// from each user or client IP address. 0 means no limit.
+	HTTPRateLimitWrites float64
+
+	// HTTPMaxRequestBytes is the maximum size of an HTTP request body. 0 means
+	// no limit.
+	HTTPMaxRequestBytes int64
+
+	// HTTPMaxLoadBytes is the maximum size of the body of an HTTP request
+	// which may carry a SQLite database file, such as a load, or of a CSV
+	// import. 0 means no limit.
+	HTTPMaxLoadBytes int64
+
+	// HTTPMaxRows is the maximum number of rows returned per statement, unless
+	// overridden for the user in the credentials file. 0 means no limit.
+	HTTPMaxRows int64
+
+	// HTTPQueryLimitMode is how queries whose SELECT statements lack a LIMIT
+	// clause are treated, unless overridden for the user in the credentials
+	// file. One of "off", "reject", or "append".
+	HTTPQueryLimitMode string
+
+	// HTTPQueryLimitRows is the LIMIT added to such statements in append mode.
+	HTTPQueryLimitRows int64
+
+	// HTTPMaxConcurrentRequests is the maximum number of database requests
+	// served at once. 0 means no limit.
+	HTTPMaxConcurrentRequests int
+
+	// HTTPMaxSubscriptions is the maximum number of subscriptions open at once
+	// on /db/subscribe and /events. 0 means no limit.
+	HTTPMaxSubscriptions int
+
+	// HTTPFollowerReadFallback enables redirecting reads, which the Leader
+	// cannot immediately admit, to an up-to-date follower.
+	HTTPFollowerReadFallback bool
+
+	// HTTPStreamChunkRows is the number of rows of a streamed query result
+	// written between flushes of the response.
+	HTTPStreamChunkRows int
+
+	// HTTPIdempotencyWindow is how long the results of an execute request
+	// carrying an Idempotency-Key header are kept for retries. 0 disables
+	// idempotency keys.
+	HTTPIdempotencyWindow time.Duration
+
+	// HTTPShutdownTimeout is how long in-progress HTTP requests are given to
+	// complete when the node shuts down.
+	HTTPShutdownTimeout time.Duration
+
+	// HTTPIdempotencyMaxKeys is the maximum number of idempotency keys kept.
+	HTTPIdempotencyMaxKeys int
+
+	// HTTPAuthExempt is the comma-separated list of HTTP paths which may be
+	// accessed without credentials. May not be set.
+	HTTPAuthExempt string
+
+	// HTTPAuthRealm is the realm sent to HTTP clients challenged to authenticate.
+	HTTPAuthRealm string
+
+	// HTTPReplicateAllowlist is the comma-separated list of host:port HTTP
+	// addresses of clusters this node may replicate its database to. May not
+	// be set.
+	HTTPReplicateAllowlist string
+
+	// HTTPLoadChunkSize is the size, in bytes, of the chunks in which a
+	// SQLite file posted to the Leader is loaded.
+	HTTPLoadChunkSize int64
+
+	// NodeLabels are comma-separated key=value pairs describing this node,
+	// such as its role. May not be set.
+	NodeLabels string
+
 	// AuthFile is the path to the authentication file. May not be set.
 	AuthFile string `filepath:"true"`
 
+	// AuthJWTKeys is the path to the public keys, as PEM or a JSON Web Key Set, used
+	// to verify JWT bearer tokens. If not set, bearer tokens are not accepted.
+	AuthJWTKeys string `filepath:"true"`
+
+	// AuthJWTIssuer, if set, must match the issuer of JWT bearer tokens.
+	AuthJWTIssuer string
+
+	// AuthJWTAudience, if set, must be among the audiences of JWT bearer tokens.
+	AuthJWTAudience string
+
+	// AuthJWTUsernameClaim is the JWT claim naming the user.
+	AuthJWTUsernameClaim string
+
+	// AuthJWTRolesClaim is the JWT claim listing the roles of the user.
+	AuthJWTRolesClaim string
+
+	// AuthJWTRolePerms is the path to a JSON file mapping JWT roles to perms. If not
+	// set, each role is itself a perm. May not be set.
+	AuthJWTRolePerms string `filepath:"true"`
+
 	// AutoBackupFile is the path to the auto-backup file. May not be set.
 	AutoBackupFile string `filepath:"true"`
 
@@ -69,9 +212,33 @@ type Config struct {
 	// HTTPx509Key is the path to the private key for the HTTP server. May not be set.
 	HTTPx509Key string `filepath:"true"`
 
+	// HTTPSNICerts is a comma-separated list of host=certfile:keyfile entries,
+	// selecting the certificate presented by the HTTP server according to
+	// the hostname requested by the client. May not be set.
+	HTTPSNICerts string
+
 	// HTTPVerifyClient indicates whether the HTTP server should verify client certificates.
 	HTTPVerifyClient bool
 
+	// HTTPClientCACert is the path to the CA certificate file used by the HTTP server
+	// to verify client certificates. If not set, HTTPx509CACert is used.
+	HTTPClientCACert string `filepath:"true"`
+
+	// HTTPClientCertAuth indicates whether users may authenticate with the HTTP server
+	// using a TLS client certificate, instead of Basic Auth.
+	HTTPClientCertAuth bool
+
+	// HTTP2Disable disables HTTP/2 on the HTTPS server.
+	HTTP2Disable bool
+
+	// HTTP2MaxConcurrentStreams is the maximum number of HTTP/2 streams each
+	// client may have open at once. 0 means the HTTP/2 default.
+	HTTP2MaxConcurrentStreams uint
+
+	// HTTP2IdleTimeout is how long an idle HTTP/2 connection is kept open.
+	// 0 means no timeout.
+	HTTP2IdleTimeout time.Duration
+
 	// NodeX509CACert is the path to the CA certficate file for when this node verifies
 	// other certificates for any inter-node communications. May not be set.
 	NodeX509CACert string `filepath:"true"`
@@ -135,15 +302,64 @@ type Config struct {
 	// FKConstraints enables SQLite foreign key constraints.
 	FKConstraints bool
 
+	// DBReadPragmas is a comma-separated list of name=value PRAGMAs applied to
+	// each read-only SQLite connection. May not be set.
+	DBReadPragmas string
+
 	// AutoVacInterval sets the automatic VACUUM interval. Use 0s to disable.
 	AutoVacInterval time.Duration
 
+	// VacSchedInterval sets the period between scheduled VACUUM checks, run by
+	// the Leader. Use 0s to disable.
+	VacSchedInterval time.Duration
+
+	// VacSchedFreeRatio is the free-page ratio above which a scheduled VACUUM
+	// is performed. 0 means always.
+	VacSchedFreeRatio float64
+
+	// VacSchedMaxWriteRate is the Raft log growth, in entries per second,
+	// above which a scheduled VACUUM is skipped. 0 means never skip.
+	VacSchedMaxWriteRate float64
+
+	// DBFileStatsInterval sets the period between refreshes of the SQLite file
+	// stats reported by /status. Use 0s to read them only at startup.
+	DBFileStatsInterval time.Duration
+
+	// DBBusyRetries is the number of times an execute request failing
+	// because the database is busy is retried.
+	DBBusyRetries int
+
+	// DBBusyRetryBackoff is the delay before the first retry of a busy
+	// execute request.
+	DBBusyRetryBackoff time.Duration
+
+	// DBBusyRetryMaxWait is the total time the retries of a busy execute
+	// request may wait.
+	DBBusyRetryMaxWait time.Duration
+
+	// SlowRequestThreshold sets how long a database request may take before
+	// it is logged as slow. Use 0s to disable.
+	SlowRequestThreshold time.Duration
+
+	// OTLPEndpoint is the URL of the OTLP/HTTP collector to which trace spans
+	// are exported. If not set, spans are not recorded.
+	OTLPEndpoint string
+
+	// OTLPSampleRatio is the fraction of traces started by this node which
+	// are exported.
+	OTLPSampleRatio float64
+
 	// RaftLogLevel sets the minimum logging level for the Raft subsystem.
 	RaftLogLevel string
 
 	// RaftNonVoter controls whether this node is a voting, read-only node.
 	RaftNonVoter bool
 
+	// ReadOnly marks this node as a read replica. It joins the cluster as a
+	// non-voter, never becomes a voter, and rejects writes and loads sent
+	// to it. Implies RaftNonVoter.
+	ReadOnly bool
+
 	// RaftSnapThreshold is the number of outstanding log entries that trigger snapshot.
 	RaftSnapThreshold uint64
 
@@ -167,6 +383,14 @@ type Config struct {
 	// RaftApplyTimeout sets the Log-apply timeout.
 	RaftApplyTimeout time.Duration
 
+	// RaftExecuteBatchWindow is the period during which the Leader coalesces
+	// concurrent write requests into a single log entry. 0 disables batching.
+	RaftExecuteBatchWindow time.Duration
+
+	// RaftExecuteBatchSize is the maximum number of write requests coalesced
+	// into a single log entry.
+	RaftExecuteBatchSize int
+
 	// RaftShutdownOnRemove sets whether Raft should be shutdown if the node is removed
 	RaftShutdownOnRemove bool
 
@@ -232,6 +456,178 @@ func (c *Config) Validate() error {
 
 	}
 
+	if c.HTTPLogSampleRate < 0 || c.HTTPLogSampleRate > 1 {
+		return errors.New("HTTP log sample rate must be between 0 and 1")
+	}
+
+	if c.HTTPMaxConcurrentRequests < 0 {
+		return errors.New("HTTP max concurrent requests must not be negative")
+	}
+
+	if c.HTTPMaxSubscriptions < 0 {
+		return errors.New("HTTP max subscriptions must not be negative")
+	}
+
+	if c.HTTPAllowCredentials {
+		origins := c.AllowOrigins()
+		if len(origins) == 0 {
+			return errors.New("HTTP allow credentials requires allowed origins")
+		}
+		for _, o := range origins {
+			if o == "*" {
+				return errors.New("HTTP allow credentials cannot be used with any origin allowed")
+			}
+		}
+	}
+
+	if c.HTTPMaxStatementBytes < 0 {
+		return errors.New("HTTP max statement bytes must not be negative")
+	}
+
+	if c.HTTPMaxStatements < 0 {
+		return errors.New("HTTP max statements must not be negative")
+	}
+
+	if c.HTTPRateLimitReads < 0 || c.HTTPRateLimitWrites < 0 {
+		return errors.New("HTTP rate limits must not be negative")
+	}
+
+	if _, err := db.ParseReadPragmas(c.DBReadPragmas); err != nil {
+		return fmt.Errorf("invalid read PRAGMAs: %s", err.Error())
+	}
+
+	if c.HTTPMaxRequestBytes < 0 {
+		return errors.New("HTTP max request bytes must not be negative")
+	}
+
+	if c.HTTPMaxLoadBytes < 0 {
+		return errors.New("HTTP max load bytes must not be negative")
+	}
+
+	if c.HTTPStreamChunkRows < 1 {
+		return errors.New("HTTP stream chunk rows must be at least 1")
+	}
+
+	if c.HTTPShutdownTimeout < 0 {
+		return errors.New("HTTP shutdown timeout must not be negative")
+	}
+
+	if c.HTTPIdempotencyWindow < 0 {
+		return errors.New("HTTP idempotency window must not be negative")
+	}
+
+	if c.HTTPIdempotencyMaxKeys < 1 {
+		return errors.New("HTTP idempotency max keys must be at least 1")
+	}
+
+	for _, p := range c.AuthExemptRoutes() {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("%s is an invalid auth-exempt path", p)
+		}
+	}
+
+	for _, addr := range c.ReplicateAllowlist() {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("%s is an invalid replicate allowlist address", addr)
+		}
+	}
+
+	if c.HTTPLoadChunkSize < 1 {
+		return errors.New("HTTP load chunk size must be at least 1")
+	}
+
+	if c.HTTPFollowerReadFallback && c.HTTPMaxConcurrentRequests == 0 {
+		return errors.New("HTTP follower read fallback requires a limit on concurrent requests")
+	}
+
+	if c.VacSchedFreeRatio < 0 || c.VacSchedFreeRatio >= 1 {
+		return errors.New("scheduled VACUUM free-page ratio must be at least 0 and less than 1")
+	}
+
+	if c.DBFileStatsInterval < 0 {
+		return errors.New("database file stats interval must not be negative")
+	}
+
+	if c.DBBusyRetries < 0 {
+		return errors.New("database busy retries must not be negative")
+	}
+
+	if c.DBBusyRetryBackoff <= 0 {
+		return errors.New("database busy retry backoff must be positive")
+	}
+
+	if c.DBBusyRetryMaxWait < 0 {
+		return errors.New("database busy retry max wait must not be negative")
+	}
+
+	if c.SlowRequestThreshold < 0 {
+		return errors.New("slow request threshold must not be negative")
+	}
+
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("OTLP endpoint must be an http or https URL")
+		}
+	}
+	if c.OTLPSampleRatio < 0 || c.OTLPSampleRatio > 1 {
+		return errors.New("OTLP sample ratio must be between 0 and 1")
+	}
+
+	if c.HTTPMaxRows < 0 {
+		return errors.New("HTTP max rows must not be negative")
+	}
+
+	if !auth.ValidQueryLimit(c.HTTPQueryLimitMode) {
+		return fmt.Errorf("invalid HTTP query limit mode %s, must be one of off, reject, or append", c.HTTPQueryLimitMode)
+	}
+
+	if c.HTTPQueryLimitRows < 1 {
+		return errors.New("HTTP query limit rows must be at least 1")
+	}
+
+	if c.HTTPCompressMinSize < 0 {
+		return errors.New("HTTP compression minimum size must not be negative")
+	}
+
+	if _, err := c.Labels(); err != nil {
+		return err
+	}
+
+	if c.AuthJWTKeys != "" && c.AuthFile == "" {
+		return fmt.Errorf("-auth must be set to use JWT authentication")
+	}
+	if c.AuthJWTRolePerms != "" && c.AuthJWTKeys == "" {
+		return fmt.Errorf("-auth-jwt-keys must be set to map JWT roles to perms")
+	}
+
+	if c.HTTPClientCertAuth && c.HTTPx509Cert == "" {
+		return fmt.Errorf("-%s must be set to use client certificate authentication", HTTPx509CertFlag)
+	}
+
+	if c.HTTP2MaxConcurrentStreams > math.MaxUint32 {
+		return fmt.Errorf("HTTP/2 max concurrent streams must be at most %d", uint32(math.MaxUint32))
+	}
+
+	if c.HTTP2IdleTimeout < 0 {
+		return errors.New("HTTP/2 idle timeout must not be negative")
+	}
+
+	sniCerts, err := c.SNICertificates()
+	if err != nil {
+		return err
+	}
+	if len(sniCerts) > 0 && c.HTTPx509Cert == "" {
+		return fmt.Errorf("-%s must be set to use SNI certificates", HTTPx509CertFlag)
+	}
+	for _, ckf := range sniCerts {
+		for _, f := range []string{ckf.CertFile, ckf.KeyFile} {
+			if _, err := os.Stat(f); err != nil {
+				return fmt.Errorf("SNI certificate file %s does not exist", f)
+			}
+		}
+	}
+
 	if c.RaftAddr == c.HTTPAddr {
 		return errors.New("HTTP and Raft addresses must differ")
 	}
@@ -289,6 +685,10 @@ func (c *Config) Validate() error {
 		return errors.New("advertised HTTP and Raft addresses must differ")
 	}
 
+	if c.ReadOnly {
+		c.RaftNonVoter = true
+	}
+
 	// Enforce bootstrapping policies
 	if c.BootstrapExpect > 0 && c.RaftNonVoter {
 		return errors.New("bootstrapping only applicable to voting nodes")
@@ -345,6 +745,62 @@ func (c *Config) JoinAddresses() []string {
 	return strings.Split(c.JoinAddrs, ",")
 }
 
+// AuthExemptRoutes returns the HTTP paths which may be accessed without
+// credentials. Returns nil if no paths were set.
+func (c *Config) AuthExemptRoutes() []string {
+	if c.HTTPAuthExempt == "" {
+		return nil
+	}
+	return strings.Split(c.HTTPAuthExempt, ",")
+}
+
+// AllowOrigins returns the origins from which browsers may make cross-origin
+// requests. Returns nil if no origins were set.
+func (c *Config) AllowOrigins() []string {
+	if c.HTTPAllowOrigin == "" {
+		return nil
+	}
+	return strings.Split(c.HTTPAllowOrigin, ",")
+}
+
+// AllowMethods returns the methods allowed in cross-origin requests. Returns
+// nil if no methods were set.
+func (c *Config) AllowMethods() []string {
+	if c.HTTPAllowMethods == "" {
+		return nil
+	}
+	return strings.Split(c.HTTPAllowMethods, ",")
+}
+
+// AllowHeaders returns the request headers allowed in cross-origin requests.
+// Returns nil if no headers were set.
+func (c *Config) AllowHeaders() []string {
+	if c.HTTPAllowHeaders == "" {
+		return nil
+	}
+	return strings.Split(c.HTTPAllowHeaders, ",")
+}
+
+// ReplicateAllowlist returns the addresses of the clusters this node may
+// replicate its database to. Returns nil if no addresses were set.
+func (c *Config) ReplicateAllowlist() []string {
+	if c.HTTPReplicateAllowlist == "" {
+		return nil
+	}
+	return strings.Split(c.HTTPReplicateAllowlist, ",")
+}
+
+// Labels returns the labels describing this node.
+func (c *Config) Labels() (map[string]string, error) {
+	return httpd.ParseLabels(c.NodeLabels)
+}
+
+// SNICertificates returns the certificates the HTTP server should present,
+// keyed by hostname.
+func (c *Config) SNICertificates() (map[string]rtls.CertKeyFiles, error) {
+	return rtls.ParseSNICertificates(c.HTTPSNICerts)
+}
+
 // HTTPURL returns the fully-formed, advertised HTTP API address for this config, including
 // protocol, host and port.
 func (c *Config) HTTPURL() string {
@@ -435,11 +891,46 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.StringVar(&config.NodeID, "node-id", "", "Unique ID for node. If not set, set to advertised Raft address")
 	flag.StringVar(&config.HTTPAddr, HTTPAddrFlag, "localhost:4001", "HTTP server bind address. To enable HTTPS, set X.509 certificate and key")
 	flag.StringVar(&config.HTTPAdv, HTTPAdvAddrFlag, "", "Advertised HTTP address. If not set, same as HTTP server bind address")
-	flag.StringVar(&config.HTTPAllowOrigin, "http-allow-origin", "", "Value to set for Access-Control-Allow-Origin HTTP header")
+	flag.StringVar(&config.HTTPAllowOrigin, "http-allow-origin", "", "Comma-delimited list of origins from which browsers may make cross-origin requests, or * for any. Unset disables CORS")
+	flag.StringVar(&config.HTTPAllowMethods, "http-allow-methods", strings.Join(httpd.DefaultAllowMethods(), ","), "Comma-delimited list of methods allowed in cross-origin requests")
+	flag.StringVar(&config.HTTPAllowHeaders, "http-allow-headers", strings.Join(httpd.DefaultAllowHeaders(), ","), "Comma-delimited list of request headers allowed in cross-origin requests")
+	flag.BoolVar(&config.HTTPAllowCredentials, "http-allow-credentials", false, "If set, cross-origin requests from explicitly allowed origins may carry credentials, such as Basic Auth")
+	flag.BoolVar(&config.HTTPCompress, "http-compress", false, "Compress query, execute, status, and backup responses for clients accepting gzip or deflate")
+	flag.IntVar(&config.HTTPCompressMinSize, "http-compress-min-size", 1024, "Minimum size in bytes of a compressed HTTP response")
+	flag.Float64Var(&config.HTTPLogSampleRate, "http-log-sample-rate", 0, "Fraction of HTTP requests, between 0 and 1, to log")
+	flag.BoolVar(&config.HTTPStrictQuery, "http-strict-query", false, "Reject statements which modify the database on the query endpoint")
+	flag.BoolVar(&config.HTTPRedactParams, "http-redact-params", false, "Remove the values of bound parameters from errors returned to clients, and from logs")
+	flag.IntVar(&config.HTTPMaxStatementBytes, "http-max-statement-bytes", 0, "Maximum length, in bytes, of the SQL of any one statement. 0 means no limit")
+	flag.IntVar(&config.HTTPMaxStatements, "http-max-statements", httpd.DefaultMaxStatements, "Maximum number of statements in a single request. 0 means no limit")
+	flag.Float64Var(&config.HTTPRateLimitReads, "http-rate-limit-reads", 0, "Read requests admitted per second from each user or client IP address. 0 means no limit")
+	flag.Float64Var(&config.HTTPRateLimitWrites, "http-rate-limit-writes", 0, "Write requests admitted per second from each user or client IP address. 0 means no limit")
+	flag.Int64Var(&config.HTTPMaxRequestBytes, "http-max-request-bytes", 0, "Maximum size, in bytes, of an HTTP request body, except for loads and imports. 0 means no limit")
+	flag.Int64Var(&config.HTTPMaxLoadBytes, "http-max-load-bytes", 0, "Maximum size, in bytes, of the body of a load, boot, backup validation, or CSV import request. 0 means no limit")
+	flag.IntVar(&config.HTTPMaxConcurrentRequests, "http-max-concurrent-requests", 0, "Maximum database requests served at once, admitted by X-Priority header. 0 means no limit")
+	flag.IntVar(&config.HTTPMaxSubscriptions, "http-max-subscriptions", 0, "Maximum subscriptions open at once on /db/subscribe and /events. 0 means no limit")
+	flag.IntVar(&config.HTTPStreamChunkRows, "http-stream-chunk-rows", 1000, "Rows of a streamed query result written between flushes of the response, unless set by chunk_rows")
+	flag.DurationVar(&config.HTTPShutdownTimeout, "http-shutdown-timeout", 10*time.Second, "How long in-progress HTTP requests are given to complete when the node shuts down")
+	flag.DurationVar(&config.HTTPIdempotencyWindow, "http-idempotency-window", 5*time.Minute, "How long the results of an execute request with an Idempotency-Key header are kept, to be returned to retries. 0 disables idempotency keys")
+	flag.IntVar(&config.HTTPIdempotencyMaxKeys, "http-idempotency-max-keys", 10000, "Maximum number of idempotency keys kept, the oldest being evicted")
+	flag.StringVar(&config.HTTPAuthRealm, "http-auth-realm", "rqlite", "Realm sent in the WWW-Authenticate header of HTTP responses requiring authentication")
+	flag.StringVar(&config.HTTPAuthExempt, "http-auth-exempt", strings.Join(httpd.DefaultAuthExemptRoutes(), ","), "Comma-delimited list of HTTP paths which may be accessed without credentials, even with authentication enabled")
+	flag.StringVar(&config.HTTPReplicateAllowlist, "http-replicate-allowlist", "", "Comma-delimited list of host:port HTTP addresses of clusters to which /db/replicate-to may stream the database")
+	flag.Int64Var(&config.HTTPLoadChunkSize, "http-load-chunk-size", 16*1024*1024, "Size, in bytes, of the chunks in which a SQLite file posted to the Leader is loaded")
+	flag.BoolVar(&config.HTTPFollowerReadFallback, "http-follower-read-fallback", false, "If set, Leader redirects reads it cannot admit immediately to an up-to-date follower, to be served with level none")
+	flag.Int64Var(&config.HTTPMaxRows, "http-max-rows", 0, "Maximum rows returned per statement, unless set for the user in the auth file. 0 means no limit")
+	flag.StringVar(&config.HTTPQueryLimitMode, "http-query-limit-mode", auth.QueryLimitOff, "How queries without a LIMIT clause are treated, unless set for the user in the auth file: off, reject, or append")
+	flag.Int64Var(&config.HTTPQueryLimitRows, "http-query-limit-rows", 1000, "LIMIT added to queries without one, if the query limit mode is append")
+	flag.StringVar(&config.NodeLabels, "node-labels", "", "Comma-separated key=value labels describing this node, such as role=analytics-replica")
 	flag.StringVar(&config.HTTPx509CACert, "http-ca-cert", "", "Path to X.509 CA certificate for HTTPS")
 	flag.StringVar(&config.HTTPx509Cert, HTTPx509CertFlag, "", "Path to HTTPS X.509 certificate")
 	flag.StringVar(&config.HTTPx509Key, HTTPx509KeyFlag, "", "Path to HTTPS X.509 private key")
+	flag.StringVar(&config.HTTPSNICerts, "http-sni-certs", "", "Comma-separated host=certfile:keyfile entries, selecting the HTTPS certificate by requested hostname")
 	flag.BoolVar(&config.HTTPVerifyClient, "http-verify-client", false, "Enable mutual TLS for HTTPS")
+	flag.StringVar(&config.HTTPClientCACert, "http-client-ca-cert", "", "Path to X.509 CA certificate for verifying HTTPS client certificates. Defaults to -http-ca-cert")
+	flag.BoolVar(&config.HTTPClientCertAuth, "http-client-cert-auth", false, "If set, HTTPS clients may authenticate as the user named by the Common Name of their certificate")
+	flag.BoolVar(&config.HTTP2Disable, "http2-disable", false, "Disable HTTP/2, serving HTTPS clients HTTP/1.1 only")
+	flag.UintVar(&config.HTTP2MaxConcurrentStreams, "http2-max-concurrent-streams", 0, "Maximum HTTP/2 streams each HTTPS client may have open at once. 0 means the HTTP/2 default of 250")
+	flag.DurationVar(&config.HTTP2IdleTimeout, "http2-idle-timeout", 0, "How long an idle HTTP/2 connection is kept open. 0 means no timeout")
 	flag.StringVar(&config.NodeX509CACert, "node-ca-cert", "", "Path to X.509 CA certificate for node-to-node encryption")
 	flag.StringVar(&config.NodeX509Cert, NodeX509CertFlag, "", "Path to X.509 certificate for node-to-node mutual authentication and encryption")
 	flag.StringVar(&config.NodeX509Key, NodeX509KeyFlag, "", "Path to X.509 private key for node-to-node mutual authentication and encryption")
@@ -447,6 +938,12 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.BoolVar(&config.NodeVerifyClient, "node-verify-client", false, "Enable mutual TLS for node-to-node communication")
 	flag.StringVar(&config.NodeVerifyServerName, "node-verify-server-name", "", "Hostname to verify on certificate returned by a node")
 	flag.StringVar(&config.AuthFile, "auth", "", "Path to authentication and authorization file. If not set, not enabled")
+	flag.StringVar(&config.AuthJWTKeys, "auth-jwt-keys", "", "Path to PEM public keys or JWKS verifying JWT bearer tokens. If not set, bearer tokens are not accepted")
+	flag.StringVar(&config.AuthJWTIssuer, "auth-jwt-issuer", "", "If set, required issuer of JWT bearer tokens")
+	flag.StringVar(&config.AuthJWTAudience, "auth-jwt-audience", "", "If set, required audience of JWT bearer tokens")
+	flag.StringVar(&config.AuthJWTUsernameClaim, "auth-jwt-username-claim", auth.DefaultUsernameClaim, "JWT claim naming the user")
+	flag.StringVar(&config.AuthJWTRolesClaim, "auth-jwt-roles-claim", auth.DefaultRolesClaim, "JWT claim listing the roles of the user")
+	flag.StringVar(&config.AuthJWTRolePerms, "auth-jwt-role-perms", "", "Path to JSON file mapping JWT roles to perms. If not set, each role is a perm")
 	flag.StringVar(&config.AutoBackupFile, "auto-backup", "", "Path to automatic backup configuration file. If not set, not enabled")
 	flag.StringVar(&config.AutoRestoreFile, "auto-restore", "", "Path to automatic restore configuration file. If not set, not enabled")
 	flag.StringVar(&config.RaftAddr, RaftAddrFlag, "localhost:4002", "Raft communication bind address")
@@ -462,12 +959,26 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.StringVar(&config.DiscoConfig, "disco-config", "", "Set discovery config, or path to cluster discovery config file")
 	flag.StringVar(&config.OnDiskPath, "on-disk-path", "", "Path for SQLite on-disk database file. If not set, use a file in data directory")
 	flag.BoolVar(&config.FKConstraints, "fk", false, "Enable SQLite foreign key constraints")
+	flag.StringVar(&config.DBReadPragmas, "db-read-pragmas", "", "Comma-separated name=value PRAGMAs applied to read-only SQLite connections, such as cache_size=-8000")
 	flag.BoolVar(&showVersion, "version", false, "Show version information and exit")
 	flag.DurationVar(&config.AutoVacInterval, "auto-vacuum-int", 0, "Period between automatic VACUUMs. It not set, not enabled")
+	flag.DurationVar(&config.VacSchedInterval, "vacuum-sched-int", 0, "Period between scheduled VACUUM checks, run by the Leader and replicated. If not set, not enabled")
+	flag.Float64Var(&config.VacSchedFreeRatio, "vacuum-sched-free-ratio", 0, "Only perform a scheduled VACUUM if the free-page ratio exceeds this value. 0 means always")
+	flag.Float64Var(&config.VacSchedMaxWriteRate, "vacuum-sched-max-write-rate", 0, "Skip a scheduled VACUUM if writes exceed this many per second. 0 means never skip")
+	flag.DurationVar(&config.DBFileStatsInterval, "db-file-stats-int", 30*time.Second, "Period between refreshes of the SQLite file size and page stats reported by status. If 0, read only at startup")
+	flag.IntVar(&config.DBBusyRetries, "db-busy-retries", 2, "Times an execute request failing because the database is busy or locked is retried, within the request's deadline. 0 disables retries")
+	flag.DurationVar(&config.DBBusyRetryBackoff, "db-busy-retry-backoff", 5*time.Millisecond, "Delay before the first retry of a busy execute request, doubled and jittered on each retry")
+	flag.DurationVar(&config.DBBusyRetryMaxWait, "db-busy-retry-max-wait", 20*time.Millisecond, "Total time the retries of a busy execute request may wait. 0 means no limit other than the request's database timeout")
+	flag.DurationVar(&config.SlowRequestThreshold, "slow-request-threshold", 0, "Log execute and query requests taking longer than this, with their request ID. If not set, not enabled")
+	flag.StringVar(&config.OTLPEndpoint, "otlp-endpoint", "", "URL of OTLP/HTTP collector to which trace spans are exported, e.g. http://localhost:4318. If not set, tracing is disabled")
+	flag.Float64Var(&config.OTLPSampleRatio, "otlp-sample-ratio", 1, "Fraction of traces started by this node which are exported")
 	flag.BoolVar(&config.RaftNonVoter, "raft-non-voter", false, "Configure as non-voting node")
+	flag.BoolVar(&config.ReadOnly, "read-only", false, "Configure as a read replica, a non-voting node which rejects writes and loads sent to it")
 	flag.DurationVar(&config.RaftHeartbeatTimeout, "raft-timeout", time.Second, "Raft heartbeat timeout")
 	flag.DurationVar(&config.RaftElectionTimeout, "raft-election-timeout", time.Second, "Raft election timeout")
 	flag.DurationVar(&config.RaftApplyTimeout, "raft-apply-timeout", 10*time.Second, "Raft apply timeout")
+	flag.DurationVar(&config.RaftExecuteBatchWindow, "raft-execute-batch-window", 0, "Window during which the Leader coalesces writes into a single log entry. 0 disables")
+	flag.IntVar(&config.RaftExecuteBatchSize, "raft-execute-batch-size", 128, "Maximum number of writes coalesced into a single log entry")
 	flag.Uint64Var(&config.RaftSnapThreshold, "raft-snap", 8192, "Number of outstanding log entries which triggers Raft snapshot")
 	flag.Uint64Var(&config.RaftSnapThresholdWALSize, "raft-snap-wal-size", 4*1024*1024, "SQLite WAL file size in bytes which triggers Raft snapshot. Set to 0 to disable")
 	flag.DurationVar(&config.RaftSnapInterval, "raft-snap-int", 10*time.Second, "Snapshot threshold check interval")
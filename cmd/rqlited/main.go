@@ -29,6 +29,7 @@ import (
 	"github.com/rqlite/rqlite/v8/rtls"
 	"github.com/rqlite/rqlite/v8/store"
 	"github.com/rqlite/rqlite/v8/tcp"
+	"github.com/rqlite/rqlite/v8/tracing"
 )
 
 const logo = `
@@ -83,6 +84,20 @@ func main() {
 	// Start requested profiling.
 	startProfile(cfg.CPUProfile, cfg.MemProfile)
 
+	// Configure trace export. Without an endpoint spans are not recorded.
+	stopTracing, err := tracing.Setup(mainCtx, &tracing.Config{
+		Endpoint:    cfg.OTLPEndpoint,
+		NodeID:      cfg.NodeID,
+		Version:     cmd.Version,
+		SampleRatio: cfg.OTLPSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("failed to configure tracing: %s", err.Error())
+	}
+	if cfg.OTLPEndpoint != "" {
+		log.Printf("exporting trace spans to %s", cfg.OTLPEndpoint)
+	}
+
 	// Create internode network mux and configure.
 	muxLn, err := net.Listen("tcp", cfg.RaftAddr)
 	if err != nil {
@@ -161,11 +176,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to create cluster client: %s", err.Error())
 	}
-	httpServ, err := startHTTPService(cfg, str, clstrClient, credStr)
+	httpServ, err := startHTTPService(cfg, str, clstrClient, clstrServ, credStr)
 	if err != nil {
 		log.Fatalf("failed to start HTTP server: %s", err.Error())
 	}
 
+	// The Leader only writes commands using a feature once every node supports it.
+	str.SetFeaturesGetter(clstrClient)
+
 	// Now, open store. How long this takes does depend on how much data is being stored by rqlite.
 	if err := str.Open(); err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
@@ -203,8 +221,11 @@ func main() {
 	<-sigCh
 
 	// Stop the HTTP server first, so clients get notification as soon as
-	// possible that the node is going away.
-	httpServ.Close()
+	// possible that the node is going away. Requests in progress are given
+	// a chance to complete, so clients learn whether their writes committed.
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.HTTPShutdownTimeout)
+	httpServ.Shutdown(shutdownCtx)
+	shutdownCancel()
 
 	if cfg.RaftClusterRemoveOnShutdown {
 		remover := cluster.NewRemover(clstrClient, 5*time.Second, str)
@@ -231,6 +252,11 @@ func main() {
 	}
 	clstrServ.Close()
 	muxLn.Close()
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := stopTracing(tracingCtx); err != nil {
+		log.Printf("failed to flush trace spans: %s", err.Error())
+	}
+	tracingCancel()
 	stopProfile()
 	log.Println("rqlite server stopped")
 }
@@ -264,6 +290,11 @@ func createStore(cfg *Config, ln *tcp.Layer) (*store.Store, error) {
 	dbConf := store.NewDBConfig()
 	dbConf.OnDiskPath = cfg.OnDiskPath
 	dbConf.FKConstraints = cfg.FKConstraints
+	readPragmas, err := db.ParseReadPragmas(cfg.DBReadPragmas)
+	if err != nil {
+		return nil, err
+	}
+	dbConf.ReadPragmas = readPragmas
 
 	str := store.New(ln, &store.Config{
 		DBConf: dbConf,
@@ -281,10 +312,21 @@ func createStore(cfg *Config, ln *tcp.Layer) (*store.Store, error) {
 	str.HeartbeatTimeout = cfg.RaftHeartbeatTimeout
 	str.ElectionTimeout = cfg.RaftElectionTimeout
 	str.ApplyTimeout = cfg.RaftApplyTimeout
+	str.ExecuteBatchWindow = cfg.RaftExecuteBatchWindow
+	str.ExecuteBatchSize = cfg.RaftExecuteBatchSize
 	str.BootstrapExpect = cfg.BootstrapExpect
+	str.ReadOnly = cfg.ReadOnly
 	str.ReapTimeout = cfg.RaftReapNodeTimeout
 	str.ReapReadOnlyTimeout = cfg.RaftReapReadOnlyNodeTimeout
 	str.AutoVacInterval = cfg.AutoVacInterval
+	str.VacSchedInterval = cfg.VacSchedInterval
+	str.VacSchedFreeRatio = cfg.VacSchedFreeRatio
+	str.VacSchedMaxWriteRate = cfg.VacSchedMaxWriteRate
+	str.DBFileStatsInterval = cfg.DBFileStatsInterval
+	str.SlowRequestThreshold = cfg.SlowRequestThreshold
+	str.BusyRetries = cfg.DBBusyRetries
+	str.BusyRetryBackoff = cfg.DBBusyRetryBackoff
+	str.BusyRetryMaxWait = cfg.DBBusyRetryMaxWait
 
 	if store.IsNewNode(cfg.DataPath) {
 		log.Printf("no preexisting node state detected in %s, node may be bootstrapping", cfg.DataPath)
@@ -333,19 +375,63 @@ func createDiscoService(cfg *Config, str *store.Store) (*disco.Service, error) {
 	return disco.NewService(c, str, disco.VoterSuffrage(!cfg.RaftNonVoter)), nil
 }
 
-func startHTTPService(cfg *Config, str *store.Store, cltr *cluster.Client, credStr *auth.CredentialsStore) (*httpd.Service, error) {
+func startHTTPService(cfg *Config, str *store.Store, cltr *cluster.Client, clstrServ *cluster.Service, credStr *auth.CredentialsStore) (*httpd.Service, error) {
 	// Create HTTP server and load authentication information.
 	s := httpd.New(cfg.HTTPAddr, str, cltr, credStr)
 
 	s.CACertFile = cfg.HTTPx509CACert
 	s.CertFile = cfg.HTTPx509Cert
 	s.KeyFile = cfg.HTTPx509Key
+	s.SNICertificates, _ = cfg.SNICertificates() // Validated with the rest of the config.
 	s.ClientVerify = cfg.HTTPVerifyClient
+	s.ClientCACertFile = cfg.HTTPClientCACert
+	s.ClientCertAuth = cfg.HTTPClientCertAuth
+	s.HTTP2Disabled = cfg.HTTP2Disable
+	s.HTTP2MaxConcurrentStreams = uint32(cfg.HTTP2MaxConcurrentStreams)
+	s.HTTP2IdleTimeout = cfg.HTTP2IdleTimeout
+	if cfg.AuthJWTKeys != "" {
+		v, err := jwtValidator(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JWT validator: %s", err.Error())
+		}
+		s.TokenValidator = v
+	}
 	s.DefaultQueueCap = cfg.WriteQueueCap
 	s.DefaultQueueBatchSz = cfg.WriteQueueBatchSz
 	s.DefaultQueueTimeout = cfg.WriteQueueTimeout
 	s.DefaultQueueTx = cfg.WriteQueueTx
-	s.AllowOrigin = cfg.HTTPAllowOrigin
+	s.AllowOrigins = cfg.AllowOrigins()
+	s.AllowMethods = cfg.AllowMethods()
+	s.AllowHeaders = cfg.AllowHeaders()
+	s.AllowCredentials = cfg.HTTPAllowCredentials
+	s.CompressResponses = cfg.HTTPCompress
+	s.CompressMinSize = cfg.HTTPCompressMinSize
+	s.LogSampleRate = cfg.HTTPLogSampleRate
+	s.StrictQuery = cfg.HTTPStrictQuery
+	s.RedactParameters = cfg.HTTPRedactParams
+	s.MaxStatementBytes = cfg.HTTPMaxStatementBytes
+	s.MaxStatements = cfg.HTTPMaxStatements
+	s.RateLimitReads = cfg.HTTPRateLimitReads
+	s.RateLimitWrites = cfg.HTTPRateLimitWrites
+	s.MaxRequestBytes = cfg.HTTPMaxRequestBytes
+	s.MaxLoadBytes = cfg.HTTPMaxLoadBytes
+	s.DefaultMaxRows = cfg.HTTPMaxRows
+	s.QueryLimitMode = cfg.HTTPQueryLimitMode
+	s.QueryLimitRows = cfg.HTTPQueryLimitRows
+	s.ReadOnly = cfg.ReadOnly
+	s.ElectionTimeout = cfg.RaftElectionTimeout
+	s.MaxConcurrentRequests = cfg.HTTPMaxConcurrentRequests
+	s.MaxSubscriptions = cfg.HTTPMaxSubscriptions
+	s.FollowerReadFallback = cfg.HTTPFollowerReadFallback
+	s.StreamChunkRows = cfg.HTTPStreamChunkRows
+	s.LoadChunkSize = cfg.HTTPLoadChunkSize
+	s.ReplicateAllowlist = cfg.ReplicateAllowlist()
+	s.AuthExemptRoutes = cfg.AuthExemptRoutes()
+	s.AuthRealm = cfg.HTTPAuthRealm
+	s.IdempotencyWindow = cfg.HTTPIdempotencyWindow
+	s.IdempotencyMaxKeys = cfg.HTTPIdempotencyMaxKeys
+	s.Labels, _ = cfg.Labels() // Validated with the rest of the config.
+	s.Advertiser = clstrServ
 	s.BuildInfo = map[string]interface{}{
 		"commit":     cmd.Commit,
 		"branch":     cmd.Branch,
@@ -390,6 +476,24 @@ func startNodeMux(cfg *Config, ln net.Listener) (*tcp.Mux, error) {
 	return mux, nil
 }
 
+func jwtValidator(cfg *Config) (*auth.JWTValidator, error) {
+	v, err := auth.NewJWTValidatorFromFile(cfg.AuthJWTKeys)
+	if err != nil {
+		return nil, err
+	}
+	v.Issuer = cfg.AuthJWTIssuer
+	v.Audience = cfg.AuthJWTAudience
+	v.UsernameClaim = cfg.AuthJWTUsernameClaim
+	v.RolesClaim = cfg.AuthJWTRolesClaim
+	if cfg.AuthJWTRolePerms != "" {
+		v.RolePerms, err = auth.LoadRolePerms(cfg.AuthJWTRolePerms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
 func credentialStore(cfg *Config) (*auth.CredentialsStore, error) {
 	if cfg.AuthFile == "" {
 		return nil, nil
@@ -400,6 +504,8 @@ func credentialStore(cfg *Config) (*auth.CredentialsStore, error) {
 func clusterService(cfg *Config, ln net.Listener, db cluster.Database, mgr cluster.Manager, credStr *auth.CredentialsStore) (*cluster.Service, error) {
 	c := cluster.New(ln, db, mgr, credStr)
 	c.SetAPIAddr(cfg.HTTPAdv)
+	labels, _ := cfg.Labels() // Validated with the rest of the config.
+	c.SetLabels(labels)
 	c.EnableHTTPS(cfg.HTTPx509Cert != "" && cfg.HTTPx509Key != "") // Conditions met for an HTTPS API
 	if err := c.Open(); err != nil {
 		return nil, err
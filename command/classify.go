@@ -0,0 +1,171 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/rqlite/sql"
+)
+
+// IsWrite returns whether the given SQL may modify the database. Each
+// statement the SQL contains is classified, and the SQL is a write if any
+// statement is. Only SELECT and EXPLAIN statements, and PRAGMAs which only
+// read, are not writes. A statement which cannot be parsed is considered a
+// write, since what it does cannot be known.
+func IsWrite(stmt string) bool {
+	return IsWriteWith(stmt, nil)
+}
+
+// ReadOnlyFunc returns whether SQLite, preparing the given single SQL
+// statement against the database, considers it read-only.
+type ReadOnlyFunc func(stmt string) (bool, error)
+
+// IsWriteWith is like IsWrite, but statements other than PRAGMAs are
+// classified by SQLite, using readOnly, so statements the parser does not
+// understand, such as those with subqueries or schema-qualified names, are
+// classified correctly. A statement which SQLite cannot prepare, for example
+// because it refers to a table which does not yet exist, is classified by
+// parsing it, as IsWrite does. If readOnly is nil, every statement is.
+func IsWriteWith(stmt string, readOnly ReadOnlyFunc) bool {
+	for _, s := range Split(stmt) {
+		if isWriteStatement(s, readOnly) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWriteStatement returns whether the given single SQL statement may
+// modify the database.
+func isWriteStatement(stmt string, readOnly ReadOnlyFunc) bool {
+	stripped := stripComments(stmt)
+	if name, arg, ok := pragma(stripped); ok {
+		switch arg {
+		case "":
+			return writePragmas[name]
+		case "(":
+			return !readPragmas[name]
+		default:
+			return true
+		}
+	}
+
+	if readOnly != nil {
+		if ro, err := readOnly(stmt); err == nil {
+			// SQLite considers statements which change the state of the
+			// connection, but not the database file, read-only.
+			return !ro || connStateKeywords[firstKeyword(stripped)]
+		}
+	}
+	return parsedWrite(stripped)
+}
+
+// parsedWrite returns whether the given single SQL statement, with comments
+// stripped, may modify the database, as determined by parsing it.
+func parsedWrite(stmt string) bool {
+	s, err := sql.NewParser(strings.NewReader(stmt)).ParseStatement()
+	if err != nil {
+		return true
+	}
+	switch s.(type) {
+	case *sql.SelectStatement, *sql.ExplainStatement:
+		return false
+	default:
+		return true
+	}
+}
+
+// connStateKeywords are the keywords beginning the statements which SQLite
+// considers read-only, but which change the state of the connection.
+var connStateKeywords = map[string]bool{
+	"ATTACH":    true,
+	"BEGIN":     true,
+	"COMMIT":    true,
+	"DETACH":    true,
+	"END":       true,
+	"RELEASE":   true,
+	"ROLLBACK":  true,
+	"SAVEPOINT": true,
+}
+
+// firstKeyword returns the upper-cased first word of the given SQL statement,
+// with comments stripped.
+func firstKeyword(stmt string) string {
+	stmt = strings.TrimSpace(stmt)
+	i := 0
+	for i < len(stmt) && isIdentByte(stmt[i]) {
+		i++
+	}
+	return strings.ToUpper(stmt[:i])
+}
+
+// readPragmas are the PRAGMAs which only read when given an argument in
+// parentheses. Any other PRAGMA given an argument may set a value.
+var readPragmas = map[string]bool{
+	"foreign_key_check": true,
+	"foreign_key_list":  true,
+	"index_info":        true,
+	"index_list":        true,
+	"index_xinfo":       true,
+	"integrity_check":   true,
+	"quick_check":       true,
+	"table_info":        true,
+	"table_list":        true,
+	"table_xinfo":       true,
+}
+
+// writePragmas are the PRAGMAs which modify the database, or the state of
+// the connection, even when given no argument.
+var writePragmas = map[string]bool{
+	"incremental_vacuum": true,
+	"optimize":           true,
+	"shrink_memory":      true,
+	"wal_checkpoint":     true,
+}
+
+// pragma returns the lower-cased name of the PRAGMA the given statement,
+// with comments stripped, runs, and how it is given an argument: "" for
+// none, "(" for an argument in parentheses, or "=" for an assignment. A
+// PRAGMA whose name cannot be understood is returned as an assignment. ok
+// is false if the statement is not a PRAGMA.
+func pragma(stmt string) (name, arg string, ok bool) {
+	stmt = strings.TrimSpace(stmt)
+	if len(stmt) < len("PRAGMA") || !strings.EqualFold(stmt[:len("PRAGMA")], "PRAGMA") ||
+		(len(stmt) > len("PRAGMA") && isIdentByte(stmt[len("PRAGMA")])) {
+		return "", "", false
+	}
+	rest := stmt[len("PRAGMA"):]
+	i := strings.IndexAny(rest, "=(")
+	if i >= 0 {
+		arg = rest[i : i+1]
+		rest = rest[:i]
+	}
+	name = strings.TrimSpace(rest)
+	if j := strings.LastIndexByte(name, '.'); j >= 0 {
+		name = strings.TrimSpace(name[j+1:])
+	}
+	if name == "" {
+		return "", "=", true
+	}
+	for k := 0; k < len(name); k++ {
+		if !isIdentByte(name[k]) {
+			return "", "=", true
+		}
+	}
+	return strings.ToLower(name), arg, true
+}
+
+// ChangesRows returns whether the given SQL statement is an INSERT, UPDATE,
+// or DELETE, and so is expected to change at least one row. If the statement
+// cannot be parsed false is returned.
+func ChangesRows(stmt string) bool {
+	s, err := sql.NewParser(strings.NewReader(stmt)).ParseStatement()
+	if err != nil {
+		return false
+	}
+	switch s.(type) {
+	case *sql.InsertStatement, *sql.UpdateStatement, *sql.DeleteStatement:
+		return true
+	default:
+		return false
+	}
+}
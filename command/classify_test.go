@@ -0,0 +1,107 @@
+package command
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_IsWrite(t *testing.T) {
+	for _, tt := range []struct {
+		sql string
+		exp bool
+	}{
+		{"SELECT * FROM foo", false},
+		{"EXPLAIN QUERY PLAN SELECT * FROM foo", false},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", false},
+		{"INSERT INTO foo VALUES(1)", true},
+		{"UPDATE foo SET name='fiona'", true},
+		{"DELETE FROM foo", true},
+		{"CREATE TABLE foo (id INTEGER PRIMARY KEY)", true},
+		{"DROP TABLE foo", true},
+		{"this is not SQL", true},
+		{"", false},
+		{"-- comment\nSELECT * FROM foo /* comment */", false},
+		{"SELECT * FROM foo; SELECT * FROM bar;", false},
+		{"SELECT * FROM foo; DELETE FROM foo", true},
+		{"SELECT 1 /* ; */; INSERT INTO foo VALUES(1)", true},
+		{"INSERT INTO foo VALUES(1) RETURNING id", true},
+		{"DELETE FROM foo RETURNING *", true},
+		{"ANALYZE", true},
+		{"REINDEX", true},
+		{"VACUUM", true},
+		{"VACUUM INTO '/tmp/copy.db'", true},
+		{"ATTACH DATABASE '/tmp/other.db' AS other", true},
+		{"DETACH DATABASE other", true},
+		{"PRAGMA foreign_keys", false},
+		{"pragma main.journal_mode", false},
+		{"PRAGMA table_info(foo)", false},
+		{"PRAGMA main.index_list('foo')", false},
+		{"PRAGMA foreign_keys = ON", true},
+		{"PRAGMA main.journal_mode=DELETE", true},
+		{"PRAGMA user_version(7)", true},
+		{"PRAGMA wal_checkpoint", true},
+		{"PRAGMA optimize", true},
+		{"PRAGMA", true},
+		{"PRAGMAX", true},
+	} {
+		if got := IsWrite(tt.sql); tt.exp != got {
+			t.Fatalf("wrong classification for %s, exp %t, got %t", tt.sql, tt.exp, got)
+		}
+	}
+}
+
+func Test_IsWriteWith(t *testing.T) {
+	readOnly := func(stmt string) (bool, error) {
+		ro, ok := map[string]bool{
+			"SELECT * FROM foo WHERE id IN (SELECT id FROM bar)": true,
+			"SELECT * FROM main.foo":                             true,
+			"SELECT (SELECT 1)":                                  true,
+			"INSERT INTO main.foo SELECT * FROM bar":             false,
+			"BEGIN":                                              true,
+			"ATTACH DATABASE '/tmp/other.db' AS other":           true,
+			"PRAGMA foreign_keys = ON":                           true,
+		}[stmt]
+		if !ok {
+			return false, errors.New("no such table")
+		}
+		return ro, nil
+	}
+	for _, tt := range []struct {
+		sql string
+		exp bool
+	}{
+		{"SELECT * FROM foo WHERE id IN (SELECT id FROM bar)", false},
+		{"SELECT * FROM main.foo", false},
+		{"SELECT (SELECT 1); SELECT * FROM main.foo", false},
+		{"INSERT INTO main.foo SELECT * FROM bar", true},
+		{"SELECT * FROM main.foo; INSERT INTO main.foo SELECT * FROM bar", true},
+		{"BEGIN", true},
+		{"ATTACH DATABASE '/tmp/other.db' AS other", true},
+		{"PRAGMA foreign_keys = ON", true},
+		{"SELECT * FROM qux", false},
+		{"INSERT INTO qux VALUES(1)", true},
+	} {
+		if got := IsWriteWith(tt.sql, readOnly); tt.exp != got {
+			t.Fatalf("wrong classification for %s, exp %t, got %t", tt.sql, tt.exp, got)
+		}
+	}
+}
+
+func Test_ChangesRows(t *testing.T) {
+	for _, tt := range []struct {
+		sql string
+		exp bool
+	}{
+		{"SELECT * FROM foo", false},
+		{"INSERT INTO foo VALUES(1)", true},
+		{"UPDATE foo SET name='fiona' WHERE id=1 AND version=2", true},
+		{"DELETE FROM foo WHERE id=1", true},
+		{"CREATE TABLE foo (id INTEGER PRIMARY KEY)", false},
+		{"DROP TABLE foo", false},
+		{"this is not SQL", false},
+	} {
+		if got := ChangesRows(tt.sql); tt.exp != got {
+			t.Fatalf("wrong classification for %s, exp %t, got %t", tt.sql, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package encoding
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// WriteCSV writes the given rows to w as CSV, as described by RFC 4180. The
+// first record is a header holding the column names. Numbers are written
+// bare, strings and BLOBs, the latter base64-encoded, are quoted, and NULL is
+// written as an empty field, so it can be distinguished from an empty string.
+func WriteCSV(w io.Writer, rows *proto.QueryRows) error {
+	var b strings.Builder
+	for i, c := range rows.Columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(csvQuote(c))
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	for n, vals := range rows.Values {
+		params := vals.GetParameters()
+		if len(params) != len(rows.Columns) {
+			return fmt.Errorf("row %d has %d values, expected %d", n, len(params), len(rows.Columns))
+		}
+		b.Reset()
+		for i, p := range params {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			f, err := csvField(p)
+			if err != nil {
+				return fmt.Errorf("row %d: %s", n, err.Error())
+			}
+			b.WriteString(f)
+		}
+		b.WriteString("\r\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvField returns the CSV representation of the given value.
+func csvField(p *proto.Parameter) (string, error) {
+	switch v := p.GetValue().(type) {
+	case *proto.Parameter_I:
+		return strconv.FormatInt(v.I, 10), nil
+	case *proto.Parameter_D:
+		return strconv.FormatFloat(v.D, 'g', -1, 64), nil
+	case *proto.Parameter_B:
+		return strconv.FormatBool(v.B), nil
+	case *proto.Parameter_Y:
+		return csvQuote(base64.StdEncoding.EncodeToString(v.Y)), nil
+	case *proto.Parameter_S:
+		return csvQuote(v.S), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type: %T", v)
+	}
+}
+
+// csvQuote returns s as a quoted CSV field.
+func csvQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
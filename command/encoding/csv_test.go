@@ -0,0 +1,60 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_WriteCSV(t *testing.T) {
+	rows := &proto.QueryRows{
+		Columns: []string{"id", `na"me`, "score", "data", "ok"},
+		Types:   []string{"integer", "text", "real", "blob", "boolean"},
+		Values: []*proto.Values{
+			{
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}},
+					{Value: &proto.Parameter_S{S: "fiona, \"the\"\nfirst"}},
+					{Value: &proto.Parameter_D{D: 1.5}},
+					{Value: &proto.Parameter_Y{Y: []byte("hi")}},
+					{Value: &proto.Parameter_B{B: true}},
+				},
+			},
+			{
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 2}},
+					{Value: &proto.Parameter_S{S: ""}},
+					{Value: nil},
+					{Value: nil},
+					{Value: &proto.Parameter_B{B: false}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, rows); err != nil {
+		t.Fatalf("failed to write CSV: %s", err.Error())
+	}
+	exp := "\"id\",\"na\"\"me\",\"score\",\"data\",\"ok\"\r\n" +
+		"1,\"fiona, \"\"the\"\"\nfirst\",1.5,\"aGk=\",true\r\n" +
+		"2,\"\",,,false\r\n"
+	if got := buf.String(); got != exp {
+		t.Fatalf("wrong CSV, exp:\n%q\ngot:\n%q", exp, got)
+	}
+
+	// A header is written even if there are no rows.
+	buf.Reset()
+	if err := WriteCSV(&buf, &proto.QueryRows{Columns: []string{"id"}, Types: []string{"integer"}}); err != nil {
+		t.Fatalf("failed to write CSV: %s", err.Error())
+	}
+	if exp, got := "\"id\"\r\n", buf.String(); got != exp {
+		t.Fatalf("wrong CSV, exp %q, got %q", exp, got)
+	}
+
+	rows.Values[0].Parameters = rows.Values[0].Parameters[:1]
+	if err := WriteCSV(&buf, rows); err == nil {
+		t.Fatalf("expected error for row with wrong number of values")
+	}
+}
@@ -25,19 +25,107 @@ type Result struct {
 
 // Rows represents the outcome of an operation that returns query data.
 type Rows struct {
-	Columns []string        `json:"columns,omitempty"`
-	Types   []string        `json:"types,omitempty"`
-	Values  [][]interface{} `json:"values,omitempty"`
-	Error   string          `json:"error,omitempty"`
-	Time    float64         `json:"time,omitempty"`
+	Columns   []string        `json:"columns,omitempty"`
+	Types     []string        `json:"types,omitempty"`
+	Values    [][]interface{} `json:"values,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Time      float64         `json:"time,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
 }
 
 // AssociativeRows represents the outcome of an operation that returns query data.
 type AssociativeRows struct {
-	Types map[string]string        `json:"types,omitempty"`
-	Rows  []map[string]interface{} `json:"rows"`
-	Error string                   `json:"error,omitempty"`
-	Time  float64                  `json:"time,omitempty"`
+	Types     map[string]string        `json:"types,omitempty"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Error     string                   `json:"error,omitempty"`
+	Time      float64                  `json:"time,omitempty"`
+	Truncated bool                     `json:"truncated,omitempty"`
+
+	columns []string // Keys of Types and each row, in column order.
+}
+
+// MarshalJSON implements the json.Marshaler interface. The keys of the types
+// and of each row are written in the order of the columns of the query.
+func (a *AssociativeRows) MarshalJSON() ([]byte, error) {
+	type assocRows AssociativeRows
+	if a.columns == nil {
+		return noEscapeEncode((*assocRows)(a))
+	}
+
+	v := struct {
+		Types     json.RawMessage   `json:"types,omitempty"`
+		Rows      []json.RawMessage `json:"rows"`
+		Error     string            `json:"error,omitempty"`
+		Time      float64           `json:"time,omitempty"`
+		Truncated bool              `json:"truncated,omitempty"`
+	}{
+		Rows:      make([]json.RawMessage, len(a.Rows)),
+		Error:     a.Error,
+		Time:      a.Time,
+		Truncated: a.Truncated,
+	}
+	var err error
+	if len(a.Types) > 0 {
+		v.Types, err = orderedObject(a.columns, func(k string) interface{} { return a.Types[k] })
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, row := range a.Rows {
+		v.Rows[i], err = orderedObject(a.columns, func(k string) interface{} { return row[k] })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return noEscapeEncode(v)
+}
+
+// orderedObject returns a JSON object with the given keys, in order, each
+// set to the value returned by val.
+func orderedObject(keys []string, val func(k string) interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := noEscapeEncode(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := noEscapeEncode(val(k))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// uniqueColumns returns the column names, with any name which repeats an
+// earlier one given a numeric suffix, such as id_2, so every name is unique.
+func uniqueColumns(columns []string) []string {
+	names := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		names[c] = true
+	}
+	used := make(map[string]bool, len(columns))
+	unique := make([]string, len(columns))
+	for i, c := range columns {
+		name := c
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", c, n)
+			if names[name] {
+				name = c
+			}
+		}
+		used[name] = true
+		unique[i] = name
+	}
+	return unique
 }
 
 // ResultWithRows represents the outcome of an operation that changes rows, but also
@@ -116,11 +204,12 @@ func NewRowsFromQueryRows(q *proto.QueryRows, bytesAsArray bool) (*Rows, error)
 		return nil, err
 	}
 	return &Rows{
-		Columns: q.Columns,
-		Types:   q.Types,
-		Values:  values,
-		Error:   q.Error,
-		Time:    q.Time,
+		Columns:   q.Columns,
+		Types:     q.Types,
+		Values:    values,
+		Error:     q.Error,
+		Time:      q.Time,
+		Truncated: q.Truncated,
 	}, nil
 }
 
@@ -135,10 +224,11 @@ func NewAssociativeRowsFromQueryRows(q *proto.QueryRows, bytesAsArray bool) (*As
 		return nil, err
 	}
 
+	columns := uniqueColumns(q.Columns)
 	rows := make([]map[string]interface{}, len(values))
 	for i := range rows {
 		m := make(map[string]interface{})
-		for ii, c := range q.Columns {
+		for ii, c := range columns {
 			m[c] = values[i][ii]
 		}
 		rows[i] = m
@@ -146,14 +236,16 @@ func NewAssociativeRowsFromQueryRows(q *proto.QueryRows, bytesAsArray bool) (*As
 
 	types := make(map[string]string)
 	for i := range q.Types {
-		types[q.Columns[i]] = q.Types[i]
+		types[columns[i]] = q.Types[i]
 	}
 
 	return &AssociativeRows{
-		Types: types,
-		Rows:  rows,
-		Error: q.Error,
-		Time:  q.Time,
+		Types:     types,
+		Rows:      rows,
+		Error:     q.Error,
+		Time:      q.Time,
+		Truncated: q.Truncated,
+		columns:   columns,
 	}, nil
 }
 
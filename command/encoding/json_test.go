@@ -1,6 +1,7 @@
 package encoding
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/rqlite/rqlite/v8/command/proto"
@@ -307,6 +308,60 @@ func Test_MarshalQueryAssociativeRows(t *testing.T) {
 
 // Test_MarshalQueryRows_Blob tests JSON marshaling of QueryRows with
 // BLOB values.
+func Test_MarshalQueryAssociativeRows_ColumnOrder(t *testing.T) {
+	enc := Encoder{
+		Associative: true,
+	}
+	r := &proto.QueryRows{
+		Columns: []string{"zeta", "id", "alpha", "id", "id_2"},
+		Types:   []string{"text", "integer", "text", "integer", "text"},
+		Values: []*proto.Values{
+			{
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_S{S: "<z>"}},
+					{Value: &proto.Parameter_I{I: 1}},
+					{Value: &proto.Parameter_S{S: "a&b"}},
+					{Value: &proto.Parameter_I{I: 2}},
+					{Value: &proto.Parameter_S{S: "two"}},
+				},
+			},
+		},
+	}
+
+	b, err := enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	if exp, got := `{"types":{"zeta":"text","id":"integer","alpha":"text","id_3":"integer","id_2":"text"},"rows":[{"zeta":"<z>","id":1,"alpha":"a&b","id_3":2,"id_2":"two"}]}`, string(b); exp != got {
+		t.Fatalf("failed to marshal QueryRows: exp %s, got %s", exp, got)
+	}
+
+	r.Values = nil
+	b, err = enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	if exp, got := `{"types":{"zeta":"text","id":"integer","alpha":"text","id_3":"integer","id_2":"text"},"rows":[]}`, string(b); exp != got {
+		t.Fatalf("failed to marshal QueryRows: exp %s, got %s", exp, got)
+	}
+}
+
+func Test_UniqueColumns(t *testing.T) {
+	for _, tt := range []struct {
+		columns []string
+		exp     []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"a", "b"}, []string{"a", "b"}},
+		{[]string{"a", "a", "a"}, []string{"a", "a_2", "a_3"}},
+		{[]string{"a", "a", "a_2"}, []string{"a", "a_3", "a_2"}},
+	} {
+		if got := uniqueColumns(tt.columns); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("wrong unique columns for %v, exp %v, got %v", tt.columns, tt.exp, got)
+		}
+	}
+}
+
 func Test_MarshalQueryRows_Blob(t *testing.T) {
 	var b []byte
 	var err error
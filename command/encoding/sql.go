@@ -0,0 +1,218 @@
+package encoding
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// WriteSQLInserts writes the given rows to w as SQL, creating the named table
+// if it does not exist and then inserting each row. The output can be loaded
+// into a database as a SQL dump.
+func WriteSQLInserts(w io.Writer, table string, rows *proto.QueryRows) error {
+	if len(rows.Columns) != len(rows.Types) {
+		return ErrTypesColumnsLengthViolation
+	}
+
+	cols := make([]string, len(rows.Columns))
+	defs := make([]string, len(rows.Columns))
+	for i, c := range rows.Columns {
+		cols[i] = QuoteIdentifier(c)
+		defs[i] = strings.TrimSpace(cols[i] + " " + rows.Types[i])
+	}
+	tbl := QuoteIdentifier(table)
+
+	var b strings.Builder
+	b.WriteString("BEGIN TRANSACTION;\n")
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (%s);\n", tbl, strings.Join(defs, ", "))
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	colList := strings.Join(cols, ",")
+	for n, vals := range rows.Values {
+		params := vals.GetParameters()
+		if len(params) != len(cols) {
+			return fmt.Errorf("row %d has %d values, expected %d", n, len(params), len(cols))
+		}
+		b.Reset()
+		fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES(", tbl, colList)
+		for i, p := range params {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			lit, err := sqlLiteral(p)
+			if err != nil {
+				return fmt.Errorf("row %d: %s", n, err.Error())
+			}
+			b.WriteString(lit)
+		}
+		b.WriteString(");\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+// QuoteIdentifier returns the given SQL identifier double-quoted, such that
+// it is safe to use in a statement.
+func QuoteIdentifier(id string) string {
+	return `"` + strings.ReplaceAll(id, `"`, `""`) + `"`
+}
+
+// sqlLiteral returns the SQL literal representation of the given value.
+func sqlLiteral(p *proto.Parameter) (string, error) {
+	switch v := p.GetValue().(type) {
+	case *proto.Parameter_I:
+		return strconv.FormatInt(v.I, 10), nil
+	case *proto.Parameter_D:
+		switch {
+		case math.IsNaN(v.D):
+			return "NULL", nil
+		case math.IsInf(v.D, 1):
+			return "1e999", nil
+		case math.IsInf(v.D, -1):
+			return "-1e999", nil
+		}
+		s := strconv.FormatFloat(v.D, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			// Ensure SQLite reads the value as a REAL.
+			s += ".0"
+		}
+		return s, nil
+	case *proto.Parameter_B:
+		if v.B {
+			return "1", nil
+		}
+		return "0", nil
+	case *proto.Parameter_Y:
+		return "X'" + hex.EncodeToString(v.Y) + "'", nil
+	case *proto.Parameter_S:
+		return "'" + strings.ReplaceAll(v.S, "'", "''") + "'", nil
+	case nil:
+		return "NULL", nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type: %T", v)
+	}
+}
+
+// InterpolateSQL returns the SQL of the given statement with its bound
+// parameters substituted in place of their placeholders. The result is for
+// display only, and must not be executed. Placeholders inside string
+// literals, quoted identifiers and comments are left untouched, as are
+// placeholders which have no matching parameter.
+func InterpolateSQL(stmt *proto.Statement) (string, error) {
+	sql := stmt.Sql
+	params := stmt.Parameters
+	if len(params) == 0 {
+		return sql, nil
+	}
+
+	named := make(map[string]*proto.Parameter)
+	var positional []*proto.Parameter
+	for _, p := range params {
+		if p.Name != "" {
+			named[p.Name] = p
+		} else {
+			positional = append(positional, p)
+		}
+	}
+
+	var b strings.Builder
+	next := 0
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == end {
+					if end != ']' && j+1 < len(sql) && sql[j+1] == end {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			j = min(j+1, len(sql))
+			b.WriteString(sql[i:j])
+			i = j
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			j := strings.IndexByte(sql[i:], '\n')
+			if j == -1 {
+				j = len(sql) - i
+			}
+			b.WriteString(sql[i : i+j])
+			i += j
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			j := strings.Index(sql[i+2:], "*/")
+			if j == -1 {
+				j = len(sql)
+			} else {
+				j = i + 2 + j + 2
+			}
+			b.WriteString(sql[i:j])
+			i = j
+		case c == '?':
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			idx := next
+			if j > i+1 {
+				n, err := strconv.Atoi(sql[i+1 : j])
+				if err != nil {
+					return "", err
+				}
+				idx = n - 1
+			}
+			next = idx + 1
+			if idx < 0 || idx >= len(positional) {
+				b.WriteString(sql[i:j])
+			} else {
+				lit, err := sqlLiteral(positional[idx])
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(lit)
+			}
+			i = j
+		case (c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentChar(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && isIdentChar(sql[j]) {
+				j++
+			}
+			p, ok := named[sql[i+1:j]]
+			if !ok {
+				b.WriteString(sql[i:j])
+			} else {
+				lit, err := sqlLiteral(p)
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(lit)
+			}
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
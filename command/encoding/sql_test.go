@@ -0,0 +1,158 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
+)
+
+func Test_WriteSQLInserts(t *testing.T) {
+	rows := &proto.QueryRows{
+		Columns: []string{"id", `na"me`},
+		Types:   []string{"integer", "text"},
+		Values: []*proto.Values{
+			{
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}},
+					{Value: &proto.Parameter_S{S: "it's"}},
+				},
+			},
+			{
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 2}},
+					{Value: nil},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSQLInserts(&buf, "foo", rows); err != nil {
+		t.Fatalf("failed to write SQL inserts: %s", err.Error())
+	}
+	exp := `BEGIN TRANSACTION;
+CREATE TABLE IF NOT EXISTS "foo" ("id" integer, "na""me" text);
+INSERT INTO "foo" ("id","na""me") VALUES(1,'it''s');
+INSERT INTO "foo" ("id","na""me") VALUES(2,NULL);
+COMMIT;
+`
+	if got := buf.String(); got != exp {
+		t.Fatalf("wrong SQL, exp:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func Test_WriteSQLInsertsRoundTrip(t *testing.T) {
+	srcDB, err := db.Open(filepath.Join(t.TempDir(), "src.db"), false, false)
+	if err != nil {
+		t.Fatalf("failed to open source database: %s", err.Error())
+	}
+	defer srcDB.Close()
+	if _, err := srcDB.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	if _, err := srcDB.ExecuteStringStmt(`INSERT INTO foo VALUES(1, 'fiona', 2.0, X'00FF'), (2, 'o''reilly; DROP TABLE foo', -1.5e300, NULL), (3, NULL, NULL, X'')`); err != nil {
+		t.Fatalf("failed to insert rows: %s", err.Error())
+	}
+	srcRows, err := srcDB.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query source database: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSQLInserts(&buf, "bar", srcRows[0]); err != nil {
+		t.Fatalf("failed to write SQL inserts: %s", err.Error())
+	}
+
+	dstDB, err := db.Open(filepath.Join(t.TempDir(), "dst.db"), false, false)
+	if err != nil {
+		t.Fatalf("failed to open destination database: %s", err.Error())
+	}
+	defer dstDB.Close()
+	if _, err := dstDB.ExecuteStringStmt(buf.String()); err != nil {
+		t.Fatalf("failed to load generated SQL: %s", err.Error())
+	}
+	dstRows, err := dstDB.QueryStringStmt(`SELECT * FROM bar`)
+	if err != nil {
+		t.Fatalf("failed to query destination database: %s", err.Error())
+	}
+
+	enc := Encoder{}
+	exp, err := enc.JSONMarshal(srcRows)
+	if err != nil {
+		t.Fatalf("failed to marshal source rows: %s", err.Error())
+	}
+	got, err := enc.JSONMarshal(dstRows)
+	if err != nil {
+		t.Fatalf("failed to marshal destination rows: %s", err.Error())
+	}
+	if !json.Valid(got) || string(exp) != string(got) {
+		t.Fatalf("rows did not round-trip, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_InterpolateSQL(t *testing.T) {
+	for _, tt := range []struct {
+		stmt *proto.Statement
+		exp  string
+	}{
+		{
+			stmt: &proto.Statement{Sql: "SELECT * FROM foo"},
+			exp:  "SELECT * FROM foo",
+		},
+		{
+			stmt: &proto.Statement{
+				Sql: "SELECT * FROM foo WHERE a=? AND b=? AND c=? AND d=? AND e=?",
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}},
+					{Value: &proto.Parameter_S{S: "it's"}},
+					{Value: &proto.Parameter_D{D: 2}},
+					{Value: &proto.Parameter_Y{Y: []byte{0xca, 0xfe}}},
+					{Value: nil},
+				},
+			},
+			exp: "SELECT * FROM foo WHERE a=1 AND b='it''s' AND c=2.0 AND d=X'cafe' AND e=NULL",
+		},
+		{
+			stmt: &proto.Statement{
+				Sql: "SELECT * FROM foo WHERE a=?2 AND b=?1",
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}},
+					{Value: &proto.Parameter_I{I: 2}},
+				},
+			},
+			exp: "SELECT * FROM foo WHERE a=2 AND b=1",
+		},
+		{
+			stmt: &proto.Statement{
+				Sql: "SELECT * FROM foo WHERE a=:a AND b=@b AND c=$c AND d=:d",
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}, Name: "a"},
+					{Value: &proto.Parameter_S{S: "x"}, Name: "b"},
+					{Value: &proto.Parameter_B{B: true}, Name: "c"},
+				},
+			},
+			exp: "SELECT * FROM foo WHERE a=1 AND b='x' AND c=1 AND d=:d",
+		},
+		{
+			stmt: &proto.Statement{
+				Sql: `SELECT '?', "a?", [b?] FROM foo /* ? */ WHERE a=? -- ?`,
+				Parameters: []*proto.Parameter{
+					{Value: &proto.Parameter_I{I: 1}},
+				},
+			},
+			exp: `SELECT '?', "a?", [b?] FROM foo /* ? */ WHERE a=1 -- ?`,
+		},
+	} {
+		got, err := InterpolateSQL(tt.stmt)
+		if err != nil {
+			t.Fatalf("failed to interpolate %s: %s", tt.stmt.Sql, err.Error())
+		}
+		if got != tt.exp {
+			t.Fatalf("wrong interpolation, exp:\n%s\ngot:\n%s", tt.exp, got)
+		}
+	}
+}
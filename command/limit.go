@@ -0,0 +1,95 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rqlite/sql"
+)
+
+// NoLimit returns those of the SQL statements, held by the given string, which
+// are SELECT statements without a LIMIT clause. A LIMIT clause which applies
+// only to a subquery or common table expression does not count. Statements
+// which cannot be parsed are not returned, leaving SQLite to report any error.
+func NoLimit(stmts string) []string {
+	var noLimit []string
+	for _, s := range Split(stmts) {
+		if needsLimit(s) {
+			noLimit = append(noLimit, s)
+		}
+	}
+	return noLimit
+}
+
+// AddLimit adds a LIMIT clause of n to each SELECT statement, held by the given
+// string, which does not have one. It returns the rewritten string, and whether
+// any clause was added. The statements are otherwise left as written.
+func AddLimit(stmts string, n int64) (string, bool) {
+	split := Split(stmts)
+	added := false
+	for i, s := range split {
+		if !needsLimit(s) {
+			continue
+		}
+		// A newline ends any trailing comment, which would otherwise swallow
+		// the clause.
+		sep := " "
+		if strings.Contains(s, "--") {
+			sep = "\n"
+		}
+		split[i] = s + sep + "LIMIT " + strconv.FormatInt(n, 10)
+		added = true
+	}
+	if !added {
+		return stmts, false
+	}
+	return strings.Join(split, "; "), true
+}
+
+// needsLimit returns whether the given SQL statement is a SELECT statement
+// without a LIMIT clause.
+func needsLimit(stmt string) bool {
+	s, err := sql.NewParser(strings.NewReader(stripComments(stmt))).ParseStatement()
+	if err != nil {
+		return false
+	}
+	sel, ok := s.(*sql.SelectStatement)
+	return ok && sel.ValueLists == nil && sel.LimitExpr == nil
+}
+
+// stripComments returns the given SQL with each comment replaced by a space,
+// since the parser does not recognise comments.
+func stripComments(stmt string) string {
+	var b strings.Builder
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := skipQuoted(stmt, i, end)
+			if j >= len(stmt) {
+				j = len(stmt) - 1
+			}
+			b.WriteString(stmt[i : j+1])
+			i = j
+		case c == '-' && i+1 < len(stmt) && stmt[i+1] == '-':
+			for i < len(stmt) && stmt[i] != '\n' {
+				i++
+			}
+			b.WriteByte(' ')
+		case c == '/' && i+1 < len(stmt) && stmt[i+1] == '*':
+			if j := strings.Index(stmt[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(stmt)
+			}
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
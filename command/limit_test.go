@@ -0,0 +1,54 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_NoLimit(t *testing.T) {
+	for _, tt := range []struct {
+		stmts string
+		exp   []string
+	}{
+		{`SELECT * FROM foo`, []string{`SELECT * FROM foo`}},
+		{`SELECT * FROM foo LIMIT 10`, nil},
+		{`SELECT * FROM foo LIMIT 10 OFFSET 5`, nil},
+		{`SELECT * FROM (SELECT * FROM foo LIMIT 10)`, []string{`SELECT * FROM (SELECT * FROM foo LIMIT 10)`}},
+		{`WITH f AS (SELECT * FROM foo) SELECT * FROM f LIMIT 1`, nil},
+		{`SELECT a FROM foo UNION SELECT b FROM bar LIMIT 3`, nil},
+		{`SELECT * FROM foo; SELECT * FROM bar LIMIT 1`, []string{`SELECT * FROM foo`}},
+		{`INSERT INTO foo VALUES(1)`, nil},
+		{`EXPLAIN QUERY PLAN SELECT * FROM foo`, nil},
+		{`VALUES(1, 2)`, nil},
+		{`PRAGMA table_info(foo)`, nil},
+		{`SELECT * FROM foo -- LIMIT 10`, []string{`SELECT * FROM foo -- LIMIT 10`}},
+		{`SELECT * FROM foo /* no limit */ LIMIT 10`, nil},
+		{`SELECT '--' FROM foo LIMIT 10`, nil},
+		{`not SQL at all`, nil},
+	} {
+		if got := NoLimit(tt.stmts); !reflect.DeepEqual(tt.exp, got) {
+			t.Fatalf("wrong statements without limit for %s, exp %v, got %v", tt.stmts, tt.exp, got)
+		}
+	}
+}
+
+func Test_AddLimit(t *testing.T) {
+	for _, tt := range []struct {
+		stmts string
+		exp   string
+		added bool
+	}{
+		{`SELECT * FROM foo`, `SELECT * FROM foo LIMIT 100`, true},
+		{`SELECT * FROM foo;`, `SELECT * FROM foo LIMIT 100`, true},
+		{`SELECT * FROM foo ORDER BY id -- newest last`, "SELECT * FROM foo ORDER BY id -- newest last\nLIMIT 100", true},
+		{`SELECT a FROM foo UNION SELECT b FROM bar`, `SELECT a FROM foo UNION SELECT b FROM bar LIMIT 100`, true},
+		{`SELECT * FROM foo; SELECT * FROM bar LIMIT 1`, `SELECT * FROM foo LIMIT 100; SELECT * FROM bar LIMIT 1`, true},
+		{`SELECT * FROM foo LIMIT 1;`, `SELECT * FROM foo LIMIT 1;`, false},
+		{`INSERT INTO foo VALUES(1)`, `INSERT INTO foo VALUES(1)`, false},
+	} {
+		got, added := AddLimit(tt.stmts, 100)
+		if got != tt.exp || added != tt.added {
+			t.Fatalf("wrong rewrite of %s, exp %q (%v), got %q (%v)", tt.stmts, tt.exp, tt.added, got, added)
+		}
+	}
+}
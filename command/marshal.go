@@ -172,6 +172,23 @@ func UnmarshalLoadChunkRequest(b []byte, lr *proto.LoadChunkRequest) error {
 	return pb.Unmarshal(b, lr)
 }
 
+// ExecuteBatchRequester adapts an ExecuteBatchRequest to a Requester, so that
+// a batch may be compressed by a RequestMarshaler. Whether to compress is
+// decided on the statements of every request in the batch.
+type ExecuteBatchRequester struct {
+	*proto.ExecuteBatchRequest
+}
+
+// GetRequest returns a Request holding the statements of every request in
+// the batch.
+func (b ExecuteBatchRequester) GetRequest() *proto.Request {
+	r := &proto.Request{}
+	for _, er := range b.GetRequests() {
+		r.Statements = append(r.Statements, er.GetRequest().GetStatements()...)
+	}
+	return r
+}
+
 // UnmarshalSubCommand unmarshalls a sub command m. It assumes that
 // m is the correct type.
 func UnmarshalSubCommand(c *proto.Command, m pb.Message) error {
@@ -133,6 +133,48 @@ func Test_MarshalCompressedBatch(t *testing.T) {
 	}
 }
 
+func Test_MarshalCompressedExecuteBatch(t *testing.T) {
+	rm := NewRequestMarshaler()
+	rm.BatchThreshold = 2
+	rm.ForceCompression = true
+
+	er := func(sql string) *proto.ExecuteRequest {
+		return &proto.ExecuteRequest{
+			Request: &proto.Request{
+				Statements: []*proto.Statement{{Sql: sql}},
+			},
+		}
+	}
+	br := &proto.ExecuteBatchRequest{
+		Requests: []*proto.ExecuteRequest{
+			er(`INSERT INTO "names" VALUES(1,'bob','123-45-678')`),
+			er(`INSERT INTO "names" VALUES(2,'tom','111-22-333')`),
+		},
+	}
+
+	// The batch threshold applies to the statements of the whole batch.
+	b, comp, err := rm.Marshal(ExecuteBatchRequester{br})
+	if err != nil {
+		t.Fatalf("failed to marshal ExecuteBatchRequest: %s", err)
+	}
+	if !comp {
+		t.Fatal("Marshaled ExecuteBatchRequest wasn't compressed")
+	}
+
+	c := &proto.Command{
+		Type:       proto.Command_COMMAND_TYPE_EXECUTE_BATCH,
+		SubCommand: b,
+		Compressed: comp,
+	}
+	var nr proto.ExecuteBatchRequest
+	if err := UnmarshalSubCommand(c, &nr); err != nil {
+		t.Fatalf("failed to unmarshal sub command: %s", err)
+	}
+	if !pb.Equal(&nr, br) {
+		t.Fatal("Original and unmarshaled Execute Batch Request are not equal")
+	}
+}
+
 func Test_MarshalCompressedSize(t *testing.T) {
 	rm := NewRequestMarshaler()
 	rm.SizeThreshold = 1
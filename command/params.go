@@ -0,0 +1,51 @@
+package command
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NamedParameters returns the names, without prefix, of the named parameters
+// referenced by the given SQL statement, in the order they first appear.
+// Parameters may be prefixed with ':', '@', or '$'. Text within string
+// literals, quoted identifiers, and comments is ignored.
+func NamedParameters(sql string) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(sql, i, c)
+		case c == '[':
+			i = skipQuoted(sql, i, ']')
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(sql)
+			}
+		case c == ':' || c == '@' || c == '$':
+			j := i + 1
+			for j < len(sql) && isIdentByte(sql[j]) {
+				j++
+			}
+			if name := sql[i+1 : j]; name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			i = j - 1
+		}
+	}
+	return names
+}
+
+// isIdentByte returns whether c may appear in an SQL identifier.
+func isIdentByte(c byte) bool {
+	return c == '_' || c > unicode.MaxASCII || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
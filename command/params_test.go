@@ -0,0 +1,51 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_NamedParameters(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		sql  string
+		exp  []string
+	}{
+		{
+			name: "none",
+			sql:  "SELECT * FROM foo WHERE id = ?",
+			exp:  nil,
+		},
+		{
+			name: "single",
+			sql:  "SELECT * FROM foo WHERE tenant = :tenant_id",
+			exp:  []string{"tenant_id"},
+		},
+		{
+			name: "all prefixes, deduplicated",
+			sql:  "SELECT :a, @b, $c, :a",
+			exp:  []string{"a", "b", "c"},
+		},
+		{
+			name: "ignore string literal",
+			sql:  "SELECT ':a' FROM foo WHERE x = :b",
+			exp:  []string{"b"},
+		},
+		{
+			name: "ignore comments",
+			sql:  "SELECT 1 -- :a\n/* @b */ WHERE x = $c",
+			exp:  []string{"c"},
+		},
+		{
+			name: "ignore quoted identifier",
+			sql:  `SELECT "col:a", [col@b] FROM foo`,
+			exp:  nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NamedParameters(tt.sql); !reflect.DeepEqual(got, tt.exp) {
+				t.Fatalf("exp %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package command
+
+import (
+	"strings"
+)
+
+// Split splits a string containing one or more SQL statements, separated
+// by semicolons, into individual statements. Semicolons within string
+// literals, quoted identifiers, comments, and the body of CREATE TRIGGER
+// statements do not separate statements. Each returned statement has
+// surrounding whitespace and its terminating semicolon removed. Empty
+// statements, including those consisting only of comments, are discarded.
+func Split(sql string) []string {
+	var stmts []string
+	var words []string // Upper-cased words of the current statement.
+	var word strings.Builder
+	start := 0
+	empty := true // Whether the current statement holds only comments so far.
+
+	endWord := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+	endStmt := func(end int) {
+		if s := strings.TrimSpace(sql[start:end]); !empty {
+			stmts = append(stmts, s)
+		}
+		words = words[:0]
+		empty = true
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			endWord()
+			empty = false
+			i = skipQuoted(sql, i, c)
+		case c == '[':
+			endWord()
+			empty = false
+			i = skipQuoted(sql, i, ']')
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			endWord()
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			endWord()
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(sql)
+			}
+		case c == ';':
+			endWord()
+			if isTrigger(words) && words[len(words)-1] != "END" {
+				continue
+			}
+			endStmt(i)
+			start = i + 1
+		case isIdentByte(c):
+			empty = false
+			word.WriteByte(c)
+		default:
+			endWord()
+			if !isSpace(c) {
+				empty = false
+			}
+		}
+	}
+	endStmt(len(sql))
+	return stmts
+}
+
+// skipQuoted returns the index of the byte which closes the quoted section
+// beginning at index i. A doubled closing quote is treated as an escape.
+func skipQuoted(sql string, i int, end byte) int {
+	for i++; i < len(sql); i++ {
+		if sql[i] == end {
+			if end != ']' && i+1 < len(sql) && sql[i+1] == end {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return i
+}
+
+// isTrigger returns whether the given statement words begin a CREATE TRIGGER
+// statement.
+func isTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TRIGGER" {
+		return true
+	}
+	return len(words) > 2 && (words[1] == "TEMP" || words[1] == "TEMPORARY") && words[2] == "TRIGGER"
+}
+
+// isSpace returns whether c is whitespace, as understood by SQLite.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
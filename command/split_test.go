@@ -0,0 +1,87 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Split(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		sql  string
+		exp  []string
+	}{
+		{
+			name: "empty",
+			sql:  "",
+			exp:  nil,
+		},
+		{
+			name: "single, no semicolon",
+			sql:  "SELECT 1",
+			exp:  []string{"SELECT 1"},
+		},
+		{
+			name: "single, trailing semicolon",
+			sql:  " SELECT 1; ",
+			exp:  []string{"SELECT 1"},
+		},
+		{
+			name: "two statements",
+			sql:  "SELECT 1; SELECT 2",
+			exp:  []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name: "empty statements",
+			sql:  ";;SELECT 1;;",
+			exp:  []string{"SELECT 1"},
+		},
+		{
+			name: "semicolon in string",
+			sql:  "SELECT 'a;b'; SELECT 'it''s;'",
+			exp:  []string{"SELECT 'a;b'", "SELECT 'it''s;'"},
+		},
+		{
+			name: "semicolon in identifiers",
+			sql:  `SELECT "a;b", [c;d], ` + "`e;f`" + ` FROM foo`,
+			exp:  []string{`SELECT "a;b", [c;d], ` + "`e;f`" + ` FROM foo`},
+		},
+		{
+			name: "semicolon in comments",
+			sql:  "SELECT 1 -- one; two\n; /* three; */ SELECT 2",
+			exp:  []string{"SELECT 1 -- one; two", "/* three; */ SELECT 2"},
+		},
+		{
+			name: "comment-only statements",
+			sql:  "-- header\nSELECT 1; /* middle */; -- trailer\n",
+			exp:  []string{"-- header\nSELECT 1"},
+		},
+		{
+			name: "trigger",
+			sql:  "CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE bar SET x=1; DELETE FROM baz; END; SELECT 1",
+			exp: []string{
+				"CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE bar SET x=1; DELETE FROM baz; END",
+				"SELECT 1",
+			},
+		},
+		{
+			name: "temp trigger",
+			sql:  "create temp trigger t after insert on foo begin select 1; end;select 2",
+			exp: []string{
+				"create temp trigger t after insert on foo begin select 1; end",
+				"select 2",
+			},
+		},
+		{
+			name: "unterminated string",
+			sql:  "SELECT 'a;b",
+			exp:  []string{"SELECT 'a;b"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.sql); !reflect.DeepEqual(tt.exp, got) {
+				t.Fatalf("wrong split, exp %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
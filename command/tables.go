@@ -0,0 +1,105 @@
+package command
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rqlite/sql"
+)
+
+// Tables returns, in lower case and sorted, the tables referenced by the given
+// SQL statement, including those referenced only by subqueries and common
+// table expressions. Views are returned as tables. So are the names of common
+// table expressions, since one may share the name of a table it hides from
+// only part of the statement. If the SQL holds more than one statement, the
+// tables of all of them are returned. An error is returned if any statement
+// cannot be parsed.
+func Tables(stmt string) ([]string, error) {
+	return TablesWith(stmt, nil)
+}
+
+// TablesFunc returns the tables accessed by a single SQL statement.
+type TablesFunc func(stmt string) ([]string, error)
+
+// TablesWith is like Tables, but calls fallback, if it is not nil, for any
+// statement which cannot be parsed. The tables returned by fallback are
+// merged with those of the other statements. An error is returned if a
+// statement cannot be parsed and fallback is nil or fails.
+func TablesWith(stmt string, fallback TablesFunc) ([]string, error) {
+	v := &tableVisitor{tables: make(map[string]bool)}
+	for _, st := range Split(stmt) {
+		s, err := sql.NewParser(strings.NewReader(stripComments(st))).ParseStatement()
+		if err != nil {
+			if fallback == nil {
+				return nil, err
+			}
+			tables, ferr := fallback(st)
+			if ferr != nil {
+				return nil, ferr
+			}
+			for _, t := range tables {
+				v.tables[strings.ToLower(t)] = true
+			}
+			continue
+		}
+		if err := sql.Walk(v, s); err != nil {
+			return nil, err
+		}
+	}
+
+	tables := make([]string, 0, len(v.tables))
+	for t := range v.tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// tableVisitor collects the tables referenced by the nodes it visits.
+type tableVisitor struct {
+	tables map[string]bool
+}
+
+func (v *tableVisitor) add(ident *sql.Ident) {
+	if name := sql.IdentName(ident); name != "" {
+		v.tables[strings.ToLower(name)] = true
+	}
+}
+
+func (v *tableVisitor) Visit(node sql.Node) (sql.Visitor, error) {
+	switch n := node.(type) {
+	case *sql.WithClause:
+		// Walk does not descend into common table expressions.
+		for _, cte := range n.CTEs {
+			v.add(cte.TableName)
+			if cte.Select != nil {
+				if err := sql.Walk(v, cte.Select); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case *sql.QualifiedTableName:
+		v.add(n.Name)
+	case *sql.InsertStatement:
+		v.add(n.Table)
+	case *sql.CreateTableStatement:
+		v.add(n.Name)
+	case *sql.AlterTableStatement:
+		v.add(n.Name)
+	case *sql.DropTableStatement:
+		v.add(n.Name)
+	case *sql.CreateViewStatement:
+		v.add(n.Name)
+	case *sql.DropViewStatement:
+		v.add(n.Name)
+	case *sql.CreateIndexStatement:
+		v.add(n.Table)
+	case *sql.CreateTriggerStatement:
+		v.add(n.Table)
+	}
+	return v, nil
+}
+
+func (v *tableVisitor) VisitEnd(node sql.Node) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_Tables(t *testing.T) {
+	for _, tt := range []struct {
+		stmt string
+		exp  []string
+	}{
+		{"SELECT * FROM foo", []string{"foo"}},
+		{"SELECT * FROM Foo f JOIN bar b ON f.id = b.id", []string{"bar", "foo"}},
+		{"SELECT * FROM foo WHERE EXISTS (SELECT 1 FROM bar)", []string{"bar", "foo"}},
+		{"SELECT * FROM foo UNION SELECT * FROM bar", []string{"bar", "foo"}},
+		{"SELECT * FROM (SELECT * FROM foo)", []string{"foo"}},
+		{"WITH cte AS (SELECT * FROM foo) SELECT * FROM cte", []string{"cte", "foo"}},
+		{"SELECT 1", []string{}},
+		{"INSERT INTO foo(name) VALUES('fiona')", []string{"foo"}},
+		{"INSERT INTO foo SELECT * FROM bar", []string{"bar", "foo"}},
+		{"DELETE FROM foo WHERE id = 1", []string{"foo"}},
+		{"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)", []string{"foo"}},
+		{"CREATE VIEW v AS SELECT * FROM foo", []string{"foo", "v"}},
+		{"CREATE INDEX idx ON foo(name)", []string{"foo"}},
+		{"DROP TABLE foo", []string{"foo"}},
+		{"ALTER TABLE foo ADD COLUMN age INTEGER", []string{"foo"}},
+		{"EXPLAIN SELECT * FROM foo", []string{"foo"}},
+		{"SELECT * FROM foo; SELECT * FROM bar", []string{"bar", "foo"}},
+		{"SELECT * FROM foo -- comment", []string{"foo"}},
+	} {
+		tables, err := Tables(tt.stmt)
+		if err != nil {
+			t.Fatalf("failed to get tables of %s: %s", tt.stmt, err)
+		}
+		if !reflect.DeepEqual(tables, tt.exp) {
+			t.Fatalf("wrong tables for %s, exp %v, got %v", tt.stmt, tt.exp, tables)
+		}
+	}
+
+	if _, err := Tables("nonsense"); err == nil {
+		t.Fatalf("expected error getting tables of invalid statement")
+	}
+	if _, err := Tables("SELECT * FROM foo; nonsense"); err == nil {
+		t.Fatalf("expected error getting tables of invalid second statement")
+	}
+}
+
+func Test_TablesWith(t *testing.T) {
+	fallback := func(stmt string) ([]string, error) {
+		if stmt == "nonsense" {
+			return nil, errors.New("no such table")
+		}
+		return []string{"Bar"}, nil
+	}
+
+	tables, err := TablesWith("SELECT * FROM foo; SELECT * FROM main.bar", fallback)
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err)
+	}
+	if exp := []string{"bar", "foo"}; !reflect.DeepEqual(tables, exp) {
+		t.Fatalf("wrong tables, exp %v, got %v", exp, tables)
+	}
+
+	// The fallback is not called for statements which parse.
+	tables, err = TablesWith("SELECT * FROM foo", func(string) ([]string, error) {
+		t.Fatalf("fallback called for statement which parses")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(tables, exp) {
+		t.Fatalf("wrong tables, exp %v, got %v", exp, tables)
+	}
+
+	if _, err := TablesWith("SELECT * FROM foo; nonsense", fallback); err == nil {
+		t.Fatalf("expected error when fallback fails")
+	}
+}
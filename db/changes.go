@@ -0,0 +1,102 @@
+package db
+
+import (
+	"sync"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// ChangeOp is the kind of change made to a row.
+type ChangeOp string
+
+const (
+	// ChangeInsert is the insertion of a row.
+	ChangeInsert ChangeOp = "insert"
+
+	// ChangeUpdate is the update of a row.
+	ChangeUpdate ChangeOp = "update"
+
+	// ChangeDelete is the deletion of a row.
+	ChangeDelete ChangeOp = "delete"
+
+	// ChangeReset is the replacement of the entire database, such as by a
+	// load or a restore from a snapshot. It names no table or row.
+	ChangeReset ChangeOp = "reset"
+)
+
+// Change is a change to a row of a table.
+type Change struct {
+	Op    ChangeOp `json:"op"`
+	Table string   `json:"table,omitempty"`
+	RowID int64    `json:"rowid,omitempty"`
+}
+
+// ChangeFunc is called with the changes made by each transaction, once it
+// commits. It is called while the database connection is still in use, so
+// must return quickly, and must not call back into the database.
+type ChangeFunc func(changes []Change)
+
+var changeOps = map[int]ChangeOp{
+	sqlite3.SQLITE_INSERT: ChangeInsert,
+	sqlite3.SQLITE_UPDATE: ChangeUpdate,
+	sqlite3.SQLITE_DELETE: ChangeDelete,
+}
+
+// changeTracker collects, using SQLite's update hook, the changes made to
+// rows through the connections it is registered with, and passes them to
+// its ChangeFunc once they are committed. Changes which are rolled back are
+// discarded. As with the update hook, changes to WITHOUT ROWID tables, and
+// rows deleted by a DELETE without a WHERE clause, are not tracked.
+type changeTracker struct {
+	mu      sync.Mutex
+	fn      ChangeFunc
+	pending []Change
+}
+
+// setFunc sets the ChangeFunc. Changes are only tracked while it is set.
+func (t *changeTracker) setFunc(fn ChangeFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fn = fn
+	t.pending = nil
+}
+
+// getFunc returns the ChangeFunc.
+func (t *changeTracker) getFunc() ChangeFunc {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fn
+}
+
+// register registers the tracker's hooks with the given connection.
+func (t *changeTracker) register(c *sqlite3.SQLiteConn) {
+	c.RegisterUpdateHook(t.update)
+	c.RegisterCommitHook(t.commit)
+	c.RegisterRollbackHook(t.rollback)
+}
+
+func (t *changeTracker) update(op int, database, table string, rowid int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fn == nil || database != "main" {
+		return
+	}
+	t.pending = append(t.pending, Change{Op: changeOps[op], Table: table, RowID: rowid})
+}
+
+func (t *changeTracker) commit() int {
+	t.mu.Lock()
+	fn, changes := t.fn, t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if fn != nil && len(changes) > 0 {
+		fn(changes)
+	}
+	return 0
+}
+
+func (t *changeTracker) rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = nil
+}
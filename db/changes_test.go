@@ -0,0 +1,95 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_DBChangeFunc(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+
+	var got [][]Change
+	db.SetChangeFunc(func(changes []Change) {
+		got = append(got, append([]Change(nil), changes...))
+	})
+
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	mustExecute(db, `UPDATE foo SET name = "declan" WHERE id = 1`)
+
+	// A transaction which fails is rolled back, and its changes discarded.
+	if _, err := db.Execute(&command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(2, "aoife")`},
+			{Sql: `INSERT INTO foo(id, name) VALUES(1, "dup")`},
+		},
+	}, false); err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	mustExecute(db, `DELETE FROM foo WHERE id = 1`)
+
+	exp := [][]Change{
+		{{Op: ChangeInsert, Table: "foo", RowID: 1}},
+		{{Op: ChangeUpdate, Table: "foo", RowID: 1}},
+		{{Op: ChangeDelete, Table: "foo", RowID: 1}},
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong changes, exp %v, got %v", exp, got)
+	}
+
+	// Changes are not tracked once the function is unset.
+	db.SetChangeFunc(nil)
+	got = nil
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(3, "fiona")`)
+	if len(got) != 0 {
+		t.Fatalf("changes tracked after function unset: %v", got)
+	}
+}
+
+func Test_SwappableDBChangeFuncReset(t *testing.T) {
+	path := mustTempPath()
+	defer os.Remove(path)
+	sdb, err := OpenSwappable(path, false, true, nil)
+	if err != nil {
+		t.Fatalf("failed to open swappable database: %s", err.Error())
+	}
+	defer sdb.Close()
+
+	var got []Change
+	sdb.SetChangeFunc(func(changes []Change) {
+		got = append(got, changes...)
+	})
+
+	srcPath := mustTempPath()
+	defer os.Remove(srcPath)
+	src, err := Open(srcPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	mustExecute(src, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	if err := src.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+	if err := sdb.Swap(srcPath, false, true); err != nil {
+		t.Fatalf("failed to swap database: %s", err.Error())
+	}
+	if exp := []Change{{Op: ChangeReset}}; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong changes after swap, exp %v, got %v", exp, got)
+	}
+
+	// The function is still set on the new database.
+	if _, err := sdb.Execute(&command.Request{
+		Statements: []*command.Statement{{Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`}},
+	}, false); err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if exp := []Change{{Op: ChangeReset}, {Op: ChangeInsert, Table: "foo", RowID: 1}}; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong changes after insert, exp %v, got %v", exp, got)
+	}
+}
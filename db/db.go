@@ -13,18 +13,28 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rqlite/go-sqlite3"
+	sqlcmd "github.com/rqlite/rqlite/v8/command"
 	command "github.com/rqlite/rqlite/v8/command/proto"
 	"github.com/rqlite/rqlite/v8/db/humanize"
 )
 
 const (
 	SQLiteHeaderSize = 32
-	bkDelay          = 250
-	durToOpenLog     = 2 * time.Second
+
+	// SQLiteFileHeaderSize is the size of the complete SQLite file header.
+	SQLiteFileHeaderSize = 100
+
+	bkDelay      = 250
+	durToOpenLog = 2 * time.Second
+
+	// sqliteRecursive is SQLITE_RECURSIVE, which the driver does not export.
+	sqliteRecursive = 33
 )
 
 const (
@@ -54,6 +64,14 @@ var (
 
 	// ErrExecuteTimeout is returned when an execute times out.
 	ErrExecuteTimeout = errors.New("execute timeout")
+
+	// ErrNotAttempted is the error of each statement which was not run because
+	// an earlier statement in a request, which asked to stop on error, failed.
+	ErrNotAttempted = errors.New("not attempted, an earlier statement failed")
+
+	// ErrNoRowsAffected is the error of a statement which was required to
+	// change at least one row, but changed none.
+	ErrNoRowsAffected = errors.New("conflict, statement affected no rows")
 )
 
 // CheckpointMode is the mode in which a checkpoint runs.
@@ -66,6 +84,27 @@ const (
 	CheckpointTruncate
 )
 
+// String returns the name of the checkpoint mode.
+func (m CheckpointMode) String() string {
+	switch m {
+	case CheckpointRestart:
+		return "RESTART"
+	case CheckpointTruncate:
+		return "TRUNCATE"
+	}
+	return "UNKNOWN"
+}
+
+// CheckpointResult is the outcome of a WAL checkpoint.
+type CheckpointResult struct {
+	Time     time.Time `json:"time"`
+	Mode     string    `json:"mode"`
+	Pages    int       `json:"pages"`
+	Moved    int       `json:"moved"`
+	Duration string    `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+}
+
 var (
 	checkpointPRAGMAs = map[CheckpointMode]string{
 		CheckpointRestart:  "PRAGMA wal_checkpoint(RESTART)",
@@ -117,6 +156,13 @@ type DB struct {
 	rwDSN string // DSN used for read-write connection
 	roDSN string // DSN used for read-only connections
 
+	roPragmas map[string]string // PRAGMAs applied to each read-only connection
+
+	changes *changeTracker // Tracks changes made through the read-write connection
+
+	lastChkMu sync.Mutex
+	lastChk   *CheckpointResult // Outcome of the most recent checkpoint.
+
 	logger *log.Logger
 }
 
@@ -135,7 +181,14 @@ type PoolStats struct {
 
 // Open opens a file-based database, creating it if it does not exist. After this
 // function returns, an actual SQLite file will always exist.
-func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
+func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
+	return OpenWithReadPragmas(dbPath, fkEnabled, wal, nil)
+}
+
+// OpenWithReadPragmas opens a file-based database, as Open does, and applies
+// the given PRAGMAs to each read-only connection as it is opened. Each PRAGMA
+// must be one of ReadPragmas.
+func OpenWithReadPragmas(dbPath string, fkEnabled, wal bool, roPragmas map[string]string) (retDB *DB, retErr error) {
 	logger := log.New(log.Writer(), "[db] ", log.LstdFlags)
 	startTime := time.Now()
 	defer func() {
@@ -151,10 +204,13 @@ func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
 	/////////////////////////////////////////////////////////////////////////
 	// Main RW connection
 	rwDSN := MakeDSN(dbPath, ModeReadWrite, fkEnabled, wal)
-	rwDB, err := sql.Open("sqlite3", rwDSN)
+	rwConnector, err := newPragmaConnector(rwDSN, nil)
 	if err != nil {
 		return nil, fmt.Errorf("open: %s", err.Error())
 	}
+	changes := &changeTracker{}
+	rwConnector.onConnect = changes.register
+	rwDB := sql.OpenDB(rwConnector)
 
 	// Critical that rqlite has full control over the checkpointing process.
 	if _, err := rwDB.Exec("PRAGMA wal_autocheckpoint=0"); err != nil {
@@ -164,10 +220,11 @@ func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
 	/////////////////////////////////////////////////////////////////////////
 	// Read-only connection
 	roDSN := MakeDSN(dbPath, ModeReadOnly, fkEnabled, wal)
-	roDB, err := sql.Open("sqlite3", roDSN)
+	roConnector, err := newPragmaConnector(roDSN, roPragmas)
 	if err != nil {
 		return nil, err
 	}
+	roDB := sql.OpenDB(roConnector)
 
 	// Force creation of database file.
 	if err := rwDB.Ping(); err != nil {
@@ -189,6 +246,8 @@ func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
 		roDB:      roDB,
 		rwDSN:     rwDSN,
 		roDSN:     roDSN,
+		roPragmas: roPragmas,
+		changes:   changes,
 		logger:    logger,
 	}, nil
 }
@@ -268,6 +327,7 @@ func (db *DB) Stats() (map[string]interface{}, error) {
 		"db_size_friendly": humanize.Bytes(uint64(dbSz)),
 		"rw_dsn":           db.rwDSN,
 		"ro_dsn":           db.roDSN,
+		"ro_pragmas":       db.roPragmas,
 		"conn_pool_stats":  connPoolStats,
 		"pragmas":          pragmas,
 	}
@@ -277,6 +337,10 @@ func (db *DB) Stats() (map[string]interface{}, error) {
 		stats["last_modified"] = lm
 	}
 
+	if lc := db.LastCheckpoint(); lc != nil {
+		stats["last_checkpoint"] = lc
+	}
+
 	stats["path"] = db.path
 	if stats["size"], err = db.FileSize(); err != nil {
 		return nil, err
@@ -303,6 +367,41 @@ func (db *DB) Size() (int64, error) {
 	return rows[0].Values[0].Parameters[0].GetI(), nil
 }
 
+// FileStats holds the size of a database's files on disk, and the page
+// counts from which the size of the database file derives.
+type FileStats struct {
+	FileSize      int64 `json:"file_size"`
+	WALSize       int64 `json:"wal_size,omitempty"`
+	PageSize      int64 `json:"page_size"`
+	PageCount     int64 `json:"page_count"`
+	FreelistCount int64 `json:"freelist_count"`
+}
+
+// FileStats returns the size of the database's files on disk and its page
+// counts. The WAL size is only set if WAL mode is enabled.
+func (db *DB) FileStats() (*FileStats, error) {
+	rows, err := db.QueryStringStmt(`SELECT page_size, page_count, freelist_count FROM pragma_page_size(), pragma_page_count(), pragma_freelist_count()`)
+	if err != nil {
+		return nil, err
+	}
+	if rows[0].Error != "" {
+		return nil, fmt.Errorf(rows[0].Error)
+	}
+	vals := rows[0].Values[0].Parameters
+	fs := &FileStats{
+		PageSize:      vals[0].GetI(),
+		PageCount:     vals[1].GetI(),
+		FreelistCount: vals[2].GetI(),
+	}
+	if fs.FileSize, err = db.FileSize(); err != nil {
+		return nil, err
+	}
+	if fs.WALSize, err = db.WALSize(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
 // FileSize returns the size of the SQLite file on disk. If running in
 // on-memory mode, this function returns 0.
 func (db *DB) FileSize() (int64, error) {
@@ -365,6 +464,9 @@ func (db *DB) Checkpoint(mode CheckpointMode) error {
 // checkpoint.
 func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err error) {
 	start := time.Now()
+	var ok int
+	var nPages int
+	var nMoved int
 	defer func() {
 		if err != nil {
 			stats.Add(numCheckpointErrors, 1)
@@ -372,6 +474,19 @@ func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err
 			stats.Get(checkpointDuration).(*expvar.Int).Set(time.Since(start).Milliseconds())
 			stats.Add(numCheckpoints, 1)
 		}
+		res := &CheckpointResult{
+			Time:     start,
+			Mode:     mode.String(),
+			Pages:    nPages,
+			Moved:    nMoved,
+			Duration: time.Since(start).String(),
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		db.lastChkMu.Lock()
+		defer db.lastChkMu.Unlock()
+		db.lastChk = res
 	}()
 
 	if dur > 0 {
@@ -390,9 +505,6 @@ func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err
 		}()
 	}
 
-	var ok int
-	var nPages int
-	var nMoved int
 	if err := db.rwDB.QueryRow(checkpointPRAGMAs[mode]).Scan(&ok, &nPages, &nMoved); err != nil {
 		return fmt.Errorf("error checkpointing WAL: %s", err.Error())
 	}
@@ -405,6 +517,14 @@ func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err
 	return nil
 }
 
+// LastCheckpoint returns the outcome of the most recent checkpoint, or nil if
+// no checkpoint has been run.
+func (db *DB) LastCheckpoint() *CheckpointResult {
+	db.lastChkMu.Lock()
+	defer db.lastChkMu.Unlock()
+	return db.lastChk
+}
+
 // DisableCheckpointing disables the automatic checkpointing that occurs when
 // the WAL reaches a certain size. This is key for full control of snapshotting.
 // and can be useful for testing.
@@ -484,6 +604,23 @@ func (db *DB) WALEnabled() bool {
 	return db.wal
 }
 
+// SetChangeFunc sets the function called with the changes made by each
+// committed transaction. Changes are only tracked while a function is set,
+// so fn may be nil to stop tracking them.
+func (db *DB) SetChangeFunc(fn ChangeFunc) {
+	db.changes.setFunc(fn)
+}
+
+// ChangeFunc returns the function set by SetChangeFunc.
+func (db *DB) ChangeFunc() ChangeFunc {
+	return db.changes.getFunc()
+}
+
+// ReadPragmas returns the PRAGMAs applied to each read-only connection.
+func (db *DB) ReadPragmas() map[string]string {
+	return db.roPragmas
+}
+
 // Path returns the path of this database.
 func (db *DB) Path() string {
 	return db.path
@@ -566,7 +703,23 @@ func (db *DB) ExecuteStringStmt(query string) ([]*command.ExecuteResult, error)
 	return db.Execute(r, false)
 }
 
-// Execute executes queries that modify the database.
+// Execute executes queries that modify the database. A result is returned for
+// each statement which is not empty, in the order of the statements. How a
+// failing statement affects the others depends on the request:
+//
+//   - If the request is a transaction, the transaction is rolled back when a
+//     statement fails, so no statement has any effect. The results end with
+//     that of the failing statement, unless StopOnError is set, in which case
+//     each later statement has a result with the error ErrNotAttempted.
+//   - Otherwise each statement takes effect on its own. By default every
+//     statement is attempted, and each failure is reported only in the error
+//     of that statement's result. If StopOnError is set, execution stops at
+//     the first failing statement. Earlier statements keep their effect and
+//     their results, and each later statement has a result with the error
+//     ErrNotAttempted.
+//
+// A statement with RequireRows set which changes no rows fails with the
+// error ErrNoRowsAffected.
 func (db *DB) Execute(req *command.Request, xTime bool) ([]*command.ExecuteResult, error) {
 	stats.Add(numExecutions, int64(len(req.Statements)))
 	conn, err := db.rwDB.Conn(context.Background())
@@ -622,11 +775,11 @@ func (db *DB) executeWithConn(ctx context.Context, req *command.Request, xTime b
 			tx = nil
 			return false
 		}
-		return true
+		return !req.StopOnError
 	}
 
 	// Execute each statement.
-	for _, stmt := range req.Statements {
+	for i, stmt := range req.Statements {
 		ss := stmt.Sql
 		if ss == "" {
 			continue
@@ -637,6 +790,13 @@ func (db *DB) executeWithConn(ctx context.Context, req *command.Request, xTime b
 			if handleError(result, err) {
 				continue
 			}
+			if req.StopOnError {
+				for _, s := range req.Statements[i+1:] {
+					if s.Sql != "" {
+						allResults = append(allResults, &command.ExecuteResult{Error: ErrNotAttempted.Error()})
+					}
+				}
+			}
 			break
 		}
 		allResults = append(allResults, result)
@@ -698,6 +858,9 @@ func (db *DB) executeStmtWithConn(ctx context.Context, stmt *command.Statement,
 	if xTime {
 		result.Time = time.Since(start).Seconds()
 	}
+	if stmt.RequireRows && ra == 0 {
+		return &command.ExecuteResult{Error: ErrNoRowsAffected.Error()}, ErrNoRowsAffected
+	}
 	return result, nil
 }
 
@@ -730,6 +893,12 @@ func (db *DB) QueryStringStmtWithTimeout(query string, tx bool, timeout time.Dur
 
 // Query executes queries that return rows, but don't modify the database.
 func (db *DB) Query(req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	return db.QueryContext(context.Background(), req, xTime)
+}
+
+// QueryContext is like Query, but any statement still running when ctx is
+// done is interrupted, and fails with the context's error.
+func (db *DB) QueryContext(ctx context.Context, req *command.Request, xTime bool) ([]*command.QueryRows, error) {
 	stats.Add(numQueries, int64(len(req.Statements)))
 	conn, err := db.roDB.Conn(context.Background())
 	if err != nil {
@@ -737,7 +906,6 @@ func (db *DB) Query(req *command.Request, xTime bool) ([]*command.QueryRows, err
 	}
 	defer conn.Close()
 
-	ctx := context.Background()
 	if req.DbTimeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.DbTimeout))
@@ -746,6 +914,36 @@ func (db *DB) Query(req *command.Request, xTime bool) ([]*command.QueryRows, err
 	return db.queryWithConn(ctx, req, xTime, conn)
 }
 
+// splitStatements returns the given statements, with any statement holding
+// more than one SQL statement, such as "SELECT 1; SELECT 2", replaced by one
+// statement for each. Statements with parameters are not split, since which
+// of the SQL statements each parameter belongs to would be ambiguous.
+func splitStatements(stmts []*command.Statement) []*command.Statement {
+	var split []*command.Statement
+	for i, stmt := range stmts {
+		var sqls []string
+		if len(stmt.Parameters) == 0 && strings.Contains(stmt.Sql, ";") {
+			sqls = sqlcmd.Split(stmt.Sql)
+		}
+		if len(sqls) < 2 {
+			if split != nil {
+				split = append(split, stmt)
+			}
+			continue
+		}
+		if split == nil {
+			split = append(make([]*command.Statement, 0, len(stmts)+len(sqls)), stmts[:i]...)
+		}
+		for _, sql := range sqls {
+			split = append(split, &command.Statement{Sql: sql})
+		}
+	}
+	if split == nil {
+		return stmts
+	}
+	return split
+}
+
 type queryer interface {
 	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
@@ -768,7 +966,7 @@ func (db *DB) queryWithConn(ctx context.Context, req *command.Request, xTime boo
 	}
 
 	var allRows []*command.QueryRows
-	for _, stmt := range req.Statements {
+	for _, stmt := range splitStatements(req.Statements) {
 		sql := stmt.Sql
 		if sql == "" {
 			continue
@@ -830,22 +1028,85 @@ func (db *DB) queryStmtWithConn(ctx context.Context, stmt *command.Statement, xT
 		return rows, nil
 	}
 
-	rs, err := q.QueryContext(ctx, stmt.Sql, parameters...)
+	columns, xTypes, err := iterateRows(ctx, stmt.Sql, parameters, q, func(columns, types []string, values *command.Values) error {
+		rows.Values = append(rows.Values, values)
+		return nil
+	})
 	if err != nil {
-		stats.Add(numQueryErrors, 1)
 		rows.Error = err.Error()
 		return rows, err
 	}
+
+	if xTime {
+		rows.Time = time.Since(start).Seconds()
+	}
+
+	rows.Columns = columns
+	rows.Types = xTypes
+	return rows, nil
+}
+
+// RowFunc is called for each row read by a query, along with the columns and
+// types of the result. Returning an error stops the query.
+type RowFunc func(columns, types []string, values *command.Values) error
+
+// QueryStream executes a single statement that returns rows, calling fn for
+// each row as it is read from the database. Unlike Query, the full result is
+// never held in memory. A timeout of 0 means no timeout.
+func (db *DB) QueryStream(stmt *command.Statement, timeout time.Duration, fn RowFunc) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numQueryErrors, 1)
+			retErr = rewriteContextTimeout(retErr, ErrQueryTimeout)
+		}
+	}()
+	stats.Add(numQueries, 1)
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	readOnly, err := db.StmtReadOnlyWithConn(stmt.Sql, conn)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return errors.New("attempt to change database via query operation")
+	}
+	parameters, err := parametersToValues(stmt.Parameters)
+	if err != nil {
+		return err
+	}
+	_, _, err = iterateRows(ctx, stmt.Sql, parameters, conn, fn)
+	return err
+}
+
+// iterateRows runs the given query, calling fn for each row returned. It
+// returns the columns and types of the result.
+func iterateRows(ctx context.Context, query string, parameters []interface{}, q queryer, fn RowFunc) ([]string, []string, error) {
+	rs, err := q.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		stats.Add(numQueryErrors, 1)
+		return nil, nil, err
+	}
 	defer rs.Close()
 
 	columns, err := rs.Columns()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	types, err := rs.ColumnTypes()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	xTypes := make([]string, len(types))
 	for i := range types {
@@ -860,15 +1121,12 @@ func (db *DB) queryStmtWithConn(ctx context.Context, stmt *command.Statement, xT
 			ptrs[i] = &dest[i]
 		}
 		if err := rs.Scan(ptrs...); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		params, err := normalizeRowValues(dest, xTypes)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		rows.Values = append(rows.Values, &command.Values{
-			Parameters: params,
-		})
 
 		// One-time population of any empty types. Best effort, ignore
 		// error.
@@ -876,21 +1134,18 @@ func (db *DB) queryStmtWithConn(ctx context.Context, stmt *command.Statement, xT
 			populateEmptyTypes(xTypes, params)
 			needsQueryTypes = false
 		}
+
+		if err := fn(columns, xTypes, &command.Values{Parameters: params}); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// Check for errors from iterating over rows.
 	if err := rs.Err(); err != nil {
 		stats.Add(numQueryErrors, 1)
-		return rows, err
-	}
-
-	if xTime {
-		rows.Time = time.Since(start).Seconds()
+		return nil, nil, err
 	}
-
-	rows.Columns = columns
-	rows.Types = xTypes
-	return rows, nil
+	return columns, xTypes, nil
 }
 
 // RequestStringStmts processes a request that can contain both executes and queries.
@@ -916,7 +1171,8 @@ func (db *DB) RequestStringStmtsWithTimeout(stmts []string, timeout time.Duratio
 	return db.Request(req, false)
 }
 
-// Request processes a request that can contain both executes and queries.
+// Request processes a request that can contain both executes and queries. A
+// failing statement affects the others in the same way as for Execute.
 func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
 	stats.Add(numRequests, int64(len(req.Statements)))
 	conn, err := db.rwDB.Conn(context.Background())
@@ -957,11 +1213,11 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 			tx = nil
 			return true
 		}
-		return false
+		return err != nil && req.StopOnError
 	}
 
 	var eqResponse []*command.ExecuteQueryResponse
-	for _, stmt := range req.Statements {
+	for i, stmt := range req.Statements {
 		ss := stmt.Sql
 		if ss == "" {
 			continue
@@ -974,21 +1230,28 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 					Error: err.Error(),
 				},
 			})
+			if req.StopOnError {
+				eqResponse = append(eqResponse, notAttemptedResponses(req.Statements[i+1:])...)
+				break
+			}
 			continue
 		}
 
+		var opErr error
 		if ro {
-			rows, opErr := db.queryStmtWithConn(ctx, stmt, xTime, queryer, time.Duration(req.DbTimeout))
+			var rows *command.QueryRows
+			rows, opErr = db.queryStmtWithConn(ctx, stmt, xTime, queryer, time.Duration(req.DbTimeout))
 			eqResponse = append(eqResponse, createEQQueryResponse(rows, opErr))
-			if abortOnError(opErr) {
-				break
-			}
 		} else {
-			result, opErr := db.executeStmtWithConn(ctx, stmt, xTime, execer, time.Duration(req.DbTimeout))
+			var result *command.ExecuteResult
+			result, opErr = db.executeStmtWithConn(ctx, stmt, xTime, execer, time.Duration(req.DbTimeout))
 			eqResponse = append(eqResponse, createEQExecuteResponse(result, opErr))
-			if abortOnError(opErr) {
-				break
+		}
+		if abortOnError(opErr) {
+			if req.StopOnError {
+				eqResponse = append(eqResponse, notAttemptedResponses(req.Statements[i+1:])...)
 			}
+			break
 		}
 	}
 
@@ -1172,6 +1435,58 @@ func (db *DB) Dump(w io.Writer) error {
 	return nil
 }
 
+// Validate checks, without executing them, that each statement of the
+// request can be prepared against the database, and is given the parameters
+// it requires. A result is returned for each statement, holding an error if
+// the statement is invalid. Each statement is prepared against the database
+// as it is, so one which depends on an earlier statement of the request, such
+// as an INSERT into a table created by the request, is reported as invalid.
+func (db *DB) Validate(req *command.Request) ([]*command.ExecuteResult, error) {
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	results := make([]*command.ExecuteResult, 0, len(req.Statements))
+	f := func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		for _, stmt := range req.Statements {
+			res := &command.ExecuteResult{}
+			if err := validateStmt(c, stmt); err != nil {
+				res.Error = err.Error()
+			}
+			results = append(results, res)
+		}
+		return nil
+	}
+	if err := conn.Raw(f); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// validateStmt prepares, but does not step, each SQL statement held by the
+// given statement.
+func validateStmt(c *sqlite3.SQLiteConn, stmt *command.Statement) error {
+	sqls := []string{stmt.Sql}
+	if len(stmt.Parameters) == 0 {
+		sqls = sqlcmd.Split(stmt.Sql)
+	}
+	for _, sql := range sqls {
+		drvStmt, err := c.Prepare(sql)
+		if err != nil {
+			return err
+		}
+		n := drvStmt.NumInput()
+		drvStmt.Close()
+		if n > len(stmt.Parameters) {
+			return fmt.Errorf("not enough args to execute query: want %d got %d", n, len(stmt.Parameters))
+		}
+	}
+	return nil
+}
+
 // StmtReadOnly returns whether the given SQL statement is read-only.
 // As per https://www.sqlite.org/c3ref/stmt_readonly.html, this function
 // may not return 100% correct results, but should cover most scenarios.
@@ -1206,6 +1521,134 @@ func (db *DB) StmtReadOnlyWithConn(sql string, conn *sql.Conn) (bool, error) {
 	return readOnly, nil
 }
 
+// StmtTables returns, in lower case and sorted, the tables the given SQL
+// statement accesses, as reported by SQLite while preparing it against the
+// database. Tables reached only through views and triggers are included, as
+// are the views themselves. A table in an attached database is returned
+// qualified by the name of that database. An error is returned if the
+// statement cannot be prepared, or if it does something, such as run a
+// PRAGMA, whose tables SQLite does not report.
+func (db *DB) StmtTables(sql string) ([]string, error) {
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var tables []string
+	f := func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		at := newAuthTables()
+		c.RegisterAuthorizer(at.authorize)
+		defer c.RegisterAuthorizer(nil)
+		drvStmt, err := c.Prepare(sql)
+		if err != nil {
+			return err
+		}
+		drvStmt.Close()
+		tables, err = at.Tables()
+		return err
+	}
+	if err := conn.Raw(f); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// authTables collects the tables reported to a SQLite authorizer.
+type authTables struct {
+	tables    map[string]bool
+	schema    map[string]bool // Accesses of the schema tables.
+	ddl       bool
+	reindex   bool
+	unhandled int
+}
+
+func newAuthTables() *authTables {
+	return &authTables{
+		tables: make(map[string]bool),
+		schema: make(map[string]bool),
+	}
+}
+
+// authorize is the authorizer callback. It allows every action.
+func (a *authTables) authorize(op int, arg1, arg2, dbName string) int {
+	switch op {
+	case sqlite3.SQLITE_READ, sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		a.add(arg1, dbName)
+	case sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TEMP_TABLE,
+		sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TEMP_TABLE,
+		sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_TEMP_VIEW,
+		sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_TEMP_VIEW:
+		a.ddl = true
+		a.add(arg1, dbName)
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TEMP_INDEX,
+		sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TEMP_INDEX,
+		sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_TEMP_TRIGGER,
+		sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_TEMP_TRIGGER:
+		a.ddl = true
+		a.add(arg2, dbName)
+	case sqlite3.SQLITE_ALTER_TABLE:
+		// The database name is passed first for this action.
+		a.ddl = true
+		a.add(arg2, arg1)
+	case sqlite3.SQLITE_REINDEX:
+		// Creating an index also reports it, naming only the index.
+		a.reindex = true
+	case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_FUNCTION, sqliteRecursive:
+	default:
+		a.unhandled = op
+	}
+	return sqlite3.SQLITE_OK
+}
+
+func (a *authTables) add(table, dbName string) {
+	if table == "" {
+		return
+	}
+	name := strings.ToLower(table)
+	if dbName != "" && dbName != "main" && dbName != "temp" {
+		name = strings.ToLower(dbName) + "." + name
+	}
+	if isSchemaTable(name) {
+		a.schema[name] = true
+		return
+	}
+	a.tables[name] = true
+}
+
+// Tables returns the tables collected. Changes to the schema access the
+// schema tables, so those are only returned for statements which do not.
+func (a *authTables) Tables() ([]string, error) {
+	if a.unhandled != 0 {
+		return nil, fmt.Errorf("tables of action %d not known", a.unhandled)
+	}
+	if a.reindex && !a.ddl {
+		return nil, fmt.Errorf("tables of action %d not known", sqlite3.SQLITE_REINDEX)
+	}
+	if !a.ddl {
+		for t := range a.schema {
+			a.tables[t] = true
+		}
+	}
+	tables := make([]string, 0, len(a.tables))
+	for t := range a.tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// isSchemaTable returns whether the table is one which SQLite maintains
+// itself.
+func isSchemaTable(name string) bool {
+	switch name {
+	case "sqlite_master", "sqlite_schema", "sqlite_temp_master", "sqlite_temp_schema", "sqlite_sequence":
+		return true
+	}
+	return false
+}
+
 func (db *DB) pragmas() (map[string]interface{}, error) {
 	conns := map[string]*sql.DB{
 		"rw": db.rwDB,
@@ -1274,6 +1717,23 @@ func createEQQueryResponse(rows *command.QueryRows, err error) *command.ExecuteQ
 	}
 }
 
+// notAttemptedResponses returns a response, with the error ErrNotAttempted,
+// for each of the given statements which is not empty.
+func notAttemptedResponses(stmts []*command.Statement) []*command.ExecuteQueryResponse {
+	var resps []*command.ExecuteQueryResponse
+	for _, s := range stmts {
+		if s.Sql == "" {
+			continue
+		}
+		resps = append(resps, &command.ExecuteQueryResponse{
+			Result: &command.ExecuteQueryResponse_Error{
+				Error: ErrNotAttempted.Error(),
+			},
+		})
+	}
+	return resps
+}
+
 func createEQExecuteResponse(execResult *command.ExecuteResult, err error) *command.ExecuteQueryResponse {
 	if err != nil {
 		return &command.ExecuteQueryResponse{
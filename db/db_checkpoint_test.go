@@ -282,3 +282,54 @@ func mustGetWALHeader(path string) []byte {
 	}
 	return hdr
 }
+
+// Test_WALDatabaseCheckpoint_LastCheckpoint tests that the outcome of the
+// most recent checkpoint is recorded and reported in the stats.
+func Test_WALDatabaseCheckpoint_LastCheckpoint(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+	if db.LastCheckpoint() != nil {
+		t.Fatalf("last checkpoint set before any checkpoint")
+	}
+
+	if _, err := db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if err := db.Checkpoint(CheckpointRestart); err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	lc := db.LastCheckpoint()
+	if lc == nil {
+		t.Fatalf("last checkpoint not set after checkpoint")
+	}
+	if exp, got := "RESTART", lc.Mode; exp != got {
+		t.Fatalf("wrong checkpoint mode, exp %s, got %s", exp, got)
+	}
+	if lc.Error != "" {
+		t.Fatalf("unexpected checkpoint error: %s", lc.Error)
+	}
+	if lc.Pages == 0 || lc.Pages != lc.Moved {
+		t.Fatalf("unexpected checkpoint page counts, pages %d, moved %d", lc.Pages, lc.Moved)
+	}
+
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if exp, got := "TRUNCATE", db.LastCheckpoint().Mode; exp != got {
+		t.Fatalf("wrong checkpoint mode, exp %s, got %s", exp, got)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if _, ok := stats["last_checkpoint"]; !ok {
+		t.Fatalf("last checkpoint not reported in stats")
+	}
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -1203,6 +1205,163 @@ func testPartialFail(t *testing.T, db *DB) {
 	}
 }
 
+func testPartialFailStopOnError(t *testing.T, db *DB) {
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(4, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(5, "fiona")`,
+			},
+		},
+		StopOnError: true,
+	}
+	r, err := db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":1,"rows_affected":1},{"last_insert_id":2,"rows_affected":1},{"error":"UNIQUE constraint failed: foo.id"},{"error":"not attempted, an earlier statement failed"},{"error":"not attempted, an earlier statement failed"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+	ro, err := db.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"fiona"]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// In a transaction nothing takes effect, but the later statements are
+	// still reported as not attempted.
+	req.Statements[0].Sql = `INSERT INTO foo(id, name) VALUES(6, "fiona")`
+	req.Transaction = true
+	r, err = db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":6,"rows_affected":1},{"error":"UNIQUE constraint failed: foo.id"},{"error":"not attempted, an earlier statement failed"},{"error":"not attempted, an earlier statement failed"},{"error":"not attempted, an earlier statement failed"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+	ro, err = db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A request stops in the same way.
+	rr, err := db.Request(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(7, "fiona")`,
+			},
+			{
+				Sql: `SELECT * FROM bar`,
+			},
+			{
+				Sql: `SELECT COUNT(*) FROM foo`,
+			},
+		},
+		StopOnError: true,
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":7,"rows_affected":1},{"error":"no such table: bar"},{"error":"not attempted, an earlier statement failed"}]`, asJSON(rr); exp != got {
+		t.Fatalf("unexpected results for request\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func testRequireRows(t *testing.T, db *DB) {
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, version INTEGER)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	_, err = db.ExecuteStringStmt(`INSERT INTO foo(id, name, version) VALUES(1, "fiona", 1)`)
+	if err != nil {
+		t.Fatalf("failed to insert record: %s", err.Error())
+	}
+
+	update := func(version int) []*command.ExecuteResult {
+		r, err := db.Execute(&command.Request{
+			Transaction: true,
+			Statements: []*command.Statement{
+				{
+					Sql:         `INSERT INTO foo(id, name, version) VALUES(2, "declan", 1)`,
+					RequireRows: true,
+				},
+				{
+					Sql:         fmt.Sprintf(`UPDATE foo SET name="fiona2", version=version+1 WHERE id=1 AND version=%d`, version),
+					RequireRows: true,
+				},
+			},
+		}, false)
+		if err != nil {
+			t.Fatalf("failed to update record: %s", err.Error())
+		}
+		return r
+	}
+
+	// A stale version changes no rows, so the transaction is rolled back.
+	r := update(2)
+	if exp, got := `[{"last_insert_id":2,"rows_affected":1},{"error":"conflict, statement affected no rows"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+	ro, err := db.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name","version"],"types":["integer","text","integer"],"values":[[1,"fiona",1]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	r = update(1)
+	if exp, got := `[{"last_insert_id":2,"rows_affected":1},{"last_insert_id":2,"rows_affected":1}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Statements which do not require rows may change none.
+	r, err = db.ExecuteStringStmt(`UPDATE foo SET name="fiona3" WHERE id=3`)
+	if err != nil {
+		t.Fatalf("failed to update record: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":2}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A request fails in the same way.
+	rr, err := db.Request(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql:         `DELETE FROM foo WHERE id=1 AND version=1`,
+				RequireRows: true,
+			},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if exp, got := `[{"error":"conflict, statement affected no rows"}]`, asJSON(rr); exp != got {
+		t.Fatalf("unexpected results for request\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func testSerialize(t *testing.T, db *DB) {
 	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
 	if err != nil {
@@ -1313,12 +1472,94 @@ func testDBFileSize(t *testing.T, db *DB) {
 		t.Fatalf("failed to read database file size: %s", err)
 	}
 }
+func testDBFileStats(t *testing.T, db *DB) {
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	for i := 0; i < 100; i++ {
+		mustExecute(db, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 1000)))
+	}
+	mustExecute(db, `DROP TABLE foo`)
+
+	fs, err := db.FileStats()
+	if err != nil {
+		t.Fatalf("failed to get file stats: %s", err)
+	}
+	sz, err := db.Size()
+	if err != nil {
+		t.Fatalf("failed to get size: %s", err)
+	}
+	if got := fs.PageSize * fs.PageCount; got != sz {
+		t.Fatalf("page size times page count is %d, exp %d", got, sz)
+	}
+	if fs.FreelistCount == 0 {
+		t.Fatalf("expected free pages after dropping table")
+	}
+	if fs.FreelistCount > fs.PageCount {
+		t.Fatalf("more free pages (%d) than pages (%d)", fs.FreelistCount, fs.PageCount)
+	}
+	if !db.WALEnabled() && fs.WALSize != 0 {
+		t.Fatalf("WAL size %d reported with WAL disabled", fs.WALSize)
+	}
+}
+
 func testDBWALSize(t *testing.T, db *DB) {
 	if _, err := db.WALSize(); err != nil {
 		t.Fatalf("failed to read database WAL file size: %s", err)
 	}
 }
 
+func testStmtTables(t *testing.T, db *DB) {
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)`,
+		`CREATE VIEW v AS SELECT * FROM bar`,
+		`CREATE INDEX foo_name ON foo(name)`,
+	} {
+		if _, err := db.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+	}
+
+	for _, tt := range []struct {
+		sql string
+		exp []string
+	}{
+		{"SELECT * FROM foo", []string{"foo"}},
+		{"SELECT * FROM main.Foo", []string{"foo"}},
+		{"SELECT COUNT(*) FROM foo", []string{"foo"}},
+		{"SELECT * FROM foo WHERE id IN (SELECT id FROM bar)", []string{"bar", "foo"}},
+		{"SELECT (SELECT COUNT(*) FROM main.bar) FROM foo", []string{"bar", "foo"}},
+		{"SELECT * FROM v", []string{"bar", "v"}},
+		{"WITH c AS (SELECT * FROM bar) SELECT * FROM c", []string{"bar"}},
+		{"SELECT * FROM sqlite_master", []string{"sqlite_master"}},
+		{"INSERT INTO main.foo(name) SELECT id FROM bar", []string{"bar", "foo"}},
+		{"DELETE FROM foo WHERE id IN (SELECT id FROM bar)", []string{"bar", "foo"}},
+		{"CREATE TABLE qux (id INTEGER PRIMARY KEY)", []string{"qux"}},
+		{"CREATE INDEX idx ON foo(id)", []string{"foo"}},
+		{"ALTER TABLE foo ADD COLUMN age INTEGER", []string{"foo"}},
+		{"DROP TABLE bar", []string{"bar"}},
+		{"SELECT 1", []string{}},
+	} {
+		tables, err := db.StmtTables(tt.sql)
+		if err != nil {
+			t.Fatalf("failed to get tables of %s: %s", tt.sql, err.Error())
+		}
+		if !reflect.DeepEqual(tables, tt.exp) {
+			t.Fatalf("wrong tables for %s, exp %v, got %v", tt.sql, tt.exp, tables)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM non_existent_table",
+		"INVALID SQL STATEMENT",
+		"PRAGMA table_info(foo)",
+		"REINDEX foo",
+	} {
+		if _, err := db.StmtTables(sql); err == nil {
+			t.Fatalf("expected error getting tables of %s", sql)
+		}
+	}
+}
+
 func testStmtReadOnly(t *testing.T, db *DB) {
 	r, err := db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
 	if err != nil {
@@ -1637,12 +1878,16 @@ func Test_DatabaseCommonOperations(t *testing.T) {
 		{"CommonTableExpressions", testCommonTableExpressions},
 		{"UniqueConstraints", testUniqueConstraints},
 		{"PartialFail", testPartialFail},
+		{"PartialFailStopOnError", testPartialFailStopOnError},
+		{"RequireRows", testRequireRows},
 		{"Serialize", testSerialize},
 		{"Dump", testDump},
 		{"Size", testSize},
 		{"DBFileSize", testDBFileSize},
 		{"DBWALSize", testDBWALSize},
+		{"DBFileStats", testDBFileStats},
 		{"StmtReadOnly", testStmtReadOnly},
+		{"StmtTables", testStmtTables},
 		{"JSON1", testJSON1},
 		{"DBSTAT_table", testDBSTAT_table},
 		{"Copy", testCopy},
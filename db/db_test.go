@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -550,6 +552,52 @@ func Test_SimpleTransaction(t *testing.T) {
 	}
 }
 
+func Test_TransactionLastInsertID(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	// Each statement's result must reflect that statement alone, even though
+	// all are executed on the same connection within one transaction.
+	req := &command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(name) VALUES("fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(name) VALUES("declan")`,
+			},
+			{
+				Sql: `INSERT INTO foo(name) VALUES("aoife")`,
+			},
+			{
+				Sql: `INSERT INTO foo(name) VALUES("fionn"), ("ciara")`,
+			},
+		},
+	}
+	r, err := db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if len(r) != 4 {
+		t.Fatalf("wrong number of results, exp 4, got %d", len(r))
+	}
+	for i := 1; i < 3; i++ {
+		if r[i].LastInsertId <= r[i-1].LastInsertId {
+			t.Fatalf("last insert IDs not increasing: %s", asJSON(r))
+		}
+	}
+	if exp, got := `[{"last_insert_id":1,"rows_affected":1},{"last_insert_id":2,"rows_affected":1},{"last_insert_id":3,"rows_affected":1},{"last_insert_id":5,"rows_affected":2}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_PartialFailTransaction(t *testing.T) {
 	db, path := mustCreateOnDiskDatabaseWAL()
 	defer db.Close()
@@ -1106,6 +1154,139 @@ func Test_QueryShouldTimeout(t *testing.T) {
 	}
 }
 
+func Test_QueryMultiStatement(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+
+	r, err := db.QueryStringStmt(`SELECT 1; -- first
+		SELECT name FROM foo WHERE name != ';';
+		SELECT 2`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["1"],"types":["integer"],"values":[[1]]},{"columns":["name"],"types":["text"],"values":[["fiona"]]},{"columns":["2"],"types":["integer"],"values":[[2]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A write embedded in a query is rejected, without affecting the others.
+	r, err = db.QueryStringStmt(`SELECT COUNT(*) FROM foo; DELETE FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]},{"error":"attempt to change database via query operation"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Statements with parameters are not split.
+	r, err = db.Query(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: `SELECT name FROM foo WHERE id = ?`,
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_I{I: 1}},
+				},
+			},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_QueryContextInterrupt(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT count(*) FROM c`,
+			},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if len(rows) != 1 || rows[0].Error == "" {
+		t.Fatalf("expected interrupted query to fail, got %s", asJSON(rows))
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("query not interrupted promptly, took %s", d)
+	}
+}
+
+func Test_QueryStream(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if _, err := db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.ExecuteStringStmt(fmt.Sprintf(`INSERT INTO foo(name) VALUES("fiona%d")`, i)); err != nil {
+			t.Fatalf("failed to insert record: %s", err.Error())
+		}
+	}
+
+	var got []string
+	err := db.QueryStream(&command.Statement{
+		Sql: "SELECT * FROM foo WHERE id > ?",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_I{I: 2}},
+		},
+	}, 0, func(columns, types []string, values *command.Values) error {
+		if exp, got := `["id","name"]`, asJSON(columns); exp != got {
+			t.Fatalf("wrong columns, exp %s, got %s", exp, got)
+		}
+		if exp, got := `["integer","text"]`, asJSON(types); exp != got {
+			t.Fatalf("wrong types, exp %s, got %s", exp, got)
+		}
+		got = append(got, values.Parameters[1].GetS())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream query: %s", err.Error())
+	}
+	if exp := `["fiona2","fiona3","fiona4"]`; exp != asJSON(got) {
+		t.Fatalf("wrong rows, exp %s, got %s", exp, asJSON(got))
+	}
+
+	// An error returned by the callback stops the query.
+	n := 0
+	errStop := errors.New("stop")
+	err = db.QueryStream(&command.Statement{Sql: "SELECT * FROM foo"}, 0, func(columns, types []string, values *command.Values) error {
+		n++
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected query to stop after 1 row, got %d", n)
+	}
+
+	for _, sql := range []string{"SELECT * FROM bar", `INSERT INTO foo(name) VALUES("declan")`} {
+		err = db.QueryStream(&command.Statement{Sql: sql}, 0, func(columns, types []string, values *command.Values) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expected error streaming %s", sql)
+		}
+	}
+}
+
 func Test_RequestShouldTimeout(t *testing.T) {
 	db, path := mustSetupDBForTimeoutTests(t, 5000)
 	defer db.Close()
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// ReadPragmas are the PRAGMAs which may be applied to read-only connections.
+// Each is local to the connection on which it is set, does not change the
+// database file, and does not change the results of a query. PRAGMAs such
+// as foreign_keys or journal_mode are not included, since they must be the
+// same on every node, and are set by rqlite itself.
+var ReadPragmas = map[string]bool{
+	"automatic_index": true,
+	"busy_timeout":    true,
+	"cache_size":      true,
+	"cache_spill":     true,
+	"mmap_size":       true,
+	"temp_store":      true,
+	"threads":         true,
+}
+
+var pragmaValueRe = regexp.MustCompile(`^(-?[0-9]+|[A-Za-z_]+)$`)
+
+// ParseReadPragmas parses a comma-separated list of name=value pairs into a
+// map of PRAGMAs which may be passed to OpenWithReadPragmas. An error is
+// returned if a PRAGMA is not one of ReadPragmas, or its value is not an
+// integer or a keyword.
+func ParseReadPragmas(s string) (map[string]string, error) {
+	pragmas := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return pragmas, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid PRAGMA %q, must be name=value", p)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if err := checkReadPragma(name, value); err != nil {
+			return nil, err
+		}
+		pragmas[name] = value
+	}
+	return pragmas, nil
+}
+
+func checkReadPragma(name, value string) error {
+	if !ReadPragmas[name] {
+		return fmt.Errorf("PRAGMA %s may not be set on read-only connections", name)
+	}
+	if !pragmaValueRe.MatchString(value) {
+		return fmt.Errorf("invalid value %q for PRAGMA %s", value, name)
+	}
+	return nil
+}
+
+// pragmaConnector is a driver.Connector which applies PRAGMAs to each
+// connection it opens.
+type pragmaConnector struct {
+	dsn     string
+	drv     *sqlite3.SQLiteDriver
+	pragmas []string // Statements to execute, in order.
+
+	// onConnect, if set, is called with each connection once it is open.
+	onConnect func(c *sqlite3.SQLiteConn)
+}
+
+// newPragmaConnector returns a pragmaConnector for the given DSN. The PRAGMAs
+// are applied in name order, so every connection is set up the same way.
+func newPragmaConnector(dsn string, pragmas map[string]string) (*pragmaConnector, error) {
+	names := make([]string, 0, len(pragmas))
+	for name, value := range pragmas {
+		if err := checkReadPragma(name, value); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stmts := make([]string, len(names))
+	for i, name := range names {
+		stmts[i] = fmt.Sprintf("PRAGMA %s=%s", name, pragmas[name])
+	}
+	return &pragmaConnector{
+		dsn:     dsn,
+		drv:     &sqlite3.SQLiteDriver{},
+		pragmas: stmts,
+	}, nil
+}
+
+// Connect implements the driver.Connector interface.
+func (c *pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqliteConn := conn.(*sqlite3.SQLiteConn)
+	for _, p := range c.pragmas {
+		if _, err := sqliteConn.Exec(p, nil); err != nil {
+			sqliteConn.Close()
+			return nil, fmt.Errorf("%s: %s", p, err.Error())
+		}
+	}
+	if c.onConnect != nil {
+		c.onConnect(sqliteConn)
+	}
+	return sqliteConn, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (c *pragmaConnector) Driver() driver.Driver {
+	return c.drv
+}
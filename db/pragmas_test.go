@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_ParseReadPragmas(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		exp map[string]string
+		err bool
+	}{
+		{in: "", exp: map[string]string{}},
+		{in: "cache_size=-8000", exp: map[string]string{"cache_size": "-8000"}},
+		{in: " Busy_Timeout = 5000 , temp_store=MEMORY", exp: map[string]string{"busy_timeout": "5000", "temp_store": "MEMORY"}},
+		{in: "cache_size", err: true},
+		{in: "foreign_keys=1", err: true},
+		{in: "journal_mode=DELETE", err: true},
+		{in: "cache_size=1; DROP TABLE foo", err: true},
+	} {
+		got, err := ParseReadPragmas(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", tt.in, err.Error())
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Fatalf("wrong PRAGMAs for %q, exp %v, got %v", tt.in, tt.exp, got)
+		}
+	}
+}
+
+func Test_OpenWithReadPragmas(t *testing.T) {
+	path := mustTempPath()
+	defer os.Remove(path)
+	pragmas := map[string]string{"cache_size": "-1234", "busy_timeout": "4321"}
+	db, err := OpenWithReadPragmas(path, false, true, pragmas)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+
+	// Open several read-only connections, and check each has the PRAGMAs set.
+	conns := make([]*sql.Conn, 0, 3)
+	for i := 0; i < 3; i++ {
+		conn, err := db.roDB.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get read-only connection: %s", err.Error())
+		}
+		conns = append(conns, conn)
+		for name, exp := range pragmas {
+			var got string
+			if err := conn.QueryRowContext(context.Background(), "PRAGMA "+name).Scan(&got); err != nil {
+				t.Fatalf("failed to query PRAGMA %s: %s", name, err.Error())
+			}
+			if got != exp {
+				t.Fatalf("wrong value for PRAGMA %s, exp %s, got %s", name, exp, got)
+			}
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+
+	// The read-write connection is unchanged.
+	var got string
+	if err := db.rwDB.QueryRow("PRAGMA cache_size").Scan(&got); err != nil {
+		t.Fatalf("failed to query PRAGMA cache_size: %s", err.Error())
+	}
+	if got == "-1234" {
+		t.Fatalf("PRAGMA applied to read-write connection")
+	}
+
+	if !reflect.DeepEqual(pragmas, db.ReadPragmas()) {
+		t.Fatalf("wrong read PRAGMAs, exp %v, got %v", pragmas, db.ReadPragmas())
+	}
+
+	if _, err := OpenWithReadPragmas(mustTempPath(), false, true, map[string]string{"journal_mode": "DELETE"}); err == nil {
+		t.Fatalf("expected error opening with disallowed PRAGMA")
+	}
+}
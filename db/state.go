@@ -3,11 +3,17 @@ package db
 import (
 	"bytes"
 	"database/sql"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+
+	"github.com/rqlite/go-sqlite3"
+	command "github.com/rqlite/rqlite/v8/command/proto"
 )
 
 const (
@@ -60,6 +66,27 @@ func IsValidSQLiteData(b []byte) bool {
 	return len(b) > 13 && string(b[0:13]) == "SQLite format"
 }
 
+// SQLiteDataSize returns the size of a SQLite database as recorded in its
+// header, the first SQLiteFileHeaderSize bytes of which must be in b. ok is
+// false if b does not hold the header, or the size recorded is not reliable.
+// See https://www.sqlite.org/fileformat2.html#in_header_database_size.
+func SQLiteDataSize(b []byte) (size int64, ok bool) {
+	if len(b) < SQLiteFileHeaderSize || !IsValidSQLiteData(b) {
+		return 0, false
+	}
+	pageSize := int64(binary.BigEndian.Uint16(b[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	nPages := int64(binary.BigEndian.Uint32(b[28:32]))
+	changeCounter := binary.BigEndian.Uint32(b[24:28])
+	validFor := binary.BigEndian.Uint32(b[92:96])
+	if nPages == 0 || changeCounter != validFor {
+		return 0, false
+	}
+	return pageSize * nPages, true
+}
+
 // IsValidSQLiteWALFile checks that the supplied path looks like a SQLite
 // WAL file. See https://www.sqlite.org/fileformat2.html#walformat. A
 // non-existent file is considered invalid.
@@ -195,6 +222,129 @@ func CheckIntegrity(path string, full bool) (bool, error) {
 	return result == "ok", nil
 }
 
+// CheckSQL checks that the given SQL text, such as that generated by a SQL
+// format backup, executes without error. The SQL is executed against a
+// temporary database, which is removed before this function returns.
+func CheckSQL(stmts string) error {
+	dir, err := os.MkdirTemp("", "rqlite-check-sql-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "check.db"), false, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := db.ExecuteStringStmt(stmts)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	return nil
+}
+
+// SchemaObject is an object, such as a table or index, in a database schema.
+type SchemaObject struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	TblName string `json:"tbl_name"`
+	SQL     string `json:"sql"`
+}
+
+// PreviewSchema executes stmts against a private in-memory database created
+// from the given schema, which holds no data, and returns the result of each
+// statement along with the schema which results. The statements may not
+// attach other databases, so they cannot read or write any file.
+func PreviewSchema(schema []string, req *command.Request) ([]*command.ExecuteResult, []SchemaObject, error) {
+	db, err := openPreview()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	for _, stmt := range schema {
+		results, err := db.ExecuteStringStmt(stmt)
+		if err != nil {
+			return nil, nil, err
+		}
+		if results[0].Error != "" {
+			return nil, nil, fmt.Errorf("failed to recreate schema: %s", results[0].Error)
+		}
+	}
+
+	results, err := db.Execute(req, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.QueryStringStmt(`SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL ORDER BY rowid`)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rows[0].Error != "" {
+		return nil, nil, errors.New(rows[0].Error)
+	}
+	objs := make([]SchemaObject, 0, len(rows[0].Values))
+	for _, v := range rows[0].Values {
+		p := v.GetParameters()
+		objs = append(objs, SchemaObject{
+			Type:    p[0].GetS(),
+			Name:    p[1].GetS(),
+			TblName: p[2].GetS(),
+			SQL:     p[3].GetS(),
+		})
+	}
+	return results, objs, nil
+}
+
+// openPreview opens a private in-memory database on a single connection,
+// used for both reads and writes. ATTACH and DETACH are denied on the connection, and it may attach
+// no databases, which also rules out VACUUM INTO.
+func openPreview() (*DB, error) {
+	dsn := "file::memory:?_fk=false"
+	connector, err := newPragmaConnector(dsn, nil)
+	if err != nil {
+		return nil, err
+	}
+	connector.onConnect = func(c *sqlite3.SQLiteConn) {
+		c.SetLimit(sqlite3.SQLITE_LIMIT_ATTACHED, 0)
+		c.RegisterAuthorizer(func(op int, _, _, _ string) int {
+			if op == sqlite3.SQLITE_ATTACH || op == sqlite3.SQLITE_DETACH {
+				return sqlite3.SQLITE_DENY
+			}
+			return sqlite3.SQLITE_OK
+		})
+	}
+	sqlDB := sql.OpenDB(connector)
+
+	// The database exists only as long as its connection does.
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+	sqlDB.SetConnMaxIdleTime(0)
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to open in-memory database: %s", err.Error())
+	}
+	return &DB{
+		path:      ":memory:",
+		rwDB:      sqlDB,
+		roDB:      sqlDB,
+		rwDSN:     dsn,
+		roDSN:     dsn,
+		changes:   &changeTracker{},
+		logger:    log.New(log.Writer(), "[db] ", log.LstdFlags),
+	}, nil
+}
+
 // ReplayWAL replays the given WAL files into the database at the given path,
 // in the order given by the slice. The supplied WAL files must be in the same
 // directory as the database file and are deleted as a result of the replay operation.
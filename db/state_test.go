@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
 )
 
 func Test_MakeDSN(t *testing.T) {
@@ -77,6 +79,46 @@ func Test_IsValidSQLiteOnDisk(t *testing.T) {
 	}
 }
 
+func Test_SQLiteDataSize(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		t.Fatalf("failed to create SQLite database: %s", err.Error())
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE foo (name TEXT)",
+		"INSERT INTO foo VALUES(zeroblob(20000))",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read SQLite file: %s", err.Error())
+	}
+	size, ok := SQLiteDataSize(data[:SQLiteFileHeaderSize])
+	if !ok {
+		t.Fatalf("size of good SQLite data marked as unreliable")
+	}
+	if exp := int64(len(data)); exp != size {
+		t.Fatalf("wrong size, exp %d, got %d", exp, size)
+	}
+
+	if _, ok := SQLiteDataSize(data[:SQLiteHeaderSize]); ok {
+		t.Fatalf("size read from partial header")
+	}
+	if _, ok := SQLiteDataSize([]byte("CREATE TABLE foo (name TEXT)")); ok {
+		t.Fatalf("size read from non-SQLite data")
+	}
+}
+
 func Test_IsWALModeEnabledOnDiskDELETE(t *testing.T) {
 	path := mustTempFile()
 	defer os.Remove(path)
@@ -184,6 +226,79 @@ func Test_EnsureDelete(t *testing.T) {
 	}
 }
 
+func Test_CheckSQL(t *testing.T) {
+	if err := CheckSQL(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);
+INSERT INTO foo VALUES(1, 'fiona');`); err != nil {
+		t.Fatalf("valid SQL failed check: %s", err.Error())
+	}
+	if err := CheckSQL(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);
+INSERT INTO foo VALUES(1, 'fiona'`); err == nil {
+		t.Fatalf("invalid SQL passed check")
+	}
+}
+
+func Test_PreviewSchema(t *testing.T) {
+	schema := []string{
+		`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE INDEX foo_name ON foo(name)`,
+	}
+	results, objs, err := PreviewSchema(schema, &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `ALTER TABLE foo RENAME COLUMN name TO full_name`},
+			{Sql: `DROP TABLE bar`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to preview schema: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Error != "" {
+		t.Fatalf("unexpected error for ALTER TABLE: %s", results[0].Error)
+	}
+	if results[1].Error != "no such table: bar" {
+		t.Fatalf("wrong error for DROP TABLE: %s", results[1].Error)
+	}
+	exp := []SchemaObject{
+		{Type: "table", Name: "foo", TblName: "foo", SQL: `CREATE TABLE foo (id INTEGER PRIMARY KEY, full_name TEXT)`},
+		{Type: "index", Name: "foo_name", TblName: "foo", SQL: `CREATE INDEX foo_name ON foo(full_name)`},
+	}
+	if fmt.Sprintf("%v", exp) != fmt.Sprintf("%v", objs) {
+		t.Fatalf("wrong schema, exp %v, got %v", exp, objs)
+	}
+
+	if _, _, err := PreviewSchema([]string{`CREATE TABLE foo (`}, &command.Request{}); err == nil {
+		t.Fatalf("expected error recreating invalid schema")
+	}
+}
+
+// Test_PreviewSchemaNoFiles ensures statements previewed cannot create or
+// write files by attaching databases.
+func Test_PreviewSchemaNoFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "attached.db")
+	results, _, err := PreviewSchema(nil, &command.Request{
+		Statements: []*command.Statement{
+			{Sql: fmt.Sprintf(`ATTACH DATABASE '%s' AS x`, path)},
+			{Sql: `CREATE TABLE x.t (id INTEGER)`},
+			{Sql: `DETACH DATABASE main`},
+			{Sql: fmt.Sprintf(`VACUUM INTO '%s'`, path)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to preview schema: %s", err.Error())
+	}
+	for i, r := range results {
+		if r.Error == "" {
+			t.Fatalf("statement %d succeeded", i)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file created by previewed statements")
+	}
+}
+
 // Test_WALReplayOK tests that WAL files are replayed as expected.
 func Test_WALReplayOK(t *testing.T) {
 	testFunc := func(t *testing.T, replayIntoDelete bool) {
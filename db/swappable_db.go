@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	command "github.com/rqlite/rqlite/v8/command/proto"
 )
@@ -17,8 +19,10 @@ type SwappableDB struct {
 }
 
 // OpenSwappable returns a new SwappableDB instance, which opens the database at the given path.
-func OpenSwappable(dbPath string, fkEnabled, wal bool) (*SwappableDB, error) {
-	db, err := Open(dbPath, fkEnabled, wal)
+// The given PRAGMAs are applied to each read-only connection, and continue to be applied
+// after the database is swapped.
+func OpenSwappable(dbPath string, fkEnabled, wal bool, roPragmas map[string]string) (*SwappableDB, error) {
+	db, err := OpenWithReadPragmas(dbPath, fkEnabled, wal, roPragmas)
 	if err != nil {
 		return nil, err
 	}
@@ -45,10 +49,14 @@ func (s *SwappableDB) Swap(path string, fkConstraints, walEnabled bool) error {
 		return fmt.Errorf("failed to rename database: %s", err)
 	}
 
-	db, err := Open(s.db.Path(), fkConstraints, walEnabled)
+	db, err := OpenWithReadPragmas(s.db.Path(), fkConstraints, walEnabled, s.db.ReadPragmas())
 	if err != nil {
 		return fmt.Errorf("open SQLite file failed: %s", err)
 	}
+	if fn := s.db.ChangeFunc(); fn != nil {
+		db.SetChangeFunc(fn)
+		fn([]Change{{Op: ChangeReset}})
+	}
 	s.db = db
 	return nil
 }
@@ -67,6 +75,13 @@ func (s *SwappableDB) Stats() (map[string]interface{}, error) {
 	return s.db.Stats()
 }
 
+// FileStats calls FileStats on the underlying database.
+func (s *SwappableDB) FileStats() (*FileStats, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.FileStats()
+}
+
 // Request calls Request on the underlying database.
 func (s *SwappableDB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
 	s.dbMu.RLock()
@@ -88,6 +103,27 @@ func (s *SwappableDB) Query(q *command.Request, xTime bool) ([]*command.QueryRow
 	return s.db.Query(q, xTime)
 }
 
+// QueryContext calls QueryContext on the underlying database.
+func (s *SwappableDB) QueryContext(ctx context.Context, q *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.QueryContext(ctx, q, xTime)
+}
+
+// Validate calls Validate on the underlying database.
+func (s *SwappableDB) Validate(req *command.Request) ([]*command.ExecuteResult, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.Validate(req)
+}
+
+// QueryStream calls QueryStream on the underlying database.
+func (s *SwappableDB) QueryStream(stmt *command.Statement, timeout time.Duration, fn RowFunc) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.QueryStream(stmt, timeout, fn)
+}
+
 // QueryStringStmt calls QueryStringStmt on the underlying database.
 func (s *SwappableDB) QueryStringStmt(query string) ([]*command.QueryRows, error) {
 	s.dbMu.RLock()
@@ -123,6 +159,13 @@ func (s *SwappableDB) StmtReadOnly(sql string) (bool, error) {
 	return s.db.StmtReadOnly(sql)
 }
 
+// StmtTables calls StmtTables on the underlying database.
+func (s *SwappableDB) StmtTables(sql string) ([]string, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.StmtTables(sql)
+}
+
 // Checkpoint calls Checkpoint on the underlying database.
 func (s *SwappableDB) Checkpoint(mode CheckpointMode) error {
 	s.dbMu.RLock()
@@ -157,3 +200,18 @@ func (s *SwappableDB) WALEnabled() bool {
 	defer s.dbMu.RUnlock()
 	return s.db.WALEnabled()
 }
+
+// SetChangeFunc calls SetChangeFunc on the underlying database. The function
+// remains set if the database is swapped, and is then passed a ChangeReset.
+func (s *SwappableDB) SetChangeFunc(fn ChangeFunc) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	s.db.SetChangeFunc(fn)
+}
+
+// ReadPragmas calls ReadPragmas on the underlying database.
+func (s *SwappableDB) ReadPragmas() map[string]string {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.ReadPragmas()
+}
@@ -12,7 +12,7 @@ func Test_OpenSwappable_Success(t *testing.T) {
 	defer os.Remove(path)
 
 	// Attempt to open a swappable database
-	swappableDB, err := OpenSwappable(path, false, false)
+	swappableDB, err := OpenSwappable(path, false, false, nil)
 	if err != nil {
 		t.Fatalf("failed to open swappable database: %s", err)
 	}
@@ -40,7 +40,7 @@ func Test_OpenSwappable_InvalidPath(t *testing.T) {
 	invalidPath := "/invalid/path/to/database"
 
 	// Attempt to open a swappable database with an invalid path
-	swappableDB, err := OpenSwappable(invalidPath, false, false)
+	swappableDB, err := OpenSwappable(invalidPath, false, false, nil)
 	if err == nil {
 		swappableDB.Close()
 		t.Fatalf("expected an error when opening swappable database with invalid path, got nil")
@@ -68,7 +68,7 @@ func Test_SwapSuccess(t *testing.T) {
 	// Create a SwappableDB with an empty database
 	swappablePath := mustTempPath()
 	defer os.Remove(swappablePath)
-	swappableDB, err := OpenSwappable(swappablePath, false, false)
+	swappableDB, err := OpenSwappable(swappablePath, false, false, nil)
 	if err != nil {
 		t.Fatalf("failed to open swappable database: %s", err)
 	}
@@ -98,7 +98,7 @@ func Test_SwapInvalidSQLiteFile(t *testing.T) {
 	// Create a SwappableDB with an empty database
 	swappablePath := mustTempPath()
 	defer os.Remove(swappablePath)
-	swappableDB, err := OpenSwappable(swappablePath, false, false)
+	swappableDB, err := OpenSwappable(swappablePath, false, false, nil)
 	if err != nil {
 		t.Fatalf("failed to open swappable database: %s", err)
 	}
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NegotiateEncoding returns the response encoding to use, given the value
+// of a request's Accept-Encoding header. gzip is preferred over deflate, and
+// an empty string is returned if neither is acceptable.
+func NegotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, e := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(e), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.TrimSpace(k) != "q" {
+				continue
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				f = 0
+			}
+			q = f
+		}
+		accepted[name] = q > 0
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, present := accepted[enc]; present {
+			if ok {
+				return enc
+			}
+			continue
+		}
+		if accepted["*"] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressResponseWriter compresses the response body written through it,
+// once at least minSize bytes have been written. Smaller responses are sent
+// as is, since compressing them would save little. Responses which already
+// have a Content-Encoding are never compressed. Close must be called once
+// the handler has returned.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	zw      io.WriteCloser
+}
+
+func newCompressResponseWriter(w http.ResponseWriter, encoding string, minSize int) *compressResponseWriter {
+	return &compressResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		minSize:        minSize,
+		status:         http.StatusOK,
+	}
+}
+
+// WriteHeader implements the http.ResponseWriter interface. The status is
+// not sent until it is known whether the response will be compressed.
+func (c *compressResponseWriter) WriteHeader(code int) {
+	if c.decided {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	c.status = code
+}
+
+// Write implements the http.ResponseWriter interface.
+func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		c.buf.Write(b)
+		if c.buf.Len() < c.minSize {
+			return len(b), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if c.zw != nil {
+		return c.zw.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface. Flushing commits to
+// compressing the response, as a streaming response will likely be large.
+func (c *compressResponseWriter) Flush() {
+	if !c.decided {
+		if err := c.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := c.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (c *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Close writes any buffered data, and completes the compressed stream.
+func (c *compressResponseWriter) Close() error {
+	if !c.decided {
+		return c.decide(false)
+	}
+	if c.zw != nil {
+		return c.zw.Close()
+	}
+	return nil
+}
+
+// decide sends the response header, and any buffered data, compressing the
+// remainder of the response if compress is true.
+func (c *compressResponseWriter) decide(compress bool) error {
+	c.decided = true
+	h := c.Header()
+	if h.Get("Content-Encoding") != "" || c.status == http.StatusNoContent ||
+		c.status == http.StatusNotModified {
+		compress = false
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	if compress {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		switch c.encoding {
+		case "gzip":
+			c.zw = gzip.NewWriter(c.ResponseWriter)
+		default:
+			c.zw, _ = flate.NewWriter(c.ResponseWriter, flate.DefaultCompression)
+		}
+		stats.Add(numCompressedResponses, 1)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if c.zw != nil {
+		_, err = c.zw.Write(c.buf.Bytes())
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf.Bytes())
+	}
+	c.buf.Reset()
+	return err
+}
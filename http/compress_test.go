@@ -0,0 +1,102 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_NegotiateEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		exp    string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"gzip;q=0, *", "deflate"},
+		{"br", ""},
+	} {
+		if got := NegotiateEncoding(tt.header); got != tt.exp {
+			t.Fatalf("wrong encoding for %q, exp %q, got %q", tt.header, tt.exp, got)
+		}
+	}
+}
+
+func Test_CompressResponseWriter(t *testing.T) {
+	large := strings.Repeat("rqlite", 1000)
+
+	// Small responses are not compressed.
+	rec := httptest.NewRecorder()
+	cw := newCompressResponseWriter(rec, "gzip", 1024)
+	cw.WriteHeader(http.StatusBadRequest)
+	cw.Write([]byte("small"))
+	if err := cw.Close(); err != nil {
+		t.Fatalf("failed to close writer: %s", err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("wrong status, exp %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("small response compressed with %s", ce)
+	}
+	if rec.Body.String() != "small" {
+		t.Fatalf("wrong body, got %s", rec.Body.String())
+	}
+
+	// Large responses are, whether written at once or in pieces.
+	for _, enc := range []string{"gzip", "deflate"} {
+		rec = httptest.NewRecorder()
+		cw = newCompressResponseWriter(rec, enc, 1024)
+		for i := 0; i < len(large); i += 100 {
+			cw.Write([]byte(large[i : i+100]))
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatalf("failed to close writer: %s", err)
+		}
+		if ce := rec.Header().Get("Content-Encoding"); ce != enc {
+			t.Fatalf("wrong Content-Encoding, exp %s, got %s", enc, ce)
+		}
+		if rec.Body.Len() >= len(large) {
+			t.Fatalf("response not compressed, %d bytes", rec.Body.Len())
+		}
+		var zr io.Reader
+		if enc == "gzip" {
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("failed to create gzip reader: %s", err)
+			}
+			zr = gr
+		} else {
+			zr = flate.NewReader(rec.Body)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("failed to decompress body: %s", err)
+		}
+		if string(b) != large {
+			t.Fatalf("wrong decompressed body")
+		}
+	}
+
+	// Responses which are already encoded are not compressed again.
+	rec = httptest.NewRecorder()
+	cw = newCompressResponseWriter(rec, "gzip", 0)
+	cw.Header().Set("Content-Encoding", "zstd")
+	cw.Write([]byte(large))
+	cw.Close()
+	if !bytes.Equal(rec.Body.Bytes(), []byte(large)) {
+		t.Fatalf("encoded response compressed again")
+	}
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"github.com/rqlite/rqlite/v8/command"
+	"github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
+)
+
+// requireRows marks each INSERT, UPDATE, and DELETE statement in stmts as
+// required to change at least one row. A marked statement which changes no
+// rows fails, allowing a client to compare-and-swap with statements such as
+// UPDATE ... WHERE version = ?.
+func requireRows(stmts []*proto.Statement) {
+	for _, stmt := range stmts {
+		if command.ChangesRows(stmt.Sql) {
+			stmt.RequireRows = true
+		}
+	}
+}
+
+// executeConflict returns whether any statement in results failed because it
+// was required to change rows, but changed none.
+func executeConflict(results []*proto.ExecuteResult) bool {
+	for _, r := range results {
+		if r.GetError() == db.ErrNoRowsAffected.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// requestConflict is executeConflict for the results of a request.
+func requestConflict(results []*proto.ExecuteQueryResponse) bool {
+	for _, r := range results {
+		if r.GetE().GetError() == db.ErrNoRowsAffected.Error() {
+			return true
+		}
+	}
+	return false
+}
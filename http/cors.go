@@ -0,0 +1,107 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long a browser may cache the response to a CORS preflight
+// request.
+const corsMaxAge = 10 * time.Minute
+
+// DefaultAllowMethods returns the methods allowed in cross-origin requests,
+// unless others are configured.
+func DefaultAllowMethods() []string {
+	return []string{"GET", "POST", "DELETE"}
+}
+
+// DefaultAllowHeaders returns the request headers allowed in cross-origin
+// requests, unless others are configured.
+func DefaultAllowHeaders() []string {
+	return []string{
+		"Content-Type",
+		"Content-Encoding",
+		"Authorization",
+		IdempotencyKeyHeader,
+		PriorityHTTPHeader,
+		RequestIDHTTPHeader,
+	}
+}
+
+// corsExposeHeaders are the response headers a browser lets a cross-origin
+// client read, beyond those it always may.
+var corsExposeHeaders = strings.Join([]string{
+	VersionHTTPHeader,
+	ServedByHTTPHeader,
+	LeaderHTTPHeader,
+	RequestIDHTTPHeader,
+	IdempotentReplayedHeader,
+	"Retry-After",
+}, ", ")
+
+// isPreflight returns whether the request is a CORS preflight request, made
+// by a browser to check that a cross-origin request is allowed.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header
+// of the response to a request from the given origin, or "" if the origin
+// may not make cross-origin requests. It also returns whether the origin is
+// one explicitly allowed, rather than allowed as any origin.
+func (s *Service) allowedOrigin(origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+	anyOrigin := false
+	for _, o := range s.AllowOrigins {
+		if o == "*" {
+			anyOrigin = true
+		} else if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	if anyOrigin {
+		return "*", false
+	}
+	return "", false
+}
+
+// addCORSHeaders adds the CORS headers to the response to a request from an
+// allowed origin. No headers are added unless origins are allowed. Requests
+// may carry credentials, such as Basic Auth, only if enabled, and only from
+// origins explicitly allowed, since browsers refuse credentials with "*".
+func (s *Service) addCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	if len(s.AllowOrigins) == 0 {
+		return
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	allow, explicit := s.allowedOrigin(r.Header.Get("Origin"))
+	if allow == "" {
+		return
+	}
+	h.Set(AllowOriginHeader, allow)
+	if s.AllowCredentials && explicit {
+		h.Set(AllowCredentialsHeader, "true")
+	}
+	if !isPreflight(r) {
+		h.Set(ExposeHeadersHeader, corsExposeHeaders)
+		return
+	}
+
+	methods := s.AllowMethods
+	if len(methods) == 0 {
+		methods = DefaultAllowMethods()
+	}
+	headers := s.AllowHeaders
+	if len(headers) == 0 {
+		headers = DefaultAllowHeaders()
+	}
+	h.Set(AllowMethodsHeader, strings.Join(methods, ", "))
+	h.Set(AllowHeadersHeader, strings.Join(headers, ", "))
+	h.Set(MaxAgeHeader, strconv.Itoa(int(corsMaxAge.Seconds())))
+}
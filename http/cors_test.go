@@ -0,0 +1,122 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_CORS(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	c := &mockCredentialStore{HasPermOK: false}
+	s := New("127.0.0.1:0", m, n, c)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	url := "http://" + s.Addr().String() + "/db/query"
+
+	do := func(method, origin string, preflight bool) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// CORS is disabled unless origins are allowed.
+	resp := do("OPTIONS", "https://app.example.com", true)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status code for preflight, exp %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	for _, h := range []string{AllowOriginHeader, AllowMethodsHeader, AllowHeadersHeader, AllowCredentialsHeader} {
+		if v := resp.Header.Get(h); v != "" {
+			t.Fatalf("%s set with CORS disabled: %s", h, v)
+		}
+	}
+
+	s.AllowOrigins = []string{"https://app.example.com", "https://admin.example.com"}
+	s.AllowCredentials = true
+
+	// Preflight requests carry no credentials, so are answered without
+	// authentication.
+	resp = do("OPTIONS", "https://admin.example.com", true)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status code for preflight, exp %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	for h, exp := range map[string]string{
+		AllowOriginHeader:      "https://admin.example.com",
+		AllowCredentialsHeader: "true",
+		AllowMethodsHeader:     "GET, POST, DELETE",
+		AllowHeadersHeader:     "Content-Type, Content-Encoding, Authorization, Idempotency-Key, X-Priority, X-Request-ID",
+		MaxAgeHeader:           "600",
+		"Vary":                 "Origin",
+	} {
+		if got := resp.Header.Get(h); exp != got {
+			t.Fatalf("wrong %s header, exp %q, got %q", h, exp, got)
+		}
+	}
+
+	// Responses to actual requests, including those which fail
+	// authentication, must allow the origin for the client to read them.
+	resp = do("GET", "https://app.example.com", false)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if exp, got := "https://app.example.com", resp.Header.Get(AllowOriginHeader); exp != got {
+		t.Fatalf("wrong allow-origin, exp %q, got %q", exp, got)
+	}
+	if exp, got := "true", resp.Header.Get(AllowCredentialsHeader); exp != got {
+		t.Fatalf("wrong allow-credentials, exp %q, got %q", exp, got)
+	}
+	if resp.Header.Get(ExposeHeadersHeader) == "" {
+		t.Fatalf("no exposed headers in response")
+	}
+	if v := resp.Header.Get(AllowMethodsHeader); v != "" {
+		t.Fatalf("allow-methods set on response to actual request: %s", v)
+	}
+
+	// Other origins are not allowed.
+	resp = do("OPTIONS", "https://evil.example.com", true)
+	if v := resp.Header.Get(AllowOriginHeader); v != "" {
+		t.Fatalf("allow-origin set for origin not allowed: %s", v)
+	}
+
+	// Any origin may be allowed, but not with credentials.
+	s.AllowOrigins = []string{"*"}
+	s.AllowMethods = []string{"GET"}
+	s.AllowHeaders = []string{"Authorization"}
+	resp = do("OPTIONS", "https://evil.example.com", true)
+	for h, exp := range map[string]string{
+		AllowOriginHeader:      "*",
+		AllowCredentialsHeader: "",
+		AllowMethodsHeader:     "GET",
+		AllowHeadersHeader:     "Authorization",
+	} {
+		if got := resp.Header.Get(h); exp != got {
+			t.Fatalf("wrong %s header, exp %q, got %q", h, exp, got)
+		}
+	}
+
+	// OPTIONS requests which are not preflight requests are unchanged.
+	resp = do("OPTIONS", "", false)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for OPTIONS, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if v := resp.Header.Get(AllowOriginHeader); v != "" {
+		t.Fatalf("allow-origin set for request without origin: %s", v)
+	}
+}
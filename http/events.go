@@ -0,0 +1,122 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/auth"
+)
+
+// eventsKeepAliveInterval is how often a comment is sent on an idle event
+// stream, so that proxies do not close it, and a closed connection is noticed.
+const eventsKeepAliveInterval = 15 * time.Second
+
+// handleEvents streams the cluster events observed by this node to the client
+// as Server-Sent Events. Each event is sent with its ID, so a client which
+// reconnects, setting the Last-Event-ID header, or the since query parameter,
+// receives the events it missed, as long as this node still retains them. If
+// it does not, a "missed" event is sent first, after which the client should
+// re-read any state it derives from events. The stream ends with an "error"
+// event if the client does not keep up with events.
+func (s *Service) handleEvents(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = qp.Since()
+	}
+	var after uint64
+	if since != "" {
+		var err error
+		if after, err = strconv.ParseUint(since, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event ID %s", since), http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	n := s.activeSubscriptions.Add(1)
+	defer s.activeSubscriptions.Add(-1)
+	if s.MaxSubscriptions > 0 && n > int64(s.MaxSubscriptions) {
+		stats.Add(numSubscribeRejected, 1)
+		http.Error(w, "too many subscriptions", http.StatusServiceUnavailable)
+		return
+	}
+
+	sub, err := s.store.SubscribeEvents(since != "", after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+	stats.Add(numEventStreams, 1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if sub.Missed() {
+		writeSSE(w, 0, "missed", struct{}{})
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					writeSSE(w, 0, "error", map[string]string{"error": err.Error()})
+					flusher.Flush()
+				}
+				return
+			}
+			if err := writeSSE(w, e.ID, string(e.Type), e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSSE writes a Server-Sent Event of the given type, with v as its JSON
+// data. An ID of 0 is not sent, so does not change the client's last event ID.
+func writeSSE(w io.Writer, id uint64, typ string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, b)
+	return err
+}
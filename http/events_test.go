@@ -0,0 +1,207 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/store"
+)
+
+type mockEventSubscription struct {
+	ch     chan store.Event
+	missed bool
+	err    error
+	closed chan struct{}
+}
+
+func newMockEventSubscription() *mockEventSubscription {
+	return &mockEventSubscription{
+		ch:     make(chan store.Event, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (m *mockEventSubscription) Events() <-chan store.Event { return m.ch }
+func (m *mockEventSubscription) Missed() bool               { return m.missed }
+func (m *mockEventSubscription) Err() error                 { return m.err }
+func (m *mockEventSubscription) Close()                     { close(m.closed) }
+
+// readSSE reads the lines of the next Server-Sent Event from r.
+func readSSE(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %s", err.Error())
+		}
+		l = strings.TrimSuffix(l, "\n")
+		if l == "" {
+			return lines
+		}
+		lines = append(lines, l)
+	}
+}
+
+func Test_Events(t *testing.T) {
+	sub := newMockEventSubscription()
+	sub.missed = true
+	var replay bool
+	var after uint64
+	m := &MockStore{
+		eventsFn: func(r bool, a uint64) (store.EventSubscription, error) {
+			replay, after = r, a
+			return sub, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := "http://" + s.Addr().String()
+
+	resp, err := http.Post(host+"/events", "", nil)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp, err = http.Get(host + "/events?since=foo")
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for invalid event ID, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	req, err := http.NewRequest("GET", host+"/events?since=1", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp, got := "text/event-stream", resp.Header.Get("Content-Type"); exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+	if !replay || after != 5 {
+		t.Fatalf("wrong subscription, exp replay after 5, got replay %v after %d", replay, after)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	if exp, got := []string{"event: missed", "data: {}"}, readSSE(t, br); strings.Join(exp, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("wrong missed event, exp %q, got %q", exp, got)
+	}
+	sub.ch <- store.Event{ID: 6, Type: store.EventLeaderChange, Node: "1", Time: time.Unix(0, 0).UTC()}
+	exp := []string{
+		"id: 6",
+		"event: leader_change",
+		`data: {"id":6,"type":"leader_change","time":"1970-01-01T00:00:00Z","node":"1"}`,
+	}
+	if got := readSSE(t, br); strings.Join(exp, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("wrong event, exp %q, got %q", exp, got)
+	}
+
+	sub.err = store.ErrEventSubscriptionOverflow
+	close(sub.ch)
+	exp = []string{"event: error", `data: {"error":"subscription fell behind events"}`}
+	if got := readSSE(t, br); strings.Join(exp, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("wrong error event, exp %q, got %q", exp, got)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-sub.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not closed once stream ended")
+	}
+}
+
+func Test_EventsClientClose(t *testing.T) {
+	sub := newMockEventSubscription()
+	var replay bool
+	m := &MockStore{
+		eventsFn: func(r bool, a uint64) (store.EventSubscription, error) {
+			replay = r
+			return sub, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/events")
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if replay {
+		t.Fatalf("subscription replays events without an event ID")
+	}
+	resp.Body.Close()
+
+	select {
+	case <-sub.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not closed once client disconnected")
+	}
+}
+
+func Test_EventsShutdown(t *testing.T) {
+	sub := newMockEventSubscription()
+	m := &MockStore{
+		eventsFn: func(r bool, a uint64) (store.EventSubscription, error) {
+			return sub, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/events")
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// An open stream must not hold up a graceful shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down with open event stream: %s", err.Error())
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("shutdown with open event stream took %s", d)
+	}
+	select {
+	case <-sub.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not closed on shutdown")
+	}
+}
@@ -0,0 +1,229 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/rqlite/rqlite/v8/auth"
+)
+
+// featureFlag is a setting of the Service which can be inspected, and if not
+// read-only, changed at runtime via /config/flags.
+type featureFlag struct {
+	readOnly bool
+	get      func(s *Service) interface{}
+	set      func(s *Service, v json.RawMessage) error
+}
+
+// featureFlags are the settings exposed via /config/flags. Settings which are
+// only consulted when the Service starts are read-only. Live settings must
+// only be read, while the Service is running, with flagsMu held.
+var featureFlags = map[string]featureFlag{
+	"strict_query": {
+		get: func(s *Service) interface{} { return s.StrictQuery },
+		set: func(s *Service, v json.RawMessage) error {
+			return json.Unmarshal(v, &s.StrictQuery)
+		},
+	},
+	"log_sample_rate": {
+		get: func(s *Service) interface{} { return s.LogSampleRate },
+		set: func(s *Service, v json.RawMessage) error {
+			var f float64
+			if err := json.Unmarshal(v, &f); err != nil {
+				return err
+			}
+			if f < 0 || f > 1 {
+				return fmt.Errorf("must be between 0 and 1")
+			}
+			s.LogSampleRate = f
+			return nil
+		},
+	},
+	"default_max_rows": {
+		get: func(s *Service) interface{} { return s.DefaultMaxRows },
+		set: func(s *Service, v json.RawMessage) error {
+			var n int64
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			if n < 0 {
+				return fmt.Errorf("must not be negative")
+			}
+			s.DefaultMaxRows = n
+			return nil
+		},
+	},
+	"query_limit_mode": {
+		get: func(s *Service) interface{} { return s.QueryLimitMode },
+		set: func(s *Service, v json.RawMessage) error {
+			var m string
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if m != "" && !auth.ValidQueryLimit(m) {
+				return fmt.Errorf("must be one of %s, %s, or %s",
+					auth.QueryLimitOff, auth.QueryLimitReject, auth.QueryLimitAppend)
+			}
+			s.QueryLimitMode = m
+			return nil
+		},
+	},
+	"query_limit_rows": {
+		get: func(s *Service) interface{} { return s.QueryLimitRows },
+		set: func(s *Service, v json.RawMessage) error {
+			var n int64
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			if n < 1 {
+				return fmt.Errorf("must be at least 1")
+			}
+			s.QueryLimitRows = n
+			return nil
+		},
+	},
+	"max_concurrent_requests": {
+		readOnly: true,
+		get:      func(s *Service) interface{} { return s.MaxConcurrentRequests },
+	},
+	"follower_read_fallback": {
+		readOnly: true,
+		get:      func(s *Service) interface{} { return s.FollowerReadFallback },
+	},
+	"compress_responses": {
+		readOnly: true,
+		get:      func(s *Service) interface{} { return s.CompressResponses },
+	},
+}
+
+// flagScopeNode is the scope of the feature flags, which are held by each
+// node and not shared with the rest of the cluster.
+const flagScopeNode = "node"
+
+// flagState is the state of a feature flag, as returned by /config/flags.
+type flagState struct {
+	Value    interface{} `json:"value"`
+	ReadOnly bool        `json:"read_only"`
+}
+
+// handleFlags returns the feature flags of this node on GET, and changes
+// them on PUT. A PUT body is a JSON object mapping flag names to their new
+// values. Either every flag in the body is changed, or none are. Changes
+// apply only to this node, and last until it restarts, so the response
+// reports a scope of "node". A change must be made on every node for the
+// cluster to behave consistently.
+func (s *Service) handleFlags(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermAll) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var changes map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, fmt.Sprintf("invalid flags: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		if err := s.setFlags(changes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.flagsMu.RLock()
+	flags := make(map[string]flagState, len(featureFlags))
+	for name, f := range featureFlags {
+		flags[name] = flagState{Value: f.get(s), ReadOnly: f.readOnly}
+	}
+	s.flagsMu.RUnlock()
+	s.writeJSON(w, qp, map[string]interface{}{
+		"scope": flagScopeNode,
+		"flags": flags,
+	})
+}
+
+// setFlags changes the given feature flags, leaving all unchanged if any
+// flag is unknown, read-only, or given an invalid value.
+func (s *Service) setFlags(changes map[string]json.RawMessage) error {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		f, ok := featureFlags[name]
+		if !ok {
+			return fmt.Errorf("unknown flag %s", name)
+		}
+		if f.readOnly {
+			return fmt.Errorf("flag %s is read-only", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s.flagsMu.Lock()
+	defer s.flagsMu.Unlock()
+	prev := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		prev[name] = featureFlags[name].get(s)
+	}
+	for _, name := range names {
+		if err := featureFlags[name].set(s, changes[name]); err != nil {
+			for n, v := range prev {
+				b, _ := json.Marshal(v)
+				featureFlags[n].set(s, b)
+			}
+			return fmt.Errorf("invalid value for flag %s: %s", name, err.Error())
+		}
+	}
+	for _, name := range names {
+		s.logger.Printf("flag %s set to %s", name, changes[name])
+	}
+	stats.Add(numFlagChanges, int64(len(names)))
+	return nil
+}
+
+// strictQuery returns whether statements which modify the database are
+// rejected on the query endpoint.
+func (s *Service) strictQuery() bool {
+	s.flagsMu.RLock()
+	defer s.flagsMu.RUnlock()
+	return s.StrictQuery
+}
+
+// logSampleRate returns the fraction of requests to log.
+func (s *Service) logSampleRate() float64 {
+	s.flagsMu.RLock()
+	defer s.flagsMu.RUnlock()
+	return s.LogSampleRate
+}
+
+// defaultMaxRows returns the maximum rows returned per statement, if not set
+// for the user.
+func (s *Service) defaultMaxRows() int64 {
+	s.flagsMu.RLock()
+	defer s.flagsMu.RUnlock()
+	return s.DefaultMaxRows
+}
+
+// queryLimit returns how SELECT statements without a LIMIT clause are
+// treated, if not set for the user.
+func (s *Service) queryLimit() string {
+	s.flagsMu.RLock()
+	defer s.flagsMu.RUnlock()
+	return s.QueryLimitMode
+}
+
+// queryLimitRows returns the LIMIT added to statements without one.
+func (s *Service) queryLimitRows() int64 {
+	s.flagsMu.RLock()
+	defer s.flagsMu.RUnlock()
+	return s.QueryLimitRows
+}
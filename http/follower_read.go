@@ -0,0 +1,122 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// Interval between refreshes of the followers which can serve reads the
+// Leader cannot admit.
+const defaultFollowerReadRefreshInterval = time.Second
+
+// followerReads tracks the API URLs of the followers which, when last
+// checked, had applied every entry committed by the Leader. It is refreshed
+// in the background, so choosing a follower for a read never waits on a
+// request to another node.
+type followerReads struct {
+	mu   sync.Mutex
+	urls []string
+	next int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newFollowerReads() *followerReads {
+	return &followerReads{
+		done: make(chan struct{}),
+	}
+}
+
+// Pick returns the URL of a caught-up follower, rotating between them, or
+// false if there is none.
+func (f *followerReads) Pick() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.urls) == 0 {
+		return "", false
+	}
+	u := f.urls[f.next%len(f.urls)]
+	f.next++
+	return u, true
+}
+
+// Set sets the URLs of the caught-up followers.
+func (f *followerReads) Set(urls []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.urls = urls
+}
+
+// Stop stops the background refresh, and waits for it to exit.
+func (f *followerReads) Stop() {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+	f.wg.Wait()
+}
+
+// runFollowerReads refreshes the caught-up followers every interval until
+// stopped. Only a Leader redirects reads, so other nodes have none.
+func (s *Service) runFollowerReads(interval time.Duration) {
+	defer s.followerReads.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.store.IsLeader() {
+			s.followerReads.Set(s.caughtUpFollowers())
+		} else {
+			s.followerReads.Set(nil)
+		}
+		select {
+		case <-s.followerReads.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// caughtUpFollowers returns the API URLs of the followers which have applied
+// every entry committed by this node. The followers are checked concurrently.
+func (s *Service) caughtUpFollowers() []string {
+	nodes, err := s.store.Nodes()
+	if err != nil {
+		return nil
+	}
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil || lAddr == "" {
+		return nil
+	}
+	leader, err := s.cluster.GetNodeMeta(lAddr, followerReadFallbackTimeout)
+	if err != nil {
+		return nil
+	}
+
+	urls := make([]string, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		if n.Addr == lAddr {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			meta, err := s.cluster.GetNodeMeta(addr, followerReadFallbackTimeout)
+			if err != nil || meta.Url == "" || meta.AppliedIndex < leader.CommitIndex {
+				return
+			}
+			urls[i] = meta.Url
+		}(i, n.Addr)
+	}
+	wg.Wait()
+
+	caughtUp := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if u != "" {
+			caughtUp = append(caughtUp, u)
+		}
+	}
+	return caughtUp
+}
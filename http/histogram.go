@@ -0,0 +1,65 @@
+package http
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Histogram counts observed values in buckets with fixed upper bounds.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []int64
+	counts []int64 // One per bound, plus one for values above every bound.
+	count  int64
+	sum    int64
+}
+
+// NewHistogram returns a Histogram with buckets bounded, inclusively, by the
+// given values, which must be in increasing order. Values greater than the
+// last bound are counted in a final, unbounded, bucket.
+func NewHistogram(bounds ...int64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe records the given value.
+func (h *Histogram) Observe(v int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// HistogramBucket is the number of observed values no greater than LE, and
+// greater than the bound of the previous bucket.
+type HistogramBucket struct {
+	LE    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// Stats returns the number and sum of observed values, and the count in each
+// bucket.
+func (h *Histogram) Stats() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i := range h.counts {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatInt(h.bounds[i], 10)
+		}
+		buckets[i] = HistogramBucket{LE: le, Count: h.counts[i]}
+	}
+	return map[string]interface{}{
+		"count":   h.count,
+		"sum":     h.sum,
+		"buckets": buckets,
+	}
+}
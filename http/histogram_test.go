@@ -0,0 +1,30 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Histogram(t *testing.T) {
+	h := NewHistogram(1, 10, 100)
+	for _, v := range []int64{0, 1, 2, 10, 11, 100, 101, 5000} {
+		h.Observe(v)
+	}
+
+	st := h.Stats()
+	if exp, got := int64(8), st["count"]; exp != got {
+		t.Fatalf("wrong count, exp %d, got %v", exp, got)
+	}
+	if exp, got := int64(5225), st["sum"]; exp != got {
+		t.Fatalf("wrong sum, exp %d, got %v", exp, got)
+	}
+	exp := []HistogramBucket{
+		{LE: "1", Count: 2},
+		{LE: "10", Count: 2},
+		{LE: "100", Count: 2},
+		{LE: "+Inf", Count: 2},
+	}
+	if got := st["buckets"]; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong buckets, exp %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	// IdempotencyKeyHeader is the HTTP header with which a client names an
+	// execute request, so that the request may be safely retried.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on a response carrying the results
+	// recorded for an earlier request with the same idempotency key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotencyEnabled returns whether execute requests may carry idempotency
+// keys.
+func (s *Service) idempotencyEnabled() bool {
+	return s.IdempotencyWindow > 0 && s.IdempotencyMaxKeys > 0
+}
+
+// setIdempotencyKey sets the idempotency key of the execute request, if the
+// HTTP request carries one, along with the digest of the request body b. The
+// key is scoped to the verified user, so that a retry is only recognized if
+// made by the same user. The window and maximum number of keys of this node
+// are written to the log with the request, so that every node applying it
+// keeps the record of it in the same way.
+func (s *Service) setIdempotencyKey(r *http.Request, er *proto.ExecuteRequest, b []byte) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" || !s.idempotencyEnabled() {
+		return
+	}
+	username, _ := s.authenticatedUsername(r)
+	digest := sha256.Sum256(b)
+	er.IdempotencyKey = username + "\x00" + key
+	er.IdempotencyDigest = digest[:]
+	er.IdempotencyWindow = int64(s.IdempotencyWindow)
+	er.IdempotencyMaxKeys = int64(s.IdempotencyMaxKeys)
+}
+
+// replayed returns whether the results were recorded for an earlier request
+// with the same idempotency key.
+func replayed(results []*proto.ExecuteResult) bool {
+	return len(results) > 0 && results[0].Replayed
+}
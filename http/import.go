@@ -0,0 +1,317 @@
+package http
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rqlite/rqlite/v8/command/encoding"
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	// DefaultImportBatchSize is the number of CSV rows inserted by each
+	// statement of an import, unless the request sets batch_size.
+	DefaultImportBatchSize = 500
+
+	// maxImportParams is the most parameters bound to a single INSERT of an
+	// import, SQLite's default limit on the number of parameters.
+	maxImportParams = 32766
+
+	// maxImportErrors is the most row errors reported by an import. Rows
+	// which fail beyond that are counted, but not reported.
+	maxImportErrors = 100
+)
+
+var (
+	// ErrNoHeader is returned when an import has no CSV header row.
+	ErrNoHeader = errors.New("CSV header row not found")
+
+	// ErrNoImportColumns is returned when an import maps no CSV field to a
+	// table column.
+	ErrNoImportColumns = errors.New("no CSV fields mapped to table columns")
+
+	// errReadingCSV wraps an error reading the CSV file of an import, as
+	// opposed to an error inserting its rows.
+	errReadingCSV = errors.New("reading CSV")
+
+	// errImportRejected wraps the error of a pre-execute hook which rejected
+	// an insert of an import.
+	errImportRejected = errors.New("import rejected")
+)
+
+// ParseColumnMap parses a mapping of CSV header names to table columns,
+// given as comma-separated header:column pairs, for example
+// "First Name:first_name,Notes:". A header mapped to no column is skipped.
+// An empty string is no mapping.
+func ParseColumnMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	if s == "" {
+		return m, nil
+	}
+	for _, hc := range strings.Split(s, ",") {
+		h, c, ok := strings.Cut(hc, ":")
+		if !ok || h == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, must be header:column", hc)
+		}
+		if _, ok := m[h]; ok {
+			return nil, fmt.Errorf("header %s mapped more than once", h)
+		}
+		m[h] = c
+	}
+	return m, nil
+}
+
+// importColumns returns the table column into which each field of a CSV
+// file with the given header row is imported, or "" if the field is skipped.
+// Each header names its column, unless mapped elsewhere. Every column must be
+// one of the table's columns, cols, and be imported at most once.
+func importColumns(header []string, mapping map[string]string, cols []string) ([]string, error) {
+	known := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		known[c] = true
+	}
+	for h := range mapping {
+		found := false
+		for _, hh := range header {
+			found = found || hh == h
+		}
+		if !found {
+			return nil, fmt.Errorf("mapped header %s not in CSV header row", h)
+		}
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		c, ok := mapping[h]
+		if !ok {
+			c = h
+		}
+		if c == "" {
+			continue
+		}
+		if !known[c] {
+			return nil, fmt.Errorf("no such column: %s", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("column %s imported more than once", c)
+		}
+		seen[c] = true
+		columns[i] = c
+	}
+	if len(seen) == 0 {
+		return nil, ErrNoImportColumns
+	}
+	return columns, nil
+}
+
+// importRow is a row of a CSV file, as the parameters bound to its insert.
+type importRow struct {
+	line   int
+	params []*command.Parameter
+}
+
+// importError is the error of a CSV row which could not be imported.
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importResult reports the outcome of an import.
+type importResult struct {
+	Rows   int64         `json:"rows"`
+	Failed int64         `json:"failed"`
+	Errors []importError `json:"errors,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// csvImporter inserts the rows of a CSV file into a table, in batches. Each
+// batch is inserted by a single INSERT statement, in its own transaction. If
+// a batch fails it is retried a row at a time, so that only the rows which
+// cannot be inserted fail, and each is reported with its line in the file.
+//
+// Fields are bound to the INSERT as text, except that an empty field is
+// bound as NULL. SQLite then converts each value according to the type
+// affinity of its column, so, for example, "42" is stored as an integer in
+// an INTEGER column, but as text in a TEXT column.
+type csvImporter struct {
+	table     string
+	columns   []string // Column of each CSV field, or "" if skipped.
+	batchSize int
+	dbTimeout int64
+	exec      func(*command.ExecuteRequest) ([]*command.ExecuteResult, error)
+
+	batch  []importRow
+	result importResult
+}
+
+// newCSVImporter returns a csvImporter for the given table and columns,
+// which executes its inserts with exec. The batch size is reduced if needed
+// so that no INSERT binds more parameters than SQLite allows.
+func newCSVImporter(table string, columns []string, batchSize int,
+	exec func(*command.ExecuteRequest) ([]*command.ExecuteResult, error)) *csvImporter {
+	n := 0
+	for _, c := range columns {
+		if c != "" {
+			n++
+		}
+	}
+	if batchSize > maxImportParams/n {
+		batchSize = maxImportParams / n
+	}
+	return &csvImporter{
+		table:     table,
+		columns:   columns,
+		batchSize: batchSize,
+		exec:      exec,
+	}
+}
+
+// Import reads rows from cr, whose header row has already been read, until
+// it is exhausted, and inserts them. A row which cannot be parsed fails,
+// but the import continues. An error is returned if reading from cr fails
+// for any other reason, wrapping errReadingCSV, or if an insert cannot be
+// executed. The rows read since the last completed batch are then not
+// imported.
+func (ci *csvImporter) Import(cr *csv.Reader) error {
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return ci.flush()
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			ci.fail(pe.StartLine, pe.Err.Error())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", errReadingCSV, err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := importRow{line: line}
+		for i, f := range record {
+			if ci.columns[i] == "" {
+				continue
+			}
+			p := &command.Parameter{}
+			if f != "" {
+				p.Value = &command.Parameter_S{S: f}
+			}
+			row.params = append(row.params, p)
+		}
+		ci.batch = append(ci.batch, row)
+		if len(ci.batch) >= ci.batchSize {
+			if err := ci.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Result returns the outcome of the import so far, with row errors in the
+// order of their lines.
+func (ci *csvImporter) Result() importResult {
+	sort.SliceStable(ci.result.Errors, func(i, j int) bool {
+		return ci.result.Errors[i].Line < ci.result.Errors[j].Line
+	})
+	return ci.result
+}
+
+// flush inserts the rows batched so far.
+func (ci *csvImporter) flush() error {
+	if len(ci.batch) == 0 {
+		return nil
+	}
+	batch := ci.batch
+	ci.batch = nil
+
+	results, err := ci.exec(ci.request(true, ci.statement(batch)))
+	if err != nil {
+		return err
+	}
+	if len(results) == 1 && results[0].Error == "" {
+		ci.result.Rows += int64(len(batch))
+		return nil
+	}
+	if len(batch) == 1 {
+		ci.fail(batch[0].line, resultError(results))
+		return nil
+	}
+
+	// Retry each row on its own, to find those which fail.
+	stmts := make([]*command.Statement, len(batch))
+	for i := range batch {
+		stmts[i] = ci.statement(batch[i : i+1])
+	}
+	results, err = ci.exec(ci.request(false, stmts...))
+	if err != nil {
+		return err
+	}
+	for i := range batch {
+		switch {
+		case i >= len(results):
+			ci.fail(batch[i].line, "no result")
+		case results[i].Error != "":
+			ci.fail(batch[i].line, results[i].Error)
+		default:
+			ci.result.Rows++
+		}
+	}
+	return nil
+}
+
+// fail records that the row at the given line was not imported.
+func (ci *csvImporter) fail(line int, err string) {
+	ci.result.Failed++
+	if len(ci.result.Errors) < maxImportErrors {
+		ci.result.Errors = append(ci.result.Errors, importError{Line: line, Error: err})
+	}
+}
+
+// request returns the request executing the given statements.
+func (ci *csvImporter) request(tx bool, stmts ...*command.Statement) *command.ExecuteRequest {
+	return &command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: tx,
+			Statements:  stmts,
+			DbTimeout:   ci.dbTimeout,
+		},
+	}
+}
+
+// statement returns the statement inserting the given rows.
+func (ci *csvImporter) statement(rows []importRow) *command.Statement {
+	var cols []string
+	for _, c := range ci.columns {
+		if c != "" {
+			cols = append(cols, encoding.QuoteIdentifier(c))
+		}
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", encoding.QuoteIdentifier(ci.table), strings.Join(cols, ", "))
+	stmt := &command.Statement{}
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(placeholders)
+		stmt.Parameters = append(stmt.Parameters, row.params...)
+	}
+	stmt.Sql = b.String()
+	return stmt
+}
+
+// resultError returns the error in the results of a single statement.
+func resultError(results []*command.ExecuteResult) string {
+	if len(results) == 0 {
+		return "no result"
+	}
+	return results[0].Error
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_ParseColumnMap(t *testing.T) {
+	for _, tt := range []struct {
+		s   string
+		exp map[string]string
+		err bool
+	}{
+		{"", map[string]string{}, false},
+		{"a:b", map[string]string{"a": "b"}, false},
+		{"First Name:first_name,Notes:", map[string]string{"First Name": "first_name", "Notes": ""}, false},
+		{"a", nil, true},
+		{":b", nil, true},
+		{"a:b,a:c", nil, true},
+	} {
+		m, err := ParseColumnMap(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error for %q, got none", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", tt.s, err.Error())
+		}
+		if !reflect.DeepEqual(m, tt.exp) {
+			t.Fatalf("wrong mapping for %q, exp %v, got %v", tt.s, tt.exp, m)
+		}
+	}
+}
+
+func Test_ImportColumns(t *testing.T) {
+	cols := []string{"id", "first_name", "age"}
+	for _, tt := range []struct {
+		name    string
+		header  []string
+		mapping map[string]string
+		exp     []string
+		err     bool
+	}{
+		{"Header names columns", []string{"id", "age"}, nil, []string{"id", "age"}, false},
+		{"Mapped", []string{"id", "First Name"}, map[string]string{"First Name": "first_name"}, []string{"id", "first_name"}, false},
+		{"Skipped", []string{"id", "notes"}, map[string]string{"notes": ""}, []string{"id", ""}, false},
+		{"Unknown column", []string{"id", "notes"}, nil, nil, true},
+		{"Column twice", []string{"id", "ID"}, map[string]string{"ID": "id"}, nil, true},
+		{"Mapped header missing", []string{"id"}, map[string]string{"age": "age"}, nil, true},
+		{"No columns", []string{"notes"}, map[string]string{"notes": ""}, nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := importColumns(tt.header, tt.mapping, cols)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(columns, tt.exp) {
+				t.Fatalf("wrong columns, exp %v, got %v", tt.exp, columns)
+			}
+		})
+	}
+}
+
+func Test_CSVImporter(t *testing.T) {
+	var reqs []*command.ExecuteRequest
+	exec := func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		reqs = append(reqs, er)
+		var results []*command.ExecuteResult
+		for _, stmt := range er.Request.Statements {
+			r := &command.ExecuteResult{}
+			for _, p := range stmt.Parameters {
+				if p.GetS() == "bad" {
+					r.Error = "CHECK constraint failed"
+				}
+			}
+			results = append(results, r)
+			if r.Error != "" && er.Request.Transaction {
+				break
+			}
+		}
+		return results, nil
+	}
+
+	cr := csv.NewReader(strings.NewReader("id,notes,name\n1,x,fiona\n2,x,\n3,x,bad\n4,x\n5,x,declan\n"))
+	if _, err := cr.Read(); err != nil {
+		t.Fatalf("failed to read header: %s", err.Error())
+	}
+	ci := newCSVImporter("foo", []string{"id", "", "name"}, 2, exec)
+	if err := ci.Import(cr); err != nil {
+		t.Fatalf("failed to import: %s", err.Error())
+	}
+
+	res := ci.Result()
+	if res.Rows != 3 || res.Failed != 2 {
+		t.Fatalf("wrong counts, exp 3 imported and 2 failed, got %d and %d", res.Rows, res.Failed)
+	}
+	if exp := []importError{
+		{Line: 4, Error: "CHECK constraint failed"},
+		{Line: 5, Error: "wrong number of fields"},
+	}; !reflect.DeepEqual(res.Errors, exp) {
+		t.Fatalf("wrong errors, exp %v, got %v", exp, res.Errors)
+	}
+
+	// The first batch succeeds. The second, of the rows either side of the
+	// row which cannot be parsed, fails and is retried row by row.
+	if len(reqs) != 3 {
+		t.Fatalf("wrong number of requests, exp 3, got %d", len(reqs))
+	}
+	first := reqs[0].Request
+	if !first.Transaction || len(first.Statements) != 1 {
+		t.Fatalf("batch not inserted by a single statement in a transaction")
+	}
+	if exp := `INSERT INTO "foo" ("id", "name") VALUES (?,?), (?,?)`; first.Statements[0].Sql != exp {
+		t.Fatalf("wrong SQL, exp %s, got %s", exp, first.Statements[0].Sql)
+	}
+	if p := first.Statements[0].Parameters; len(p) != 4 || p[1].GetS() != "fiona" || p[3].GetValue() != nil {
+		t.Fatalf("wrong parameters: %v", p)
+	}
+	if retry := reqs[2].Request; retry.Transaction || len(retry.Statements) != 2 {
+		t.Fatalf("failed batch not retried row by row")
+	}
+}
+
+func Test_CSVImporterBatchSize(t *testing.T) {
+	ci := newCSVImporter("foo", []string{"a", "b", ""}, 1000000, nil)
+	if exp := maxImportParams / 2; ci.batchSize != exp {
+		t.Fatalf("batch size not limited by parameters, exp %d, got %d", exp, ci.batchSize)
+	}
+}
+
+func Test_CSVImporterExecError(t *testing.T) {
+	errExec := errors.New("leader not found")
+	cr := csv.NewReader(strings.NewReader("1\n2\n"))
+	ci := newCSVImporter("foo", []string{"id"}, 10, func(*command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return nil, errExec
+	})
+	if err := ci.Import(cr); err != errExec {
+		t.Fatalf("expected exec error, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority is the priority with which a request is admitted by a Limiter.
+type Priority int
+
+const (
+	// PriorityLow is for requests, such as bulk writes, which may wait.
+	PriorityLow Priority = iota
+	// PriorityNormal is the priority of requests which don't specify one.
+	PriorityNormal
+	// PriorityHigh is for latency-sensitive requests, such as interactive reads.
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+// ParsePriority parses the value of a priority hint. An empty string is
+// PriorityNormal.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "high":
+		return PriorityHigh, nil
+	case "normal", "":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority %q, must be high, normal, or low", s)
+}
+
+// Limiter bounds the number of requests in progress. Requests waiting for
+// admission are admitted highest priority first, and in arrival order
+// within a priority.
+type Limiter struct {
+	mu      sync.Mutex
+	max     int
+	active  int
+	waiting [numPriorities][]chan struct{}
+}
+
+// NewLimiter returns a Limiter which admits at most max requests at once.
+func NewLimiter(max int) *Limiter {
+	return &Limiter{
+		max: max,
+	}
+}
+
+// Acquire blocks until the request is admitted, or ctx is done. If it
+// returns nil the caller must call Release when the request completes.
+func (l *Limiter) Acquire(ctx context.Context, p Priority) error {
+	l.mu.Lock()
+	if l.active < l.max && l.numWaiting() == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	l.waiting[p] = append(l.waiting[p], ch)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, c := range l.waiting[p] {
+			if c == ch {
+				l.waiting[p] = append(l.waiting[p][:i], l.waiting[p][i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Admitted concurrently with cancellation, so pass the slot on.
+		l.release()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire admits the request only if it can be admitted without waiting,
+// returning whether it was. If it returns true the caller must call Release
+// when the request completes.
+func (l *Limiter) TryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active < l.max && l.numWaiting() == 0 {
+		l.active++
+		return true
+	}
+	return false
+}
+
+// Release marks an admitted request as complete, admitting the highest
+// priority waiting request, if any.
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.release()
+}
+
+// Waiting returns the number of requests waiting for admission.
+func (l *Limiter) Waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.numWaiting()
+}
+
+// Active returns the number of admitted requests.
+func (l *Limiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+func (l *Limiter) release() {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(l.waiting[p]) > 0 {
+			// Hand the slot directly to the waiter.
+			close(l.waiting[p][0])
+			l.waiting[p] = l.waiting[p][1:]
+			return
+		}
+	}
+	l.active--
+}
+
+func (l *Limiter) numWaiting() int {
+	n := 0
+	for p := range l.waiting {
+		n += len(l.waiting[p])
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_ParsePriority(t *testing.T) {
+	for s, exp := range map[string]Priority{
+		"":       PriorityNormal,
+		"normal": PriorityNormal,
+		"high":   PriorityHigh,
+		"low":    PriorityLow,
+	} {
+		p, err := ParsePriority(s)
+		if err != nil {
+			t.Fatalf("failed to parse priority %q: %s", s, err.Error())
+		}
+		if p != exp {
+			t.Fatalf("wrong priority for %q, exp %d, got %d", s, exp, p)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Fatalf("expected error parsing invalid priority")
+	}
+}
+
+func Test_LimiterAdmitsUpToMax(t *testing.T) {
+	l := NewLimiter(2)
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(context.Background(), PriorityNormal); err != nil {
+			t.Fatalf("failed to acquire: %s", err.Error())
+		}
+	}
+	if exp, got := 2, l.Active(); exp != got {
+		t.Fatalf("wrong active count, exp %d, got %d", exp, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, PriorityHigh); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded acquiring saturated limiter, got %v", err)
+	}
+	if exp, got := 0, l.Waiting(); exp != got {
+		t.Fatalf("cancelled request still waiting, got %d", got)
+	}
+
+	l.Release()
+	l.Release()
+	if exp, got := 0, l.Active(); exp != got {
+		t.Fatalf("wrong active count, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_LimiterTryAcquire(t *testing.T) {
+	l := NewLimiter(1)
+	if !l.TryAcquire() {
+		t.Fatalf("failed to acquire idle limiter")
+	}
+	if l.TryAcquire() {
+		t.Fatalf("acquired saturated limiter")
+	}
+	if exp, got := 1, l.Active(); exp != got {
+		t.Fatalf("wrong active count, exp %d, got %d", exp, got)
+	}
+	l.Release()
+	if !l.TryAcquire() {
+		t.Fatalf("failed to acquire released limiter")
+	}
+}
+
+func Test_LimiterPriorityOrder(t *testing.T) {
+	l := NewLimiter(1)
+	if err := l.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+
+	admitted := make(chan Priority)
+	enqueue := func(p Priority, n int) {
+		go func() {
+			if err := l.Acquire(context.Background(), p); err != nil {
+				t.Errorf("failed to acquire: %s", err.Error())
+				return
+			}
+			admitted <- p
+		}()
+		waitFor(t, func() bool { return l.Waiting() == n })
+	}
+	enqueue(PriorityLow, 1)
+	enqueue(PriorityLow, 2)
+	enqueue(PriorityNormal, 3)
+	enqueue(PriorityHigh, 4)
+
+	for _, exp := range []Priority{PriorityHigh, PriorityNormal, PriorityLow, PriorityLow} {
+		l.Release()
+		if got := <-admitted; got != exp {
+			t.Fatalf("wrong priority admitted, exp %d, got %d", exp, got)
+		}
+	}
+	l.Release()
+	if exp, got := 0, l.Active(); exp != got {
+		t.Fatalf("wrong active count, exp %d, got %d", exp, got)
+	}
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	// MigrationsTable is the table in which the name of each applied migration
+	// is recorded.
+	MigrationsTable = "schema_migrations"
+
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (name TEXT NOT NULL PRIMARY KEY, applied_at TEXT NOT NULL)`
+	recordMigration       = `INSERT INTO schema_migrations(name, applied_at) VALUES(?, ?)`
+	selectMigrations      = `SELECT name FROM schema_migrations`
+)
+
+var (
+	// ErrNoMigrations is returned when a migration request is empty.
+	ErrNoMigrations = errors.New("no migrations")
+)
+
+// Migration is a named set of statements which changes the database, and
+// which is applied at most once.
+type Migration struct {
+	Name       string
+	Statements []*command.Statement
+}
+
+// ParseMigrations parses an ordered list of migrations, each a JSON object
+// with a name and statements, the statements taking any form accepted by
+// ParseRequest. Names must be unique.
+func ParseMigrations(b []byte, maxStmtBytes int) ([]*Migration, error) {
+	var raw []struct {
+		Name       string          `json:"name"`
+		Statements json.RawMessage `json:"statements"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	if len(raw) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	names := make(map[string]bool, len(raw))
+	migrations := make([]*Migration, len(raw))
+	for i := range raw {
+		if raw[i].Name == "" {
+			return nil, fmt.Errorf("migration %d has no name", i)
+		}
+		if names[raw[i].Name] {
+			return nil, fmt.Errorf("migration %s listed more than once", raw[i].Name)
+		}
+		names[raw[i].Name] = true
+
+		stmts, err := ParseRequestLimit(raw[i].Statements, maxStmtBytes)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", raw[i].Name, err)
+		}
+		migrations[i] = &Migration{
+			Name:       raw[i].Name,
+			Statements: stmts,
+		}
+	}
+	return migrations, nil
+}
+
+// migrationRequest returns the request which applies the migration, and
+// records it as applied at the given time, in a single transaction. The
+// migration is recorded before its statements run, so that if it has already
+// been recorded the transaction fails without running them.
+func migrationRequest(m *Migration, at time.Time) *command.ExecuteRequest {
+	stmts := []*command.Statement{
+		{
+			Sql: createMigrationsTable,
+		},
+		{
+			Sql: recordMigration,
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_S{S: m.Name}},
+				{Value: &command.Parameter_S{S: at.UTC().Format(time.RFC3339Nano)}},
+			},
+		},
+	}
+	return &command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements:  append(stmts, m.Statements...),
+		},
+	}
+}
+
+// migrationResult returns the error, if any, in the results of a request
+// returned by migrationRequest, and whether the migration had already been
+// recorded as applied. Only the results of the migration's own statements
+// are included in the error.
+func migrationResult(results []*command.ExecuteResult) (alreadyApplied bool, err error) {
+	for i, r := range results {
+		if r.Error == "" {
+			continue
+		}
+		if i == 1 && strings.HasPrefix(r.Error, "UNIQUE constraint failed: "+MigrationsTable+".name") {
+			return true, nil
+		}
+		if i < 2 {
+			return false, fmt.Errorf("recording migration: %s", r.Error)
+		}
+		return false, fmt.Errorf("statement %d: %s", i-2, r.Error)
+	}
+	return false, nil
+}
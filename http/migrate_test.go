@@ -0,0 +1,55 @@
+package http
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ParseMigrations(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		body  string
+		names []string
+		nStmt []int
+		err   bool
+	}{
+		{"Empty", `[]`, nil, nil, true},
+		{"Not JSON", `foo`, nil, nil, true},
+		{"Not a list", `{"name":"a","statements":["SELECT 1"]}`, nil, nil, true},
+		{"No name", `[{"statements":["SELECT 1"]}]`, nil, nil, true},
+		{"No statements", `[{"name":"a"}]`, nil, nil, true},
+		{"Empty statements", `[{"name":"a","statements":[]}]`, nil, nil, true},
+		{"Duplicate name", `[{"name":"a","statements":["SELECT 1"]},{"name":"a","statements":["SELECT 2"]}]`, nil, nil, true},
+		{"Single", `[{"name":"a","statements":["SELECT 1"]}]`, []string{"a"}, []int{1}, false},
+		{"Ordered", `[{"name":"b","statements":["SELECT 1","SELECT 2"]},{"name":"a","statements":[["SELECT ?", 1]]}]`, []string{"b", "a"}, []int{2, 1}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := ParseMigrations([]byte(tt.body), 0)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(migrations) != len(tt.names) {
+				t.Fatalf("wrong number of migrations, exp %d, got %d", len(tt.names), len(migrations))
+			}
+			for i, m := range migrations {
+				if m.Name != tt.names[i] {
+					t.Fatalf("wrong name for migration %d, exp %s, got %s", i, tt.names[i], m.Name)
+				}
+				if len(m.Statements) != tt.nStmt[i] {
+					t.Fatalf("wrong number of statements for migration %s, exp %d, got %d", m.Name, tt.nStmt[i], len(m.Statements))
+				}
+			}
+		})
+	}
+
+	_, err := ParseMigrations([]byte(`[{"name":"a","statements":["SELECT 1"]}]`), 4)
+	if !errors.Is(err, ErrStatementTooLarge) {
+		t.Fatalf("expected ErrStatementTooLarge, got %v", err)
+	}
+}
@@ -3,14 +3,23 @@ package http
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	clstrPB "github.com/rqlite/rqlite/v8/cluster/proto"
 	"github.com/rqlite/rqlite/v8/store"
 )
 
+// NodeMetaGetter is the interface that wraps the GetNodeMeta method.
+// GetNodeMeta returns the metadata of the node at the given Raft address.
+type NodeMetaGetter interface {
+	GetNodeMeta(addr string, timeout time.Duration) (*clstrPB.NodeMeta, error)
+}
+
 // Node represents a single node in the cluster and can include
 // information about the node's reachability and leadership status.
 // If there was an error communicating with the node, the Error
@@ -26,49 +35,93 @@ type Node struct {
 	TimeS     string  `json:"time_s,omitempty"`
 	Error     string  `json:"error,omitempty"`
 
+	// Suffrage is the Raft suffrage of the node, one of Voter, Nonvoter,
+	// or Staging.
+	Suffrage string `json:"suffrage,omitempty"`
+
+	// LastContact is, if reported by the Leader, the time the node last
+	// responded to the Leader, in RFC 3339 format.
+	LastContact string `json:"last_contact,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
 	mu sync.Mutex
 }
 
 // NewNodeFromServer creates a Node from a Server.
 func NewNodeFromServer(s *store.Server) *Node {
-	return &Node{
-		ID:    s.ID,
-		Addr:  s.Addr,
-		Voter: s.Suffrage == "Voter",
+	n := &Node{
+		ID:       s.ID,
+		Addr:     s.Addr,
+		Voter:    s.Suffrage == "Voter",
+		Suffrage: s.Suffrage,
+	}
+	if !s.LastContact.IsZero() {
+		n.LastContact = s.LastContact.UTC().Format(time.RFC3339Nano)
 	}
+	return n
 }
 
-// Test tests the node's reachability and leadership status. If an error
-// occurs, the Error field will be populated.
+// Test tests the node's reachability and leadership status, by asking the
+// node for its API address, and records the round-trip time of the request.
+// If ga also implements NodeMetaGetter the node's labels are retrieved too.
+// If an error occurs, or the node does not respond within the timeout, the
+// Error field will be populated.
 func (n *Node) Test(ga GetAddresser, leaderAddr string, timeout time.Duration) {
-	start := time.Now()
-	n.Time = time.Since(start).Seconds()
-	n.TimeS = time.Since(start).String()
 	n.Reachable = false
 	n.Leader = false
 
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
-	done := make(chan struct{})
+	type probe struct {
+		apiAddr string
+		labels  map[string]string
+		err     error
+	}
+	// Buffered, so a probe which responds after the timeout does not block.
+	ch := make(chan probe, 1)
+	start := time.Now()
 	go func() {
-		defer close(done)
-		apiAddr, err := ga.GetNodeAPIAddr(n.Addr, timeout)
-		if err != nil {
-			n.SetError(err.Error())
-			return
+		var p probe
+		if mg, ok := ga.(NodeMetaGetter); ok {
+			var meta *clstrPB.NodeMeta
+			if meta, p.err = mg.GetNodeMeta(n.Addr, timeout); p.err == nil {
+				p.apiAddr, p.labels = meta.Url, meta.Labels
+			}
+		} else {
+			p.apiAddr, p.err = ga.GetNodeAPIAddr(n.Addr, timeout)
 		}
-		n.APIAddr = apiAddr
-		n.Reachable = true
-		n.Leader = n.Addr == leaderAddr
+		ch <- p
 	}()
 
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	select {
 	case <-timer.C:
 		n.SetError("timeout waiting for node to respond")
-	case <-done:
+	case p := <-ch:
+		rtt := time.Since(start)
+		n.Time = rtt.Seconds()
+		n.TimeS = rtt.String()
+		if p.err != nil {
+			n.SetError(p.err.Error())
+			return
+		}
+		n.APIAddr = p.apiAddr
+		n.Labels = p.labels
+		n.Reachable = true
+		n.Leader = n.Addr == leaderAddr
 	}
 }
 
+// HasLabels returns whether the node has every one of the given labels.
+func (n *Node) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if nv, ok := n.Labels[k]; !ok || nv != v {
+			return false
+		}
+	}
+	return true
+}
+
 // SetError sets the Error field of the Node in a synchronized manner.
 func (n *Node) SetError(err string) {
 	n.mu.Lock()
@@ -116,6 +169,34 @@ func (n Nodes) Voters() Nodes {
 	return v
 }
 
+// NodeCounts summarises the suffrage of the nodes in a cluster, and how many
+// of the voters are reachable. The cluster can commit writes only while at
+// least Quorum voters are reachable.
+type NodeCounts struct {
+	Voters          int `json:"voters"`
+	NonVoters       int `json:"non_voters"`
+	ReachableVoters int `json:"reachable_voters"`
+	Quorum          int `json:"quorum"`
+}
+
+// Counts returns the counts of the nodes. Reachability is only known for
+// nodes which have been tested.
+func (n Nodes) Counts() *NodeCounts {
+	c := &NodeCounts{}
+	for _, node := range n {
+		if node.Voter {
+			c.Voters++
+			if node.Reachable {
+				c.ReachableVoters++
+			}
+		} else {
+			c.NonVoters++
+		}
+	}
+	c.Quorum = c.Voters/2 + 1
+	return c
+}
+
 // HasAddr returns whether any node in the Nodes slice has the given Raft address.
 func (n Nodes) HasAddr(addr string) bool {
 	for _, node := range n {
@@ -150,12 +231,32 @@ func (n Nodes) Test(ga GetAddresser, leaderAddr string, timeout time.Duration) {
 	wg.Wait()
 }
 
+// ParseLabels parses node labels given as comma-separated key=value pairs,
+// for example "role=analytics-replica,zone=us-east-1a". An empty string
+// is no labels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, must be key=value", kv)
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
 // NodesRespEncoder encodes Nodes into JSON with an option for legacy format.
 type NodesRespEncoder struct {
 	writer io.Writer
 	legacy bool
 	prefix string
 	indent string
+	counts *NodeCounts
 }
 
 // NewNodesRespEncoder creates a new NodesRespEncoder instance with the specified
@@ -173,6 +274,12 @@ func (e *NodesRespEncoder) SetIndent(prefix, indent string) {
 	e.indent = indent
 }
 
+// SetCounts sets the counts included alongside the nodes. Counts are not
+// included in the legacy format.
+func (e *NodesRespEncoder) SetCounts(c *NodeCounts) {
+	e.counts = c
+}
+
 // Encode takes a slice of Nodes and encodes it into JSON,
 // writing the output to the Encoder's writer.
 func (e *NodesRespEncoder) Encode(nodes Nodes) error {
@@ -205,9 +312,11 @@ func (e *NodesRespEncoder) Encode(nodes Nodes) error {
 // encode encodes the nodes in the standard format.
 func (e *NodesRespEncoder) encode(nodes Nodes) ([]byte, error) {
 	nodeOutput := &struct {
-		Nodes Nodes `json:"nodes"`
+		Nodes  Nodes       `json:"nodes"`
+		Counts *NodeCounts `json:"counts,omitempty"`
 	}{
-		Nodes: nodes,
+		Nodes:  nodes,
+		Counts: e.counts,
 	}
 	return json.Marshal(nodeOutput)
 }
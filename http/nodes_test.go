@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -18,6 +19,17 @@ func Test_NewNodeFromServer(t *testing.T) {
 	if node.ID != server.ID || node.Addr != server.Addr || !node.Voter {
 		t.Fatalf("NewNodeFromServer did not correctly initialize Node from Server")
 	}
+	if node.Suffrage != "Voter" {
+		t.Fatalf("wrong suffrage, exp Voter, got %s", node.Suffrage)
+	}
+	if node.LastContact != "" {
+		t.Fatalf("last contact set for server without one: %s", node.LastContact)
+	}
+
+	server.LastContact = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if exp, got := "2024-01-02T03:04:05Z", NewNodeFromServer(server).LastContact; exp != got {
+		t.Fatalf("wrong last contact, exp %s, got %s", exp, got)
+	}
 }
 
 func Test_NewNodesFromServers(t *testing.T) {
@@ -49,6 +61,19 @@ func Test_NodesVoters(t *testing.T) {
 	}
 }
 
+func Test_NodesCounts(t *testing.T) {
+	nodes := Nodes{
+		{ID: "1", Voter: true, Reachable: true},
+		{ID: "2", Voter: true, Reachable: false},
+		{ID: "3", Voter: true, Reachable: true},
+		{ID: "4", Voter: false, Reachable: true},
+	}
+	exp := &NodeCounts{Voters: 3, NonVoters: 1, ReachableVoters: 2, Quorum: 2}
+	if got := nodes.Counts(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong counts, exp %+v, got %+v", exp, got)
+	}
+}
+
 func Test_NodeTestLeader(t *testing.T) {
 	node := &Node{ID: "1", Addr: "leader-raft-addr", APIAddr: "leader-api-addr"}
 	mockGA := newMockGetAddresser("leader-api-addr", nil)
@@ -69,6 +94,23 @@ func Test_NodeTestNotLeader(t *testing.T) {
 	}
 }
 
+func Test_NodeTestRoundTrip(t *testing.T) {
+	node := &Node{ID: "1", Addr: "follower-raft-addr"}
+	mockGA := &mockGetAddresser{}
+	mockGA.getAddrFn = func(addr string, timeout time.Duration) (string, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "follower-api-addr", nil
+	}
+
+	node.Test(mockGA, "leader-raft-addr", 10*time.Second)
+	if !node.Reachable {
+		t.Fatalf("Test method did not correctly update node status %s", asJSON(node))
+	}
+	if node.Time < 0.1 || node.TimeS == "" {
+		t.Fatalf("round-trip time not measured, got %f (%s)", node.Time, node.TimeS)
+	}
+}
+
 func Test_NodeTestDouble(t *testing.T) {
 	node1 := &Node{ID: "1", Addr: "leader-raft-addr", APIAddr: "leader-api-addr"}
 	node2 := &Node{ID: "2", Addr: "follower-raft-addr", APIAddr: "follower-api-addr"}
@@ -154,6 +196,26 @@ func Test_NodesRespEncodeStandard(t *testing.T) {
 	checkNode(t, node)
 }
 
+func Test_NodesRespEncodeStandardCounts(t *testing.T) {
+	nodes := mockNodes()
+	buffer := new(bytes.Buffer)
+	encoder := NewNodesRespEncoder(buffer, false)
+	encoder.SetCounts(&NodeCounts{Voters: 1, ReachableVoters: 1, Quorum: 1})
+	if err := encoder.Encode(nodes); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var m struct {
+		Counts *NodeCounts `json:"counts"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &m); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if exp := (&NodeCounts{Voters: 1, ReachableVoters: 1, Quorum: 1}); !reflect.DeepEqual(exp, m.Counts) {
+		t.Fatalf("wrong counts, exp %+v, got %+v", exp, m.Counts)
+	}
+}
+
 func Test_NodeRespEncodeLegacy(t *testing.T) {
 	nodes := mockNodes()
 	buffer := new(bytes.Buffer)
@@ -293,3 +355,28 @@ func asJSON(v interface{}) string {
 	}
 	return string(b)
 }
+
+func Test_ParseLabels(t *testing.T) {
+	labels, err := ParseLabels("role=analytics-replica, zone=a")
+	if err != nil {
+		t.Fatalf("failed to parse labels: %s", err.Error())
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"role": "analytics-replica", "zone": "a"}) {
+		t.Fatalf("wrong labels: %v", labels)
+	}
+
+	node := &Node{Labels: labels}
+	if !node.HasLabels(map[string]string{"zone": "a"}) {
+		t.Fatalf("node does not have label it was given")
+	}
+	if node.HasLabels(map[string]string{"zone": "b"}) {
+		t.Fatalf("node has label it was not given")
+	}
+
+	if labels, err := ParseLabels(""); err != nil || len(labels) != 0 {
+		t.Fatalf("empty string did not parse to no labels: %v, %v", labels, err)
+	}
+	if _, err := ParseLabels("role"); err == nil {
+		t.Fatalf("expected error parsing label without value")
+	}
+}
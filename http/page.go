@@ -0,0 +1,104 @@
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/rqlite/v8/command/encoding"
+	command "github.com/rqlite/rqlite/v8/command/proto"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// pageAfterParam names the parameter holding the last key seen, if the
+// statement being paged uses named parameters.
+const pageAfterParam = "rqlite_page_after"
+
+var (
+	// ErrInvalidPageToken is returned when a page token cannot be decoded, or
+	// was issued for a different key column.
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// PageStatement returns a statement reading the page of at most size rows,
+// returned by the given statement, which follow the row whose key column
+// holds after. The rows are ordered by the key column. If after is nil the
+// first page is read.
+func PageStatement(stmt *command.Statement, key string, size int, after *command.Parameter) *command.Statement {
+	sql := strings.TrimRight(strings.TrimSpace(stmt.Sql), ";")
+	col := encoding.QuoteIdentifier(key)
+	params := append([]*command.Parameter(nil), stmt.Parameters...)
+
+	where := ""
+	if after != nil {
+		p := &command.Parameter{Value: after.Value}
+		if hasNamedParameters(stmt) {
+			p.Name = pageAfterParam
+			where = fmt.Sprintf(" WHERE %s > :%s", col, pageAfterParam)
+		} else {
+			where = fmt.Sprintf(" WHERE %s > ?", col)
+		}
+		params = append(params, p)
+	}
+	return &command.Statement{
+		Sql:        fmt.Sprintf("SELECT * FROM (%s)%s ORDER BY %s LIMIT %d", sql, where, col, size),
+		Parameters: params,
+	}
+}
+
+// NextPageToken returns the token for the page following the given rows,
+// read by a statement from PageStatement, or an empty string if there are
+// no more rows. Rows truncated short of the page size are followed by a
+// page holding the rest.
+func NextPageToken(rows *command.QueryRows, key string, size int) (string, error) {
+	if rows.Error != "" || len(rows.Values) == 0 || (len(rows.Values) < size && !rows.Truncated) {
+		return "", nil
+	}
+	idx := -1
+	for i, c := range rows.Columns {
+		if c == key {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return "", fmt.Errorf("page key column %s not in results", key)
+	}
+	last := rows.Values[len(rows.Values)-1].Parameters
+	if idx >= len(last) || last[idx].Value == nil {
+		return "", fmt.Errorf("page key column %s is NULL", key)
+	}
+	b, err := pb.Marshal(&command.Parameter{
+		Value: last[idx].Value,
+		Name:  key,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodePageToken returns the last key seen, as held by a token returned by
+// NextPageToken for the given key column.
+func DecodePageToken(token, key string) (*command.Parameter, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var p command.Parameter
+	if err := pb.Unmarshal(b, &p); err != nil || p.Value == nil || p.Name != key {
+		return nil, ErrInvalidPageToken
+	}
+	return &p, nil
+}
+
+// hasNamedParameters returns whether any parameter of the statement is named.
+func hasNamedParameters(stmt *command.Statement) bool {
+	for _, p := range stmt.Parameters {
+		if p.Name != "" {
+			return true
+		}
+	}
+	return false
+}
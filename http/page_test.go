@@ -0,0 +1,100 @@
+package http
+
+import (
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_PageStatement(t *testing.T) {
+	stmt := &command.Statement{
+		Sql: "SELECT * FROM foo WHERE age > ?;",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_I{I: 20}},
+		},
+	}
+
+	p := PageStatement(stmt, "id", 10, nil)
+	if exp, got := `SELECT * FROM (SELECT * FROM foo WHERE age > ?) ORDER BY "id" LIMIT 10`, p.Sql; exp != got {
+		t.Fatalf("wrong first page SQL, exp %s, got %s", exp, got)
+	}
+	if len(p.Parameters) != 1 {
+		t.Fatalf("wrong number of parameters, exp 1, got %d", len(p.Parameters))
+	}
+
+	after := &command.Parameter{Value: &command.Parameter_I{I: 5}}
+	p = PageStatement(stmt, "id", 10, after)
+	if exp, got := `SELECT * FROM (SELECT * FROM foo WHERE age > ?) WHERE "id" > ? ORDER BY "id" LIMIT 10`, p.Sql; exp != got {
+		t.Fatalf("wrong next page SQL, exp %s, got %s", exp, got)
+	}
+	if len(p.Parameters) != 2 || p.Parameters[1].GetI() != 5 {
+		t.Fatalf("wrong parameters for next page: %v", p.Parameters)
+	}
+	if len(stmt.Parameters) != 1 {
+		t.Fatalf("original statement modified")
+	}
+
+	named := &command.Statement{
+		Sql: "SELECT * FROM foo WHERE age > :age",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_I{I: 20}, Name: "age"},
+		},
+	}
+	p = PageStatement(named, "id", 10, after)
+	if exp, got := `SELECT * FROM (SELECT * FROM foo WHERE age > :age) WHERE "id" > :rqlite_page_after ORDER BY "id" LIMIT 10`, p.Sql; exp != got {
+		t.Fatalf("wrong next page SQL, exp %s, got %s", exp, got)
+	}
+	if p.Parameters[1].Name != pageAfterParam {
+		t.Fatalf("wrong name for page parameter, got %s", p.Parameters[1].Name)
+	}
+}
+
+func Test_PageToken(t *testing.T) {
+	rows := &command.QueryRows{
+		Columns: []string{"id", "name"},
+		Values: []*command.Values{
+			{Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: "a"}}, {Value: &command.Parameter_S{S: "fiona"}}}},
+			{Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: "b"}}, {Value: &command.Parameter_S{S: "declan"}}}},
+		},
+	}
+
+	// A short page is the last.
+	token, err := NextPageToken(rows, "id", 3)
+	if err != nil {
+		t.Fatalf("failed to get token: %s", err.Error())
+	}
+	if token != "" {
+		t.Fatalf("expected no token for last page, got %s", token)
+	}
+
+	token, err = NextPageToken(rows, "id", 2)
+	if err != nil {
+		t.Fatalf("failed to get token: %s", err.Error())
+	}
+	if token == "" {
+		t.Fatalf("expected token for full page")
+	}
+	after, err := DecodePageToken(token, "id")
+	if err != nil {
+		t.Fatalf("failed to decode token: %s", err.Error())
+	}
+	if exp, got := "b", after.GetS(); exp != got {
+		t.Fatalf("wrong last key, exp %s, got %s", exp, got)
+	}
+
+	// A truncated page is followed by the rest.
+	rows.Truncated = true
+	if token, err := NextPageToken(rows, "id", 3); err != nil || token == "" {
+		t.Fatalf("expected token for truncated page, got %q, %v", token, err)
+	}
+
+	if _, err := DecodePageToken(token, "name"); err != ErrInvalidPageToken {
+		t.Fatalf("expected ErrInvalidPageToken for different key, got %v", err)
+	}
+	if _, err := DecodePageToken("!!!", "id"); err != ErrInvalidPageToken {
+		t.Fatalf("expected ErrInvalidPageToken for bad token, got %v", err)
+	}
+	if _, err := NextPageToken(rows, "age", 2); err == nil {
+		t.Fatalf("expected error for key not in results")
+	}
+}
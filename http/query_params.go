@@ -31,7 +31,7 @@ func NewQueryParams(r *http.Request) (QueryParams, error) {
 			return nil, fmt.Errorf("freshness_strict requires freshness")
 		}
 	}
-	for _, k := range []string{"timeout", "freshness", "db_timeout"} {
+	for _, k := range []string{"timeout", "freshness", "db_timeout", "interval"} {
 		t, ok := qp[k]
 		if ok {
 			_, err := time.ParseDuration(t)
@@ -40,7 +40,7 @@ func NewQueryParams(r *http.Request) (QueryParams, error) {
 			}
 		}
 	}
-	for _, k := range []string{"retries"} {
+	for _, k := range []string{"retries", "limit", "offset", "chunk_rows", "page_size", "batch_size"} {
 		r, ok := qp[k]
 		if ok {
 			_, err := strconv.Atoi(r)
@@ -49,8 +49,45 @@ func NewQueryParams(r *http.Request) (QueryParams, error) {
 			}
 		}
 	}
-	q, ok := qp["q"]
-	if ok {
+	if _, ok := qp["page_size"]; ok {
+		if qp.PageSize() < 1 {
+			return nil, fmt.Errorf("page_size must be at least 1")
+		}
+		if qp.PageKey() == "" {
+			return nil, fmt.Errorf("page_size requires page_key")
+		}
+	}
+	if _, ok := qp["page_token"]; ok {
+		if _, ok := qp["page_size"]; !ok {
+			return nil, fmt.Errorf("page_token requires page_size")
+		}
+	}
+	if c, ok := qp["compress"]; ok {
+		switch c {
+		case "", "true", "gzip", "zstd", "none":
+		default:
+			return nil, fmt.Errorf("compress must be one of gzip, zstd, or none")
+		}
+	}
+	if r, ok := qp["rollup"]; ok {
+		if _, err := ParseRollup(r); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := qp["batch_size"]; ok && qp.BatchSize(0) < 1 {
+		return nil, fmt.Errorf("batch_size must be at least 1")
+	}
+	if c, ok := qp["columns"]; ok {
+		if _, err := ParseColumnMap(c); err != nil {
+			return nil, err
+		}
+	}
+	if l, ok := qp["label"]; ok {
+		if _, err := ParseLabels(l); err != nil {
+			return nil, err
+		}
+	}
+	for _, q := range values["q"] {
 		if q == "" {
 			return nil, fmt.Errorf("query parameter not set")
 		}
@@ -63,11 +100,40 @@ func (qp QueryParams) Timings() bool {
 	return qp.HasKey("timings")
 }
 
+// DebugSQL returns true if the query parameters request the statements be
+// returned with their parameters interpolated.
+func (qp QueryParams) DebugSQL() bool {
+	return qp.HasKey("debug_sql")
+}
+
+// Rollup returns the aggregates to compute over the rows returned by each
+// query, or nil if none were requested.
+func (qp QueryParams) Rollup() []*Rollup {
+	r, ok := qp["rollup"]
+	if !ok {
+		return nil
+	}
+	rollups, _ := ParseRollup(r) // Validated when the params were parsed.
+	return rollups
+}
+
 // Tx returns true if the query parameters indicate the query should be executed in a transaction.
 func (qp QueryParams) Tx() bool {
 	return qp.HasKey("transaction")
 }
 
+// StopOnError returns true if the query parameters request that execution of
+// a request stop at the first statement which fails.
+func (qp QueryParams) StopOnError() bool {
+	return qp.HasKey("stop_on_error")
+}
+
+// Conditional returns true if the query parameters request that each INSERT,
+// UPDATE, or DELETE statement change at least one row, or fail.
+func (qp QueryParams) Conditional() bool {
+	return qp.HasKey("conditional")
+}
+
 // Query returns true if the query parameters request queued operation
 func (qp QueryParams) Queue() bool {
 	return qp.HasKey("queue")
@@ -113,6 +179,25 @@ func (qp QueryParams) NoLeader() bool {
 	return qp.HasKey("noleader")
 }
 
+// NoForward returns true if the query parameters request that a read this
+// node cannot serve within the requested freshness is not forwarded to the
+// Leader.
+func (qp QueryParams) NoForward() bool {
+	return qp.HasKey("noforward")
+}
+
+// DryRun returns true if the query parameters request that statements be
+// validated, but not executed.
+func (qp QueryParams) DryRun() bool {
+	return qp.HasKey("dry_run")
+}
+
+// Explain returns true if the query parameters request the query plan of
+// each query, instead of its results.
+func (qp QueryParams) Explain() bool {
+	return qp.HasKey("explain")
+}
+
 // Redirect returns true if the query parameters request redirect mode.
 func (qp QueryParams) Redirect() bool {
 	return qp.HasKey("redirect")
@@ -123,9 +208,61 @@ func (qp QueryParams) Vacuum() bool {
 	return qp.HasKey("vacuum")
 }
 
-// Compress returns true if the query parameters request compression.
+// Compress returns true if the query parameters request gzip compression.
 func (qp QueryParams) Compress() bool {
-	return qp.HasKey("compress")
+	return qp.Compression() == "gzip"
+}
+
+// Compression returns the compression algorithm requested by the query
+// parameters, either "gzip" or "zstd". An empty string means no compression.
+// For backwards compatibility, "compress" with no value, or with the value
+// "true", means gzip.
+func (qp QueryParams) Compression() string {
+	c, ok := qp["compress"]
+	if !ok {
+		return ""
+	}
+	switch c {
+	case "", "true", "gzip":
+		return "gzip"
+	case "zstd":
+		return "zstd"
+	}
+	return ""
+}
+
+// Format returns the requested format of query results.
+func (qp QueryParams) Format() string {
+	return qp["format"]
+}
+
+// Labels returns the node labels, given as comma-separated key=value pairs,
+// which nodes must have to serve the request.
+func (qp QueryParams) Labels() map[string]string {
+	l, _ := ParseLabels(qp["label"])
+	return l
+}
+
+// Table returns the value of the key named "table".
+func (qp QueryParams) Table() string {
+	return qp["table"]
+}
+
+// ColumnMap returns the mapping of CSV header names to table columns, given
+// by the key named "columns".
+func (qp QueryParams) ColumnMap() map[string]string {
+	m, _ := ParseColumnMap(qp["columns"])
+	return m
+}
+
+// BatchSize returns the requested number of rows inserted by each statement.
+func (qp QueryParams) BatchSize(def int) int {
+	b, ok := qp["batch_size"]
+	if !ok {
+		return def
+	}
+	n, _ := strconv.Atoi(b)
+	return n
 }
 
 // Key returns the value of the key named "key".
@@ -169,7 +306,8 @@ func (qp QueryParams) BackupFormat() command.BackupRequest_Format {
 	}
 }
 
-// Query returns the requested query.
+// Query returns the requested query. If the q parameter is repeated, the
+// first is returned.
 func (qp QueryParams) Query() string {
 	return qp["q"]
 }
@@ -201,6 +339,16 @@ func (qp QueryParams) Timeout(def time.Duration) time.Duration {
 	return d
 }
 
+// Interval returns the requested sampling interval.
+func (qp QueryParams) Interval(def time.Duration) time.Duration {
+	t, ok := qp["interval"]
+	if !ok {
+		return def
+	}
+	d, _ := time.ParseDuration(t)
+	return d
+}
+
 // Retries returns the requested number of retries.
 func (qp QueryParams) Retries(def int) int {
 	i, ok := qp["retries"]
@@ -211,11 +359,66 @@ func (qp QueryParams) Retries(def int) int {
 	return r
 }
 
+// Limit returns the requested maximum number of rows.
+func (qp QueryParams) Limit(def int) int {
+	i, ok := qp["limit"]
+	if !ok {
+		return def
+	}
+	l, _ := strconv.Atoi(i)
+	return l
+}
+
+// PageSize returns the requested number of rows in a page of query results,
+// or 0 if paging was not requested.
+func (qp QueryParams) PageSize() int {
+	n, _ := strconv.Atoi(qp["page_size"])
+	return n
+}
+
+// PageKey returns the column by which query results are paged.
+func (qp QueryParams) PageKey() string {
+	return qp["page_key"]
+}
+
+// PageToken returns the token identifying the page of query results to read,
+// or an empty string for the first page.
+func (qp QueryParams) PageToken() string {
+	return qp["page_token"]
+}
+
+// ChunkRows returns the requested number of rows written between flushes
+// of a streamed response.
+func (qp QueryParams) ChunkRows(def int) int {
+	i, ok := qp["chunk_rows"]
+	if !ok {
+		return def
+	}
+	c, _ := strconv.Atoi(i)
+	return c
+}
+
+// Offset returns the requested number of rows to skip.
+func (qp QueryParams) Offset() int {
+	o, _ := strconv.Atoi(qp["offset"])
+	return o
+}
+
+// OrderBy returns the name of the column by which rows should be ordered.
+func (qp QueryParams) OrderBy() string {
+	return qp["order_by"]
+}
+
 // Version returns the requested version.
 func (qp QueryParams) Version() string {
 	return qp["ver"]
 }
 
+// Since returns the ID of the event after which events should be sent.
+func (qp QueryParams) Since() string {
+	return qp["since"]
+}
+
 // HasKey returns true if the given key is present in the query parameters.
 func (qp QueryParams) HasKey(k string) bool {
 	_, ok := qp[k]
@@ -20,9 +20,13 @@ func Test_NewQueryParams(t *testing.T) {
 		{"Invalid Timeout", "timeout=invalid", nil, true},
 		{"Invalid Retry", "retries=invalid", nil, true},
 		{"Valid Retry", "retries=4", QueryParams{"retries": "4"}, false},
+		{"Invalid Chunk Rows", "chunk_rows=many", nil, true},
+		{"Valid Chunk Rows", "chunk_rows=50", QueryParams{"chunk_rows": "50"}, false},
 		{"Empty Q", "q=", nil, true},
 		{"Invalid Q", "q", nil, true},
 		{"Valid Q, no case changes", "q=SELeCT", QueryParams{"q": "SELeCT"}, false},
+		{"Multiple Q", "q=SELECT+1&q=SELECT+2", QueryParams{"q": "SELECT 1"}, false},
+		{"Multiple Q, one empty", "q=SELECT+1&q=", nil, true},
 		{"Multiple Values", "key1=value1&key2=value2", QueryParams{"key1": "value1", "key2": "value2"}, false},
 		{"Mixed Case Keys", "KeyOne=value1&keyTwo=value2", QueryParams{"KeyOne": "value1", "keyTwo": "value2"}, false},
 		{"Numeric Values", "num=1234", QueryParams{"num": "1234"}, false},
@@ -34,6 +38,23 @@ func Test_NewQueryParams(t *testing.T) {
 		{"freshness_strict", "&freshness=5s&freshness_strict", QueryParams{"freshness_strict": "", "freshness": "5s"}, false},
 		{"freshness_strict requires freshness", "freshness_strict", nil, true},
 		{"Sync with timeout", "sync&timeout=2s", QueryParams{"sync": "", "timeout": "2s"}, false},
+		{"Compress gzip", "compress=gzip", QueryParams{"compress": "gzip"}, false},
+		{"Compress zstd", "compress=zstd", QueryParams{"compress": "zstd"}, false},
+		{"Compress none", "compress=none", QueryParams{"compress": "none"}, false},
+		{"Compress no value", "compress", QueryParams{"compress": ""}, false},
+		{"Invalid compress", "compress=lz4", nil, true},
+		{"Label", "label=role=replica,zone=a", QueryParams{"label": "role=replica,zone=a"}, false},
+		{"Invalid label", "label=role", nil, true},
+		{"Invalid interval", "interval=often", nil, true},
+		{"Rollup", "rollup=sum(price),count(*)", QueryParams{"rollup": "sum(price),count(*)"}, false},
+		{"Invalid rollup", "rollup=median(price)", nil, true},
+		{"Limit and offset", "limit=10&offset=20", QueryParams{"limit": "10", "offset": "20"}, false},
+		{"Invalid limit", "limit=ten", nil, true},
+		{"Page", "page_size=10&page_key=id&page_token=abc", QueryParams{"page_size": "10", "page_key": "id", "page_token": "abc"}, false},
+		{"Invalid page size", "page_size=ten&page_key=id", nil, true},
+		{"Zero page size", "page_size=0&page_key=id", nil, true},
+		{"Page size requires key", "page_size=10", nil, true},
+		{"Page token requires size", "page_key=id&page_token=abc", nil, true},
 		{"Byte array with associative", "byte_array&associative", QueryParams{"byte_array": "", "associative": ""}, false},
 	}
 
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/auth"
+)
+
+// rateLimitSweepInterval is how often buckets which have not been used
+// for a while are discarded.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds the tokens available to one client, for one kind of
+// request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter admits requests at a given rate per client, using a token
+// bucket for each client. Each bucket holds up to one second's worth of
+// tokens, and at least one, so a client may burst up to its rate. It is
+// safe for concurrent use.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter returns a new rateLimiter.
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// allow takes a token from the bucket with the given key, which is refilled
+// at rate tokens per second. If no token is available it returns false, and
+// how long until one will be.
+func (rl *rateLimiter) allow(key string, rate float64) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	rl.sweep(now)
+
+	burst := math.Max(1, math.Ceil(rate))
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// sweep discards the buckets which have not been used since the last sweep.
+// A discarded bucket is recreated full, so a bucket may only be discarded
+// once it would have refilled, which a minute is enough for at any rate
+// worth limiting by.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	for k, b := range rl.buckets {
+		if b.last.Before(rl.lastSweep) {
+			delete(rl.buckets, k)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// len returns the number of buckets held.
+func (rl *rateLimiter) len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+// isWriteRequest returns whether the given request changes the database,
+// and so is admitted at the write rate.
+func isWriteRequest(r *http.Request) bool {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/db/execute"), strings.HasPrefix(r.URL.Path, "/db/load"):
+		return true
+	case strings.HasPrefix(r.URL.Path, "/db/tables/"):
+		return r.Method != http.MethodGet
+	}
+	switch r.URL.Path {
+	case "/db/request", "/db/queue", "/db/migrate", "/db/vacuum", "/db/import", "/boot":
+		return true
+	}
+	return false
+}
+
+// isRateLimited returns whether the given request is subject to rate limits.
+func isRateLimited(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/db/") || r.URL.Path == "/boot" || r.URL.Path == "/cluster/query"
+}
+
+// rateLimitKey returns the key of the bucket from which the given request
+// takes a token, and the rates which apply to it. Authenticated users are
+// limited by user, using the rates configured for them if any, and other
+// clients by IP address.
+func (s *Service) rateLimitKey(r *http.Request) (string, auth.RateLimit) {
+	rates := auth.RateLimit{
+		Reads:  s.RateLimitReads,
+		Writes: s.RateLimitWrites,
+	}
+	if username, ok := s.authenticatedUsername(r); ok {
+		if rl, ok := s.credentialStore.RateLimit(username); ok {
+			rates = rl
+		}
+		return "user:" + username, rates
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, rates
+}
+
+// checkRateLimit returns whether the given request is within its rate
+// limit. If it is not, a 429 Too Many Requests response is written, with a
+// Retry-After header telling the client when it may retry.
+func (s *Service) checkRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if !isRateLimited(r) {
+		return true
+	}
+	key, rates := s.rateLimitKey(r)
+	rate, kind := rates.Reads, "read"
+	if isWriteRequest(r) {
+		rate, kind = rates.Writes, "write"
+	}
+	if rate <= 0 {
+		return true
+	}
+	ok, wait := s.rateLimiter.allow(kind+":"+key, rate)
+	if ok {
+		return true
+	}
+	stats.Add(numRateLimited, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "rate limit exceeded for "+kind+" requests", http.StatusTooManyRequests)
+	return false
+}
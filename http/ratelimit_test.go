@@ -0,0 +1,92 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_RateLimiterAllow(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter()
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow("a", 2); !ok {
+			t.Fatalf("request %d within burst not allowed", i)
+		}
+	}
+	ok, wait := rl.allow("a", 2)
+	if ok {
+		t.Fatalf("request over burst allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("wrong wait, exp 500ms, got %s", wait)
+	}
+	if ok, _ := rl.allow("b", 2); !ok {
+		t.Fatalf("request on other key not allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := rl.allow("a", 2); !ok {
+		t.Fatalf("request not allowed after refill")
+	}
+	if ok, _ := rl.allow("a", 2); ok {
+		t.Fatalf("request allowed beyond refill")
+	}
+
+	// Rates below one per second still admit a single request.
+	if ok, _ := rl.allow("c", 0.5); !ok {
+		t.Fatalf("first request at fractional rate not allowed")
+	}
+	if ok, wait := rl.allow("c", 0.5); ok || wait != 2*time.Second {
+		t.Fatalf("wrong result at fractional rate, ok %t, wait %s", ok, wait)
+	}
+}
+
+func Test_RateLimiterSweep(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter()
+	rl.now = func() time.Time { return now }
+	rl.lastSweep = now
+
+	now = now.Add(time.Second)
+	rl.allow("a", 1)
+	rl.allow("b", 1)
+	now = now.Add(rateLimitSweepInterval)
+	rl.allow("b", 1)
+	if n := rl.len(); n != 2 {
+		t.Fatalf("buckets used since last sweep discarded, exp 2, got %d", n)
+	}
+	now = now.Add(rateLimitSweepInterval)
+	rl.allow("c", 1)
+	if n := rl.len(); n != 2 {
+		t.Fatalf("unused bucket not discarded, exp 2, got %d", n)
+	}
+	if _, ok := rl.buckets["a"]; ok {
+		t.Fatalf("unused bucket not discarded")
+	}
+}
+
+func Test_IsWriteRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method string
+		path   string
+		exp    bool
+	}{
+		{"POST", "/db/execute", true},
+		{"POST", "/db/load", true},
+		{"POST", "/db/request", true},
+		{"POST", "/boot", true},
+		{"POST", "/db/query", false},
+		{"GET", "/db/query", false},
+		{"GET", "/db/backup", false},
+		{"GET", "/db/tables/foo", false},
+		{"DELETE", "/db/tables/foo", true},
+	} {
+		r, _ := http.NewRequest(tt.method, tt.path, nil)
+		if got := isWriteRequest(r); got != tt.exp {
+			t.Fatalf("wrong result for %s %s, exp %t, got %t", tt.method, tt.path, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// redactedValue replaces each parameter value removed from an error.
+const redactedValue = "[REDACTED]"
+
+// redactor removes the values of the parameters bound to a set of statements
+// from error strings. A nil redactor leaves strings unchanged.
+type redactor struct {
+	values  *strings.Replacer // Removes text values wherever they appear.
+	numbers []string          // Numeric values, removed only where they stand alone.
+}
+
+// newRedactor returns a redactor for the parameters of the given
+// statements, or nil if there are none.
+func newRedactor(stmts []*command.Statement) *redactor {
+	var values, numbers []string
+	for _, stmt := range stmts {
+		for _, p := range stmt.Parameters {
+			switch v := p.GetValue().(type) {
+			case *command.Parameter_S:
+				values = append(values, v.S)
+			case *command.Parameter_Y:
+				values = append(values, string(v.Y), hex.EncodeToString(v.Y),
+					strings.ToUpper(hex.EncodeToString(v.Y)))
+			case *command.Parameter_I:
+				numbers = append(numbers, strconv.FormatInt(v.I, 10))
+			case *command.Parameter_D:
+				numbers = append(numbers, strconv.FormatFloat(v.D, 'g', -1, 64))
+			}
+		}
+	}
+	if len(values) == 0 && len(numbers) == 0 {
+		return nil
+	}
+
+	// Remove longer values first, so a value is not left partly in place
+	// because it contains another.
+	byLen := func(a []string) {
+		sort.SliceStable(a, func(i, j int) bool { return len(a[i]) > len(a[j]) })
+	}
+	byLen(values)
+	byLen(numbers)
+	var oldnew []string
+	for _, v := range values {
+		if v != "" {
+			oldnew = append(oldnew, v, redactedValue)
+		}
+	}
+	return &redactor{
+		values:  strings.NewReplacer(oldnew...),
+		numbers: numbers,
+	}
+}
+
+// redactor returns the redactor for the parameters of the given statements,
+// or nil if parameters are not to be redacted.
+func (s *Service) redactor(stmts []*command.Statement) *redactor {
+	if !s.RedactParameters {
+		return nil
+	}
+	return newRedactor(stmts)
+}
+
+// Redact returns s with the parameter values removed.
+func (rd *redactor) Redact(s string) string {
+	if rd == nil || s == "" {
+		return s
+	}
+	s = rd.values.Replace(s)
+	for _, n := range rd.numbers {
+		s = replaceNumber(s, n)
+	}
+	return s
+}
+
+// RedactResponse removes the parameter values from every error in resp.
+func (rd *redactor) RedactResponse(resp *Response) {
+	if rd == nil {
+		return
+	}
+	resp.Error = rd.Redact(resp.Error)
+	if resp.Results == nil {
+		return
+	}
+	for _, r := range resp.Results.ExecuteResult {
+		r.Error = rd.Redact(r.Error)
+	}
+	for _, r := range resp.Results.QueryRows {
+		r.Error = rd.Redact(r.Error)
+	}
+	for _, r := range resp.Results.ExecuteQueryResponse {
+		switch v := r.GetResult().(type) {
+		case *command.ExecuteQueryResponse_Error:
+			v.Error = rd.Redact(v.Error)
+		case *command.ExecuteQueryResponse_E:
+			v.E.Error = rd.Redact(v.E.Error)
+		case *command.ExecuteQueryResponse_Q:
+			v.Q.Error = rd.Redact(v.Q.Error)
+		}
+	}
+}
+
+// replaceNumber replaces each occurrence of the number n in s which is not
+// part of a longer number or word.
+func replaceNumber(s, n string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, n)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(n)
+		before := i > 0 && (isWordChar(s[i-1]) || s[i-1] == '.' || s[i-1] == '-')
+		after := end < len(s) && (isWordChar(s[end]) ||
+			(s[end] == '.' && end+1 < len(s) && isWordChar(s[end+1])))
+		if before || after {
+			b.WriteString(s[:end])
+		} else {
+			b.WriteString(s[:i])
+			b.WriteString(redactedValue)
+		}
+		s = s[end:]
+	}
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"strings"
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_RedactorNoParameters(t *testing.T) {
+	if rd := newRedactor([]*command.Statement{{Sql: "SELECT 1"}}); rd != nil {
+		t.Fatalf("expected nil redactor for statements without parameters")
+	}
+	var rd *redactor
+	if got := rd.Redact("error: secret"); got != "error: secret" {
+		t.Fatalf("nil redactor changed string, got %s", got)
+	}
+	rd.RedactResponse(NewResponse())
+}
+
+func Test_Redactor(t *testing.T) {
+	rd := newRedactor([]*command.Statement{
+		{
+			Sql: "INSERT INTO foo VALUES(?, ?, ?, ?)",
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_S{S: "alice@example.com"}},
+				{Value: &command.Parameter_S{S: "alice"}},
+				{Value: &command.Parameter_I{I: 42}},
+				{Value: &command.Parameter_Y{Y: []byte{0xde, 0xad}}},
+			},
+		},
+		{
+			Sql: "INSERT INTO bar VALUES(:x)",
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_D{D: 3.5}, Name: "x"},
+			},
+		},
+	})
+	for _, tt := range []struct {
+		in  string
+		exp string
+	}{
+		{"", ""},
+		{"no such table: foo", "no such table: foo"},
+		{"bad email alice@example.com", "bad email [REDACTED]"},
+		{"alice, not alice2", "[REDACTED], not [REDACTED]2"},
+		{"value 42 too large", "value [REDACTED] too large"},
+		{"value 42.", "value [REDACTED]."},
+		{"statement 420, 142, 42.1, x42", "statement 420, 142, 42.1, x42"},
+		{"blob X'DEAD' or dead", "blob X'[REDACTED]' or [REDACTED]"},
+		{"got 3.5", "got [REDACTED]"},
+	} {
+		if got := rd.Redact(tt.in); got != tt.exp {
+			t.Fatalf("wrong redaction of %q, exp %q, got %q", tt.in, tt.exp, got)
+		}
+	}
+}
+
+func Test_RedactorResponse(t *testing.T) {
+	rd := newRedactor([]*command.Statement{
+		{
+			Sql:        "SELECT * FROM foo WHERE name=?",
+			Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: "secret"}}},
+		},
+	})
+	resp := NewResponse()
+	resp.Error = "failed on secret"
+	resp.Results.ExecuteResult = []*command.ExecuteResult{{Error: "secret 1"}}
+	resp.Results.QueryRows = []*command.QueryRows{{Error: "secret 2"}}
+	resp.Results.ExecuteQueryResponse = []*command.ExecuteQueryResponse{
+		{Result: &command.ExecuteQueryResponse_Error{Error: "secret 3"}},
+		{Result: &command.ExecuteQueryResponse_E{E: &command.ExecuteResult{Error: "secret 4"}}},
+		{Result: &command.ExecuteQueryResponse_Q{Q: &command.QueryRows{Error: "secret 5"}}},
+	}
+	rd.RedactResponse(resp)
+
+	errs := []string{
+		resp.Error,
+		resp.Results.ExecuteResult[0].Error,
+		resp.Results.QueryRows[0].Error,
+		resp.Results.ExecuteQueryResponse[0].GetError(),
+		resp.Results.ExecuteQueryResponse[1].GetE().Error,
+		resp.Results.ExecuteQueryResponse[2].GetQ().Error,
+	}
+	for i, e := range errs {
+		if e == "" || strings.Contains(e, "secret") {
+			t.Fatalf("error %d not redacted: %q", i, e)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	// RequestIDHTTPHeader is the HTTP header carrying the ID of a request. A
+	// client may set it, and otherwise an ID is assigned. Either way it is
+	// returned on the response, included in any log lines for the request,
+	// and passed on with any request forwarded to the Leader.
+	RequestIDHTTPHeader = "X-Request-ID"
+
+	// maxRequestIDLen is the maximum length of a client-supplied request ID.
+	maxRequestIDLen = 128
+)
+
+// assignRequestID sets the ID of the given request, reusing any valid ID
+// supplied by the client, and returns it.
+func assignRequestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHTTPHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+		r.Header.Set(RequestIDHTTPHeader, id)
+	}
+	return id
+}
+
+// requestID returns the ID assigned to the given request.
+func requestID(r *http.Request) string {
+	return r.Header.Get(RequestIDHTTPHeader)
+}
+
+// setRequestID records the ID of the HTTP request in the given request,
+// unless it already carries one, so the store can include it in its logs.
+func setRequestID(req *command.Request, r *http.Request) {
+	if req != nil && req.RequestId == "" {
+		req.RequestId = requestID(r)
+	}
+}
+
+// validRequestID returns whether id may be used as a request ID. IDs must
+// be non-empty, not too long, and made up of printable ASCII characters, so
+// they can't corrupt log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate request ID: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// logRequestf logs a line about the given request, prefixed with its ID.
+func (s *Service) logRequestf(r *http.Request, format string, v ...interface{}) {
+	s.logger.Printf("[%s] %s", requestID(r), fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func Test_NewRequestID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newRequestID()
+		if !uuidRegex.MatchString(id) {
+			t.Fatalf("request ID %s is not a version 4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("request ID %s generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func Test_ValidRequestID(t *testing.T) {
+	for _, tt := range []struct {
+		id  string
+		exp bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{strings.Repeat("a", maxRequestIDLen), true},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+		{"has space", false},
+		{"new\nline", false},
+		{"café", false},
+	} {
+		if got := validRequestID(tt.id); got != tt.exp {
+			t.Fatalf("wrong validity for %q, exp %t, got %t", tt.id, tt.exp, got)
+		}
+	}
+}
+
+func Test_AssignRequestID(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/status", nil)
+	r.Header.Set(RequestIDHTTPHeader, "abc-123")
+	if got := assignRequestID(r); got != "abc-123" {
+		t.Fatalf("client request ID not reused, got %s", got)
+	}
+
+	r.Header.Set(RequestIDHTTPHeader, "bad id")
+	id := assignRequestID(r)
+	if !uuidRegex.MatchString(id) {
+		t.Fatalf("invalid client request ID not replaced, got %s", id)
+	}
+	if got := requestID(r); got != id {
+		t.Fatalf("assigned request ID not recorded, exp %s, got %s", id, got)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 
+	cmd "github.com/rqlite/rqlite/v8/command"
 	command "github.com/rqlite/rqlite/v8/command/proto"
 )
 
@@ -21,10 +23,29 @@ var (
 
 	// ErrUnsupportedType is returned when a request contains an unsupported type.
 	ErrUnsupportedType = errors.New("unsupported type")
+
+	// ErrMixedParameters is returned when a statement has both positional
+	// and named parameters.
+	ErrMixedParameters = errors.New("statement mixes positional and named parameters")
+
+	// ErrStatementTooLarge is returned when the SQL of a statement is longer
+	// than permitted.
+	ErrStatementTooLarge = errors.New("statement too large")
+
+	// ErrTooManyStatements is returned when a request contains more
+	// statements than permitted.
+	ErrTooManyStatements = errors.New("too many statements")
 )
 
 // ParseRequest generates a set of Statements for a given byte slice.
 func ParseRequest(b []byte) ([]*command.Statement, error) {
+	return ParseRequestLimit(b, 0)
+}
+
+// ParseRequestLimit is like ParseRequest, but returns ErrStatementTooLarge
+// if the SQL of any statement is more than maxStmtBytes long. A limit of 0
+// means no limit.
+func ParseRequestLimit(b []byte, maxStmtBytes int) ([]*command.Statement, error) {
 	if len(b) == 0 {
 		return nil, ErrNoStatements
 	}
@@ -41,6 +62,9 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 
 		stmts := make([]*command.Statement, len(simple))
 		for i := range simple {
+			if err := CheckStatementSize(i, simple[i], maxStmtBytes); err != nil {
+				return nil, err
+			}
 			stmts[i] = &command.Statement{
 				Sql: simple[i],
 			}
@@ -65,6 +89,9 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 		if !ok {
 			return nil, ErrInvalidRequest
 		}
+		if err := CheckStatementSize(i, sql, maxStmtBytes); err != nil {
+			return nil, err
+		}
 		stmts[i] = &command.Statement{
 			Sql:        sql,
 			Parameters: nil,
@@ -75,9 +102,11 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 		}
 
 		stmts[i].Parameters = make([]*command.Parameter, 0)
+		var named, positional bool
 		for j := range parameterized[i][1:] {
 			m, ok := parameterized[i][j+1].(map[string]interface{})
 			if ok {
+				named = true
 				for k, v := range m {
 					p, err := makeParameter(k, v)
 					if err != nil {
@@ -86,17 +115,72 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 					stmts[i].Parameters = append(stmts[i].Parameters, p)
 				}
 			} else {
+				positional = true
 				p, err := makeParameter("", parameterized[i][j+1])
 				if err != nil {
 					return nil, err
 				}
 				stmts[i].Parameters = append(stmts[i].Parameters, p)
 			}
+			if named && positional {
+				return nil, ErrMixedParameters
+			}
+		}
+	}
+	return stmts, nil
+}
+
+// ParseSQLRequestLimit generates a set of Statements from raw SQL, containing
+// one or more statements separated by semicolons. Semicolons within quoted
+// strings and identifiers, comments, and trigger bodies do not separate
+// statements. It returns ErrStatementTooLarge if the SQL of any statement is
+// more than maxStmtBytes long. A limit of 0 means no limit.
+func ParseSQLRequestLimit(b []byte, maxStmtBytes int) ([]*command.Statement, error) {
+	sqls := cmd.Split(string(b))
+	if len(sqls) == 0 {
+		return nil, ErrNoStatements
+	}
+
+	stmts := make([]*command.Statement, len(sqls))
+	for i := range sqls {
+		if err := CheckStatementSize(i, sqls[i], maxStmtBytes); err != nil {
+			return nil, err
+		}
+		stmts[i] = &command.Statement{
+			Sql: sqls[i],
 		}
 	}
 	return stmts, nil
 }
 
+// IsSQLContentType returns whether the given Content-Type header identifies
+// a body of raw SQL, rather than JSON.
+func IsSQLContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "application/sql"
+}
+
+// CheckStatementSize returns an error wrapping ErrStatementTooLarge if sql,
+// the i'th statement of a request, is more than maxStmtBytes long. A limit
+// of 0 means no limit.
+func CheckStatementSize(i int, sql string, maxStmtBytes int) error {
+	if maxStmtBytes > 0 && len(sql) > maxStmtBytes {
+		return fmt.Errorf("%w: statement %d is %d bytes, limit is %d bytes",
+			ErrStatementTooLarge, i, len(sql), maxStmtBytes)
+	}
+	return nil
+}
+
+// CheckStatementCount returns ErrTooManyStatements if a request of n
+// statements exceeds maxStmts. A limit of 0 means no limit.
+func CheckStatementCount(n, maxStmts int) error {
+	if maxStmts > 0 && n > maxStmts {
+		return fmt.Errorf("%w: request has %d statements, limit is %d",
+			ErrTooManyStatements, n, maxStmts)
+	}
+	return nil
+}
+
 func makeParameter(name string, i interface{}) (*command.Parameter, error) {
 	// Check if the value is a JSON number, and if so, convert it to an int64 or float64.
 	// Then let the switch statement below handle it.
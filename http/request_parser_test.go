@@ -2,6 +2,7 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -363,6 +364,32 @@ func Test_MixedInvalidRequest(t *testing.T) {
 	}
 }
 
+func Test_MixedParametersRequest(t *testing.T) {
+	for _, b := range []string{
+		`[["INSERT INTO foo(name, age) VALUES(?, :age)", "alice", {"age": 30}]]`,
+		`[["INSERT INTO foo(name, age) VALUES(:name, ?)", {"name": "alice"}, 30]]`,
+		`[["SELECT * FROM foo"], ["INSERT INTO foo(name, age) VALUES(?, :age)", "alice", {"age": 30}]]`,
+	} {
+		_, err := ParseRequest([]byte(b))
+		if err != ErrMixedParameters {
+			t.Fatalf("got unexpected error for mixed parameters request %s: %v", b, err)
+		}
+	}
+
+	// Different statements may use different styles.
+	b := []byte(`[["INSERT INTO foo(name) VALUES(?)", "alice"], ["INSERT INTO foo(name) VALUES(:name)", {"name": "bob"}]]`)
+	stmts, err := ParseRequest(b)
+	if err != nil {
+		t.Fatalf("failed to parse request: %s", err.Error())
+	}
+	if len(stmts) != 2 {
+		t.Fatalf("incorrect number of statements returned: %d", len(stmts))
+	}
+	if stmts[0].Parameters[0].GetName() != "" || stmts[1].Parameters[0].GetName() != "name" {
+		t.Fatalf("incorrect parameters parsed: %v", stmts)
+	}
+}
+
 func Test_SingleInvalidTypeRequests(t *testing.T) {
 	_, err := ParseRequest([]byte(`[1]`))
 	if err != ErrInvalidJSON {
@@ -380,6 +407,77 @@ func Test_SingleInvalidTypeRequests(t *testing.T) {
 	}
 }
 
+func Test_StatementTooLargeRequests(t *testing.T) {
+	for _, b := range []string{
+		`["SELECT 1", "SELECT * FROM foo WHERE name = 'fiona'"]`,
+		`[["SELECT 1"], ["SELECT * FROM foo WHERE name = ?", "fiona"]]`,
+	} {
+		if _, err := ParseRequestLimit([]byte(b), 20); !errors.Is(err, ErrStatementTooLarge) {
+			t.Fatalf("got unexpected error for over-limit statement in %s: %v", b, err)
+		}
+		stmts, err := ParseRequestLimit([]byte(b), 100)
+		if err != nil {
+			t.Fatalf("failed to parse %s within limit: %s", b, err.Error())
+		}
+		if len(stmts) != 2 {
+			t.Fatalf("incorrect number of statements returned: %d", len(stmts))
+		}
+		if _, err := ParseRequestLimit([]byte(b), 0); err != nil {
+			t.Fatalf("failed to parse %s with no limit: %s", b, err.Error())
+		}
+	}
+}
+
+func Test_SQLRequests(t *testing.T) {
+	b := []byte(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);
+-- a comment; not a statement
+INSERT INTO foo(name) VALUES('semi;colon');
+/* another; comment */ SELECT * FROM foo WHERE id IN (1, 2, 3)`)
+	stmts, err := ParseSQLRequestLimit(b, 0)
+	if err != nil {
+		t.Fatalf("failed to parse SQL request: %s", err.Error())
+	}
+	exp := []string{
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)",
+		"-- a comment; not a statement\nINSERT INTO foo(name) VALUES('semi;colon')",
+		"/* another; comment */ SELECT * FROM foo WHERE id IN (1, 2, 3)",
+	}
+	if len(stmts) != len(exp) {
+		t.Fatalf("incorrect number of statements returned: %d", len(stmts))
+	}
+	for i := range exp {
+		if stmts[i].Sql != exp[i] {
+			t.Fatalf("incorrect statement %d, exp %q, got %q", i, exp[i], stmts[i].Sql)
+		}
+		if stmts[i].Parameters != nil {
+			t.Fatalf("parameters found for statement %d", i)
+		}
+	}
+
+	for _, b := range []string{"", " ;\n; "} {
+		if _, err := ParseSQLRequestLimit([]byte(b), 0); err != ErrNoStatements {
+			t.Fatalf("got unexpected error for empty SQL request %q: %v", b, err)
+		}
+	}
+	if _, err := ParseSQLRequestLimit([]byte("SELECT 1; SELECT * FROM foo"), 10); !errors.Is(err, ErrStatementTooLarge) {
+		t.Fatalf("got unexpected error for over-limit statement: %v", err)
+	}
+}
+
+func Test_IsSQLContentType(t *testing.T) {
+	for ct, exp := range map[string]bool{
+		"application/sql":                true,
+		"Application/SQL; charset=utf-8": true,
+		"application/json":               false,
+		"text/plain":                     false,
+		"":                               false,
+	} {
+		if got := IsSQLContentType(ct); got != exp {
+			t.Fatalf("wrong result for %q, exp %v, got %v", ct, exp, got)
+		}
+	}
+}
+
 func mustJSONMarshal(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
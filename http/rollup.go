@@ -0,0 +1,111 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/rqlite/v8/command/encoding"
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// rollupFuncs are the aggregate functions which may be used in a rollup.
+var rollupFuncs = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"total": true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+// Rollup is an aggregate, such as sum(col), computed over all rows returned
+// by a query.
+type Rollup struct {
+	Func   string // Aggregate function, in lower case.
+	Column string // Column aggregated, or "*" for count(*).
+}
+
+// String returns the rollup as requested, such as sum(col). It keys the
+// value of the rollup in the response.
+func (r *Rollup) String() string {
+	return fmt.Sprintf("%s(%s)", r.Func, r.Column)
+}
+
+// SQL returns the rollup as a SQL expression.
+func (r *Rollup) SQL() string {
+	if r.Column == "*" {
+		return r.Func + "(*)"
+	}
+	return fmt.Sprintf("%s(%s)", r.Func, encoding.QuoteIdentifier(r.Column))
+}
+
+// ParseRollup parses a comma-separated list of aggregates, each of the form
+// func(col), such as "sum(price),count(*)".
+func ParseRollup(s string) ([]*Rollup, error) {
+	var rollups []*Rollup
+	for _, agg := range strings.Split(s, ",") {
+		agg = strings.TrimSpace(agg)
+		open := strings.Index(agg, "(")
+		if open < 1 || !strings.HasSuffix(agg, ")") {
+			return nil, fmt.Errorf("invalid rollup %q, must be of the form func(column)", agg)
+		}
+		r := &Rollup{
+			Func:   strings.ToLower(strings.TrimSpace(agg[:open])),
+			Column: strings.TrimSpace(agg[open+1 : len(agg)-1]),
+		}
+		if !rollupFuncs[r.Func] {
+			return nil, fmt.Errorf("unsupported rollup function %s", r.Func)
+		}
+		if r.Column == "" || (r.Column == "*" && r.Func != "count") {
+			return nil, fmt.Errorf("invalid column for rollup %q", agg)
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+// RollupStatements returns, for each statement, a statement computing the
+// rollups over the rows the statement returns.
+func RollupStatements(stmts []*command.Statement, rollups []*Rollup) []*command.Statement {
+	exprs := make([]string, len(rollups))
+	for i, r := range rollups {
+		exprs[i] = r.SQL()
+	}
+	sel := strings.Join(exprs, ", ")
+
+	rStmts := make([]*command.Statement, len(stmts))
+	for i, stmt := range stmts {
+		sql := strings.TrimRight(strings.TrimSpace(stmt.Sql), ";")
+		rStmts[i] = &command.Statement{
+			Sql:        fmt.Sprintf("SELECT %s FROM (%s)", sel, sql),
+			Parameters: stmt.Parameters,
+		}
+	}
+	return rStmts
+}
+
+// RollupResults returns the value of each rollup, keyed by the rollup, from
+// the rows returned by the statements generated by RollupStatements.
+func RollupResults(rows []*command.QueryRows, rollups []*Rollup) ([]map[string]interface{}, error) {
+	results := make([]map[string]interface{}, len(rows))
+	for i, r := range rows {
+		if r.Error != "" {
+			results[i] = map[string]interface{}{"error": r.Error}
+			continue
+		}
+		results[i] = make(map[string]interface{}, len(rollups))
+		if len(r.Values) != 1 {
+			continue
+		}
+		vals := make([][]interface{}, 1)
+		if err := encoding.NewValuesFromQueryValues(vals, r.Values, false); err != nil {
+			return nil, err
+		}
+		for j, rollup := range rollups {
+			if j < len(vals[0]) {
+				results[i][rollup.String()] = vals[0][j]
+			}
+		}
+	}
+	return results, nil
+}
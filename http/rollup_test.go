@@ -0,0 +1,64 @@
+package http
+
+import (
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_ParseRollup(t *testing.T) {
+	rollups, err := ParseRollup(" SUM(price), count(*),max(first name)")
+	if err != nil {
+		t.Fatalf("failed to parse rollup: %s", err.Error())
+	}
+	if len(rollups) != 3 {
+		t.Fatalf("wrong number of rollups, exp 3, got %d", len(rollups))
+	}
+	for i, exp := range []struct{ str, sql string }{
+		{"sum(price)", `sum("price")`},
+		{"count(*)", "count(*)"},
+		{"max(first name)", `max("first name")`},
+	} {
+		if got := rollups[i].String(); got != exp.str {
+			t.Fatalf("wrong rollup string, exp %s, got %s", exp.str, got)
+		}
+		if got := rollups[i].SQL(); got != exp.sql {
+			t.Fatalf("wrong rollup SQL, exp %s, got %s", exp.sql, got)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"sum",
+		"sum(price",
+		"(price)",
+		"sum()",
+		"sum(*)",
+		"median(price)",
+		"sum(price),",
+	} {
+		if _, err := ParseRollup(s); err == nil {
+			t.Fatalf("expected error parsing rollup %q", s)
+		}
+	}
+}
+
+func Test_RollupStatements(t *testing.T) {
+	rollups, err := ParseRollup(`sum(a"b),count(*)`)
+	if err != nil {
+		t.Fatalf("failed to parse rollup: %s", err.Error())
+	}
+	params := []*command.Parameter{{Value: &command.Parameter_I{I: 5}}}
+	stmts := RollupStatements([]*command.Statement{
+		{Sql: "SELECT * FROM foo WHERE id > ?; ", Parameters: params},
+	}, rollups)
+	if len(stmts) != 1 {
+		t.Fatalf("wrong number of statements, exp 1, got %d", len(stmts))
+	}
+	if exp, got := `SELECT sum("a""b"), count(*) FROM (SELECT * FROM foo WHERE id > ?)`, stmts[0].Sql; exp != got {
+		t.Fatalf("wrong rollup statement, exp %s, got %s", exp, got)
+	}
+	if len(stmts[0].Parameters) != 1 || stmts[0].Parameters[0].GetI() != 5 {
+		t.Fatalf("parameters not passed to rollup statement")
+	}
+}
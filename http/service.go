@@ -3,37 +3,66 @@ package http
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"expvar"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/raft"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rqlite/rqlite/v8/auth"
 	clstrPB "github.com/rqlite/rqlite/v8/cluster/proto"
 	"github.com/rqlite/rqlite/v8/command"
+	"github.com/rqlite/rqlite/v8/command/chunking"
 	"github.com/rqlite/rqlite/v8/command/encoding"
 	"github.com/rqlite/rqlite/v8/command/proto"
 	"github.com/rqlite/rqlite/v8/db"
+	"github.com/rqlite/rqlite/v8/progress"
 	"github.com/rqlite/rqlite/v8/queue"
+	"github.com/rqlite/rqlite/v8/random"
 	"github.com/rqlite/rqlite/v8/rtls"
 	"github.com/rqlite/rqlite/v8/store"
+	"golang.org/x/net/http2"
+	pb "google.golang.org/protobuf/proto"
 )
 
 var (
 	// ErrLeaderNotFound is returned when a node cannot locate a leader
 	ErrLeaderNotFound = errors.New("leader not found")
+
+	// ErrCertNotForwardable is returned when a request authenticated by its
+	// TLS client certificate must be forwarded to another node. Other nodes
+	// can only check Basic Auth credentials.
+	ErrCertNotForwardable = errors.New("requests authenticated by client certificate cannot be forwarded to another node")
+
+	// ErrTokenNotForwardable is returned when a request authenticated by a
+	// bearer token must be forwarded to another node.
+	ErrTokenNotForwardable = errors.New("requests authenticated by bearer token cannot be forwarded to another node")
 )
 
 type ResultsError interface {
@@ -55,12 +84,20 @@ type Database interface {
 	// is held on the database.
 	Query(qr *proto.QueryRequest) ([]*proto.QueryRows, error)
 
+	// QueryStream executes a single query, calling fn for each row as it
+	// is read, rather than returning the full result.
+	QueryStream(qr *proto.QueryRequest, fn db.RowFunc) error
+
 	// Request processes a slice of requests, each of which can be either
 	// an Execute or Query request.
 	Request(eqr *proto.ExecuteQueryRequest) ([]*proto.ExecuteQueryResponse, error)
 
 	// Load loads a SQLite file into the system via Raft consensus.
 	Load(lr *proto.LoadRequest) error
+
+	// LoadChunk loads a chunk of a SQLite file into the system via Raft
+	// consensus.
+	LoadChunk(lcr *proto.LoadChunkRequest) error
 }
 
 // Store is the interface the Raft-based database must implement.
@@ -73,6 +110,45 @@ type Store interface {
 	// LeaderAddr returns the Raft address of the leader of the cluster.
 	LeaderAddr() (string, error)
 
+	// QueryContext is like Query, but queries run against the local
+	// database are interrupted if ctx is done.
+	QueryContext(ctx context.Context, qr *proto.QueryRequest) ([]*proto.QueryRows, error)
+
+	// ExecuteContext is like Execute, but a request which fails because the
+	// database is busy is not retried beyond ctx's deadline.
+	ExecuteContext(ctx context.Context, er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error)
+
+	// Validate checks, without executing them, that the statements of the
+	// execute request are valid against the local database.
+	Validate(er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error)
+
+	// StmtReadOnly returns whether SQLite, preparing the given single SQL
+	// statement against the local database, considers it read-only.
+	StmtReadOnly(sql string) (bool, error)
+
+	// StmtTables returns the tables which SQLite, preparing the given single
+	// SQL statement against the local database, reports the statement accesses.
+	StmtTables(sql string) ([]string, error)
+
+	// Subscribe returns a subscription to the changes made to the given
+	// tables of the local database.
+	Subscribe(tables []string) (store.Subscription, error)
+
+	// SubscribeEvents returns a subscription to the cluster events observed
+	// by this node. If replay is set, the retained events after the event
+	// with the given ID are received first.
+	SubscribeEvents(replay bool, after uint64) (store.EventSubscription, error)
+
+	// IsLeader returns whether this node is the leader of the cluster.
+	IsLeader() bool
+
+	// Staleness returns how stale a read served locally by this node, with
+	// read consistency level "none", would be.
+	Staleness(strict bool) time.Duration
+
+	// LeaderlessFor returns how long this node has been without a Leader.
+	LeaderlessFor() time.Duration
+
 	// Ready returns whether the Store is ready to service requests.
 	Ready() bool
 
@@ -89,12 +165,33 @@ type Store interface {
 	// Backup writes backup of the node state to dst
 	Backup(br *proto.BackupRequest, dst io.Writer) error
 
+	// Snapshot triggers a Raft snapshot, leaving n trailing logs behind, or
+	// the configured number if n is zero.
+	Snapshot(n uint64) error
+
+	// LastSnapshotIndex returns the index of the most recent snapshot.
+	LastSnapshotIndex() (uint64, error)
+
+	// VacuumReplicated performs a VACUUM on every node, returning the
+	// database file stats of this node before and after.
+	VacuumReplicated() (before, after *db.FileStats, err error)
+
+	// CopyFile writes a consistent copy of the node's SQLite database
+	// file to the io.Writer.
+	CopyFile(w io.Writer) error
+
 	// ReadFrom reads and loads a SQLite database into the node, initially bypassing
 	// the Raft system. It then triggers a Raft snapshot, which will then make
 	// Raft aware of the new data.
 	ReadFrom(r io.Reader) (int64, error)
 }
 
+// Advertiser is the interface that wraps the SetAPIAddr method.
+// SetAPIAddr sets the API address this node advertises to the cluster.
+type Advertiser interface {
+	SetAPIAddr(addr string)
+}
+
 // GetAddresser is the interface that wraps the GetNodeAPIAddr method.
 // GetNodeAPIAddr returns the HTTP API URL for the node at the given Raft address.
 type GetAddresser interface {
@@ -105,6 +202,14 @@ type GetAddresser interface {
 type Cluster interface {
 	GetAddresser
 
+	// GetNodeTime returns the current time on the node at the given Raft
+	// address, and the round-trip time of the request.
+	GetNodeTime(addr string, timeout time.Duration) (time.Time, time.Duration, error)
+
+	// GetNodeMeta returns the metadata, including Raft indexes, of the node
+	// at the given Raft address.
+	GetNodeMeta(addr string, timeout time.Duration) (*clstrPB.NodeMeta, error)
+
 	// Execute performs an Execute Request on a remote node.
 	Execute(er *proto.ExecuteRequest, nodeAddr string, creds *clstrPB.Credentials, timeout time.Duration, retries int) ([]*proto.ExecuteResult, error)
 
@@ -117,8 +222,8 @@ type Cluster interface {
 	// Backup retrieves a backup from a remote node and writes to the io.Writer.
 	Backup(br *proto.BackupRequest, nodeAddr string, creds *clstrPB.Credentials, timeout time.Duration, w io.Writer) error
 
-	// Load loads a SQLite database into the node.
-	Load(lr *proto.LoadRequest, nodeAddr string, creds *clstrPB.Credentials, timeout time.Duration, retries int) error
+	// LoadChunk loads a chunk of a SQLite database into the node.
+	LoadChunk(lcr *proto.LoadChunkRequest, nodeAddr string, creds *clstrPB.Credentials, timeout time.Duration) error
 
 	// RemoveNode removes a node from the cluster.
 	RemoveNode(rn *proto.RemoveNodeRequest, nodeAddr string, creds *clstrPB.Credentials, timeout time.Duration) error
@@ -131,8 +236,55 @@ type Cluster interface {
 type CredentialStore interface {
 	// AA authenticates and checks authorization for the given perm.
 	AA(username, password, perm string) bool
+
+	// Authorized checks authorization, for the given perm, of a user
+	// authenticated by other means, such as a TLS client certificate.
+	Authorized(username, perm string) bool
+
+	// HasPermForResource returns whether the access of the given user, with
+	// the given perm, extends to the given resource, such as a table.
+	HasPermForResource(username, perm, resource string) bool
+
+	// MaxRows returns the maximum number of rows a query may return for
+	// the given user, if a limit is configured for that user.
+	MaxRows(username string) (int64, bool)
+
+	// Params returns the parameters bound, by default, to statements
+	// executed by the given user.
+	Params(username string) map[string]interface{}
+
+	// QueryLimit returns how queries by the given user which lack a LIMIT
+	// clause are treated, if a mode is configured for that user.
+	QueryLimit(username string) (string, bool)
+
+	// Check returns whether the password is correct for the given user.
+	Check(username, password string) bool
+
+	// RateLimit returns the rate at which the requests of the given user
+	// are admitted, if a rate is configured for that user.
+	RateLimit(username string) (auth.RateLimit, bool)
+}
+
+// TokenValidator validates bearer tokens.
+type TokenValidator interface {
+	// Validate checks the token, returning the user it names and the perms
+	// it grants. A non-nil error means the token must not be trusted.
+	Validate(token string) (username string, perms []string, err error)
 }
 
+// HealthCheck reports whether a dependency of this node is healthy, returning
+// a non-nil error if it is not.
+type HealthCheck func() error
+
+// PreExecuteHook inspects a statement before it is executed. Returning a
+// non-nil error vetoes the entire request.
+type PreExecuteHook func(r *http.Request, stmt *proto.Statement) error
+
+// PostExecuteHook is called once the statements of a request have been
+// executed, or accepted into the queue for queued writes. err is any error
+// which prevented execution.
+type PostExecuteHook func(r *http.Request, stmts []*proto.Statement, err error)
+
 // StatusReporter is the interface status providers must implement.
 type StatusReporter interface {
 	Stats() (map[string]interface{}, error)
@@ -177,11 +329,44 @@ type Response struct {
 	Error       string     `json:"error,omitempty"`
 	Time        float64    `json:"time,omitempty"`
 	SequenceNum int64      `json:"sequence_number,omitempty"`
+	DebugSQL    *DebugSQL  `json:"debug_sql,omitempty"`
+
+	// Rollup holds, for each query, the aggregates requested by the rollup
+	// query parameter.
+	Rollup []map[string]interface{} `json:"rollup,omitempty"`
+
+	// NextPageToken, if set, is passed as the page_token query parameter to
+	// read the page of query results following this one.
+	NextPageToken string `json:"next_page_token,omitempty"`
 
 	start time.Time
 	end   time.Time
 }
 
+// DebugSQL holds the statements of a request with their bound parameters
+// interpolated. It is for display only, and the statements must not be
+// executed, as the interpolation is not guaranteed to match how SQLite
+// binds the parameters.
+type DebugSQL struct {
+	Executable bool     `json:"executable"`
+	Statements []string `json:"statements"`
+}
+
+// NewDebugSQL returns a DebugSQL for the given statements.
+func NewDebugSQL(stmts []*proto.Statement) (*DebugSQL, error) {
+	d := &DebugSQL{
+		Statements: make([]string, len(stmts)),
+	}
+	for i := range stmts {
+		s, err := encoding.InterpolateSQL(stmts[i])
+		if err != nil {
+			return nil, err
+		}
+		d.Statements[i] = s
+	}
+	return d, nil
+}
+
 // SetTime sets the Time attribute of the response. This way it will be present
 // in the serialized JSON version.
 func (r *Response) SetTime() {
@@ -215,6 +400,7 @@ const (
 	numQueuedExecutionsWait           = "queued_executions_wait"
 	numQueries                        = "queries"
 	numQueryStmtsRx                   = "query_stmts_rx"
+	numQueryRowsTruncated             = "query_rows_truncated"
 	numRequests                       = "requests"
 	numRequestStmtsRx                 = "request_stmts_rx"
 	numRemoteExecutions               = "remote_executions"
@@ -225,19 +411,91 @@ const (
 	numRemoteRequestsFailed           = "remote_requests_failed"
 	numRemoteBackups                  = "remote_backups"
 	numRemoteLoads                    = "remote_loads"
+	numChunkedLoads                   = "chunked_loads"
+	numGzipLoads                      = "gzip_loads"
+	numFlagChanges                    = "flag_changes"
+	numReplicateTo                    = "replicate_to"
+	numSnapshots                      = "snapshots"
+	numRequestTooLarge                = "request_too_large"
+	numRateLimited                    = "rate_limited"
+	numExplains                       = "explains"
+	numReadOnlyRejected               = "read_only_rejected"
+	numQueryLimitRejected             = "query_limit_rejected"
+	numQueryLimitAppended             = "query_limit_appended"
+	numSubscriptions                  = "subscriptions"
+	numSubscribeRejected              = "subscribe_rejected"
+	numEventStreams                   = "event_streams"
+	numDryRunExecutions               = "dry_run_executions"
+	numIdempotentReplays              = "idempotent_replays"
+	numIdempotencyConflicts           = "idempotency_key_conflicts"
+	numReplicateToFailed              = "replicate_to_failed"
 	numRemoteRemoveNode               = "remote_remove_node"
 	numReadyz                         = "num_readyz"
 	numStatus                         = "num_status"
+	numLeader                         = "num_leader"
 	numBackups                        = "backups"
 	numLoad                           = "loads"
 	numLoadAborted                    = "loads_aborted"
 	numBoot                           = "boot"
+	numAdvertise                      = "advertise"
+	numPreExecuteRejections           = "pre_execute_rejections"
+	numTableRows                      = "table_rows"
+	numTableChecksums                 = "table_checksums"
+	numIndexes                        = "indexes"
+	numFollowerReadRedirects          = "follower_read_redirects"
+	numStaleReadsForwarded            = "stale_reads_forwarded"
+	numStaleReadsRejected             = "stale_reads_rejected"
+	numQueriesInterrupted             = "queries_interrupted"
+	numDeadlineExceeded               = "deadline_exceeded"
+	numQueryNDJSON                    = "query_ndjson"
+	numMigratePreviews                = "migrate_previews"
+	numMigrations                     = "migrations"
+	numMigrationsApplied              = "migrations_applied"
+	numImports                        = "imports"
+	numImportedRows                   = "imported_rows"
+	numPagedQueries                   = "paged_queries"
+	numVacuums                        = "vacuums"
+	numCompressedResponses            = "compressed_responses"
 	numAuthOK                         = "authOK"
 	numAuthFail                       = "authFail"
+	numTokenAuthFail                  = "token_auth_fail"
+	numNotForwardable                 = "not_forwardable"
+	numTablePermDenied                = "table_perm_denied"
 
 	// Default timeout for cluster communications.
 	defaultTimeout = 30 * time.Second
 
+	// Default time allowed for each node to respond when listing nodes.
+	defaultProbeTimeout = 5 * time.Second
+
+	// Maximum time clients are told to wait, before retrying, when there
+	// is no Leader.
+	maxLeaderRetryAfter = 30 * time.Second
+
+	// Default size of the chunks in which a SQLite file is loaded.
+	defaultLoadChunkSize = 16 * 1024 * 1024
+
+	// Default timeout for replicating the database to another cluster.
+	defaultReplicateTimeout = 30 * time.Minute
+
+	// Default and maximum number of rows returned by a table rows request.
+	defaultTableRowsLimit = 100
+	maxTableRowsLimit     = 10000
+
+	// Content type of newline-delimited JSON query results.
+	ndjsonContentType = "application/x-ndjson"
+
+	// Timeout for fetching node metadata when choosing a follower to serve
+	// a read the Leader cannot admit.
+	followerReadFallbackTimeout = 500 * time.Millisecond
+
+	// Default interval over which a node's apply rate is measured.
+	defaultCatchupInterval = time.Second
+
+	// DefaultMaxStatements is the default maximum number of statements in
+	// a single request.
+	DefaultMaxStatements = 10000
+
 	// VersionHTTPHeader is the HTTP header key for the version.
 	VersionHTTPHeader = "X-RQLITE-VERSION"
 
@@ -246,6 +504,35 @@ const (
 	// it wasn't served by this node.
 	ServedByHTTPHeader = "X-RQLITE-SERVED-BY"
 
+	// LeaderHTTPHeader is the HTTP header used to report the API address of
+	// the Leader, when a request must be served by the Leader and this node
+	// is not the Leader.
+	LeaderHTTPHeader = "X-RQLITE-LEADER"
+
+	// ElectionTimeoutHTTPHeader is the HTTP header reporting the Raft election
+	// timeout, on responses to requests which failed for want of a Leader.
+	ElectionTimeoutHTTPHeader = "X-RQLITE-ELECTION-TIMEOUT"
+
+	// PriorityHTTPHeader is the HTTP header clients use to set the priority
+	// with which a request is admitted, if concurrent requests are limited.
+	PriorityHTTPHeader = "X-Priority"
+
+	// ReadConsistencyHTTPHeader is the HTTP header set on responses to reads
+	// served with read consistency level "none". Such reads come straight
+	// from this node's copy of the database, without checking with the Leader,
+	// and so may be stale.
+	ReadConsistencyHTTPHeader = "X-RQLITE-READ-CONSISTENCY"
+
+	// TraceHTTPHeader is the HTTP header a client can set to true to force
+	// the request to be logged, regardless of the log sample rate.
+	TraceHTTPHeader = "X-RQLITE-TRACE"
+
+	// StrictTransportSecurityHeader is the HTTP header for HTTP Strict Transport Security.
+	StrictTransportSecurityHeader = "Strict-Transport-Security"
+
+	// defaultHSTS is the default HSTS policy, applied when HTTPS is enabled.
+	defaultHSTS = "max-age=31536000"
+
 	// AllowOriginHeader is the HTTP header for allowing CORS compliant access from certain origins
 	AllowOriginHeader = "Access-Control-Allow-Origin"
 
@@ -257,6 +544,13 @@ const (
 
 	// AllowCredentialsHeader is the HTTP header for supporting specifying credentials
 	AllowCredentialsHeader = "Access-Control-Allow-Credentials"
+
+	// ExposeHeadersHeader is the HTTP header listing the response headers a
+	// cross-origin client may read
+	ExposeHeadersHeader = "Access-Control-Expose-Headers"
+
+	// MaxAgeHeader is the HTTP header for how long a preflight response may be cached
+	MaxAgeHeader = "Access-Control-Max-Age"
 )
 
 func init() {
@@ -282,6 +576,7 @@ func ResetStats() {
 	stats.Add(numQueuedExecutionsWait, 0)
 	stats.Add(numQueries, 0)
 	stats.Add(numQueryStmtsRx, 0)
+	stats.Add(numQueryRowsTruncated, 0)
 	stats.Add(numRequests, 0)
 	stats.Add(numRequestStmtsRx, 0)
 	stats.Add(numRemoteExecutions, 0)
@@ -292,15 +587,56 @@ func ResetStats() {
 	stats.Add(numRemoteRequestsFailed, 0)
 	stats.Add(numRemoteBackups, 0)
 	stats.Add(numRemoteLoads, 0)
+	stats.Add(numChunkedLoads, 0)
+	stats.Add(numGzipLoads, 0)
+	stats.Add(numFlagChanges, 0)
+	stats.Add(numReplicateTo, 0)
+	stats.Add(numSnapshots, 0)
+	stats.Add(numRequestTooLarge, 0)
+	stats.Add(numRateLimited, 0)
+	stats.Add(numExplains, 0)
+	stats.Add(numReadOnlyRejected, 0)
+	stats.Add(numQueryLimitRejected, 0)
+	stats.Add(numQueryLimitAppended, 0)
+	stats.Add(numSubscriptions, 0)
+	stats.Add(numSubscribeRejected, 0)
+	stats.Add(numEventStreams, 0)
+	stats.Add(numDryRunExecutions, 0)
+	stats.Add(numIdempotentReplays, 0)
+	stats.Add(numIdempotencyConflicts, 0)
+	stats.Add(numReplicateToFailed, 0)
 	stats.Add(numRemoteRemoveNode, 0)
 	stats.Add(numReadyz, 0)
 	stats.Add(numStatus, 0)
+	stats.Add(numLeader, 0)
 	stats.Add(numBackups, 0)
 	stats.Add(numLoad, 0)
 	stats.Add(numLoadAborted, 0)
 	stats.Add(numBoot, 0)
+	stats.Add(numAdvertise, 0)
+	stats.Add(numPreExecuteRejections, 0)
+	stats.Add(numDeadlineExceeded, 0)
+	stats.Add(numQueryNDJSON, 0)
+	stats.Add(numMigratePreviews, 0)
+	stats.Add(numMigrations, 0)
+	stats.Add(numMigrationsApplied, 0)
+	stats.Add(numImports, 0)
+	stats.Add(numImportedRows, 0)
+	stats.Add(numPagedQueries, 0)
+	stats.Add(numVacuums, 0)
+	stats.Add(numCompressedResponses, 0)
+	stats.Add(numTableRows, 0)
+	stats.Add(numTableChecksums, 0)
+	stats.Add(numIndexes, 0)
+	stats.Add(numFollowerReadRedirects, 0)
+	stats.Add(numStaleReadsForwarded, 0)
+	stats.Add(numStaleReadsRejected, 0)
+	stats.Add(numQueriesInterrupted, 0)
 	stats.Add(numAuthOK, 0)
 	stats.Add(numAuthFail, 0)
+	stats.Add(numTokenAuthFail, 0)
+	stats.Add(numNotForwardable, 0)
+	stats.Add(numTablePermDenied, 0)
 }
 
 // Service provides HTTP service.
@@ -310,6 +646,11 @@ type Service struct {
 	addr       string       // Bind address of the HTTP service.
 	ln         net.Listener // Service listener
 
+	// streamsDone is closed to end long-lived streams, such as event
+	// streams, which would otherwise hold up a graceful shutdown.
+	streamsDone     chan struct{}
+	streamsDoneOnce sync.Once
+
 	store Store // The Raft-backed database store.
 
 	queueDone chan struct{}
@@ -323,13 +664,188 @@ type Service struct {
 	statusMu sync.RWMutex
 	statuses map[string]StatusReporter
 
+	healthMu     sync.RWMutex
+	healthChecks map[string]HealthCheck
+
+	queryRowsHist  *Histogram // Rows returned by each query.
+	queryBytesHist *Histogram // Encoded size of the result of each query.
+
+	// Latency, in microseconds, of executes and queries, whether served by
+	// this node's Store or forwarded to the Leader.
+	executeLocalHist   *Histogram
+	executeForwardHist *Histogram
+	queryLocalHist     *Histogram
+	queryForwardHist   *Histogram
+
+	hooksMu          sync.RWMutex
+	preExecuteHooks  []PreExecuteHook
+	postExecuteHooks []PostExecuteHook
+
 	CACertFile   string // Path to x509 CA certificate used to verify certificates.
 	CertFile     string // Path to server's own x509 certificate.
 	KeyFile      string // Path to server's own x509 private key.
 	ClientVerify bool   // Whether client certificates should verified.
 	tlsConfig    *tls.Config
 
-	AllowOrigin string // Value to set for Access-Control-Allow-Origin
+	// ClientCACertFile is the path to the x509 CA certificate used to verify
+	// client certificates. If not set, CACertFile is used.
+	ClientCACertFile string
+
+	// ClientCertAuth enables authentication by TLS client certificate. The
+	// user is named by the Common Name of a verified certificate, or if not
+	// set, its first DNS or email Subject Alternative Name. Requests with
+	// such a certificate are authorized as that user, without Basic Auth.
+	// Requests forwarded to the leader carry only Basic Auth credentials.
+	ClientCertAuth bool
+
+	// TokenValidator, if set, enables authentication by bearer token. A valid
+	// token authorizes the request with the perms it grants, as well as any
+	// granted to its user in the credential store. Like certificates, tokens
+	// are not forwarded to the Leader.
+	TokenValidator TokenValidator
+
+	// AuthRealm is the realm sent in authentication challenges.
+	AuthRealm string
+
+	// CompressResponses enables compression of query, execute, status, and
+	// backup responses, for clients which accept gzip or deflate encoding.
+	// Only responses of at least CompressMinSize bytes are compressed.
+	CompressResponses bool
+	CompressMinSize   int
+
+	// SNICertificates maps hostnames to the certificate presented when
+	// a client requests that hostname via SNI. Clients requesting any other
+	// hostname are presented the certificate at CertFile.
+	SNICertificates map[string]rtls.CertKeyFiles
+
+	// HTTP2Disabled disables HTTP/2, so HTTPS clients are served HTTP/1.1
+	// only. HTTP/2 is only ever served over HTTPS.
+	HTTP2Disabled bool
+
+	// HTTP2MaxConcurrentStreams is the maximum number of HTTP/2 streams each
+	// client may have open at once. 0 means the HTTP/2 default of 250.
+	HTTP2MaxConcurrentStreams uint32
+
+	// HTTP2IdleTimeout is how long an idle HTTP/2 connection is kept open.
+	// 0 means no timeout.
+	HTTP2IdleTimeout time.Duration
+
+	// AllowOrigins are the origins from which browsers may make cross-origin
+	// requests, or "*" for any origin. If none are set, CORS is disabled.
+	AllowOrigins     []string
+	AllowMethods     []string // Methods allowed in cross-origin requests. Defaults to DefaultAllowMethods.
+	AllowHeaders     []string // Request headers allowed in cross-origin requests. Defaults to DefaultAllowHeaders.
+	AllowCredentials bool     // Whether cross-origin requests from allowed origins may carry credentials.
+
+	LogSampleRate float64 // Fraction of requests, between 0 and 1, to log.
+
+	ResponseHeaders map[string]string // Static headers to set on every response.
+
+	WarmupQueries []string // Queries run, against the local database, to warm caches.
+
+	StrictQuery bool // Reject statements which modify the database on the query endpoint.
+
+	// RedactParameters removes the values of the parameters bound to a
+	// request's statements from any error written in the response to the
+	// request, or logged while handling it.
+	RedactParameters bool
+
+	// ElectionTimeout is the Raft election timeout of the cluster, from which
+	// clients are told how long to wait before retrying when there is no
+	// Leader.
+	ElectionTimeout time.Duration
+
+	// ReadOnly marks this node as a read replica. Requests to write to the
+	// database, or load it, are rejected with 403 Forbidden, whatever the
+	// credentials of the client, unless the client asks to be redirected to
+	// the Leader.
+	ReadOnly bool
+
+	MaxStatementBytes int // Maximum length of the SQL of any one statement. 0 means no limit.
+
+	// RateLimitReads and RateLimitWrites are the number of read and write
+	// requests, respectively, admitted per second from each client. Clients
+	// are authenticated users, or IP addresses if not authenticated. A rate
+	// configured for a user in the credential store takes precedence. 0
+	// means no limit. Requests over the limit are rejected with 429 Too Many
+	// Requests.
+	RateLimitReads  float64
+	RateLimitWrites float64
+	rateLimiter     *rateLimiter
+
+	// MaxStatements is the maximum number of statements in a single request.
+	// Larger requests are rejected with 400 Bad Request before any statement
+	// is executed. 0 means no limit.
+	MaxStatements int
+
+	// MaxRequestBytes is the maximum size of a request body. Larger requests
+	// are rejected with 413 Request Entity Too Large. 0 means no limit.
+	MaxRequestBytes int64
+
+	// MaxLoadBytes is the maximum size of the body of a load, boot, or backup
+	// validation request, which may carry a SQLite database file, or of a CSV
+	// import, in place of MaxRequestBytes. 0 means no limit.
+	MaxLoadBytes int64
+
+	DefaultMaxRows int64 // Maximum rows returned per statement, if not set for the user. 0 means no limit.
+
+	// QueryLimitMode is how SELECT statements without a LIMIT clause are
+	// treated on the query endpoints, if not set for the user. One of
+	// auth.QueryLimitReject, auth.QueryLimitAppend, or auth.QueryLimitOff.
+	// Empty means auth.QueryLimitOff.
+	QueryLimitMode string
+
+	QueryLimitRows int64 // LIMIT added to statements when QueryLimitMode is auth.QueryLimitAppend.
+
+	Labels map[string]string // Labels, such as role, describing this node.
+
+	Advertiser Advertiser // Sets the API address advertised to the cluster. May be nil.
+
+	MaxConcurrentRequests int // Maximum database requests in progress at once. 0 means no limit.
+	limiter               *Limiter
+
+	MaxSubscriptions    int // Maximum subscriptions open at once on /db/subscribe and /events. 0 means no limit.
+	activeSubscriptions atomic.Int64
+
+	// FollowerReadFallback, if set, redirects reads which cannot be admitted
+	// immediately by a Leader at its request limit to an up-to-date follower,
+	// instead of queueing them. The follower serves the read with read
+	// consistency level "none".
+	FollowerReadFallback bool
+
+	// FollowerReadRefreshInterval is how often a Leader with
+	// FollowerReadFallback set checks which followers are caught up.
+	FollowerReadRefreshInterval time.Duration
+	followerReads               *followerReads
+
+	// StreamChunkRows is the number of rows of a streamed query result
+	// written between flushes of the response, unless set by the request.
+	StreamChunkRows int
+
+	flagsMu sync.RWMutex // Guards settings which can be changed at runtime.
+
+	// ReplicateAllowlist is the set of host:port addresses of the clusters
+	// to which this node will stream its database, via /db/replicate-to.
+	// If empty, replication to other clusters is disabled.
+	ReplicateAllowlist []string
+
+	// LoadChunkSize is the size, in bytes, of the chunks in which a SQLite
+	// file posted to a Leader is streamed into the Store.
+	LoadChunkSize int64
+
+	// IdempotencyWindow is how long the results of an execute request
+	// carrying an Idempotency-Key header are kept, to be returned again if
+	// the request is retried. The key is written to the Raft log with the
+	// request, and each node records the results in the database as it
+	// applies the request, so a retry is recognized even if the first
+	// request timed out before it was applied, was made to a different
+	// node, or the records were restored from a snapshot. 0 disables
+	// idempotency keys.
+	IdempotencyWindow time.Duration
+
+	// IdempotencyMaxKeys is the maximum number of idempotency keys kept.
+	// Once reached, the oldest key is evicted.
+	IdempotencyMaxKeys int
 
 	DefaultQueueCap     int
 	DefaultQueueBatchSz int
@@ -341,25 +857,54 @@ type Service struct {
 
 	credentialStore CredentialStore
 
+	// AuthExemptRoutes are the paths which may be accessed without
+	// credentials, even when authentication is enabled.
+	AuthExemptRoutes []string
+
 	BuildInfo map[string]interface{}
 
 	logger *log.Logger
 }
 
+// DefaultAuthExemptRoutes returns the paths, used by load balancers to check
+// the health of a node, which may be accessed without credentials by default.
+func DefaultAuthExemptRoutes() []string {
+	return []string{"/readyz", "/healthz", "/version"}
+}
+
 // New returns an uninitialized HTTP service. If credentials is nil, then
 // the service performs no authentication and authorization checks.
 func New(addr string, store Store, cluster Cluster, credentials CredentialStore) *Service {
 	return &Service{
-		addr:                addr,
-		store:               store,
-		DefaultQueueCap:     1024,
-		DefaultQueueBatchSz: 128,
-		DefaultQueueTimeout: 100 * time.Millisecond,
-		cluster:             cluster,
-		start:               time.Now(),
-		statuses:            make(map[string]StatusReporter),
-		credentialStore:     credentials,
-		logger:              log.New(os.Stderr, "[http] ", log.LstdFlags),
+		addr:                        addr,
+		store:                       store,
+		DefaultQueueCap:             1024,
+		DefaultQueueBatchSz:         128,
+		DefaultQueueTimeout:         100 * time.Millisecond,
+		CompressMinSize:             1024,
+		StreamChunkRows:             1000,
+		LoadChunkSize:               defaultLoadChunkSize,
+		AuthExemptRoutes:            DefaultAuthExemptRoutes(),
+		AuthRealm:                   "rqlite",
+		QueryLimitRows:              1000,
+		ElectionTimeout:             time.Second,
+		FollowerReadRefreshInterval: defaultFollowerReadRefreshInterval,
+		IdempotencyWindow:           5 * time.Minute,
+		IdempotencyMaxKeys:          10000,
+		MaxStatements:               DefaultMaxStatements,
+		rateLimiter:                 newRateLimiter(),
+		cluster:                     cluster,
+		start:                       time.Now(),
+		statuses:                    make(map[string]StatusReporter),
+		healthChecks:                make(map[string]HealthCheck),
+		queryRowsHist:               NewHistogram(0, 1, 10, 100, 1000, 10000, 100000),
+		queryBytesHist:              NewHistogram(1<<10, 10<<10, 100<<10, 1<<20, 10<<20, 100<<20),
+		executeLocalHist:            newLatencyHistogram(),
+		executeForwardHist:          newLatencyHistogram(),
+		queryLocalHist:              newLatencyHistogram(),
+		queryForwardHist:            newLatencyHistogram(),
+		credentialStore:             credentials,
+		logger:                      log.New(os.Stderr, "[http] ", log.LstdFlags),
 	}
 }
 
@@ -380,11 +925,23 @@ func (s *Service) Start() error {
 		mTLSState := rtls.MTLSStateDisabled
 		if s.ClientVerify {
 			mTLSState = rtls.MTLSStateEnabled
+		} else if s.ClientCertAuth {
+			mTLSState = rtls.MTLSStateOptional
 		}
-		s.tlsConfig, err = rtls.CreateServerConfig(s.CertFile, s.KeyFile, s.CACertFile, mTLSState)
+		caCertFile := s.CACertFile
+		if s.ClientCACertFile != "" {
+			caCertFile = s.ClientCACertFile
+		}
+		s.tlsConfig, err = rtls.CreateServerConfig(s.CertFile, s.KeyFile, caCertFile, mTLSState)
 		if err != nil {
 			return err
 		}
+		if err := rtls.AddSNICertificates(s.tlsConfig, s.SNICertificates); err != nil {
+			return err
+		}
+		if err := s.configureHTTP2(); err != nil {
+			return err
+		}
 		ln, err = tls.Listen("tcp", s.addr, s.tlsConfig)
 		if err != nil {
 			return err
@@ -394,19 +951,40 @@ func (s *Service) Start() error {
 		if s.CACertFile != "" {
 			b.WriteString(fmt.Sprintf(", CA cert %s", s.CACertFile))
 		}
+		if len(s.SNICertificates) > 0 {
+			b.WriteString(fmt.Sprintf(", %d SNI certificates", len(s.SNICertificates)))
+		}
+		if s.ClientCACertFile != "" {
+			b.WriteString(fmt.Sprintf(", client CA cert %s", s.ClientCACertFile))
+		}
 		if s.ClientVerify {
 			b.WriteString(", mutual TLS enabled")
+		} else if s.ClientCertAuth {
+			b.WriteString(", mutual TLS optional")
 		} else {
 			b.WriteString(", mutual TLS disabled")
 		}
+		if s.HTTP2Disabled {
+			b.WriteString(", HTTP/2 disabled")
+		}
 		// print the message
 		s.logger.Println(b.String())
 	}
 	s.ln = ln
 
 	s.closeCh = make(chan struct{})
+	s.streamsDone = make(chan struct{})
 	s.queueDone = make(chan struct{})
 
+	if s.MaxConcurrentRequests > 0 {
+		s.limiter = NewLimiter(s.MaxConcurrentRequests)
+	}
+	if s.FollowerReadFallback {
+		s.followerReads = newFollowerReads()
+		s.followerReads.wg.Add(1)
+		go s.runFollowerReads(s.FollowerReadRefreshInterval)
+	}
+
 	s.stmtQueue = queue.New(s.DefaultQueueCap, s.DefaultQueueBatchSz, s.DefaultQueueTimeout)
 	go s.runQueue()
 	s.logger.Printf("execute queue processing started with capacity %d, batch size %d, timeout %s",
@@ -423,11 +1001,68 @@ func (s *Service) Start() error {
 	return nil
 }
 
-// Close closes the service.
+// configureHTTP2 applies the HTTP/2 settings of the service to its HTTPS
+// server, which otherwise serves HTTP/2 with the default settings.
+func (s *Service) configureHTTP2() error {
+	if s.HTTP2Disabled {
+		// A non-nil, empty map disables HTTP/2, and h2 must no longer be
+		// offered to clients during the TLS handshake.
+		s.httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		protos := make([]string, 0, len(s.tlsConfig.NextProtos))
+		for _, p := range s.tlsConfig.NextProtos {
+			if p != http2.NextProtoTLS {
+				protos = append(protos, p)
+			}
+		}
+		s.tlsConfig.NextProtos = protos
+		return nil
+	}
+	return http2.ConfigureServer(&s.httpServer, &http2.Server{
+		MaxConcurrentStreams: s.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          s.HTTP2IdleTimeout,
+	})
+}
+
+// Close closes the service immediately, interrupting any in-progress
+// requests. Use Shutdown to wait for them to complete.
 func (s *Service) Close() {
 	s.logger.Println("closing HTTP service on", s.ln.Addr().String())
-	s.httpServer.Shutdown(context.Background())
+	s.closeStreams()
+	s.httpServer.Close()
+	s.closeQueue()
+}
 
+// Shutdown gracefully stops the service. It stops accepting connections, and
+// waits for in-progress requests to complete before closing the service.
+// Long-lived streams, which would never complete, are ended first. If ctx is
+// done first, the remaining connections are closed, interrupting their
+// requests, and the context's error is returned.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.logger.Println("shutting down HTTP service on", s.ln.Addr().String())
+	s.closeStreams()
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.logger.Printf("in-progress requests on %s not complete before shutdown deadline: %s",
+			s.ln.Addr().String(), err.Error())
+		s.httpServer.Close()
+	}
+	s.closeQueue()
+	return err
+}
+
+// closeStreams ends the long-lived streams being served.
+func (s *Service) closeStreams() {
+	s.streamsDoneOnce.Do(func() {
+		close(s.streamsDone)
+	})
+}
+
+// closeQueue stops processing of the execute queue, and of any other
+// background work, and closes the listener.
+func (s *Service) closeQueue() {
+	if s.followerReads != nil {
+		s.followerReads.Stop()
+	}
 	s.stmtQueue.Close()
 	select {
 	case <-s.queueDone:
@@ -446,9 +1081,30 @@ func (s *Service) HTTPS() bool {
 
 // ServeHTTP allows Service to serve HTTP requests.
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(RequestIDHTTPHeader, assignRequestID(r))
+	r, span := startRequestSpan(r)
+	if sampled := s.sampleRequest(r); sampled || span.IsRecording() {
+		lw := newLoggingResponseWriter(w)
+		w = lw
+		defer func() {
+			endRequestSpan(span, lw.statusCode)
+			if sampled {
+				s.logRequestf(r, "%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL.Path,
+					lw.statusCode, time.Since(lw.start))
+			}
+		}()
+	} else {
+		defer span.End()
+	}
+
 	s.addBuildVersion(w)
-	s.addAllowHeaders(w)
+	s.addCORSHeaders(w, r)
+	s.addResponseHeaders(w)
 
+	if isPreflight(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -460,24 +1116,116 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if limit := s.maxRequestBytes(r); limit > 0 {
+		if r.ContentLength > limit {
+			writeRequestTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	if s.CompressResponses && s.compressible(r, params) {
+		if enc := NegotiateEncoding(r.Header.Get("Accept-Encoding")); enc != "" {
+			cw := newCompressResponseWriter(w, enc, s.CompressMinSize)
+			defer cw.Close()
+			w = cw
+		}
+	}
+
+	if !s.checkRateLimit(w, r) {
+		return
+	}
+
+	// If the client set a timeout, the entire handling of the request,
+	// including admission, authentication, and leader resolution, must
+	// complete within it.
+	if params.HasKey("timeout") {
+		ctx, cancel := context.WithTimeout(r.Context(), params.Timeout(defaultTimeout))
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// Subscriptions are long-lived, so are not admitted by the limiter.
+	if s.limiter != nil && strings.HasPrefix(r.URL.Path, "/db/") && r.URL.Path != "/db/subscribe" {
+		p, err := ParsePriority(r.Header.Get(PriorityHTTPHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.limiter.TryAcquire() {
+			if s.followerReads != nil && r.URL.Path == "/db/query" && s.store.IsLeader() &&
+				s.redirectToFollower(w, r) {
+				return
+			}
+			if err := s.limiter.Acquire(r.Context(), p); err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					writeDeadlineExceeded(w)
+					return
+				}
+				http.Error(w, "request not admitted: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer s.limiter.Release()
+	}
+
 	switch {
 	case r.URL.Path == "/" || r.URL.Path == "":
 		http.Redirect(w, r, "/status", http.StatusFound)
 	case strings.HasPrefix(r.URL.Path, "/db/execute"):
 		stats.Add(numExecutions, 1)
 		s.handleExecute(w, r, params)
+	case r.URL.Path == "/db/queue":
+		s.handleQueue(w, r, params)
+	case r.URL.Path == "/db/warmup":
+		s.handleWarmup(w, r, params)
+	case strings.HasPrefix(r.URL.Path, "/db/tables/") && strings.HasSuffix(r.URL.Path, "/rows"):
+		stats.Add(numTableRows, 1)
+		s.handleTableRows(w, r, params)
+	case r.URL.Path == "/db/indexes":
+		stats.Add(numIndexes, 1)
+		s.handleIndexes(w, r, params)
+	case strings.HasPrefix(r.URL.Path, "/db/tables/") && strings.HasSuffix(r.URL.Path, "/checksum"):
+		stats.Add(numTableChecksums, 1)
+		s.handleTableChecksum(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/db/query"):
 		stats.Add(numQueries, 1)
 		s.handleQuery(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/db/request"):
 		stats.Add(numRequests, 1)
 		s.handleRequest(w, r, params)
+	case r.URL.Path == "/db/backup/validate":
+		s.handleBackupValidate(w, r, params)
+	case r.URL.Path == "/db/vacuum":
+		stats.Add(numVacuums, 1)
+		s.handleVacuum(w, r, params)
+	case r.URL.Path == "/db/migrate":
+		stats.Add(numMigrations, 1)
+		s.handleMigrate(w, r, params)
+	case r.URL.Path == "/db/migrate/preview":
+		stats.Add(numMigratePreviews, 1)
+		s.handleMigratePreview(w, r, params)
+	case r.URL.Path == "/db/import":
+		stats.Add(numImports, 1)
+		s.handleImport(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/db/backup"):
 		stats.Add(numBackups, 1)
 		s.handleBackup(w, r, params)
+	case r.URL.Path == "/db/file":
+		s.handleFile(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/db/load"):
 		stats.Add(numLoad, 1)
 		s.handleLoad(w, r, params)
+	case r.URL.Path == "/db/subscribe":
+		s.handleSubscribe(w, r, params)
+	case r.URL.Path == "/events":
+		s.handleEvents(w, r, params)
+	case r.URL.Path == "/db/replicate-to":
+		stats.Add(numReplicateTo, 1)
+		s.handleReplicateTo(w, r, params)
+	case r.URL.Path == "/snapshot":
+		stats.Add(numSnapshots, 1)
+		s.handleSnapshot(w, r, params)
 	case r.URL.Path == "/boot":
 		stats.Add(numBoot, 1)
 		s.handleBoot(w, r, params)
@@ -486,8 +1234,23 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(r.URL.Path, "/status"):
 		stats.Add(numStatus, 1)
 		s.handleStatus(w, r, params)
+	case r.URL.Path == "/leader":
+		stats.Add(numLeader, 1)
+		s.handleLeader(w, r, params)
+	case r.URL.Path == "/config/flags":
+		s.handleFlags(w, r, params)
+	case r.URL.Path == "/node/advertise":
+		s.handleAdvertise(w, r, params)
+	case strings.HasPrefix(r.URL.Path, "/nodes/") && strings.HasSuffix(r.URL.Path, "/catchup"):
+		s.handleNodeCatchup(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/nodes"):
 		s.handleNodes(w, r, params)
+	case r.URL.Path == "/cluster/clockskew":
+		s.handleClockSkew(w, r, params)
+	case r.URL.Path == "/cluster/query":
+		s.handleClusterQuery(w, r, params)
+	case r.URL.Path == "/cluster/verify-row":
+		s.handleVerifyRow(w, r, params)
 	case strings.HasPrefix(r.URL.Path, "/readyz"):
 		stats.Add(numReadyz, 1)
 		s.handleReadyz(w, r, params)
@@ -513,10 +1276,92 @@ func (s *Service) RegisterStatus(key string, stat StatusReporter) error {
 	return nil
 }
 
-// handleRemove handles cluster-remove requests.
+// RegisterHealthCheck registers a named check which must pass for the node
+// to be reported as ready.
+func (s *Service) RegisterHealthCheck(name string, check HealthCheck) error {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	if _, ok := s.healthChecks[name]; ok {
+		return fmt.Errorf("health check already registered with name %s", name)
+	}
+	s.healthChecks[name] = check
+
+	return nil
+}
+
+// RegisterPreExecuteHook registers a hook which is passed every statement
+// received for execution, and may reject the request. Hooks run in the order
+// they are registered.
+func (s *Service) RegisterPreExecuteHook(hook PreExecuteHook) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.preExecuteHooks = append(s.preExecuteHooks, hook)
+}
+
+// RegisterPostExecuteHook registers a hook which is called after the
+// statements of each request are executed, for example to audit them.
+func (s *Service) RegisterPostExecuteHook(hook PostExecuteHook) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.postExecuteHooks = append(s.postExecuteHooks, hook)
+}
+
+// preExecute passes each statement to every registered pre-execute hook,
+// returning the first error returned by a hook.
+func (s *Service) preExecute(r *http.Request, stmts []*proto.Statement) error {
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	for _, hook := range s.preExecuteHooks {
+		for _, stmt := range stmts {
+			if err := hook(r, stmt); err != nil {
+				stats.Add(numPreExecuteRejections, 1)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postExecute calls every registered post-execute hook.
+func (s *Service) postExecute(r *http.Request, stmts []*proto.Statement, err error) {
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	for _, hook := range s.postExecuteHooks {
+		hook(r, stmts, err)
+	}
+}
+
+// runHealthChecks runs all registered health checks, in name order. It
+// returns a line reporting the result of each check, and whether all passed.
+func (s *Service) runHealthChecks() (string, bool) {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+
+	names := make([]string, 0, len(s.healthChecks))
+	for name := range s.healthChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	ok := true
+	for _, name := range names {
+		if err := s.healthChecks[name](); err != nil {
+			ok = false
+			b.WriteString(fmt.Sprintf("\n[+]%s failed: %s", name, err.Error()))
+		} else {
+			b.WriteString(fmt.Sprintf("\n[+]%s ok", name))
+		}
+	}
+	return b.String(), ok
+}
+
+// handleRemove handles cluster-remove requests. The node to remove is named
+// by its ID, or by its Raft address.
 func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	if !s.CheckRequestPerm(r, auth.PermRemove) {
-		w.WriteHeader(http.StatusUnauthorized)
+		s.writeUnauthorized(w, r)
 		return
 	}
 
@@ -527,7 +1372,7 @@ func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request, qp QueryP
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	m := map[string]string{}
@@ -536,16 +1381,39 @@ func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request, qp QueryP
 		return
 	}
 
-	if len(m) != 1 {
-		w.WriteHeader(http.StatusBadRequest)
+	// The node may be named by its ID, or by its Raft address, which is
+	// resolved to an ID using the current cluster configuration.
+	id, byID := m["id"]
+	addr, byAddr := m["addr"]
+	if byID && byAddr {
+		http.Error(w, "only one of id and addr may be set", http.StatusBadRequest)
 		return
 	}
-
-	remoteID, ok := m["id"]
-	if !ok {
+	if len(m) != 1 || (!byID && !byAddr) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+	servers := store.Servers(sNodes)
+	remoteID := id
+	if byAddr {
+		remoteID, _ = servers.IDForAddr(addr)
+		if remoteID == "" {
+			http.Error(w, fmt.Sprintf("no node with address %s in the cluster", addr), http.StatusNotFound)
+			return
+		}
+	} else if !servers.Contains(id) {
+		http.Error(w, fmt.Sprintf("no node with ID %s in the cluster", id), http.StatusNotFound)
+		return
+	}
 
 	rn := &proto.RemoveNodeRequest{
 		Id: remoteID,
@@ -565,20 +1433,21 @@ func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request, qp QueryP
 				return
 			}
 			if addr == "" {
-				stats.Add(numLeaderNotFound, 1)
-				http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+				s.writeLeaderNotFound(w)
 				return
 			}
 
-			username, password, ok := r.BasicAuth()
-			if !ok {
-				username = ""
+			creds, err := s.forwardCredentials(r)
+			if err != nil {
+				s.writeNotForwardable(w, err)
+				return
 			}
 
 			w.Header().Add(ServedByHTTPHeader, addr)
-			removeErr := s.cluster.RemoveNode(rn, addr, makeCredentials(username, password), qp.Timeout(defaultTimeout))
+			removeErr := s.cluster.RemoveNode(rn, addr, creds, qp.Timeout(defaultTimeout))
 			if removeErr != nil {
 				if removeErr.Error() == "unauthorized" {
+					s.addAuthChallenge(w, r)
 					http.Error(w, "remote remove node not authorized", http.StatusUnauthorized)
 				} else {
 					http.Error(w, removeErr.Error(), http.StatusInternalServerError)
@@ -596,7 +1465,7 @@ func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request, qp QueryP
 // handleBackup returns the consistent database snapshot.
 func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	if !s.CheckRequestPerm(r, auth.PermBackup) {
-		w.WriteHeader(http.StatusUnauthorized)
+		s.writeUnauthorized(w, r)
 		return
 	}
 
@@ -613,7 +1482,27 @@ func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request, qp QueryP
 	}
 	addBackupFormatHeader(w, qp)
 
-	err := s.store.Backup(br, w)
+	// An explicitly named algorithm is advertised via Content-Encoding. A bare
+	// "compress" keeps the historical behaviour of returning a gzip file
+	// without the header.
+	var dst io.Writer = w
+	var zw *zstd.Encoder
+	switch qp["compress"] {
+	case "gzip":
+		dst = &contentEncodingWriter{ResponseWriter: w, encoding: "gzip"}
+	case "zstd":
+		var err error
+		zw, err = zstd.NewWriter(&contentEncodingWriter{ResponseWriter: w, encoding: "zstd"})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("zstd writer: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		dst = zw
+	}
+
+	span := startSpan(r, "store.Backup")
+	err := s.store.Backup(br, dst)
+	endSpan(span, err)
 	if err != nil {
 		if err == store.ErrNotLeader {
 			if s.DoRedirect(w, r, qp) {
@@ -627,26 +1516,34 @@ func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request, qp QueryP
 				return
 			}
 			if addr == "" {
-				stats.Add(numLeaderNotFound, 1)
-				http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+				s.writeLeaderNotFound(w)
 				return
 			}
 
-			username, password, ok := r.BasicAuth()
-			if !ok {
-				username = ""
+			creds, err := s.forwardCredentials(r)
+			if err != nil {
+				s.writeNotForwardable(w, err)
+				return
 			}
 
 			w.Header().Add(ServedByHTTPHeader, addr)
-			backupErr := s.cluster.Backup(br, addr, makeCredentials(username, password), qp.Timeout(defaultTimeout), w)
+			span := startForwardSpan(r, "forward.Backup", addr)
+			backupErr := s.cluster.Backup(br, addr, creds, qp.Timeout(defaultTimeout), dst)
+			endSpan(span, backupErr)
 			if backupErr != nil {
 				if backupErr.Error() == "unauthorized" {
+					s.addAuthChallenge(w, r)
 					http.Error(w, "remote backup not authorized", http.StatusUnauthorized)
 				} else {
 					http.Error(w, backupErr.Error(), http.StatusInternalServerError)
 				}
 				return
 			}
+			if zw != nil {
+				if err := zw.Close(); err != nil {
+					s.logger.Println("closing zstd writer failed:", err.Error())
+				}
+			}
 			stats.Add(numRemoteBackups, 1)
 			return
 		} else if err == store.ErrInvalidVacuum {
@@ -656,14 +1553,47 @@ func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request, qp QueryP
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			s.logger.Println("closing zstd writer failed:", err.Error())
+			return
+		}
+	}
 
 	s.lastBackup = time.Now()
 }
 
-// handleLoad loads the database from the given SQLite database file or SQLite dump.
-func (s *Service) handleLoad(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+// handleFile returns a consistent copy of this node's SQLite database file.
+// Unlike a backup it is not Raft-aware, and always reflects the local node.
+func (s *Service) handleFile(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermBackup) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.store.CopyFile(w); err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+}
+
+// handleBackupValidate checks that the given SQLite database file or SQLite
+// dump is valid, without loading it.
+func (s *Service) handleBackupValidate(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
 	if !s.CheckRequestPerm(r, auth.PermLoad) {
-		w.WriteHeader(http.StatusUnauthorized)
+		s.writeUnauthorized(w, r)
 		return
 	}
 
@@ -672,361 +1602,2448 @@ func (s *Service) handleLoad(w http.ResponseWriter, r *http.Request, qp QueryPar
 		return
 	}
 
-	resp := NewResponse()
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	r.Body.Close()
 
+	format := "sql"
+	var checkErr error
 	if db.IsValidSQLiteData(b) {
-		s.logger.Printf("SQLite database file detected as load data")
-		lr := &proto.LoadRequest{
-			Data: b,
-		}
+		format = "binary"
+		checkErr = checkSQLiteData(b)
+	} else {
+		checkErr = db.CheckSQL(string(b))
+	}
 
-		err := s.store.Load(lr)
-		if err != nil && err != store.ErrNotLeader {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		} else if err != nil && err == store.ErrNotLeader {
-			if s.DoRedirect(w, r, qp) {
-				return
-			}
+	errs := make([]string, 0)
+	if checkErr != nil {
+		errs = append(errs, checkErr.Error())
+	}
+	s.writeJSON(w, qp, map[string]interface{}{
+		"ok":     checkErr == nil,
+		"format": format,
+		"errors": errs,
+	})
+}
 
-			addr, err := s.store.LeaderAddr()
-			if err != nil {
-				http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
-					http.StatusInternalServerError)
-				return
-			}
-			if addr == "" {
-				stats.Add(numLeaderNotFound, 1)
-				http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
-				return
-			}
+// handleMigratePreview applies the submitted statements to a sandbox holding
+// a copy of the current schema, but no data, and returns the result of each
+// statement along with the resulting schema. The sandbox is then discarded,
+// so the database is never changed. Since the statements are executed, if
+// only against the sandbox, the execute permission is required.
+func (s *Service) handleMigratePreview(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-			username, password, ok := r.BasicAuth()
-			if !ok {
-				username = ""
-			}
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
+		return
+	}
 
-			w.Header().Add(ServedByHTTPHeader, addr)
-			loadErr := s.cluster.Load(lr, addr, makeCredentials(username, password),
-				qp.Timeout(defaultTimeout), qp.Retries(0))
-			if loadErr != nil {
-				if loadErr.Error() == "unauthorized" {
-					http.Error(w, "remote load not authorized", http.StatusUnauthorized)
-				} else {
-					http.Error(w, loadErr.Error(), http.StatusInternalServerError)
-				}
-				return
-			}
-			stats.Add(numRemoteLoads, 1)
-			// Allow this if block to exit, so response remains as before request
-			// forwarding was put in place.
-		}
-	} else {
-		// No JSON structure expected for this API.
-		queries := []string{string(b)}
-		er := executeRequestFromStrings(queries, qp.Timings(), false)
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 
-		results, err := s.store.Execute(er)
-		if err != nil {
-			if err == store.ErrNotLeader {
-				if s.DoRedirect(w, r, qp) {
-					return
-				}
-			}
-			resp.Error = err.Error()
-		} else {
-			resp.Results.ExecuteResult = results
-		}
-		resp.end = time.Now()
-	}
-	s.writeResponse(w, r, qp, resp)
-}
-
-// handleBoot handles booting this node using a SQLite file.
-func (s *Service) handleBoot(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	if !s.CheckRequestPerm(r, auth.PermLoad) {
-		w.WriteHeader(http.StatusUnauthorized)
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
+	r.Body.Close()
 
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	stmts, err := parseRequestBody(r, b, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	bufReader := bufio.NewReader(r.Body)
-	peek, err := bufReader.Peek(db.SQLiteHeaderSize)
+	qr := &proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: `SELECT sql FROM sqlite_master
+						WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`,
+				},
+			},
+		},
+		Level:     qp.Level(),
+		Freshness: qp.Freshness().Nanoseconds(),
+	}
+	rows, err := s.forwardQuery(r, qp, qr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		s.writeForwardQueryError(w, r, err)
 		return
 	}
-	if !db.IsValidSQLiteData(peek) {
-		http.Error(w, "invalid SQLite data", http.StatusBadRequest)
+	if len(rows) != 1 {
+		http.Error(w, "unexpected number of schema results", http.StatusInternalServerError)
 		return
 	}
+	if rows[0].Error != "" {
+		http.Error(w, rows[0].Error, http.StatusInternalServerError)
+		return
+	}
+	schema := make([]string, 0, len(rows[0].Values))
+	for _, v := range rows[0].Values {
+		schema = append(schema, v.GetParameters()[0].GetS())
+	}
 
-	s.logger.Printf("starting boot process")
-	_, err = s.store.ReadFrom(bufReader)
+	results, objs, err := db.PreviewSchema(schema, &proto.Request{
+		Transaction: qp.Tx(),
+		Statements:  stmts,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		http.Error(w, fmt.Sprintf("preview: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
+
+	res := make([]*encoding.Result, len(results))
+	for i := range results {
+		res[i], _ = encoding.NewResultFromExecuteResult(results[i])
+	}
+	s.writeJSON(w, qp, map[string]interface{}{
+		"results": res,
+		"schema":  objs,
+	})
 }
 
-// handleStatus returns status on the system.
-func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+// handleVacuum performs a VACUUM of the database on every node, reporting the
+// size of this node's database files before and after. It must be sent to the
+// Leader, so other nodes redirect the client to it.
+func (s *Service) handleVacuum(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
 		return
 	}
 
-	if r.Method != "GET" {
+	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	storeStatus, err := s.store.Stats()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("store stats: %s", err.Error()),
-			http.StatusInternalServerError)
+	if s.rejectReadOnly(w, r, qp) {
 		return
 	}
 
-	clusterStatus, err := s.cluster.Stats()
+	startT := time.Now()
+	before, after, err := s.store.VacuumReplicated()
+	if err == store.ErrNotLeader {
+		leaderAPIAddr := s.setLeaderHeader(w, r)
+		if leaderAPIAddr == "" {
+			s.writeLeaderNotFound(w)
+			return
+		}
+		http.Redirect(w, r, redirectURL(r, leaderAPIAddr), http.StatusMovedPermanently)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("cluster stats: %s", err.Error()),
-			http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("vacuum: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 
-	rt := map[string]interface{}{
-		"GOARCH":        runtime.GOARCH,
-		"GOOS":          runtime.GOOS,
-		"GOMAXPROCS":    runtime.GOMAXPROCS(0),
-		"num_cpu":       runtime.NumCPU(),
-		"num_goroutine": runtime.NumGoroutine(),
-		"version":       runtime.Version(),
-	}
+	s.writeJSON(w, qp, map[string]interface{}{
+		"before":    before,
+		"after":     after,
+		"reclaimed": (before.FileSize + before.WALSize) - (after.FileSize + after.WALSize),
+		"time":      time.Since(startT).Seconds(),
+	})
+}
 
-	oss := map[string]interface{}{
-		"pid":       os.Getpid(),
-		"ppid":      os.Getppid(),
-		"page_size": os.Getpagesize(),
-	}
-	executable, err := os.Executable()
-	if err == nil {
-		oss["executable"] = executable
-	}
-	hostname, err := os.Hostname()
-	if err == nil {
-		oss["hostname"] = hostname
-	}
+// handleMigrate applies, in order, each of the submitted migrations which is
+// not yet recorded in the migrations table. The statements of every migration
+// are checked, and passed to the pre-execute hooks, as if they were sent to
+// /db/execute, before any is applied. Each migration is applied, and
+// recorded, in its own transaction. Application stops at the first migration
+// which fails, and the response names it, so resubmitting the same migrations
+// once the failure is fixed resumes where application stopped.
+func (s *Service) handleMigrate(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	qs, err := s.stmtQueue.Stats()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("queue stats: %s", err.Error()),
-			http.StatusInternalServerError)
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
 		return
 	}
-	s.seqNumMu.Lock()
-	qs["sequence_number"] = s.seqNum
-	s.seqNumMu.Unlock()
-	queueStats := map[string]interface{}{
-		"_default": qs,
-	}
-	httpStatus := map[string]interface{}{
-		"bind_addr": s.Addr().String(),
-		"auth":      prettyEnabled(s.credentialStore != nil),
-		"cluster":   clusterStatus,
-		"queue":     queueStats,
-		"tls":       s.tlsStats(),
-	}
 
-	nodeStatus := map[string]interface{}{
-		"start_time":   s.start,
-		"current_time": time.Now(),
-		"uptime":       time.Since(s.start).String(),
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Build the status response.
-	status := map[string]interface{}{
-		"os":      oss,
-		"runtime": rt,
-		"store":   storeStatus,
-		"http":    httpStatus,
-		"node":    nodeStatus,
-	}
-	if !s.lastBackup.IsZero() {
-		status["last_backup_time"] = s.lastBackup
-	}
-	if s.BuildInfo != nil {
-		status["build"] = s.BuildInfo
+	if s.rejectReadOnly(w, r, qp) {
+		return
 	}
 
-	// Add any registered StatusReporters.
-	func() {
-		s.statusMu.RLock()
-		defer s.statusMu.RUnlock()
-		for k, v := range s.statuses {
-			stat, err := v.Stats()
-			if err != nil {
-				http.Error(w, fmt.Sprintf("registered stats: %s", err.Error()),
-					http.StatusInternalServerError)
-				return
-			}
-			status[k] = stat
-		}
-	}()
-
-	var b []byte
-	if qp.Pretty() {
-		b, err = json.MarshalIndent(status, "", "    ")
-	} else {
-		b, err = json.Marshal(status)
-	}
+	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("JSON marshal: %s", err.Error()),
-			http.StatusInternalServerError)
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
+	r.Body.Close()
 
-	b, err = getSubJSON(b, qp.Key())
+	migrations, err := ParseMigrations(b, s.MaxStatementBytes)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("JSON subkey: %s", err.Error()),
-			http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	for _, m := range migrations {
+		if err := s.checkTablePerms(r, auth.PermExecute, m.Statements); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := s.injectParams(r, m.Statements); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.preExecute(r, m.Statements); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := command.Rewrite(m.Statements, !qp.NoRewriteRandom()); err != nil {
+			http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	_, err = w.Write(b)
+	rows, err := s.forwardQuery(r, qp, &proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: selectMigrations,
+				},
+			},
+		},
+		Level: proto.QueryRequest_QUERY_REQUEST_LEVEL_WEAK,
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("write: %s", err.Error()),
-			http.StatusInternalServerError)
+		s.writeForwardQueryError(w, r, err)
 		return
 	}
+	recorded := make(map[string]bool)
+	if len(rows) == 1 && !strings.HasPrefix(rows[0].Error, "no such table") {
+		if rows[0].Error != "" {
+			http.Error(w, rows[0].Error, http.StatusInternalServerError)
+			return
+		}
+		for _, v := range rows[0].Values {
+			recorded[v.GetParameters()[0].GetS()] = true
+		}
+	}
+
+	resp := struct {
+		Applied []string `json:"applied"`
+		Skipped []string `json:"skipped"`
+		Failed  string   `json:"failed,omitempty"`
+		Error   string   `json:"error,omitempty"`
+	}{
+		Applied: make([]string, 0),
+		Skipped: make([]string, 0),
+	}
+	for _, m := range migrations {
+		if recorded[m.Name] {
+			resp.Skipped = append(resp.Skipped, m.Name)
+			continue
+		}
+		results, err := s.forwardExecute(r, qp, migrationRequest(m, time.Now()))
+		if err != nil {
+			s.postExecute(r, m.Statements, err)
+			s.writeForwardQueryError(w, r, err)
+			return
+		}
+		alreadyApplied, err := migrationResult(results)
+		s.postExecute(r, m.Statements, err)
+		if err != nil {
+			resp.Failed = m.Name
+			resp.Error = err.Error()
+			break
+		}
+		if alreadyApplied {
+			resp.Skipped = append(resp.Skipped, m.Name)
+			continue
+		}
+		stats.Add(numMigrationsApplied, 1)
+		resp.Applied = append(resp.Applied, m.Name)
+	}
+	s.writeJSON(w, qp, resp)
 }
 
-// handleNodes returns status on the other voting nodes in the system.
-// This attempts to contact all the nodes in the cluster, so may take
-// some time to return.
-func (s *Service) handleNodes(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+// handleImport inserts the rows of a CSV file, streamed in the request body,
+// into the table named by the table query parameter. The first row of the
+// file is a header row, naming the column into which each field is imported,
+// unless the columns query parameter maps the header to another column.
+// Rows are inserted in batches, each passed to the execute hooks, and the
+// response reports how many rows were imported, and the line and error of
+// each row which was not.
+func (s *Service) handleImport(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
 		return
 	}
 
-	if r.Method != "GET" {
+	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get nodes in the cluster, and possibly filter out non-voters.
-	sNodes, err := s.store.Nodes()
-	if err != nil {
-		statusCode := http.StatusInternalServerError
-		if err == store.ErrNotOpen {
-			statusCode = http.StatusServiceUnavailable
-		}
-		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+	if s.rejectReadOnly(w, r, qp) {
 		return
 	}
-	nodes := NewNodesFromServers(sNodes)
-	if !qp.NonVoters() {
-		nodes = nodes.Voters()
+
+	table := qp.Table()
+	if table == "" {
+		http.Error(w, "table not set", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerm(r, auth.PermExecute, table); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	cols, _, ok := s.tableInfo(w, r, qp, table, proto.QueryRequest_QUERY_REQUEST_LEVEL_WEAK)
+	if !ok {
+		return
 	}
 
-	// Now test the nodes
-	lAddr, err := s.store.LeaderAddr()
+	cr := csv.NewReader(r.Body)
+	header, err := cr.Read()
+	if err == io.EOF {
+		err = ErrNoHeader
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
-			http.StatusInternalServerError)
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
-	nodes.Test(s.cluster, lAddr, qp.Timeout(defaultTimeout))
-
-	enc := NewNodesRespEncoder(w, qp.Version() != "2")
-	if qp.Pretty() {
-		enc.SetIndent("", "    ")
+	columns, err := importColumns(header, qp.ColumnMap(), cols)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	err = enc.Encode(nodes)
+
+	ci := newCSVImporter(table, columns, qp.BatchSize(DefaultImportBatchSize),
+		func(er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+			if err := s.preExecute(r, er.Request.Statements); err != nil {
+				return nil, fmt.Errorf("%w: %w", errImportRejected, err)
+			}
+			results, err := s.forwardExecute(r, qp, er)
+			s.postExecute(r, er.Request.Statements, err)
+			return results, err
+		})
+	ci.dbTimeout = int64(qp.DBTimeout(0))
+	err = ci.Import(cr)
+	resp := ci.Result()
+	stats.Add(numImportedRows, resp.Rows)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("JSON marshal: %s", err.Error()),
-			http.StatusInternalServerError)
+		if resp.Rows == 0 && resp.Failed == 0 {
+			if errors.Is(err, errReadingCSV) {
+				writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, errImportRejected) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				s.writeForwardQueryError(w, r, err)
+			}
+			return
+		}
+		s.logRequestf(r, "import into %s stopped after %d rows: %s", table, resp.Rows, err.Error())
+		resp.Error = err.Error()
 	}
+	s.writeJSON(w, qp, resp)
 }
 
-// handleReadyz returns whether the node is ready.
-func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	if !s.CheckRequestPerm(r, auth.PermReady) {
-		w.WriteHeader(http.StatusUnauthorized)
+// handleLoad loads the database from the given SQLite database file or SQLite dump.
+func (s *Service) handleLoad(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermLoad) {
+		s.writeUnauthorized(w, r)
 		return
 	}
 
-	if r.Method != "GET" {
+	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	if qp.NoLeader() {
-		// Simply handling the HTTP request is enough.
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("[+]node ok"))
+	if s.rejectReadOnly(w, r, qp) {
 		return
 	}
 
-	lAddr, err := s.store.LeaderAddr()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
-			http.StatusInternalServerError)
+	// Gzipped load data is decompressed as it is read, whether or not the
+	// client set the Content-Encoding header.
+	bufReader := bufio.NewReader(r.Body)
+	magic, err := bufReader.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if r.Header.Get("Content-Encoding") == "gzip" || bytes.Equal(magic, gzipMagic) {
+		gzr, err := gzip.NewReader(bufReader)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid gzip load data: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		defer gzr.Close()
+		// The limit applies to the decompressed data too, so a small body
+		// cannot expand without bound.
+		var rc io.ReadCloser = gzr
+		if s.MaxLoadBytes > 0 {
+			rc = http.MaxBytesReader(w, gzr, s.MaxLoadBytes)
+		}
+		bufReader = bufio.NewReader(rc)
+		stats.Add(numGzipLoads, 1)
+	}
 
-	if lAddr == "" {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("[+]node ok\n[+]leader does not exist"))
+	// A SQLite file is streamed into the Store, or to the Leader, so large
+	// files need not be held in memory in full.
+	peek, err := bufReader.Peek(db.SQLiteFileHeaderSize)
+	if err != nil && err != io.EOF {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
-
+	if db.IsValidSQLiteData(peek) {
+		if s.store.IsLeader() {
+			s.loadChunked(w, r, qp, bufReader, peek, s.store.LoadChunk)
+			return
+		}
+		s.forwardLoad(w, r, qp, bufReader, peek)
+		return
+	}
+
+	resp := NewResponse()
+	b, err := io.ReadAll(bufReader)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	// No JSON structure expected for this API.
+	queries := []string{string(b)}
+	er := executeRequestFromStrings(queries, qp.Timings(), false)
+
+	results, err := s.store.Execute(er)
+	if err != nil {
+		if err == store.ErrNotLeader {
+			if s.DoRedirect(w, r, qp) {
+				return
+			}
+		}
+		resp.Error = err.Error()
+	} else {
+		resp.Results.ExecuteResult = results
+	}
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// forwardLoad streams the SQLite file read from br to the Leader, in chunks,
+// as loadChunked does on the Leader itself. header is the start of the file,
+// as already peeked from br.
+func (s *Service) forwardLoad(w http.ResponseWriter, r *http.Request, qp QueryParams, br io.Reader, header []byte) {
+	if s.DoRedirect(w, r, qp) {
+		return
+	}
+
+	addr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	if addr == "" {
+		s.writeLeaderNotFound(w)
+		return
+	}
+
+	creds, err := s.forwardCredentials(r)
+	if err != nil {
+		s.writeNotForwardable(w, err)
+		return
+	}
+
+	w.Header().Add(ServedByHTTPHeader, addr)
+	timeout := qp.Timeout(defaultTimeout)
+	if s.loadChunked(w, r, qp, br, header, func(lcr *proto.LoadChunkRequest) error {
+		err := s.cluster.LoadChunk(lcr, addr, creds, timeout)
+		if err != nil && err.Error() == "unsupported" {
+			return fmt.Errorf("leader at %s does not support forwarded loads, send the load to the leader", addr)
+		}
+		return err
+	}) {
+		stats.Add(numRemoteLoads, 1)
+	}
+}
+
+// loadChunked streams the SQLite file read from br, in chunks of LoadChunkSize
+// bytes, to load, which loads each into the Store or forwards it to the
+// Leader. header is the start of the file, as already peeked from br. If the
+// upload fails part way through, the chunks applied so far are discarded,
+// leaving the database unchanged. Returns whether the file was loaded.
+func (s *Service) loadChunked(w http.ResponseWriter, r *http.Request, qp QueryParams, br io.Reader, header []byte,
+	load func(lcr *proto.LoadChunkRequest) error) bool {
+	defer r.Body.Close()
+	s.logRequestf(r, "SQLite database file detected as load data, streaming in chunks")
+
+	lr := &loadReader{r: br}
+	lr.expected, _ = db.SQLiteDataSize(header)
+	chunker := chunking.NewChunker(lr, s.LoadChunkSize)
+
+	fail := func(applied bool, err error, code int) {
+		if applied {
+			if abortErr := load(chunker.Abort()); abortErr != nil {
+				s.logRequestf(r, "failed to abort chunked load: %s", abortErr.Error())
+			}
+		}
+		writeBodyError(w, err, err.Error(), code)
+	}
+
+	applied := false
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fail(applied, err, http.StatusBadRequest)
+			return false
+		}
+
+		if err := load(chunk); err != nil {
+			if err.Error() == "unauthorized" {
+				s.addAuthChallenge(w, r)
+				fail(applied, errors.New("remote load not authorized"), http.StatusUnauthorized)
+				return false
+			}
+			code := http.StatusInternalServerError
+			if err == store.ErrNotLeader || err == store.ErrNotReady {
+				code = http.StatusServiceUnavailable
+			}
+			if err == store.ErrNotLeader {
+				s.setLeaderHeader(w, r)
+			}
+			fail(applied, err, code)
+			return false
+		}
+		applied = true
+		if chunk.IsLast {
+			break
+		}
+	}
+	stats.Add(numChunkedLoads, 1)
+
+	resp := NewResponse()
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+	return true
+}
+
+// loadReader counts the bytes read from a SQLite file being loaded. If the
+// size of the file is known, loadReader returns an error instead of io.EOF
+// should the file end early.
+type loadReader struct {
+	r        io.Reader
+	n        int64
+	expected int64
+}
+
+// Read implements io.Reader.
+func (l *loadReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if err == io.EOF && l.n < l.expected {
+		return n, fmt.Errorf("load data truncated: received %d of %d bytes", l.n, l.expected)
+	} else if err != nil && err != io.EOF {
+		return n, fmt.Errorf("load data read failed after %d bytes: %w", l.n, err)
+	}
+	return n, err
+}
+
+// replicateToRequest is the body of a request to replicate the database to
+// another cluster.
+type replicateToRequest struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// replicateToResponse reports the outcome of replicating the database to
+// another cluster.
+type replicateToResponse struct {
+	Target   string `json:"target"`
+	Bytes    int64  `json:"bytes"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleSnapshot triggers a Raft snapshot on the Leader, truncating its Raft
+// log, and returns the index of the most recent snapshot. Requests sent to
+// any other node are redirected to the Leader.
+func (s *Service) handleSnapshot(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermSnapshot) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.store.IsLeader() {
+		leaderAPIAddr := s.setLeaderHeader(w, r)
+		if leaderAPIAddr == "" {
+			s.writeLeaderNotFound(w)
+			return
+		}
+		http.Redirect(w, r, redirectURL(r, leaderAPIAddr), http.StatusMovedPermanently)
+		return
+	}
+
+	err := s.store.Snapshot(0)
+	switch {
+	case err == nil, errors.Is(err, raft.ErrNothingNewToSnapshot):
+	case err == store.ErrSnapshotInProgress, err == store.ErrLoadInProgress:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	idx, err := s.store.LastSnapshotIndex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, qp, map[string]interface{}{"index": idx})
+}
+
+// handleReplicateTo streams a binary backup of the database to the /db/load
+// endpoint of the Leader of another cluster. The target must be in the
+// replication allowlist.
+func (s *Service) handleReplicateTo(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermBackup) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rr replicateToRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		writeBodyError(w, err, fmt.Sprintf("invalid replicate request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+	target, err := url.Parse(rr.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") ||
+		target.Host == "" || target.User != nil || strings.Trim(target.Path, "/") != "" ||
+		target.RawQuery != "" || target.Fragment != "" {
+		http.Error(w, "url must be of the form http[s]://host:port", http.StatusBadRequest)
+		return
+	}
+	if !s.replicateAllowed(target.Host) {
+		http.Error(w, fmt.Sprintf("replication to %s not allowed", target.Host), http.StatusForbidden)
+		return
+	}
+
+	if !s.store.IsLeader() {
+		if s.DoRedirect(w, r, qp) {
+			return
+		}
+		http.Error(w, store.ErrNotLeader.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: qp.Timeout(defaultReplicateTimeout),
+		// A redirect could send the data somewhere not in the allowlist.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if target.Scheme == "https" {
+		tlsConfig, err := rtls.CreateClientConfig("", "", s.CACertFile, "", rr.Insecure)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TLS config: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	// Stream the backup straight into the request to the target, so the
+	// database is never held in memory in full.
+	pr, pw := io.Pipe()
+	go func() {
+		br := &proto.BackupRequest{
+			Format: proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY,
+			Leader: true,
+		}
+		pw.CloseWithError(s.store.Backup(br, pw))
+	}()
+	cr := progress.NewCountingReader(pr)
+	monitor := progress.StartCountingMonitor(func(n int64) {
+		s.logRequestf(r, "replicated %d bytes of database to %s", n, target.Host)
+	}, cr)
+
+	start := time.Now()
+	err = s.postLoad(r.Context(), client, target.String(), &rr, cr)
+	pr.Close()
+	monitor.StopAndWait()
+
+	resp := replicateToResponse{
+		Target:   target.Host,
+		Bytes:    cr.Count(),
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		stats.Add(numReplicateToFailed, 1)
+		s.logRequestf(r, "replication of database to %s failed: %s", target.Host, err.Error())
+		resp.Error = err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	} else {
+		s.logRequestf(r, "replicated database, %d bytes, to %s in %s", resp.Bytes, target.Host, resp.Duration)
+	}
+	s.writeJSON(w, qp, resp)
+}
+
+// replicateAllowed returns whether replication to the given host:port is
+// allowed.
+func (s *Service) replicateAllowed(host string) bool {
+	for _, a := range s.ReplicateAllowlist {
+		if strings.EqualFold(a, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// postLoad posts the data read from body to the /db/load endpoint at the
+// given base URL, returning an error unless the load succeeded.
+func (s *Service) postLoad(ctx context.Context, client *http.Client, baseURL string, rr *replicateToRequest, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(baseURL, "/")+"/db/load", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if rr.Username != "" {
+		req.SetBasicAuth(rr.Username, rr.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response from target: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("target returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var lr struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &lr); err != nil {
+		return fmt.Errorf("invalid response from target: %s", err.Error())
+	}
+	if lr.Error != "" {
+		return fmt.Errorf("target load failed: %s", lr.Error)
+	}
+	return nil
+}
+
+// gzipMagic is the prefix of gzip-compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// handleBoot handles booting this node using a SQLite file.
+func (s *Service) handleBoot(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermLoad) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.rejectReadOnly(w, r, qp) {
+		return
+	}
+
+	bufReader := bufio.NewReader(r.Body)
+	peek, err := bufReader.Peek(db.SQLiteHeaderSize)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !db.IsValidSQLiteData(peek) {
+		http.Error(w, "invalid SQLite data", http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Printf("starting boot process")
+	_, err = s.store.ReadFrom(bufReader)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+}
+
+// handleStatus returns status on the system.
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	storeStatus, err := s.store.Stats()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("store stats: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+
+	clusterStatus, err := s.cluster.Stats()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cluster stats: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+
+	rt := map[string]interface{}{
+		"GOARCH":        runtime.GOARCH,
+		"GOOS":          runtime.GOOS,
+		"GOMAXPROCS":    runtime.GOMAXPROCS(0),
+		"num_cpu":       runtime.NumCPU(),
+		"num_goroutine": runtime.NumGoroutine(),
+		"version":       runtime.Version(),
+	}
+
+	oss := map[string]interface{}{
+		"pid":       os.Getpid(),
+		"ppid":      os.Getppid(),
+		"page_size": os.Getpagesize(),
+	}
+	executable, err := os.Executable()
+	if err == nil {
+		oss["executable"] = executable
+	}
+	hostname, err := os.Hostname()
+	if err == nil {
+		oss["hostname"] = hostname
+	}
+
+	qs, err := s.stmtQueue.Stats()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("queue stats: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	s.seqNumMu.Lock()
+	qs["sequence_number"] = s.seqNum
+	s.seqNumMu.Unlock()
+	queueStats := map[string]interface{}{
+		"_default": qs,
+	}
+	httpStatus := map[string]interface{}{
+		"bind_addr": s.Addr().String(),
+		"auth":      prettyEnabled(s.credentialStore != nil),
+		"read_only": s.ReadOnly,
+		"cluster":   clusterStatus,
+		"queue":     queueStats,
+		"tls":       s.tlsStats(),
+		"compression": map[string]interface{}{
+			"enabled":  s.CompressResponses,
+			"min_size": s.CompressMinSize,
+		},
+		"query_results": map[string]interface{}{
+			"rows":  s.queryRowsHist.Stats(),
+			"bytes": s.queryBytesHist.Stats(),
+		},
+		"latency_us": map[string]interface{}{
+			"execute": map[string]interface{}{
+				"local":   s.executeLocalHist.Stats(),
+				"forward": s.executeForwardHist.Stats(),
+			},
+			"query": map[string]interface{}{
+				"local":   s.queryLocalHist.Stats(),
+				"forward": s.queryForwardHist.Stats(),
+			},
+		},
+	}
+
+	nodeStatus := map[string]interface{}{
+		"start_time":   s.start,
+		"current_time": time.Now(),
+		"uptime":       time.Since(s.start).String(),
+	}
+	if len(s.Labels) > 0 {
+		nodeStatus["labels"] = s.Labels
+	}
+
+	// Build the status response.
+	status := map[string]interface{}{
+		"os":      oss,
+		"runtime": rt,
+		"store":   storeStatus,
+		"http":    httpStatus,
+		"node":    nodeStatus,
+	}
+	if !s.lastBackup.IsZero() {
+		status["last_backup_time"] = s.lastBackup
+	}
+	if s.BuildInfo != nil {
+		status["build"] = s.BuildInfo
+	}
+
+	// Add any registered StatusReporters.
+	func() {
+		s.statusMu.RLock()
+		defer s.statusMu.RUnlock()
+		for k, v := range s.statuses {
+			stat, err := v.Stats()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("registered stats: %s", err.Error()),
+					http.StatusInternalServerError)
+				return
+			}
+			status[k] = stat
+		}
+	}()
+
+	var b []byte
+	if qp.Pretty() {
+		b, err = json.MarshalIndent(status, "", "    ")
+	} else {
+		b, err = json.Marshal(status)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("JSON marshal: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+
+	b, err = getSubJSON(b, qp.Key())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("JSON subkey: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+
+	_, err = w.Write(b)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("write: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleNodes returns status on the other voting nodes in the system.
+// Every node is contacted in parallel, and reported unreachable if it does
+// not respond within the timeout, so the response is delayed by at most
+// the timeout.
+func (s *Service) handleNodes(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get nodes in the cluster, and possibly filter out non-voters.
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+	allNodes := NewNodesFromServers(sNodes)
+	nodes := allNodes
+	if !qp.NonVoters() {
+		nodes = nodes.Voters()
+	}
+
+	// Now test the nodes
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	nodes.Test(s.cluster, lAddr, qp.Timeout(defaultProbeTimeout))
+
+	enc := NewNodesRespEncoder(w, qp.Version() != "2")
+	if qp.Pretty() {
+		enc.SetIndent("", "    ")
+	}
+	// Every voter is tested, so the counts of all nodes are complete, even
+	// if non-voters are not listed.
+	enc.SetCounts(allNodes.Counts())
+	err = enc.Encode(nodes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("JSON marshal: %s", err.Error()),
+			http.StatusInternalServerError)
+	}
+}
+
+// handleLeader returns the Raft and API addresses of the Leader. If there is
+// no Leader, or its API address cannot be resolved, 503 Service Unavailable
+// is returned, with a Retry-After header, so clients can poll during an
+// election.
+func (s *Service) handleLeader(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	addr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	if addr == "" {
+		s.writeLeaderNotFound(w)
+		return
+	}
+	apiAddr, err := s.cluster.GetNodeAPIAddr(addr, qp.Timeout(defaultTimeout))
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("leader API address: %s", err.Error()),
+			http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSON(w, qp, map[string]string{
+		"addr":     addr,
+		"api_addr": apiAddr,
+	})
+}
+
+// handleAdvertise changes the API address this node advertises to the rest of
+// the cluster, without a restart. Only the Leader accepts the change.
+func (s *Service) handleAdvertise(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermAll) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.Advertiser == nil {
+		http.Error(w, "advertised address cannot be changed", http.StatusNotImplemented)
+		return
+	}
+
+	var req struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, ErrInvalidJSON.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(req.Addr); err != nil {
+		http.Error(w, fmt.Sprintf("invalid address %q: %s", req.Addr, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if !s.store.IsLeader() {
+		s.setLeaderHeader(w, r)
+		http.Error(w, store.ErrNotLeader.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	s.Advertiser.SetAPIAddr(req.Addr)
+	stats.Add(numAdvertise, 1)
+	s.logger.Printf("advertised API address changed to %s", req.Addr)
+}
+
+// handleNodeCatchup returns an estimate of how long the node, given by ID,
+// will take to catch up with the Leader. The node's apply rate is measured
+// by sampling its applied index twice, separated by the requested interval.
+func (s *Service) handleNodeCatchup(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/catchup")
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+	var node *store.Server
+	for _, n := range sNodes {
+		if n.ID == id {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		http.Error(w, fmt.Sprintf("node %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	if lAddr == "" {
+		s.writeLeaderNotFound(w)
+		return
+	}
+
+	timeout := qp.Timeout(defaultTimeout)
+	first, err := s.cluster.GetNodeMeta(node.Addr, timeout)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("node %s: %s", id, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	start := time.Now()
+	time.Sleep(qp.Interval(defaultCatchupInterval))
+	second, err := s.cluster.GetNodeMeta(node.Addr, timeout)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("node %s: %s", id, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	elapsed := time.Since(start)
+	leader, err := s.cluster.GetNodeMeta(lAddr, timeout)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader: %s", err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+
+	var rate float64
+	if second.AppliedIndex > first.AppliedIndex {
+		rate = float64(second.AppliedIndex-first.AppliedIndex) / elapsed.Seconds()
+	}
+	var lag uint64
+	if leader.CommitIndex > second.AppliedIndex {
+		lag = leader.CommitIndex - second.AppliedIndex
+	}
+
+	// The ETA is unknown, and so reported as null, if the node is behind
+	// but not currently making progress.
+	var eta *float64
+	if lag == 0 {
+		eta = new(float64)
+	} else if rate > 0 {
+		e := float64(lag) / rate
+		eta = &e
+	}
+
+	s.writeJSON(w, qp, map[string]interface{}{
+		"id":                  id,
+		"applied_index":       second.AppliedIndex,
+		"leader_commit_index": leader.CommitIndex,
+		"lag":                 lag,
+		"apply_rate":          rate,
+		"eta":                 eta,
+	})
+}
+
+// handleVerifyRow fetches a single row, identified by its table and primary
+// key, from every node in the cluster and reports any node whose copy of the
+// row differs from the Leader's.
+func (s *Service) handleVerifyRow(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Table string                 `json:"table"`
+		Key   map[string]interface{} `json:"key"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err, ErrInvalidJSON.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Table == "" || len(req.Key) == 0 {
+		http.Error(w, "table and key are required", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerm(r, auth.PermQuery, req.Table); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Build the lookup, sorting key columns so the statement is deterministic.
+	cols := make([]string, 0, len(req.Key))
+	for c := range req.Key {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	conds := make([]string, len(cols))
+	params := make([]*proto.Parameter, len(cols))
+	for i, c := range cols {
+		conds[i] = fmt.Sprintf("%s = ?", encoding.QuoteIdentifier(c))
+		p, err := makeParameter("", req.Key[c])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("key %s: %s", c, err.Error()), http.StatusBadRequest)
+			return
+		}
+		params[i] = p
+	}
+	qr := &proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: fmt.Sprintf("SELECT * FROM %s WHERE %s", encoding.QuoteIdentifier(req.Table),
+						strings.Join(conds, " AND ")),
+					Parameters: params,
+				},
+			},
+		},
+		Level: proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	}
+
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	if lAddr == "" {
+		s.writeLeaderNotFound(w)
+		return
+	}
+
+	type nodeRow struct {
+		Addr        string                 `json:"addr"`
+		Row         map[string]interface{} `json:"row"`
+		Differences []string               `json:"differences,omitempty"`
+		Error       string                 `json:"error,omitempty"`
+	}
+	creds, err := s.forwardCredentials(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	rows := make(map[string]*nodeRow, len(sNodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, n := range sNodes {
+		wg.Add(1)
+		go func(n *store.Server) {
+			defer wg.Done()
+			nr := &nodeRow{Addr: n.Addr}
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				rows[n.ID] = nr
+			}()
+
+			results, err := s.cluster.Query(qr, n.Addr, creds,
+				qp.Timeout(defaultTimeout))
+			if err != nil {
+				nr.Error = err.Error()
+				return
+			}
+			if len(results) != 1 {
+				nr.Error = "unexpected number of results"
+				return
+			}
+			if results[0].Error != "" {
+				nr.Error = results[0].Error
+				return
+			}
+			ar, err := encoding.NewAssociativeRowsFromQueryRows(results[0], false)
+			if err != nil {
+				nr.Error = err.Error()
+				return
+			}
+			if len(ar.Rows) > 1 {
+				nr.Error = "key matches more than one row"
+				return
+			}
+			if len(ar.Rows) == 1 {
+				nr.Row = ar.Rows[0]
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	var leaderRow *nodeRow
+	for _, nr := range rows {
+		if nr.Addr == lAddr {
+			leaderRow = nr
+		}
+	}
+	if leaderRow == nil || leaderRow.Error != "" {
+		http.Error(w, "unable to retrieve row from leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	consistent := true
+	for _, nr := range rows {
+		if nr.Error != "" {
+			consistent = false
+			continue
+		}
+		nr.Differences = rowDifferences(leaderRow.Row, nr.Row)
+		if len(nr.Differences) > 0 {
+			consistent = false
+		}
+	}
+
+	s.writeJSON(w, qp, map[string]interface{}{
+		"table":      req.Table,
+		"key":        req.Key,
+		"consistent": consistent,
+		"nodes":      rows,
+	})
+}
+
+// handleClusterQuery runs a query against every node in the cluster, or only
+// those nodes with the labels given by the label query parameter, and returns
+// each node's results. Each node reads its local database, so results reflect
+// that node's state.
+func (s *Service) handleClusterQuery(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	queries, err := requestQueries(r, qp, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerms(r, auth.PermQuery, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := s.injectParams(r, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s.strictQuery() {
+		for i := range queries {
+			if s.isWrite(queries[i].Sql) {
+				http.Error(w, fmt.Sprintf("statement may modify the database, use /db/execute: %s", queries[i].Sql),
+					http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := s.applyQueryLimit(r, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	qr := &proto.QueryRequest{
+		Request: &proto.Request{
+			Transaction: qp.Tx(),
+			DbTimeout:   int64(qp.DBTimeout(0)),
+			Statements:  queries,
+		},
+		Timings: qp.Timings(),
+		Level:   proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	}
+
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+
+	type nodeResults struct {
+		Addr    string            `json:"addr"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Results *DBResults        `json:"results,omitempty"`
+		Error   string            `json:"error,omitempty"`
+	}
+	creds, err := s.forwardCredentials(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	labels := qp.Labels()
+	maxRows := s.maxRows(r)
+	timeout := qp.Timeout(defaultTimeout)
+	nodes := make(map[string]*nodeResults, len(sNodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, n := range NewNodesFromServers(sNodes) {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			nr := &nodeResults{Addr: n.Addr}
+			meta, err := s.cluster.GetNodeMeta(n.Addr, timeout)
+			if err != nil {
+				nr.Error = err.Error()
+			} else {
+				n.Labels = meta.Labels
+				if !n.HasLabels(labels) {
+					return
+				}
+				nr.Labels = n.Labels
+				results, err := s.cluster.Query(qr, n.Addr, creds, timeout)
+				if err != nil {
+					nr.Error = err.Error()
+				} else {
+					truncateQueryRows(results, maxRows)
+					nr.Results = &DBResults{
+						QueryRows:       results,
+						AssociativeJSON: qp.Associative(),
+						BlobsAsArrays:   qp.BlobArray(),
+					}
+				}
+			}
+
+			// Nodes which can't be reached are reported even when filtering
+			// by label, since whether they match is unknown.
+			mu.Lock()
+			defer mu.Unlock()
+			nodes[n.ID] = nr
+		}(n)
+	}
+	wg.Wait()
+
+	s.writeJSON(w, qp, map[string]interface{}{
+		"nodes": nodes,
+	})
+}
+
+// handleClockSkew returns an estimate of the clock skew of every node in the
+// cluster, relative to the Leader. Skew is estimated by exchanging timestamps
+// with each node, and comparing the remote time with the midpoint of the
+// request's round trip. The estimate is accurate to within half the RTT.
+func (s *Service) handleClockSkew(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	sNodes, err := s.store.Nodes()
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == store.ErrNotOpen {
+			statusCode = http.StatusServiceUnavailable
+		}
+		http.Error(w, fmt.Sprintf("store nodes: %s", err.Error()), statusCode)
+		return
+	}
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	if lAddr == "" {
+		s.writeLeaderNotFound(w)
+		return
+	}
+
+	type nodeSkew struct {
+		Addr  string  `json:"addr"`
+		Skew  float64 `json:"skew"`
+		SkewS string  `json:"skew_s"`
+		RTT   float64 `json:"rtt"`
+		Error string  `json:"error,omitempty"`
+
+		skew time.Duration
+	}
+	skews := make(map[string]*nodeSkew, len(sNodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	timeout := qp.Timeout(defaultTimeout)
+	for _, n := range sNodes {
+		wg.Add(1)
+		go func(n *store.Server) {
+			defer wg.Done()
+			ns := &nodeSkew{Addr: n.Addr}
+			start := time.Now()
+			t, rtt, err := s.cluster.GetNodeTime(n.Addr, timeout)
+			if err != nil {
+				ns.Error = err.Error()
+			} else {
+				ns.skew = t.Sub(start.Add(rtt / 2))
+				ns.RTT = rtt.Seconds()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			skews[n.ID] = ns
+		}(n)
+	}
+	wg.Wait()
+
+	// Express all estimates relative to the Leader's clock.
+	var leaderSkew *nodeSkew
+	for _, ns := range skews {
+		if ns.Addr == lAddr {
+			leaderSkew = ns
+		}
+	}
+	if leaderSkew == nil || leaderSkew.Error != "" {
+		http.Error(w, "unable to retrieve time from leader", http.StatusServiceUnavailable)
+		return
+	}
+	offset := leaderSkew.skew
+	for _, ns := range skews {
+		if ns.Error == "" {
+			ns.skew -= offset
+			ns.Skew = ns.skew.Seconds()
+			ns.SkewS = ns.skew.String()
+		}
+	}
+
+	s.writeJSON(w, qp, map[string]interface{}{
+		"leader": lAddr,
+		"nodes":  skews,
+	})
+}
+
+// handleReadyz returns whether the node is ready.
+func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermReady) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if qp.NoLeader() {
+		// Simply handling the HTTP request is enough.
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("[+]node ok"))
+		return
+	}
+
+	lAddr, err := s.store.LeaderAddr()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if lAddr == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("[+]node ok\n[+]leader does not exist"))
+		return
+	}
+
 	_, err = s.cluster.GetNodeAPIAddr(lAddr, qp.Timeout(defaultTimeout))
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte(fmt.Sprintf("[+]node ok\n[+]leader not contactable: %s", err.Error())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(fmt.Sprintf("[+]node ok\n[+]leader not contactable: %s", err.Error())))
+		return
+	}
+
+	if !s.store.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("[+]node ok\n[+]leader ok\n[+]store not ready"))
+		return
+	}
+
+	okMsg := "[+]node ok\n[+]leader ok\n[+]store ok"
+	if qp.Sync() {
+		if _, err := s.store.Committed(qp.Timeout(defaultTimeout)); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(fmt.Sprintf("[+]node ok\n[+]leader ok\n[+]store ok\n[+]sync %s", err.Error())))
+			return
+		}
+		okMsg += "\n[+]sync ok"
+	}
+
+	checksMsg, checksOK := s.runHealthChecks()
+	if !checksOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(okMsg + checksMsg))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(okMsg + checksMsg))
+}
+
+func (s *Service) handleExecute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if deadlineExceeded(w, r) {
+		return
+	}
+
+	if qp.DryRun() {
+		s.validateExecute(w, r, qp)
+		return
+	}
+	if s.rejectReadOnly(w, r, qp) {
+		return
+	}
+
+	if qp.Queue() && r.Header.Get(IdempotencyKeyHeader) != "" && s.idempotencyEnabled() {
+		http.Error(w, "idempotency keys are not supported with queue", http.StatusBadRequest)
+		return
+	}
+	s.executeOrQueue(w, r, qp)
+}
+
+// executeOrQueue handles an execute request, queued if requested.
+func (s *Service) executeOrQueue(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if qp.Queue() && qp.Conditional() {
+		http.Error(w, "conditional execution is not supported with queue", http.StatusBadRequest)
+		return
+	}
+	if qp.Queue() {
+		stats.Add(numQueuedExecutions, 1)
+		s.queuedExecute(w, r, qp)
+	} else {
+		s.execute(w, r, qp)
+	}
+}
+
+// queuedExecute handles queued queries that modify the database.
+func (s *Service) queuedExecute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	resp := NewResponse()
+
+	// Perform a leader check, unless disabled. This prevents generating queued writes on
+	// a node that does not appear to be connected to a cluster (even a single-node cluster).
+	if !qp.NoLeader() {
+		addr, err := s.store.LeaderAddr()
+		if err != nil || addr == "" {
+			s.writeLeaderNotFound(w)
+			return
+		}
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	stmts, err := parseRequestBody(r, b, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		if errors.Is(err, ErrStatementTooLarge) || errors.Is(err, ErrTooManyStatements) ||
+			errors.Is(err, ErrMixedParameters) ||
+			(errors.Is(err, ErrNoStatements) && !qp.Wait()) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.checkTablePerms(r, auth.PermExecute, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := s.injectParams(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.preExecute(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := command.Rewrite(stmts, !qp.NoRewriteRandom()); err != nil {
+		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	var fc queue.FlushChannel
+	if qp.Wait() {
+		stats.Add(numQueuedExecutionsWait, 1)
+		fc = make(queue.FlushChannel)
+	}
+
+	seqNum, err := s.stmtQueue.Write(stmts, fc)
+	s.postExecute(r, stmts, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.SequenceNum = seqNum
+
+	if qp.Wait() {
+		// Wait for the flush channel to close, or timeout.
+		select {
+		case <-fc:
+			break
+		case <-time.NewTimer(qp.Timeout(defaultTimeout)).C:
+			http.Error(w, "timeout", http.StatusRequestTimeout)
+			return
+		}
+	}
+
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// handleQueue returns the state of the queue used for queued writes.
+func (s *Service) handleQueue(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermAll) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, oldest := s.stmtQueue.Pending()
+	var oldestAge time.Duration
+	if n > 0 {
+		oldestAge = time.Since(oldest)
+	}
+	s.writeJSON(w, qp, map[string]interface{}{
+		"pending":         n,
+		"oldest_age":      oldestAge.String(),
+		"high_water_mark": s.stmtQueue.HighWaterMark(),
+	})
+}
+
+// validateExecute handles an execute request in dry-run mode. Each statement
+// is checked, as if it were to be executed, and prepared against the local
+// database, but nothing is executed or written to the Raft log.
+func (s *Service) validateExecute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	resp := NewResponse()
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	stmts, err := parseRequestBody(r, b, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerms(r, auth.PermExecute, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := s.injectParams(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.preExecute(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats.Add(numDryRunExecutions, 1)
+	results, err := s.store.Validate(&proto.ExecuteRequest{
+		Request: &proto.Request{
+			Statements: stmts,
+		},
+	})
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Results.ExecuteResult = results
+	}
+	s.redactor(stmts).RedactResponse(resp)
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// execute handles queries that modify the database.
+func (s *Service) execute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	resp := NewResponse()
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	stmts, err := parseRequestBody(r, b, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numExecuteStmtsRx, int64(len(stmts)))
+	if err := s.checkTablePerms(r, auth.PermExecute, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := s.injectParams(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.preExecute(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := command.Rewrite(stmts, !qp.NoRewriteRandom()); err != nil {
+		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if qp.Conditional() {
+		requireRows(stmts)
+	}
+
+	er := &proto.ExecuteRequest{
+		Request: &proto.Request{
+			Transaction: qp.Tx(),
+			DbTimeout:   int64(qp.DBTimeout(0)),
+			Statements:  stmts,
+			StopOnError: qp.StopOnError(),
+			RequestId:   requestID(r),
+		},
+		Timings: qp.Timings(),
+	}
+	s.setIdempotencyKey(r, er, b)
+
+	var results []*proto.ExecuteResult
+	resultsErr := runWrite(r.Context(), func() error {
+		start := time.Now()
+		span := startSpan(r, "store.Execute", statementsAttr(er.Request))
+		res, err := s.store.ExecuteContext(r.Context(), er)
+		endSpan(span, err)
+		if err != store.ErrNotLeader {
+			observeLatency(s.executeLocalHist, start)
+		}
+		results = res
+		return err
+	})
+	if errors.Is(resultsErr, context.DeadlineExceeded) {
+		writeDeadlineExceeded(w)
+		return
+	}
+	if resultsErr != nil && resultsErr == store.ErrNotLeader {
+		if s.DoRedirect(w, r, qp) {
+			return
+		}
+
+		addr, err := s.leaderAddr(r)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeDeadlineExceeded(w)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+				http.StatusInternalServerError)
+			return
+		}
+		if addr == "" {
+			s.writeLeaderNotFound(w)
+			return
+		}
+
+		creds, err := s.forwardCredentials(r)
+		if err != nil {
+			s.writeNotForwardable(w, err)
+			return
+		}
+
+		w.Header().Add(ServedByHTTPHeader, addr)
+		start := time.Now()
+		span := startForwardSpan(r, "forward.Execute", addr, statementsAttr(er.Request))
+		results, resultsErr = s.cluster.Execute(er, addr, creds,
+			remainingTimeout(r, qp), qp.Retries(0))
+		endSpan(span, resultsErr)
+		observeLatency(s.executeForwardHist, start)
+		if resultsErr != nil {
+			stats.Add(numRemoteExecutionsFailed, 1)
+			if resultsErr.Error() == "unauthorized" {
+				s.addAuthChallenge(w, r)
+				http.Error(w, "remote Execute not authorized", http.StatusUnauthorized)
+				return
+			}
+			s.logRequestf(r, "forwarding execute to %s failed: %s", addr,
+				s.redactor(stmts).Redact(resultsErr.Error()))
+			resultsErr = fmt.Errorf("node failed to process Execute on remote node at %s: %s",
+				addr, resultsErr.Error())
+		}
+		stats.Add(numRemoteExecutions, 1)
+	}
+
+	s.postExecute(r, stmts, resultsErr)
+	if resultsErr != nil && strings.Contains(resultsErr.Error(), store.ErrIdempotencyKeyConflict.Error()) {
+		stats.Add(numIdempotencyConflicts, 1)
+		http.Error(w, store.ErrIdempotencyKeyConflict.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if resultsErr != nil && strings.Contains(resultsErr.Error(), store.ErrIdempotencyUnsupported.Error()) {
+		http.Error(w, store.ErrIdempotencyUnsupported.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if resultsErr != nil && strings.Contains(resultsErr.Error(), store.ErrIdempotencyUnknown.Error()) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, store.ErrIdempotencyUnknown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if resultsErr != nil {
+		resp.Error = resultsErr.Error()
+	} else {
+		resp.Results.ExecuteResult = results
+		if replayed(results) {
+			stats.Add(numIdempotentReplays, 1)
+			w.Header().Set(IdempotentReplayedHeader, "true")
+		}
+		if qp.Conditional() && executeConflict(results) {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}
+	s.redactor(stmts).RedactResponse(resp)
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// handleQuery handles queries that do not modify the database.
+func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if deadlineExceeded(w, r) {
+		return
+	}
+
+	// Get the query statement(s), and do tx if necessary.
+	queries, err := requestQueries(r, qp, s.MaxStatementBytes, s.MaxStatements)
+	if err != nil {
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numQueryStmtsRx, int64(len(queries)))
+	format := qp.Format()
+	if format == "" && strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		format = "ndjson"
+	}
+	rollups := qp.Rollup()
+	if rollups != nil && format != "" && format != "json" {
+		http.Error(w, "rollup requires JSON format", http.StatusBadRequest)
+		return
+	}
+	if rollups != nil && qp.Explain() {
+		http.Error(w, "rollup not supported with explain", http.StatusBadRequest)
+		return
+	}
+	pageSize := qp.PageSize()
+	if pageSize > 0 {
+		if len(queries) != 1 {
+			http.Error(w, "paging requires exactly one query", http.StatusBadRequest)
+			return
+		}
+		if (format != "" && format != "json") || rollups != nil || qp.Explain() {
+			http.Error(w, "paging requires JSON format, and is not supported with rollup or explain", http.StatusBadRequest)
+			return
+		}
+	}
+	switch format {
+	case "", "json":
+	case "ndjson":
+		if len(queries) != 1 {
+			http.Error(w, "NDJSON format requires exactly one query", http.StatusBadRequest)
+			return
+		}
+		if qp.Level() == proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+			http.Error(w, "NDJSON format does not support strong read consistency", http.StatusBadRequest)
+			return
+		}
+		if qp.ChunkRows(s.StreamChunkRows) < 1 {
+			http.Error(w, "chunk_rows must be at least 1", http.StatusBadRequest)
+			return
+		}
+	case "csv":
+		if len(queries) != 1 {
+			http.Error(w, "CSV format requires exactly one query", http.StatusBadRequest)
+			return
+		}
+	case "sql":
+		if qp.Table() == "" {
+			http.Error(w, "table is required for SQL format", http.StatusBadRequest)
+			return
+		}
+		if len(queries) != 1 {
+			http.Error(w, "SQL format requires exactly one query", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %s", qp.Format()), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerms(r, auth.PermQuery, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := s.injectParams(r, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if qp.Explain() {
+		stats.Add(numExplains, 1)
+		for i := range queries {
+			queries[i].Sql = explainQueryPlan(queries[i].Sql)
+		}
+	}
+
+	if s.strictQuery() {
+		for i := range queries {
+			if s.isWrite(queries[i].Sql) {
+				http.Error(w, fmt.Sprintf("statement may modify the database, use /db/execute: %s", queries[i].Sql),
+					http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if pageSize > 0 {
+		stats.Add(numPagedQueries, 1)
+		var after *proto.Parameter
+		if token := qp.PageToken(); token != "" {
+			after, err = DecodePageToken(token, qp.PageKey())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		queries[0] = PageStatement(queries[0], qp.PageKey(), pageSize, after)
+	}
+	if err := s.applyQueryLimit(r, queries); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// No point rewriting queries if they don't go through the Raft log, since they
+	// will never be replayed from the log anyway.
+	if qp.Level() == proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		if err := command.Rewrite(queries, qp.NoRewriteRandom()); err != nil {
+			http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = qp.Associative()
+	resp.Results.BlobsAsArrays = qp.BlobArray()
+	if qp.DebugSQL() {
+		resp.DebugSQL, err = NewDebugSQL(queries)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("debug SQL: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Rollups are computed by statements appended to the request, so they are
+	// served with the same read consistency as the queries themselves.
+	nQueries := len(queries)
+	if rollups != nil {
+		queries = append(queries, RollupStatements(queries, rollups)...)
+	}
+
+	qr := &proto.QueryRequest{
+		Request: &proto.Request{
+			Transaction: qp.Tx(),
+			DbTimeout:   int64(qp.DBTimeout(0)),
+			Statements:  queries,
+			RequestId:   requestID(r),
+		},
+		Timings:         qp.Timings(),
+		Level:           qp.Level(),
+		Freshness:       qp.Freshness().Nanoseconds(),
+		FreshnessStrict: qp.FreshnessStrict(),
+	}
+	if qp.Explain() {
+		// A query plan depends only on the schema, so any node may report
+		// it from its own database.
+		qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+		qr.Freshness = 0
+		qr.FreshnessStrict = false
+	}
+	setReadConsistencyHeader(w, qr.Level)
+	if format == "ndjson" {
+		s.queryNDJSON(w, r, qp, qr)
+		return
+	}
+
+	// Queries run against the local database are interrupted if the client
+	// disconnects, or the request's deadline passes.
+	var results []*proto.QueryRows
+	resultsErr := runWithDeadline(r.Context(), func() error {
+		start := time.Now()
+		span := startSpan(r, "store.Query", statementsAttr(qr.Request), levelAttr(qr.Level))
+		res, err := s.store.QueryContext(r.Context(), qr)
+		endSpan(span, err)
+		if err != store.ErrNotLeader && err != store.ErrStaleRead {
+			observeLatency(s.queryLocalHist, start)
+		}
+		results = res
+		return err
+	})
+	if errors.Is(resultsErr, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeDeadlineExceeded(w)
+		return
+	}
+	if r.Context().Err() != nil {
+		stats.Add(numQueriesInterrupted, 1)
+		return
+	}
+	if resultsErr == store.ErrStaleRead && s.staleRead(w, qp) {
+		return
+	}
+	if resultsErr == store.ErrNotLeader || resultsErr == store.ErrStaleRead {
+		if s.DoRedirect(w, r, qp) {
+			return
+		}
+
+		addr, err := s.leaderAddr(r)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeDeadlineExceeded(w)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if addr == "" {
+			s.writeLeaderNotFound(w)
+			return
+		}
+		creds, err := s.forwardCredentials(r)
+		if err != nil {
+			s.writeNotForwardable(w, err)
+			return
+		}
+
+		w.Header().Add(ServedByHTTPHeader, addr)
+		start := time.Now()
+		span := startForwardSpan(r, "forward.Query", addr, statementsAttr(qr.Request), levelAttr(qr.Level))
+		results, resultsErr = s.cluster.Query(qr, addr, creds, remainingTimeout(r, qp))
+		endSpan(span, resultsErr)
+		observeLatency(s.queryForwardHist, start)
+		if resultsErr != nil {
+			stats.Add(numRemoteQueriesFailed, 1)
+			if resultsErr.Error() == "unauthorized" {
+				s.addAuthChallenge(w, r)
+				http.Error(w, "remote query not authorized", http.StatusUnauthorized)
+				return
+			}
+			s.logRequestf(r, "forwarding query to %s failed: %s", addr,
+				s.redactor(queries).Redact(resultsErr.Error()))
+			resultsErr = fmt.Errorf("node failed to process Query on remote node at %s: %s",
+				addr, resultsErr.Error())
+		}
+		stats.Add(numRemoteQueries, 1)
+	}
+
+	if resultsErr == nil && rollups != nil && len(results) > nQueries {
+		resp.Rollup, resultsErr = RollupResults(results[nQueries:], rollups)
+		results = results[:nQueries]
+	}
+	if resultsErr != nil {
+		resp.Error = resultsErr.Error()
+		s.redactor(queries).RedactResponse(resp)
+	} else {
+		truncateQueryRows(results, s.maxRows(r))
+		s.observeQueryRows(results)
+		resp.Results.QueryRows = results
+		if pageSize > 0 && len(results) == 1 {
+			resp.NextPageToken, err = NextPageToken(results[0], qp.PageKey(), pageSize)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		s.redactor(queries).RedactResponse(resp)
+		switch qp.Format() {
+		case "sql":
+			s.writeSQLInserts(w, qp.Table(), results)
+			return
+		case "csv":
+			s.writeCSV(w, results)
+			return
+		}
+	}
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// queryNDJSON writes the result of the query as newline-delimited JSON, one
+// object per row, as the rows are read from the database. The response is
+// flushed every chunk of rows. So that a slow client cannot hold the read
+// open, blocking loads, restores and WAL checkpoints, each row must be
+// written within ndjsonWriteTimeout, or the read is ended. Reading also
+// stops if the client disconnects. If the read must be served by the Leader
+// the query is forwarded, and the Leader's result, which is necessarily held
+// in memory, is written in the same format. If the rows are cut short by the
+// row cap, a final object with a single "truncated" key is written. An
+// error, including one which occurs part way through the rows, is written as
+// a final object with a single "error" key.
+func (s *Service) queryNDJSON(w http.ResponseWriter, r *http.Request, qp QueryParams, qr *proto.QueryRequest) {
+	stats.Add(numQueryNDJSON, 1)
+	w.Header().Set("Content-Type", ndjsonContentType)
+	ctx := r.Context()
+	maxRows := s.maxRows(r)
+	chunkRows := qp.ChunkRows(s.StreamChunkRows)
+
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{})
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	var nRows int64
+	truncated := false
+	writeRow := func(columns, types []string, values *proto.Values) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if maxRows > 0 && nRows >= maxRows {
+			truncated = true
+			return errRowLimit
+		}
+		row := ndjsonRow{columns: columns, values: make([][]interface{}, 1)}
+		if err := encoding.NewValuesFromQueryValues(row.values, []*proto.Values{values}, qp.BlobArray()); err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(ndjsonWriteTimeout))
+		if err := enc.Encode(row); err != nil {
+			return errClientWrite
+		}
+		nRows++
+		if nRows%int64(chunkRows) == 0 {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return errClientWrite
+			}
+		}
+		return nil
+	}
+
+	err := s.store.QueryStream(qr, writeRow)
+	if err == store.ErrStaleRead && s.staleRead(w, qp) {
+		return
+	}
+	if err == store.ErrNotLeader || err == store.ErrStaleRead {
+		if s.DoRedirect(w, r, qp) {
+			return
+		}
+		results, ferr := s.forwardQuery(r, qp, qr)
+		if ferr != nil {
+			s.writeForwardQueryError(w, r, ferr)
+			return
+		}
+		err = nil
+		if len(results) == 1 {
+			for _, v := range results[0].Values {
+				if err = writeRow(results[0].Columns, results[0].Types, v); err != nil {
+					break
+				}
+			}
+			if err == nil && results[0].Error != "" {
+				err = errors.New(results[0].Error)
+			}
+		}
+	}
+	if ctx.Err() != nil || err == errClientWrite {
 		return
 	}
+	if err == errRowLimit {
+		err = nil
+	}
+	rc.SetWriteDeadline(time.Now().Add(ndjsonWriteTimeout))
+	if truncated {
+		stats.Add(numQueryRowsTruncated, 1)
+		enc.Encode(map[string]bool{"truncated": true})
+	}
+	if err != nil {
+		enc.Encode(map[string]string{"error": s.redactor(qr.Request.GetStatements()).Redact(err.Error())})
+	}
+}
+
+// ndjsonWriteTimeout is the time allowed to write a row of a streamed query
+// result, before the query is ended.
+const ndjsonWriteTimeout = 10 * time.Second
+
+// errClientWrite stops reading the rows of a query once a row cannot be
+// written to the client.
+var errClientWrite = errors.New("failed to write to client")
+
+// errRowLimit stops reading the rows of a query once the row cap is reached.
+var errRowLimit = errors.New("row limit reached")
+
+// ndjsonRow is a single row of a query result, encoded as a JSON object with
+// its keys in column order.
+type ndjsonRow struct {
+	columns []string
+	values  [][]interface{}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r ndjsonRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, c := range r.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		var v interface{}
+		if len(r.values[0]) > i {
+			v = r.values[0][i]
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-	if !s.store.Ready() {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("[+]node ok\n[+]leader ok\n[+]store not ready"))
+// writeSQLInserts writes the given query results as SQL statements which
+// insert the rows into the named table.
+func (s *Service) writeSQLInserts(w http.ResponseWriter, table string, results []*proto.QueryRows) {
+	if len(results) != 1 {
+		http.Error(w, "unexpected number of results", http.StatusInternalServerError)
 		return
 	}
+	if results[0].Error != "" {
+		http.Error(w, results[0].Error, http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := encoding.WriteSQLInserts(&buf, table, results[0]); err != nil {
+		http.Error(w, fmt.Sprintf("SQL encode: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/sql")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		s.logger.Println("writing response failed:", err.Error())
+	}
+}
 
-	okMsg := "[+]node ok\n[+]leader ok\n[+]store ok"
-	if qp.Sync() {
-		if _, err := s.store.Committed(qp.Timeout(defaultTimeout)); err != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(fmt.Sprintf("[+]node ok\n[+]leader ok\n[+]store ok\n[+]sync %s", err.Error())))
-			return
-		}
-		okMsg += "\n[+]sync ok"
+// writeCSV writes the given query results as CSV, with a header row of
+// column names.
+func (s *Service) writeCSV(w http.ResponseWriter, results []*proto.QueryRows) {
+	if len(results) != 1 {
+		http.Error(w, "unexpected number of results", http.StatusInternalServerError)
+		return
+	}
+	if results[0].Error != "" {
+		http.Error(w, results[0].Error, http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := encoding.WriteCSV(&buf, results[0]); err != nil {
+		http.Error(w, fmt.Sprintf("CSV encode: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8; header=present")
+	w.Header().Set("Content-Disposition", `attachment; filename="query.csv"`)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		s.logger.Println("writing response failed:", err.Error())
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(okMsg))
 }
 
-func (s *Service) handleExecute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+func (s *Service) handleRequest(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	if !s.CheckRequestPerm(r, auth.PermExecute) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !s.CheckRequestPermAll(r, auth.PermQuery, auth.PermExecute) {
+		s.writeUnauthorized(w, r)
 		return
 	}
 
@@ -1035,342 +4052,721 @@ func (s *Service) handleExecute(w http.ResponseWriter, r *http.Request, qp Query
 		return
 	}
 
-	if qp.Queue() {
-		stats.Add(numQueuedExecutions, 1)
-		s.queuedExecute(w, r, qp)
-	} else {
-		s.execute(w, r, qp)
-	}
-}
-
-// queuedExecute handles queued queries that modify the database.
-func (s *Service) queuedExecute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	resp := NewResponse()
-
-	// Perform a leader check, unless disabled. This prevents generating queued writes on
-	// a node that does not appear to be connected to a cluster (even a single-node cluster).
-	if !qp.NoLeader() {
-		addr, err := s.store.LeaderAddr()
-		if err != nil || addr == "" {
-			stats.Add(numLeaderNotFound, 1)
-			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
-			return
-		}
+	if deadlineExceeded(w, r) {
+		return
 	}
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBodyError(w, err, err.Error(), http.StatusBadRequest)
 		return
 	}
 	r.Body.Close()
 
-	stmts, err := ParseRequest(b)
+	stmts, err := parseRequestBody(r, b, s.MaxStatementBytes, s.MaxStatements)
 	if err != nil {
-		if errors.Is(err, ErrNoStatements) && !qp.Wait() {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-	}
-	if err := command.Rewrite(stmts, !qp.NoRewriteRandom()); err != nil {
-		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	var fc queue.FlushChannel
-	if qp.Wait() {
-		stats.Add(numQueuedExecutionsWait, 1)
-		fc = make(queue.FlushChannel)
-	}
-
-	seqNum, err := s.stmtQueue.Write(stmts, fc)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	stats.Add(numRequestStmtsRx, int64(len(stmts)))
+	if err := s.checkRequestTablePerms(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	resp.SequenceNum = seqNum
-
-	if qp.Wait() {
-		// Wait for the flush channel to close, or timeout.
-		select {
-		case <-fc:
-			break
-		case <-time.NewTimer(qp.Timeout(defaultTimeout)).C:
-			http.Error(w, "timeout", http.StatusRequestTimeout)
-			return
+	if s.ReadOnly {
+		for i := range stmts {
+			if s.isWrite(stmts[i].Sql) && s.rejectReadOnly(w, r, qp) {
+				return
+			}
 		}
 	}
-
-	resp.end = time.Now()
-	s.writeResponse(w, r, qp, resp)
-}
-
-// execute handles queries that modify the database.
-func (s *Service) execute(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	resp := NewResponse()
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := s.injectParams(r, stmts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	r.Body.Close()
-
-	stmts, err := ParseRequest(b)
-	if err != nil {
+	if err := s.preExecute(r, stmts); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	stats.Add(numExecuteStmtsRx, int64(len(stmts)))
-	if err := command.Rewrite(stmts, !qp.NoRewriteRandom()); err != nil {
+
+	if err := command.Rewrite(stmts, qp.NoRewriteRandom()); err != nil {
 		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
+	if qp.Conditional() {
+		requireRows(stmts)
+	}
 
-	er := &proto.ExecuteRequest{
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = qp.Associative()
+	resp.Results.BlobsAsArrays = qp.BlobArray()
+
+	eqr := &proto.ExecuteQueryRequest{
 		Request: &proto.Request{
 			Transaction: qp.Tx(),
-			DbTimeout:   int64(qp.DBTimeout(0)),
 			Statements:  stmts,
+			DbTimeout:   int64(qp.DBTimeout(0)),
+			StopOnError: qp.StopOnError(),
+			RequestId:   requestID(r),
 		},
-		Timings: qp.Timings(),
+		Timings:         qp.Timings(),
+		Level:           qp.Level(),
+		Freshness:       qp.Freshness().Nanoseconds(),
+		FreshnessStrict: qp.FreshnessStrict(),
 	}
+	setReadConsistencyHeader(w, eqr.Level)
 
-	results, resultsErr := s.store.Execute(er)
-	if resultsErr != nil && resultsErr == store.ErrNotLeader {
+	// A request which may write runs to completion, so its outcome is known.
+	run := runWithDeadline
+	for i := range stmts {
+		if s.isWrite(stmts[i].Sql) {
+			run = runWrite
+			break
+		}
+	}
+	var results []*proto.ExecuteQueryResponse
+	resultsErr := run(r.Context(), func() error {
+		span := startSpan(r, "store.Request", statementsAttr(eqr.Request), levelAttr(eqr.Level))
+		res, err := s.store.Request(eqr)
+		endSpan(span, err)
+		results = res
+		return err
+	})
+	if errors.Is(resultsErr, context.DeadlineExceeded) {
+		writeDeadlineExceeded(w)
+		return
+	}
+	if resultsErr == store.ErrStaleRead && s.staleRead(w, qp) {
+		return
+	}
+	if resultsErr == store.ErrNotLeader || resultsErr == store.ErrStaleRead {
 		if s.DoRedirect(w, r, qp) {
 			return
 		}
 
-		addr, err := s.store.LeaderAddr()
+		addr, err := s.leaderAddr(r)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeDeadlineExceeded(w)
+			return
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
-				http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		if addr == "" {
-			stats.Add(numLeaderNotFound, 1)
-			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+			s.writeLeaderNotFound(w)
 			return
 		}
-
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			username = ""
+		creds, err := s.forwardCredentials(r)
+		if err != nil {
+			s.writeNotForwardable(w, err)
+			return
 		}
 
 		w.Header().Add(ServedByHTTPHeader, addr)
-		results, resultsErr = s.cluster.Execute(er, addr, makeCredentials(username, password),
-			qp.Timeout(defaultTimeout), qp.Retries(0))
+		span := startForwardSpan(r, "forward.Request", addr, statementsAttr(eqr.Request), levelAttr(eqr.Level))
+		results, resultsErr = s.cluster.Request(eqr, addr, creds,
+			remainingTimeout(r, qp), qp.Retries(0))
+		endSpan(span, resultsErr)
 		if resultsErr != nil {
-			stats.Add(numRemoteExecutionsFailed, 1)
+			stats.Add(numRemoteRequestsFailed, 1)
 			if resultsErr.Error() == "unauthorized" {
-				http.Error(w, "remote Execute not authorized", http.StatusUnauthorized)
+				s.addAuthChallenge(w, r)
+				http.Error(w, "remote Request not authorized", http.StatusUnauthorized)
 				return
 			}
-			resultsErr = fmt.Errorf("node failed to process Execute on remote node at %s: %s",
+			s.logRequestf(r, "forwarding request to %s failed: %s", addr,
+				s.redactor(stmts).Redact(resultsErr.Error()))
+			resultsErr = fmt.Errorf("node failed to process Request on remote node at %s: %s",
 				addr, resultsErr.Error())
 		}
-		stats.Add(numRemoteExecutions, 1)
+		stats.Add(numRemoteRequests, 1)
 	}
 
+	s.postExecute(r, stmts, resultsErr)
 	if resultsErr != nil {
 		resp.Error = resultsErr.Error()
 	} else {
-		resp.Results.ExecuteResult = results
+		maxRows := s.maxRows(r)
+		for i := range results {
+			if q := results[i].GetQ(); q != nil {
+				truncateQueryRows([]*proto.QueryRows{q}, maxRows)
+				s.observeQueryRows([]*proto.QueryRows{q})
+			}
+		}
+		resp.Results.ExecuteQueryResponse = results
+		if qp.Conditional() && requestConflict(results) {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}
+	s.redactor(stmts).RedactResponse(resp)
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// handleTableRows returns a page of rows from the table given in the path,
+// without the client writing SQL. The table, and any column by which rows
+// are ordered, must exist. Without an order_by column rows are ordered by
+// the table's primary key, if it has one.
+func (s *Service) handleTableRows(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	table := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/db/tables/"), "/rows")
+	if table == "" || strings.Contains(table, "/") {
+		http.Error(w, "invalid table name", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerm(r, auth.PermQuery, table); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	limit, offset := qp.Limit(defaultTableRowsLimit), qp.Offset()
+	if limit < 1 || limit > maxTableRowsLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTableRowsLimit), http.StatusBadRequest)
+		return
+	}
+	if offset < 0 {
+		http.Error(w, "offset must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	// Look up the table's columns, so that the names given by the client are
+	// only ever used once confirmed to exist.
+	cols, pks, ok := s.tableInfo(w, r, qp, table, qp.Level())
+	if !ok {
+		return
+	}
+
+	var orderBy []string
+	for _, col := range cols {
+		if col == qp.OrderBy() {
+			orderBy = []string{encoding.QuoteIdentifier(col)}
+		}
+	}
+	if qp.OrderBy() != "" && orderBy == nil {
+		http.Error(w, fmt.Sprintf("no such column: %s", qp.OrderBy()), http.StatusBadRequest)
+		return
+	}
+	if orderBy == nil {
+		for _, pk := range pks {
+			orderBy = append(orderBy, encoding.QuoteIdentifier(pk))
+		}
+	}
+
+	sql := fmt.Sprintf("SELECT * FROM %s", encoding.QuoteIdentifier(table))
+	if len(orderBy) > 0 {
+		sql += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	sql += " LIMIT ? OFFSET ?"
+
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = qp.Associative()
+	resp.Results.BlobsAsArrays = qp.BlobArray()
+	results, err := s.forwardQuery(r, qp, &proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: sql,
+					Parameters: []*proto.Parameter{
+						{Value: &proto.Parameter_I{I: int64(limit)}},
+						{Value: &proto.Parameter_I{I: int64(offset)}},
+					},
+				},
+			},
+		},
+		Timings: qp.Timings(),
+		Level:   qp.Level(),
+	})
+	if err != nil {
+		s.writeForwardQueryError(w, r, err)
+		return
+	}
+	truncateQueryRows(results, s.maxRows(r))
+	s.observeQueryRows(results)
+	resp.Results.QueryRows = results
+	resp.end = time.Now()
+	s.writeResponse(w, r, qp, resp)
+}
+
+// handleTableChecksum returns a SHA-256 checksum of the contents of a table,
+// read in primary key order. The checksum is always computed from this node's
+// copy of the database, so that the checksums returned by different nodes can
+// be compared to confirm the table is identical on each.
+func (s *Service) handleTableChecksum(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	table := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/db/tables/"), "/checksum")
+	if table == "" || strings.Contains(table, "/") {
+		http.Error(w, "invalid table name", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkTablePerm(r, auth.PermQuery, table); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	level := proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	cols, pks, ok := s.tableInfo(w, r, qp, table, level)
+	if !ok {
+		return
+	}
+	orderBy := []string{"rowid"}
+	if len(pks) > 0 {
+		orderBy = make([]string, len(pks))
+		for i, pk := range pks {
+			orderBy[i] = encoding.QuoteIdentifier(pk)
+		}
+	}
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = encoding.QuoteIdentifier(col)
+	}
+
+	// Column names are part of the checksum, so that tables holding the same
+	// values under a different schema are not reported as identical.
+	h := sha256.New()
+	for _, col := range cols {
+		writeChecksumBytes(h, []byte(col))
+	}
+	var nRows int64
+	err := s.store.QueryStream(&proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(quoted, ", "),
+						encoding.QuoteIdentifier(table), strings.Join(orderBy, ", ")),
+				},
+			},
+		},
+		Level:           level,
+		Freshness:       qp.Freshness().Nanoseconds(),
+		FreshnessStrict: qp.FreshnessStrict(),
+	}, func(columns, types []string, values *proto.Values) error {
+		nRows++
+		writeChecksumRow(h, values)
+		return nil
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == store.ErrStaleRead {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.writeJSON(w, qp, map[string]interface{}{
+		"table":    table,
+		"checksum": hex.EncodeToString(h.Sum(nil)),
+		"rows":     nRows,
+	})
+}
+
+// writeChecksumRow writes an unambiguous encoding of a row to h. Each value
+// is written as a type tag followed by the value itself, so that, for
+// example, the integer 1 and the string "1" contribute different bytes.
+func writeChecksumRow(h hash.Hash, values *proto.Values) {
+	var b [8]byte
+	h.Write([]byte{'r'})
+	for _, p := range values.Parameters {
+		switch v := p.GetValue().(type) {
+		case *proto.Parameter_I:
+			h.Write([]byte{'i'})
+			binary.BigEndian.PutUint64(b[:], uint64(v.I))
+			h.Write(b[:])
+		case *proto.Parameter_D:
+			h.Write([]byte{'d'})
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(v.D))
+			h.Write(b[:])
+		case *proto.Parameter_B:
+			h.Write([]byte{'b'})
+			if v.B {
+				h.Write([]byte{1})
+			} else {
+				h.Write([]byte{0})
+			}
+		case *proto.Parameter_S:
+			h.Write([]byte{'s'})
+			writeChecksumBytes(h, []byte(v.S))
+		case *proto.Parameter_Y:
+			h.Write([]byte{'y'})
+			writeChecksumBytes(h, v.Y)
+		default:
+			h.Write([]byte{'n'})
+		}
 	}
-	resp.end = time.Now()
-	s.writeResponse(w, r, qp, resp)
 }
 
-// handleQuery handles queries that do not modify the database.
-func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+// writeChecksumBytes writes b to h, prefixed by its length.
+func writeChecksumBytes(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// Index describes an index, as returned by the indexes endpoint.
+type Index struct {
+	Name    string    `json:"name"`
+	Table   string    `json:"table"`
+	Columns []*string `json:"columns"` // nil for an expression
+	Unique  bool      `json:"unique"`
+	Origin  string    `json:"origin"` // "c" for CREATE INDEX, "u" for UNIQUE, "pk" for PRIMARY KEY
+	Partial bool      `json:"partial"`
+
+	// RowsEstimate is the approximate number of rows in the index, and
+	// Stat the full sqlite_stat1 statistics. They are only present once
+	// ANALYZE has been run. SQLite does not record how often an index is
+	// used by queries.
+	RowsEstimate *int64 `json:"rows_estimate,omitempty"`
+	Stat         string `json:"stat,omitempty"`
+}
+
+// handleIndexes returns every index in the database, along with its table,
+// columns, and any statistics gathered by ANALYZE.
+func (s *Service) handleIndexes(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
 	if !s.CheckRequestPerm(r, auth.PermQuery) {
-		w.WriteHeader(http.StatusUnauthorized)
+		s.writeUnauthorized(w, r)
 		return
 	}
 
-	if r.Method != "GET" && r.Method != "POST" {
+	if r.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get the query statement(s), and do tx if necessary.
-	queries, err := requestQueries(r, qp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := s.checkTablePerm(r, auth.PermQuery, auth.AllResources); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	stats.Add(numQueryStmtsRx, int64(len(queries)))
-
-	// No point rewriting queries if they don't go through the Raft log, since they
-	// will never be replayed from the log anyway.
-	if qp.Level() == proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
-		if err := command.Rewrite(queries, qp.NoRewriteRandom()); err != nil {
-			http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	resp := NewResponse()
-	resp.Results.AssociativeJSON = qp.Associative()
-	resp.Results.BlobsAsArrays = qp.BlobArray()
 
-	qr := &proto.QueryRequest{
+	results, err := s.forwardQuery(r, qp, &proto.QueryRequest{
 		Request: &proto.Request{
-			Transaction: qp.Tx(),
-			DbTimeout:   int64(qp.DBTimeout(0)),
-			Statements:  queries,
+			Statements: []*proto.Statement{
+				{
+					Sql: `SELECT il.name, m.name, il."unique", il.origin, il.partial
+FROM sqlite_master AS m JOIN pragma_index_list(m.name) AS il
+WHERE m.type = 'table' ORDER BY m.name, il.name`,
+				},
+				{
+					Sql: `SELECT il.name, ii.name
+FROM sqlite_master AS m JOIN pragma_index_list(m.name) AS il JOIN pragma_index_info(il.name) AS ii
+WHERE m.type = 'table' ORDER BY il.name, ii.seqno`,
+				},
+				{
+					Sql: "SELECT idx, stat FROM sqlite_stat1 WHERE idx IS NOT NULL",
+				},
+			},
 		},
-		Timings:         qp.Timings(),
 		Level:           qp.Level(),
 		Freshness:       qp.Freshness().Nanoseconds(),
 		FreshnessStrict: qp.FreshnessStrict(),
+	})
+	if err != nil {
+		s.writeForwardQueryError(w, r, err)
+		return
+	}
+	if len(results) != 3 || results[0].Error != "" || results[1].Error != "" {
+		http.Error(w, "failed to retrieve indexes", http.StatusInternalServerError)
+		return
 	}
 
-	results, resultsErr := s.store.Query(qr)
-	if resultsErr != nil && resultsErr == store.ErrNotLeader {
-		if s.DoRedirect(w, r, qp) {
-			return
-		}
-
-		addr, err := s.store.LeaderAddr()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if addr == "" {
-			stats.Add(numLeaderNotFound, 1)
-			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
-			return
+	indexes := make([]*Index, 0, len(results[0].Values))
+	byName := make(map[string]*Index)
+	for _, v := range results[0].Values {
+		p := v.Parameters
+		idx := &Index{
+			Name:    p[0].GetS(),
+			Table:   p[1].GetS(),
+			Unique:  p[2].GetI() != 0,
+			Origin:  p[3].GetS(),
+			Partial: p[4].GetI() != 0,
+			Columns: []*string{},
 		}
-		username, password, ok := r.BasicAuth()
+		indexes = append(indexes, idx)
+		byName[idx.Name] = idx
+	}
+	for _, v := range results[1].Values {
+		idx, ok := byName[v.Parameters[0].GetS()]
 		if !ok {
-			username = ""
+			continue
 		}
+		var col *string
+		if _, ok := v.Parameters[1].GetValue().(*proto.Parameter_S); ok {
+			c := v.Parameters[1].GetS()
+			col = &c
+		}
+		idx.Columns = append(idx.Columns, col)
+	}
 
-		w.Header().Add(ServedByHTTPHeader, addr)
-		results, resultsErr = s.cluster.Query(qr, addr, makeCredentials(username, password), qp.Timeout(defaultTimeout))
-		if resultsErr != nil {
-			stats.Add(numRemoteQueriesFailed, 1)
-			if resultsErr.Error() == "unauthorized" {
-				http.Error(w, "remote query not authorized", http.StatusUnauthorized)
-				return
+	// sqlite_stat1 only exists once ANALYZE has been run, so an error
+	// reading it means there are no statistics.
+	if results[2].Error == "" {
+		for _, v := range results[2].Values {
+			idx, ok := byName[v.Parameters[0].GetS()]
+			if !ok {
+				continue
+			}
+			idx.Stat = v.Parameters[1].GetS()
+			f := strings.Fields(idx.Stat)
+			if len(f) > 0 {
+				if n, err := strconv.ParseInt(f[0], 10, 64); err == nil {
+					idx.RowsEstimate = &n
+				}
 			}
-			resultsErr = fmt.Errorf("node failed to process Query on remote node at %s: %s",
-				addr, resultsErr.Error())
 		}
-		stats.Add(numRemoteQueries, 1)
 	}
 
-	if resultsErr != nil {
-		resp.Error = resultsErr.Error()
-	} else {
-		resp.Results.QueryRows = results
+	s.writeJSON(w, qp, map[string]interface{}{
+		"indexes": indexes,
+	})
+}
+
+// setReadConsistencyHeader marks the response as possibly stale, if reads
+// are served at the given level without involving the Leader.
+func setReadConsistencyHeader(w http.ResponseWriter, level proto.QueryRequest_Level) {
+	if level == proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+		w.Header().Set(ReadConsistencyHTTPHeader, "none")
 	}
-	resp.end = time.Now()
-	s.writeResponse(w, r, qp, resp)
 }
 
-func (s *Service) handleRequest(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// tableInfo returns the columns of the named table, and the columns which
+// form its primary key, in key order. If the table cannot be looked up, or
+// does not exist, an error response is written and ok is false.
+func (s *Service) tableInfo(w http.ResponseWriter, r *http.Request, qp QueryParams, table string,
+	level proto.QueryRequest_Level) (cols, pks []string, ok bool) {
+	info, err := s.forwardQuery(r, qp, &proto.QueryRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{
+				{
+					Sql: "SELECT name, pk FROM pragma_table_info(?)",
+					Parameters: []*proto.Parameter{
+						{Value: &proto.Parameter_S{S: table}},
+					},
+				},
+			},
+		},
+		Level: level,
+	})
+	if err != nil {
+		s.writeForwardQueryError(w, r, err)
+		return nil, nil, false
+	}
+	if len(info) != 1 || info[0].Error != "" {
+		http.Error(w, "failed to retrieve table columns", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if len(info[0].Values) == 0 {
+		http.Error(w, fmt.Sprintf("no such table: %s", table), http.StatusNotFound)
+		return nil, nil, false
+	}
 
-	if !s.CheckRequestPermAll(r, auth.PermQuery, auth.PermExecute) {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	pkCols := make(map[int64]string)
+	for _, v := range info[0].Values {
+		col, pk := v.Parameters[0].GetS(), v.Parameters[1].GetI()
+		cols = append(cols, col)
+		if pk > 0 {
+			pkCols[pk] = col
+		}
+	}
+	for i := int64(1); i <= int64(len(pkCols)); i++ {
+		pks = append(pks, pkCols[i])
 	}
+	return cols, pks, true
+}
 
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+// forwardQuery runs the query on this node, or on the Leader if the read
+// consistency level requires it and this node is not the Leader.
+func (s *Service) forwardQuery(r *http.Request, qp QueryParams, qr *proto.QueryRequest) ([]*proto.QueryRows, error) {
+	setRequestID(qr.Request, r)
+	span := startSpan(r, "store.Query", statementsAttr(qr.Request), levelAttr(qr.Level))
+	results, err := s.store.Query(qr)
+	endSpan(span, err)
+	if err != store.ErrNotLeader && err != store.ErrStaleRead {
+		return results, err
 	}
 
-	b, err := io.ReadAll(r.Body)
+	addr, err := s.store.LeaderAddr()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, err
 	}
-	r.Body.Close()
+	if addr == "" {
+		stats.Add(numLeaderNotFound, 1)
+		return nil, ErrLeaderNotFound
+	}
+	creds, err := s.forwardCredentials(r)
+	if err != nil {
+		return nil, err
+	}
+	stats.Add(numRemoteQueries, 1)
+	span = startForwardSpan(r, "forward.Query", addr, statementsAttr(qr.Request), levelAttr(qr.Level))
+	results, err = s.cluster.Query(qr, addr, creds, qp.Timeout(defaultTimeout))
+	endSpan(span, err)
+	if err != nil {
+		stats.Add(numRemoteQueriesFailed, 1)
+		if err.Error() == "unauthorized" {
+			return nil, err
+		}
+		s.logRequestf(r, "forwarding query to %s failed: %s", addr,
+			s.redactor(qr.Request.GetStatements()).Redact(err.Error()))
+		return nil, fmt.Errorf("node failed to process Query on remote node at %s: %s",
+			addr, err.Error())
+	}
+	return results, nil
+}
 
-	stmts, err := ParseRequest(b)
+// forwardExecute runs the execute request on this node, or on the Leader if
+// this node is not the Leader.
+func (s *Service) forwardExecute(r *http.Request, qp QueryParams, er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+	setRequestID(er.Request, r)
+	span := startSpan(r, "store.Execute", statementsAttr(er.Request))
+	results, err := s.store.Execute(er)
+	endSpan(span, err)
+	if err != store.ErrNotLeader {
+		return results, err
+	}
+
+	addr, err := s.store.LeaderAddr()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, err
 	}
-	stats.Add(numRequestStmtsRx, int64(len(stmts)))
+	if addr == "" {
+		stats.Add(numLeaderNotFound, 1)
+		return nil, ErrLeaderNotFound
+	}
+	creds, err := s.forwardCredentials(r)
+	if err != nil {
+		return nil, err
+	}
+	stats.Add(numRemoteExecutions, 1)
+	span = startForwardSpan(r, "forward.Execute", addr, statementsAttr(er.Request))
+	results, err = s.cluster.Execute(er, addr, creds, qp.Timeout(defaultTimeout), qp.Retries(0))
+	endSpan(span, err)
+	if err != nil {
+		stats.Add(numRemoteExecutionsFailed, 1)
+		if err.Error() == "unauthorized" {
+			return nil, err
+		}
+		s.logRequestf(r, "forwarding execute to %s failed: %s", addr,
+			s.redactor(er.Request.GetStatements()).Redact(err.Error()))
+		return nil, fmt.Errorf("node failed to process Execute on remote node at %s: %s",
+			addr, err.Error())
+	}
+	return results, nil
+}
 
-	if err := command.Rewrite(stmts, qp.NoRewriteRandom()); err != nil {
-		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
-		return
+// staleRead handles a read which this node cannot serve within the requested
+// freshness. If forwarding is disabled it writes a response giving the node's
+// actual staleness, and returns true. Otherwise it returns false, and the
+// read should be forwarded to the Leader.
+func (s *Service) staleRead(w http.ResponseWriter, qp QueryParams) bool {
+	if !qp.NoForward() {
+		stats.Add(numStaleReadsForwarded, 1)
+		w.Header().Del(ReadConsistencyHTTPHeader)
+		return false
 	}
+	stats.Add(numStaleReadsRejected, 1)
+	http.Error(w, fmt.Sprintf("stale read: node is %s stale, exceeding freshness of %s",
+		s.store.Staleness(qp.FreshnessStrict()).Round(time.Millisecond), qp.Freshness()),
+		http.StatusServiceUnavailable)
+	return true
+}
 
-	resp := NewResponse()
-	resp.Results.AssociativeJSON = qp.Associative()
-	resp.Results.BlobsAsArrays = qp.BlobArray()
+// writeForwardQueryError writes the HTTP response for an error returned by
+// forwardQuery or forwardExecute.
+func (s *Service) writeForwardQueryError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == ErrLeaderNotFound:
+		s.writeLeaderNotFound(w)
+	case err == ErrCertNotForwardable || err == ErrTokenNotForwardable:
+		s.writeNotForwardable(w, err)
+	case err.Error() == "unauthorized":
+		s.addAuthChallenge(w, r)
+		http.Error(w, "remote query not authorized", http.StatusUnauthorized)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	eqr := &proto.ExecuteQueryRequest{
-		Request: &proto.Request{
-			Transaction: qp.Tx(),
-			Statements:  stmts,
-			DbTimeout:   int64(qp.DBTimeout(0)),
-		},
-		Timings:         qp.Timings(),
-		Level:           qp.Level(),
-		Freshness:       qp.Freshness().Nanoseconds(),
-		FreshnessStrict: qp.FreshnessStrict(),
+// handleWarmup runs the configured warmup queries against the local database,
+// populating the SQLite page cache. It can be run on any node.
+func (s *Service) handleWarmup(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
 	}
 
-	results, resultsErr := s.store.Request(eqr)
-	if resultsErr != nil && resultsErr == store.ErrNotLeader {
-		if s.DoRedirect(w, r, qp) {
-			return
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	stmts := make([]*proto.Statement, len(s.WarmupQueries))
+	for i := range s.WarmupQueries {
+		stmts[i] = &proto.Statement{
+			Sql: s.WarmupQueries[i],
 		}
+	}
 
-		addr, err := s.store.LeaderAddr()
+	errs := make([]string, 0)
+	start := time.Now()
+	if len(stmts) > 0 {
+		qr := &proto.QueryRequest{
+			Request: &proto.Request{
+				Statements: stmts,
+				DbTimeout:  int64(qp.DBTimeout(0)),
+			},
+			Level: proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+		}
+		results, err := s.store.Query(qr)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if addr == "" {
-			stats.Add(numLeaderNotFound, 1)
-			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
-			return
-		}
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			username = ""
-		}
-
-		w.Header().Add(ServedByHTTPHeader, addr)
-		results, resultsErr = s.cluster.Request(eqr, addr, makeCredentials(username, password),
-			qp.Timeout(defaultTimeout), qp.Retries(0))
-		if resultsErr != nil {
-			stats.Add(numRemoteRequestsFailed, 1)
-			if resultsErr.Error() == "unauthorized" {
-				http.Error(w, "remote Request not authorized", http.StatusUnauthorized)
-				return
+		for i := range results {
+			if results[i].Error != "" {
+				errs = append(errs, fmt.Sprintf("%s: %s", s.WarmupQueries[i], results[i].Error))
 			}
-			resultsErr = fmt.Errorf("node failed to process Request on remote node at %s: %s",
-				addr, resultsErr.Error())
 		}
-		stats.Add(numRemoteRequests, 1)
 	}
 
-	if resultsErr != nil {
-		resp.Error = resultsErr.Error()
-	} else {
-		resp.Results.ExecuteQueryResponse = results
-	}
-	resp.end = time.Now()
-	s.writeResponse(w, r, qp, resp)
+	s.writeJSON(w, qp, map[string]interface{}{
+		"completed": true,
+		"queries":   len(stmts),
+		"errors":    errs,
+		"time":      time.Since(start).Seconds(),
+	})
 }
 
 // handleExpvar serves registered expvar information over HTTP.
 func (s *Service) handleExpvar(w http.ResponseWriter, r *http.Request, qp QueryParams) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
+		s.writeUnauthorized(w, r)
 		return
 	}
 
@@ -1389,44 +4785,267 @@ func (s *Service) handleExpvar(w http.ResponseWriter, r *http.Request, qp QueryP
 	fmt.Fprintf(w, "\n}\n")
 }
 
-// handlePprof serves pprof information over HTTP.
-func (s *Service) handlePprof(w http.ResponseWriter, r *http.Request, qp QueryParams) {
-	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+// handlePprof serves pprof information over HTTP.
+func (s *Service) handlePprof(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/debug/pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case "/debug/pprof/profile":
+		pprof.Profile(w, r)
+	case "/debug/pprof/symbol":
+		pprof.Symbol(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
+
+// Addr returns the address on which the Service is listening
+func (s *Service) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// compressible returns whether the response to the request may be compressed.
+// Backups which the client asked to be compressed already are excluded, so
+// they are not compressed twice.
+func (s *Service) compressible(r *http.Request, qp QueryParams) bool {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/db/backup"):
+		return r.URL.Path == "/db/backup" && qp.Compression() == ""
+	case strings.HasPrefix(r.URL.Path, "/db/query"),
+		strings.HasPrefix(r.URL.Path, "/db/execute"),
+		strings.HasPrefix(r.URL.Path, "/db/request"),
+		r.URL.Path == "/status":
+		return true
+	}
+	return false
+}
+
+// runWithDeadline runs fn, returning early with the context's error if the
+// context's deadline passes before fn completes. In that case fn continues
+// to run in the background, so the caller must not read anything fn sets.
+func runWithDeadline(ctx context.Context, fn func() error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return fn()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runWrite runs fn, which may modify the database, unless the context's
+// deadline has already passed. Once started fn always runs to completion,
+// since a write abandoned at the deadline may still be applied, leaving the
+// client unable to tell whether it was. Waiting also keeps the request's
+// limiter slot held for as long as the write is in progress.
+func runWrite(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// leaderAddr returns the Raft address of the Leader, subject to the
+// request's deadline.
+func (s *Service) leaderAddr(r *http.Request) (string, error) {
+	var addr string
+	err := runWithDeadline(r.Context(), func() error {
+		a, err := s.store.LeaderAddr()
+		addr = a
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// remainingTimeout returns the time left before the request's deadline,
+// or the timeout set by the client if the request has no deadline.
+func remainingTimeout(r *http.Request, qp QueryParams) time.Duration {
+	if d, ok := r.Context().Deadline(); ok {
+		return time.Until(d)
+	}
+	return qp.Timeout(defaultTimeout)
+}
+
+// deadlineExceeded writes a 408 response and returns true if the request's
+// deadline has passed.
+func deadlineExceeded(w http.ResponseWriter, r *http.Request) bool {
+	if !errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+	writeDeadlineExceeded(w)
+	return true
+}
+
+// newLatencyHistogram returns a Histogram for latencies, in microseconds,
+// with buckets from 100µs to 10s.
+func newLatencyHistogram() *Histogram {
+	return NewHistogram(100, 500, 1000, 5000, 10000, 50000, 100000, 500000, 1000000, 5000000, 10000000)
+}
+
+// observeLatency records the time elapsed since start, in microseconds.
+func observeLatency(h *Histogram, start time.Time) {
+	h.Observe(time.Since(start).Microseconds())
+}
+
+func writeDeadlineExceeded(w http.ResponseWriter) {
+	stats.Add(numDeadlineExceeded, 1)
+	http.Error(w, "request deadline exceeded", http.StatusRequestTimeout)
+}
+
+// writeBodyError responds to a request whose body could not be read or
+// parsed, with the given message and code. If the body was larger than
+// permitted, it responds with 413 Request Entity Too Large instead.
+func writeBodyError(w http.ResponseWriter, err error, msg string, code int) {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		writeRequestTooLarge(w, mbe.Limit)
+		return
+	}
+	http.Error(w, msg, code)
+}
+
+func writeRequestTooLarge(w http.ResponseWriter, limit int64) {
+	stats.Add(numRequestTooLarge, 1)
+	http.Error(w, fmt.Sprintf("request body larger than maximum of %d bytes", limit),
+		http.StatusRequestEntityTooLarge)
+}
+
+// maxRequestBytes returns the maximum size, in bytes, of the body of the
+// given request, or 0 if there is no limit. Requests which may carry a
+// SQLite database file have a limit of their own.
+func (s *Service) maxRequestBytes(r *http.Request) int64 {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/db/load"), r.URL.Path == "/boot", r.URL.Path == "/db/backup/validate",
+		r.URL.Path == "/db/import":
+		return s.MaxLoadBytes
+	default:
+		return s.MaxRequestBytes
+	}
+}
+
+// writeLeaderNotFound responds with 503 Service Unavailable to a request which
+// needs the Leader when none is known, such as during an election. The
+// Retry-After header tells the client when to retry, and the election
+// timeout is reported so clients may pace retries themselves.
+func (s *Service) writeLeaderNotFound(w http.ResponseWriter) {
+	stats.Add(numLeaderNotFound, 1)
+	retry := s.leaderRetryAfter()
+	w.Header().Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+	if s.ElectionTimeout > 0 {
+		w.Header().Set(ElectionTimeoutHTTPHeader, s.ElectionTimeout.String())
+	}
+	http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+}
+
+// leaderRetryAfter returns how long a client should wait before retrying a
+// request which failed because there is no Leader. An election normally
+// completes within an election timeout, so that is the initial wait. It
+// doubles for every election timeout the node has been without a Leader,
+// since a long wait suggests the cluster has lost quorum, up to a maximum.
+func (s *Service) leaderRetryAfter() time.Duration {
+	et := s.ElectionTimeout
+	if et <= 0 {
+		et = time.Second
 	}
-
-	switch r.URL.Path {
-	case "/debug/pprof/cmdline":
-		pprof.Cmdline(w, r)
-	case "/debug/pprof/profile":
-		pprof.Profile(w, r)
-	case "/debug/pprof/symbol":
-		pprof.Symbol(w, r)
-	default:
-		pprof.Index(w, r)
+	retry := et
+	for n := s.store.LeaderlessFor() / et; n > 0 && retry < maxLeaderRetryAfter; n-- {
+		retry *= 2
+	}
+	if retry > maxLeaderRetryAfter {
+		retry = maxLeaderRetryAfter
 	}
+	return retry
 }
 
-// Addr returns the address on which the Service is listening
-func (s *Service) Addr() net.Addr {
-	return s.ln.Addr()
+// rejectReadOnly rejects a request to write to the database of a read-only
+// node, redirecting it to the Leader if the client asked to be redirected.
+// Returns true if the request was rejected, and so handled.
+func (s *Service) rejectReadOnly(w http.ResponseWriter, r *http.Request, qp QueryParams) bool {
+	if !s.ReadOnly {
+		return false
+	}
+	if s.DoRedirect(w, r, qp) {
+		return true
+	}
+	stats.Add(numReadOnlyRejected, 1)
+	http.Error(w, "node is read-only, send writes to the Leader", http.StatusForbidden)
+	return true
 }
 
 // DoRedirect checks if the request is a redirect, and if so, performs the redirect.
 // Returns true caller can consider the request handled. Returns false if the request
-// was not a redirect and the caller should continue processing the request.
+// was not a redirect and the caller should continue processing the request. Either
+// way, the response reports the Leader, if known, in the LeaderHTTPHeader header.
 func (s *Service) DoRedirect(w http.ResponseWriter, r *http.Request, qp QueryParams) bool {
+	leaderAPIAddr := s.setLeaderHeader(w, r)
 	if !qp.Redirect() {
 		return false
 	}
 
-	rd, err := s.FormRedirect(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if leaderAPIAddr == "" {
+		s.writeLeaderNotFound(w)
 	} else {
-		http.Redirect(w, r, rd, http.StatusMovedPermanently)
+		http.Redirect(w, r, redirectURL(r, leaderAPIAddr), http.StatusMovedPermanently)
+	}
+	return true
+}
+
+// setLeaderHeader sets the LeaderHTTPHeader header of a response to a
+// request this node could not serve because it is not the Leader, and
+// returns the API address of the Leader. If the Leader is not known before
+// the request's deadline, the header is not set and an empty string is
+// returned.
+func (s *Service) setLeaderHeader(w http.ResponseWriter, r *http.Request) string {
+	var leaderAPIAddr string
+	if err := runWithDeadline(r.Context(), func() error {
+		leaderAPIAddr = s.LeaderAPIAddr()
+		return nil
+	}); err != nil {
+		return ""
 	}
+	if leaderAPIAddr != "" {
+		w.Header().Set(LeaderHTTPHeader, leaderAPIAddr)
+	}
+	return leaderAPIAddr
+}
+
+// redirectToFollower redirects the read to a follower which, when last
+// checked, had applied every entry committed by this node, with read
+// consistency level "none" so the follower serves it from its own database.
+// The request is authenticated first, so a follower is never disclosed to
+// a client which could not have run the read. It returns false, having
+// written nothing, if no such follower is known.
+func (s *Service) redirectToFollower(w http.ResponseWriter, r *http.Request) bool {
+	u, ok := s.followerReads.Pick()
+	if !ok {
+		return false
+	}
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return true
+	}
+	q := r.URL.Query()
+	q.Set("level", "none")
+	stats.Add(numFollowerReadRedirects, 1)
+	http.Redirect(w, r, fmt.Sprintf("%s%s?%s", u, r.URL.Path, q.Encode()),
+		http.StatusTemporaryRedirect)
 	return true
 }
 
@@ -1437,17 +5056,26 @@ func (s *Service) FormRedirect(r *http.Request) (string, error) {
 		stats.Add(numLeaderNotFound, 1)
 		return "", ErrLeaderNotFound
 	}
+	return redirectURL(r, leaderAPIAddr), nil
+}
 
+// redirectURL returns the URL of the given request on the node with the
+// given API address.
+func redirectURL(r *http.Request, apiAddr string) string {
 	rq := r.URL.RawQuery
 	if rq != "" {
 		rq = fmt.Sprintf("?%s", rq)
 	}
-	return fmt.Sprintf("%s%s%s", leaderAPIAddr, r.URL.Path, rq), nil
+	return fmt.Sprintf("%s%s%s", apiAddr, r.URL.Path, rq)
 }
 
 // CheckRequestPerm checks if the request is authenticated and authorized
 // with the given Perm.
 func (s *Service) CheckRequestPerm(r *http.Request, perm string) (b bool) {
+	if s.authExempt(r) {
+		return true
+	}
+
 	defer func() {
 		if b {
 			stats.Add(numAuthOK, 1)
@@ -1461,17 +5089,16 @@ func (s *Service) CheckRequestPerm(r *http.Request, perm string) (b bool) {
 		return true
 	}
 
-	username, password, ok := r.BasicAuth()
-	if !ok {
-		username = ""
-	}
-
-	return s.credentialStore.AA(username, password, perm)
+	return s.requestAuthorizer(r)(perm)
 }
 
 // CheckRequestPermAll checksif the request is authenticated and authorized
 // with all the given Perms.
 func (s *Service) CheckRequestPermAll(r *http.Request, perms ...string) (b bool) {
+	if s.authExempt(r) {
+		return true
+	}
+
 	defer func() {
 		if b {
 			stats.Add(numAuthOK, 1)
@@ -1485,17 +5112,167 @@ func (s *Service) CheckRequestPermAll(r *http.Request, perms ...string) (b bool)
 		return true
 	}
 
+	authorized := s.requestAuthorizer(r)
+	for _, perm := range perms {
+		if !authorized(perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestAuthorizer returns a function which checks whether the request is
+// authorized with a given perm. The request is authenticated by its TLS
+// client certificate, bearer token, or Basic Auth credentials, in that order
+// of precedence.
+func (s *Service) requestAuthorizer(r *http.Request) func(perm string) bool {
+	if username, ok := s.certUsername(r); ok {
+		return func(perm string) bool {
+			return s.credentialStore.Authorized(username, perm)
+		}
+	}
+
+	if token, ok := s.bearerToken(r); ok {
+		username, tokenPerms, err := s.TokenValidator.Validate(token)
+		if err != nil {
+			stats.Add(numTokenAuthFail, 1)
+			return func(string) bool { return false }
+		}
+		return func(perm string) bool {
+			for _, p := range tokenPerms {
+				if p == perm || p == auth.PermAll {
+					return true
+				}
+			}
+			return s.credentialStore.Authorized(username, perm)
+		}
+	}
+
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		username = ""
 	}
+	return func(perm string) bool {
+		return s.credentialStore.AA(username, password, perm)
+	}
+}
+
+// bearerToken returns the bearer token of the request, if token
+// authentication is enabled.
+func (s *Service) bearerToken(r *http.Request) (string, bool) {
+	if s.TokenValidator == nil {
+		return "", false
+	}
+	h := r.Header.Get("Authorization")
+	if len(h) < len("Bearer ") || !strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[len("Bearer "):]), true
+}
 
-	for _, perm := range perms {
-		if !s.credentialStore.AA(username, password, perm) {
-			return false
+// certUsername returns the user named by the verified TLS client certificate
+// of the request, if client certificate authentication is enabled.
+func (s *Service) certUsername(r *http.Request) (string, bool) {
+	if !s.ClientCertAuth || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 ||
+		len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName, true
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], true
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0], true
+	}
+	return "", false
+}
+
+// authenticatedUsername returns the user the given request authenticates
+// as, if the request carries valid credentials. A username which is not
+// backed by valid credentials is ignored, so that a client cannot claim the
+// table access, limits, or requests of another user.
+func (s *Service) authenticatedUsername(r *http.Request) (string, bool) {
+	if s.credentialStore == nil {
+		return "", false
+	}
+	if username, ok := s.certUsername(r); ok {
+		return username, true
+	}
+	if token, ok := s.bearerToken(r); ok {
+		username, _, err := s.TokenValidator.Validate(token)
+		return username, err == nil && username != ""
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || username == "" || !s.credentialStore.Check(username, password) {
+		return "", false
+	}
+	return username, true
+}
+
+// forwardCredentials returns the credentials with which the request is
+// forwarded to another node. Other nodes can only check Basic Auth
+// credentials, so an error is returned if the request is authenticated in
+// any other way.
+func (s *Service) forwardCredentials(r *http.Request) (*clstrPB.Credentials, error) {
+	if s.credentialStore != nil {
+		if _, ok := s.certUsername(r); ok {
+			return nil, ErrCertNotForwardable
+		}
+		if _, ok := s.bearerToken(r); ok {
+			return nil, ErrTokenNotForwardable
 		}
 	}
-	return true
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		username = ""
+	}
+	return makeCredentials(username, password), nil
+}
+
+// writeNotForwardable responds to a request which must be served by the
+// Leader, but which cannot be forwarded to it because of how the request is
+// authenticated. The client is told to send the request to the Leader, which
+// is reported in the LeaderHTTPHeader header if known.
+func (s *Service) writeNotForwardable(w http.ResponseWriter, err error) {
+	stats.Add(numNotForwardable, 1)
+	http.Error(w, fmt.Sprintf("%s, send the request to the Leader", err.Error()),
+		http.StatusMisdirectedRequest)
+}
+
+// writeUnauthorized responds to a request which failed authentication or
+// authorization, challenging the client to authenticate.
+func (s *Service) writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+	s.addAuthChallenge(w, r)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// addAuthChallenge sets the WWW-Authenticate header of the response to a
+// request which failed authentication or authorization. Requests with a
+// bearer token are challenged for a token, and those with a verified client
+// certificate are not challenged, since other credentials would be ignored.
+func (s *Service) addAuthChallenge(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.certUsername(r); ok {
+		return
+	}
+	realm := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s.AuthRealm)
+	if _, ok := s.bearerToken(r); ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token"`, realm))
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+}
+
+// authExempt returns whether the request is for a route which may be
+// accessed without credentials.
+func (s *Service) authExempt(r *http.Request) bool {
+	for _, p := range s.AuthExemptRoutes {
+		if r.URL.Path == p {
+			return true
+		}
+	}
+	return false
 }
 
 // LeaderAPIAddr returns the API address of the leader, as known by this node.
@@ -1550,7 +5327,7 @@ func (s *Service) runQueue() {
 							_, err = s.cluster.Execute(er, addr, nil, defaultTimeout, 0)
 							if err != nil {
 								s.logger.Printf("execute queue write failed for sequence number %d on node %s: %s",
-									req.SequenceNumber, s.Addr().String(), err.Error())
+									req.SequenceNumber, s.Addr().String(), s.redactor(req.Statements).Redact(err.Error()))
 								if err.Error() == "leadership lost while committing log" {
 									stats.Add(numQueuedExecutionsLeadershipLost, 1)
 								} else if err.Error() == "not leader" {
@@ -1582,6 +5359,15 @@ func (s *Service) runQueue() {
 	}
 }
 
+// sampleRequest returns whether the given request should be logged.
+func (s *Service) sampleRequest(r *http.Request) bool {
+	if forced, err := strconv.ParseBool(r.Header.Get(TraceHTTPHeader)); err == nil && forced {
+		return true
+	}
+	rate := s.logSampleRate()
+	return rate > 0 && random.Float64() < rate
+}
+
 // addBuildVersion adds the build version to the HTTP response.
 func (s *Service) addBuildVersion(w http.ResponseWriter) {
 	// Add version header to every response, if available.
@@ -1592,18 +5378,14 @@ func (s *Service) addBuildVersion(w http.ResponseWriter) {
 	w.Header().Add(VersionHTTPHeader, version)
 }
 
-// addAllowHeaders adds the Access-Control-Allow-Origin, Access-Control-Allow-Methods,
-// and Access-Control-Allow-Headers headers to the HTTP response.
-func (s *Service) addAllowHeaders(w http.ResponseWriter) {
-	if s.AllowOrigin != "" {
-		w.Header().Add(AllowOriginHeader, s.AllowOrigin)
+// addResponseHeaders adds any configured static headers to the HTTP response.
+// If HTTPS is enabled a HSTS header is also added, unless explicitly configured.
+func (s *Service) addResponseHeaders(w http.ResponseWriter) {
+	if s.HTTPS() {
+		w.Header().Set(StrictTransportSecurityHeader, defaultHSTS)
 	}
-	w.Header().Add(AllowMethodsHeader, "OPTIONS, GET, POST")
-	if s.credentialStore == nil {
-		w.Header().Add(AllowHeadersHeader, "Content-Type")
-	} else {
-		w.Header().Add(AllowHeadersHeader, "Content-Type, Authorization")
-		w.Header().Add(AllowCredentialsHeader, "true")
+	for k, v := range s.ResponseHeaders {
+		w.Header().Set(k, v)
 	}
 }
 
@@ -1615,6 +5397,24 @@ func addBackupFormatHeader(w http.ResponseWriter, qp QueryParams) {
 	}
 }
 
+// contentEncodingWriter sets the Content-Encoding header on the first write,
+// so error responses sent before any data is written are not marked as
+// encoded.
+type contentEncodingWriter struct {
+	http.ResponseWriter
+	encoding string
+	wrote    bool
+}
+
+// Write implements the io.Writer interface.
+func (c *contentEncodingWriter) Write(b []byte) (int, error) {
+	if !c.wrote {
+		c.Header().Set("Content-Encoding", c.encoding)
+		c.wrote = true
+	}
+	return c.ResponseWriter.Write(b)
+}
+
 // tlsStats returns the TLS stats for the service.
 func (s *Service) tlsStats() map[string]interface{} {
 	m := map[string]interface{}{
@@ -1626,6 +5426,21 @@ func (s *Service) tlsStats() map[string]interface{} {
 		m["key_file"] = s.KeyFile
 		m["ca_file"] = s.CACertFile
 		m["next_protos"] = s.tlsConfig.NextProtos
+		m["http2"] = map[string]interface{}{
+			"enabled":                !s.HTTP2Disabled,
+			"max_concurrent_streams": s.HTTP2MaxConcurrentStreams,
+			"idle_timeout":           s.HTTP2IdleTimeout.String(),
+		}
+		if len(s.SNICertificates) > 0 {
+			sni := make(map[string]interface{}, len(s.SNICertificates))
+			for host, ckf := range s.SNICertificates {
+				sni[host] = map[string]string{
+					"cert_file": ckf.CertFile,
+					"key_file":  ckf.KeyFile,
+				}
+			}
+			m["sni_certs"] = sni
+		}
 	}
 	return m
 }
@@ -1649,27 +5464,370 @@ func (s *Service) writeResponse(w http.ResponseWriter, r *http.Request, qp Query
 		return
 	}
 	_, err = w.Write(b)
+	if err != nil {
+		s.logRequestf(r, "writing response failed: %s", err.Error())
+	}
+}
+
+// loggingResponseWriter records the status code written to the underlying
+// ResponseWriter, so the request can be logged once it is handled.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	start      time.Time
+}
+
+func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
+	return &loggingResponseWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		start:          time.Now(),
+	}
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (lw *loggingResponseWriter) WriteHeader(code int) {
+	lw.statusCode = code
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// Flush implements the http.Flusher interface.
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface.
+func (lw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	lw.statusCode = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// writeJSON writes the given value to the given writer, in JSON form.
+func (s *Service) writeJSON(w http.ResponseWriter, qp QueryParams, v interface{}) {
+	var b []byte
+	var err error
+	if qp.Pretty() {
+		b, err = json.MarshalIndent(v, "", "    ")
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("JSON marshal: %s", err.Error()),
+			http.StatusInternalServerError)
+		return
+	}
+	_, err = w.Write(b)
 	if err != nil {
 		s.logger.Println("writing response failed:", err.Error())
 	}
 }
 
-func requestQueries(r *http.Request, qp QueryParams) ([]*proto.Statement, error) {
+// maxRows returns the maximum number of rows any single statement may return
+// for the given request. A limit configured for the authenticated user takes
+// precedence over the default.
+func (s *Service) maxRows(r *http.Request) int64 {
+	if s.credentialStore != nil {
+		username, _ := s.authenticatedUsername(r)
+		if n, ok := s.credentialStore.MaxRows(username); ok {
+			return n
+		}
+	}
+	return s.defaultMaxRows()
+}
+
+// queryLimitMode returns how SELECT statements without a LIMIT clause are
+// treated for the given request. A mode configured for the authenticated user
+// takes precedence over the default.
+func (s *Service) queryLimitMode(r *http.Request) string {
+	if s.credentialStore != nil {
+		username, _ := s.authenticatedUsername(r)
+		if m, ok := s.credentialStore.QueryLimit(username); ok {
+			return m
+		}
+	}
+	return s.queryLimit()
+}
+
+// applyQueryLimit rejects, or adds a LIMIT clause to, those of the given
+// queries which are SELECT statements without one, as the query limit mode
+// for the request requires.
+func (s *Service) applyQueryLimit(r *http.Request, queries []*proto.Statement) error {
+	switch s.queryLimitMode(r) {
+	case auth.QueryLimitReject:
+		for i := range queries {
+			if stmts := command.NoLimit(queries[i].Sql); len(stmts) > 0 {
+				stats.Add(numQueryLimitRejected, 1)
+				return fmt.Errorf("statement has no LIMIT clause, add one such as LIMIT %d: %s",
+					s.queryLimitRows(), stmts[0])
+			}
+		}
+	case auth.QueryLimitAppend:
+		n := s.queryLimitRows()
+		for i := range queries {
+			if sql, ok := command.AddLimit(queries[i].Sql, n); ok {
+				stats.Add(numQueryLimitAppended, 1)
+				queries[i].Sql = sql
+			}
+		}
+	}
+	return nil
+}
+
+// rowDifferences returns, sorted, the columns whose values differ between
+// the two rows. If exactly one of the rows is missing, every column of the
+// other is considered different.
+func rowDifferences(a, b map[string]interface{}) []string {
+	var diffs []string
+	for c, v := range a {
+		if bv, ok := b[c]; !ok || !reflect.DeepEqual(v, bv) {
+			diffs = append(diffs, c)
+		}
+	}
+	for c := range b {
+		if _, ok := a[c]; !ok {
+			diffs = append(diffs, c)
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// checkTablePerms returns an error if the user making the request may not
+// access, with perm, every table referenced by the statements. A request
+// which does not authenticate as a user is checked against the tables all
+// users may access. A statement the parser cannot handle has its tables
+// determined by SQLite, which must be able to prepare it against the local
+// database; for such a statement, the tables behind any view or trigger it
+// uses must be accessible too. Statements whose tables cannot be determined
+// are permitted only to users whose access with perm is not restricted to
+// certain tables.
+func (s *Service) checkTablePerms(r *http.Request, perm string, stmts []*proto.Statement) error {
+	if s.credentialStore == nil || len(stmts) == 0 {
+		return nil
+	}
+	username, _ := s.authenticatedUsername(r)
+	if s.credentialStore.HasPermForResource(username, perm, auth.AllResources) {
+		return nil
+	}
+
+	for _, stmt := range stmts {
+		tables, err := command.TablesWith(stmt.Sql, s.store.StmtTables)
+		if err != nil {
+			stats.Add(numTablePermDenied, 1)
+			return fmt.Errorf("%s not permitted, unable to determine tables of statement: %s", perm, stmt.Sql)
+		}
+		if err := s.checkTablePerm(r, perm, tables...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isWrite returns whether the given SQL may modify the database, as
+// classified by SQLite against the local database.
+func (s *Service) isWrite(sql string) bool {
+	return command.IsWriteWith(sql, s.store.StmtReadOnly)
+}
+
+// checkRequestTablePerms checks the table perms of a unified request, which
+// may contain both queries and statements which modify the database.
+func (s *Service) checkRequestTablePerms(r *http.Request, stmts []*proto.Statement) error {
+	var queries, executes []*proto.Statement
+	for _, stmt := range stmts {
+		if s.isWrite(stmt.Sql) {
+			executes = append(executes, stmt)
+		} else {
+			queries = append(queries, stmt)
+		}
+	}
+	if err := s.checkTablePerms(r, auth.PermQuery, queries); err != nil {
+		return err
+	}
+	return s.checkTablePerms(r, auth.PermExecute, executes)
+}
+
+// checkTablePerm returns an error if the user making the request may not
+// access, with perm, every one of the given tables.
+func (s *Service) checkTablePerm(r *http.Request, perm string, tables ...string) error {
+	if s.credentialStore == nil {
+		return nil
+	}
+	username, _ := s.authenticatedUsername(r)
+	for _, t := range tables {
+		if !s.credentialStore.HasPermForResource(username, perm, t) {
+			stats.Add(numTablePermDenied, 1)
+			if t == auth.AllResources {
+				return fmt.Errorf("%s not permitted on all tables", perm)
+			}
+			return fmt.Errorf("%s not permitted on table %s", perm, t)
+		}
+	}
+	return nil
+}
+
+// injectParams binds the authenticated user's default parameters to any
+// statement which references them by name. Injected values replace any of
+// the same name supplied in the request, so clients cannot override them.
+func (s *Service) injectParams(r *http.Request, stmts []*proto.Statement) error {
+	if s.credentialStore == nil {
+		return nil
+	}
+	username, _ := s.authenticatedUsername(r)
+	params := s.credentialStore.Params(username)
+	if len(params) == 0 {
+		return nil
+	}
+
+	for _, stmt := range stmts {
+		for _, name := range command.NamedParameters(stmt.Sql) {
+			v, ok := params[name]
+			if !ok {
+				continue
+			}
+			p, err := makeParameter(name, v)
+			if err != nil {
+				return fmt.Errorf("parameter %s: %s", name, err.Error())
+			}
+			stmtParams := stmt.Parameters[:0]
+			for _, sp := range stmt.Parameters {
+				if sp.Name != name {
+					stmtParams = append(stmtParams, sp)
+				}
+			}
+			stmt.Parameters = append(stmtParams, p)
+		}
+	}
+	return nil
+}
+
+// truncateQueryRows limits each set of rows to at most max rows, marking
+// any that are cut short as truncated. A max of 0 means no limit.
+func truncateQueryRows(rows []*proto.QueryRows, max int64) {
+	if max <= 0 {
+		return
+	}
+	for _, qr := range rows {
+		if qr != nil && int64(len(qr.Values)) > max {
+			qr.Values = qr.Values[:max]
+			qr.Truncated = true
+			stats.Add(numQueryRowsTruncated, 1)
+		}
+	}
+}
+
+// observeQueryRows records the number of rows returned by, and the size of,
+// each query result.
+func (s *Service) observeQueryRows(rows []*proto.QueryRows) {
+	for _, qr := range rows {
+		if qr == nil || qr.Error != "" {
+			continue
+		}
+		s.queryRowsHist.Observe(int64(len(qr.Values)))
+		s.queryBytesHist.Observe(int64(pb.Size(qr)))
+	}
+}
+
+// checkSQLiteData runs an integrity check on the given SQLite database file.
+func checkSQLiteData(b []byte) error {
+	f, err := os.CreateTemp("", "rqlite-validate-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	ok, err := db.CheckIntegrity(f.Name(), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("integrity check failed")
+	}
+	return nil
+}
+
+func requestQueries(r *http.Request, qp QueryParams, maxStmtBytes, maxStmts int) ([]*proto.Statement, error) {
 	if r.Method == "GET" {
-		return []*proto.Statement{
-			{
-				Sql: qp.Query(),
-			},
-		}, nil
+		// The q parameter may be repeated, and each may contain multiple
+		// statements, all of which are executed in turn.
+		var stmts []*proto.Statement
+		for _, q := range r.URL.Query()["q"] {
+			sqls := command.Split(q)
+			if len(sqls) == 0 {
+				sqls = []string{q}
+			}
+			for i := range sqls {
+				if err := CheckStatementSize(len(stmts), sqls[i], maxStmtBytes); err != nil {
+					return nil, err
+				}
+				stmts = append(stmts, &proto.Statement{
+					Sql: sqls[i],
+				})
+			}
+		}
+		if len(stmts) == 0 {
+			stmts = []*proto.Statement{{Sql: qp.Query()}}
+		}
+		if err := CheckStatementCount(len(stmts), maxStmts); err != nil {
+			return nil, err
+		}
+		return stmts, nil
 	}
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, errors.New("bad query POST request")
+		return nil, fmt.Errorf("bad query POST request: %w", err)
 	}
 	r.Body.Close()
 
-	return ParseRequest(b)
+	return parseRequestBody(r, b, maxStmtBytes, maxStmts)
+}
+
+// explainQueryPlan returns the statement which reports the query plan of
+// the given statement. EXPLAIN statements are returned unchanged.
+func explainQueryPlan(stmt string) string {
+	if t := strings.TrimSpace(stmt); len(t) >= len("EXPLAIN") && strings.EqualFold(t[:len("EXPLAIN")], "EXPLAIN") {
+		return stmt
+	}
+	return "EXPLAIN QUERY PLAN " + stmt
+}
+
+// parseRequestBody generates a set of Statements from the body of the given
+// request, which is raw SQL if so identified by its Content-Type, and JSON
+// otherwise.
+func parseRequestBody(r *http.Request, b []byte, maxStmtBytes, maxStmts int) ([]*proto.Statement, error) {
+	var stmts []*proto.Statement
+	var err error
+	if IsSQLContentType(r.Header.Get("Content-Type")) {
+		stmts, err = ParseSQLRequestLimit(b, maxStmtBytes)
+	} else {
+		stmts, err = ParseRequestLimit(b, maxStmtBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckStatementCount(len(stmts), maxStmts); err != nil {
+		return nil, err
+	}
+	return stmts, nil
 }
 
 func getSubJSON(jsonBlob []byte, keyString string) (json.RawMessage, error) {
@@ -2,21 +2,38 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/raft"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/v8/auth"
 	cluster "github.com/rqlite/rqlite/v8/cluster/proto"
 	command "github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
 	"github.com/rqlite/rqlite/v8/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_ResponseJSONMarshal(t *testing.T) {
@@ -99,6 +116,72 @@ func Test_NewService(t *testing.T) {
 	}
 }
 
+func Test_ShutdownDrainsRequests(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		expErr  error
+	}{
+		{"drained", 5 * time.Second, nil},
+		{"deadline", 100 * time.Millisecond, context.DeadlineExceeded},
+	} {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		m := &MockStore{
+			executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+				close(started)
+				<-release
+				return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+			},
+		}
+		s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+		if err := s.Start(); err != nil {
+			t.Fatalf("%s: failed to start service", tt.name)
+		}
+		url := fmt.Sprintf("http://%s/db/execute", s.Addr().String())
+
+		respCh := make(chan int, 1)
+		go func() {
+			resp, err := http.Post(url, "application/json", strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+			if err != nil {
+				respCh <- 0
+				return
+			}
+			resp.Body.Close()
+			respCh <- resp.StatusCode
+		}()
+		<-started
+
+		errCh := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			errCh <- s.Shutdown(ctx)
+		}()
+		if tt.expErr == nil {
+			time.Sleep(100 * time.Millisecond)
+			if _, err := http.Get(fmt.Sprintf("http://%s/status", s.Addr().String())); err == nil {
+				t.Fatalf("%s: new connection accepted during shutdown", tt.name)
+			}
+			close(release)
+		}
+
+		if err := <-errCh; err != tt.expErr {
+			t.Fatalf("%s: wrong error from Shutdown, exp %v, got %v", tt.name, tt.expErr, err)
+		}
+		code := <-respCh
+		if tt.expErr == nil && code != http.StatusOK {
+			t.Fatalf("%s: in-progress request not completed, got status %d", tt.name, code)
+		}
+		if tt.expErr != nil {
+			if code != 0 {
+				t.Fatalf("%s: expected in-progress request to be interrupted, got status %d", tt.name, code)
+			}
+			close(release)
+		}
+	}
+}
+
 func Test_HasVersionHeader(t *testing.T) {
 	m := &MockStore{}
 	c := &mockClusterService{}
@@ -134,7 +217,12 @@ func Test_HasAllowOriginHeader(t *testing.T) {
 	url := fmt.Sprintf("http://%s", s.Addr().String())
 
 	client := &http.Client{}
-	resp, err := client.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("Origin", "https://www.philipotoole.com")
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatalf("failed to make request")
 	}
@@ -142,8 +230,8 @@ func Test_HasAllowOriginHeader(t *testing.T) {
 		t.Fatalf("incorrect allow-origin present in HTTP response header")
 	}
 
-	s.AllowOrigin = "https://www.philipotoole.com"
-	resp, err = client.Get(url)
+	s.AllowOrigins = []string{"https://www.philipotoole.com"}
+	resp, err = client.Do(req)
 	if err != nil {
 		t.Fatalf("failed to make request")
 	}
@@ -152,6 +240,36 @@ func Test_HasAllowOriginHeader(t *testing.T) {
 	}
 }
 
+func Test_ResponseHeaders(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.ResponseHeaders = map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	url := fmt.Sprintf("http://%s/status", s.Addr().String())
+
+	client := &http.Client{}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("failed to make request")
+	}
+	if exp, got := "nosniff", resp.Header.Get("X-Content-Type-Options"); exp != got {
+		t.Fatalf("incorrect X-Content-Type-Options header, exp %s, got %s", exp, got)
+	}
+	if exp, got := "DENY", resp.Header.Get("X-Frame-Options"); exp != got {
+		t.Fatalf("incorrect X-Frame-Options header, exp %s, got %s", exp, got)
+	}
+	if v := resp.Header.Get(StrictTransportSecurityHeader); v != "" {
+		t.Fatalf("HSTS header present without TLS: %s", v)
+	}
+}
+
 func Test_Options(t *testing.T) {
 	m := &MockStore{}
 	c := &mockClusterService{}
@@ -279,11 +397,28 @@ func Test_405Routes(t *testing.T) {
 		{method: "GET", path: "/db/execute"},
 		{method: "GET", path: "/boot"},
 		{method: "GET", path: "/db/load"},
+		{method: "GET", path: "/db/replicate-to"},
+		{method: "GET", path: "/snapshot"},
+		{method: "POST", path: "/config/flags"},
 		{method: "GET", path: "/remove"},
 		{method: "POST", path: "/remove"},
 		{method: "POST", path: "/db/backup"},
 		{method: "POST", path: "/status"},
 		{method: "POST", path: "/nodes"},
+		{method: "POST", path: "/db/queue"},
+		{method: "GET", path: "/db/backup/validate"},
+		{method: "POST", path: "/cluster/clockskew"},
+		{method: "POST", path: "/nodes/1/catchup"},
+		{method: "GET", path: "/cluster/verify-row"},
+		{method: "POST", path: "/db/file"},
+		{method: "GET", path: "/node/advertise"},
+		{method: "POST", path: "/db/tables/foo/rows"},
+		{method: "POST", path: "/db/tables/foo/checksum"},
+		{method: "POST", path: "/db/indexes"},
+		{method: "GET", path: "/db/migrate/preview"},
+		{method: "GET", path: "/db/migrate"},
+		{method: "GET", path: "/db/vacuum"},
+		{method: "POST", path: "/leader"},
 	}
 
 	m := &MockStore{}
@@ -359,11 +494,26 @@ func Test_401Routes_NoBasicAuth(t *testing.T) {
 		"/db/request",
 		"/db/backup",
 		"/db/load",
+		"/db/replicate-to",
+		"/snapshot",
+		"/config/flags",
+		"/db/queue",
+		"/db/file",
 		"/boot",
 		"/remove",
 		"/status",
 		"/nodes",
-		"/readyz",
+		"/leader",
+		"/cluster/clockskew",
+		"/cluster/verify-row",
+		"/cluster/query",
+		"/node/advertise",
+		"/db/tables/foo/rows",
+		"/db/tables/foo/checksum",
+		"/db/indexes",
+		"/db/migrate/preview",
+		"/db/migrate",
+		"/db/vacuum",
 		"/debug/vars",
 		"/debug/pprof/cmdline",
 		"/debug/pprof/profile",
@@ -376,6 +526,52 @@ func Test_401Routes_NoBasicAuth(t *testing.T) {
 		if resp.StatusCode != 401 {
 			t.Fatalf("failed to get expected 401 for path %s, got %d", path, resp.StatusCode)
 		}
+		if h := resp.Header.Get("WWW-Authenticate"); h != `Basic realm="rqlite", charset="UTF-8"` {
+			t.Fatalf("failed to get expected challenge for path %s, got %s", path, h)
+		}
+	}
+}
+
+func Test_401AuthRealm(t *testing.T) {
+	c := &mockCredentialStore{HasPermOK: false}
+
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, c)
+	s.AuthRealm = `my "cluster"`
+	s.TokenValidator = &mockTokenValidator{}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	url := fmt.Sprintf("http://%s/status", s.Addr().String())
+
+	for _, tt := range []struct {
+		header string
+		exp    string
+	}{
+		{"", `Basic realm="my \"cluster\"", charset="UTF-8"`},
+		{"Basic Zm9vOmJhcg==", `Basic realm="my \"cluster\"", charset="UTF-8"`},
+		{"Bearer bad-token", `Bearer realm="my \"cluster\"", error="invalid_token"`},
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failed to get expected 401, got %d", resp.StatusCode)
+		}
+		if h := resp.Header.Get("WWW-Authenticate"); h != tt.exp {
+			t.Fatalf("wrong challenge for Authorization %q, exp %s, got %s", tt.header, tt.exp, h)
+		}
 	}
 }
 
@@ -401,7 +597,6 @@ func Test_401Routes_BasicAuthBadPassword(t *testing.T) {
 		"/boot",
 		"/status",
 		"/nodes",
-		"/readyz",
 		"/debug/vars",
 		"/debug/pprof/cmdline",
 		"/debug/pprof/profile",
@@ -446,7 +641,6 @@ func Test_401Routes_BasicAuthBadPerm(t *testing.T) {
 		"/boot",
 		"/status",
 		"/nodes",
-		"/readyz",
 		"/debug/vars",
 		"/debug/pprof/cmdline",
 		"/debug/pprof/profile",
@@ -468,168 +662,431 @@ func Test_401Routes_BasicAuthBadPerm(t *testing.T) {
 	}
 }
 
-func Test_BackupOK(t *testing.T) {
+func Test_AuthExemptRoutes(t *testing.T) {
+	c := &mockCredentialStore{HasPermOK: false}
+
 	m := &MockStore{}
-	c := &mockClusterService{}
-	s := New("127.0.0.1:0", m, c, nil)
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, c)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		return nil
+	get := func(path string) int {
+		resp, err := http.Get(host + path)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
 	}
 
-	client := &http.Client{}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/db/backup")
-	if err != nil {
-		t.Fatalf("failed to make backup request")
+	if code := get("/readyz?noleader"); code != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for exempt route, got %d", code)
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
+	for _, path := range []string{"/status", "/db/query?q=SELECT+1", "/readyz/"} {
+		if code := get(path); code != http.StatusUnauthorized {
+			t.Fatalf("failed to get expected 401 for path %s, got %d", path, code)
+		}
+	}
+
+	s.AuthExemptRoutes = []string{"/status"}
+	if code := get("/status"); code != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for exempt route, got %d", code)
+	}
+	if code := get("/readyz?noleader"); code != http.StatusUnauthorized {
+		t.Fatalf("failed to get expected 401 for route no longer exempt, got %d", code)
 	}
 }
 
-func Test_BackupVacuumOK(t *testing.T) {
+func Test_BearerTokenAuth(t *testing.T) {
+	c := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			switch username {
+			case "carol":
+				return perm == auth.PermStatus
+			case "dave":
+				return password == "secret"
+			}
+			return false
+		},
+	}
+
 	m := &MockStore{}
-	c := &mockClusterService{}
-	s := New("127.0.0.1:0", m, c, nil)
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, c)
+	s.TokenValidator = &mockTokenValidator{
+		tokens: map[string]mockToken{
+			"alice-token": {"alice", []string{auth.PermStatus}},
+			"admin-token": {"admin", []string{auth.PermAll}},
+			"bob-token":   {"bob", []string{auth.PermQuery}},
+			"carol-token": {"carol", nil},
+		},
+	}
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
+	url := fmt.Sprintf("http://%s/status", s.Addr().String())
 
-	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		if !br.Vacuum {
-			t.Fatal("expected vacuum to be true")
+	for _, tt := range []struct {
+		name   string
+		header string
+		basic  bool
+		exp    int
+	}{
+		{"token with perm", "Bearer alice-token", false, http.StatusOK},
+		{"token with all perms", "bearer admin-token", false, http.StatusOK},
+		{"token without perm", "Bearer bob-token", false, http.StatusUnauthorized},
+		{"token user with perm in credential store", "Bearer carol-token", false, http.StatusOK},
+		{"invalid token", "Bearer bad-token", false, http.StatusUnauthorized},
+		{"invalid token overrides basic auth", "Bearer bad-token", true, http.StatusUnauthorized},
+		{"basic auth", "", true, http.StatusOK},
+		{"no credentials", "", false, http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if tt.basic {
+			req.SetBasicAuth("dave", "secret")
+		}
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: failed to make request: %s", tt.name, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("%s: wrong status code, exp %d, got %d", tt.name, tt.exp, resp.StatusCode)
 		}
-		return nil
 	}
+}
 
-	client := &http.Client{}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-
-	resp, err := client.Get(host + "/db/backup?vacuum")
-	if err != nil {
-		t.Fatalf("failed to make backup request")
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
+func Test_BearerTokenAuthForward(t *testing.T) {
+	c := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return username == "dave" && password == "secret"
+		},
 	}
-	resp, err = client.Get(host + "/db/backup?vacuum&fmt=binary")
-	if err != nil {
-		t.Fatalf("failed to make backup request")
+
+	// This node is a follower, so every write and strong read must be
+	// forwarded.
+	m := &MockStore{
+		leaderAddr: "leader:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return nil, store.ErrNotLeader
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, store.ErrNotLeader
+		},
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
+	var forwarded int
+	n := &mockClusterService{
+		apiAddr: "http://leader:4001",
+		executeFn: func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error) {
+			forwarded++
+			return []*command.ExecuteResult{{}}, nil
+		},
+		queryFn: func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+			forwarded++
+			return []*command.QueryRows{{}}, nil
+		},
 	}
-}
-
-func Test_BackupFlagsNoLeaderRedirect(t *testing.T) {
-	m := &MockStore{}
-	c := &mockClusterService{
-		apiAddr: "http://1.2.3.4:999",
+	s := New("127.0.0.1:0", m, n, c)
+	s.TokenValidator = &mockTokenValidator{
+		tokens: map[string]mockToken{
+			"admin-token": {"admin", []string{auth.PermAll}},
+		},
 	}
-
-	s := New("127.0.0.1:0", m, c, nil)
-
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		return store.ErrNotLeader
-	}
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"execute", "POST", "/db/execute", `["INSERT INTO foo VALUES(1)"]`},
+		{"query", "GET", "/db/query?level=strong&q=SELECT%201", ""},
+	} {
+		do := func(token bool) (*http.Response, string) {
+			req, err := http.NewRequest(tt.method, host+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("failed to create request: %s", err.Error())
+			}
+			if token {
+				req.Header.Set("Authorization", "Bearer admin-token")
+			} else {
+				req.SetBasicAuth("dave", "secret")
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: failed to make request: %s", tt.name, err.Error())
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("%s: failed to read body: %s", tt.name, err.Error())
+			}
+			return resp, string(b)
+		}
 
-	client := &http.Client{}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
+		// A request authenticated by token cannot be checked by the Leader,
+		// so the client is told to send it to the Leader instead.
+		forwarded = 0
+		resp, body := do(true)
+		if resp.StatusCode != http.StatusMisdirectedRequest {
+			t.Fatalf("%s: wrong status code, exp %d, got %d", tt.name, http.StatusMisdirectedRequest, resp.StatusCode)
+		}
+		if !strings.Contains(body, "send the request to the Leader") {
+			t.Fatalf("%s: wrong response body: %s", tt.name, body)
+		}
+		if h := resp.Header.Get("WWW-Authenticate"); h != "" {
+			t.Fatalf("%s: unexpected challenge %q", tt.name, h)
+		}
+		if h := resp.Header.Get(LeaderHTTPHeader); h != "http://leader:4001" {
+			t.Fatalf("%s: wrong Leader header, got %q", tt.name, h)
+		}
+		if forwarded != 0 {
+			t.Fatalf("%s: request authenticated by token was forwarded", tt.name)
+		}
 
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/db/backup?redirect")
-	if err != nil {
-		t.Fatalf("failed to make backup request: %s", err.Error())
-	}
-	if resp.StatusCode != http.StatusMovedPermanently {
-		t.Fatalf("failed to get expected StatusServiceUnavailable for backup, got %d", resp.StatusCode)
+		// Basic Auth credentials are still forwarded.
+		resp, _ = do(false)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: wrong status code for Basic Auth, exp %d, got %d", tt.name, http.StatusOK, resp.StatusCode)
+		}
+		if forwarded != 1 {
+			t.Fatalf("%s: request authenticated by Basic Auth was not forwarded", tt.name)
+		}
 	}
 }
 
-func Test_BackupFlagsNoLeaderRemoteFetch(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:1234",
+func Test_TablePerms(t *testing.T) {
+	c := &mockCredentialStore{
+		HasPermOK: true,
+		tables: map[string]map[string][]string{
+			"analyst": {
+				auth.PermQuery:   {"orders", "customers"},
+				auth.PermExecute: {"scratch"},
+			},
+		},
 	}
-	c := &mockClusterService{
-		apiAddr: "http://1.2.3.4:999",
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
+		requestFn: func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+			return []*command.ExecuteQueryResponse{}, nil
+		},
 	}
-
-	s := New("127.0.0.1:0", m, c, nil)
-
+	s := New("127.0.0.1:0", m, &mockClusterService{}, c)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		return store.ErrNotLeader
-	}
-
-	backupData := "this is SQLite data"
-	c.backupFn = func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error {
-		w.Write([]byte(backupData))
-		return nil
-	}
-
-	client := &http.Client{}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
+	for _, tt := range []struct {
+		username string
+		method   string
+		path     string
+		body     string
+		exp      int
+	}{
+		{"analyst", "GET", "/db/query?q=SELECT+*+FROM+orders", "", http.StatusOK},
+		{"analyst", "GET", "/db/query?q=SELECT+*+FROM+orders+JOIN+customers+ON+orders.cid%3Dcustomers.id", "", http.StatusOK},
+		{"analyst", "GET", "/db/query?q=SELECT+*+FROM+secrets", "", http.StatusForbidden},
+		{"analyst", "GET", "/db/query?q=SELECT+*+FROM+orders+JOIN+secrets+ON+orders.id%3Dsecrets.id", "", http.StatusForbidden},
+		{"analyst", "GET", "/db/query?q=SELECT+*+FROM+(SELECT+*+FROM+secrets)", "", http.StatusForbidden},
+		{"analyst", "GET", "/db/query?q=nonsense", "", http.StatusForbidden},
+		{"analyst", "POST", "/db/query", `["SELECT * FROM orders; SELECT * FROM secrets"]`, http.StatusForbidden},
+		{"analyst", "POST", "/db/execute", `["INSERT INTO scratch(id) VALUES(1)"]`, http.StatusOK},
+		{"analyst", "POST", "/db/execute", `["INSERT INTO orders(id) VALUES(1)"]`, http.StatusForbidden},
+		{"analyst", "POST", "/db/request", `["SELECT * FROM orders", "INSERT INTO scratch(id) VALUES(1)"]`, http.StatusOK},
+		{"analyst", "POST", "/db/request", `["SELECT * FROM scratch"]`, http.StatusForbidden},
+		{"analyst", "GET", "/db/tables/secrets/rows", "", http.StatusForbidden},
+		{"analyst", "GET", "/db/tables/secrets/checksum", "", http.StatusForbidden},
+		{"analyst", "GET", "/db/indexes", "", http.StatusForbidden},
+		{"admin", "GET", "/db/query?q=SELECT+*+FROM+secrets", "", http.StatusOK},
+		{"admin", "POST", "/db/execute", `["INSERT INTO orders(id) VALUES(1)"]`, http.StatusOK},
+	} {
+		req, err := http.NewRequest(tt.method, host+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth(tt.username, "password")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("wrong status code for %s %s %s by %s, exp %d, got %d",
+				tt.method, tt.path, tt.body, tt.username, tt.exp, resp.StatusCode)
+		}
 	}
+}
 
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/db/backup")
+// Test_TablePermsSQLite ensures the tables of statements the parser cannot
+// handle are determined by SQLite, and checked against the user's tables.
+func Test_TablePermsSQLite(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
 	if err != nil {
-		t.Fatalf("failed to make backup request: %s", err.Error())
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for remote backup fetch, got %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		t.Fatalf("failed to read response body: %s", err.Error())
-	}
-	if exp, got := backupData, string(body); exp != got {
-		t.Fatalf("received incorrect backup data, exp: %s, got: %s", exp, got)
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, cid INTEGER)`,
+		`CREATE TABLE customers (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE secrets (id INTEGER PRIMARY KEY)`,
+		`CREATE VIEW hidden AS SELECT * FROM secrets`,
+	} {
+		if _, err := database.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to create table: %s", err.Error())
+		}
 	}
-}
 
-func Test_BackupFlagsNoLeaderOK(t *testing.T) {
-	m := &MockStore{}
-	c := &mockClusterService{
-		apiAddr: "http://1.2.3.4:999",
+	c := &mockCredentialStore{
+		HasPermOK: true,
+		tables: map[string]map[string][]string{
+			"analyst": {
+				auth.PermQuery:   {"orders", "customers", "hidden"},
+				auth.PermExecute: {"orders"},
+			},
+		},
 	}
-
-	s := New("127.0.0.1:0", m, c, nil)
-
+	m := &MockStore{
+		tablesFn: database.StmtTables,
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, c)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		if !br.Leader {
-			return nil
+	for _, tt := range []struct {
+		path string
+		body string
+		exp  int
+	}{
+		{"/db/query", `["SELECT * FROM orders WHERE cid IN (SELECT id FROM customers)"]`, http.StatusOK},
+		{"/db/query", `["SELECT * FROM main.orders"]`, http.StatusOK},
+		{"/db/query", `["SELECT * FROM orders WHERE EXISTS (SELECT 1 FROM main.customers)"]`, http.StatusOK},
+		{"/db/query", `["SELECT * FROM orders WHERE cid IN (SELECT id FROM secrets)"]`, http.StatusForbidden},
+		{"/db/query", `["SELECT (SELECT COUNT(*) FROM secrets) FROM orders"]`, http.StatusForbidden},
+		{"/db/query", `["SELECT * FROM main.secrets"]`, http.StatusForbidden},
+		{"/db/query", `["SELECT * FROM orders WHERE id IN (SELECT id FROM hidden)"]`, http.StatusForbidden},
+		{"/db/query", `["SELECT * FROM orders WHERE id IN (SELECT id FROM nonexistent)"]`, http.StatusForbidden},
+		{"/db/query", `["PRAGMA table_info(secrets)"]`, http.StatusForbidden},
+		{"/db/execute", `["INSERT INTO main.orders(id) VALUES(1)"]`, http.StatusOK},
+		{"/db/execute", `["INSERT INTO main.secrets(id) VALUES(1)"]`, http.StatusForbidden},
+		{"/db/execute", `["DELETE FROM orders WHERE id IN (SELECT id FROM secrets)"]`, http.StatusForbidden},
+	} {
+		req, err := http.NewRequest("POST", host+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
 		}
-		return store.ErrNotLeader
+		req.SetBasicAuth("analyst", "password")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("wrong status code for %s %s, exp %d, got %d", tt.path, tt.body, tt.exp, resp.StatusCode)
+		}
+	}
+}
+
+// Test_TablePermsUnverifiedUser ensures a request naming a user, but not
+// authenticating as them, gets only the table access of all users.
+func Test_TablePermsUnverifiedUser(t *testing.T) {
+	c := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			// Every perm is granted to all users, so only Check
+			// verifies the password.
+			return perm != "" || password == "password"
+		},
+		tables: map[string]map[string][]string{
+			auth.AllUsers: {
+				auth.PermQuery: {"orders"},
+			},
+			"admin": {
+				auth.PermQuery: {"orders", "secrets"},
+			},
+		},
+	}
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, c)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		password string
+		exp      int
+	}{
+		{"password", http.StatusOK},
+		{"wrong", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT+*+FROM+secrets", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth("admin", tt.password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("wrong status code with password %s, exp %d, got %d", tt.password, tt.exp, resp.StatusCode)
+		}
+	}
+}
+
+func Test_BackupOK(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		return nil
 	}
 
 	client := &http.Client{}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/db/backup?noleader")
+	resp, err := client.Get(host + "/db/backup")
 	if err != nil {
 		t.Fatalf("failed to make backup request")
 	}
@@ -638,38 +1095,98 @@ func Test_BackupFlagsNoLeaderOK(t *testing.T) {
 	}
 }
 
-func Test_BackupFlagsInvalid(t *testing.T) {
+func Test_BackupCompression(t *testing.T) {
 	m := &MockStore{}
-	c := &mockClusterService{
-		apiAddr: "http://1.2.3.4:999",
-	}
-
+	c := &mockClusterService{}
 	s := New("127.0.0.1:0", m, c, nil)
-
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
+	backup := []byte("SQLite format 3\x00 some backup data")
 	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
-		if br.Vacuum && br.Format == command.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
-			return store.ErrInvalidVacuum
+		if br.Compress {
+			gw := gzip.NewWriter(dst)
+			if _, err := gw.Write(backup); err != nil {
+				return err
+			}
+			return gw.Close()
 		}
-		return nil
+		_, err := dst.Write(backup)
+		return err
 	}
 
-	client := &http.Client{}
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/db/backup?fmt=sql&vacuum")
+	getBackup := func(params string) ([]byte, string) {
+		resp, err := client.Get(host + "/db/backup" + params)
+		if err != nil {
+			t.Fatalf("failed to make backup request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read backup: %s", err.Error())
+		}
+		return b, resp.Header.Get("Content-Encoding")
+	}
+
+	b, enc := getBackup("?compress=zstd")
+	if enc != "zstd" {
+		t.Fatalf("wrong Content-Encoding, exp zstd, got %s", enc)
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(b))
 	if err != nil {
-		t.Fatalf("failed to make backup request")
+		t.Fatalf("failed to create zstd reader: %s", err.Error())
+	}
+	defer zr.Close()
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress zstd backup: %s", err.Error())
+	}
+	if !bytes.Equal(got, backup) {
+		t.Fatalf("zstd backup is wrong, exp %q, got %q", backup, got)
+	}
+
+	b, enc = getBackup("?compress=gzip")
+	if enc != "gzip" {
+		t.Fatalf("wrong Content-Encoding, exp gzip, got %s", enc)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %s", err.Error())
 	}
+	got, err = io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decompress gzip backup: %s", err.Error())
+	}
+	if !bytes.Equal(got, backup) {
+		t.Fatalf("gzip backup is wrong, exp %q, got %q", backup, got)
+	}
+
+	b, enc = getBackup("?compress=none")
+	if enc != "" {
+		t.Fatalf("unexpected Content-Encoding for uncompressed backup: %s", enc)
+	}
+	if !bytes.Equal(b, backup) {
+		t.Fatalf("uncompressed backup is wrong, exp %q, got %q", backup, b)
+	}
+
+	resp, err := client.Get(host + "/db/backup?compress=lz4")
+	if err != nil {
+		t.Fatalf("failed to make backup request: %s", err.Error())
+	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusBadRequest {
-		t.Fatalf("failed to get expected StatusBadRequest for backup, got %d", resp.StatusCode)
+		t.Fatalf("failed to get expected StatusBadRequest for invalid compression, got %d", resp.StatusCode)
 	}
 }
 
-func Test_LoadOK(t *testing.T) {
+func Test_FileOK(t *testing.T) {
 	m := &MockStore{}
 	c := &mockClusterService{}
 	s := New("127.0.0.1:0", m, c, nil)
@@ -678,86 +1195,79 @@ func Test_LoadOK(t *testing.T) {
 	}
 	defer s.Close()
 
+	m.copyFileFn = func(w io.Writer) error {
+		b, err := os.ReadFile("testdata/load.db")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
 	client := &http.Client{}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Post(host+"/db/load", "application/octet-stream", strings.NewReader("SELECT"))
+	resp, err := client.Get(host + "/db/file")
 	if err != nil {
-		t.Fatalf("failed to make load request")
+		t.Fatalf("failed to make file request: %s", err.Error())
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for load, got %d", resp.StatusCode)
+		t.Fatalf("failed to get expected StatusOK for file, got %d", resp.StatusCode)
 	}
-	body, err := io.ReadAll(resp.Body)
+
+	f, err := os.CreateTemp(t.TempDir(), "rqlite-file-")
 	if err != nil {
-		t.Fatalf("failed to read response body: %s", err.Error())
+		t.Fatalf("failed to create temp file: %s", err.Error())
 	}
-	if exp, got := `{"results":[]}`, string(body); exp != got {
-		t.Fatalf("incorrect response body, exp: %s, got %s", exp, got)
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		t.Fatalf("failed to download file: %s", err.Error())
 	}
-}
-
-func Test_LoadFlagsNoLeader(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:1234",
+	if !db.IsValidSQLiteFile(f.Name()) {
+		t.Fatalf("downloaded file is not a valid SQLite file")
 	}
-	c := &mockClusterService{
-		apiAddr: "http://1.2.3.4:999",
+	if ok, err := db.CheckIntegrity(f.Name(), true); err != nil || !ok {
+		t.Fatalf("downloaded file failed integrity check: %v", err)
 	}
+}
 
+func Test_BackupVacuumOK(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
 	s := New("127.0.0.1:0", m, c, nil)
-
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	testData, err := os.ReadFile("testdata/load.db")
-	if err != nil {
-		t.Fatalf("failed to load test SQLite data")
-	}
-
-	m.loadFn = func(lr *command.LoadRequest) error {
-		return store.ErrNotLeader
-	}
-
-	clusterLoadCalled := false
-	c.loadFn = func(lc *command.LoadRequest, nodeAddr string, timeout time.Duration) error {
-		clusterLoadCalled = true
-		if !bytes.Equal(lc.Data, testData) {
-			t.Fatalf("wrong data passed to cluster load")
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		if !br.Vacuum {
+			t.Fatal("expected vacuum to be true")
 		}
 		return nil
 	}
 
 	client := &http.Client{}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData))
+
+	resp, err := client.Get(host + "/db/backup?vacuum")
 	if err != nil {
-		t.Fatalf("failed to make load request")
+		t.Fatalf("failed to make backup request")
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for load, got %d", resp.StatusCode)
-	}
-
-	if !clusterLoadCalled {
-		t.Fatalf("cluster load was not called")
+		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
 	}
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err = client.Get(host + "/db/backup?vacuum&fmt=binary")
 	if err != nil {
-		t.Fatalf("failed to read response body: %s", err.Error())
+		t.Fatalf("failed to make backup request")
 	}
-	if exp, got := `{"results":[]}`, string(body); exp != got {
-		t.Fatalf("incorrect response body, exp: %s, got %s", exp, got)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
 	}
 }
 
-func Test_LoadRemoteError(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:1234",
-	}
+func Test_BackupFlagsNoLeaderRedirect(t *testing.T) {
+	m := &MockStore{}
 	c := &mockClusterService{
 		apiAddr: "http://1.2.3.4:999",
 	}
@@ -769,355 +1279,434 @@ func Test_LoadRemoteError(t *testing.T) {
 	}
 	defer s.Close()
 
-	testData, err := os.ReadFile("testdata/load.db")
-	if err != nil {
-		t.Fatalf("failed to load test SQLite data")
-	}
-
-	m.loadFn = func(br *command.LoadRequest) error {
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
 		return store.ErrNotLeader
 	}
-	clusterLoadCalled := false
-	c.loadFn = func(lr *command.LoadRequest, addr string, t time.Duration) error {
-		clusterLoadCalled = true
-		return fmt.Errorf("the load failed")
-	}
 
 	client := &http.Client{}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData))
-	if err != nil {
-		t.Fatalf("failed to make load request")
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusInternalServerError {
-		t.Fatalf("failed to get expected StatusInternalServerError for load, got %d", resp.StatusCode)
-	}
-
-	if !clusterLoadCalled {
-		t.Fatalf("cluster load was not called")
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/backup?redirect")
 	if err != nil {
-		t.Fatalf("failed to read response body: %s", err.Error())
+		t.Fatalf("failed to make backup request: %s", err.Error())
 	}
-	if exp, got := "the load failed\n", string(body); exp != got {
-		t.Fatalf(`incorrect response body, exp: "%s", got: "%s"`, exp, got)
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for backup, got %d", resp.StatusCode)
+	}
+	if exp, got := "http://1.2.3.4:999", resp.Header.Get(LeaderHTTPHeader); exp != got {
+		t.Fatalf("wrong leader header, exp %s, got %s", exp, got)
 	}
 }
 
-func Test_Boot(t *testing.T) {
+func Test_LeaderHeader(t *testing.T) {
 	m := &MockStore{
 		leaderAddr: "foo:1234",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return nil, store.ErrNotLeader
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, store.ErrNotLeader
+		},
 	}
 	c := &mockClusterService{
 		apiAddr: "http://1.2.3.4:999",
+		executeFn: func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+		},
+		queryFn: func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
 	}
-
 	s := New("127.0.0.1:0", m, c, nil)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	testData, err := os.ReadFile("testdata/load.db")
-	if err != nil {
-		t.Fatalf("failed to load test SQLite data")
+	client := &http.Client{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
-
-	readFromCalled := false
-	m.readFromFn = func(r io.Reader) (int64, error) {
-		// read all data from r and compare to the data in testData
-		b, err := io.ReadAll(r)
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/db/execute", http.StatusOK},
+		{"/db/execute?redirect", http.StatusMovedPermanently},
+		{"/db/query?level=strong", http.StatusOK},
+		{"/db/query?level=strong&redirect", http.StatusMovedPermanently},
+	} {
+		resp, err := client.Post(host+tt.path, "application/json", strings.NewReader(`["SELECT * FROM foo"]`))
 		if err != nil {
-			return 0, err
+			t.Fatalf("failed to make request to %s: %s", tt.path, err.Error())
 		}
-		if !bytes.Equal(b, testData) {
-			t.Fatalf("wrong data passed to ReadFrom")
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status for %s, exp %d, got %d", tt.path, tt.code, resp.StatusCode)
+		}
+		if exp, got := "http://1.2.3.4:999", resp.Header.Get(LeaderHTTPHeader); exp != got {
+			t.Fatalf("wrong leader header for %s, exp %s, got %s", tt.path, exp, got)
 		}
-		readFromCalled = true
-		return int64(len(b)), nil
 	}
 
-	client := &http.Client{}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Post(host+"/boot", "application/octet-stream", bytes.NewReader(testData))
-	if err != nil {
-		t.Fatalf("failed to make boot request")
+	// No header is set when this node is the Leader.
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return []*command.ExecuteResult{{RowsAffected: 1}}, nil
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for boot, got %d", resp.StatusCode)
+	resp, err := client.Post(host+"/db/execute", "application/json", strings.NewReader(`["SELECT * FROM foo"]`))
+	if err != nil {
+		t.Fatalf("failed to make execute request: %s", err.Error())
 	}
-
-	if !readFromCalled {
-		t.Fatalf("ReadFrom was not called")
+	resp.Body.Close()
+	if got := resp.Header.Get(LeaderHTTPHeader); got != "" {
+		t.Fatalf("leader header set on Leader, got %s", got)
 	}
 }
 
-func Test_RegisterStatus(t *testing.T) {
-	var stats *mockStatusReporter
+func Test_ExecuteStopOnError(t *testing.T) {
 	m := &MockStore{}
-	c := &mockClusterService{}
-
-	s := New("127.0.0.1:0", m, c, nil)
-
-	if err := s.RegisterStatus("foo", stats); err != nil {
-		t.Fatalf("failed to register statusReporter: %s", err.Error())
+	var stopOnError bool
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		stopOnError = er.Request.StopOnError
+		return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+	}
+	m.requestFn = func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		stopOnError = eqr.Request.StopOnError
+		return nil, nil
 	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	if err := s.RegisterStatus("foo", stats); err == nil {
-		t.Fatal("successfully re-registered statusReporter")
+	for _, tt := range []struct {
+		path string
+		exp  bool
+	}{
+		{"/db/execute", false},
+		{"/db/execute?stop_on_error", true},
+		{"/db/request", false},
+		{"/db/request?stop_on_error", true},
+	} {
+		stopOnError = !tt.exp
+		resp, err := http.Post(host+tt.path, "application/json", strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+		if err != nil {
+			t.Fatalf("failed to make request to %s: %s", tt.path, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status for %s, exp %d, got %d", tt.path, http.StatusOK, resp.StatusCode)
+		}
+		if stopOnError != tt.exp {
+			t.Fatalf("wrong stop on error for %s, exp %t, got %t", tt.path, tt.exp, stopOnError)
+		}
 	}
 }
 
-func Test_FormRedirect(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:4002",
+func Test_ExecuteRedactParameters(t *testing.T) {
+	m := &MockStore{}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return []*command.ExecuteResult{{Error: "CHECK constraint failed for 'hunter2'"}}, nil
 	}
-	c := &mockClusterService{
-		apiAddr: "http://foo:4001",
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
 	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	s := New("127.0.0.1:0", m, c, nil)
-	req := mustNewHTTPRequest("http://qux:4001")
-
-	rd, err := s.FormRedirect(req)
-	if err != nil {
-		t.Fatalf("failed to form redirect: %s", err.Error())
-	}
-	if exp, got := "http://foo:4001", rd; exp != got {
-		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	body := `[["INSERT INTO foo(password) VALUES(?)", "hunter2"]]`
+	for _, redact := range []bool{false, true} {
+		s.RedactParameters = redact
+		resp, err := http.Post(host+"/db/execute", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response: %s", err.Error())
+		}
+		if exp, got := !redact, strings.Contains(string(b), "hunter2"); exp != got {
+			t.Fatalf("wrong parameter presence in response with redaction %t: %s", redact, b)
+		}
+		if redact && !strings.Contains(string(b), "CHECK constraint failed for '[REDACTED]'") {
+			t.Fatalf("redacted error missing from response: %s", b)
+		}
 	}
 }
 
-func Test_FormRedirectParam(t *testing.T) {
+func Test_RequestID(t *testing.T) {
+	var storeID, leaderID string
 	m := &MockStore{
-		leaderAddr: "foo:4002",
+		leaderAddr: "foo:1234",
+	}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		storeID = er.Request.RequestId
+		return nil, store.ErrNotLeader
 	}
 	c := &mockClusterService{
-		apiAddr: "http://foo:4001",
+		executeFn: func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error) {
+			leaderID = er.Request.RequestId
+			return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+		},
 	}
 	s := New("127.0.0.1:0", m, c, nil)
-	req := mustNewHTTPRequest("http://qux:4001/db/query?x=y")
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	rd, err := s.FormRedirect(req)
+	// A client-supplied ID is echoed, and passed to the store and the Leader.
+	req, err := http.NewRequest("POST", host+"/db/execute", strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
 	if err != nil {
-		t.Fatalf("failed to form redirect: %s", err.Error())
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set(RequestIDHTTPHeader, "client-id-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get(RequestIDHTTPHeader); got != "client-id-1" {
+		t.Fatalf("client request ID not echoed, got %s", got)
+	}
+	if storeID != "client-id-1" || leaderID != "client-id-1" {
+		t.Fatalf("client request ID not propagated, store got %s, Leader got %s", storeID, leaderID)
 	}
 
-	if exp, got := "http://foo:4001/db/query?x=y", rd; rd != got {
-		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	// Otherwise an ID is generated.
+	resp, err = http.Get(host + "/status")
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(RequestIDHTTPHeader); !validRequestID(id) || id == "client-id-1" {
+		t.Fatalf("request ID not generated, got %s", id)
 	}
 }
 
-func Test_FormRedirectHTTPS(t *testing.T) {
+func Test_Tracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp, prop := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(prop)
+	}()
+
 	m := &MockStore{
-		leaderAddr: "foo:4002",
+		leaderAddr: "foo:1234",
+	}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return nil, store.ErrNotLeader
 	}
 	c := &mockClusterService{
-		apiAddr: "https://foo:4001",
+		executeFn: func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+		},
 	}
-
 	s := New("127.0.0.1:0", m, c, nil)
-	req := mustNewHTTPRequest("http://qux:4001")
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	rd, err := s.FormRedirect(req)
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, err := http.NewRequest("POST", host+"/db/execute", strings.NewReader(`["INSERT INTO foo VALUES(1)", "INSERT INTO foo VALUES(2)"]`))
 	if err != nil {
-		t.Fatalf("failed to form redirect: %s", err.Error())
+		t.Fatalf("failed to create request: %s", err.Error())
 	}
-	if exp, got := "https://foo:4001", rd; exp != got {
-		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status, exp %d, got %d", http.StatusOK, resp.StatusCode)
 	}
-}
 
-func Test_DoRedirect(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:4002",
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, sp := range sr.Ended() {
+		if got := sp.SpanContext().TraceID().String(); got != traceID {
+			t.Fatalf("span %s not part of incoming trace, got trace ID %s", sp.Name(), got)
+		}
+		spans[sp.Name()] = sp
 	}
-	c := &mockClusterService{
-		apiAddr: "https://foo:4001",
+	attrs := func(sp sdktrace.ReadOnlySpan) map[string]string {
+		m := make(map[string]string)
+		for _, kv := range sp.Attributes() {
+			m[string(kv.Key)] = kv.Value.Emit()
+		}
+		return m
 	}
-	s := New("127.0.0.1:0", m, c, nil)
-	req := mustNewHTTPRequest("http://qux:4001")
-	qp := mustGetQueryParams(req)
 
-	if s.DoRedirect(nil, req, qp) {
-		t.Fatalf("incorrectly redirected")
+	root, ok := spans["POST /db/execute"]
+	if !ok {
+		t.Fatalf("no span for request, got %v", spans)
 	}
-
-	req = mustNewHTTPRequest("http://qux:4001/db/query?redirect")
-	qp = mustGetQueryParams(req)
-	w := httptest.NewRecorder()
-	if !s.DoRedirect(w, req, qp) {
-		t.Fatalf("incorrectly not redirected")
+	if a := attrs(root); a["rqlite.forwarded"] != "true" || a["http.response.status_code"] != "200" ||
+		a["rqlite.request_id"] != resp.Header.Get(RequestIDHTTPHeader) {
+		t.Fatalf("wrong attributes for request span: %v", a)
 	}
-	if exp, got := http.StatusMovedPermanently, w.Code; exp != got {
-		t.Fatalf("incorrect redirect code, exp: %d, got: %d", exp, got)
+	for _, name := range []string{"store.Execute", "forward.Execute"} {
+		sp, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span, got %v", name, spans)
+		}
+		if sp.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatalf("%s span not a child of request span", name)
+		}
+		if a := attrs(sp); a["rqlite.statements"] != "2" {
+			t.Fatalf("wrong attributes for %s span: %v", name, a)
+		}
 	}
-	// check location header
-	if exp, got := "https://foo:4001/db/query?redirect", w.Header().Get("Location"); exp != got {
-		t.Fatalf("incorrect redirect location, exp: %s, got: %s", exp, got)
+	if a := attrs(spans["forward.Execute"]); a["rqlite.leader"] != "foo:1234" {
+		t.Fatalf("wrong attributes for forward span: %v", a)
 	}
 }
 
-func Test_Nodes(t *testing.T) {
+func Test_Vacuum(t *testing.T) {
 	m := &MockStore{
-		leaderAddr: "foo:1234",
+		vacuumFn: func() (*db.FileStats, *db.FileStats, error) {
+			return &db.FileStats{FileSize: 8192, WALSize: 4096, PageSize: 4096, PageCount: 2, FreelistCount: 1},
+				&db.FileStats{FileSize: 4096, PageSize: 4096, PageCount: 1}, nil
+		},
 	}
 	c := &mockClusterService{
-		apiAddr: "https://bar:5678",
+		apiAddr: "http://1.2.3.4:999",
 	}
 	s := New("127.0.0.1:0", m, c, nil)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
-
-	client := &http.Client{}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/nodes")
-	if err != nil {
-		t.Fatalf("failed to make nodes request")
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for nodes, got %d", resp.StatusCode)
-	}
-}
-
-func Test_RootRedirectToStatus(t *testing.T) {
-	m := &MockStore{}
-	c := &mockClusterService{}
-	s := New("127.0.0.1:0", m, c, nil)
-	if err := s.Start(); err != nil {
-		t.Fatalf("failed to start service")
-	}
-	defer s.Close()
 
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
-	resp, err := client.Get(fmt.Sprintf("http://%s/", s.Addr().String()))
+	resp, err := client.Post(host+"/db/vacuum", "", nil)
 	if err != nil {
-		t.Fatalf("failed to make root request")
+		t.Fatalf("failed to make request: %s", err.Error())
 	}
-	if resp.StatusCode != http.StatusFound {
-		t.Fatalf("failed to get expected StatusFound for root, got %d", resp.StatusCode)
+	var v struct {
+		Before    *db.FileStats `json:"before"`
+		After     *db.FileStats `json:"after"`
+		Reclaimed int64         `json:"reclaimed"`
 	}
-	if resp.Header["Location"][0] != "/status" {
-		t.Fatalf("received incorrect redirect path")
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	if err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if v.Before.FreelistCount != 1 || v.After.PageCount != 1 {
+		t.Fatalf("wrong stats, before %+v, after %+v", v.Before, v.After)
+	}
+	if exp, got := int64(8192), v.Reclaimed; exp != got {
+		t.Fatalf("wrong space reclaimed, exp %d, got %d", exp, got)
 	}
 
-	resp, err = client.Get(fmt.Sprintf("http://%s", s.Addr().String()))
+	// A node which is not the Leader redirects to it.
+	m.vacuumFn = func() (*db.FileStats, *db.FileStats, error) {
+		return nil, nil, store.ErrNotLeader
+	}
+	resp, err = client.Post(host+"/db/vacuum", "", nil)
 	if err != nil {
-		t.Fatalf("failed to make root request")
+		t.Fatalf("failed to make request: %s", err.Error())
 	}
-	if resp.StatusCode != http.StatusFound {
-		t.Fatalf("failed to get expected StatusFound for root, got %d", resp.StatusCode)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently, got %d", resp.StatusCode)
 	}
-	if resp.Header["Location"][0] != "/status" {
-		t.Fatalf("received incorrect redirect path")
+	if exp, got := "http://1.2.3.4:999/db/vacuum", resp.Header.Get("Location"); exp != got {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, got)
 	}
 }
 
-func Test_Readyz(t *testing.T) {
+func Test_BackupFlagsNoLeaderRemoteFetch(t *testing.T) {
 	m := &MockStore{
 		leaderAddr: "foo:1234",
 	}
 	c := &mockClusterService{
-		apiAddr: "https://bar:5678",
+		apiAddr: "http://1.2.3.4:999",
 	}
+
 	s := New("127.0.0.1:0", m, c, nil)
+
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	client := &http.Client{}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
-	resp, err := client.Get(host + "/readyz")
-	if err != nil {
-		t.Fatalf("failed to make readyz request")
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for node, got %d", resp.StatusCode)
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		return store.ErrNotLeader
 	}
 
-	m.notReady = true
-	m.committedFn = func(timeout time.Duration) (uint64, error) {
-		t.Fatal("committedFn should not have been called")
-		return 0, nil
-	}
-	resp, err = client.Get(host + "/readyz")
-	if err != nil {
-		t.Fatalf("failed to make readyz request")
-	}
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d", resp.StatusCode)
+	backupData := "this is SQLite data"
+	c.backupFn = func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error {
+		w.Write([]byte(backupData))
+		return nil
 	}
 
-	cnt := &atomic.Uint32{}
-	m.notReady = false
-	m.committedFn = func(timeout time.Duration) (uint64, error) {
-		cnt.Store(1)
-		return 0, fmt.Errorf("timeout")
+	client := &http.Client{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
-	resp, err = client.Get(host + "/readyz?sync")
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/backup")
 	if err != nil {
-		t.Fatalf("failed to make readyz request with sync set")
-	}
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d", resp.StatusCode)
+		t.Fatalf("failed to make backup request: %s", err.Error())
 	}
-	if cnt.Load() != 1 {
-		t.Fatalf("failed to call committedFn")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for remote backup fetch, got %d", resp.StatusCode)
 	}
-	m.notReady = false
-	m.committedFn = func(timeout time.Duration) (uint64, error) {
-		cnt.Store(2)
-		return 0, nil
+	if exp, got := "http://1.2.3.4:999", resp.Header.Get(LeaderHTTPHeader); exp != got {
+		t.Fatalf("wrong leader header, exp %s, got %s", exp, got)
 	}
-	resp, err = client.Get(host + "/readyz?sync")
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		t.Fatalf("failed to make readyz request with sync set")
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+		t.Fatalf("failed to read response body: %s", err.Error())
 	}
-	if cnt.Load() != 2 {
-		t.Fatalf("failed to call committedFn")
+	if exp, got := backupData, string(body); exp != got {
+		t.Fatalf("received incorrect backup data, exp: %s, got: %s", exp, got)
 	}
 }
 
-func Test_ForwardingRedirectQuery(t *testing.T) {
+func Test_Snapshot(t *testing.T) {
 	m := &MockStore{
-		leaderAddr: "foo:1234",
+		leaderAddr:  "foo:1234",
+		snapshotIdx: 42,
 	}
-	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
-		return nil, store.ErrNotLeader
-	}
-
 	c := &mockClusterService{
-		apiAddr: "https://bar:5678",
-	}
-	c.queryFn = func(qr *command.QueryRequest, addr string, timeout time.Duration) ([]*command.QueryRows, error) {
-		rows := &command.QueryRows{
-			Columns: []string{},
-			Types:   []string{},
-		}
-		return []*command.QueryRows{rows}, nil
+		apiAddr: "http://1.2.3.4:999",
 	}
-
 	s := New("127.0.0.1:0", m, c, nil)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	// Check queries.
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -1125,484 +1714,6082 @@ func Test_ForwardingRedirectQuery(t *testing.T) {
 	}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	resp, err := client.Get(host + "/db/query?pretty&timings&q=SELECT%20%2A%20FROM%20foo")
-	if err != nil {
-		t.Fatalf("failed to make query request")
+	snapshot := func() (int, string) {
+		resp, err := client.Post(host+"/snapshot", "", nil)
+		if err != nil {
+			t.Fatalf("failed to make snapshot request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusMovedPermanently {
+			return resp.StatusCode, resp.Header.Get("location")
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(body)
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for nodes, got %d", resp.StatusCode)
+
+	for _, tt := range []struct {
+		err  error
+		code int
+		body string
+	}{
+		{nil, http.StatusOK, `{"index":42}`},
+		{raft.ErrNothingNewToSnapshot, http.StatusOK, `{"index":42}`},
+		{store.ErrSnapshotInProgress, http.StatusConflict, "snapshot in progress\n"},
+		{fmt.Errorf("disk full"), http.StatusInternalServerError, "disk full\n"},
+	} {
+		m.snapshotFn = func(n uint64) error {
+			return tt.err
+		}
+		code, body := snapshot()
+		if code != tt.code {
+			t.Fatalf("wrong status code for snapshot error %v, exp %d, got %d", tt.err, tt.code, code)
+		}
+		if body != tt.body {
+			t.Fatalf("wrong body for snapshot error %v, exp %s, got %s", tt.err, tt.body, body)
+		}
 	}
 
-	resp, err = client.Get(host + "/db/query?redirect&pretty&timings&q=SELECT%20%2A%20FROM%20foo")
-	if err != nil {
-		t.Fatalf("failed to make redirected query request: %s", err)
+	m.notLeader = true
+	m.snapshotFn = func(n uint64) error {
+		t.Fatalf("snapshot triggered on non-leader")
+		return nil
 	}
-	if resp.StatusCode != http.StatusMovedPermanently {
-		t.Fatalf("failed to get expected StatusMovedPermanently for query, got %d", resp.StatusCode)
-	}
-
-	// Check leader failure case.
-	m.leaderAddr = ""
-	resp, err = client.Get(host + "/db/query?pretty&timings&q=SELECT%20%2A%20FROM%20foo")
-	if err != nil {
-		t.Fatalf("failed to make query forwarded request")
+	code, location := snapshot()
+	if code != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected redirect from non-leader, got %d", code)
 	}
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	if exp := "http://1.2.3.4:999/snapshot"; location != exp {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, location)
 	}
 }
 
-func Test_ForwardingRedirectExecute(t *testing.T) {
+func Test_BackupCompressionNoLeader(t *testing.T) {
 	m := &MockStore{
 		leaderAddr: "foo:1234",
 	}
-	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
-		return nil, store.ErrNotLeader
-	}
-
 	c := &mockClusterService{
-		apiAddr: "https://bar:5678",
-	}
-	c.executeFn = func(er *command.ExecuteRequest, addr string, timeout time.Duration) ([]*command.ExecuteResult, error) {
-		result := &command.ExecuteResult{
-			LastInsertId: 1234,
-			RowsAffected: 5678,
-		}
-		return []*command.ExecuteResult{result}, nil
+		apiAddr: "http://1.2.3.4:999",
 	}
-
 	s := New("127.0.0.1:0", m, c, nil)
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	// Check executes.
+	backupData := "this is SQLite data"
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		if br.Leader {
+			return store.ErrNotLeader
+		}
+		if !br.Compress {
+			return fmt.Errorf("compression not requested of local backup")
+		}
+		_, err := dst.Write([]byte(backupData))
+		return err
+	}
+	c.backupFn = func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error {
+		if !br.Compress {
+			return fmt.Errorf("compression not requested of remote backup")
+		}
+		_, err := w.Write([]byte(backupData))
+		return err
+	}
+
 	client := &http.Client{
+		Transport: &http.Transport{DisableCompression: true},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	resp, err := client.Post(host+"/db/execute", "application/json", strings.NewReader(`["Some SQL"]`))
-	if err != nil {
-		t.Fatalf("failed to make execute request")
-	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for execute, got %d", resp.StatusCode)
+	for _, params := range []string{"?compress=gzip", "?compress=gzip&noleader"} {
+		resp, err := client.Get(host + "/db/backup" + params)
+		if err != nil {
+			t.Fatalf("failed to make backup request: %s", err.Error())
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for backup%s, got %d: %s", params, resp.StatusCode, body)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("wrong Content-Encoding for backup%s, exp gzip, got %s", params, enc)
+		}
+		if exp, got := backupData, string(body); exp != got {
+			t.Fatalf("received incorrect backup data, exp: %s, got: %s", exp, got)
+		}
 	}
 
-	resp, err = client.Post(host+"/db/execute?redirect", "application/json", strings.NewReader(`["Some SQL"]`))
+	resp, err := client.Get(host + "/db/backup?compress=gzip&redirect")
 	if err != nil {
-		t.Fatalf("failed to make redirected execute request: %s", err)
+		t.Fatalf("failed to make backup request: %s", err.Error())
 	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusMovedPermanently {
-		t.Fatalf("failed to get expected StatusMovedPermanently for execute, got %d", resp.StatusCode)
+		t.Fatalf("failed to get expected StatusMovedPermanently for backup, got %d", resp.StatusCode)
 	}
+	if exp, got := "http://1.2.3.4:999/db/backup?compress=gzip&redirect", resp.Header.Get("Location"); exp != got {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, got)
+	}
+}
 
-	// Check leader failure case.
-	m.leaderAddr = ""
-	resp, err = client.Post(host+"/db/execute", "application/json", strings.NewReader(`["Some SQL"]`))
-	if err != nil {
-		t.Fatalf("failed to make execute request")
+func Test_BackupFlagsNoLeaderOK(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
 	}
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+
+	s := New("127.0.0.1:0", m, c, nil)
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
 	}
-}
+	defer s.Close()
 
-func Test_ForwardingRedirectExecuteQuery(t *testing.T) {
-	m := &MockStore{
-		leaderAddr: "foo:1234",
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		if !br.Leader {
+			return nil
+		}
+		return store.ErrNotLeader
 	}
-	m.requestFn = func(er *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
-		return nil, store.ErrNotLeader
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/backup?noleader")
+	if err != nil {
+		t.Fatalf("failed to make backup request")
 	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for backup, got %d", resp.StatusCode)
+	}
+}
 
+func Test_BackupFlagsInvalid(t *testing.T) {
+	m := &MockStore{}
 	c := &mockClusterService{
-		apiAddr: "https://bar:5678",
-	}
-	c.requestFn = func(er *command.ExecuteQueryRequest, addr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error) {
-		resp := &command.ExecuteQueryResponse{
-			Result: &command.ExecuteQueryResponse_E{
-				E: &command.ExecuteResult{
-					LastInsertId: 1234,
-					RowsAffected: 5678,
-				},
-			},
-		}
-		return []*command.ExecuteQueryResponse{resp}, nil
+		apiAddr: "http://1.2.3.4:999",
 	}
 
 	s := New("127.0.0.1:0", m, c, nil)
+
 	if err := s.Start(); err != nil {
 		t.Fatalf("failed to start service")
 	}
 	defer s.Close()
 
-	// Check ExecuteQuery.
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		if br.Vacuum && br.Format == command.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
+			return store.ErrInvalidVacuum
+		}
+		return nil
 	}
-	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	resp, err := client.Post(host+"/db/request", "application/json", strings.NewReader(`["Some SQL"]`))
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/backup?fmt=sql&vacuum")
 	if err != nil {
-		t.Fatalf("failed to make ExecuteQuery request")
+		t.Fatalf("failed to make backup request")
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("failed to get expected StatusOK for ExecuteQuery, got %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for backup, got %d", resp.StatusCode)
 	}
+}
 
-	resp, err = client.Post(host+"/db/request?redirect", "application/json", strings.NewReader(`["Some SQL"]`))
+func Test_LoadOK(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/load", "application/octet-stream", strings.NewReader("SELECT"))
 	if err != nil {
-		t.Fatalf("failed to make redirected ExecuteQuery request: %s", err)
+		t.Fatalf("failed to make load request")
 	}
-	if resp.StatusCode != http.StatusMovedPermanently {
-		t.Fatalf("failed to get expected StatusMovedPermanently for execute, got %d", resp.StatusCode)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for load, got %d", resp.StatusCode)
 	}
-
-	// Check leader failure case.
-	m.leaderAddr = ""
-	resp, err = client.Post(host+"/db/request", "application/json", strings.NewReader(`["Some SQL"]`))
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		t.Fatalf("failed to make ExecuteQuery request")
+		t.Fatalf("failed to read response body: %s", err.Error())
 	}
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	if exp, got := `{"results":[]}`, string(body); exp != got {
+		t.Fatalf("incorrect response body, exp: %s, got %s", exp, got)
 	}
 }
 
-func Test_timeoutVersionPrettyQueryParam(t *testing.T) {
-	defStr := "10s"
-	def := mustParseDuration(defStr)
-	tests := []struct {
-		u        string
-		dur      string
-		ver      string
-		pretty   bool
-		parseErr bool
-	}{
-		{
-			u:      "http://localhost:4001/nodes?pretty&timeout=5s&ver=2",
-			dur:    "5s",
-			pretty: true,
-			ver:    "2",
-		},
-		{
-			u:   "http://localhost:4001/nodes?timeout=2m",
-			dur: "2m",
-		},
-		{
-			u:   "http://localhost:4001/nodes?x=777&timeout=5s",
-			dur: "5s",
-		},
-		{
-			u:   "http://localhost:4001/nodes",
-			dur: defStr,
-		},
-		{
-			u:   "http://localhost:4001/nodes?ver=666",
-			dur: defStr,
-			ver: "666",
-		},
-		{
-			u:      "http://localhost:4001/nodes?pretty&ver=666",
-			dur:    defStr,
-			pretty: true,
-			ver:    "666",
-		},
-		{
-			u:        "http://localhost:4001/nodes?timeout=zdfjkh",
-			parseErr: true,
-		},
-		{
-			u:        "http://localhost:4001/db/query?q=",
-			dur:      defStr,
-			parseErr: true,
-		},
+func Test_BackupValidate(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
 	}
+	defer s.Close()
 
-	for i, tt := range tests {
-		mustURLParse(tt.u) // Make sure it's OK.
-		req, err := http.NewRequest("GET", tt.u, nil)
-		if err != nil {
-			t.Fatalf("failed to create request: %s", err)
-		}
-		qp, err := NewQueryParams(req)
-		if err != nil {
-			if !tt.parseErr {
-				t.Fatalf(" unexpectedly failed to parse query params on test %d: %s", i, err)
-			}
-			continue
-		}
+	b, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to read test data: %s", err.Error())
+	}
 
-		if got, exp := qp.Timeout(def), mustParseDuration(tt.dur); got != exp {
-			t.Fatalf("got wrong timeout on test %d, expected %s, got %s", i, exp, got)
-		}
-		if got, exp := qp.Version(), tt.ver; got != exp {
-			t.Fatalf("got wrong version on test %d, expected %s, got %s", i, exp, got)
-		}
-		if got, exp := qp.Pretty(), tt.pretty; got != exp {
-			t.Fatalf("got wrong pretty on test %d, expected %t, got %t", i, exp, got)
-		}
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		name   string
+		data   []byte
+		format string
+		ok     bool
+	}{
+		{"valid binary", b, "binary", true},
+		{"truncated binary", b[:len(b)/2], "binary", false},
+		{"valid SQL", []byte("CREATE TABLE foo (id INTEGER PRIMARY KEY)"), "sql", true},
+		{"invalid SQL", []byte("CREATE TABLE foo (id INTEGER PRIMARY KEY"), "sql", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Post(host+"/db/backup/validate", "application/octet-stream", bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("failed to make validate request: %s", err.Error())
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("failed to get expected StatusOK for validate, got %d", resp.StatusCode)
+			}
+			var v struct {
+				OK     bool     `json:"ok"`
+				Format string   `json:"format"`
+				Errors []string `json:"errors"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+				t.Fatalf("failed to decode response: %s", err.Error())
+			}
+			if v.OK != tt.ok {
+				t.Fatalf("wrong validity, exp %t, got %t (errors: %v)", tt.ok, v.OK, v.Errors)
+			}
+			if v.Format != tt.format {
+				t.Fatalf("wrong format, exp %s, got %s", tt.format, v.Format)
+			}
+			if !v.OK && len(v.Errors) == 0 {
+				t.Fatalf("invalid data reported no errors")
+			}
+		})
 	}
 }
 
-func Test_DBTimeoutQueryParam(t *testing.T) {
-	tests := []struct {
-		name    string
+func Test_LoadFlagsNoLeader(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		notLeader:  true,
+	}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	s.LoadChunkSize = 1024
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	m.loadChunkFn = func(lcr *command.LoadChunkRequest) error {
+		t.Fatalf("chunk loaded into local store on non-leader")
+		return nil
+	}
+
+	// The file is streamed to the Leader in chunks, rather than buffered.
+	var chunks []*command.LoadChunkRequest
+	c.loadChunkFn = func(lcr *command.LoadChunkRequest, nodeAddr string, timeout time.Duration) error {
+		if nodeAddr != "foo:1234" {
+			t.Fatalf("chunk forwarded to wrong node: %s", nodeAddr)
+		}
+		chunks = append(chunks, lcr)
+		return nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData))
+	if err != nil {
+		t.Fatalf("failed to make load request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for load, got %d", resp.StatusCode)
+	}
+
+	if exp, got := (len(testData)+1023)/1024, len(chunks); got < exp {
+		t.Fatalf("too few chunks forwarded, exp at least %d, got %d", exp, got)
+	}
+	if !chunks[len(chunks)-1].IsLast {
+		t.Fatalf("last chunk not marked as last")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	if exp, got := `{"results":[]}`, string(body); exp != got {
+		t.Fatalf("incorrect response body, exp: %s, got %s", exp, got)
+	}
+}
+
+func Test_LoadChunked(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.LoadChunkSize = 1024
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	m.loadFn = func(lr *command.LoadRequest) error {
+		t.Fatalf("buffered load called for SQLite data posted to Leader")
+		return nil
+	}
+	var chunks []*command.LoadChunkRequest
+	m.loadChunkFn = func(lcr *command.LoadChunkRequest) error {
+		chunks = append(chunks, lcr)
+		return nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData))
+	if err != nil {
+		t.Fatalf("failed to make load request")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for load, got %d", resp.StatusCode)
+	}
+	if exp, got := (len(testData)+1023)/1024, len(chunks); got < exp {
+		t.Fatalf("too few chunks, exp at least %d, got %d", exp, got)
+	}
+	if !chunks[len(chunks)-1].IsLast {
+		t.Fatalf("last chunk not marked as last")
+	}
+	for _, c := range chunks {
+		if c.Abort {
+			t.Fatalf("chunked load aborted")
+		}
+	}
+
+	// A truncated file must be rejected, and the stream aborted.
+	chunks = nil
+	resp, err = client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData[:len(testData)-100]))
+	if err != nil {
+		t.Fatalf("failed to make load request")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for truncated load, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "truncated") {
+		t.Fatalf("truncation not reported, got %s", body)
+	}
+	if len(chunks) == 0 || !chunks[len(chunks)-1].Abort {
+		t.Fatalf("truncated load not aborted")
+	}
+	for _, c := range chunks {
+		if c.IsLast {
+			t.Fatalf("truncated load marked last chunk")
+		}
+	}
+}
+
+func Test_LoadGzip(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			t.Fatalf("failed to gzip data: %s", err.Error())
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatalf("failed to close gzip writer: %s", err.Error())
+		}
+		return buf.Bytes()
+	}
+	load := func(body []byte, encoding string) int {
+		req, err := http.NewRequest("POST", host+"/db/load", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make load request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	// Gzipped SQLite data, sniffed and sent with the header.
+	for _, encoding := range []string{"", "gzip"} {
+		var loaded bytes.Buffer
+		m.loadChunkFn = func(lcr *command.LoadChunkRequest) error {
+			if len(lcr.Data) == 0 {
+				return nil
+			}
+			gr, err := gzip.NewReader(bytes.NewReader(lcr.Data))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(&loaded, gr)
+			return err
+		}
+		if code := load(gzipped(testData), encoding); code != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for gzipped SQLite load, got %d", code)
+		}
+		if !bytes.Equal(loaded.Bytes(), testData) {
+			t.Fatalf("gzipped SQLite data not decompressed before load")
+		}
+	}
+
+	// Gzipped SQL text.
+	var executed string
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		executed = er.Request.Statements[0].Sql
+		return nil, nil
+	}
+	sql := "CREATE TABLE foo (id INTEGER PRIMARY KEY)"
+	if code := load(gzipped([]byte(sql)), ""); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for gzipped SQL load, got %d", code)
+	}
+	if executed != sql {
+		t.Fatalf("gzipped SQL not decompressed before load, got %s", executed)
+	}
+
+	// Data claimed to be gzipped, but which is not.
+	if code := load([]byte(sql), "gzip"); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for invalid gzip load, got %d", code)
+	}
+}
+
+func Test_MaxRequestBytes(t *testing.T) {
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
+		loadFn: func(lr *command.LoadRequest) error {
+			return nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.MaxRequestBytes = 100
+	s.MaxLoadBytes = 1000
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path, body string, chunked bool) int {
+		var r io.Reader = strings.NewReader(body)
+		if chunked {
+			// Hide the length, so the body is sent without a Content-Length.
+			r = io.MultiReader(r)
+		}
+		resp, err := http.Post(host+path, "application/json", r)
+		if err != nil {
+			t.Fatalf("failed to make request to %s: %s", path, err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	small := `["INSERT INTO foo VALUES(1)"]`
+	large := fmt.Sprintf(`["INSERT INTO foo VALUES('%s')"]`, strings.Repeat("x", 200))
+	for _, tt := range []struct {
+		path    string
+		body    string
+		chunked bool
+		exp     int
+	}{
+		{"/db/execute", small, false, http.StatusOK},
+		{"/db/execute", large, false, http.StatusRequestEntityTooLarge},
+		{"/db/execute", large, true, http.StatusRequestEntityTooLarge},
+		{"/db/query", small, false, http.StatusOK},
+		{"/db/query", large, true, http.StatusRequestEntityTooLarge},
+		{"/db/request", large, true, http.StatusRequestEntityTooLarge},
+		{"/db/load", strings.Repeat("SELECT 1;", 30), true, http.StatusOK},
+		{"/db/load", strings.Repeat("SELECT 1;", 300), true, http.StatusRequestEntityTooLarge},
+	} {
+		if got := post(tt.path, tt.body, tt.chunked); got != tt.exp {
+			t.Fatalf("wrong status for %s (chunked %t), exp %d, got %d", tt.path, tt.chunked, tt.exp, got)
+		}
+	}
+}
+
+func Test_MaxLoadBytesGzip(t *testing.T) {
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+		},
+		loadChunkFn: func(lcr *command.LoadChunkRequest) error {
+			return nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.MaxLoadBytes = 4096
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+	gz := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			t.Fatalf("failed to compress data: %s", err.Error())
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatalf("failed to close gzip writer: %s", err.Error())
+		}
+		return buf.Bytes()
+	}
+
+	// The limit applies to the data once decompressed, even though each
+	// compressed body is within it.
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		name string
+		body []byte
+		exp  int
+	}{
+		{"small dump", gz([]byte(strings.Repeat("SELECT 1;", 30))), http.StatusOK},
+		{"large dump", gz([]byte(strings.Repeat("SELECT 1;", 10000))), http.StatusRequestEntityTooLarge},
+		{"large SQLite file", gz(testData), http.StatusRequestEntityTooLarge},
+	} {
+		if len(tt.body) > int(s.MaxLoadBytes) {
+			t.Fatalf("test %s: compressed body larger than limit", tt.name)
+		}
+		resp, err := http.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("test %s: failed to make load request: %s", tt.name, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("test %s: wrong status, exp %d, got %d", tt.name, tt.exp, resp.StatusCode)
+		}
+	}
+}
+
+func Test_LoadRemoteError(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		notLeader:  true,
+	}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	clusterLoadCalled := false
+	c.loadChunkFn = func(lcr *command.LoadChunkRequest, addr string, t time.Duration) error {
+		clusterLoadCalled = true
+		if lcr.Abort {
+			return nil
+		}
+		return fmt.Errorf("the load failed")
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/load", "application/octet-stream", bytes.NewReader(testData))
+	if err != nil {
+		t.Fatalf("failed to make load request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("failed to get expected StatusInternalServerError for load, got %d", resp.StatusCode)
+	}
+
+	if !clusterLoadCalled {
+		t.Fatalf("cluster load was not called")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	if exp, got := "the load failed\n", string(body); exp != got {
+		t.Fatalf(`incorrect response body, exp: "%s", got: "%s"`, exp, got)
+	}
+}
+
+func Test_ReplicateTo(t *testing.T) {
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	var received []byte
+	var username, password string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/load" || r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		username, password, _ = r.BasicAuth()
+		received, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"results":[]}`))
+	}))
+	defer target.Close()
+	targetHost := strings.TrimPrefix(target.URL, "http://")
+
+	m := &MockStore{}
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		if br.Format != command.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY {
+			return fmt.Errorf("wrong backup format requested")
+		}
+		_, err := dst.Write(testData)
+		return err
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	replicate := func(body string) (int, map[string]interface{}) {
+		resp, err := http.Post(host+"/db/replicate-to", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make replicate request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err.Error())
+		}
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		return resp.StatusCode, m
+	}
+	reqBody := fmt.Sprintf(`{"url":"%s","username":"fiona","password":"secret"}`, target.URL)
+
+	// Targets must be explicitly allowed.
+	if code, _ := replicate(reqBody); code != http.StatusForbidden {
+		t.Fatalf("expected StatusForbidden for target not in allowlist, got %d", code)
+	}
+	s.ReplicateAllowlist = []string{targetHost}
+	for _, body := range []string{
+		`{"url":"ftp://` + targetHost + `"}`,
+		`{"url":"` + target.URL + `/db/execute"}`,
+		`{"url":"http://user:pass@` + targetHost + `"}`,
+		`not json`,
+	} {
+		if code, _ := replicate(body); code != http.StatusBadRequest {
+			t.Fatalf("expected StatusBadRequest for %s, got %d", body, code)
+		}
+	}
+
+	code, resp := replicate(reqBody)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for replicate, got %d: %v", code, resp)
+	}
+	if !bytes.Equal(received, testData) {
+		t.Fatalf("target received wrong data")
+	}
+	if username != "fiona" || password != "secret" {
+		t.Fatalf("target received wrong credentials: %s, %s", username, password)
+	}
+	if exp, got := float64(len(testData)), resp["bytes"]; exp != got {
+		t.Fatalf("wrong bytes reported, exp %v, got %v", exp, got)
+	}
+	if resp["target"] != targetHost {
+		t.Fatalf("wrong target reported, got %v", resp["target"])
+	}
+
+	// A failed backup must not be reported as success.
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		dst.Write(testData[:100])
+		return fmt.Errorf("backup failed")
+	}
+	code, resp = replicate(reqBody)
+	if code != http.StatusBadGateway {
+		t.Fatalf("expected StatusBadGateway for failed backup, got %d", code)
+	}
+	if resp["error"] == nil {
+		t.Fatalf("failed replication reported no error")
+	}
+
+	m.notLeader = true
+	if code, _ := replicate(reqBody); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected StatusServiceUnavailable on follower, got %d", code)
+	}
+}
+
+func Test_Boot(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	testData, err := os.ReadFile("testdata/load.db")
+	if err != nil {
+		t.Fatalf("failed to load test SQLite data")
+	}
+
+	readFromCalled := false
+	m.readFromFn = func(r io.Reader) (int64, error) {
+		// read all data from r and compare to the data in testData
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(b, testData) {
+			t.Fatalf("wrong data passed to ReadFrom")
+		}
+		readFromCalled = true
+		return int64(len(b)), nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/boot", "application/octet-stream", bytes.NewReader(testData))
+	if err != nil {
+		t.Fatalf("failed to make boot request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for boot, got %d", resp.StatusCode)
+	}
+
+	if !readFromCalled {
+		t.Fatalf("ReadFrom was not called")
+	}
+}
+
+func Test_RegisterStatus(t *testing.T) {
+	var stats *mockStatusReporter
+	m := &MockStore{}
+	c := &mockClusterService{}
+
+	s := New("127.0.0.1:0", m, c, nil)
+
+	if err := s.RegisterStatus("foo", stats); err != nil {
+		t.Fatalf("failed to register statusReporter: %s", err.Error())
+	}
+
+	if err := s.RegisterStatus("foo", stats); err == nil {
+		t.Fatal("successfully re-registered statusReporter")
+	}
+}
+
+func Test_FormRedirect(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:4002",
+	}
+	c := &mockClusterService{
+		apiAddr: "http://foo:4001",
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	req := mustNewHTTPRequest("http://qux:4001")
+
+	rd, err := s.FormRedirect(req)
+	if err != nil {
+		t.Fatalf("failed to form redirect: %s", err.Error())
+	}
+	if exp, got := "http://foo:4001", rd; exp != got {
+		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	}
+}
+
+func Test_FormRedirectParam(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:4002",
+	}
+	c := &mockClusterService{
+		apiAddr: "http://foo:4001",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	req := mustNewHTTPRequest("http://qux:4001/db/query?x=y")
+
+	rd, err := s.FormRedirect(req)
+	if err != nil {
+		t.Fatalf("failed to form redirect: %s", err.Error())
+	}
+
+	if exp, got := "http://foo:4001/db/query?x=y", rd; rd != got {
+		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	}
+}
+
+func Test_FormRedirectHTTPS(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:4002",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://foo:4001",
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	req := mustNewHTTPRequest("http://qux:4001")
+
+	rd, err := s.FormRedirect(req)
+	if err != nil {
+		t.Fatalf("failed to form redirect: %s", err.Error())
+	}
+	if exp, got := "https://foo:4001", rd; exp != got {
+		t.Fatalf("incorrect redirect, exp: %s, got: %s", exp, got)
+	}
+}
+
+func Test_DoRedirect(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:4002",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://foo:4001",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	req := mustNewHTTPRequest("http://qux:4001")
+	qp := mustGetQueryParams(req)
+
+	w := httptest.NewRecorder()
+	if s.DoRedirect(w, req, qp) {
+		t.Fatalf("incorrectly redirected")
+	}
+	if exp, got := "https://foo:4001", w.Header().Get(LeaderHTTPHeader); exp != got {
+		t.Fatalf("incorrect leader header, exp: %s, got: %s", exp, got)
+	}
+
+	req = mustNewHTTPRequest("http://qux:4001/db/query?redirect")
+	qp = mustGetQueryParams(req)
+	w = httptest.NewRecorder()
+	if !s.DoRedirect(w, req, qp) {
+		t.Fatalf("incorrectly not redirected")
+	}
+	if exp, got := http.StatusMovedPermanently, w.Code; exp != got {
+		t.Fatalf("incorrect redirect code, exp: %d, got: %d", exp, got)
+	}
+	// check location header
+	if exp, got := "https://foo:4001/db/query?redirect", w.Header().Get("Location"); exp != got {
+		t.Fatalf("incorrect redirect location, exp: %s, got: %s", exp, got)
+	}
+}
+
+func Test_Nodes(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/nodes")
+	if err != nil {
+		t.Fatalf("failed to make nodes request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for nodes, got %d", resp.StatusCode)
+	}
+}
+
+func Test_RootRedirectToStatus(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("http://%s/", s.Addr().String()))
+	if err != nil {
+		t.Fatalf("failed to make root request")
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("failed to get expected StatusFound for root, got %d", resp.StatusCode)
+	}
+	if resp.Header["Location"][0] != "/status" {
+		t.Fatalf("received incorrect redirect path")
+	}
+
+	resp, err = client.Get(fmt.Sprintf("http://%s", s.Addr().String()))
+	if err != nil {
+		t.Fatalf("failed to make root request")
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("failed to get expected StatusFound for root, got %d", resp.StatusCode)
+	}
+	if resp.Header["Location"][0] != "/status" {
+		t.Fatalf("received incorrect redirect path")
+	}
+}
+
+func Test_Readyz(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/readyz")
+	if err != nil {
+		t.Fatalf("failed to make readyz request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for node, got %d", resp.StatusCode)
+	}
+
+	m.notReady = true
+	m.committedFn = func(timeout time.Duration) (uint64, error) {
+		t.Fatal("committedFn should not have been called")
+		return 0, nil
+	}
+	resp, err = client.Get(host + "/readyz")
+	if err != nil {
+		t.Fatalf("failed to make readyz request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d", resp.StatusCode)
+	}
+
+	cnt := &atomic.Uint32{}
+	m.notReady = false
+	m.committedFn = func(timeout time.Duration) (uint64, error) {
+		cnt.Store(1)
+		return 0, fmt.Errorf("timeout")
+	}
+	resp, err = client.Get(host + "/readyz?sync")
+	if err != nil {
+		t.Fatalf("failed to make readyz request with sync set")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d", resp.StatusCode)
+	}
+	if cnt.Load() != 1 {
+		t.Fatalf("failed to call committedFn")
+	}
+	m.notReady = false
+	m.committedFn = func(timeout time.Duration) (uint64, error) {
+		cnt.Store(2)
+		return 0, nil
+	}
+	resp, err = client.Get(host + "/readyz?sync")
+	if err != nil {
+		t.Fatalf("failed to make readyz request with sync set")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	if cnt.Load() != 2 {
+		t.Fatalf("failed to call committedFn")
+	}
+}
+
+func Test_ReadyzHealthChecks(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	if err := s.RegisterHealthCheck("audit", func() error { return nil }); err != nil {
+		t.Fatalf("failed to register health check: %s", err.Error())
+	}
+	if err := s.RegisterHealthCheck("audit", func() error { return nil }); err == nil {
+		t.Fatalf("expected error registering duplicate health check")
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	readyz := func() (int, string) {
+		resp, err := client.Get(host + "/readyz")
+		if err != nil {
+			t.Fatalf("failed to make readyz request")
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read readyz response")
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := readyz()
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK with passing check, got %d", code)
+	}
+	if !strings.Contains(body, "[+]audit ok") {
+		t.Fatalf("passing check not reported, got %s", body)
+	}
+
+	if err := s.RegisterHealthCheck("sink", func() error { return fmt.Errorf("unreachable") }); err != nil {
+		t.Fatalf("failed to register health check: %s", err.Error())
+	}
+	code, body = readyz()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable with failing check, got %d", code)
+	}
+	if !strings.Contains(body, "[+]sink failed: unreachable") {
+		t.Fatalf("failing check not reported, got %s", body)
+	}
+	if !strings.Contains(body, "[+]audit ok") {
+		t.Fatalf("passing check not reported, got %s", body)
+	}
+}
+
+func Test_ForwardingRedirectQuery(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, store.ErrNotLeader
+	}
+
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	c.queryFn = func(qr *command.QueryRequest, addr string, timeout time.Duration) ([]*command.QueryRows, error) {
+		rows := &command.QueryRows{
+			Columns: []string{},
+			Types:   []string{},
+		}
+		return []*command.QueryRows{rows}, nil
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	// Check queries.
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp, err := client.Get(host + "/db/query?pretty&timings&q=SELECT%20%2A%20FROM%20foo")
+	if err != nil {
+		t.Fatalf("failed to make query request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for nodes, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(host + "/db/query?redirect&pretty&timings&q=SELECT%20%2A%20FROM%20foo")
+	if err != nil {
+		t.Fatalf("failed to make redirected query request: %s", err)
+	}
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently for query, got %d", resp.StatusCode)
+	}
+
+	// Check leader failure case.
+	m.leaderAddr = ""
+	resp, err = client.Get(host + "/db/query?pretty&timings&q=SELECT%20%2A%20FROM%20foo")
+	if err != nil {
+		t.Fatalf("failed to make query forwarded request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ForwardingRedirectExecute(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return nil, store.ErrNotLeader
+	}
+
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	c.executeFn = func(er *command.ExecuteRequest, addr string, timeout time.Duration) ([]*command.ExecuteResult, error) {
+		result := &command.ExecuteResult{
+			LastInsertId: 1234,
+			RowsAffected: 5678,
+		}
+		return []*command.ExecuteResult{result}, nil
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	// Check executes.
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp, err := client.Post(host+"/db/execute", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make execute request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for execute, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(host+"/db/execute?redirect", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make redirected execute request: %s", err)
+	}
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently for execute, got %d", resp.StatusCode)
+	}
+
+	// Check leader failure case.
+	m.leaderAddr = ""
+	resp, err = client.Post(host+"/db/execute", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make execute request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ForwardingRedirectExecuteQuery(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	m.requestFn = func(er *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		return nil, store.ErrNotLeader
+	}
+
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	c.requestFn = func(er *command.ExecuteQueryRequest, addr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error) {
+		resp := &command.ExecuteQueryResponse{
+			Result: &command.ExecuteQueryResponse_E{
+				E: &command.ExecuteResult{
+					LastInsertId: 1234,
+					RowsAffected: 5678,
+				},
+			},
+		}
+		return []*command.ExecuteQueryResponse{resp}, nil
+	}
+
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	// Check ExecuteQuery.
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp, err := client.Post(host+"/db/request", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make ExecuteQuery request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for ExecuteQuery, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(host+"/db/request?redirect", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make redirected ExecuteQuery request: %s", err)
+	}
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently for execute, got %d", resp.StatusCode)
+	}
+
+	// Check leader failure case.
+	m.leaderAddr = ""
+	resp, err = client.Post(host+"/db/request", "application/json", strings.NewReader(`["Some SQL"]`))
+	if err != nil {
+		t.Fatalf("failed to make ExecuteQuery request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	}
+}
+
+func Test_timeoutVersionPrettyQueryParam(t *testing.T) {
+	defStr := "10s"
+	def := mustParseDuration(defStr)
+	tests := []struct {
+		u        string
+		dur      string
+		ver      string
+		pretty   bool
+		parseErr bool
+	}{
+		{
+			u:      "http://localhost:4001/nodes?pretty&timeout=5s&ver=2",
+			dur:    "5s",
+			pretty: true,
+			ver:    "2",
+		},
+		{
+			u:   "http://localhost:4001/nodes?timeout=2m",
+			dur: "2m",
+		},
+		{
+			u:   "http://localhost:4001/nodes?x=777&timeout=5s",
+			dur: "5s",
+		},
+		{
+			u:   "http://localhost:4001/nodes",
+			dur: defStr,
+		},
+		{
+			u:   "http://localhost:4001/nodes?ver=666",
+			dur: defStr,
+			ver: "666",
+		},
+		{
+			u:      "http://localhost:4001/nodes?pretty&ver=666",
+			dur:    defStr,
+			pretty: true,
+			ver:    "666",
+		},
+		{
+			u:        "http://localhost:4001/nodes?timeout=zdfjkh",
+			parseErr: true,
+		},
+		{
+			u:        "http://localhost:4001/db/query?q=",
+			dur:      defStr,
+			parseErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		mustURLParse(tt.u) // Make sure it's OK.
+		req, err := http.NewRequest("GET", tt.u, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		qp, err := NewQueryParams(req)
+		if err != nil {
+			if !tt.parseErr {
+				t.Fatalf(" unexpectedly failed to parse query params on test %d: %s", i, err)
+			}
+			continue
+		}
+
+		if got, exp := qp.Timeout(def), mustParseDuration(tt.dur); got != exp {
+			t.Fatalf("got wrong timeout on test %d, expected %s, got %s", i, exp, got)
+		}
+		if got, exp := qp.Version(), tt.ver; got != exp {
+			t.Fatalf("got wrong version on test %d, expected %s, got %s", i, exp, got)
+		}
+		if got, exp := qp.Pretty(), tt.pretty; got != exp {
+			t.Fatalf("got wrong pretty on test %d, expected %t, got %t", i, exp, got)
+		}
+	}
+}
+
+func Test_DBTimeoutQueryParam(t *testing.T) {
+	tests := []struct {
+		name    string
 		url     string
 		want    time.Duration
 		wantErr bool
 	}{
-		{
-			name: "1s",
-			url:  "http://localhost:4001/execute?db_timeout=1s",
-			want: 1 * time.Second,
+		{
+			name: "1s",
+			url:  "http://localhost:4001/execute?db_timeout=1s",
+			want: 1 * time.Second,
+		},
+		{
+			name: "100ms",
+			url:  "http://localhost:4001/execute?db_timeout=100ms",
+			want: 100 * time.Millisecond,
+		},
+		{
+			name: "default value",
+			url:  "http://localhost:4001/execute",
+			want: 0,
+		},
+		{
+			name:    "parse error",
+			url:     "http://localhost:4001/execute?db_timeout=xyz",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustURLParse(tt.url)
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %s", err)
+			}
+			qp, err := NewQueryParams(req)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("failed to create request: %s", err)
+				}
+			}
+
+			got := qp.DBTimeout(0)
+			if got != tt.want {
+				t.Fatalf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_QueueInspect(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.DefaultQueueBatchSz = 100
+	s.DefaultQueueTimeout = time.Hour
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	client := &http.Client{}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post(host+"/db/execute?queue", "application/json",
+			strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+		if err != nil {
+			t.Fatalf("failed to make queued execute request: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for queued execute, got %d", resp.StatusCode)
+		}
+	}
+
+	resp, err := client.Get(host + "/db/queue")
+	if err != nil {
+		t.Fatalf("failed to make queue request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for queue, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	var q map[string]interface{}
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatalf("failed to unmarshal queue response: %s", err.Error())
+	}
+	if exp, got := float64(3), q["pending"]; exp != got {
+		t.Fatalf("wrong pending count, exp %v, got %v", exp, got)
+	}
+	if exp, got := float64(3), q["high_water_mark"]; exp != got {
+		t.Fatalf("wrong high-water mark, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_QueryMultiStatementString(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		rows := make([]*command.QueryRows, len(qr.Request.Statements))
+		for i, stmt := range qr.Request.Statements {
+			rows[i] = &command.QueryRows{
+				Columns: []string{stmt.Sql},
+				Types:   []string{"text"},
+			}
+		}
+		return rows, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   "SELECT 1; SELECT 2",
+			exp: `{"results":[{"columns":["SELECT 1"],"types":["text"]},{"columns":["SELECT 2"],"types":["text"]}]}`,
+		},
+		{
+			q:   "SELECT 'a;b'",
+			exp: `{"results":[{"columns":["SELECT 'a;b'"],"types":["text"]}]}`,
+		},
+	} {
+		resp, err := client.Get(host + "/db/query?q=" + url.QueryEscape(tt.q))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err.Error())
+		}
+		if got := string(body); tt.exp != got {
+			t.Fatalf("wrong response for %s, exp %s, got %s", tt.q, tt.exp, got)
+		}
+	}
+}
+
+func Test_QueryStrict(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.StrictQuery = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	queryCalled := false
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		queryCalled = true
+		return nil, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/query", "application/json",
+		strings.NewReader(`["SELECT * FROM foo", "UPDATE foo SET name='fiona'"]`))
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for write on query endpoint, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "use /db/execute") {
+		t.Fatalf("error message is not descriptive: %s", body)
+	}
+	if queryCalled {
+		t.Fatalf("store was queried despite rejected statement")
+	}
+
+	resp, err = client.Get(host + "/db/query?q=" + url.QueryEscape("SELECT * FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for read on query endpoint, got %d", resp.StatusCode)
+	}
+	if !queryCalled {
+		t.Fatalf("store was not queried for read")
+	}
+
+	// Every statement is checked, and those which cannot be parsed are
+	// rejected, except PRAGMAs which only read.
+	for _, tt := range []struct {
+		body string
+		exp  int
+	}{
+		{`["SELECT * FROM foo; DELETE FROM foo"]`, http.StatusBadRequest},
+		{`["VACUUM"]`, http.StatusBadRequest},
+		{`["PRAGMA foreign_keys=OFF"]`, http.StatusBadRequest},
+		{`["PRAGMA table_info(foo)"]`, http.StatusOK},
+	} {
+		resp, err := client.Post(host+"/db/query", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.body, tt.exp, resp.StatusCode)
+		}
+	}
+}
+
+func Test_QueryStrictClassifiedBySQLite(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE bar (id INTEGER PRIMARY KEY)`,
+	} {
+		if _, err := database.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to create table: %s", err.Error())
+		}
+	}
+
+	m := &MockStore{
+		readOnlyFn: database.StmtReadOnly,
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.StrictQuery = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		body string
+		exp  int
+	}{
+		{`["SELECT * FROM foo WHERE id IN (SELECT id FROM bar)"]`, http.StatusOK},
+		{`["SELECT * FROM main.foo"]`, http.StatusOK},
+		{`["SELECT (SELECT COUNT(*) FROM main.bar) FROM foo"]`, http.StatusOK},
+		{`["INSERT INTO main.foo SELECT id, NULL FROM bar"]`, http.StatusBadRequest},
+		{`["SELECT * FROM main.foo; DELETE FROM main.foo"]`, http.StatusBadRequest},
+		{`["BEGIN"]`, http.StatusBadRequest},
+	} {
+		resp, err := client.Post(host+"/db/query", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.body, tt.exp, resp.StatusCode)
+		}
+	}
+}
+
+func Test_Warmup(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.WarmupQueries = []string{"SELECT * FROM foo", "SELECT * FROM bar"}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var executed []string
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		if qr.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+			t.Fatalf("warmup queries not run against local database")
+		}
+		rows := make([]*command.QueryRows, len(qr.Request.Statements))
+		for i, stmt := range qr.Request.Statements {
+			executed = append(executed, stmt.Sql)
+			rows[i] = &command.QueryRows{}
+		}
+		return rows, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Post(host+"/db/warmup", "", nil)
+	if err != nil {
+		t.Fatalf("failed to make warmup request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for warmup, got %d", resp.StatusCode)
+	}
+	if !reflect.DeepEqual(executed, s.WarmupQueries) {
+		t.Fatalf("wrong queries executed, exp %v, got %v", s.WarmupQueries, executed)
+	}
+
+	var v map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if v["completed"] != true {
+		t.Fatalf("warmup did not report completion: %v", v)
+	}
+	if exp, got := float64(2), v["queries"]; exp != got {
+		t.Fatalf("wrong number of warmup queries reported, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_LogSampleRate(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	var buf bytes.Buffer
+	s.logger = log.New(&buf, "", 0)
+
+	s.LogSampleRate = 0
+	req := mustNewHTTPRequest("http://127.0.0.1/readyz?noleader")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if buf.Len() != 0 {
+		t.Fatalf("unforced request was logged with 0 sample rate: %s", buf.String())
+	}
+
+	for _, v := range []string{"0", "false", "yes"} {
+		req = mustNewHTTPRequest("http://127.0.0.1/readyz?noleader")
+		req.Header.Set(TraceHTTPHeader, v)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+		if buf.Len() != 0 {
+			t.Fatalf("request with trace header %q was logged: %s", v, buf.String())
+		}
+	}
+
+	req = mustNewHTTPRequest("http://127.0.0.1/readyz?noleader")
+	req.Header.Set(TraceHTTPHeader, "1")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "GET /readyz 200") {
+		t.Fatalf("forced request was not logged, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	s.LogSampleRate = 1
+	req = mustNewHTTPRequest("http://127.0.0.1/readyz?noleader")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "GET /readyz 200") {
+		t.Fatalf("request was not logged with sample rate of 1, got: %s", buf.String())
+	}
+}
+
+func Test_ClockSkew(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "leader:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "leader:4002", true),
+				store.NewServer("2", "follower1:4002", true),
+				store.NewServer("3", "follower2:4002", true),
+			}, nil
+		},
+	}
+	c := &mockClusterService{
+		nodeTimeFn: func(addr string) (time.Time, time.Duration, error) {
+			switch addr {
+			case "follower1:4002":
+				return time.Now().Add(10 * time.Second), 0, nil
+			case "follower2:4002":
+				return time.Now().Add(-5 * time.Second), 0, nil
+			}
+			return time.Now(), 0, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/cluster/clockskew")
+	if err != nil {
+		t.Fatalf("failed to make clockskew request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for clockskew, got %d", resp.StatusCode)
+	}
+
+	var v struct {
+		Leader string `json:"leader"`
+		Nodes  map[string]struct {
+			Addr  string  `json:"addr"`
+			Skew  float64 `json:"skew"`
+			Error string  `json:"error"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if v.Leader != "leader:4002" {
+		t.Fatalf("wrong leader, got %s", v.Leader)
+	}
+	if len(v.Nodes) != 3 {
+		t.Fatalf("wrong number of nodes, exp 3, got %d", len(v.Nodes))
+	}
+	for id, exp := range map[string]float64{"1": 0, "2": 10, "3": -5} {
+		n, ok := v.Nodes[id]
+		if !ok {
+			t.Fatalf("node %s missing from response", id)
+		}
+		if n.Error != "" {
+			t.Fatalf("unexpected error for node %s: %s", id, n.Error)
+		}
+		if n.Skew < exp-1 || n.Skew > exp+1 {
+			t.Fatalf("wrong skew for node %s, exp approx %f, got %f", id, exp, n.Skew)
+		}
+	}
+
+	// An unreachable follower is reported, but doesn't fail the request.
+	c.nodeTimeFn = func(addr string) (time.Time, time.Duration, error) {
+		if addr == "follower2:4002" {
+			return time.Time{}, 0, fmt.Errorf("unreachable")
+		}
+		return time.Now(), 0, nil
+	}
+	resp, err = client.Get(host + "/cluster/clockskew")
+	if err != nil {
+		t.Fatalf("failed to make clockskew request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for clockskew, got %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	if v.Nodes["3"].Error != "unreachable" {
+		t.Fatalf("expected error for unreachable node, got %s", v.Nodes["3"].Error)
+	}
+}
+
+func Test_QueryMaxRowsPerUser(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return perm != "" || password == "password"
+		},
+		maxRows: map[string]int64{
+			"low":  2,
+			"high": 100,
+		},
+	}
+	s := New("127.0.0.1:0", m, c, creds)
+	s.DefaultMaxRows = 3
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		rows := &command.QueryRows{
+			Columns: []string{"id"},
+			Types:   []string{"integer"},
+		}
+		for i := 0; i < 5; i++ {
+			rows.Values = append(rows.Values, &command.Values{
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_I{I: int64(i)}},
+				},
+			})
+		}
+		return []*command.QueryRows{rows}, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{}
+	for _, tt := range []struct {
+		username string
+		password string
+		exp      string
+	}{
+		{
+			username: "low",
+			exp:      `{"results":[{"columns":["id"],"types":["integer"],"values":[[0],[1]],"truncated":true}]}`,
+		},
+		{
+			username: "high",
+			exp:      `{"results":[{"columns":["id"],"types":["integer"],"values":[[0],[1],[2],[3],[4]]}]}`,
+		},
+		{
+			// Naming a user without their password gets the default.
+			username: "high",
+			password: "wrong",
+			exp:      `{"results":[{"columns":["id"],"types":["integer"],"values":[[0],[1],[2]],"truncated":true}]}`,
+		},
+		{
+			username: "other",
+			exp:      `{"results":[{"columns":["id"],"types":["integer"],"values":[[0],[1],[2]],"truncated":true}]}`,
+		},
+	} {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT%20*%20FROM%20foo", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		password := tt.password
+		if password == "" {
+			password = "password"
+		}
+		req.SetBasicAuth(tt.username, password)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", tt.username, resp.StatusCode)
+		}
+		if got := string(body); got != tt.exp {
+			t.Fatalf("wrong response for %s, exp %s, got %s", tt.username, tt.exp, got)
+		}
+	}
+}
+
+func Test_QueryLimitMode(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return perm != "" || password == "password"
+		},
+		queryLimit: map[string]string{
+			"appender": auth.QueryLimitAppend,
+			"trusted":  auth.QueryLimitOff,
+		},
+	}
+	s := New("127.0.0.1:0", m, c, creds)
+	s.QueryLimitMode = auth.QueryLimitReject
+	s.QueryLimitRows = 50
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var got string
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		got = qr.Request.Statements[0].Sql
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{}
+	for _, tt := range []struct {
+		username string
+		password string
+		query    string
+		code     int
+		exp      string
+	}{
+		{"other", "password", "SELECT * FROM foo", http.StatusBadRequest, ""},
+		{"other", "password", "SELECT * FROM foo LIMIT 5", http.StatusOK, "SELECT * FROM foo LIMIT 5"},
+		{"appender", "password", "SELECT * FROM foo", http.StatusOK, "SELECT * FROM foo LIMIT 50"},
+		{"trusted", "password", "SELECT * FROM foo", http.StatusOK, "SELECT * FROM foo"},
+		{"trusted", "wrong", "SELECT * FROM foo", http.StatusBadRequest, ""},
+	} {
+		got = ""
+		req, err := http.NewRequest("GET", host+"/db/query?q="+url.QueryEscape(tt.query), nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth(tt.username, tt.password)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s %s, exp %d, got %d", tt.username, tt.query, tt.code, resp.StatusCode)
+		}
+		if tt.code == http.StatusBadRequest && !strings.Contains(string(body), "LIMIT 50") {
+			t.Fatalf("rejection does not suggest a LIMIT: %s", body)
+		}
+		if got != tt.exp {
+			t.Fatalf("wrong statement queried for %s, exp %q, got %q", tt.username, tt.exp, got)
+		}
+	}
+}
+
+func Test_NodeCatchup(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "leader:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "leader:4002", true),
+				store.NewServer("2", "follower:4002", true),
+			}, nil
+		},
+	}
+	var followerIdx []uint64
+	var leaderCommit uint64
+	c := &mockClusterService{
+		nodeMetaFn: func(addr string) (*cluster.NodeMeta, error) {
+			if addr == "leader:4002" {
+				return &cluster.NodeMeta{CommitIndex: leaderCommit}, nil
+			}
+			idx := followerIdx[0]
+			followerIdx = followerIdx[1:]
+			return &cluster.NodeMeta{AppliedIndex: idx}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	getCatchup := func(id string) map[string]interface{} {
+		resp, err := client.Get(host + "/nodes/" + id + "/catchup?interval=100ms")
+		if err != nil {
+			t.Fatalf("failed to make catchup request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for catchup, got %d", resp.StatusCode)
+		}
+		var v map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("failed to decode response: %s", err.Error())
+		}
+		return v
+	}
+
+	// Follower applies 100 entries in 100ms, and is 1000 entries behind.
+	followerIdx = []uint64{100, 200}
+	leaderCommit = 1200
+	v := getCatchup("2")
+	if got := v["applied_index"].(float64); got != 200 {
+		t.Fatalf("wrong applied index, exp 200, got %f", got)
+	}
+	if got := v["leader_commit_index"].(float64); got != 1200 {
+		t.Fatalf("wrong leader commit index, exp 1200, got %f", got)
+	}
+	if got := v["lag"].(float64); got != 1000 {
+		t.Fatalf("wrong lag, exp 1000, got %f", got)
+	}
+	if got := v["apply_rate"].(float64); got <= 0 || got > 1000 {
+		t.Fatalf("wrong apply rate, got %f", got)
+	}
+	if got := v["eta"].(float64); got < 1 || got > 2 {
+		t.Fatalf("wrong ETA, exp approx 1, got %f", got)
+	}
+
+	// Follower is caught up.
+	followerIdx = []uint64{1200, 1200}
+	v = getCatchup("2")
+	if got := v["eta"].(float64); got != 0 {
+		t.Fatalf("wrong ETA for caught-up node, exp 0, got %f", got)
+	}
+
+	// Follower is behind, but making no progress.
+	followerIdx = []uint64{100, 100}
+	v = getCatchup("2")
+	if v["eta"] != nil {
+		t.Fatalf("expected null ETA for stalled node, got %v", v["eta"])
+	}
+
+	resp, err := client.Get(host + "/nodes/3/catchup")
+	if err != nil {
+		t.Fatalf("failed to make catchup request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("failed to get expected StatusNotFound for unknown node, got %d", resp.StatusCode)
+	}
+}
+
+func Test_InjectedParams(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return perm != "" || password == "password"
+		},
+		params: map[string]map[string]interface{}{
+			"tenant7": {"tenant_id": json.Number("7")},
+		},
+	}
+	s := New("127.0.0.1:0", m, c, creds)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var stmts []*command.Statement
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		stmts = qr.Request.Statements
+		return nil, nil
+	}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		stmts = er.Request.Statements
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{}
+	doPost := func(path, username, password, body string) {
+		req, err := http.NewRequest("POST", host+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth(username, password)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+		}
+	}
+	checkTenant := func(stmt *command.Statement, exp int64) {
+		if len(stmt.Parameters) != 1 {
+			t.Fatalf("expected 1 parameter, got %d", len(stmt.Parameters))
+		}
+		p := stmt.Parameters[0]
+		if p.Name != "tenant_id" || p.GetI() != exp {
+			t.Fatalf("wrong parameter, exp tenant_id=%d, got %s=%v", exp, p.Name, p.GetValue())
+		}
+	}
+
+	// Parameter referenced by the statement is injected.
+	doPost("/db/query", "tenant7", "password", `["SELECT * FROM foo WHERE tenant_id = :tenant_id", "SELECT * FROM bar"]`)
+	checkTenant(stmts[0], 7)
+	if len(stmts[1].Parameters) != 0 {
+		t.Fatalf("parameter injected into statement which doesn't reference it")
+	}
+
+	// Client cannot override an injected parameter.
+	doPost("/db/execute", "tenant7", "password", `[["DELETE FROM foo WHERE tenant_id = :tenant_id", {"tenant_id": 8}]]`)
+	checkTenant(stmts[0], 7)
+
+	// Other users get no injected parameters.
+	doPost("/db/query", "other", "password", `["SELECT * FROM foo WHERE tenant_id = :tenant_id"]`)
+	if len(stmts[0].Parameters) != 0 {
+		t.Fatalf("parameter injected for user without default parameters")
+	}
+
+	// Naming a user without their password injects nothing.
+	doPost("/db/query", "tenant7", "wrong", `["SELECT * FROM foo WHERE tenant_id = :tenant_id"]`)
+	if len(stmts[0].Parameters) != 0 {
+		t.Fatalf("parameter injected for request not authenticated as the user")
+	}
+}
+
+func Test_VerifyRow(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "node1:4002", true),
+				store.NewServer("2", "node2:4002", true),
+			}, nil
+		},
+	}
+	names := map[string]string{"node1:4002": "fiona", "node2:4002": "fiona"}
+	c := &mockClusterService{
+		queryFn: func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{
+				{
+					Columns: []string{"id", "name"},
+					Types:   []string{"integer", "text"},
+					Values: []*command.Values{
+						{
+							Parameters: []*command.Parameter{
+								{Value: &command.Parameter_I{I: 1}},
+								{Value: &command.Parameter_S{S: names[addr]}},
+							},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var stmt atomic.Pointer[command.Statement]
+	queryFn := c.queryFn
+	c.queryFn = func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+		stmt.Store(qr.Request.Statements[0])
+		return queryFn(qr, addr, t)
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	verify := func() map[string]interface{} {
+		resp, err := client.Post(host+"/cluster/verify-row", "application/json",
+			strings.NewReader(`{"table": "foo", "key": {"id": 1}}`))
+		if err != nil {
+			t.Fatalf("failed to make verify-row request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for verify-row, got %d", resp.StatusCode)
+		}
+		var v map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("failed to decode response: %s", err.Error())
+		}
+		return v
+	}
+
+	v := verify()
+	if exp, got := `SELECT * FROM "foo" WHERE "id" = ?`, stmt.Load().Sql; exp != got {
+		t.Fatalf("wrong lookup statement, exp %s, got %s", exp, got)
+	}
+	if p := stmt.Load().Parameters[0]; p.GetI() != 1 {
+		t.Fatalf("wrong key parameter, got %v", p.GetValue())
+	}
+	if v["consistent"] != true {
+		t.Fatalf("expected matching rows to be consistent, got %v", v)
+	}
+
+	names["node2:4002"] = "declan"
+	v = verify()
+	if v["consistent"] != false {
+		t.Fatalf("expected mismatching rows to be inconsistent, got %v", v)
+	}
+	nodes := v["nodes"].(map[string]interface{})
+	if diffs := nodes["1"].(map[string]interface{})["differences"]; diffs != nil {
+		t.Fatalf("leader should have no differences, got %v", diffs)
+	}
+	diffs := nodes["2"].(map[string]interface{})["differences"].([]interface{})
+	if len(diffs) != 1 || diffs[0] != "name" {
+		t.Fatalf("wrong differences for node 2, got %v", diffs)
+	}
+	if row := nodes["2"].(map[string]interface{})["row"].(map[string]interface{}); row["name"] != "declan" {
+		t.Fatalf("wrong row for node 2, got %v", row)
+	}
+}
+
+func Test_RequestPriority(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.MaxConcurrentRequests = 1
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	unblock := make(chan struct{})
+	order := make(chan string, 3)
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		sql := qr.Request.Statements[0].Sql
+		if sql == "SELECT 'block'" {
+			<-unblock
+		}
+		order <- sql
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{}
+	query := func(sql, priority string) {
+		req, err := http.NewRequest("GET", host+"/db/query?q="+url.QueryEscape(sql), nil)
+		if err != nil {
+			t.Errorf("failed to create request: %s", err.Error())
+			return
+		}
+		req.Header.Set(PriorityHTTPHeader, priority)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("failed to make request: %s", err.Error())
+			return
+		}
+		resp.Body.Close()
+	}
+
+	go query("SELECT 'block'", "normal")
+	waitFor(t, func() bool { return s.limiter.Active() == 1 })
+	go query("SELECT 'low'", "low")
+	waitFor(t, func() bool { return s.limiter.Waiting() == 1 })
+	go query("SELECT 'high'", "high")
+	waitFor(t, func() bool { return s.limiter.Waiting() == 2 })
+
+	close(unblock)
+	for _, exp := range []string{"SELECT 'block'", "SELECT 'high'", "SELECT 'low'"} {
+		if got := <-order; got != exp {
+			t.Fatalf("wrong admission order, exp %s, got %s", exp, got)
+		}
+	}
+
+	req, err := http.NewRequest("GET", host+"/db/query?q=SELECT%201", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set(PriorityHTTPHeader, "urgent")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for invalid priority, got %d", resp.StatusCode)
+	}
+}
+
+func Test_FollowerReadFallback(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "node1:4002", true),
+				store.NewServer("2", "node2:4002", true),
+				store.NewServer("3", "node3:4002", true),
+			}, nil
+		},
+	}
+	var node3Applied atomic.Uint64
+	node3Applied.Store(100)
+	c := &mockClusterService{
+		nodeMetaFn: func(addr string) (*cluster.NodeMeta, error) {
+			switch addr {
+			case "node1:4002":
+				return &cluster.NodeMeta{Url: "http://node1:4001", CommitIndex: 100, AppliedIndex: 100}, nil
+			case "node2:4002":
+				return &cluster.NodeMeta{Url: "http://node2:4001", CommitIndex: 100, AppliedIndex: 90}, nil
+			default:
+				return &cluster.NodeMeta{Url: "http://node3:4001", CommitIndex: 100, AppliedIndex: node3Applied.Load()}, nil
+			}
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.MaxConcurrentRequests = 1
+	s.FollowerReadFallback = true
+	s.FollowerReadRefreshInterval = 10 * time.Millisecond
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	waitFor(t, func() bool {
+		_, ok := s.followerReads.Pick()
+		return ok
+	})
+
+	unblock := make(chan struct{})
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		if qr.Request.Statements[0].Sql == "SELECT 'block'" {
+			<-unblock
+		}
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	query := func(sql string) *http.Response {
+		resp, err := client.Get(host + "/db/query?level=strong&q=" + url.QueryEscape(sql))
+		if err != nil {
+			t.Errorf("failed to make request: %s", err.Error())
+			return nil
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// An idle Leader serves the read itself.
+	if resp := query("SELECT 1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK from idle Leader, got %d", resp.StatusCode)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		query("SELECT 'block'")
+	}()
+	waitFor(t, func() bool { return s.limiter.Active() == 1 })
+
+	// The saturated Leader redirects to the only caught-up follower.
+	resp := query("SELECT 1")
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("failed to get expected StatusTemporaryRedirect, got %d", resp.StatusCode)
+	}
+	if exp, got := "http://node3:4001/db/query?level=none&q=SELECT+1", resp.Header.Get("Location"); exp != got {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, got)
+	}
+
+	// With no follower caught up, the read waits for admission instead.
+	node3Applied.Store(99)
+	waitFor(t, func() bool {
+		_, ok := s.followerReads.Pick()
+		return !ok
+	})
+	queued := make(chan int)
+	go func() {
+		if resp := query("SELECT 1"); resp != nil {
+			queued <- resp.StatusCode
+		}
+	}()
+	waitFor(t, func() bool { return s.limiter.Waiting() == 1 })
+	close(unblock)
+	if code := <-queued; code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for queued read, got %d", code)
+	}
+	<-done
+}
+
+func Test_FollowerReadFallbackAuth(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "node1:4002", true),
+				store.NewServer("2", "node2:4002", true),
+			}, nil
+		},
+	}
+	c := &mockClusterService{
+		nodeMetaFn: func(addr string) (*cluster.NodeMeta, error) {
+			if addr == "node1:4002" {
+				return &cluster.NodeMeta{Url: "http://node1:4001", CommitIndex: 100, AppliedIndex: 100}, nil
+			}
+			return &cluster.NodeMeta{Url: "http://node2:4001", CommitIndex: 100, AppliedIndex: 100}, nil
+		},
+	}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return username == "fiona" && password == "secret1"
+		},
+	}
+	s := New("127.0.0.1:0", m, c, creds)
+	s.MaxConcurrentRequests = 1
+	s.FollowerReadFallback = true
+	s.FollowerReadRefreshInterval = 10 * time.Millisecond
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	waitFor(t, func() bool {
+		_, ok := s.followerReads.Pick()
+		return ok
+	})
+
+	unblock := make(chan struct{})
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		<-unblock
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	query := func(username, password string) *http.Response {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT+1", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("failed to make request: %s", err.Error())
+			return nil
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		query("fiona", "secret1")
+	}()
+	waitFor(t, func() bool { return s.limiter.Active() == 1 })
+	defer func() {
+		close(unblock)
+		<-done
+	}()
+
+	// A client which cannot run the read is not told of the follower.
+	for _, tt := range []struct {
+		username string
+		password string
+	}{
+		{"", ""},
+		{"fiona", "wrong"},
+	} {
+		resp := query(tt.username, tt.password)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failed to get expected StatusUnauthorized for %q, got %d", tt.username, resp.StatusCode)
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			t.Fatalf("unauthenticated request redirected to %s", loc)
+		}
+	}
+
+	resp := query("fiona", "secret1")
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("failed to get expected StatusTemporaryRedirect, got %d", resp.StatusCode)
+	}
+	if exp, got := "http://node2:4001/db/query?level=none&q=SELECT+1", resp.Header.Get("Location"); exp != got {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_NodeLabels(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "node1:4002", true),
+			}, nil
+		},
+	}
+	c := &mockClusterService{
+		nodeMetaFn: func(addr string) (*cluster.NodeMeta, error) {
+			return &cluster.NodeMeta{
+				Url:    "http://node1:4001",
+				Labels: map[string]string{"role": "analytics-replica"},
+			}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.Labels = map[string]string{"role": "analytics-replica"}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func(path string, v interface{}) {
+		resp, err := client.Get(host + path)
+		if err != nil {
+			t.Fatalf("failed to make %s request: %s", path, err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode %s response: %s", path, err.Error())
+		}
+	}
+
+	var status struct {
+		Node struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"node"`
+	}
+	get("/status", &status)
+	if exp, got := "analytics-replica", status.Node.Labels["role"]; exp != got {
+		t.Fatalf("wrong role label in status, exp %s, got %s", exp, got)
+	}
+
+	var nodes Nodes
+	get("/nodes?ver=2&nonvoters", &struct {
+		Nodes *Nodes `json:"nodes"`
+	}{&nodes})
+	if len(nodes) != 1 {
+		t.Fatalf("wrong number of nodes, exp 1, got %d", len(nodes))
+	}
+	if exp, got := "analytics-replica", nodes[0].Labels["role"]; exp != got {
+		t.Fatalf("wrong role label in nodes, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_ClusterQueryLabels(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				store.NewServer("1", "node1:4002", true),
+				store.NewServer("2", "node2:4002", true),
+				store.NewServer("3", "node3:4002", true),
+			}, nil
+		},
+	}
+	labels := map[string]map[string]string{
+		"node1:4002": {"role": "primary"},
+		"node2:4002": {"role": "analytics-replica", "zone": "a"},
+		"node3:4002": {"role": "analytics-replica", "zone": "b"},
+	}
+	var mu sync.Mutex
+	queried := make(map[string]bool)
+	c := &mockClusterService{
+		nodeMetaFn: func(addr string) (*cluster.NodeMeta, error) {
+			return &cluster.NodeMeta{Labels: labels[addr]}, nil
+		},
+		queryFn: func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+			if qr.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+				return nil, fmt.Errorf("wrong read consistency level: %s", qr.Level)
+			}
+			mu.Lock()
+			queried[addr] = true
+			mu.Unlock()
+			return []*command.QueryRows{
+				{
+					Columns: []string{"addr"},
+					Types:   []string{"text"},
+					Values: []*command.Values{
+						{Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: addr}}}},
+					},
+				},
+			}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	query := func(params string) map[string]interface{} {
+		mu.Lock()
+		queried = make(map[string]bool)
+		mu.Unlock()
+		resp, err := client.Get(host + "/cluster/query?q=SELECT%20addr&" + params)
+		if err != nil {
+			t.Fatalf("failed to make cluster query request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+		}
+		var body struct {
+			Nodes map[string]interface{} `json:"nodes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode cluster query response: %s", err.Error())
+		}
+		return body.Nodes
+	}
+
+	nodes := query("")
+	if len(nodes) != 3 || len(queried) != 3 {
+		t.Fatalf("unfiltered fanout did not reach every node: %v", nodes)
+	}
+
+	nodes = query("label=role=analytics-replica")
+	if len(nodes) != 2 || queried["node1:4002"] {
+		t.Fatalf("label filter did not restrict fanout: %v", nodes)
+	}
+	if _, ok := nodes["2"]; !ok {
+		t.Fatalf("node 2 missing from filtered fanout: %v", nodes)
+	}
+
+	nodes = query("label=role=analytics-replica,zone=b")
+	if len(nodes) != 1 || !queried["node3:4002"] || len(queried) != 1 {
+		t.Fatalf("multiple labels did not restrict fanout: %v", nodes)
+	}
+	n3 := nodes["3"].(map[string]interface{})
+	if n3["error"] != nil {
+		t.Fatalf("unexpected error from node 3: %v", n3["error"])
+	}
+	exp := `[{"columns":["addr"],"types":["text"],"values":[["node3:4002"]]}]`
+	if b, _ := json.Marshal(n3["results"]); string(b) != exp {
+		t.Fatalf("wrong results, exp %s, got %s", exp, b)
+	}
+
+	resp, err := client.Get(host + "/cluster/query?q=SELECT%20addr&label=role")
+	if err != nil {
+		t.Fatalf("failed to make cluster query request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for bad label, got %d", resp.StatusCode)
+	}
+}
+
+func Test_IdempotentExecute(t *testing.T) {
+	var requests []*command.ExecuteRequest
+	var storeErr error
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			requests = append(requests, er)
+			if storeErr != nil {
+				return nil, storeErr
+			}
+			// The store replays the results of a request whose key it has
+			// already applied.
+			replayed := len(requests) > 1 && er.IdempotencyKey != "" &&
+				er.IdempotencyKey == requests[len(requests)-2].IdempotencyKey
+			return []*command.ExecuteResult{{LastInsertId: 1, RowsAffected: 1, Replayed: replayed}}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.IdempotencyWindow = time.Minute
+	s.IdempotencyMaxKeys = 10
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	execute := func(key, path, body string) (*http.Response, string) {
+		req, err := http.NewRequest("POST", host+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make execute request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp, string(b)
+	}
+	insert := `["INSERT INTO foo(name) VALUES('fiona')"]`
+	exp := `{"results":[{"last_insert_id":1,"rows_affected":1}]}`
+
+	// The key, and the digest of the body, are written to the log with the
+	// request, along with the window and maximum number of keys.
+	resp, body := execute("key1", "/db/execute", insert)
+	if resp.StatusCode != http.StatusOK || body != exp {
+		t.Fatalf("wrong response to first request, got %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first response marked as replayed")
+	}
+	er := requests[0]
+	digest := sha256.Sum256([]byte(insert))
+	if er.IdempotencyKey != "\x00key1" || !bytes.Equal(er.IdempotencyDigest, digest[:]) ||
+		er.IdempotencyWindow != int64(time.Minute) || er.IdempotencyMaxKeys != 10 {
+		t.Fatalf("wrong idempotency key in request: %v", er)
+	}
+
+	resp, body = execute("key1", "/db/execute", insert)
+	if resp.StatusCode != http.StatusOK || body != exp {
+		t.Fatalf("wrong response to retried request, got %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retried response not marked as replayed")
+	}
+
+	storeErr = store.ErrIdempotencyKeyConflict
+	if resp, body := execute("key1", "/db/execute", `["DELETE FROM foo"]`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("failed to get expected StatusUnprocessableEntity for reused key, got %d: %s", resp.StatusCode, body)
+	}
+	storeErr = store.ErrIdempotencyUnsupported
+	if resp, body := execute("key1", "/db/execute", insert); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for unsupported key, got %d: %s", resp.StatusCode, body)
+	}
+	storeErr = nil
+
+	// Requests without a key carry none.
+	execute("", "/db/execute", insert)
+	if er := requests[len(requests)-1]; er.IdempotencyKey != "" || er.IdempotencyDigest != nil {
+		t.Fatalf("request without key carries one: %v", er)
+	}
+
+	// Queued requests are not written to the log one by one, so can't
+	// carry keys.
+	n := len(requests)
+	if resp, body := execute("key2", "/db/execute?queue", insert); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for queued request with key, got %d: %s", resp.StatusCode, body)
+	}
+	if len(requests) != n {
+		t.Fatalf("queued request with key executed")
+	}
+
+	// With idempotency disabled, keys are ignored.
+	s.IdempotencyWindow = 0
+	execute("key3", "/db/execute", insert)
+	if er := requests[len(requests)-1]; er.IdempotencyKey != "" {
+		t.Fatalf("request carries key while idempotency disabled: %v", er)
+	}
+}
+
+// Test_IdempotentExecuteUserScope ensures a key is scoped to the user who made
+// the request, and not to a request which names that user without
+// authenticating as them.
+func Test_IdempotentExecuteUserScope(t *testing.T) {
+	var keys []string
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			keys = append(keys, er.IdempotencyKey)
+			return []*command.ExecuteResult{{LastInsertId: 1, RowsAffected: 1}}, nil
+		},
+	}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return perm != "" || password == "password"
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, creds)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		password string
+		key      string
+	}{
+		{"password", "alice\x00key1"},
+		{"wrong", "\x00key1"},
+	} {
+		req, err := http.NewRequest("POST", host+"/db/execute", strings.NewReader(`["INSERT INTO foo(name) VALUES('fiona')"]`))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.Header.Set(IdempotencyKeyHeader, "key1")
+		req.SetBasicAuth("alice", tt.password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make execute request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if got := keys[len(keys)-1]; got != tt.key {
+			t.Fatalf("wrong key with password %s, exp %q, got %q", tt.password, tt.key, got)
+		}
+	}
+}
+
+func Test_ExecuteHooks(t *testing.T) {
+	var executed []string
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			for _, stmt := range er.Request.Statements {
+				executed = append(executed, stmt.Sql)
+			}
+			return nil, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+
+	s.RegisterPreExecuteHook(func(r *http.Request, stmt *command.Statement) error {
+		sql := strings.ToUpper(stmt.Sql)
+		if strings.HasPrefix(sql, "DELETE") && !strings.Contains(sql, " WHERE ") {
+			return fmt.Errorf("DELETE requires a WHERE clause: %s", stmt.Sql)
+		}
+		return nil
+	})
+	var audited []string
+	s.RegisterPostExecuteHook(func(r *http.Request, stmts []*command.Statement, err error) {
+		for _, stmt := range stmts {
+			audited = append(audited, stmt.Sql)
+		}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	execute := func(body string) (int, string) {
+		resp, err := client.Post(host+"/db/execute", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make execute request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := execute(`["INSERT INTO foo VALUES(1)", "DELETE FROM foo"]`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for unqualified DELETE, got %d", code)
+	}
+	if !strings.Contains(body, "DELETE requires a WHERE clause") {
+		t.Fatalf("hook error not returned, got %s", body)
+	}
+	if len(executed) != 0 || len(audited) != 0 {
+		t.Fatalf("rejected request was executed")
+	}
+
+	code, _ = execute(`["DELETE FROM foo WHERE id = 1"]`)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for qualified DELETE, got %d", code)
+	}
+	if exp := []string{"DELETE FROM foo WHERE id = 1"}; !reflect.DeepEqual(executed, exp) {
+		t.Fatalf("wrong statements executed, exp %v, got %v", exp, executed)
+	}
+	if !reflect.DeepEqual(audited, executed) {
+		t.Fatalf("wrong statements audited, exp %v, got %v", executed, audited)
+	}
+}
+
+func Test_QueryResultHistograms(t *testing.T) {
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			n, err := strconv.Atoi(strings.TrimPrefix(qr.Request.Statements[0].Sql, "SELECT "))
+			if err != nil {
+				return nil, err
+			}
+			rows := &command.QueryRows{
+				Columns: []string{"id"},
+				Types:   []string{"integer"},
+			}
+			for i := 0; i < n; i++ {
+				rows.Values = append(rows.Values, &command.Values{
+					Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(i)}}},
+				})
+			}
+			return []*command.QueryRows{rows}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, n := range []int{0, 5, 50, 50, 1000} {
+		resp, err := client.Get(fmt.Sprintf("%s/db/query?q=SELECT%%20%d", host, n))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+		}
+	}
+
+	resp, err := client.Get(host + "/status")
+	if err != nil {
+		t.Fatalf("failed to make status request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	var status struct {
+		HTTP struct {
+			QueryResults struct {
+				Rows struct {
+					Count   int64             `json:"count"`
+					Sum     int64             `json:"sum"`
+					Buckets []HistogramBucket `json:"buckets"`
+				} `json:"rows"`
+				Bytes struct {
+					Count   int64             `json:"count"`
+					Buckets []HistogramBucket `json:"buckets"`
+				} `json:"bytes"`
+			} `json:"query_results"`
+		} `json:"http"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %s", err.Error())
+	}
+
+	rows := status.HTTP.QueryResults.Rows
+	if rows.Count != 5 || rows.Sum != 1105 {
+		t.Fatalf("wrong rows count or sum, got %d and %d", rows.Count, rows.Sum)
+	}
+	exp := []HistogramBucket{
+		{LE: "0", Count: 1},
+		{LE: "1", Count: 0},
+		{LE: "10", Count: 1},
+		{LE: "100", Count: 2},
+		{LE: "1000", Count: 1},
+		{LE: "10000", Count: 0},
+		{LE: "100000", Count: 0},
+		{LE: "+Inf", Count: 0},
+	}
+	if !reflect.DeepEqual(exp, rows.Buckets) {
+		t.Fatalf("wrong rows buckets, exp %v, got %v", exp, rows.Buckets)
+	}
+
+	bytes := status.HTTP.QueryResults.Bytes
+	if bytes.Count != 5 {
+		t.Fatalf("wrong bytes count, exp 5, got %d", bytes.Count)
+	}
+	if bytes.Buckets[0].Count != 4 || bytes.Buckets[1].Count != 1 {
+		t.Fatalf("wrong bytes buckets, got %v", bytes.Buckets)
+	}
+}
+
+func Test_LatencyHistograms(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			time.Sleep(2 * time.Millisecond)
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, store.ErrNotLeader
+		},
+	}
+	c := &mockClusterService{
+		queryFn: func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error) {
+			time.Sleep(20 * time.Millisecond)
+			return []*command.QueryRows{}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, path := range []string{"/db/execute", "/db/execute", "/db/query"} {
+		resp, err := http.Post(host+path, "application/json", strings.NewReader(`["SELECT 1"]`))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(host + "/status")
+	if err != nil {
+		t.Fatalf("failed to make status request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	type hist struct {
+		Count   int64             `json:"count"`
+		Sum     int64             `json:"sum"`
+		Buckets []HistogramBucket `json:"buckets"`
+	}
+	var status struct {
+		HTTP struct {
+			Latency map[string]map[string]hist `json:"latency_us"`
+		} `json:"http"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %s", err.Error())
+	}
+
+	lat := status.HTTP.Latency
+	for _, tt := range []struct {
+		op, where string
+		count     int64
+		minSum    int64
+	}{
+		{"execute", "local", 2, 4000},
+		{"execute", "forward", 0, 0},
+		{"query", "local", 0, 0},
+		{"query", "forward", 1, 20000},
+	} {
+		h := lat[tt.op][tt.where]
+		if h.Count != tt.count {
+			t.Fatalf("wrong %s %s count, exp %d, got %d", tt.op, tt.where, tt.count, h.Count)
+		}
+		if h.Sum < tt.minSum {
+			t.Fatalf("wrong %s %s sum, exp at least %d, got %d", tt.op, tt.where, tt.minSum, h.Sum)
+		}
+		if exp, got := 12, len(h.Buckets); exp != got {
+			t.Fatalf("wrong number of %s %s buckets, exp %d, got %d", tt.op, tt.where, exp, got)
+		}
+	}
+}
+
+func Test_TableRows(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 1; i <= 10; i++ {
+		if _, err := database.ExecuteStringStmt(fmt.Sprintf(`INSERT INTO foo VALUES(%d, "name%02d")`, i, 20-i)); err != nil {
+			t.Fatalf("failed to insert row: %s", err.Error())
+		}
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func(path string) (int, string) {
+		resp, err := client.Get(host + path)
+		if err != nil {
+			t.Fatalf("failed to make table rows request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	for path, exp := range map[string]string{
+		"/db/tables/foo/rows?limit=3&offset=2":             `{"results":[{"columns":["id","name"],"types":["integer","text"],"values":[[3,"name17"],[4,"name16"],[5,"name15"]]}]}`,
+		"/db/tables/foo/rows?limit=2&order_by=name":        `{"results":[{"columns":["id","name"],"types":["integer","text"],"values":[[10,"name10"],[9,"name11"]]}]}`,
+		"/db/tables/foo/rows?limit=2&offset=9":             `{"results":[{"columns":["id","name"],"types":["integer","text"],"values":[[10,"name10"]]}]}`,
+		"/db/tables/foo/rows?limit=1&offset=1&associative": `{"results":[{"types":{"id":"integer","name":"text"},"rows":[{"id":2,"name":"name18"}]}]}`,
+	} {
+		code, body := get(path)
+		if code != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d: %s", path, code, body)
+		}
+		if body != exp {
+			t.Fatalf("wrong rows for %s\nexp: %s\ngot: %s", path, exp, body)
+		}
+	}
+
+	for path, exp := range map[string]int{
+		"/db/tables/foo/rows?order_by=name%3B%20DROP%20TABLE%20foo": http.StatusBadRequest,
+		"/db/tables/foo/rows?order_by=nonexistent":                  http.StatusBadRequest,
+		"/db/tables/foo/rows?limit=0":                               http.StatusBadRequest,
+		"/db/tables/foo/rows?offset=-1":                             http.StatusBadRequest,
+		"/db/tables/foo/rows?limit=ten":                             http.StatusBadRequest,
+		"/db/tables/bar/rows":                                       http.StatusNotFound,
+	} {
+		if code, body := get(path); code != exp {
+			t.Fatalf("wrong status for %s, exp %d, got %d: %s", path, exp, code, body)
+		}
+	}
+}
+
+func Test_QueryExplain(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	if _, err := database.ExecuteStringStmt(`CREATE INDEX foo_name ON foo(name)`); err != nil {
+		t.Fatalf("failed to create index: %s", err.Error())
+	}
+
+	var level command.QueryRequest_Level
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			level = qr.Level
+			return database.Query(qr.Request, false)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.StrictQuery = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	query := func(params, body string) (int, string) {
+		resp, err := http.Post(host+"/db/query?"+params, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := query("explain&level=strong", `["SELECT * FROM foo WHERE name = 'fiona'", "EXPLAIN QUERY PLAN SELECT id FROM foo WHERE name > 'd'"]`)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+		t.Fatalf("query plan not read at level none, got %s", level)
+	}
+	var r struct {
+		Results []struct {
+			Columns []string        `json:"columns"`
+			Values  [][]interface{} `json:"values"`
+			Error   string          `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatalf("failed to unmarshal response: %s", err.Error())
+	}
+	if len(r.Results) != 2 {
+		t.Fatalf("wrong number of results, exp 2, got %d: %s", len(r.Results), body)
+	}
+	for i, res := range r.Results {
+		if res.Error != "" {
+			t.Fatalf("result %d has error: %s", i, res.Error)
+		}
+		if exp := []string{"id", "parent", "notused", "detail"}; !reflect.DeepEqual(res.Columns, exp) {
+			t.Fatalf("wrong columns for result %d, exp %v, got %v", i, exp, res.Columns)
+		}
+		if len(res.Values) == 0 || !strings.Contains(res.Values[0][3].(string), "foo_name") {
+			t.Fatalf("query plan for result %d does not use index: %s", i, body)
+		}
+	}
+
+	if code, body := query("explain&rollup=count(id)", `["SELECT * FROM foo"]`); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for rollup with explain, got %d: %s", code, body)
+	}
+}
+
+func Test_ExecuteDryRun(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			t.Fatalf("statements executed during dry run")
+			return nil, nil
+		},
+		validateFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return database.Validate(er.Request)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	body := `[
+		["INSERT INTO foo(name) VALUES('fiona')"],
+		["INSERT INTO bar(name) VALUES('fiona')"],
+		["INSERT INTO foo(name) VALUES(?)"],
+		["INSERT INTO foo(name) VALUES(?)", "fiona"],
+		["CREATE TABLE baz (id INTEGER PRIMARY KEY); DELETE FROM qux"],
+		["INSERT INTO foo(nam) VALUES('fiona')"]
+	]`
+	resp, err := http.Post(fmt.Sprintf("http://%s/db/execute?dry_run", s.Addr().String()), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make execute request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", resp.StatusCode, b)
+	}
+	if exp, got := `{"results":[{},{"error":"no such table: bar"},{"error":"not enough args to execute query: want 1 got 0"},{},{"error":"no such table: qux"},{"error":"table foo has no column named nam"}]}`, string(b); exp != got {
+		t.Fatalf("wrong dry run response\nexp: %s\ngot: %s", exp, got)
+	}
+
+	r, err := database.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if n := r[0].Values[0].Parameters[0].GetI(); n != 0 {
+		t.Fatalf("dry run changed database, exp 0 rows, got %d", n)
+	}
+}
+
+func Test_Leader(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp, err := http.Get(host + "/leader")
+	if err != nil {
+		t.Fatalf("failed to make leader request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("wrong status code with no leader, exp %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if exp, got := "1", resp.Header.Get("Retry-After"); exp != got {
+		t.Fatalf("wrong Retry-After header, exp %s, got %s", exp, got)
+	}
+
+	m.leaderAddr = "foo:1234"
+	resp, err = http.Get(host + "/leader")
+	if err != nil {
+		t.Fatalf("failed to make leader request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	if exp, got := `{"addr":"foo:1234","api_addr":"http://1.2.3.4:999"}`, string(b); exp != got {
+		t.Fatalf("wrong leader response, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_LeaderNotFoundRetryAfter(t *testing.T) {
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return nil, store.ErrNotLeader
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.ElectionTimeout = 2 * time.Second
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		path          string
+		leaderlessFor time.Duration
+		retryAfter    string
+	}{
+		{"/db/execute", 0, "2"},
+		{"/db/execute", 5 * time.Second, "8"},
+		{"/db/execute", time.Minute, "30"},
+		{"/db/execute?redirect", 0, "2"},
+	} {
+		m.leaderlessFor = tt.leaderlessFor
+		resp, err := http.Post(host+tt.path, "application/json", strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+		if err != nil {
+			t.Fatalf("failed to make execute request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.path, http.StatusServiceUnavailable, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Retry-After"); got != tt.retryAfter {
+			t.Fatalf("wrong Retry-After for %s leaderless for %s, exp %s, got %s", tt.path, tt.leaderlessFor, tt.retryAfter, got)
+		}
+		if exp, got := "2s", resp.Header.Get(ElectionTimeoutHTTPHeader); exp != got {
+			t.Fatalf("wrong election timeout header, exp %s, got %s", exp, got)
+		}
+	}
+}
+
+func Test_RemoveByIDOrAddr(t *testing.T) {
+	var removed string
+	m := &MockStore{
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "node2", Addr: "localhost:4004", Suffrage: "Voter"},
+			}, nil
+		},
+		removeFn: func(rn *command.RemoveNodeRequest) error {
+			removed = rn.Id
+			return nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		body    string
+		code    int
+		removed string
+	}{
+		{`{"id": "node2"}`, http.StatusOK, "node2"},
+		{`{"addr": "localhost:4004"}`, http.StatusOK, "node2"},
+		{`{"id": "node3"}`, http.StatusNotFound, ""},
+		{`{"addr": "localhost:4006"}`, http.StatusNotFound, ""},
+		{`{"name": "node2"}`, http.StatusBadRequest, ""},
+		{`{"id": "node2", "addr": "localhost:4004"}`, http.StatusBadRequest, ""},
+		{`{"id": "node1", "addr": "localhost:4004"}`, http.StatusBadRequest, ""},
+		{`{"id": "node2", "name": "node2"}`, http.StatusBadRequest, ""},
+	} {
+		removed = ""
+		req, err := http.NewRequest("DELETE", host+"/remove", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make remove request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.body, tt.code, resp.StatusCode)
+		}
+		if removed != tt.removed {
+			t.Fatalf("wrong node removed for %s, exp %q, got %q", tt.body, tt.removed, removed)
+		}
+	}
+}
+
+func Test_ReadOnlyNode(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	m := &MockStore{
+		readOnlyFn: database.StmtReadOnly,
+		leaderAddr: "foo:1234",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			t.Fatalf("statements executed on read-only node")
+			return nil, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, nil
+		},
+	}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+	s := New("127.0.0.1:0", m, c, &mockCredentialStore{HasPermOK: true})
+	s.ReadOnly = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/db/execute", `["INSERT INTO foo VALUES(1)"]`, http.StatusForbidden},
+		{"/db/execute?redirect", `["INSERT INTO foo VALUES(1)"]`, http.StatusMovedPermanently},
+		{"/db/load", "CREATE TABLE foo (id INTEGER)", http.StatusForbidden},
+		{"/db/request", `["INSERT INTO foo VALUES(1)"]`, http.StatusForbidden},
+		{"/db/request", `["SELECT * FROM foo"]`, http.StatusOK},
+		{"/db/request", `["SELECT * FROM main.foo WHERE id IN (SELECT MAX(id) FROM foo)"]`, http.StatusOK},
+		{"/db/request", `["INSERT INTO main.foo SELECT MAX(id)+1 FROM foo"]`, http.StatusForbidden},
+		{"/db/query", `["SELECT * FROM foo"]`, http.StatusOK},
+	} {
+		req, err := http.NewRequest("POST", host+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth("admin", "password")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request to %s: %s", tt.path, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.path, tt.code, resp.StatusCode)
+		}
+		if tt.code != http.StatusOK {
+			if exp, got := "http://1.2.3.4:999", resp.Header.Get(LeaderHTTPHeader); exp != got {
+				t.Fatalf("wrong leader header for %s, exp %s, got %s", tt.path, exp, got)
+			}
+		}
+	}
+}
+
+func Test_SQLRequestBody(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return database.Execute(er.Request, false)
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(host+path, "application/sql", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	ids := make([]string, 500)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%d", i+1)
+	}
+	script := `-- Create the schema; then populate it.
+CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);
+INSERT INTO foo(name) VALUES('fiona; the first');
+INSERT INTO foo(name) VALUES('declan');
+`
+	code, body := post("/db/execute", script)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for execute, got %d: %s", code, body)
+	}
+	if exp := `{"results":[{},{"last_insert_id":1,"rows_affected":1},{"last_insert_id":2,"rows_affected":1}]}`; body != exp {
+		t.Fatalf("wrong execute response\nexp: %s\ngot: %s", exp, body)
+	}
+
+	code, body = post("/db/query", fmt.Sprintf("SELECT name FROM foo WHERE id IN (%s) ORDER BY id", strings.Join(ids, ",")))
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for query, got %d: %s", code, body)
+	}
+	if exp := `{"results":[{"columns":["name"],"types":["text"],"values":[["fiona; the first"],["declan"]]}]}`; body != exp {
+		t.Fatalf("wrong query response\nexp: %s\ngot: %s", exp, body)
+	}
+
+	if code, body := post("/db/query", "-- nothing to see here\n"); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for empty script, got %d: %s", code, body)
+	}
+}
+
+func Test_Indexes(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, email TEXT UNIQUE)`,
+		`CREATE INDEX foo_name_age ON foo(name, age)`,
+		`CREATE INDEX foo_lower_name ON foo(lower(name)) WHERE age > 18`,
+		`INSERT INTO foo VALUES(1, "fiona", 20, "fiona@example.com")`,
+		`INSERT INTO foo VALUES(2, "declan", 30, "declan@example.com")`,
+	} {
+		if _, err := database.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func() string {
+		resp, err := http.Get(host + "/db/indexes")
+		if err != nil {
+			t.Fatalf("failed to make indexes request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK, got %d: %s", resp.StatusCode, b)
+		}
+		return string(b)
+	}
+
+	exp := `{"indexes":[` +
+		`{"name":"foo_lower_name","table":"foo","columns":[null],"unique":false,"origin":"c","partial":true},` +
+		`{"name":"foo_name_age","table":"foo","columns":["name","age"],"unique":false,"origin":"c","partial":false},` +
+		`{"name":"sqlite_autoindex_foo_1","table":"foo","columns":["email"],"unique":true,"origin":"u","partial":false}]}`
+	if got := get(); exp != got {
+		t.Fatalf("wrong indexes\nexp: %s\ngot: %s", exp, got)
+	}
+
+	if _, err := database.ExecuteStringStmt(`ANALYZE`); err != nil {
+		t.Fatalf("failed to analyze: %s", err.Error())
+	}
+	exp = `{"name":"foo_name_age","table":"foo","columns":["name","age"],"unique":false,"origin":"c","partial":false,"rows_estimate":2,"stat":"2 1 1"}`
+	if got := get(); !strings.Contains(got, exp) {
+		t.Fatalf("analyzed index missing statistics\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_TableChecksum(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, score REAL)`,
+		`CREATE TABLE bar (id INTEGER PRIMARY KEY, name TEXT, score REAL)`,
+		`INSERT INTO foo VALUES(1, "fiona", 1.5)`,
+		`INSERT INTO foo VALUES(2, "declan", NULL)`,
+		`INSERT INTO bar VALUES(2, "declan", NULL)`,
+		`INSERT INTO bar VALUES(1, "fiona", 1.5)`,
+	} {
+		if _, err := database.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+		queryStreamFn: func(qr *command.QueryRequest, fn db.RowFunc) error {
+			if qr.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+				return fmt.Errorf("checksum not computed locally, level is %s", qr.Level)
+			}
+			return database.QueryStream(qr.Request.Statements[0], 0, fn)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	checksum := func(table string) (string, int64) {
+		resp, err := http.Get(host + "/db/tables/" + table + "/checksum")
+		if err != nil {
+			t.Fatalf("failed to make checksum request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", table, resp.StatusCode)
+		}
+		var r struct {
+			Table    string `json:"table"`
+			Checksum string `json:"checksum"`
+			Rows     int64  `json:"rows"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatalf("failed to decode checksum response: %s", err.Error())
+		}
+		if r.Table != table {
+			t.Fatalf("wrong table in response, exp %s, got %s", table, r.Table)
+		}
+		return r.Checksum, r.Rows
+	}
+
+	fooSum, fooRows := checksum("foo")
+	barSum, barRows := checksum("bar")
+	if fooRows != 2 || barRows != 2 {
+		t.Fatalf("wrong row counts, foo %d, bar %d", fooRows, barRows)
+	}
+	if fooSum == "" || fooSum != barSum {
+		t.Fatalf("identical tables have different checksums, foo %s, bar %s", fooSum, barSum)
+	}
+	if sum, _ := checksum("foo"); sum != fooSum {
+		t.Fatalf("checksum is not deterministic, exp %s, got %s", fooSum, sum)
+	}
+
+	if _, err := database.ExecuteStringStmt(`UPDATE bar SET name="fionn" WHERE id=1`); err != nil {
+		t.Fatalf("failed to update table: %s", err.Error())
+	}
+	if sum, _ := checksum("bar"); sum == fooSum {
+		t.Fatalf("modified table has same checksum as original")
+	}
+
+	resp, err := http.Get(host + "/db/tables/qux/checksum")
+	if err != nil {
+		t.Fatalf("failed to make checksum request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("failed to get expected StatusNotFound for missing table, got %d", resp.StatusCode)
+	}
+}
+
+func Test_Flags(t *testing.T) {
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return nil, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	type flagsResponse struct {
+		Scope string `json:"scope"`
+		Flags map[string]struct {
+			Value    interface{} `json:"value"`
+			ReadOnly bool        `json:"read_only"`
+		} `json:"flags"`
+	}
+	doFlags := func(method, body string) (int, flagsResponse) {
+		req, err := http.NewRequest(method, host+"/config/flags", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make flags request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		var fr flagsResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&fr); err != nil {
+				t.Fatalf("failed to decode flags response: %s", err.Error())
+			}
+		}
+		return resp.StatusCode, fr
+	}
+	queryCode := func() int {
+		resp, err := http.Get(host + "/db/query?q=" + url.QueryEscape("INSERT INTO foo VALUES(1)"))
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	code, fr := doFlags("GET", "")
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for flags, got %d", code)
+	}
+	if fr.Scope != "node" {
+		t.Fatalf("wrong scope for flags, got %q", fr.Scope)
+	}
+	if f := fr.Flags["strict_query"]; f.Value != false || f.ReadOnly {
+		t.Fatalf("wrong state for strict_query: %v", f)
+	}
+	if f := fr.Flags["max_concurrent_requests"]; !f.ReadOnly {
+		t.Fatalf("max_concurrent_requests not read-only")
+	}
+	if code := queryCode(); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for write query, got %d", code)
+	}
+
+	// Toggling a live flag changes behavior.
+	code, fr = doFlags("PUT", `{"strict_query":true,"default_max_rows":5}`)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for flags update, got %d", code)
+	}
+	if fr.Flags["strict_query"].Value != true || fr.Flags["default_max_rows"].Value != float64(5) {
+		t.Fatalf("flags not updated: %v", fr.Flags)
+	}
+	if code := queryCode(); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for write query with strict_query, got %d", code)
+	}
+
+	// Read-only and unknown flags are rejected, as are invalid values, and
+	// no flag is changed.
+	for _, body := range []string{
+		`{"strict_query":false,"max_concurrent_requests":10}`,
+		`{"strict_query":false,"no_such_flag":true}`,
+		`{"strict_query":false,"default_max_rows":-1}`,
+		`{"strict_query":"no"}`,
+		`{"default_max_rows":1,"strict_query":"no"}`,
+		`not json`,
+	} {
+		if code, _ := doFlags("PUT", body); code != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for %s, got %d", body, code)
+		}
+	}
+	_, fr = doFlags("GET", "")
+	if fr.Flags["strict_query"].Value != true || fr.Flags["default_max_rows"].Value != float64(5) {
+		t.Fatalf("flags changed by rejected update: %v", fr.Flags)
+	}
+	if fr.Flags["max_concurrent_requests"].Value != float64(0) {
+		t.Fatalf("read-only flag changed: %v", fr.Flags["max_concurrent_requests"])
+	}
+}
+
+func Test_QueryGETMultipleQ(t *testing.T) {
+	var stmts []string
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			stmts = nil
+			rows := make([]*command.QueryRows, len(qr.Request.Statements))
+			for i, s := range qr.Request.Statements {
+				stmts = append(stmts, s.Sql)
+				rows[i] = &command.QueryRows{
+					Columns: []string{"n"},
+					Types:   []string{"integer"},
+					Values: []*command.Values{
+						{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(i + 1)}}}},
+					},
+				}
+			}
+			return rows, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		params string
+		stmts  []string
+	}{
+		{"q=SELECT+1", []string{"SELECT 1"}},
+		{"q=SELECT+1%3B+SELECT+2", []string{"SELECT 1", "SELECT 2"}},
+		{"q=SELECT+1&q=SELECT+2", []string{"SELECT 1", "SELECT 2"}},
+		{"q=SELECT+1%3B+SELECT+2&q=SELECT+3", []string{"SELECT 1", "SELECT 2", "SELECT 3"}},
+	} {
+		resp, err := http.Get(host + "/db/query?" + tt.params)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", tt.params, resp.StatusCode)
+		}
+		if !reflect.DeepEqual(stmts, tt.stmts) {
+			t.Fatalf("wrong statements for %s, exp %v, got %v", tt.params, tt.stmts, stmts)
+		}
+		var r struct {
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			t.Fatalf("failed to decode response: %s", err.Error())
+		}
+		if exp, got := len(tt.stmts), len(r.Results); exp != got {
+			t.Fatalf("wrong number of results for %s, exp %d, got %d", tt.params, exp, got)
+		}
+	}
+}
+
+func Test_QueryRollup(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, price INTEGER)`,
+		`INSERT INTO foo VALUES(1, "fiona", 10)`,
+		`INSERT INTO foo VALUES(2, "declan", 20)`,
+		`INSERT INTO foo VALUES(3, "fiona", 30)`,
+	} {
+		if _, err := database.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	query := func(params string) (int, string) {
+		resp, err := http.Get(host + "/db/query?" + params)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	q := url.QueryEscape(`SELECT * FROM foo WHERE name="fiona"`)
+	code, body := query("q=" + q + "&rollup=" + url.QueryEscape("sum(price),count(*),max(name)"))
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if exp := `{"results":[{"columns":["id","name","price"],"types":["integer","text","integer"],"values":[[1,"fiona",10],[3,"fiona",30]]}],"rollup":[{"count(*)":2,"max(name)":"fiona","sum(price)":40}]}`; exp != body {
+		t.Fatalf("wrong rollup response\nexp: %s\ngot: %s", exp, body)
+	}
+
+	// Rollups are computed over the rows the query returns, so respect any
+	// LIMIT, and a trailing semicolon does not break the rollup statement.
+	code, body = query("q=" + url.QueryEscape("SELECT * FROM foo LIMIT 1;") + "&rollup=avg(price)")
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if !strings.HasSuffix(body, `"rollup":[{"avg(price)":10}]}`) {
+		t.Fatalf("wrong rollup for limited query, got: %s", body)
+	}
+
+	code, body = query("q=" + url.QueryEscape("SELECT * FROM bar") + "&rollup=count(*)")
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if !strings.Contains(body, `"rollup":[{"error":"no such table: bar"}]`) {
+		t.Fatalf("rollup error not reported, got: %s", body)
+	}
+
+	for _, params := range []string{
+		"q=" + q + "&rollup=median(price)",
+		"q=" + q + "&rollup=sum(*)",
+		"q=" + q + "&rollup=count(*)&format=csv",
+	} {
+		if code, body := query(params); code != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for %s, got %d: %s", params, code, body)
+		}
+	}
+}
+
+type mockAdvertiser struct {
+	addr string
+}
+
+func (m *mockAdvertiser) SetAPIAddr(addr string) {
+	m.addr = addr
+}
+
+func Test_Advertise(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	advertise := func(body string) int {
+		resp, err := client.Post(host+"/node/advertise", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make advertise request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := advertise(`{"addr": "new-host:4001"}`); code != http.StatusNotImplemented {
+		t.Fatalf("failed to get expected StatusNotImplemented without advertiser, got %d", code)
+	}
+
+	a := &mockAdvertiser{}
+	s.Advertiser = a
+	if code := advertise(`{"addr": "new-host"}`); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for invalid address, got %d", code)
+	}
+	m.notLeader = true
+	if code := advertise(`{"addr": "new-host:4001"}`); code != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable on follower, got %d", code)
+	}
+	if a.addr != "" {
+		t.Fatalf("follower changed advertised address")
+	}
+	m.notLeader = false
+	if code := advertise(`{"addr": "new-host:4001"}`); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", code)
+	}
+	if exp, got := "new-host:4001", a.addr; exp != got {
+		t.Fatalf("wrong advertised address, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_QueryFormatSQL(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{
+			{
+				Columns: []string{"id", "name"},
+				Types:   []string{"integer", "text"},
+				Values: []*command.Values{
+					{
+						Parameters: []*command.Parameter{
+							{Value: &command.Parameter_I{I: 1}},
+							{Value: &command.Parameter_S{S: "fiona"}},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/query?q=SELECT%20*%20FROM%20foo&format=sql&table=bar")
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	if exp, got := "application/sql", resp.Header.Get("Content-Type"); exp != got {
+		t.Fatalf("wrong Content-Type, exp %s, got %s", exp, got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	exp := `BEGIN TRANSACTION;
+CREATE TABLE IF NOT EXISTS "bar" ("id" integer, "name" text);
+INSERT INTO "bar" ("id","name") VALUES(1,'fiona');
+COMMIT;
+`
+	if got := string(body); got != exp {
+		t.Fatalf("wrong SQL, exp:\n%s\ngot:\n%s", exp, got)
+	}
+
+	for _, params := range []string{"format=sql", "format=xml&table=bar"} {
+		resp, err := client.Get(host + "/db/query?q=SELECT%20*%20FROM%20foo&" + params)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for %s, got %d", params, resp.StatusCode)
+		}
+	}
+}
+
+type MockStore struct {
+	executeFn      func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+	queryFn        func(qr *command.QueryRequest) ([]*command.QueryRows, error)
+	queryStreamFn  func(qr *command.QueryRequest, fn db.RowFunc) error
+	queryContextFn func(ctx context.Context, qr *command.QueryRequest) ([]*command.QueryRows, error)
+	requestFn      func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error)
+	validateFn     func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+	readOnlyFn     func(sql string) (bool, error)
+	tablesFn       func(sql string) ([]string, error)
+	subscribeFn    func(tables []string) (store.Subscription, error)
+	eventsFn       func(replay bool, after uint64) (store.EventSubscription, error)
+	backupFn       func(br *command.BackupRequest, dst io.Writer) error
+	loadFn         func(lr *command.LoadRequest) error
+	loadChunkFn    func(lcr *command.LoadChunkRequest) error
+	snapshotFn     func(n uint64) error
+	snapshotIdx    uint64
+	readFromFn     func(r io.Reader) (int64, error)
+	copyFileFn     func(w io.Writer) error
+	committedFn    func(timeout time.Duration) (uint64, error)
+	nodesFn        func() ([]*store.Server, error)
+	leaderlessFor  time.Duration
+	removeFn       func(rn *command.RemoveNodeRequest) error
+	vacuumFn       func() (*db.FileStats, *db.FileStats, error)
+	leaderAddrFn   func() (string, error)
+	leaderAddr     string
+	notReady       bool // Default value is true, easier to test.
+	notLeader      bool
+	staleness      time.Duration
+}
+
+func (m *MockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	if m.executeFn != nil {
+		return m.executeFn(er)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	if m.queryFn != nil {
+		return m.queryFn(qr)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) QueryContext(ctx context.Context, qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	if m.queryContextFn != nil {
+		return m.queryContextFn(ctx, qr)
+	}
+	return m.Query(qr)
+}
+
+func (m *MockStore) QueryStream(qr *command.QueryRequest, fn db.RowFunc) error {
+	if m.queryStreamFn != nil {
+		return m.queryStreamFn(qr, fn)
+	}
+	return nil
+}
+
+func (m *MockStore) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+	if m.requestFn != nil {
+		return m.requestFn(eqr)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) ExecuteContext(ctx context.Context, er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	return m.Execute(er)
+}
+
+func (m *MockStore) Validate(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	if m.validateFn != nil {
+		return m.validateFn(er)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) StmtReadOnly(sql string) (bool, error) {
+	if m.readOnlyFn != nil {
+		return m.readOnlyFn(sql)
+	}
+	return false, errors.New("not implemented")
+}
+
+func (m *MockStore) StmtTables(sql string) ([]string, error) {
+	if m.tablesFn != nil {
+		return m.tablesFn(sql)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *MockStore) Subscribe(tables []string) (store.Subscription, error) {
+	if m.subscribeFn != nil {
+		return m.subscribeFn(tables)
+	}
+	return nil, fmt.Errorf("subscriptions not supported")
+}
+
+func (m *MockStore) SubscribeEvents(replay bool, after uint64) (store.EventSubscription, error) {
+	if m.eventsFn != nil {
+		return m.eventsFn(replay, after)
+	}
+	return nil, fmt.Errorf("event subscriptions not supported")
+}
+
+func (m *MockStore) Join(jr *command.JoinRequest) error {
+	return nil
+}
+
+func (m *MockStore) Notify(nr *command.NotifyRequest) error {
+	return nil
+}
+
+func (m *MockStore) Remove(rn *command.RemoveNodeRequest) error {
+	if m.removeFn != nil {
+		return m.removeFn(rn)
+	}
+	return nil
+}
+
+func (m *MockStore) LeaderAddr() (string, error) {
+	if m.leaderAddrFn != nil {
+		return m.leaderAddrFn()
+	}
+	return m.leaderAddr, nil
+}
+
+func (m *MockStore) IsLeader() bool {
+	return !m.notLeader
+}
+
+func (m *MockStore) Staleness(strict bool) time.Duration {
+	return m.staleness
+}
+
+func (m *MockStore) Ready() bool {
+	return !m.notReady
+}
+
+func (m *MockStore) Committed(timeout time.Duration) (uint64, error) {
+	if m.committedFn != nil {
+		return m.committedFn(timeout)
+	}
+	return 0, nil
+}
+
+func (m *MockStore) Stats() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (m *MockStore) LeaderlessFor() time.Duration {
+	return m.leaderlessFor
+}
+
+func (m *MockStore) Nodes() ([]*store.Server, error) {
+	if m.nodesFn != nil {
+		return m.nodesFn()
+	}
+	return nil, nil
+}
+
+func (m *MockStore) Snapshot(n uint64) error {
+	if m.snapshotFn == nil {
+		return nil
+	}
+	return m.snapshotFn(n)
+}
+
+func (m *MockStore) VacuumReplicated() (*db.FileStats, *db.FileStats, error) {
+	if m.vacuumFn == nil {
+		return &db.FileStats{}, &db.FileStats{}, nil
+	}
+	return m.vacuumFn()
+}
+
+func (m *MockStore) LastSnapshotIndex() (uint64, error) {
+	return m.snapshotIdx, nil
+}
+
+func (m *MockStore) Backup(br *command.BackupRequest, w io.Writer) error {
+	if m.backupFn == nil {
+		return nil
+	}
+	return m.backupFn(br, w)
+}
+
+func (m *MockStore) Load(lr *command.LoadRequest) error {
+	if m.loadFn != nil {
+		return m.loadFn(lr)
+	}
+	return nil
+}
+
+func (m *MockStore) LoadChunk(lcr *command.LoadChunkRequest) error {
+	if m.loadChunkFn != nil {
+		return m.loadChunkFn(lcr)
+	}
+	return nil
+}
+
+func (m *MockStore) CopyFile(w io.Writer) error {
+	if m.copyFileFn != nil {
+		return m.copyFileFn(w)
+	}
+	return nil
+}
+
+func (m *MockStore) ReadFrom(r io.Reader) (int64, error) {
+	if m.readFromFn != nil {
+		return m.readFromFn(r)
+	}
+	return 0, nil
+}
+
+type mockClusterService struct {
+	apiAddr      string
+	nodeTimeFn   func(addr string) (time.Time, time.Duration, error)
+	nodeMetaFn   func(addr string) (*cluster.NodeMeta, error)
+	executeFn    func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error)
+	queryFn      func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error)
+	requestFn    func(eqr *command.ExecuteQueryRequest, nodeAddr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error)
+	backupFn     func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error
+	loadChunkFn  func(lcr *command.LoadChunkRequest, addr string, t time.Duration) error
+	removeNodeFn func(rn *command.RemoveNodeRequest, nodeAddr string, t time.Duration) error
+}
+
+func (m *mockClusterService) GetNodeAPIAddr(a string, t time.Duration) (string, error) {
+	return m.apiAddr, nil
+}
+
+func (m *mockClusterService) GetNodeTime(a string, t time.Duration) (time.Time, time.Duration, error) {
+	if m.nodeTimeFn != nil {
+		return m.nodeTimeFn(a)
+	}
+	return time.Now(), 0, nil
+}
+
+func (m *mockClusterService) GetNodeMeta(a string, t time.Duration) (*cluster.NodeMeta, error) {
+	if m.nodeMetaFn != nil {
+		return m.nodeMetaFn(a)
+	}
+	return &cluster.NodeMeta{Url: m.apiAddr}, nil
+}
+
+func (m *mockClusterService) Execute(er *command.ExecuteRequest, addr string, creds *cluster.Credentials, t time.Duration, r int) ([]*command.ExecuteResult, error) {
+	if m.executeFn != nil {
+		return m.executeFn(er, addr, t)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Query(qr *command.QueryRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.QueryRows, error) {
+	if m.queryFn != nil {
+		return m.queryFn(qr, addr, t)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Request(eqr *command.ExecuteQueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration, r int) ([]*command.ExecuteQueryResponse, error) {
+	if m.requestFn != nil {
+		return m.requestFn(eqr, nodeAddr, timeout)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Backup(br *command.BackupRequest, addr string, creds *cluster.Credentials, t time.Duration, w io.Writer) error {
+	if m.backupFn != nil {
+		return m.backupFn(br, addr, t, w)
+	}
+	return nil
+}
+
+func (m *mockClusterService) LoadChunk(lcr *command.LoadChunkRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) error {
+	if m.loadChunkFn != nil {
+		return m.loadChunkFn(lcr, nodeAddr, timeout)
+	}
+	return nil
+}
+
+func (m *mockClusterService) RemoveNode(rn *command.RemoveNodeRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
+	if m.removeNodeFn != nil {
+		return m.removeNodeFn(rn, addr, t)
+	}
+	return nil
+}
+
+type mockCredentialStore struct {
+	HasPermOK  bool
+	aaFunc     func(username, password, perm string) bool
+	maxRows    map[string]int64
+	queryLimit map[string]string
+	params     map[string]map[string]interface{}
+	tables     map[string]map[string][]string
+	rateLimits map[string]auth.RateLimit
+}
+
+func (m *mockCredentialStore) AA(username, password, perm string) bool {
+	if m == nil {
+		return true
+	}
+
+	if m.aaFunc != nil {
+		return m.aaFunc(username, password, perm)
+	}
+	return m.HasPermOK
+}
+
+func (m *mockCredentialStore) Authorized(username, perm string) bool {
+	if m == nil {
+		return true
+	}
+
+	if m.aaFunc != nil {
+		return m.aaFunc(username, "", perm)
+	}
+	return m.HasPermOK
+}
+
+func (m *mockCredentialStore) HasPermForResource(username, perm, resource string) bool {
+	if m == nil || m.tables == nil {
+		return true
+	}
+	scope, ok := m.tables[username][perm]
+	if !ok {
+		if scope, ok = m.tables[auth.AllUsers][perm]; !ok {
+			return true
+		}
+	}
+	for _, t := range scope {
+		if t == resource {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockCredentialStore) Check(username, password string) bool {
+	if m == nil {
+		return false
+	}
+	if m.aaFunc != nil {
+		return m.aaFunc(username, password, "")
+	}
+	return m.HasPermOK
+}
+
+func (m *mockCredentialStore) RateLimit(username string) (auth.RateLimit, bool) {
+	if m == nil || m.rateLimits == nil {
+		return auth.RateLimit{}, false
+	}
+	rl, ok := m.rateLimits[username]
+	return rl, ok
+}
+
+func (m *mockCredentialStore) Params(username string) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.params[username]
+}
+
+func (m *mockCredentialStore) MaxRows(username string) (int64, bool) {
+	if m == nil {
+		return 0, false
+	}
+	n, ok := m.maxRows[username]
+	return n, ok
+}
+
+func (m *mockCredentialStore) QueryLimit(username string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	mode, ok := m.queryLimit[username]
+	return mode, ok
+}
+
+type mockToken struct {
+	username string
+	perms    []string
+}
+
+type mockTokenValidator struct {
+	tokens map[string]mockToken
+}
+
+func (m *mockTokenValidator) Validate(token string) (string, []string, error) {
+	t, ok := m.tokens[token]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid token")
+	}
+	return t.username, t.perms, nil
+}
+
+func (m *mockClusterService) Stats() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+type mockStatusReporter struct {
+}
+
+func (m *mockStatusReporter) Stats() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func mustNewHTTPRequest(url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		panic("failed to create HTTP request for testing")
+	}
+	return req
+}
+
+func mustURLParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic("failed to URL parse string")
+	}
+	return u
+}
+
+func mustParseDuration(d string) time.Duration {
+	if dur, err := time.ParseDuration(d); err != nil {
+		panic(fmt.Sprintf("failed to parse duration %s: %s", d, err))
+	} else {
+		return dur
+	}
+}
+
+func mustGetQueryParams(req *http.Request) QueryParams {
+	qp, err := NewQueryParams(req)
+	if err != nil {
+		panic("failed to get query params")
+	}
+	return qp
+}
+
+func Test_QueryDebugSQL(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var stmts []*command.Statement
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		stmts = qr.Request.Statements
+		return []*command.QueryRows{{}, {}}, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `[["SELECT * FROM foo WHERE name=? AND age>?", "it's", 5],["SELECT * FROM foo WHERE id=:id", {"id": 7}]]`
+	resp, err := client.Post(host+"/db/query?debug_sql=true", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	var r struct {
+		DebugSQL *DebugSQL `json:"debug_sql"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("failed to unmarshal response: %s", err.Error())
+	}
+	if r.DebugSQL == nil {
+		t.Fatalf("debug SQL missing from response: %s", b)
+	}
+	if r.DebugSQL.Executable {
+		t.Fatalf("debug SQL marked as executable")
+	}
+	exp := []string{
+		`SELECT * FROM foo WHERE name='it''s' AND age>5`,
+		`SELECT * FROM foo WHERE id=7`,
+	}
+	if !reflect.DeepEqual(exp, r.DebugSQL.Statements) {
+		t.Fatalf("wrong debug SQL, exp %v, got %v", exp, r.DebugSQL.Statements)
+	}
+
+	// The statements sent to the store must be unchanged.
+	if len(stmts) != 2 || len(stmts[0].Parameters) != 2 || stmts[0].Sql != "SELECT * FROM foo WHERE name=? AND age>?" {
+		t.Fatalf("statements sent to store were modified: %v", stmts)
+	}
+
+	resp, err = client.Post(host+"/db/query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	b, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	if strings.Contains(string(b), "debug_sql") {
+		t.Fatalf("debug SQL present in response when not requested: %s", b)
+	}
+}
+
+func Test_MixedParametersBadRequest(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.DefaultQueueTimeout = time.Hour
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		t.Fatalf("execute called for request with mixed parameters")
+		return nil, nil
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		t.Fatalf("query called for request with mixed parameters")
+		return nil, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `[["INSERT INTO foo(name, age) VALUES(?, :age)", "alice", {"age": 30}]]`
+	for _, path := range []string{"/db/execute", "/db/execute?queue", "/db/query"} {
+		resp, err := client.Post(host+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for %s, got %d", path, resp.StatusCode)
+		}
+		if !strings.Contains(string(b), ErrMixedParameters.Error()) {
+			t.Fatalf("wrong error for %s: %s", path, b)
+		}
+	}
+
+	if n, _ := s.stmtQueue.Pending(); n != 0 {
+		t.Fatalf("request with mixed parameters queued, %d statements pending", n)
+	}
+}
+
+func Test_RequestDeadline(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	// This node is not the Leader, and finding the Leader is slow.
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return nil, store.ErrNotLeader
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, store.ErrNotLeader
+	}
+	m.requestFn = func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		return nil, store.ErrNotLeader
+	}
+	var delay atomic.Int64
+	delay.Store(int64(2 * time.Second))
+	m.leaderAddrFn = func() (string, error) {
+		time.Sleep(time.Duration(delay.Load()))
+		return "", nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `["INSERT INTO foo VALUES(1)"]`
+	for _, path := range []string{"/db/execute", "/db/query", "/db/request"} {
+		start := time.Now()
+		resp, err := client.Post(host+path+"?timeout=100ms", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestTimeout {
+			t.Fatalf("failed to get expected StatusRequestTimeout for %s, got %d", path, resp.StatusCode)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("request to %s took %s, deadline not respected", path, d)
+		}
+	}
+
+	// Without a timeout the request waits for leader resolution.
+	delay.Store(int64(200 * time.Millisecond))
+	resp, err := client.Post(host+"/db/execute", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d", resp.StatusCode)
+	}
+
+	// A write which has started is not abandoned at the deadline, so its
+	// outcome is reported.
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		time.Sleep(300 * time.Millisecond)
+		return []*command.ExecuteResult{{RowsAffected: 1}}, nil
+	}
+	m.requestFn = func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		time.Sleep(300 * time.Millisecond)
+		return []*command.ExecuteQueryResponse{{
+			Result: &command.ExecuteQueryResponse_E{E: &command.ExecuteResult{RowsAffected: 1}},
+		}}, nil
+	}
+	for _, path := range []string{"/db/execute", "/db/request"} {
+		resp, err := client.Post(host+path+"?timeout=100ms", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", path, resp.StatusCode)
+		}
+		if !strings.Contains(string(b), `"rows_affected":1`) {
+			t.Fatalf("write result not reported for %s, got %s", path, b)
+		}
+	}
+}
+
+func Test_QueryNDJSON(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := database.ExecuteStringStmt(fmt.Sprintf(`INSERT INTO foo VALUES(%d, "name%d")`, i, i)); err != nil {
+			t.Fatalf("failed to insert row: %s", err.Error())
+		}
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			t.Fatalf("materializing query called for NDJSON request")
+			return nil, nil
+		},
+		queryStreamFn: func(qr *command.QueryRequest, fn db.RowFunc) error {
+			return database.QueryStream(qr.Request.Statements[0], 0, fn)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func(path string, hdr map[string]string) (int, string, string) {
+		req, err := http.NewRequest("GET", host+path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+	}
+
+	exp := `{"id":1,"name":"name1"}
+{"id":2,"name":"name2"}
+{"id":3,"name":"name3"}
+`
+	q := "/db/query?q=" + url.QueryEscape("SELECT * FROM foo ORDER BY id")
+	for _, tt := range []struct {
+		path string
+		hdr  map[string]string
+	}{
+		{q + "&format=ndjson", nil},
+		{q, map[string]string{"Accept": "application/x-ndjson"}},
+	} {
+		code, ct, body := get(tt.path, tt.hdr)
+		if code != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", tt.path, code)
+		}
+		if ct != "application/x-ndjson" {
+			t.Fatalf("wrong Content-Type, got %s", ct)
+		}
+		if body != exp {
+			t.Fatalf("wrong NDJSON, exp:\n%s\ngot:\n%s", exp, body)
+		}
+	}
+
+	// Errors are reported as a final object.
+	code, _, body := get("/db/query?format=ndjson&q="+url.QueryEscape("SELECT * FROM bar"), nil)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", code)
+	}
+	if exp := `{"error":"no such table: bar"}` + "\n"; body != exp {
+		t.Fatalf("wrong NDJSON error, exp:\n%s\ngot:\n%s", exp, body)
+	}
+
+	// The row cap applies, and truncation is reported as a final object.
+	s.DefaultMaxRows = 2
+	code, _, body = get(q+"&format=ndjson", nil)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", code)
+	}
+	if exp := `{"id":1,"name":"name1"}
+{"id":2,"name":"name2"}
+{"truncated":true}
+`; body != exp {
+		t.Fatalf("wrong truncated NDJSON, exp:\n%s\ngot:\n%s", exp, body)
+	}
+	s.DefaultMaxRows = 0
+
+	// Invalid requests.
+	for _, path := range []string{
+		"/db/query?format=ndjson&level=strong&q=" + url.QueryEscape("SELECT * FROM foo"),
+	} {
+		if code, _, _ := get(path, nil); code != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for %s, got %d", path, code)
+		}
+	}
+	resp, err := client.Post(host+"/db/query?format=ndjson", "application/json",
+		strings.NewReader(`["SELECT * FROM foo", "SELECT * FROM foo"]`))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for multiple queries, got %d", resp.StatusCode)
+	}
+
+	// Reads which must be served by the Leader are forwarded.
+	m.queryStreamFn = func(qr *command.QueryRequest, fn db.RowFunc) error {
+		return store.ErrNotLeader
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, store.ErrNotLeader
+	}
+	m.leaderAddr = "leader:4002"
+	c.queryFn = func(qr *command.QueryRequest, addr string, timeout time.Duration) ([]*command.QueryRows, error) {
+		return database.Query(qr.Request, false)
+	}
+	code, _, body = get(q+"&format=ndjson&level=weak", nil)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", code)
+	}
+	if body != exp {
+		t.Fatalf("wrong forwarded NDJSON, exp:\n%s\ngot:\n%s", exp, body)
+	}
+}
+
+// failingWriter is a ResponseWriter whose writes fail once limit bytes have
+// been written, as if the client had gone away.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if f.Body.Len()+len(b) > f.limit {
+		return 0, errors.New("client gone")
+	}
+	return f.ResponseRecorder.Write(b)
+}
+
+func Test_QueryNDJSONClientWriteFails(t *testing.T) {
+	var nRead int
+	var streamErr error
+	m := &MockStore{
+		queryStreamFn: func(qr *command.QueryRequest, fn db.RowFunc) error {
+			for nRead = 0; nRead < 1000; nRead++ {
+				if streamErr = fn([]string{"id"}, []string{"integer"}, &command.Values{
+					Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(nRead)}}},
+				}); streamErr != nil {
+					return streamErr
+				}
+			}
+			return nil
 		},
-		{
-			name: "100ms",
-			url:  "http://localhost:4001/execute?db_timeout=100ms",
-			want: 100 * time.Millisecond,
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 100}
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/db/query?q=SELECT%20id%20FROM%20foo&format=ndjson", nil))
+	if streamErr == nil {
+		t.Fatalf("read was not ended by failed write")
+	}
+	if nRead >= 1000 {
+		t.Fatalf("all rows read despite failed write")
+	}
+	if strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("error object written after failed write: %s", w.Body.String())
+	}
+}
+
+// flushCounter is a ResponseWriter which counts calls to Flush, and the
+// NDJSON rows which had been written at the last call.
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes     int
+	flushedRows int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.flushedRows = strings.Count(f.Body.String(), "\n")
+	f.ResponseRecorder.Flush()
+}
+
+func Test_QueryNDJSONChunkRows(t *testing.T) {
+	// flushedRows records, as the rows are read, the number of rows which
+	// had been flushed to the client when each row was read.
+	var w *flushCounter
+	var flushedRows []int
+	m := &MockStore{
+		queryStreamFn: func(qr *command.QueryRequest, fn db.RowFunc) error {
+			for i := 0; i < 25; i++ {
+				flushedRows = append(flushedRows, w.flushedRows)
+				if err := fn([]string{"id"}, []string{"integer"}, &command.Values{
+					Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(i)}}},
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
 		},
-		{
-			name: "default value",
-			url:  "http://localhost:4001/execute",
-			want: 0,
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.StreamChunkRows = 10
+
+	for _, tt := range []struct {
+		path    string
+		chunk   int
+		flushes int
+	}{
+		{"/db/query?q=SELECT%20id%20FROM%20foo&format=ndjson", 10, 2},
+		{"/db/query?q=SELECT%20id%20FROM%20foo&format=ndjson&chunk_rows=5", 5, 5},
+		{"/db/query?q=SELECT%20id%20FROM%20foo&format=ndjson&chunk_rows=1", 1, 25},
+	} {
+		path := tt.path
+		w = &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+		flushedRows = nil
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", path, w.Code)
+		}
+		if got := strings.Count(w.Body.String(), "\n"); got != 25 {
+			t.Fatalf("wrong number of rows for %s, exp 25, got %d", path, got)
+		}
+		if w.flushes != tt.flushes {
+			t.Fatalf("wrong number of flushes for %s, exp %d, got %d", path, tt.flushes, w.flushes)
+		}
+
+		// Every complete chunk must have been flushed before the next row
+		// is read, not once the read has finished.
+		for i, n := range flushedRows {
+			if want := i / tt.chunk * tt.chunk; n != want {
+				t.Fatalf("wrong rows flushed for %s before reading row %d, exp %d, got %d", path, i, want, n)
+			}
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/db/query?q=SELECT%201&format=ndjson&chunk_rows=0", nil))
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for zero chunk rows, got %d", rw.Code)
+	}
+}
+
+func Test_MigratePreview(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	if _, err := database.ExecuteStringStmt(`INSERT INTO foo VALUES(1, "fiona")`); err != nil {
+		t.Fatalf("failed to insert row: %s", err.Error())
+	}
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			t.Fatalf("execute called for migration preview")
+			return nil, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `["ALTER TABLE foo ADD COLUMN age INTEGER", "CREATE INDEX foo_name ON foo(name)", "ALTER TABLE bar ADD COLUMN age INTEGER"]`
+	resp, err := client.Post(host+"/db/migrate/preview", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	exp := `{"results":[{},{},{"error":"no such table: bar"}],"schema":[` +
+		`{"type":"table","name":"foo","tbl_name":"foo","sql":"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)"},` +
+		`{"type":"index","name":"foo_name","tbl_name":"foo","sql":"CREATE INDEX foo_name ON foo(name)"}]}`
+	if got := string(b); got != exp {
+		t.Fatalf("wrong preview, exp:\n%s\ngot:\n%s", exp, got)
+	}
+
+	// The live database must be unaffected.
+	rows, err := database.QueryStringStmt(`SELECT sql FROM sqlite_master`)
+	if err != nil {
+		t.Fatalf("failed to query schema: %s", err.Error())
+	}
+	if exp, got := `[[CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)]]`, fmt.Sprintf("%v", valuesAsStrings(rows[0].Values)); exp != got {
+		t.Fatalf("live schema changed, exp %s, got %s", exp, got)
+	}
+	rows, err = database.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if n := rows[0].Values[0].Parameters[0].GetI(); n != 1 {
+		t.Fatalf("live data changed, exp 1 row, got %d", n)
+	}
+}
+
+func Test_MigratePreviewPerm(t *testing.T) {
+	m := &MockStore{}
+	creds := &mockCredentialStore{
+		aaFunc: func(username, password, perm string) bool {
+			return perm == auth.PermQuery
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, creds)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := http.Post(host+"/db/migrate/preview", "application/json", strings.NewReader(`["CREATE TABLE foo (id INTEGER)"]`))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("failed to get expected StatusUnauthorized without execute permission, got %d", resp.StatusCode)
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return database.Execute(er.Request, false)
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	migrate := func(body string) string {
+		t.Helper()
+		resp, err := http.Post(host+"/db/migrate", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return string(b)
+	}
+
+	first := `{"name":"0001_create","statements":["CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)"]}`
+	second := `{"name":"0002_seed","statements":[["INSERT INTO foo(name) VALUES(?)", "fiona"]]}`
+	third := `{"name":"0003_alter","statements":["ALTER TABLE foo ADD COLUMN age INTEGER", "ALTER TABLE bar ADD COLUMN age INTEGER"]}`
+	fourth := `{"name":"0004_index","statements":["CREATE INDEX foo_name ON foo(name)"]}`
+
+	if exp, got := `{"applied":["0001_create","0002_seed"],"skipped":[]}`, migrate("["+first+","+second+"]"); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+
+	// Re-running must change nothing.
+	if exp, got := `{"applied":[],"skipped":["0001_create","0002_seed"]}`, migrate("["+first+","+second+"]"); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+
+	// A failing migration stops application, and is rolled back.
+	if exp, got := `{"applied":[],"skipped":["0001_create","0002_seed"],"failed":"0003_alter","error":"statement 1: no such table: bar"}`,
+		migrate("["+first+","+second+","+third+","+fourth+"]"); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+	rows, err := database.QueryStringStmt(`SELECT name FROM schema_migrations ORDER BY name`)
+	if err != nil {
+		t.Fatalf("failed to query migrations: %s", err.Error())
+	}
+	if exp, got := `[[0001_create] [0002_seed]]`, fmt.Sprintf("%v", valuesAsStrings(rows[0].Values)); exp != got {
+		t.Fatalf("wrong migrations recorded, exp %s, got %s", exp, got)
+	}
+	rows, err = database.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := []string{"id", "name"}, rows[0].Columns; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("migration not rolled back, exp columns %s, got %s", exp, got)
+	}
+
+	// Once fixed, application resumes at the failed migration.
+	third = `{"name":"0003_alter","statements":["ALTER TABLE foo ADD COLUMN age INTEGER"]}`
+	if exp, got := `{"applied":["0003_alter","0004_index"],"skipped":["0001_create","0002_seed"]}`,
+		migrate("["+first+","+second+","+third+","+fourth+"]"); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+
+	// A migration recorded between the check and its application is skipped.
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{Columns: []string{"name"}}}, nil
+	}
+	if exp, got := `{"applied":[],"skipped":["0001_create","0002_seed","0003_alter","0004_index"]}`,
+		migrate("["+first+","+second+","+third+","+fourth+"]"); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+
+	resp, err := http.Post(host+"/db/migrate", "application/json", strings.NewReader(`[`+first+`,`+first+`]`))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for duplicate names, got %d", resp.StatusCode)
+	}
+}
+
+func Test_MigrateHooks(t *testing.T) {
+	var executed int
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{{Columns: []string{"name"}}}, nil
 		},
-		{
-			name:    "parse error",
-			url:     "http://localhost:4001/execute?db_timeout=xyz",
-			wantErr: true,
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed++
+			results := make([]*command.ExecuteResult, len(er.Request.Statements))
+			for i := range results {
+				results[i] = &command.ExecuteResult{RowsAffected: 1}
+			}
+			return results, nil
 		},
 	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.RegisterPreExecuteHook(func(r *http.Request, stmt *command.Statement) error {
+		if strings.HasPrefix(strings.ToUpper(stmt.Sql), "DELETE") {
+			return fmt.Errorf("DELETE not permitted")
+		}
+		return nil
+	})
+	var audited []string
+	s.RegisterPostExecuteHook(func(r *http.Request, stmts []*command.Statement, err error) {
+		for _, stmt := range stmts {
+			audited = append(audited, stmt.Sql)
+		}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mustURLParse(tt.url)
-			req, err := http.NewRequest("GET", tt.url, nil)
-			if err != nil {
-				t.Fatalf("failed to create request: %s", err)
-			}
-			qp, err := NewQueryParams(req)
-			if err != nil {
-				if !tt.wantErr {
-					t.Fatalf("failed to create request: %s", err)
-				}
-			}
+	migrate := func(body string) (int, string) {
+		resp, err := http.Post(host+"/db/migrate", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
 
-			got := qp.DBTimeout(0)
-			if got != tt.want {
-				t.Fatalf("want %d, got %d", tt.want, got)
-			}
-		})
+	// A statement rejected by a hook rejects every migration.
+	code, body := migrate(`[{"name":"0001_create","statements":["CREATE TABLE foo (id INTEGER PRIMARY KEY)"]},` +
+		`{"name":"0002_purge","statements":["DELETE FROM foo"]}]`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for rejected migration, got %d", code)
+	}
+	if !strings.Contains(body, "DELETE not permitted") {
+		t.Fatalf("hook error not returned, got %s", body)
+	}
+	if executed != 0 || len(audited) != 0 {
+		t.Fatalf("rejected migrations were executed")
 	}
-}
 
-type MockStore struct {
-	executeFn   func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
-	queryFn     func(qr *command.QueryRequest) ([]*command.QueryRows, error)
-	requestFn   func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error)
-	backupFn    func(br *command.BackupRequest, dst io.Writer) error
-	loadFn      func(lr *command.LoadRequest) error
-	readFromFn  func(r io.Reader) (int64, error)
-	committedFn func(timeout time.Duration) (uint64, error)
-	leaderAddr  string
-	notReady    bool // Default value is true, easier to test.
+	code, _ = migrate(`[{"name":"0001_create","statements":["CREATE TABLE foo (id INTEGER PRIMARY KEY)"]}]`)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for migration, got %d", code)
+	}
+	if exp := []string{"CREATE TABLE foo (id INTEGER PRIMARY KEY)"}; !reflect.DeepEqual(audited, exp) {
+		t.Fatalf("wrong statements audited, exp %v, got %v", exp, audited)
+	}
 }
 
-func (m *MockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
-	if m.executeFn != nil {
-		return m.executeFn(er)
+func Test_QueryPage(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for _, n := range []string{"fiona", "declan", "dana", "sinead", "aoife"} {
+		if _, err := database.ExecuteStringStmt(fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, n)); err != nil {
+			t.Fatalf("failed to insert row: %s", err.Error())
+		}
 	}
-	return nil, nil
-}
 
-func (m *MockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
-	if m.queryFn != nil {
-		return m.queryFn(qr)
+	m := &MockStore{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	var names []string
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		v := url.Values{}
+		v.Set("q", "SELECT id, name FROM foo WHERE name != 'dana'")
+		v.Set("page_size", "2")
+		v.Set("page_key", "id")
+		if token != "" {
+			v.Set("page_token", token)
+		}
+		resp, err := http.Get(host + "/db/query?" + v.Encode())
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		var r struct {
+			Results []struct {
+				Values [][]interface{} `json:"values"`
+				Error  string          `json:"error"`
+			} `json:"results"`
+			NextPageToken string `json:"next_page_token"`
+			Error         string `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode response: %s", err.Error())
+		}
+		if r.Error != "" || len(r.Results) != 1 || r.Results[0].Error != "" {
+			t.Fatalf("unexpected response: %+v", r)
+		}
+		for _, row := range r.Results[0].Values {
+			names = append(names, row[1].(string))
+		}
+		if r.NextPageToken == "" {
+			break
+		}
+		token = r.NextPageToken
+	}
+	if exp, got := []string{"fiona", "declan", "sinead", "aoife"}, names; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong rows paged, exp %v, got %v", exp, got)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		query string
+	}{
+		{"token for another key", "page_size=2&page_key=name&page_token=" + token},
+		{"bad token", "page_size=2&page_key=id&page_token=xyz"},
+		{"multiple queries", "page_size=2&page_key=id&q=SELECT+1&q=SELECT+2"},
+		{"rollup", "page_size=2&page_key=id&rollup=count(*)"},
+		{"csv", "page_size=2&page_key=id&format=csv"},
+	} {
+		q := tt.query
+		if !strings.Contains(q, "q=") {
+			q += "&q=SELECT+*+FROM+foo"
+		}
+		resp, err := http.Get(host + "/db/query?" + q)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: exp status %d, got %d", tt.name, http.StatusBadRequest, resp.StatusCode)
+		}
 	}
-	return nil, nil
 }
 
-func (m *MockStore) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
-	if m.requestFn != nil {
-		return m.requestFn(eqr)
+func valuesAsStrings(values []*command.Values) [][]string {
+	out := make([][]string, len(values))
+	for i, v := range values {
+		for _, p := range v.Parameters {
+			out[i] = append(out[i], p.GetS())
+		}
 	}
-	return nil, nil
+	return out
 }
 
-func (m *MockStore) Join(jr *command.JoinRequest) error {
-	return nil
+func Test_CompressResponses(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.CompressResponses = true
+	s.BuildInfo = map[string]interface{}{
+		"version": "the version",
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	nRows := 0
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		rows := &command.QueryRows{
+			Columns: []string{"name"},
+			Types:   []string{"text"},
+		}
+		for i := 0; i < nRows; i++ {
+			rows.Values = append(rows.Values, &command.Values{
+				Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: "fiona"}}},
+			})
+		}
+		return []*command.QueryRows{rows}, nil
+	}
+	m.backupFn = func(br *command.BackupRequest, dst io.Writer) error {
+		_, err := dst.Write(bytes.Repeat([]byte("x"), 10000))
+		return err
+	}
+
+	// Disable the transport's own compression, so the raw response is seen.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func(path, acceptEncoding string) *http.Response {
+		req, err := http.NewRequest("GET", host+path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		return resp
+	}
+
+	nRows = 1000
+	resp := get("/db/query?q=SELECT%20*%20FROM%20foo", "gzip")
+	defer resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("wrong Content-Encoding, exp gzip, got %q", ce)
+	}
+	if v := resp.Header.Get(VersionHTTPHeader); v != "the version" {
+		t.Fatalf("incorrect build version present in HTTP response header, got: %s", v)
+	}
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %s", err.Error())
+	}
+	b, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %s", err.Error())
+	}
+	var r map[string]interface{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("failed to unmarshal decompressed response: %s", err.Error())
+	}
+
+	// Not compressed if the client does not accept it.
+	resp = get("/db/query?q=SELECT%20*%20FROM%20foo", "")
+	resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Fatalf("response compressed for client not accepting it: %s", ce)
+	}
+
+	// Not compressed if below the minimum size.
+	nRows = 1
+	resp = get("/db/query?q=SELECT%20*%20FROM%20foo", "gzip")
+	resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Fatalf("small response compressed: %s", ce)
+	}
+
+	// Backups are compressed, unless already compressed at the client's request.
+	resp = get("/db/backup", "gzip")
+	resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("wrong Content-Encoding for backup, exp gzip, got %q", ce)
+	}
+	resp = get("/db/backup?compress=zstd", "gzip")
+	resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "zstd" {
+		t.Fatalf("wrong Content-Encoding for compressed backup, exp zstd, got %q", ce)
+	}
 }
 
-func (m *MockStore) Notify(nr *command.NotifyRequest) error {
-	return nil
+func Test_QueryFormatCSV(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{
+			{
+				Columns: []string{"id", "name"},
+				Types:   []string{"integer", "text"},
+				Values: []*command.Values{
+					{
+						Parameters: []*command.Parameter{
+							{Value: &command.Parameter_I{I: 1}},
+							{Value: &command.Parameter_S{S: "fiona"}},
+						},
+					},
+					{
+						Parameters: []*command.Parameter{
+							{Value: &command.Parameter_I{I: 2}},
+							{Value: nil},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/db/query?q=SELECT%20*%20FROM%20foo&format=csv")
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("wrong Content-Type, got %s", ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "filename=") {
+		t.Fatalf("wrong Content-Disposition, got %s", cd)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	exp := "\"id\",\"name\"\r\n1,\"fiona\"\r\n2,\r\n"
+	if got := string(body); got != exp {
+		t.Fatalf("wrong CSV, exp:\n%q\ngot:\n%q", exp, got)
+	}
+
+	resp, err = client.Post(host+"/db/query?format=csv", "application/json",
+		strings.NewReader(`["SELECT * FROM foo", "SELECT * FROM bar"]`))
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for multiple queries, got %d", resp.StatusCode)
+	}
 }
 
-func (m *MockStore) Remove(rn *command.RemoveNodeRequest) error {
-	return nil
+func Test_QueryReadConsistencyHeader(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	var level command.QueryRequest_Level
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		level = qr.Level
+		return []*command.QueryRows{{}}, nil
+	}
+	m.requestFn = func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		level = eqr.Level
+		return nil, nil
+	}
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	for _, tt := range []struct {
+		path     string
+		expLevel command.QueryRequest_Level
+		expHdr   string
+	}{
+		{"/db/query?q=SELECT%201&level=none", command.QueryRequest_QUERY_REQUEST_LEVEL_NONE, "none"},
+		{"/db/query?q=SELECT%201&level=weak", command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, ""},
+		{"/db/query?q=SELECT%201&level=strong", command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG, ""},
+		{"/db/query?q=SELECT%201", command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, ""},
+		{"/db/request?level=none", command.QueryRequest_QUERY_REQUEST_LEVEL_NONE, "none"},
+		{"/db/request", command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, ""},
+	} {
+		var resp *http.Response
+		var err error
+		if strings.HasPrefix(tt.path, "/db/request") {
+			resp, err = http.Post(host+tt.path, "application/json", strings.NewReader(`["SELECT 1"]`))
+		} else {
+			resp, err = http.Get(host + tt.path)
+		}
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected StatusOK for %s, got %d", tt.path, resp.StatusCode)
+		}
+		if level != tt.expLevel {
+			t.Fatalf("wrong level for %s, exp %s, got %s", tt.path, tt.expLevel, level)
+		}
+		if got := resp.Header.Get(ReadConsistencyHTTPHeader); got != tt.expHdr {
+			t.Fatalf("wrong %s header for %s, exp %q, got %q", ReadConsistencyHTTPHeader, tt.path, tt.expHdr, got)
+		}
+	}
 }
 
-func (m *MockStore) LeaderAddr() (string, error) {
-	return m.leaderAddr, nil
+func Test_QueryFreshness(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		notLeader:  true,
+		staleness:  7 * time.Second,
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		if qr.Freshness != (5 * time.Second).Nanoseconds() {
+			return nil, fmt.Errorf("wrong freshness %d", qr.Freshness)
+		}
+		return nil, store.ErrStaleRead
+	}
+	var leaderQueries atomic.Int64
+	c := &mockClusterService{
+		queryFn: func(qr *command.QueryRequest, addr string, timeout time.Duration) ([]*command.QueryRows, error) {
+			leaderQueries.Add(1)
+			return []*command.QueryRows{{Columns: []string{"leader"}, Types: []string{"text"}}}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(host + path)
+		if err != nil {
+			t.Fatalf("failed to make query request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp, string(b)
+	}
+
+	// A read this node cannot serve within the freshness goes to the Leader.
+	resp, body := get("/db/query?q=SELECT%201&level=none&freshness=5s")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", resp.StatusCode, body)
+	}
+	if exp := `{"results":[{"columns":["leader"],"types":["text"]}]}`; body != exp {
+		t.Fatalf("wrong body, exp %s, got %s", exp, body)
+	}
+	if exp, got := "node1:4002", resp.Header.Get(ServedByHTTPHeader); exp != got {
+		t.Fatalf("wrong served-by header, exp %s, got %s", exp, got)
+	}
+	if resp.Header.Get(ReadConsistencyHTTPHeader) != "" {
+		t.Fatalf("forwarded read marked as possibly stale")
+	}
+	if exp, got := int64(1), leaderQueries.Load(); exp != got {
+		t.Fatalf("wrong number of Leader queries, exp %d, got %d", exp, got)
+	}
+
+	// Unless forwarding is disabled.
+	resp, body = get("/db/query?q=SELECT%201&level=none&freshness=5s&noforward")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable, got %d: %s", resp.StatusCode, body)
+	}
+	if exp := "stale read: node is 7s stale, exceeding freshness of 5s\n"; body != exp {
+		t.Fatalf("wrong body, exp %q, got %q", exp, body)
+	}
+	if exp, got := int64(1), leaderQueries.Load(); exp != got {
+		t.Fatalf("wrong number of Leader queries, exp %d, got %d", exp, got)
+	}
 }
 
-func (m *MockStore) Ready() bool {
-	return !m.notReady
-}
+func Test_QueryInterrupted(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
 
-func (m *MockStore) Committed(timeout time.Duration) (uint64, error) {
-	if m.committedFn != nil {
-		return m.committedFn(timeout)
+	interrupted := make(chan error, 1)
+	m.queryContextFn = func(ctx context.Context, qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		<-ctx.Done()
+		interrupted <- ctx.Err()
+		return []*command.QueryRows{{Error: "interrupted"}}, nil
 	}
-	return 0, nil
-}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-func (m *MockStore) Stats() (map[string]interface{}, error) {
-	return nil, nil
-}
+	// A query running when the request's deadline passes is interrupted.
+	resp, err := http.Get(host + "/db/query?q=SELECT%201&timeout=100ms")
+	if err != nil {
+		t.Fatalf("failed to make query request: %s", err.Error())
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("failed to get expected StatusRequestTimeout, got %d: %s", resp.StatusCode, b)
+	}
+	if exp, got := "request deadline exceeded\n", string(b); exp != got {
+		t.Fatalf("wrong body, exp %q, got %q", exp, got)
+	}
+	select {
+	case err := <-interrupted:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("wrong interruption cause, exp %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for query to be interrupted")
+	}
 
-func (m *MockStore) Nodes() ([]*store.Server, error) {
-	return nil, nil
+	// As is a query whose client disconnects.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", host+"/db/query?q=SELECT%201", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatalf("expected error for cancelled request")
+	}
+	select {
+	case err := <-interrupted:
+		if err != context.Canceled {
+			t.Fatalf("wrong interruption cause, exp %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for query to be interrupted")
+	}
 }
 
-func (m *MockStore) Backup(br *command.BackupRequest, w io.Writer) error {
-	if m.backupFn == nil {
-		return nil
+func Test_MaxStatementBytes(t *testing.T) {
+	var executed int
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed += len(er.Request.Statements)
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
 	}
-	return m.backupFn(br, w)
-}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.MaxStatementBytes = 40
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
 
-func (m *MockStore) Load(lr *command.LoadRequest) error {
-	if m.loadFn != nil {
-		return m.loadFn(lr)
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path, body string) int {
+		resp, err := http.Post(host+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
 	}
-	return nil
-}
 
-func (m *MockStore) ReadFrom(r io.Reader) (int64, error) {
-	if m.readFromFn != nil {
-		return m.readFromFn(r)
+	// Many small statements may together exceed the limit.
+	small := make([]string, 50)
+	for i := range small {
+		small[i] = fmt.Sprintf("INSERT INTO foo VALUES(%d)", i)
+	}
+	if code := post("/db/execute", string(mustJSONMarshal(small))); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for small statements, got %d", code)
+	}
+	if executed != 50 {
+		t.Fatalf("wrong number of statements executed, exp 50, got %d", executed)
 	}
-	return 0, nil
-}
 
-type mockClusterService struct {
-	apiAddr      string
-	executeFn    func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error)
-	queryFn      func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error)
-	requestFn    func(eqr *command.ExecuteQueryRequest, nodeAddr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error)
-	backupFn     func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error
-	loadFn       func(lr *command.LoadRequest, addr string, t time.Duration) error
-	removeNodeFn func(rn *command.RemoveNodeRequest, nodeAddr string, t time.Duration) error
-}
+	large := `["INSERT INTO foo VALUES(1)", "INSERT INTO foo(name) VALUES('` + strings.Repeat("x", 40) + `')"]`
+	for _, path := range []string{"/db/execute", "/db/query", "/db/request", "/db/execute?queue"} {
+		if code := post(path, large); code != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for large statement on %s, got %d", path, code)
+		}
+	}
+	if executed != 50 {
+		t.Fatalf("over-limit request was executed")
+	}
 
-func (m *mockClusterService) GetNodeAPIAddr(a string, t time.Duration) (string, error) {
-	return m.apiAddr, nil
+	resp, err := http.Get(host + "/db/query?q=" + url.QueryEscape("SELECT '"+strings.Repeat("x", 40)+"'"))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for large GET query, got %d", resp.StatusCode)
+	}
 }
 
-func (m *mockClusterService) Execute(er *command.ExecuteRequest, addr string, creds *cluster.Credentials, t time.Duration, r int) ([]*command.ExecuteResult, error) {
-	if m.executeFn != nil {
-		return m.executeFn(er, addr, t)
+func Test_MaxStatements(t *testing.T) {
+	var executed, queried int
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed += len(er.Request.Statements)
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			queried += len(qr.Request.Statements)
+			return []*command.QueryRows{}, nil
+		},
 	}
-	return nil, nil
-}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if s.MaxStatements != DefaultMaxStatements {
+		t.Fatalf("wrong default max statements, exp %d, got %d", DefaultMaxStatements, s.MaxStatements)
+	}
+	s.MaxStatements = 3
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
 
-func (m *mockClusterService) Query(qr *command.QueryRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.QueryRows, error) {
-	if m.queryFn != nil {
-		return m.queryFn(qr, addr, t)
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path string, stmts []string) int {
+		resp, err := http.Post(host+path, "application/json", bytes.NewReader(mustJSONMarshal(stmts)))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
 	}
-	return nil, nil
-}
 
-func (m *mockClusterService) Request(eqr *command.ExecuteQueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration, r int) ([]*command.ExecuteQueryResponse, error) {
-	if m.requestFn != nil {
-		return m.requestFn(eqr, nodeAddr, timeout)
+	stmts := []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 4"}
+	if code := post("/db/execute", stmts[:3]); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK at limit, got %d", code)
+	}
+	if code := post("/db/query", stmts[:3]); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK at limit, got %d", code)
+	}
+	for _, path := range []string{"/db/execute", "/db/query", "/db/request", "/db/execute?queue"} {
+		if code := post(path, stmts); code != http.StatusBadRequest {
+			t.Fatalf("failed to get expected StatusBadRequest for too many statements on %s, got %d", path, code)
+		}
+	}
+	if executed != 3 || queried != 3 {
+		t.Fatalf("over-limit request was executed, executed %d, queried %d", executed, queried)
 	}
-	return nil, nil
-}
 
-func (m *mockClusterService) Backup(br *command.BackupRequest, addr string, creds *cluster.Credentials, t time.Duration, w io.Writer) error {
-	if m.backupFn != nil {
-		return m.backupFn(br, addr, t, w)
+	resp, err := http.Get(host + "/db/query?q=" + url.QueryEscape("SELECT 1; SELECT 2; SELECT 3; SELECT 4"))
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for too many GET queries, got %d", resp.StatusCode)
 	}
-	return nil
-}
 
-func (m *mockClusterService) Load(lr *command.LoadRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration, r int) error {
-	if m.loadFn != nil {
-		return m.loadFn(lr, nodeAddr, timeout)
+	s.MaxStatements = 0
+	if code := post("/db/execute", stmts); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK with no limit, got %d", code)
 	}
-	return nil
 }
 
-func (m *mockClusterService) RemoveNode(rn *command.RemoveNodeRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
-	if m.removeNodeFn != nil {
-		return m.removeNodeFn(rn, addr, t)
+func Test_RateLimit(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return []*command.ExecuteResult{}, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{}, nil
+		},
 	}
-	return nil
-}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.RateLimitReads = 1
+	s.RateLimitWrites = 1
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
 
-type mockCredentialStore struct {
-	HasPermOK bool
-	aaFunc    func(username, password, perm string) bool
-}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	do := func(path, username string) *http.Response {
+		req, err := http.NewRequest("POST", host+path, bytes.NewReader(mustJSONMarshal([]string{"SELECT 1"})))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if username != "" {
+			req.SetBasicAuth(username, "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
 
-func (m *mockCredentialStore) AA(username, password, perm string) bool {
-	if m == nil {
-		return true
+	if resp := do("/db/query", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for first read, got %d", resp.StatusCode)
+	}
+	resp := do("/db/query", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("failed to get expected StatusTooManyRequests for second read, got %d", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "1" {
+		t.Fatalf("wrong Retry-After header, exp 1, got %s", ra)
+	}
+	if resp := do("/db/execute", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("write limited by read rate, got %d", resp.StatusCode)
+	}
+	if resp := do("/db/execute", ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("failed to get expected StatusTooManyRequests for second write, got %d", resp.StatusCode)
+	}
+	resp, err := http.Get(host + "/status")
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status request was rate limited, got %d", resp.StatusCode)
 	}
 
-	if m.aaFunc != nil {
-		return m.aaFunc(username, password, perm)
+	// Authenticated users are limited apart from their IP address, at any
+	// rate configured for them.
+	s.credentialStore = &mockCredentialStore{
+		HasPermOK:  true,
+		rateLimits: map[string]auth.RateLimit{"alice": {}},
+	}
+	for i := 0; i < 5; i++ {
+		if resp := do("/db/query", "alice"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("user without limit was rate limited, got %d", resp.StatusCode)
+		}
+	}
+	if resp := do("/db/query", "bob"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for first read by user, got %d", resp.StatusCode)
+	}
+	if resp := do("/db/query", "bob"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("failed to get expected StatusTooManyRequests for second read by user, got %d", resp.StatusCode)
 	}
-	return m.HasPermOK
 }
 
-func (m *mockClusterService) Stats() (map[string]interface{}, error) {
-	return nil, nil
-}
+func Test_ConditionalExecute(t *testing.T) {
+	var required []bool
+	conflict := func(stmts []*command.Statement) bool {
+		required = nil
+		for _, stmt := range stmts {
+			required = append(required, stmt.RequireRows)
+		}
+		return strings.Contains(stmts[len(stmts)-1].Sql, "version=1")
+	}
+	m := &MockStore{
+		leaderAddr: "node1:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			if conflict(er.Request.Statements) {
+				return []*command.ExecuteResult{{}, {Error: db.ErrNoRowsAffected.Error()}}, nil
+			}
+			return []*command.ExecuteResult{{}, {RowsAffected: 1}}, nil
+		},
+		requestFn: func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+			if conflict(eqr.Request.Statements) {
+				return []*command.ExecuteQueryResponse{{
+					Result: &command.ExecuteQueryResponse_E{
+						E: &command.ExecuteResult{Error: db.ErrNoRowsAffected.Error()},
+					},
+				}}, nil
+			}
+			return []*command.ExecuteQueryResponse{}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
 
-type mockStatusReporter struct {
-}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path string, version int) int {
+		stmts := []string{
+			"CREATE TABLE IF NOT EXISTS foo (id INTEGER PRIMARY KEY, version INTEGER)",
+			fmt.Sprintf("UPDATE foo SET version=version+1 WHERE id=1 AND version=%d", version),
+		}
+		resp, err := http.Post(host+path, "application/json", bytes.NewReader(mustJSONMarshal(stmts)))
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
 
-func (m *mockStatusReporter) Stats() (map[string]interface{}, error) {
-	return nil, nil
+	if code := post("/db/execute?conditional", 1); code != http.StatusConflict {
+		t.Fatalf("failed to get expected StatusConflict, got %d", code)
+	}
+	if !reflect.DeepEqual(required, []bool{false, true}) {
+		t.Fatalf("wrong statements required to change rows: %v", required)
+	}
+	if code := post("/db/execute?conditional", 2); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d", code)
+	}
+	if code := post("/db/execute", 1); code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK without conditional, got %d", code)
+	}
+	if !reflect.DeepEqual(required, []bool{false, false}) {
+		t.Fatalf("statements required to change rows without conditional: %v", required)
+	}
+	if code := post("/db/request?conditional", 1); code != http.StatusConflict {
+		t.Fatalf("failed to get expected StatusConflict for request, got %d", code)
+	}
+	if code := post("/db/execute?conditional&queue", 1); code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for queued conditional execute, got %d", code)
+	}
 }
 
-func mustNewHTTPRequest(url string) *http.Request {
-	req, err := http.NewRequest("GET", url, nil)
+func Test_Import(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
 	if err != nil {
-		panic("failed to create HTTP request for testing")
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT NOT NULL, age INTEGER, score REAL)`); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
 	}
-	return req
-}
 
-func mustURLParse(s string) *url.URL {
-	u, err := url.Parse(s)
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return database.Execute(er.Request, false)
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return database.Query(qr.Request, false)
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(host+path, "text/csv", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to make import request: %s", err.Error())
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err.Error())
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	csv := "id,Full Name,age,score,notes\n1,fiona,20,1.5,x\n2,declan,,2,x\n1,dup,30,3,x\n3,,40,4,x\n4,\"sinead\nmary\",abc,5,x\n"
+	code, body := post("/db/import?table=foo&batch_size=2&"+url.Values{"columns": {"Full Name:name,notes:"}}.Encode(), csv)
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if exp := `{"rows":3,"failed":2,"errors":[{"line":4,"error":"UNIQUE constraint failed: foo.id"},{"line":5,"error":"NOT NULL constraint failed: foo.name"}]}`; body != exp {
+		t.Fatalf("wrong import response\nexp: %s\ngot: %s", exp, body)
+	}
+	resp, err := http.Get(host + "/db/query?q=" + url.QueryEscape("SELECT id, name, age, typeof(age), score, typeof(score) FROM foo"))
 	if err != nil {
-		panic("failed to URL parse string")
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err.Error())
+	}
+	if exp := `"values":[[1,"fiona",20,"integer",1.5,"real"],[2,"declan",null,"null",2,"real"],[4,"sinead\nmary","abc","text",5,"real"]]`; !strings.Contains(string(b), exp) {
+		t.Fatalf("wrong rows imported\nexp: %s\ngot: %s", exp, b)
 	}
-	return u
-}
 
-func mustParseDuration(d string) time.Duration {
-	if dur, err := time.ParseDuration(d); err != nil {
-		panic(fmt.Sprintf("failed to parse duration %s: %s", d, err))
-	} else {
-		return dur
+	for _, tt := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/db/import", "id\n1\n", http.StatusBadRequest},
+		{"/db/import?table=bar", "id\n1\n", http.StatusNotFound},
+		{"/db/import?table=foo", "", http.StatusBadRequest},
+		{"/db/import?table=foo", "id,colour\n1,red\n", http.StatusBadRequest},
+		{"/db/import?table=foo&batch_size=0", "id\n1\n", http.StatusBadRequest},
+		{"/db/import?table=foo&columns=bad", "id\n1\n", http.StatusBadRequest},
+	} {
+		if code, body := post(tt.path, tt.body); code != tt.code {
+			t.Fatalf("wrong status for %s, exp %d, got %d: %s", tt.path, tt.code, code, body)
+		}
 	}
-}
 
-func mustGetQueryParams(req *http.Request) QueryParams {
-	qp, err := NewQueryParams(req)
+	resp, err = http.Get(host + "/db/import?table=foo")
 	if err != nil {
-		panic("failed to get query params")
+		t.Fatalf("failed to make import request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("failed to get expected StatusMethodNotAllowed, got %d", resp.StatusCode)
+	}
+
+	// Each batch is passed to the execute hooks, which may reject it.
+	var audited int
+	s.RegisterPostExecuteHook(func(r *http.Request, stmts []*command.Statement, err error) {
+		audited += len(stmts)
+	})
+	code, body = post("/db/import?table=foo&batch_size=2", "id,name\n10,aoife\n11,dana\n12,eve\n")
+	if code != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK, got %d: %s", code, body)
+	}
+	if audited != 2 {
+		t.Fatalf("wrong number of batches audited, exp 2, got %d", audited)
+	}
+	s.RegisterPreExecuteHook(func(r *http.Request, stmt *command.Statement) error {
+		return fmt.Errorf("imports not permitted")
+	})
+	code, body = post("/db/import?table=foo", "id,name\n20,orla\n")
+	if code != http.StatusBadRequest {
+		t.Fatalf("failed to get expected StatusBadRequest for rejected import, got %d: %s", code, body)
+	}
+	if !strings.Contains(body, "imports not permitted") {
+		t.Fatalf("hook error not returned, got %s", body)
+	}
+	if audited != 2 {
+		t.Fatalf("rejected import was audited")
 	}
-	return qp
 }
@@ -6,13 +6,18 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/rqlite/rqlite/v8/auth"
+	command "github.com/rqlite/rqlite/v8/command/proto"
 	"github.com/rqlite/rqlite/v8/rtls"
+	"github.com/rqlite/rqlite/v8/store"
 	"golang.org/x/net/http2"
 )
 
@@ -54,6 +59,9 @@ func Test_TLSServiceInsecure(t *testing.T) {
 	if v := resp.Header.Get("X-RQLITE-VERSION"); v != "the version" {
 		t.Fatalf("incorrect build version present in HTTP response header, got: %s", v)
 	}
+	if v := resp.Header.Get(StrictTransportSecurityHeader); v != defaultHSTS {
+		t.Fatalf("incorrect HSTS header present in HTTP response header, got: %s", v)
+	}
 
 	// Test connecting with an HTTP/2 client.
 	client = &http.Client{
@@ -228,9 +236,273 @@ func Test_TLSServiceSecureMutual(t *testing.T) {
 	}
 }
 
+func Test_TLSServiceClientCertAuth(t *testing.T) {
+	caCertPEM, caKeyPEM, err := rtls.GenerateCACert(pkix.Name{CommonName: "ca.rqlite.io"}, time.Hour, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate CA cert: %s", err)
+	}
+	caCert, _ := pem.Decode(caCertPEM)
+	caKey, _ := pem.Decode(caKeyPEM)
+	if caCert == nil || caKey == nil {
+		t.Fatal("failed to decode CA certificate or key")
+	}
+	parsedCACert, err := x509.ParseCertificate(caCert.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedCAKey, err := x509.ParsePKCS1PrivateKey(caKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certServer, keyServer, err := rtls.GenerateCertIPSAN(pkix.Name{CommonName: "server.rqlite.io"}, time.Hour, 2048, parsedCACert, parsedCAKey, net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("failed to generate server cert: %s", err)
+	}
+
+	credentialStore := auth.NewCredentialsStore()
+	if err := credentialStore.Load(strings.NewReader(`[
+		{"username": "alice", "perms": ["status"]},
+		{"username": "bob", "password": "secret", "perms": ["ready"]}
+	]`)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err)
+	}
+
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, credentialStore)
+	s.CertFile = mustWriteTempFile(t, certServer)
+	s.KeyFile = mustWriteTempFile(t, keyServer)
+	s.ClientCACertFile = mustWriteTempFile(t, caCertPEM)
+	s.ClientCertAuth = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	url := fmt.Sprintf("https://%s/status", s.Addr().String())
+
+	newClient := func(cn string) *http.Client {
+		tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+		tlsConfig.RootCAs.AppendCertsFromPEM(caCertPEM)
+		if cn != "" {
+			certClient, keyClient, err := rtls.GenerateCert(pkix.Name{CommonName: cn}, time.Hour, 2048, parsedCACert, parsedCAKey)
+			if err != nil {
+				t.Fatalf("failed to generate client cert: %s", err)
+			}
+			pair, err := tls.X509KeyPair(certClient, keyClient)
+			if err != nil {
+				t.Fatalf("failed to set X509 key pair %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		cn       string
+		username string
+		password string
+		exp      int
+	}{
+		{"authorized certificate", "alice", "", "", http.StatusOK},
+		{"certificate without perm", "bob", "", "", http.StatusUnauthorized},
+		{"unknown certificate", "mallory", "", "", http.StatusUnauthorized},
+		{"certificate overrides basic auth", "bob", "alice", "", http.StatusUnauthorized},
+		{"no certificate", "", "", "", http.StatusUnauthorized},
+		{"no certificate, basic auth without perm", "", "bob", "secret", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		if tt.username != "" {
+			req.SetBasicAuth(tt.username, tt.password)
+		}
+		resp, err := newClient(tt.cn).Do(req)
+		if err != nil {
+			t.Fatalf("%s: failed to make HTTP request: %s", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.exp {
+			t.Fatalf("%s: wrong status code, exp %d, got %d", tt.name, tt.exp, resp.StatusCode)
+		}
+		if h := resp.Header.Get("WWW-Authenticate"); (h != "") != (tt.exp == http.StatusUnauthorized && tt.cn == "") {
+			t.Fatalf("%s: unexpected challenge %q", tt.name, h)
+		}
+	}
+}
+
+func Test_TLSServiceClientCertAuthForward(t *testing.T) {
+	caCertPEM, caKeyPEM, err := rtls.GenerateCACert(pkix.Name{CommonName: "ca.rqlite.io"}, time.Hour, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate CA cert: %s", err)
+	}
+	caCert, _ := pem.Decode(caCertPEM)
+	caKey, _ := pem.Decode(caKeyPEM)
+	if caCert == nil || caKey == nil {
+		t.Fatal("failed to decode CA certificate or key")
+	}
+	parsedCACert, err := x509.ParseCertificate(caCert.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedCAKey, err := x509.ParsePKCS1PrivateKey(caKey.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certServer, keyServer, err := rtls.GenerateCertIPSAN(pkix.Name{CommonName: "server.rqlite.io"}, time.Hour, 2048, parsedCACert, parsedCAKey, net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("failed to generate server cert: %s", err)
+	}
+	certClient, keyClient, err := rtls.GenerateCert(pkix.Name{CommonName: "alice"}, time.Hour, 2048, parsedCACert, parsedCAKey)
+	if err != nil {
+		t.Fatalf("failed to generate client cert: %s", err)
+	}
+	pair, err := tls.X509KeyPair(certClient, keyClient)
+	if err != nil {
+		t.Fatalf("failed to set X509 key pair %s", err)
+	}
+
+	credentialStore := auth.NewCredentialsStore()
+	if err := credentialStore.Load(strings.NewReader(`[
+		{"username": "alice", "perms": ["execute"]},
+		{"username": "bob", "password": "secret", "perms": ["execute"]}
+	]`)); err != nil {
+		t.Fatalf("failed to load credentials: %s", err)
+	}
+
+	// This node is a follower, so every execute must be forwarded.
+	m := &MockStore{
+		leaderAddr: "leader:4002",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return nil, store.ErrNotLeader
+		},
+	}
+	var forwarded int
+	c := &mockClusterService{
+		apiAddr: "http://leader:4001",
+		executeFn: func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error) {
+			forwarded++
+			return []*command.ExecuteResult{{}}, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, c, credentialStore)
+	s.CertFile = mustWriteTempFile(t, certServer)
+	s.KeyFile = mustWriteTempFile(t, keyServer)
+	s.ClientCACertFile = mustWriteTempFile(t, caCertPEM)
+	s.ClientCertAuth = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+	tlsConfig.RootCAs.AppendCertsFromPEM(caCertPEM)
+	tlsConfig.Certificates = []tls.Certificate{pair}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	url := fmt.Sprintf("https://%s/db/execute", s.Addr().String())
+
+	// A request authenticated by certificate cannot be checked by the
+	// Leader, so the client is told to send it to the Leader instead.
+	resp, err := client.Post(url, "application/json", strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+	if err != nil {
+		t.Fatalf("failed to make HTTP request: %s", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusMisdirectedRequest, resp.StatusCode)
+	}
+	if !strings.Contains(string(b), "send the request to the Leader") {
+		t.Fatalf("wrong response body: %s", b)
+	}
+	if h := resp.Header.Get("WWW-Authenticate"); h != "" {
+		t.Fatalf("unexpected challenge %q", h)
+	}
+	if h := resp.Header.Get(LeaderHTTPHeader); h != "http://leader:4001" {
+		t.Fatalf("wrong Leader header, got %q", h)
+	}
+	if forwarded != 0 {
+		t.Fatalf("request authenticated by certificate was forwarded")
+	}
+
+	// Basic Auth credentials are still forwarded.
+	tlsConfig.Certificates = nil
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	req, err := http.NewRequest("POST", url, strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	req.SetBasicAuth("bob", "secret")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make HTTP request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for Basic Auth, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if forwarded != 1 {
+		t.Fatalf("request authenticated by Basic Auth was not forwarded")
+	}
+}
+
 // mustWriteTempFile writes the given bytes to a temporary file, and returns the
 // path to the file. If there is an error, it panics. The file will be automatically
 // deleted when the test ends.
+func Test_TLSServiceSNI(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+
+	newCert := func(cn string) (string, string) {
+		cert, key, err := rtls.GenerateSelfSignedCert(pkix.Name{CommonName: cn}, time.Hour, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate self-signed cert: %s", err)
+		}
+		return mustWriteTempFile(t, cert), mustWriteTempFile(t, key)
+	}
+	s.CertFile, s.KeyFile = newCert("default")
+	fooCert, fooKey := newCert("foo.example.com")
+	barCert, barKey := newCert("bar.example.com")
+	s.SNICertificates = map[string]rtls.CertKeyFiles{
+		"foo.example.com": {CertFile: fooCert, KeyFile: fooKey},
+		"bar.example.com": {CertFile: barCert, KeyFile: barKey},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	for _, tt := range []struct {
+		serverName string
+		exp        string
+	}{
+		{"foo.example.com", "foo.example.com"},
+		{"BAR.example.com", "bar.example.com"},
+		{"qux.example.com", "default"},
+		{"", "default"},
+	} {
+		conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+			ServerName:         tt.serverName,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to dial with server name %q: %s", tt.serverName, err)
+		}
+		certs := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		if len(certs) == 0 {
+			t.Fatalf("no certificate presented for server name %q", tt.serverName)
+		}
+		if got := certs[0].Subject.CommonName; got != tt.exp {
+			t.Fatalf("wrong certificate presented for server name %q, exp %s, got %s", tt.serverName, tt.exp, got)
+		}
+	}
+}
+
 func mustWriteTempFile(t *testing.T, b []byte) string {
 	f, err := os.CreateTemp(t.TempDir(), "rqlite-test")
 	if err != nil {
@@ -242,3 +514,38 @@ func mustWriteTempFile(t *testing.T, b []byte) string {
 	}
 	return f.Name()
 }
+
+func Test_TLSServiceHTTP2(t *testing.T) {
+	cert, key, err := rtls.GenerateSelfSignedCert(pkix.Name{CommonName: "rqlite"}, time.Hour, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate self-signed cert: %s", err)
+	}
+
+	for _, disabled := range []bool{false, true} {
+		s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+		s.CertFile = mustWriteTempFile(t, cert)
+		s.KeyFile = mustWriteTempFile(t, key)
+		s.HTTP2Disabled = disabled
+		s.HTTP2MaxConcurrentStreams = 500
+		s.HTTP2IdleTimeout = time.Minute
+		if err := s.Start(); err != nil {
+			t.Fatalf("failed to start service")
+		}
+		defer s.Close()
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			},
+		}
+		resp, err := client.Get(fmt.Sprintf("https://%s/status", s.Addr().String()))
+		if err != nil {
+			t.Fatalf("failed to make HTTP request: %s", err)
+		}
+		resp.Body.Close()
+		if exp := map[bool]int{false: 2, true: 1}[disabled]; resp.ProtoMajor != exp {
+			t.Fatalf("wrong protocol with HTTP/2 disabled %t, exp HTTP/%d, got %s", disabled, exp, resp.Proto)
+		}
+	}
+}
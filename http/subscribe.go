@@ -0,0 +1,230 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/auth"
+	"github.com/rqlite/rqlite/v8/command/encoding"
+	"github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
+	"golang.org/x/net/websocket"
+)
+
+// subscribeWriteTimeout is the time allowed to write a message to a
+// subscriber, before the subscription is ended.
+const subscribeWriteTimeout = 10 * time.Second
+
+// subscribeSnapshotPageRows is the number of rows of a table read at once
+// for a snapshot. Each page is read in full before it is sent, so a slow
+// subscriber never holds a read transaction open on the database.
+const subscribeSnapshotPageRows = 1000
+
+// SubscribeRequest is the first message a client sends on /db/subscribe,
+// naming the tables to watch, and whether their rows should be sent before
+// changes to them.
+type SubscribeRequest struct {
+	Tables   []string `json:"tables"`
+	Snapshot bool     `json:"snapshot,omitempty"`
+}
+
+// SubscribeMessage is a message sent to a client of /db/subscribe. Its type is
+// one of "snapshot", for a row of a watched table, "ready", once any snapshot
+// has been sent and changes follow, "change", for a change to a row, or
+// "error", after which the connection is closed.
+type SubscribeMessage struct {
+	Type    string        `json:"type"`
+	Table   string        `json:"table,omitempty"`
+	Columns []string      `json:"columns,omitempty"`
+	Values  []interface{} `json:"values,omitempty"`
+	Op      db.ChangeOp   `json:"op,omitempty"`
+	RowID   *int64        `json:"rowid,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// handleSubscribe upgrades the request to a WebSocket, over which the changes
+// to a set of tables are streamed to the client, as they are applied to this
+// node's database. The client names the tables in an initial SubscribeRequest,
+// and must be permitted to query each of them. If the client requests a
+// snapshot, every row of the tables is sent first. Since the subscription is
+// made before the snapshot is read, a change may be sent for a row already
+// included in the snapshot. The subscription ends with an error message if
+// the client does not keep up with changes, after which it may reconnect and
+// request a new snapshot.
+func (s *Service) handleSubscribe(w http.ResponseWriter, r *http.Request, qp QueryParams) {
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		s.writeUnauthorized(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := s.activeSubscriptions.Add(1)
+	defer s.activeSubscriptions.Add(-1)
+	if s.MaxSubscriptions > 0 && n > int64(s.MaxSubscriptions) {
+		stats.Add(numSubscribeRejected, 1)
+		http.Error(w, "too many subscriptions", http.StatusServiceUnavailable)
+		return
+	}
+
+	srv := websocket.Server{
+		Handshake: s.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			stats.Add(numSubscriptions, 1)
+			s.subscribe(ws, r, qp)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// checkOrigin accepts a WebSocket handshake made by a non-browser client,
+// one from a page served by this node, or one from an origin allowed by the
+// Service. This prevents another site from subscribing, using credentials
+// the browser holds for this node.
+func (s *Service) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if allow, _ := s.allowedOrigin(origin); origin == "" || allow != "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != r.Host {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	return nil
+}
+
+// subscribe serves a subscription over the given WebSocket.
+func (s *Service) subscribe(ws *websocket.Conn, r *http.Request, qp QueryParams) {
+	defer ws.Close()
+
+	// The connection was hijacked from the HTTP server, and may still have
+	// the deadlines of the request which opened it.
+	ws.SetDeadline(time.Time{})
+	send := func(m *SubscribeMessage) error {
+		ws.SetWriteDeadline(time.Now().Add(subscribeWriteTimeout))
+		return websocket.JSON.Send(ws, m)
+	}
+	fail := func(err error) {
+		send(&SubscribeMessage{Type: "error", Error: err.Error()})
+	}
+
+	var req SubscribeRequest
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		fail(fmt.Errorf("invalid subscribe request: %s", err.Error()))
+		return
+	}
+	if len(req.Tables) == 0 {
+		fail(errors.New("no tables to subscribe to"))
+		return
+	}
+	if err := s.checkTablePerm(r, auth.PermQuery, req.Tables...); err != nil {
+		fail(err)
+		return
+	}
+
+	sub, err := s.store.Subscribe(req.Tables)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer sub.Close()
+
+	if req.Snapshot {
+		for _, t := range req.Tables {
+			if err := s.sendTableSnapshot(t, qp, send); err != nil {
+				fail(fmt.Errorf("snapshot of table %s: %s", t, err.Error()))
+				return
+			}
+		}
+	}
+	if err := send(&SubscribeMessage{Type: "ready"}); err != nil {
+		return
+	}
+
+	// Nothing further is expected from the client, but reading is how a
+	// closed connection is noticed.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var b []byte
+		for websocket.Message.Receive(ws, &b) == nil {
+		}
+	}()
+
+	for {
+		select {
+		case c, ok := <-sub.Changes():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					fail(err)
+				}
+				return
+			}
+			m := &SubscribeMessage{Type: "change", Op: c.Op, Table: c.Table}
+			if c.Op != db.ChangeReset {
+				m.RowID = &c.RowID
+			}
+			if err := send(m); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-s.streamsDone:
+			return
+		}
+	}
+}
+
+// sendTableSnapshot sends every row of the given table, read from this node's
+// database, one page at a time in rowid order.
+func (s *Service) sendTableSnapshot(table string, qp QueryParams, send func(m *SubscribeMessage) error) error {
+	stmt := &proto.Statement{
+		Sql: fmt.Sprintf(`SELECT rowid, * FROM "%s"`, strings.ReplaceAll(table, `"`, `""`)),
+	}
+	var after *proto.Parameter
+	for {
+		rows, err := s.store.Query(&proto.QueryRequest{
+			Request: &proto.Request{
+				Statements: []*proto.Statement{PageStatement(stmt, "rowid", subscribeSnapshotPageRows, after)},
+			},
+			Level: proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+		})
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 {
+			return fmt.Errorf("unexpected number of results: %d", len(rows))
+		}
+		if rows[0].Error != "" {
+			return errors.New(rows[0].Error)
+		}
+
+		values := make([][]interface{}, len(rows[0].Values))
+		if err := encoding.NewValuesFromQueryValues(values, rows[0].Values, qp.BlobArray()); err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err := send(&SubscribeMessage{Type: "snapshot", Table: table, Columns: rows[0].Columns, Values: v}); err != nil {
+				return err
+			}
+		}
+		if len(values) < subscribeSnapshotPageRows {
+			return nil
+		}
+		last := rows[0].Values[len(values)-1].Parameters
+		if len(last) == 0 {
+			return errors.New("rowid missing from results")
+		}
+		after = last[0]
+	}
+}
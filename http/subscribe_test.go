@@ -0,0 +1,151 @@
+package http
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
+	"github.com/rqlite/rqlite/v8/store"
+	"golang.org/x/net/websocket"
+)
+
+type mockSubscription struct {
+	ch     chan db.Change
+	err    error
+	closed chan struct{}
+}
+
+func newMockSubscription() *mockSubscription {
+	return &mockSubscription{
+		ch:     make(chan db.Change, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (m *mockSubscription) Changes() <-chan db.Change { return m.ch }
+func (m *mockSubscription) Err() error                { return m.err }
+func (m *mockSubscription) Close()                    { close(m.closed) }
+
+func Test_Subscribe(t *testing.T) {
+	sub := newMockSubscription()
+	var subscribed []string
+	m := &MockStore{
+		subscribeFn: func(tables []string) (store.Subscription, error) {
+			subscribed = tables
+			return sub, nil
+		},
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			// The snapshot is read a page at a time. The first page is full,
+			// so a second is read after the last rowid of the first.
+			stmt := qr.Request.Statements[0]
+			first, last := int64(1), int64(subscribeSnapshotPageRows)
+			if len(stmt.Parameters) == 0 {
+				if exp, got := fmt.Sprintf(`SELECT * FROM (SELECT rowid, * FROM "foo") ORDER BY "rowid" LIMIT %d`, subscribeSnapshotPageRows), stmt.Sql; exp != got {
+					return nil, fmt.Errorf("wrong snapshot query, exp %s, got %s", exp, got)
+				}
+			} else {
+				if exp, got := int64(subscribeSnapshotPageRows), stmt.Parameters[0].GetI(); exp != got {
+					return nil, fmt.Errorf("wrong page start, exp %d, got %d", exp, got)
+				}
+				first, last = last+1, last+1
+			}
+			rows := &command.QueryRows{
+				Columns: []string{"rowid", "id", "name"},
+				Types:   []string{"integer", "integer", "text"},
+			}
+			for i := first; i <= last; i++ {
+				rows.Values = append(rows.Values, &command.Values{
+					Parameters: []*command.Parameter{
+						{Value: &command.Parameter_I{I: i}},
+						{Value: &command.Parameter_I{I: i}},
+						{Value: &command.Parameter_S{S: "fiona"}},
+					},
+				})
+			}
+			return []*command.QueryRows{rows}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	host := s.Addr().String()
+	ws, err := websocket.Dial(fmt.Sprintf("ws://%s/db/subscribe", host), "", fmt.Sprintf("http://%s", host))
+	if err != nil {
+		t.Fatalf("failed to dial subscribe endpoint: %s", err.Error())
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := websocket.JSON.Send(ws, &SubscribeRequest{Tables: []string{"foo"}, Snapshot: true}); err != nil {
+		t.Fatalf("failed to send subscribe request: %s", err.Error())
+	}
+	receive := func() string {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			t.Fatalf("failed to receive message: %s", err.Error())
+		}
+		return msg
+	}
+
+	for i := 1; i <= subscribeSnapshotPageRows+1; i++ {
+		exp := fmt.Sprintf(`{"type":"snapshot","table":"foo","columns":["rowid","id","name"],"values":[%d,%d,"fiona"]}`, i, i)
+		if got := receive(); exp != got {
+			t.Fatalf("wrong snapshot message\nexp: %s\ngot: %s", exp, got)
+		}
+	}
+	if exp, got := `{"type":"ready"}`, receive(); exp != got {
+		t.Fatalf("wrong ready message\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(exp, subscribed) {
+		t.Fatalf("wrong tables subscribed, exp %v, got %v", exp, subscribed)
+	}
+
+	sub.ch <- db.Change{Op: db.ChangeUpdate, Table: "foo", RowID: 0}
+	if exp, got := `{"type":"change","table":"foo","op":"update","rowid":0}`, receive(); exp != got {
+		t.Fatalf("wrong change message\nexp: %s\ngot: %s", exp, got)
+	}
+	sub.ch <- db.Change{Op: db.ChangeReset}
+	if exp, got := `{"type":"change","op":"reset"}`, receive(); exp != got {
+		t.Fatalf("wrong reset message\nexp: %s\ngot: %s", exp, got)
+	}
+
+	sub.err = store.ErrSubscriptionOverflow
+	close(sub.ch)
+	if exp, got := `{"type":"error","error":"subscription fell behind changes"}`, receive(); exp != got {
+		t.Fatalf("wrong error message\nexp: %s\ngot: %s", exp, got)
+	}
+	select {
+	case <-sub.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not closed")
+	}
+}
+
+func Test_SubscribeCrossOrigin(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	url := fmt.Sprintf("ws://%s/db/subscribe", s.Addr().String())
+	if _, err := websocket.Dial(url, "", "http://example.com"); err == nil {
+		t.Fatalf("subscription from another origin allowed")
+	}
+
+	s.AllowOrigins = []string{"http://example.com"}
+	ws, err := websocket.Dial(url, "", "http://example.com")
+	if err != nil {
+		t.Fatalf("subscription from allowed origin failed: %s", err.Error())
+	}
+	ws.Close()
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/store"
+	"github.com/rqlite/rqlite/v8/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes set on spans.
+const (
+	attrStatements = attribute.Key("rqlite.statements")
+	attrLevel      = attribute.Key("rqlite.level")
+	attrForwarded  = attribute.Key("rqlite.forwarded")
+	attrLeader     = attribute.Key("rqlite.leader")
+	attrRequestID  = attribute.Key("rqlite.request_id")
+)
+
+// startRequestSpan starts the span covering the handling of the given
+// request, continuing any trace named by the request's headers. It returns
+// the request, carrying the span in its context.
+func startRequestSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attrRequestID.String(requestID(r)),
+		))
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan ends a span started by startRequestSpan, recording the
+// status code of the response.
+func endRequestSpan(span trace.Span, statusCode int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+	span.End()
+}
+
+// startSpan starts a span, as a child of the given request's span, covering
+// an operation performed on behalf of the request.
+func startSpan(r *http.Request, name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := tracing.Tracer().Start(r.Context(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// startForwardSpan starts a span covering the forwarding of the given
+// request to the Leader at addr, and marks the request as forwarded.
+func startForwardSpan(r *http.Request, name, addr string, attrs ...attribute.KeyValue) trace.Span {
+	trace.SpanFromContext(r.Context()).SetAttributes(attrForwarded.Bool(true))
+	return startSpan(r, name, append(attrs, attrLeader.String(addr))...)
+}
+
+// endSpan ends the given span, recording err if it is not nil. A store which
+// is not the Leader, or is too stale to serve a read, is not recorded as an
+// error, since the request is then forwarded.
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != store.ErrNotLeader && err != store.ErrStaleRead {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statementsAttr returns the span attribute giving the number of statements
+// in the given request.
+func statementsAttr(req *command.Request) attribute.KeyValue {
+	return attrStatements.Int(len(req.GetStatements()))
+}
+
+// levelAttr returns the span attribute giving the read consistency level.
+func levelAttr(level command.QueryRequest_Level) attribute.KeyValue {
+	return attrLevel.String(strings.ToLower(strings.TrimPrefix(level.String(), "QUERY_REQUEST_LEVEL_")))
+}
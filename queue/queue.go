@@ -96,6 +96,10 @@ type Queue struct {
 	seqMu  sync.Mutex
 	seqNum int64
 
+	pendMu    sync.Mutex
+	pending   []time.Time // Queue times of unsent writes, oldest first.
+	highWater int
+
 	// Whitebox unit-testing
 	numTimeouts int
 }
@@ -137,6 +141,13 @@ func (q *Queue) Write(stmts []*command.Statement, c FlushChannel) (int64, error)
 	defer q.seqMu.Unlock()
 	q.seqNum++
 
+	q.pendMu.Lock()
+	q.pending = append(q.pending, time.Now())
+	if len(q.pending) > q.highWater {
+		q.highWater = len(q.pending)
+	}
+	q.pendMu.Unlock()
+
 	q.batchCh <- &queuedStatements{
 		SequenceNumber: q.seqNum,
 		Statements:     stmts,
@@ -168,6 +179,26 @@ func (q *Queue) Depth() int {
 	return len(q.batchCh)
 }
 
+// Pending returns the number of writes which have been queued but not
+// yet sent for processing, and the time at which the oldest of those
+// writes was queued. The time is zero if there are no pending writes.
+func (q *Queue) Pending() (int, time.Time) {
+	q.pendMu.Lock()
+	defer q.pendMu.Unlock()
+	if len(q.pending) == 0 {
+		return 0, time.Time{}
+	}
+	return len(q.pending), q.pending[0]
+}
+
+// HighWaterMark returns the largest number of pending writes observed
+// since the queue was created.
+func (q *Queue) HighWaterMark() int {
+	q.pendMu.Lock()
+	defer q.pendMu.Unlock()
+	return q.highWater
+}
+
 // Stats returns stats on this queue.
 func (q *Queue) Stats() (map[string]interface{}, error) {
 	return map[string]interface{}{
@@ -189,6 +220,9 @@ func (q *Queue) run() {
 		// mergeQueued returns a new object, ownership will pass
 		// implicitly to the other side of sendCh.
 		req := mergeQueued(queuedStmts)
+		q.pendMu.Lock()
+		q.pending = q.pending[len(queuedStmts):]
+		q.pendMu.Unlock()
 		q.sendCh <- req
 		stats.Add(numStatementsTx, int64(len(req.Statements)))
 		queuedStmts = queuedStmts[:0] // Better on the GC than setting to nil.
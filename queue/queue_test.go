@@ -427,3 +427,49 @@ func Test_NewQueueWriteTimeoutBatch(t *testing.T) {
 		t.Fatalf("timed out waiting for statement")
 	}
 }
+
+func Test_NewQueuePending(t *testing.T) {
+	q := New(1024, 3, 60*time.Second)
+	defer q.Close()
+
+	if n, oldest := q.Pending(); n != 0 || !oldest.IsZero() {
+		t.Fatalf("new queue reports pending writes, n: %d, oldest: %s", n, oldest)
+	}
+
+	before := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := q.Write(testStmtsFoo, nil); err != nil {
+			t.Fatalf("failed to write: %s", err.Error())
+		}
+	}
+	n, oldest := q.Pending()
+	if exp, got := 2, n; exp != got {
+		t.Fatalf("wrong number of pending writes, exp %d, got %d", exp, got)
+	}
+	if oldest.Before(before) {
+		t.Fatalf("oldest pending write time is wrong: %s", oldest)
+	}
+	if exp, got := 2, q.HighWaterMark(); exp != got {
+		t.Fatalf("wrong high-water mark, exp %d, got %d", exp, got)
+	}
+
+	// Filling the batch should send all pending writes.
+	if _, err := q.Write(testStmtsFoo, nil); err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	select {
+	case req := <-q.C:
+		if exp, got := 3, len(req.Statements); exp != got {
+			t.Fatalf("received wrong length slice, exp %d, got %d", exp, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for statement")
+	}
+
+	if n, _ := q.Pending(); n != 0 {
+		t.Fatalf("sent queue reports %d pending writes", n)
+	}
+	if exp, got := 3, q.HighWaterMark(); exp != got {
+		t.Fatalf("wrong high-water mark after send, exp %d, got %d", exp, got)
+	}
+}
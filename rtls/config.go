@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"strings"
 )
 
 const (
@@ -20,6 +21,10 @@ type MTLSState tls.ClientAuthType
 const (
 	MTLSStateDisabled MTLSState = MTLSState(tls.NoClientCert)
 	MTLSStateEnabled  MTLSState = MTLSState(tls.RequireAndVerifyClientCert)
+
+	// MTLSStateOptional requests a certificate from the client, and verifies
+	// it if presented, but does not require one.
+	MTLSStateOptional MTLSState = MTLSState(tls.VerifyClientCertIfGiven)
 )
 
 // CreateClientConfig creates a new tls.Config for use by a client. The certFile and keyFile
@@ -84,6 +89,65 @@ func CreateServerConfig(certFile, keyFile, caCertFile string, mtls MTLSState) (*
 	return config, nil
 }
 
+// CertKeyFiles are the paths to an x509 certificate and its private key.
+type CertKeyFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// AddSNICertificates loads the certificate for each hostname in certs, and
+// configures config to present the certificate matching the server name
+// requested by the client via SNI. If no certificate matches the requested
+// name, or the client does not use SNI, the config's default certificate is
+// presented. Hostnames are matched case-insensitively.
+func AddSNICertificates(config *tls.Config, certs map[string]CertKeyFiles) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	if len(config.Certificates) == 0 {
+		return fmt.Errorf("default certificate required for SNI certificate selection")
+	}
+
+	byName := make(map[string]*tls.Certificate, len(certs))
+	for host, ckf := range certs {
+		cert, err := tls.LoadX509KeyPair(ckf.CertFile, ckf.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate for %s: %s", host, err)
+		}
+		byName[strings.ToLower(host)] = &cert
+	}
+	def := &config.Certificates[0]
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert, ok := byName[strings.ToLower(hello.ServerName)]; ok {
+			return cert, nil
+		}
+		return def, nil
+	}
+	return nil
+}
+
+// ParseSNICertificates parses a comma-separated list of host=certfile:keyfile
+// entries, returning a map of hostname to certificate and key file paths.
+func ParseSNICertificates(s string) (map[string]CertKeyFiles, error) {
+	certs := make(map[string]CertKeyFiles)
+	if s == "" {
+		return certs, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		host, files, ok := strings.Cut(e, "=")
+		host = strings.TrimSpace(host)
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid SNI certificate %q, must be host=certfile:keyfile", e)
+		}
+		certFile, keyFile, ok := strings.Cut(files, ":")
+		if !ok || certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("invalid SNI certificate %q, must be host=certfile:keyfile", e)
+		}
+		certs[host] = CertKeyFiles{CertFile: certFile, KeyFile: keyFile}
+	}
+	return certs, nil
+}
+
 func createBaseTLSConfig(serverName string, noverify bool) *tls.Config {
 	return &tls.Config{
 		ServerName:         serverName,
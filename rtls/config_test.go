@@ -113,6 +113,80 @@ func Test_CreateClientConfig(t *testing.T) {
 // mustWriteTempFile writes the given bytes to a temporary file, and returns the
 // path to the file. If there is an error, it panics. The file will be automatically
 // deleted when the test ends.
+func Test_AddSNICertificates(t *testing.T) {
+	newCert := func(cn string) (string, string) {
+		certPEM, keyPEM, err := GenerateCert(pkix.Name{CommonName: cn}, 365*24*time.Hour, 2048, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to generate cert: %v", err)
+		}
+		return mustWriteTempFile(t, certPEM), mustWriteTempFile(t, keyPEM)
+	}
+	certFile, keyFile := newCert("default")
+	config, err := CreateServerConfig(certFile, keyFile, NoCACert, MTLSStateDisabled)
+	if err != nil {
+		t.Fatalf("failed to create server config: %v", err)
+	}
+
+	fooCert, fooKey := newCert("foo")
+	if err := AddSNICertificates(config, map[string]CertKeyFiles{
+		"foo.example.com": {CertFile: fooCert, KeyFile: fooKey},
+	}); err != nil {
+		t.Fatalf("failed to add SNI certificates: %v", err)
+	}
+
+	for name, exp := range map[string]string{
+		"foo.example.com": "foo",
+		"FOO.example.com": "foo",
+		"bar.example.com": "default",
+		"":                "default",
+	} {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("failed to get certificate for %q: %v", name, err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		if parsed.Subject.CommonName != exp {
+			t.Fatalf("wrong certificate for %q, exp %s, got %s", name, exp, parsed.Subject.CommonName)
+		}
+	}
+
+	if err := AddSNICertificates(config, map[string]CertKeyFiles{
+		"foo.example.com": {CertFile: fooCert, KeyFile: "/does/not/exist"},
+	}); err == nil {
+		t.Fatalf("expected error adding SNI certificate with missing key")
+	}
+}
+
+func Test_ParseSNICertificates(t *testing.T) {
+	certs, err := ParseSNICertificates("foo.example.com=foo.crt:foo.key,bar.example.com=bar.crt:bar.key")
+	if err != nil {
+		t.Fatalf("failed to parse SNI certificates: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected 2 SNI certificates, got %d", len(certs))
+	}
+	if exp, got := (CertKeyFiles{CertFile: "foo.crt", KeyFile: "foo.key"}), certs["foo.example.com"]; exp != got {
+		t.Fatalf("wrong SNI certificate, exp %v, got %v", exp, got)
+	}
+
+	certs, err = ParseSNICertificates("")
+	if err != nil {
+		t.Fatalf("failed to parse empty SNI certificates: %v", err)
+	}
+	if len(certs) != 0 {
+		t.Fatalf("expected no SNI certificates, got %d", len(certs))
+	}
+
+	for _, s := range []string{"foo.example.com", "=foo.crt:foo.key", "foo.example.com=foo.crt", "foo.example.com=:foo.key"} {
+		if _, err := ParseSNICertificates(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
+
 func mustWriteTempFile(t *testing.T, b []byte) string {
 	f, err := os.CreateTemp(t.TempDir(), "rqlite-test")
 	if err != nil {
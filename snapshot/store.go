@@ -198,11 +198,22 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"dir":       s.dir,
 		"snapshots": snapsAsIDs,
 		"db_path":   dbPath,
-	}, nil
+	}
+	if len(snapshots) > 0 {
+		latest := snapshots[len(snapshots)-1]
+		stats["last_snapshot_index"] = latest.Index
+		stats["last_snapshot_term"] = latest.Term
+		fi, err := os.Stat(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		stats["db_size"] = fi.Size()
+	}
+	return stats, nil
 }
 
 // Reap reaps all snapshots, except the most recent one. Returns the number of
@@ -178,6 +178,16 @@ func Test_StoreList(t *testing.T) {
 	if snaps[0].ID != "2-1131-1704807720976" {
 		t.Errorf("Expected snapshot ID to be 2-1131-1704807720976, got %s", snaps[0].ID)
 	}
+	st, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if idx, ok := st["last_snapshot_index"].(uint64); !ok || idx != 1131 {
+		t.Errorf("Expected last snapshot index 1131, got %v", st["last_snapshot_index"])
+	}
+	if sz, ok := st["db_size"].(int64); !ok || sz <= 0 {
+		t.Errorf("Expected positive snapshot size, got %v", st["db_size"])
+	}
 }
 
 func mustTouchFile(t *testing.T, path string) {
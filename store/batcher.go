@@ -0,0 +1,110 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// executeBatchFn applies a batch of Execute requests, returning one
+// response per request, in order.
+type executeBatchFn func(ers []*proto.ExecuteRequest) ([]*fsmExecuteResponse, error)
+
+type pendingExecute struct {
+	er   *proto.ExecuteRequest
+	resp *fsmExecuteResponse
+	err  error
+	done chan struct{}
+}
+
+// executeBatcher coalesces Execute requests which arrive within a short
+// window of each other, so they are written to the Raft log as a single
+// entry. Each caller still receives its own results.
+type executeBatcher struct {
+	window time.Duration
+	max    int
+	apply  executeBatchFn
+
+	mu      sync.Mutex
+	pending []*pendingExecute
+	timer   *time.Timer
+}
+
+// newExecuteBatcher returns a new executeBatcher. Batches are flushed
+// when window has elapsed since the first request in the batch arrived,
+// or when the batch holds max requests, whichever comes first.
+func newExecuteBatcher(window time.Duration, max int, apply executeBatchFn) *executeBatcher {
+	return &executeBatcher{
+		window: window,
+		max:    max,
+		apply:  apply,
+	}
+}
+
+// Execute adds the request to the current batch, and blocks until the
+// batch has been applied.
+func (b *executeBatcher) Execute(er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+	p := &pendingExecute{
+		er:   er,
+		done: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, p)
+	if len(b.pending) >= b.max {
+		batch := b.take()
+		b.mu.Unlock()
+		go b.flush(batch)
+	} else {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.window, b.onTimer)
+		}
+		b.mu.Unlock()
+	}
+
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.resp.results, p.resp.error
+}
+
+func (b *executeBatcher) onTimer() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	b.flush(batch)
+}
+
+// take returns the pending batch, and resets the batcher. It must be
+// called with mu held.
+func (b *executeBatcher) take() []*pendingExecute {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+func (b *executeBatcher) flush(batch []*pendingExecute) {
+	if len(batch) == 0 {
+		return
+	}
+	ers := make([]*proto.ExecuteRequest, len(batch))
+	for i := range batch {
+		ers[i] = batch[i].er
+	}
+
+	resps, err := b.apply(ers)
+	for i, p := range batch {
+		if err != nil {
+			p.err = err
+		} else {
+			p.resp = resps[i]
+		}
+		close(p.done)
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/random"
+)
+
+// retryBusy calls fn, retrying up to BusyRetries times, with jittered
+// exponential backoff, if the execute request fails because the database is
+// busy or locked. Each retry writes the request to the Raft log again, so
+// applying the log never waits. Retries stop once the total wait would exceed
+// the budget returned by retryBudget, or ctx is done. A busy error in the
+// result of a single statement is only retried if the request is a
+// transaction, as otherwise earlier statements may have been applied.
+func (s *Store) retryBusy(ctx context.Context, er *proto.ExecuteRequest, fn func() ([]*proto.ExecuteResult, error)) ([]*proto.ExecuteResult, error) {
+	budget := s.retryBudget(er)
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		results, err := fn()
+		if !executeBusy(er, results, err) {
+			return results, err
+		}
+		if attempt >= s.BusyRetries || (budget > 0 && waited >= budget) {
+			if attempt > 0 {
+				stats.Add(numBusyRetriesExhausted, 1)
+			}
+			return results, err
+		}
+		delay := random.Jitter((s.BusyRetryBackoff << attempt) / 2)
+		if budget > 0 && waited+delay > budget {
+			delay = budget - waited
+		}
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < delay {
+			stats.Add(numBusyRetriesExhausted, 1)
+			return results, err
+		}
+		stats.Add(numBusyRetries, 1)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			stats.Add(numBusyRetriesExhausted, 1)
+			return results, err
+		case <-t.C:
+		}
+		waited += delay
+	}
+}
+
+// retryBudget returns the total time the retries of the execute request may
+// wait, or 0 if there is no limit.
+func (s *Store) retryBudget(er *proto.ExecuteRequest) time.Duration {
+	budget := s.BusyRetryMaxWait
+	if t := time.Duration(er.Request.DbTimeout); t > 0 && (budget == 0 || t < budget) {
+		budget = t
+	}
+	return budget
+}
+
+// executeBusy returns whether an execute request failed, as a whole, because
+// the database was busy or locked.
+func executeBusy(er *proto.ExecuteRequest, results []*proto.ExecuteResult, err error) bool {
+	if err != nil {
+		return isBusyError(err.Error())
+	}
+	if !er.Request.Transaction {
+		return false
+	}
+	for _, r := range results {
+		if isBusyError(r.Error) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBusyError returns whether the error message is that of SQLITE_BUSY or
+// SQLITE_LOCKED.
+func isBusyError(msg string) bool {
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "SQLITE_BUSY") ||
+		strings.Contains(msg, "SQLITE_LOCKED")
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_StoreRetryBusy(t *testing.T) {
+	s := &Store{
+		BusyRetryBackoff: time.Millisecond,
+	}
+
+	for _, tt := range []struct {
+		name       string
+		tx         bool
+		busyCalls  int
+		busyResult bool
+		retries    int
+		expCalls   int
+		expErr     string
+		expResult  string
+	}{
+		{"busy once", false, 1, false, 2, 2, "", ""},
+		{"persistently busy", false, 10, false, 2, 3, "database is locked", ""},
+		{"busy transaction", true, 1, true, 2, 2, "", ""},
+		{"busy statement, no transaction", false, 1, true, 2, 1, "", "database is locked"},
+		{"retries disabled", false, 1, false, 0, 1, "database is locked", ""},
+	} {
+		s.BusyRetries = tt.retries
+		er := &proto.ExecuteRequest{Request: &proto.Request{Transaction: tt.tx}}
+		calls := 0
+		results, err := s.retryBusy(context.Background(), er, func() ([]*proto.ExecuteResult, error) {
+			calls++
+			if calls > tt.busyCalls {
+				return []*proto.ExecuteResult{{RowsAffected: 1}}, nil
+			}
+			if tt.busyResult {
+				return []*proto.ExecuteResult{{Error: "database is locked"}}, nil
+			}
+			return nil, errors.New("database is locked")
+		})
+		if calls != tt.expCalls {
+			t.Fatalf("test %s: wrong number of calls, exp %d, got %d", tt.name, tt.expCalls, calls)
+		}
+		if tt.expErr != "" {
+			if err == nil || err.Error() != tt.expErr {
+				t.Fatalf("test %s: wrong error, exp %s, got %v", tt.name, tt.expErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %s: unexpected error: %s", tt.name, err.Error())
+		}
+		if len(results) != 1 || results[0].Error != tt.expResult {
+			t.Fatalf("test %s: wrong results: %v", tt.name, results)
+		}
+	}
+}
+
+func Test_StoreRetryBusyBudget(t *testing.T) {
+	s := &Store{
+		BusyRetries:      100,
+		BusyRetryBackoff: 10 * time.Millisecond,
+	}
+
+	for _, tt := range []struct {
+		name      string
+		maxWait   time.Duration
+		dbTimeout time.Duration
+	}{
+		{"max wait", 15 * time.Millisecond, 0},
+		{"database timeout", 0, 15 * time.Millisecond},
+		{"database timeout shorter than max wait", time.Second, 15 * time.Millisecond},
+	} {
+		s.BusyRetryMaxWait = tt.maxWait
+		er := &proto.ExecuteRequest{Request: &proto.Request{DbTimeout: int64(tt.dbTimeout)}}
+		calls := 0
+		start := time.Now()
+		_, err := s.retryBusy(context.Background(), er, func() ([]*proto.ExecuteResult, error) {
+			calls++
+			return nil, errors.New("database is locked")
+		})
+		if err == nil {
+			t.Fatalf("test %s: expected error", tt.name)
+		}
+
+		// The first delay is between 5ms and 10ms, and the second is cut
+		// short so the total wait is 15ms.
+		if calls != 3 {
+			t.Fatalf("test %s: wrong number of calls, exp 3, got %d", tt.name, calls)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("test %s: retries waited too long: %s", tt.name, d)
+		}
+	}
+}
+
+func Test_StoreRetryBusyDeadline(t *testing.T) {
+	s := &Store{
+		BusyRetries:      100,
+		BusyRetryBackoff: 10 * time.Millisecond,
+	}
+	er := &proto.ExecuteRequest{Request: &proto.Request{}}
+
+	// A retry which would wait beyond the deadline is not made.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Millisecond)
+	defer cancel()
+	calls := 0
+	if _, err := s.retryBusy(ctx, er, func() ([]*proto.ExecuteResult, error) {
+		calls++
+		return nil, errors.New("database is locked")
+	}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if calls != 1 {
+		t.Fatalf("wrong number of calls, exp 1, got %d", calls)
+	}
+
+	// Retries stop once the context is done.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	calls = 0
+	start := time.Now()
+	if _, err := s.retryBusy(ctx, er, func() ([]*proto.ExecuteResult, error) {
+		calls++
+		return nil, errors.New("database is locked")
+	}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if calls < 2 || calls > 5 {
+		t.Fatalf("wrong number of calls, got %d", calls)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("retries waited beyond the deadline: %s", d)
+	}
+}
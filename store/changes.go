@@ -0,0 +1,153 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	sql "github.com/rqlite/rqlite/v8/db"
+)
+
+// changeBufferSize is the number of changes buffered for each subscription.
+const changeBufferSize = 1024
+
+// ErrSubscriptionOverflow is the error of a subscription which was ended
+// because it did not receive changes as quickly as they were made.
+var ErrSubscriptionOverflow = errors.New("subscription fell behind changes")
+
+// Subscription is a subscription to the changes made to a set of tables.
+type Subscription interface {
+	// Changes returns the channel on which changes are received. A
+	// ChangeReset is received if the entire database is replaced. The
+	// channel is closed once the subscription ends, after which Err
+	// returns why it ended.
+	Changes() <-chan sql.Change
+
+	// Err returns the error which ended the subscription, if any. It must
+	// only be called once the changes channel is closed.
+	Err() error
+
+	// Close ends the subscription.
+	Close()
+}
+
+// changeSubscription is a Subscription to the changes sent by a changeBroker.
+type changeSubscription struct {
+	b      *changeBroker
+	tables map[string]bool // In lower case.
+	ch     chan sql.Change
+	err    error
+}
+
+// Changes implements the Subscription interface.
+func (cs *changeSubscription) Changes() <-chan sql.Change {
+	return cs.ch
+}
+
+// Err implements the Subscription interface.
+func (cs *changeSubscription) Err() error {
+	return cs.err
+}
+
+// Close implements the Subscription interface.
+func (cs *changeSubscription) Close() {
+	cs.b.unsubscribe(cs, nil)
+}
+
+// changeBroker fans out the changes made to the database to subscriptions.
+// Changes are sent without blocking, so a subscription whose buffer is full
+// is ended, rather than holding up writes to the database.
+type changeBroker struct {
+	mu   sync.Mutex
+	subs map[*changeSubscription]struct{}
+
+	// setFn sets the function called with changes to the database, and is
+	// called with nil once there are no subscriptions. Since the database
+	// may publish changes while it is locked, setFn is never called with mu
+	// held, but with fnMu held instead.
+	fnMu  sync.Mutex
+	setFn func(fn sql.ChangeFunc)
+}
+
+func newChangeBroker(setFn func(fn sql.ChangeFunc)) *changeBroker {
+	return &changeBroker{
+		subs:  make(map[*changeSubscription]struct{}),
+		setFn: setFn,
+	}
+}
+
+// subscribe returns a subscription to the changes made to the given tables.
+func (b *changeBroker) subscribe(tables []string) *changeSubscription {
+	cs := &changeSubscription{
+		b:      b,
+		tables: make(map[string]bool, len(tables)),
+		ch:     make(chan sql.Change, changeBufferSize),
+	}
+	for _, t := range tables {
+		cs.tables[strings.ToLower(t)] = true
+	}
+
+	b.fnMu.Lock()
+	defer b.fnMu.Unlock()
+	b.mu.Lock()
+	b.subs[cs] = struct{}{}
+	n := len(b.subs)
+	b.mu.Unlock()
+	if n == 1 {
+		b.setFn(b.publish)
+	}
+	return cs
+}
+
+// unsubscribe ends the given subscription, with the given error.
+func (b *changeBroker) unsubscribe(cs *changeSubscription, err error) {
+	b.fnMu.Lock()
+	defer b.fnMu.Unlock()
+	b.mu.Lock()
+	b.remove(cs, err)
+	n := len(b.subs)
+	b.mu.Unlock()
+	if n == 0 {
+		b.setFn(nil)
+	}
+}
+
+// remove removes the subscription, if it has not already been removed. b.mu
+// must be held.
+func (b *changeBroker) remove(cs *changeSubscription, err error) {
+	if _, ok := b.subs[cs]; !ok {
+		return
+	}
+	delete(b.subs, cs)
+	cs.err = err
+	close(cs.ch)
+}
+
+// publish sends the given changes to each subscription to their tables.
+func (b *changeBroker) publish(changes []sql.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for cs := range b.subs {
+		for _, c := range changes {
+			if c.Op != sql.ChangeReset && !cs.tables[strings.ToLower(c.Table)] {
+				continue
+			}
+			select {
+			case cs.ch <- c:
+			default:
+				stats.Add(numSubscriptionOverflows, 1)
+				b.remove(cs, ErrSubscriptionOverflow)
+			}
+			if cs.err != nil {
+				break
+			}
+		}
+	}
+}
+
+// Len returns the number of subscriptions.
+func (b *changeBroker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
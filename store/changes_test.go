@@ -0,0 +1,78 @@
+package store
+
+import (
+	"testing"
+
+	sql "github.com/rqlite/rqlite/v8/db"
+)
+
+func Test_ChangeBroker(t *testing.T) {
+	var fn sql.ChangeFunc
+	b := newChangeBroker(func(f sql.ChangeFunc) { fn = f })
+
+	foo := b.subscribe([]string{"FOO"})
+	if fn == nil {
+		t.Fatalf("change function not set on first subscription")
+	}
+	bar := b.subscribe([]string{"bar"})
+
+	fn([]sql.Change{
+		{Op: sql.ChangeInsert, Table: "foo", RowID: 1},
+		{Op: sql.ChangeInsert, Table: "bar", RowID: 2},
+		{Op: sql.ChangeInsert, Table: "baz", RowID: 3},
+	})
+	fn([]sql.Change{{Op: sql.ChangeReset}})
+
+	for _, tt := range []struct {
+		sub Subscription
+		exp []sql.Change
+	}{
+		{foo, []sql.Change{{Op: sql.ChangeInsert, Table: "foo", RowID: 1}, {Op: sql.ChangeReset}}},
+		{bar, []sql.Change{{Op: sql.ChangeInsert, Table: "bar", RowID: 2}, {Op: sql.ChangeReset}}},
+	} {
+		for _, exp := range tt.exp {
+			if got := <-tt.sub.Changes(); got != exp {
+				t.Fatalf("wrong change, exp %v, got %v", exp, got)
+			}
+		}
+	}
+
+	foo.Close()
+	if _, ok := <-foo.Changes(); ok {
+		t.Fatalf("changes channel not closed")
+	}
+	if foo.Err() != nil {
+		t.Fatalf("closed subscription has error: %s", foo.Err())
+	}
+	bar.Close()
+	if fn != nil {
+		t.Fatalf("change function not unset once no subscriptions")
+	}
+	if b.Len() != 0 {
+		t.Fatalf("wrong number of subscriptions, exp 0, got %d", b.Len())
+	}
+}
+
+func Test_ChangeBrokerOverflow(t *testing.T) {
+	var fn sql.ChangeFunc
+	b := newChangeBroker(func(f sql.ChangeFunc) { fn = f })
+	sub := b.subscribe([]string{"foo"})
+	defer sub.Close()
+
+	for i := 0; i <= changeBufferSize; i++ {
+		fn([]sql.Change{{Op: sql.ChangeInsert, Table: "foo", RowID: int64(i)}})
+	}
+	n := 0
+	for range sub.Changes() {
+		n++
+	}
+	if n != changeBufferSize {
+		t.Fatalf("wrong number of changes received, exp %d, got %d", changeBufferSize, n)
+	}
+	if sub.Err() != ErrSubscriptionOverflow {
+		t.Fatalf("wrong error for subscription, exp %v, got %v", ErrSubscriptionOverflow, sub.Err())
+	}
+	if b.Len() != 0 {
+		t.Fatalf("wrong number of subscriptions, exp 0, got %d", b.Len())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/rqlite/rqlite/v8/command"
 	"github.com/rqlite/rqlite/v8/command/chunking"
@@ -38,11 +39,13 @@ type CommandProcessor struct {
 func NewCommandProcessor(logger *log.Logger, dm *chunking.DechunkerManager) *CommandProcessor {
 	return &CommandProcessor{
 		logger:  logger,
-		decMgmr: dm}
+		decMgmr: dm,
+	}
 }
 
-// Process processes the given command against the given database.
-func (c *CommandProcessor) Process(data []byte, db *sql.SwappableDB) (*proto.Command, bool, interface{}) {
+// Process processes the given command against the given database. appendedAt
+// is the time the command was appended to the Raft log by the Leader.
+func (c *CommandProcessor) Process(data []byte, db *sql.SwappableDB, appendedAt time.Time) (*proto.Command, bool, interface{}) {
 	cmd := &proto.Command{}
 	if err := command.Unmarshal(data, cmd); err != nil {
 		panic(fmt.Sprintf("failed to unmarshal cluster command: %s", err.Error()))
@@ -61,8 +64,19 @@ func (c *CommandProcessor) Process(data []byte, db *sql.SwappableDB) (*proto.Com
 		if err := command.UnmarshalSubCommand(cmd, &er); err != nil {
 			panic(fmt.Sprintf("failed to unmarshal execute subcommand: %s", err.Error()))
 		}
-		r, err := db.Execute(er.Request, er.Timings)
+		r, err := c.execute(db, &er, appendedAt)
 		return cmd, true, &fsmExecuteResponse{results: r, error: err}
+	case proto.Command_COMMAND_TYPE_EXECUTE_BATCH:
+		var br proto.ExecuteBatchRequest
+		if err := command.UnmarshalSubCommand(cmd, &br); err != nil {
+			panic(fmt.Sprintf("failed to unmarshal execute-batch subcommand: %s", err.Error()))
+		}
+		resps := make([]*fsmExecuteResponse, len(br.Requests))
+		for i, er := range br.Requests {
+			r, err := c.execute(db, er, appendedAt)
+			resps[i] = &fsmExecuteResponse{results: r, error: err}
+		}
+		return cmd, true, &fsmExecuteBatchResponse{responses: resps}
 	case proto.Command_COMMAND_TYPE_EXECUTE_QUERY:
 		var eqr proto.ExecuteQueryRequest
 		if err := command.UnmarshalSubCommand(cmd, &eqr); err != nil {
@@ -144,3 +158,28 @@ func (c *CommandProcessor) Process(data []byte, db *sql.SwappableDB) (*proto.Com
 		return cmd, false, &fsmGenericResponse{error: fmt.Errorf("unhandled command: %v", cmd.Type)}
 	}
 }
+
+// execute applies the execute request to the database. If the request
+// carries an idempotency key, and a request with the same key was applied
+// within its window, the results of that request are returned instead, and
+// the request is not applied again.
+func (c *CommandProcessor) execute(db *sql.SwappableDB, er *proto.ExecuteRequest, appendedAt time.Time) ([]*proto.ExecuteResult, error) {
+	if er.IdempotencyKey != "" {
+		results, ok, err := replayIdempotent(db, er, appendedAt)
+		if ok || err != nil {
+			if err == nil {
+				stats.Add(numIdempotentReplays, 1)
+			}
+			return results, err
+		}
+	}
+	results, err := db.Execute(er.Request, er.Timings)
+	// A request which failed because the database was busy may be retried,
+	// so its results are not recorded.
+	if er.IdempotencyKey != "" && err == nil && !executeBusy(er, results, err) {
+		if err := recordIdempotent(db, er, results, appendedAt); err != nil {
+			c.logger.Printf("failed to record idempotency key: %s", err.Error())
+		}
+	}
+	return results, err
+}
@@ -7,6 +7,9 @@ type DBConfig struct {
 
 	// Enforce Foreign Key constraints
 	FKConstraints bool `json:"fk_constraints"`
+
+	// PRAGMAs applied to each read-only connection
+	ReadPragmas map[string]string `json:"read_pragmas,omitempty"`
 }
 
 // NewDBConfig returns a new DB config instance.
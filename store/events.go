@@ -0,0 +1,217 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBufferSize is the number of events buffered for each event
+	// subscription, beyond any events replayed to it.
+	eventBufferSize = 256
+
+	// eventHistorySize is the number of recent events retained, so that
+	// they can be replayed to a subscriber which reconnects.
+	eventHistorySize = 1024
+)
+
+// ErrEventSubscriptionOverflow is the error of an event subscription which
+// was ended because it did not receive events as quickly as they occurred.
+var ErrEventSubscriptionOverflow = errors.New("subscription fell behind events")
+
+// EventType is the kind of an event.
+type EventType string
+
+const (
+	// EventLeaderChange is a change of the Leader, as observed by this node.
+	// The event names the new Leader, or no node if the Leader was lost.
+	EventLeaderChange EventType = "leader_change"
+
+	// EventNodeJoin is the addition of a node to the cluster, by this node
+	// as Leader.
+	EventNodeJoin EventType = "node_join"
+
+	// EventNodeRemove is the removal of a node from the cluster, by this
+	// node as Leader.
+	EventNodeRemove EventType = "node_remove"
+
+	// EventSnapshot is the creation of a snapshot by this node, up to the
+	// given log index.
+	EventSnapshot EventType = "snapshot"
+
+	// EventApplyError is the failure of a log entry, at the given index, to
+	// be applied to this node's database, other than because of an error in
+	// a statement it carries.
+	EventApplyError EventType = "apply_error"
+)
+
+// Event is an event in the cluster, as observed by this node.
+type Event struct {
+	// ID identifies the event among those published by this node. IDs
+	// increase with each event, and continue to do so across restarts of
+	// the node, as long as its clock does not go backwards.
+	ID    uint64    `json:"id"`
+	Type  EventType `json:"type"`
+	Time  time.Time `json:"time"`
+	Node  string    `json:"node,omitempty"`
+	Addr  string    `json:"addr,omitempty"`
+	Index uint64    `json:"index,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// EventSubscription is a subscription to the events observed by this node.
+type EventSubscription interface {
+	// Events returns the channel on which events are received. The channel
+	// is closed once the subscription ends, after which Err returns why it
+	// ended.
+	Events() <-chan Event
+
+	// Missed returns whether events were missed between the event after
+	// which the subscription was asked to start, and the first event
+	// received, because they are no longer retained.
+	Missed() bool
+
+	// Err returns the error which ended the subscription, if any. It must
+	// only be called once the events channel is closed.
+	Err() error
+
+	// Close ends the subscription.
+	Close()
+}
+
+// eventSubscription is an EventSubscription to the events published by an
+// eventBroker.
+type eventSubscription struct {
+	b      *eventBroker
+	ch     chan Event
+	missed bool
+	err    error
+}
+
+// Events implements the EventSubscription interface.
+func (es *eventSubscription) Events() <-chan Event {
+	return es.ch
+}
+
+// Missed implements the EventSubscription interface.
+func (es *eventSubscription) Missed() bool {
+	return es.missed
+}
+
+// Err implements the EventSubscription interface.
+func (es *eventSubscription) Err() error {
+	return es.err
+}
+
+// Close implements the EventSubscription interface.
+func (es *eventSubscription) Close() {
+	es.b.unsubscribe(es, nil)
+}
+
+// eventBroker fans out events to subscriptions, and retains the most recent
+// events so that they can be replayed. Events are sent without blocking, so
+// a subscription whose buffer is full is ended, rather than holding up the
+// publisher.
+type eventBroker struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event // Oldest first.
+	subs    map[*eventSubscription]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		// Starting from the time means IDs keep increasing across restarts,
+		// so an ID from before a restart is recognised as such.
+		lastID: uint64(time.Now().UnixNano()),
+		subs:   make(map[*eventSubscription]struct{}),
+	}
+}
+
+// subscribe returns a subscription to events. If replay is set, the retained
+// events after the event with the given ID are sent first.
+func (b *eventBroker) subscribe(replay bool, after uint64) *eventSubscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var events []Event
+	missed := false
+	if replay {
+		for _, e := range b.history {
+			if e.ID > after {
+				events = append(events, e)
+			}
+		}
+		// Events were missed if the first event retained is not the one
+		// after the given event, or if the given event is not one this
+		// broker has published.
+		missed = after > b.lastID ||
+			(len(b.history) > 0 && b.history[0].ID > after+1) ||
+			(len(b.history) == 0 && after < b.lastID)
+	}
+
+	es := &eventSubscription{
+		b:      b,
+		ch:     make(chan Event, eventBufferSize+len(events)),
+		missed: missed,
+	}
+	for _, e := range events {
+		es.ch <- e
+	}
+	b.subs[es] = struct{}{}
+	return es
+}
+
+// unsubscribe ends the given subscription, with the given error.
+func (b *eventBroker) unsubscribe(es *eventSubscription, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(es, err)
+}
+
+// remove removes the subscription, if it has not already been removed. b.mu
+// must be held.
+func (b *eventBroker) remove(es *eventSubscription, err error) {
+	if _, ok := b.subs[es]; !ok {
+		return
+	}
+	delete(b.subs, es)
+	es.err = err
+	close(es.ch)
+}
+
+// publish assigns the event its ID, and its time if not set, retains it, and
+// sends it to each subscription.
+func (b *eventBroker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	stats.Add(numEvents, 1)
+
+	if len(b.history) == eventHistorySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistorySize-1]
+	}
+	b.history = append(b.history, e)
+
+	for es := range b.subs {
+		select {
+		case es.ch <- e:
+		default:
+			stats.Add(numEventSubscriptionOverflows, 1)
+			b.remove(es, ErrEventSubscriptionOverflow)
+		}
+	}
+}
+
+// Len returns the number of subscriptions.
+func (b *eventBroker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
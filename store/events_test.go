@@ -0,0 +1,127 @@
+package store
+
+import (
+	"testing"
+)
+
+func Test_EventBroker(t *testing.T) {
+	b := newEventBroker()
+	sub1 := b.subscribe(false, 0)
+	sub2 := b.subscribe(false, 0)
+
+	b.publish(Event{Type: EventLeaderChange, Node: "1", Addr: "localhost:4002"})
+	b.publish(Event{Type: EventSnapshot, Index: 10})
+
+	for _, sub := range []EventSubscription{sub1, sub2} {
+		if sub.Missed() {
+			t.Fatalf("subscription not asked to replay reports missed events")
+		}
+		e1, e2 := <-sub.Events(), <-sub.Events()
+		if e1.Type != EventLeaderChange || e1.Node != "1" || e1.Addr != "localhost:4002" {
+			t.Fatalf("wrong first event: %+v", e1)
+		}
+		if e2.Type != EventSnapshot || e2.Index != 10 {
+			t.Fatalf("wrong second event: %+v", e2)
+		}
+		if e2.ID != e1.ID+1 {
+			t.Fatalf("event IDs not consecutive, got %d and %d", e1.ID, e2.ID)
+		}
+		if e1.Time.IsZero() {
+			t.Fatalf("event time not set")
+		}
+	}
+
+	sub1.Close()
+	if _, ok := <-sub1.Events(); ok {
+		t.Fatalf("events channel not closed")
+	}
+	if sub1.Err() != nil {
+		t.Fatalf("closed subscription has error: %s", sub1.Err())
+	}
+	sub2.Close()
+	if b.Len() != 0 {
+		t.Fatalf("wrong number of subscriptions, exp 0, got %d", b.Len())
+	}
+}
+
+func Test_EventBrokerReplay(t *testing.T) {
+	b := newEventBroker()
+	start := b.lastID
+
+	sub := b.subscribe(true, start)
+	if sub.Missed() {
+		t.Fatalf("subscription from latest event reports missed events")
+	}
+	sub.Close()
+
+	for i := 0; i < 3; i++ {
+		b.publish(Event{Type: EventSnapshot, Index: uint64(i)})
+	}
+
+	sub = b.subscribe(true, start+1)
+	defer sub.Close()
+	if sub.Missed() {
+		t.Fatalf("subscription from retained event reports missed events")
+	}
+	for _, exp := range []uint64{1, 2} {
+		if e := <-sub.Events(); e.Index != exp {
+			t.Fatalf("wrong replayed event, exp index %d, got %d", exp, e.Index)
+		}
+	}
+	b.publish(Event{Type: EventSnapshot, Index: 3})
+	if e := <-sub.Events(); e.Index != 3 {
+		t.Fatalf("wrong event after replay, exp index 3, got %d", e.Index)
+	}
+
+	// An event from before the node restarted, or from another node.
+	for _, after := range []uint64{start - 1, b.lastID + 1} {
+		sub := b.subscribe(true, after)
+		if !sub.Missed() {
+			t.Fatalf("subscription after unknown event %d does not report missed events", after)
+		}
+		sub.Close()
+	}
+}
+
+func Test_EventBrokerHistory(t *testing.T) {
+	b := newEventBroker()
+	start := b.lastID
+	for i := 0; i < eventHistorySize+1; i++ {
+		b.publish(Event{Type: EventSnapshot, Index: uint64(i)})
+	}
+	if len(b.history) != eventHistorySize {
+		t.Fatalf("wrong number of events retained, exp %d, got %d", eventHistorySize, len(b.history))
+	}
+
+	sub := b.subscribe(true, start)
+	defer sub.Close()
+	if !sub.Missed() {
+		t.Fatalf("subscription from event no longer retained does not report missed events")
+	}
+	if e := <-sub.Events(); e.Index != 1 {
+		t.Fatalf("wrong first replayed event, exp index 1, got %d", e.Index)
+	}
+}
+
+func Test_EventBrokerOverflow(t *testing.T) {
+	b := newEventBroker()
+	sub := b.subscribe(false, 0)
+	defer sub.Close()
+
+	for i := 0; i <= eventBufferSize; i++ {
+		b.publish(Event{Type: EventSnapshot, Index: uint64(i)})
+	}
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Fatalf("wrong number of events received, exp %d, got %d", eventBufferSize, n)
+	}
+	if sub.Err() != ErrEventSubscriptionOverflow {
+		t.Fatalf("wrong error for subscription, exp %v, got %v", ErrEventSubscriptionOverflow, sub.Err())
+	}
+	if b.Len() != 0 {
+		t.Fatalf("wrong number of subscriptions, exp 0, got %d", b.Len())
+	}
+}
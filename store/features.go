@@ -0,0 +1,188 @@
+package store
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Features of the Raft log commands this node can apply. A Leader only writes
+// a command using a feature once every node in the cluster reports that it
+// supports the feature, so a node which has not yet been upgraded never sees
+// a command it would misapply.
+const (
+	featureExecuteBatch    = "execute_batch"
+	featureIdempotencyKeys = "idempotency_keys"
+)
+
+var features = []string{
+	featureExecuteBatch,
+	featureIdempotencyKeys,
+}
+
+const (
+	// featureCheckInterval is how long the result of checking whether every
+	// node supports a feature is used, unless cluster membership changes.
+	featureCheckInterval = 10 * time.Second
+
+	featureCheckTimeout = 5 * time.Second
+
+	// featureCheckPollInterval is how often a caller waiting for the other
+	// nodes to answer checks whether they have.
+	featureCheckPollInterval = 10 * time.Millisecond
+)
+
+// FeaturesGetter is the interface that wraps the GetNodeFeatures method.
+// GetNodeFeatures returns the features of the Raft log commands supported by
+// the node at the given Raft address.
+type FeaturesGetter interface {
+	GetNodeFeatures(addr string, timeout time.Duration) ([]string, error)
+}
+
+// featureCheck is the result of checking whether every node in the cluster
+// supports a feature.
+type featureCheck struct {
+	supported bool
+	members   string
+	at        time.Time
+}
+
+// featureChecker checks, and caches, whether every node in the cluster
+// supports a feature. Nodes are asked in the background, so that a caller
+// never waits on a slow node.
+type featureChecker struct {
+	mu       sync.Mutex
+	getter   FeaturesGetter
+	gen      uint64 // Incremented each time getter is set.
+	checks   map[string]featureCheck
+	checking map[string]uint64 // Generation of each check in progress.
+}
+
+// Features returns the features of the Raft log commands this node supports.
+func (s *Store) Features() []string {
+	return features
+}
+
+// SetFeaturesGetter sets the FeaturesGetter used to learn which features the
+// other nodes in the cluster support. Until it is set, features are only
+// used if this node is the only one in the cluster.
+func (s *Store) SetFeaturesGetter(fg FeaturesGetter) {
+	s.featureChecker.mu.Lock()
+	defer s.featureChecker.mu.Unlock()
+	s.featureChecker.getter = fg
+	s.featureChecker.gen++
+	s.featureChecker.checks = nil
+}
+
+// clusterSupports returns whether every node in the cluster supports the
+// given feature. If the cached result is out of date, the other nodes are
+// asked again in the background. Until they have answered, the previous
+// result is returned if membership has not changed since, and otherwise
+// false. A node which cannot be asked is taken not to support the feature.
+func (s *Store) clusterSupports(feature string) bool {
+	supported, _ := s.clusterFeature(feature)
+	return supported
+}
+
+// clusterSupportsWait is like clusterSupports, but if whether every node
+// supports the feature is not yet known for the current membership, it waits
+// up to timeout for the other nodes to answer. It also returns whether the
+// answer is known, which it is not if the nodes did not answer in time.
+func (s *Store) clusterSupportsWait(feature string, timeout time.Duration) (supported, known bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		supported, known = s.clusterFeature(feature)
+		if known || time.Now().After(deadline) {
+			return supported, known
+		}
+		time.Sleep(featureCheckPollInterval)
+	}
+}
+
+// clusterFeature returns whether every node in the cluster supports the given
+// feature, as clusterSupports does, and whether that is known for the current
+// membership, rather than assumed while the other nodes are asked.
+func (s *Store) clusterFeature(feature string) (supported, known bool) {
+	f := s.raft.GetConfiguration()
+	if f.Error() != nil {
+		return false, false
+	}
+	var others []raft.Server
+	var b strings.Builder
+	for _, srv := range f.Configuration().Servers {
+		b.WriteString(string(srv.ID) + "@" + string(srv.Address) + ",")
+		if srv.ID != raft.ServerID(s.raftID) {
+			others = append(others, srv)
+		}
+	}
+	members := b.String()
+
+	fc := &s.featureChecker
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	c, ok := fc.checks[feature]
+	if ok && c.members == members && time.Since(c.at) < featureCheckInterval {
+		return c.supported, true
+	}
+	if len(others) == 0 || fc.getter == nil {
+		fc.store(feature, featureCheck{supported: len(others) == 0, members: members, at: time.Now()})
+		return len(others) == 0, true
+	}
+	if gen, ok := fc.checking[feature]; !ok || gen != fc.gen {
+		if fc.checking == nil {
+			fc.checking = make(map[string]uint64)
+		}
+		fc.checking[feature] = fc.gen
+		go fc.check(fc.getter, fc.gen, feature, members, others)
+	}
+	known = ok && c.members == members
+	return known && c.supported, known
+}
+
+// check asks each of the given nodes, concurrently, whether it supports the
+// feature, and caches the result unless the getter has since been replaced.
+func (fc *featureChecker) check(getter FeaturesGetter, gen uint64, feature, members string, servers []raft.Server) {
+	var supported atomic.Bool
+	supported.Store(true)
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			fs, err := getter.GetNodeFeatures(addr, featureCheckTimeout)
+			if err != nil || !hasFeature(fs, feature) {
+				supported.Store(false)
+			}
+		}(string(srv.Address))
+	}
+	wg.Wait()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.checking[feature] == gen {
+		delete(fc.checking, feature)
+	}
+	if fc.gen == gen {
+		fc.store(feature, featureCheck{supported: supported.Load(), members: members, at: time.Now()})
+	}
+}
+
+// store caches the result of a check. It must be called with mu held.
+func (fc *featureChecker) store(feature string, c featureCheck) {
+	if fc.checks == nil {
+		fc.checks = make(map[string]featureCheck)
+	}
+	fc.checks[feature] = c
+}
+
+func hasFeature(fs []string, feature string) bool {
+	for _, f := range fs {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+	sql "github.com/rqlite/rqlite/v8/db"
+)
+
+// IdempotencyTable is the table in which the results of execute requests
+// carrying idempotency keys are recorded. It is written as log entries are
+// applied, so it is part of the database, and of every snapshot and backup
+// of it, and every node holds the same records.
+const IdempotencyTable = "rqlite_idempotency"
+
+var createIdempotencyTable = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, request_key TEXT NOT NULL UNIQUE, digest BLOB NOT NULL, results TEXT NOT NULL, expires INTEGER NOT NULL)`, IdempotencyTable)
+
+// idempotentResult is the recorded result of one statement of an execute
+// request carrying an idempotency key.
+type idempotentResult struct {
+	LastInsertID int64  `json:"last_insert_id,omitempty"`
+	RowsAffected int64  `json:"rows_affected,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// replayIdempotent returns the recorded results of an earlier request with
+// the key of the given request, marked as replayed, and true. If the earlier
+// request differs from the given one, ErrIdempotencyKeyConflict is returned
+// instead. If there is no unexpired record it returns false. Records which
+// have expired by now, the time the request was appended to the log, are
+// removed first.
+func replayIdempotent(db *sql.SwappableDB, er *proto.ExecuteRequest, now time.Time) ([]*proto.ExecuteResult, bool, error) {
+	resps, err := db.Request(&proto.Request{
+		Transaction: true,
+		Statements: []*proto.Statement{
+			{Sql: createIdempotencyTable},
+			{
+				Sql:        fmt.Sprintf(`DELETE FROM %s WHERE expires <= ?`, IdempotencyTable),
+				Parameters: []*proto.Parameter{{Value: &proto.Parameter_I{I: now.UnixNano()}}},
+			},
+			{
+				Sql:        fmt.Sprintf(`SELECT digest, results FROM %s WHERE request_key = ?`, IdempotencyTable),
+				Parameters: []*proto.Parameter{{Value: &proto.Parameter_S{S: er.IdempotencyKey}}},
+			},
+		},
+	}, false)
+	if err := requestError(resps, err); err != nil {
+		return nil, false, err
+	}
+	rows := resps[len(resps)-1].GetQ()
+	if rows == nil || len(rows.Values) == 0 {
+		return nil, false, nil
+	}
+
+	v := rows.Values[0].Parameters
+	if len(v) != 2 {
+		return nil, false, errors.New("malformed idempotency record")
+	}
+	if !bytes.Equal(v[0].GetY(), er.IdempotencyDigest) {
+		return nil, true, ErrIdempotencyKeyConflict
+	}
+	var recorded []idempotentResult
+	if err := json.Unmarshal([]byte(v[1].GetS()), &recorded); err != nil {
+		return nil, false, fmt.Errorf("malformed idempotency record: %s", err.Error())
+	}
+	results := make([]*proto.ExecuteResult, len(recorded))
+	for i, r := range recorded {
+		results[i] = &proto.ExecuteResult{
+			LastInsertId: r.LastInsertID,
+			RowsAffected: r.RowsAffected,
+			Error:        r.Error,
+			Replayed:     true,
+		}
+	}
+	return results, true, nil
+}
+
+// recordIdempotent records the results of applying the given request, until
+// its window has passed, measured from now, the time the request was
+// appended to the log. The oldest records are removed while more are held
+// than the request allows.
+func recordIdempotent(db *sql.SwappableDB, er *proto.ExecuteRequest, results []*proto.ExecuteResult, now time.Time) error {
+	if er.IdempotencyWindow <= 0 {
+		return nil
+	}
+	recorded := make([]idempotentResult, len(results))
+	for i, r := range results {
+		recorded[i] = idempotentResult{
+			LastInsertID: r.LastInsertId,
+			RowsAffected: r.RowsAffected,
+			Error:        r.Error,
+		}
+	}
+	b, err := json.Marshal(recorded)
+	if err != nil {
+		return err
+	}
+
+	stmts := []*proto.Statement{
+		{Sql: createIdempotencyTable},
+		{
+			Sql:        fmt.Sprintf(`DELETE FROM %s WHERE request_key = ?`, IdempotencyTable),
+			Parameters: []*proto.Parameter{{Value: &proto.Parameter_S{S: er.IdempotencyKey}}},
+		},
+		{
+			Sql: fmt.Sprintf(`INSERT INTO %s(request_key, digest, results, expires) VALUES(?, ?, ?, ?)`, IdempotencyTable),
+			Parameters: []*proto.Parameter{
+				{Value: &proto.Parameter_S{S: er.IdempotencyKey}},
+				{Value: &proto.Parameter_Y{Y: er.IdempotencyDigest}},
+				{Value: &proto.Parameter_S{S: string(b)}},
+				{Value: &proto.Parameter_I{I: now.Add(time.Duration(er.IdempotencyWindow)).UnixNano()}},
+			},
+		},
+	}
+	if er.IdempotencyMaxKeys > 0 {
+		stmts = append(stmts, &proto.Statement{
+			Sql:        fmt.Sprintf(`DELETE FROM %s WHERE id NOT IN (SELECT id FROM %s ORDER BY id DESC LIMIT ?)`, IdempotencyTable, IdempotencyTable),
+			Parameters: []*proto.Parameter{{Value: &proto.Parameter_I{I: er.IdempotencyMaxKeys}}},
+		})
+	}
+	return requestError(db.Request(&proto.Request{Transaction: true, Statements: stmts}, false))
+}
+
+// requestError returns the error of a request, or of the first of its
+// statements which failed.
+func requestError(resps []*proto.ExecuteQueryResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, r := range resps {
+		if e := r.GetError(); e != "" {
+			return errors.New(e)
+		}
+		if e := r.GetE().GetError(); e != "" {
+			return errors.New(e)
+		}
+		if e := r.GetQ().GetError(); e != "" {
+			return errors.New(e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+	sql "github.com/rqlite/rqlite/v8/db"
+)
+
+func idempotentRequest(key, body string) *proto.ExecuteRequest {
+	return &proto.ExecuteRequest{
+		IdempotencyKey:     key,
+		IdempotencyDigest:  []byte(body),
+		IdempotencyWindow:  int64(time.Minute),
+		IdempotencyMaxKeys: 2,
+	}
+}
+
+func mustOpenIdempotencyDB(t *testing.T) *sql.SwappableDB {
+	t.Helper()
+	db, err := sql.OpenSwappable(filepath.Join(t.TempDir(), "db.sqlite"), false, true, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_IdempotencyRecords(t *testing.T) {
+	db := mustOpenIdempotencyDB(t)
+	now := time.Now()
+
+	a := idempotentRequest("a", "body")
+	if _, ok, err := replayIdempotent(db, a, now); ok || err != nil {
+		t.Fatalf("expected no record for new key, got %v, %v", ok, err)
+	}
+	if err := recordIdempotent(db, a, []*proto.ExecuteResult{{LastInsertId: 3, RowsAffected: 1}}, now); err != nil {
+		t.Fatalf("failed to record key: %s", err.Error())
+	}
+
+	results, ok, err := replayIdempotent(db, a, now.Add(time.Second))
+	if !ok || err != nil {
+		t.Fatalf("expected record for key, got %v, %v", ok, err)
+	}
+	if len(results) != 1 || results[0].LastInsertId != 3 || results[0].RowsAffected != 1 || !results[0].Replayed {
+		t.Fatalf("wrong replayed results: %v", results)
+	}
+	if _, ok, err := replayIdempotent(db, idempotentRequest("a", "other"), now); !ok || !errors.Is(err, ErrIdempotencyKeyConflict) {
+		t.Fatalf("expected conflict for different request, got %v, %v", ok, err)
+	}
+
+	// Keys past the window expire.
+	if _, ok, _ := replayIdempotent(db, a, now.Add(2*time.Minute)); ok {
+		t.Fatalf("expected expired key")
+	}
+}
+
+func Test_IdempotencyRecordsEvicts(t *testing.T) {
+	db := mustOpenIdempotencyDB(t)
+	now := time.Now()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := recordIdempotent(db, idempotentRequest(k, "body"), nil, now); err != nil {
+			t.Fatalf("failed to record key %s: %s", k, err.Error())
+		}
+	}
+
+	if _, ok, _ := replayIdempotent(db, idempotentRequest("a", "body"), now); ok {
+		t.Fatalf("oldest key not evicted")
+	}
+	for _, k := range []string{"b", "c"} {
+		if _, ok, _ := replayIdempotent(db, idempotentRequest(k, "body"), now); !ok {
+			t.Fatalf("key %s evicted", k)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package store
 
+import "time"
+
 // Server represents another node in the cluster.
 type Server struct {
 	ID       string `json:"id,omitempty"`
 	Addr     string `json:"addr,omitempty"`
 	Suffrage string `json:"suffrage,omitempty"`
+
+	// LastContact is, on the Leader, the time the node last responded to
+	// the Leader. It is zero for the Leader itself, on other nodes, and if
+	// the node has not responded to this node since it started.
+	LastContact time.Time `json:"-"`
 }
 
 // NewServer returns an initialized Server.
@@ -59,6 +66,21 @@ func (s Servers) Contains(id string) bool {
 	return false
 }
 
+// IDForAddr returns the Raft ID of the node, in the set of servers, with the
+// given Raft address. If no node has the address then found will be false.
+func (s Servers) IDForAddr(addr string) (id string, found bool) {
+	if s == nil || addr == "" {
+		return "", false
+	}
+
+	for _, n := range s {
+		if n != nil && n.Addr == addr {
+			return n.ID, true
+		}
+	}
+	return "", false
+}
+
 func (s Servers) Less(i, j int) bool { return s[i].ID < s[j].ID }
 func (s Servers) Len() int           { return len(s) }
 func (s Servers) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
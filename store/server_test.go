@@ -111,3 +111,22 @@ func Test_Contains(t *testing.T) {
 		})
 	}
 }
+
+func Test_IDForAddr(t *testing.T) {
+	servers := Servers([]*Server{
+		{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+		{ID: "node2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+	})
+	if id, found := servers.IDForAddr("localhost:4004"); !found || id != "node2" {
+		t.Fatalf("IDForAddr returned %s, %t, expected node2, true", id, found)
+	}
+	if _, found := servers.IDForAddr("localhost:4006"); found {
+		t.Fatalf("IDForAddr found node for non-existent address")
+	}
+	if _, found := servers.IDForAddr(""); found {
+		t.Fatalf("IDForAddr found node for empty address")
+	}
+	if _, found := Servers(nil).IDForAddr("localhost:4002"); found {
+		t.Fatalf("IDForAddr found node in empty servers")
+	}
+}
@@ -30,27 +30,42 @@ func IsStaleRead(
 		// Freshness not set, so no read can be stale.
 		return false
 	}
-	if time.Since(leaderlastContact).Nanoseconds() > freshness {
-		// The Leader has not been in contact witin the freshness window, so
-		// the read is stale.
-		return true
-	}
+	return Staleness(leaderlastContact, lastFSMUpdateTime, lastAppendedAtTime,
+		fsmIndex, commitIndex, strict).Nanoseconds() > freshness
+}
+
+// Staleness returns how stale a read would be, as judged by IsStaleRead.
+// That is the time since the Leader was last in contact, or, in strict mode,
+// if it is greater and the FSM is not caught up, the time between the Leader
+// appending the log entry which last updated the FSM and it being applied.
+func Staleness(
+	leaderlastContact time.Time,
+	lastFSMUpdateTime time.Time,
+	lastAppendedAtTime time.Time,
+	fsmIndex uint64,
+	commitIndex uint64,
+	strict bool,
+) time.Duration {
+	staleness := time.Since(leaderlastContact)
 	if !strict {
 		// Strict mode is not enabled, so no further checks are needed.
-		return false
+		return staleness
 	}
 	if lastAppendedAtTime.IsZero() {
 		// We've yet to be told about any appended log entries, so we
 		// assume we're caught up.
-		return false
+		return staleness
 	}
 	if fsmIndex == commitIndex {
 		// FSM index is the same as the commit index, so we're caught up.
-		return false
+		return staleness
+	}
+	// OK, we're not caught up. So how long after the Leader appended the log
+	// that last updated our local FSM was it applied?
+	if d := lastFSMUpdateTime.Sub(lastAppendedAtTime); d > staleness {
+		return d
 	}
-	// OK, we're not caught up. So was the log that last updated our local FSM
-	// appended by the Leader to its log within the freshness window?
-	return lastFSMUpdateTime.Sub(lastAppendedAtTime).Nanoseconds() > freshness
+	return staleness
 }
 
 // IsNewNode returns whether a node using raftDir would be a brand-new node.
@@ -142,7 +157,7 @@ func RecoverNode(dataDir string, logger *log.Logger, logs raft.LogStore, stable
 	}
 
 	// Now, open the database so we can replay any outstanding Raft log entries.
-	db, err := sql.OpenSwappable(tmpDBPath, false, true)
+	db, err := sql.OpenSwappable(tmpDBPath, false, true, nil)
 	if err != nil {
 		return fmt.Errorf("failed to open temporary database: %s", err)
 	}
@@ -173,7 +188,7 @@ func RecoverNode(dataDir string, logger *log.Logger, logs raft.LogStore, stable
 			return fmt.Errorf("failed to get log at index %d: %v", index, err)
 		}
 		if entry.Type == raft.LogCommand {
-			cmdProc.Process(entry.Data, db)
+			cmdProc.Process(entry.Data, db, entry.AppendedAt)
 		}
 		lastIndex = entry.Index
 		lastTerm = entry.Term
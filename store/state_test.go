@@ -9,6 +9,19 @@ import (
 	"github.com/rqlite/rqlite/v8/command/proto"
 )
 
+func Test_Staleness(t *testing.T) {
+	now := time.Now()
+	if d := Staleness(now.Add(-time.Second), now, now.Add(-time.Hour), 1, 2, false); d < time.Second || d > time.Minute {
+		t.Fatalf("wrong non-strict staleness, got %s", d)
+	}
+	if d := Staleness(now.Add(-time.Second), now, now.Add(-time.Hour), 1, 2, true); d != time.Hour {
+		t.Fatalf("wrong strict staleness, got %s", d)
+	}
+	if d := Staleness(now.Add(-time.Second), now, now.Add(-time.Hour), 2, 2, true); d > time.Minute {
+		t.Fatalf("wrong strict staleness when caught up, got %s", d)
+	}
+}
+
 func Test_IsStaleRead(t *testing.T) {
 	tests := []struct {
 		Name               string
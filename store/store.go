@@ -6,6 +6,7 @@ package store
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"expvar"
@@ -50,6 +51,10 @@ var (
 	// operation.
 	ErrNotLeader = errors.New("not leader")
 
+	// ErrReadOnly is returned when a read-only node is asked to become, or
+	// is found to be, a voter.
+	ErrReadOnly = errors.New("node is read-only")
+
 	// ErrNotSingleNode is returned when a node attempts to execute a single-node
 	// only operation.
 	ErrNotSingleNode = errors.New("not single-node")
@@ -81,6 +86,27 @@ var (
 	// ErrLoadInProgress is returned when a load is already in progress and the
 	// requested operation cannot be performed.
 	ErrLoadInProgress = errors.New("load in progress")
+
+	// ErrSnapshotInProgress is returned when a snapshot cannot be performed
+	// because another snapshot, or an operation which blocks snapshotting, is
+	// in progress.
+	ErrSnapshotInProgress = errors.New("snapshot in progress")
+
+	// ErrStreamUnsupported is returned when a query cannot be streamed.
+	ErrStreamUnsupported = errors.New("query cannot be streamed")
+
+	// ErrIdempotencyKeyConflict is returned when an execute request carries
+	// the idempotency key of an earlier, different, request.
+	ErrIdempotencyKeyConflict = errors.New("idempotency key already used for a different request")
+
+	// ErrIdempotencyUnsupported is returned when an execute request carries an
+	// idempotency key, but not every node in the cluster supports them.
+	ErrIdempotencyUnsupported = errors.New("idempotency keys not supported by every node in the cluster")
+
+	// ErrIdempotencyUnknown is returned when an execute request carries an
+	// idempotency key, but the other nodes in the cluster have not yet said
+	// whether they support them. The request may be retried.
+	ErrIdempotencyUnknown = errors.New("support for idempotency keys not yet known, retry the request")
 )
 
 const (
@@ -105,6 +131,7 @@ const (
 	raftLogCacheSize           = 512
 	trailingScale              = 1.25
 	observerChanLen            = 50
+	defaultExecuteBatchSize    = 128
 
 	baseVacuumTimeKey = "rqlite_base_vacuum"
 	lastVacuumTimeKey = "rqlite_last_vacuum"
@@ -124,9 +151,20 @@ const (
 	numAutoVacuums                    = "num_auto_vacuums"
 	numAutoVacuumsFailed              = "num_auto_vacuums_failed"
 	autoVacuumDuration                = "auto_vacuum_duration"
+	numScheduledVacuums               = "num_scheduled_vacuums"
+	numExecuteBatches                 = "num_execute_batches"
+	numExecuteBatchedRequests         = "num_execute_batched_requests"
+	numScheduledVacuumsFailed         = "num_scheduled_vacuums_failed"
+	numScheduledVacuumsSkipped        = "num_scheduled_vacuums_skipped"
+	numReplicatedVacuums              = "num_replicated_vacuums"
+	numSlowRequests                   = "num_slow_requests"
+	numBusyRetries                    = "busy_retries"
+	numIdempotentReplays              = "idempotent_replays"
+	numBusyRetriesExhausted           = "busy_retries_exhausted"
 	numBoots                          = "num_boots"
 	numBackups                        = "num_backups"
 	numLoads                          = "num_loads"
+	numLoadChunks                     = "num_load_chunks"
 	numRestores                       = "num_restores"
 	numRestoresFailed                 = "num_restores_failed"
 	numAutoRestores                   = "num_auto_restores"
@@ -153,6 +191,9 @@ const (
 	failedHeartbeatObserved           = "failed_heartbeat_observed"
 	nodesReapedOK                     = "nodes_reaped_ok"
 	nodesReapedFailed                 = "nodes_reaped_failed"
+	numSubscriptionOverflows          = "num_subscription_overflows"
+	numEvents                         = "num_events"
+	numEventSubscriptionOverflows     = "num_event_subscription_overflows"
 )
 
 // stats captures stats for the Store.
@@ -179,9 +220,20 @@ func ResetStats() {
 	stats.Add(numAutoVacuums, 0)
 	stats.Add(numAutoVacuumsFailed, 0)
 	stats.Add(autoVacuumDuration, 0)
+	stats.Add(numScheduledVacuums, 0)
+	stats.Add(numExecuteBatches, 0)
+	stats.Add(numExecuteBatchedRequests, 0)
+	stats.Add(numScheduledVacuumsFailed, 0)
+	stats.Add(numScheduledVacuumsSkipped, 0)
+	stats.Add(numReplicatedVacuums, 0)
+	stats.Add(numSlowRequests, 0)
+	stats.Add(numBusyRetries, 0)
+	stats.Add(numIdempotentReplays, 0)
+	stats.Add(numBusyRetriesExhausted, 0)
 	stats.Add(numBoots, 0)
 	stats.Add(numBackups, 0)
 	stats.Add(numLoads, 0)
+	stats.Add(numLoadChunks, 0)
 	stats.Add(numRestores, 0)
 	stats.Add(numRestoresFailed, 0)
 	stats.Add(numRecoveries, 0)
@@ -208,6 +260,9 @@ func ResetStats() {
 	stats.Add(failedHeartbeatObserved, 0)
 	stats.Add(nodesReapedOK, 0)
 	stats.Add(nodesReapedFailed, 0)
+	stats.Add(numSubscriptionOverflows, 0)
+	stats.Add(numEvents, 0)
+	stats.Add(numEventSubscriptionOverflows, 0)
 }
 
 // SnapshotStore is the interface Snapshot stores must implement.
@@ -269,6 +324,22 @@ type Store struct {
 	snapshotWClose chan struct{}
 	snapshotWDone  chan struct{}
 
+	batcher        *executeBatcher
+	featureChecker featureChecker
+
+	vacSchedClose chan struct{}
+	vacSchedDone  chan struct{}
+	vacSchedMu    sync.Mutex
+	vacSchedLast  map[string]interface{} // Outcome of the last scheduled VACUUM check.
+
+	// Cached database file stats, refreshed periodically so reporting them
+	// does not query the database.
+	dbFileStatsClose chan struct{}
+	dbFileStatsDone  chan struct{}
+	dbFileStatsMu    sync.RWMutex
+	dbFileStats      *sql.FileStats
+	dbFileStatsTime  time.Time
+
 	// Snapshotting synchronization
 	queryTxMu   sync.RWMutex
 	snapshotCAS *CheckAndSet
@@ -282,10 +353,16 @@ type Store struct {
 	// The Leader that actually appended the log entry is not necessarily the current Leader.
 	appendedAtTime *AtomicTime
 
+	// leaderLostTime is when this node observed that it had lost the Leader.
+	// It is zero while a Leader is known. This is node-local time.
+	leaderLostTime *AtomicTime
+
 	// Latest log entry index which actually changed the database.
 	dbAppliedIdx *atomic.Uint64
 
 	reqMarshaller *command.RequestMarshaler // Request marshaler for writing to log.
+	changes       *changeBroker             // Subscriptions to changes to the database.
+	events        *eventBroker              // Subscriptions to cluster events.
 	raftLog       raft.LogStore             // Persistent log store.
 	raftStable    raft.StableStore          // Persistent k-v store.
 	boltStore     *rlog.Log                 // Physical store.
@@ -310,6 +387,10 @@ type Store struct {
 	bootstrapped    bool
 	notifyingNodes  map[string]*Server
 
+	// ReadOnly marks this node as a read replica, which never becomes a
+	// voter, and so never becomes the Leader.
+	ReadOnly bool
+
 	ShutdownOnRemove         bool
 	SnapshotThreshold        uint64
 	SnapshotThresholdWALSize uint64
@@ -322,6 +403,42 @@ type Store struct {
 	NoFreeListSync           bool
 	AutoVacInterval          time.Duration
 
+	// Scheduled VACUUM configuration. Only the Leader runs scheduled VACUUMs,
+	// which are replicated to the rest of the cluster through the Raft log.
+	VacSchedInterval     time.Duration // Period between scheduled VACUUM checks. 0 disables.
+	VacSchedFreeRatio    float64       // VACUUM only if the free-page ratio exceeds this. 0 means always.
+	VacSchedMaxWriteRate float64       // Skip if the log grew faster than this many entries/sec. 0 means never skip.
+
+	// DBFileStatsInterval is the period between refreshes of the database
+	// file stats reported by Stats. 0 means they are read only on open.
+	DBFileStatsInterval time.Duration
+
+	// SlowRequestThreshold is how long an execute, query, or request may
+	// take before it is logged as slow, along with its request ID. 0
+	// disables logging of slow requests.
+	SlowRequestThreshold time.Duration
+
+	// BusyRetries is the number of times an execute request which fails
+	// because the database is busy or locked is retried, by writing it to
+	// the Raft log again. 0 disables retries.
+	BusyRetries int
+
+	// BusyRetryBackoff is the delay before the first retry of a busy execute
+	// request. It doubles on each subsequent retry, and each delay is
+	// jittered, chosen at random between half and all of its full length.
+	BusyRetryBackoff time.Duration
+
+	// BusyRetryMaxWait is the total time the retries of a busy execute
+	// request may wait. If the request has a database timeout which is
+	// shorter, that is used instead. 0 means no limit other than the
+	// request's database timeout, if any.
+	BusyRetryMaxWait time.Duration
+
+	// Execute batching configuration. If enabled, the Leader coalesces
+	// Execute requests received within the window into a single log entry.
+	ExecuteBatchWindow time.Duration // 0 disables batching.
+	ExecuteBatchSize   int           // Maximum requests per batch. 0 means the default.
+
 	// Node-reaping configuration
 	ReapTimeout         time.Duration
 	ReapReadOnlyTimeout time.Duration
@@ -329,11 +446,12 @@ type Store struct {
 	numTrailingLogs uint64
 
 	// For whitebox testing
-	numAutoVacuums  int
-	numIgnoredJoins int
-	numNoops        *atomic.Uint64
-	numSnapshotsMu  sync.Mutex
-	numSnapshots    int
+	numAutoVacuums      int
+	numScheduledVacuums *atomic.Uint64
+	numIgnoredJoins     int
+	numNoops            *atomic.Uint64
+	numSnapshotsMu      sync.Mutex
+	numSnapshots        int
 }
 
 // Config represents the configuration of the underlying Store.
@@ -357,7 +475,7 @@ func New(ly Layer, c *Config) *Store {
 		dbPath = c.DBConf.OnDiskPath
 	}
 
-	return &Store{
+	s := &Store{
 		open:            NewAtomicBool(),
 		ly:              ly,
 		raftDir:         c.Dir,
@@ -379,9 +497,17 @@ func New(ly Layer, c *Config) *Store {
 		fsmIdx:          &atomic.Uint64{},
 		fsmUpdateTime:   NewAtomicTime(),
 		appendedAtTime:  NewAtomicTime(),
+		leaderLostTime:  NewAtomicTime(),
 		dbAppliedIdx:    &atomic.Uint64{},
 		numNoops:        &atomic.Uint64{},
+
+		numScheduledVacuums: &atomic.Uint64{},
 	}
+	s.changes = newChangeBroker(func(fn sql.ChangeFunc) {
+		s.db.SetChangeFunc(fn)
+	})
+	s.events = newEventBroker()
+	return s
 }
 
 // SetRestorePath sets the path to a file containing a copy of a
@@ -502,7 +628,7 @@ func (s *Store) Open() (retErr error) {
 		stats.Add(numRecoveries, 1)
 	}
 
-	s.db, err = createOnDisk(s.dbPath, s.dbConf.FKConstraints, true)
+	s.db, err = createOnDisk(s.dbPath, s.dbConf.FKConstraints, true, s.dbConf.ReadPragmas)
 	if err != nil {
 		return fmt.Errorf("failed to create on-disk database: %s", err)
 	}
@@ -535,6 +661,15 @@ func (s *Store) Open() (retErr error) {
 	}
 	s.raft = ra
 
+	if s.ReadOnly {
+		if err := s.checkNotVoter(); err != nil {
+			if sErr := s.raft.Shutdown().Error(); sErr != nil {
+				s.logger.Printf("failed to shut down Raft: %s", sErr.Error())
+			}
+			return err
+		}
+	}
+
 	// Open the observer channels.
 	s.observerChan = make(chan raft.Observation, observerChanLen)
 	s.observer = raft.NewObserver(s.observerChan, false, func(o *raft.Observation) bool {
@@ -550,6 +685,20 @@ func (s *Store) Open() (retErr error) {
 	// WAL-size triggered snapshotting.
 	s.snapshotWClose, s.snapshotWDone = s.runWALSnapshotting()
 
+	// Scheduled VACUUMs.
+	s.vacSchedClose, s.vacSchedDone = s.runVacuumScheduling()
+
+	// Database file stats.
+	s.dbFileStatsClose, s.dbFileStatsDone = s.runDBFileStats()
+
+	if s.ExecuteBatchWindow > 0 {
+		sz := s.ExecuteBatchSize
+		if sz <= 0 {
+			sz = defaultExecuteBatchSize
+		}
+		s.batcher = newExecuteBatcher(s.ExecuteBatchWindow, sz, s.executeBatch)
+	}
+
 	if err := s.initVacuumTime(); err != nil {
 		return fmt.Errorf("failed to initialize auto-vacuum times: %s", err.Error())
 	}
@@ -557,8 +706,12 @@ func (s *Store) Open() (retErr error) {
 }
 
 // Bootstrap executes a cluster bootstrap on this node, using the given
-// Servers as the configuration.
+// Servers as the configuration. A read-only node cannot be bootstrapped,
+// since it would be a voter.
 func (s *Store) Bootstrap(servers ...*Server) error {
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
 	raftServers := make([]raft.Server, len(servers))
 	for i := range servers {
 		raftServers[i] = raft.Server{
@@ -654,6 +807,12 @@ func (s *Store) Close(wait bool) (retErr error) {
 	close(s.snapshotWClose)
 	<-s.snapshotWDone
 
+	close(s.vacSchedClose)
+	<-s.vacSchedDone
+
+	close(s.dbFileStatsClose)
+	<-s.dbFileStatsDone
+
 	f := s.raft.Shutdown()
 	if wait {
 		if f.Error() != nil {
@@ -753,6 +912,17 @@ func (s *Store) IsLeader() bool {
 	return s.raft.State() == raft.Leader
 }
 
+// LeaderlessFor returns how long this node has been without a Leader, since
+// it observed the Leader being lost. It is zero while a Leader is known, and
+// if this node has never known a Leader.
+func (s *Store) LeaderlessFor() time.Duration {
+	t := s.leaderLostTime.Load()
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t)
+}
+
 // HasLeader returns true if the cluster has a leader, false otherwise.
 func (s *Store) HasLeader() bool {
 	if !s.open.Is() {
@@ -780,6 +950,22 @@ func (s *Store) IsVoter() (bool, error) {
 	return false, nil
 }
 
+// checkNotVoter returns ErrReadOnly if the Raft configuration this node
+// restored on opening holds it as a voter. Such a node must be removed from
+// the cluster, and rejoin, before it can be read-only.
+func (s *Store) checkNotVoter() error {
+	cfg := s.raft.GetConfiguration()
+	if err := cfg.Error(); err != nil {
+		return err
+	}
+	for _, srv := range cfg.Configuration().Servers {
+		if srv.ID == raft.ServerID(s.raftID) && srv.Suffrage == raft.Voter {
+			return fmt.Errorf("%w: configured as a voter", ErrReadOnly)
+		}
+	}
+	return nil
+}
+
 // State returns the current node's Raft state
 func (s *Store) State() ClusterState {
 	if !s.open.Is() {
@@ -861,6 +1047,14 @@ func (s *Store) CommitIndex() (uint64, error) {
 	return s.raft.CommitIndex(), nil
 }
 
+// AppliedIndex returns the Raft applied index.
+func (s *Store) AppliedIndex() (uint64, error) {
+	if !s.open.Is() {
+		return 0, ErrNotOpen
+	}
+	return s.raft.AppliedIndex(), nil
+}
+
 // LeaderCommitIndex returns the Raft leader commit index, as indicated
 // by the latest AppendEntries RPC. If this node is the Leader then the
 // commit index is returned directly from the Raft object.
@@ -887,12 +1081,18 @@ func (s *Store) Nodes() ([]*Server, error) {
 
 	rs := f.Configuration().Servers
 	servers := make([]*Server, len(rs))
+	isLeader := s.raft.State() == raft.Leader
 	for i := range rs {
 		servers[i] = &Server{
 			ID:       string(rs[i].ID),
 			Addr:     string(rs[i].Address),
 			Suffrage: rs[i].Suffrage.String(),
 		}
+		if isLeader && rs[i].ID != raft.ServerID(s.raftID) {
+			if t, ok := s.raftTn.LastContact(rs[i].ID); ok {
+				servers[i].LastContact = t
+			}
+		}
 	}
 
 	sort.Sort(Servers(servers))
@@ -1025,6 +1225,14 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	fi, li, err := s.boltStore.Indexes()
+	if err != nil {
+		return nil, err
+	}
+	raftStats["log_entries"] = uint64(0)
+	if li > 0 && li >= fi {
+		raftStats["log_entries"] = li - fi + 1
+	}
 	raftStats["voter"], err = s.IsVoter()
 	if err != nil {
 		return nil, err
@@ -1042,6 +1250,7 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 		"node_id":          s.raftID,
 		"raft":             raftStats,
 		"fsm_index":        s.fsmIdx.Load(),
+		"fsm_lag":          lag(s.raft.CommitIndex(), s.fsmIdx.Load()),
 		"fsm_update_time":  s.fsmUpdateTime.Load(),
 		"db_applied_index": s.dbAppliedIdx.Load(),
 		"addr":             s.Addr(),
@@ -1062,6 +1271,7 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 		"snapshot_interval":      s.SnapshotInterval.String(),
 		"reap_timeout":           s.ReapTimeout.String(),
 		"reap_read_only_timeout": s.ReapReadOnlyTimeout.String(),
+		"read_only":              s.ReadOnly,
 		"no_freelist_sync":       s.NoFreeListSync,
 		"trailing_logs":          s.numTrailingLogs,
 		"request_marshaler":      s.reqMarshaller.Stats(),
@@ -1071,6 +1281,8 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 		"dir_size_friendly":      friendlyBytes(uint64(dirSz)),
 		"sqlite3":                dbStatus,
 		"db_conf":                s.dbConf,
+		"subscriptions":          s.changes.Len(),
+		"event_subscriptions":    s.events.Len(),
 	}
 
 	if s.AutoVacInterval > 0 {
@@ -1088,6 +1300,37 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 		status["auto_vacuum"] = avm
 	}
 
+	s.dbFileStatsMu.RLock()
+	if fs := s.dbFileStats; fs != nil {
+		dfm := map[string]interface{}{
+			"file_size":          fs.FileSize,
+			"file_size_friendly": friendlyBytes(uint64(fs.FileSize)),
+			"page_size":          fs.PageSize,
+			"page_count":         fs.PageCount,
+			"freelist_count":     fs.FreelistCount,
+			"updated_at":         s.dbFileStatsTime,
+		}
+		if s.db.WALEnabled() {
+			dfm["wal_size"] = fs.WALSize
+		}
+		status["db_file"] = dfm
+	}
+	s.dbFileStatsMu.RUnlock()
+
+	if s.VacSchedInterval > 0 {
+		svm := map[string]interface{}{
+			"interval":       s.VacSchedInterval.String(),
+			"free_ratio":     s.VacSchedFreeRatio,
+			"max_write_rate": s.VacSchedMaxWriteRate,
+		}
+		s.vacSchedMu.Lock()
+		if s.vacSchedLast != nil {
+			svm["last_run"] = s.vacSchedLast
+		}
+		s.vacSchedMu.Unlock()
+		status["scheduled_vacuum"] = svm
+	}
+
 	// Snapshot stats may be in flux if a snapshot is in progress. Only
 	// report them if they are available.
 	snapsStats, err := s.snapshotStore.Stats()
@@ -1099,6 +1342,14 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 
 // Execute executes queries that return no rows, but do modify the database.
 func (s *Store) Execute(ex *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+	return s.ExecuteContext(context.Background(), ex)
+}
+
+// ExecuteContext is like Execute, but a request which fails because the
+// database is busy is not retried once ctx is done, nor if the retry would
+// wait beyond ctx's deadline.
+func (s *Store) ExecuteContext(ctx context.Context, ex *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+	defer s.logIfSlow("execute", ex.GetRequest(), time.Now())
 	if !s.open.Is() {
 		return nil, ErrNotOpen
 	}
@@ -1109,7 +1360,82 @@ func (s *Store) Execute(ex *proto.ExecuteRequest) ([]*proto.ExecuteResult, error
 	if !s.Ready() {
 		return nil, ErrNotReady
 	}
-	return s.execute(ex)
+	if ex.IdempotencyKey != "" {
+		// Until the other nodes have answered, whether they can apply the
+		// request is unknown, so wait for them, rather than failing the
+		// first requests after startup or a change of Leader.
+		supported, known := s.clusterSupportsWait(featureIdempotencyKeys, featureCheckTimeout)
+		if !known {
+			return nil, ErrIdempotencyUnknown
+		}
+		if !supported {
+			return nil, ErrIdempotencyUnsupported
+		}
+	}
+	return s.retryBusy(ctx, ex, func() ([]*proto.ExecuteResult, error) {
+		if s.batcher != nil {
+			return s.batcher.Execute(ex)
+		}
+		return s.execute(ex)
+	})
+}
+
+// executeBatch applies the given requests to the database through a single
+// Raft log entry, returning the response to each request. Until every node
+// in the cluster can apply a batch, each request is written to the log as
+// its own entry, concurrently, as if it had never been batched.
+func (s *Store) executeBatch(ers []*proto.ExecuteRequest) ([]*fsmExecuteResponse, error) {
+	if len(ers) == 1 || !s.clusterSupports(featureExecuteBatch) {
+		resps := make([]*fsmExecuteResponse, len(ers))
+		var wg sync.WaitGroup
+		for i := range ers {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r, err := s.execute(ers[i])
+				resps[i] = &fsmExecuteResponse{results: r, error: err}
+			}(i)
+		}
+		wg.Wait()
+		return resps, nil
+	}
+
+	b, compressed, err := s.tryCompress(command.ExecuteBatchRequester{
+		ExecuteBatchRequest: &proto.ExecuteBatchRequest{Requests: ers},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &proto.Command{
+		Type:       proto.Command_COMMAND_TYPE_EXECUTE_BATCH,
+		SubCommand: b,
+		Compressed: compressed,
+	}
+	b, err = command.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	af := s.raft.Apply(b, s.ApplyTimeout)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return nil, ErrNotLeader
+		}
+		return nil, af.Error()
+	}
+	stats.Add(numExecuteBatches, 1)
+	stats.Add(numExecuteBatchedRequests, int64(len(ers)))
+	switch r := af.Response().(type) {
+	case *fsmExecuteBatchResponse:
+		return r.responses, nil
+	case *fsmGenericResponse:
+		if r.error != nil {
+			return nil, r.error
+		}
+		return nil, errors.New("execute batch not applied")
+	default:
+		return nil, fmt.Errorf("unexpected execute batch response: %T", r)
+	}
 }
 
 func (s *Store) execute(ex *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
@@ -1142,6 +1468,14 @@ func (s *Store) execute(ex *proto.ExecuteRequest) ([]*proto.ExecuteResult, error
 
 // Query executes queries that return rows, and do not modify the database.
 func (s *Store) Query(qr *proto.QueryRequest) ([]*proto.QueryRows, error) {
+	return s.QueryContext(context.Background(), qr)
+}
+
+// QueryContext is like Query, but queries run against the local database
+// are interrupted if ctx is done. Queries with STRONG read consistency are
+// applied through the Raft log, and so are not interrupted.
+func (s *Store) QueryContext(ctx context.Context, qr *proto.QueryRequest) ([]*proto.QueryRows, error) {
+	defer s.logIfSlow("query", qr.GetRequest(), time.Now())
 	if !s.open.Is() {
 		return nil, ErrNotOpen
 	}
@@ -1196,11 +1530,64 @@ func (s *Store) Query(qr *proto.QueryRequest) ([]*proto.QueryRows, error) {
 		defer s.queryTxMu.RUnlock()
 	}
 
-	return s.db.Query(qr.Request, qr.Timings)
+	return s.db.QueryContext(ctx, qr.Request, qr.Timings)
+}
+
+// QueryStream executes a single query, calling fn for each row as it is read
+// from the database, so the full result is never held in memory. Reads with
+// STRONG consistency go through the Raft log, and cannot be streamed.
+func (s *Store) QueryStream(qr *proto.QueryRequest, fn sql.RowFunc) error {
+	if !s.open.Is() {
+		return ErrNotOpen
+	}
+	if len(qr.Request.Statements) != 1 || qr.Level == proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		return ErrStreamUnsupported
+	}
+
+	if qr.Level == proto.QueryRequest_QUERY_REQUEST_LEVEL_WEAK && s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	if qr.Level == proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE && s.isStaleRead(qr.Freshness, qr.FreshnessStrict) {
+		return ErrStaleRead
+	}
+
+	return s.db.QueryStream(qr.Request.Statements[0], time.Duration(qr.Request.DbTimeout), fn)
+}
+
+// Validate checks, without executing them or writing to the Raft log, that
+// the statements of the execute request are valid against the local database.
+// A result is returned for each statement, holding an error if it is invalid.
+// It may be called on any node.
+func (s *Store) Validate(er *proto.ExecuteRequest) ([]*proto.ExecuteResult, error) {
+	if !s.open.Is() {
+		return nil, ErrNotOpen
+	}
+	return s.db.Validate(er.Request)
+}
+
+// Subscribe returns a subscription to the changes made to the given tables,
+// as they are applied to the local database. It may be called on any node.
+func (s *Store) Subscribe(tables []string) (Subscription, error) {
+	if !s.open.Is() {
+		return nil, ErrNotOpen
+	}
+	return s.changes.subscribe(tables), nil
+}
+
+// SubscribeEvents returns a subscription to the cluster events observed by
+// this node. If replay is set, the retained events published after the
+// event with the given ID are received first. It may be called on any node.
+func (s *Store) SubscribeEvents(replay bool, after uint64) (EventSubscription, error) {
+	if !s.open.Is() {
+		return nil, ErrNotOpen
+	}
+	return s.events.subscribe(replay, after), nil
 }
 
 // Request processes a request that may contain both Executes and Queries.
 func (s *Store) Request(eqr *proto.ExecuteQueryRequest) ([]*proto.ExecuteQueryResponse, error) {
+	defer s.logIfSlow("request", eqr.GetRequest(), time.Now())
 	if !s.open.Is() {
 		return nil, ErrNotOpen
 	}
@@ -1365,6 +1752,35 @@ func (s *Store) Backup(br *proto.BackupRequest, dst io.Writer) (retErr error) {
 	return ErrInvalidBackupFormat
 }
 
+// CopyFile writes a consistent copy of this node's SQLite database file to
+// dst. The copy is made using SQLite's online backup API, so it is safe to
+// call while the database is being written. Unlike Backup, leadership is not
+// checked and no snapshot is triggered.
+func (s *Store) CopyFile(dst io.Writer) (retErr error) {
+	if !s.open.Is() {
+		return ErrNotOpen
+	}
+
+	startT := time.Now()
+	defer func() {
+		if retErr == nil {
+			s.logger.Printf("database file copied in %s", time.Since(startT))
+		}
+	}()
+
+	fd, err := createTemp(s.dbDir, backupScatchPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+	if err := s.db.Backup(fd.Name(), false); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, fd)
+	return err
+}
+
 // Loads an entire SQLite file into the database, sending the request
 // through the Raft log.
 func (s *Store) Load(lr *proto.LoadRequest) error {
@@ -1416,6 +1832,49 @@ func (s *Store) load(lr *proto.LoadRequest) error {
 	return nil
 }
 
+// LoadChunk applies a chunk of a SQLite file being streamed into the database.
+// Chunks are reassembled on every node, and the database is swapped for the
+// file once the last chunk of the stream is applied. A chunk with Abort set
+// discards the chunks of the stream applied so far.
+func (s *Store) LoadChunk(lcr *proto.LoadChunkRequest) error {
+	if !s.open.Is() {
+		return ErrNotOpen
+	}
+
+	if !s.Ready() {
+		return ErrNotReady
+	}
+
+	b, err := command.MarshalLoadChunkRequest(lcr)
+	if err != nil {
+		return err
+	}
+	c := &proto.Command{
+		Type:       proto.Command_COMMAND_TYPE_LOAD_CHUNK,
+		SubCommand: b,
+	}
+	b, err = command.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	af := s.raft.Apply(b, s.ApplyTimeout)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return ErrNotLeader
+		}
+		return af.Error()
+	}
+	if r, ok := af.Response().(*fsmGenericResponse); ok && r.error != nil {
+		return r.error
+	}
+	stats.Add(numLoadChunks, 1)
+	if lcr.IsLast {
+		stats.Add(numLoads, 1)
+	}
+	return nil
+}
+
 // ReadFrom reads data from r, and loads it into the database, bypassing Raft consensus.
 // Once the data is loaded, a snapshot is triggered, which then results in a system as
 // if the data had been loaded through Raft consensus.
@@ -1567,7 +2026,7 @@ func (s *Store) Notify(nr *proto.NotifyRequest) error {
 		return fmt.Errorf("failed to resolve %s: %w", addr, err)
 	}
 
-	s.notifyingNodes[nr.Id] = &Server{nr.Id, nr.Address, "voter"}
+	s.notifyingNodes[nr.Id] = &Server{ID: nr.Id, Addr: nr.Address, Suffrage: "voter"}
 	if len(s.notifyingNodes) < s.BootstrapExpect {
 		return nil
 	}
@@ -1659,6 +2118,7 @@ func (s *Store) Join(jr *proto.JoinRequest) error {
 	}
 
 	stats.Add(numJoins, 1)
+	s.events.publish(Event{Type: EventNodeJoin, Node: id, Addr: addr})
 	s.logger.Printf("node with ID %s, at %s, joined successfully as %s", id, addr, prettyVoter(voter))
 	return nil
 }
@@ -1703,6 +2163,45 @@ func (s *Store) Noop(id string) (raft.ApplyFuture, error) {
 	return s.raft.Apply(bc, s.ApplyTimeout), nil
 }
 
+// logIfSlow logs the given request if it has taken longer than the slow
+// request threshold since start. The log line carries the request ID, so it
+// can be matched with the HTTP request which led to it, on whichever node
+// that request was received.
+func (s *Store) logIfSlow(kind string, req *proto.Request, start time.Time) {
+	if s.SlowRequestThreshold <= 0 {
+		return
+	}
+	d := time.Since(start)
+	if d < s.SlowRequestThreshold {
+		return
+	}
+	stats.Add(numSlowRequests, 1)
+	id := req.GetRequestId()
+	if id == "" {
+		id = "-"
+	}
+	s.logger.Printf("slow %s of %d statements took %s (request ID %s)",
+		kind, len(req.GetStatements()), d, id)
+}
+
+// StmtReadOnly returns whether SQLite, preparing the given single SQL
+// statement against the local database, considers it read-only.
+func (s *Store) StmtReadOnly(sql string) (bool, error) {
+	if !s.open.Is() {
+		return false, ErrNotOpen
+	}
+	return s.db.StmtReadOnly(sql)
+}
+
+// StmtTables returns the tables which SQLite, preparing the given single SQL
+// statement against the local database, reports the statement accesses.
+func (s *Store) StmtTables(sql string) ([]string, error) {
+	if !s.open.Is() {
+		return nil, ErrNotOpen
+	}
+	return s.db.StmtTables(sql)
+}
+
 // RORWCount returns the number of read-only and read-write statements in the
 // given ExecuteQueryRequest.
 func (s *Store) RORWCount(eqr *proto.ExecuteQueryRequest) (nRW, nRO int) {
@@ -1727,6 +2226,9 @@ func (s *Store) remove(id string) error {
 	if f.Error() != nil && f.Error() == raft.ErrNotLeader {
 		return ErrNotLeader
 	}
+	if f.Error() == nil {
+		s.events.publish(Event{Type: EventNodeRemove, Node: id})
+	}
 	return f.Error()
 }
 
@@ -1802,6 +2304,22 @@ func (s *Store) raftConfig() *raft.Config {
 	return config
 }
 
+// Staleness returns how stale a read served by this node at read consistency
+// level "none" would be, as compared with a freshness requirement. It is
+// zero on the Leader.
+func (s *Store) Staleness(strict bool) time.Duration {
+	if !s.open.Is() || s.raft.State() == raft.Leader {
+		return 0
+	}
+	return Staleness(
+		s.raft.LastContact(),
+		s.fsmUpdateTime.Load(),
+		s.appendedAtTime.Load(),
+		s.fsmIdx.Load(),
+		s.raftTn.CommandCommitIndex(),
+		strict)
+}
+
 func (s *Store) isStaleRead(freshness int64, strict bool) bool {
 	if s.raft.State() == raft.Leader {
 		return false
@@ -1821,6 +2339,10 @@ type fsmExecuteResponse struct {
 	error   error
 }
 
+type fsmExecuteBatchResponse struct {
+	responses []*fsmExecuteResponse
+}
+
 type fsmQueryResponse struct {
 	rows  []*proto.QueryRows
 	error error
@@ -1835,6 +2357,29 @@ type fsmGenericResponse struct {
 	error error
 }
 
+// fsmResponseError returns the error of the given response to the
+// application of a log entry, if any. Errors in the results of individual
+// statements are not included.
+func fsmResponseError(r interface{}) error {
+	switch resp := r.(type) {
+	case *fsmExecuteResponse:
+		return resp.error
+	case *fsmQueryResponse:
+		return resp.error
+	case *fsmExecuteQueryResponse:
+		return resp.error
+	case *fsmGenericResponse:
+		return resp.error
+	case *fsmExecuteBatchResponse:
+		for _, er := range resp.responses {
+			if er.error != nil {
+				return er.error
+			}
+		}
+	}
+	return nil
+}
+
 // fsmApply applies a Raft log entry to the database.
 func (s *Store) fsmApply(l *raft.Log) (e interface{}) {
 	defer func() {
@@ -1848,13 +2393,17 @@ func (s *Store) fsmApply(l *raft.Log) (e interface{}) {
 		s.logger.Printf("first log applied since node start, log at index %d", l.Index)
 	}
 
-	cmd, mutated, r := s.cmdProc.Process(l.Data, s.db)
+	cmd, mutated, r := s.cmdProc.Process(l.Data, s.db, l.AppendedAt)
+	if err := fsmResponseError(r); err != nil {
+		s.events.publish(Event{Type: EventApplyError, Index: l.Index, Error: err.Error()})
+	}
 	if mutated {
 		s.dbAppliedIdx.Store(l.Index)
 	}
 	if cmd.Type == proto.Command_COMMAND_TYPE_NOOP {
 		s.numNoops.Add(1)
-	} else if cmd.Type == proto.Command_COMMAND_TYPE_LOAD {
+	} else if cmd.Type == proto.Command_COMMAND_TYPE_LOAD ||
+		(cmd.Type == proto.Command_COMMAND_TYPE_LOAD_CHUNK && mutated) {
 		// Swapping in a new database invalidates any existing snapshot.
 		err := s.snapshotStore.SetFullNeeded()
 		if err != nil {
@@ -1993,6 +2542,7 @@ func (s *Store) fsmSnapshot() (fSnap raft.FSMSnapshot, retErr error) {
 		s.logger.Printf("%s snapshot created in %s on node ID %s", fPLog, dur, s.raftID)
 		fs.logger = s.logger
 	}
+	s.events.publish(Event{Type: EventSnapshot, Index: s.fsmIdx.Load()})
 	return &fs, nil
 }
 
@@ -2108,6 +2658,11 @@ func (s *Store) observe() (closeCh, doneCh chan struct{}) {
 						}
 					}
 				case raft.LeaderObservation:
+					if signal.LeaderID == "" {
+						s.leaderLostTime.Store(time.Now())
+					} else {
+						s.leaderLostTime.Store(time.Time{})
+					}
 					s.leaderObserversMu.RLock()
 					for i := range s.leaderObservers {
 						select {
@@ -2118,6 +2673,11 @@ func (s *Store) observe() (closeCh, doneCh chan struct{}) {
 						}
 					}
 					s.leaderObserversMu.RUnlock()
+					s.events.publish(Event{
+						Type: EventLeaderChange,
+						Node: string(signal.LeaderID),
+						Addr: string(signal.LeaderAddr),
+					})
 					s.selfLeaderChange(signal.LeaderID == raft.ServerID(s.raftID))
 				}
 
@@ -2158,12 +2718,31 @@ func (s *Store) Snapshot(n uint64) (retError error) {
 		if strings.Contains(err.Error(), ErrLoadInProgress.Error()) {
 			return ErrLoadInProgress
 		}
+		if strings.Contains(err.Error(), ErrCASConflict.Error()) {
+			return ErrSnapshotInProgress
+		}
 		return err
 	}
 	stats.Add(numUserSnapshots, 1)
 	return nil
 }
 
+// LastSnapshotIndex returns the index of the most recent snapshot, or zero if
+// no snapshot has been taken.
+func (s *Store) LastSnapshotIndex() (uint64, error) {
+	snaps, err := s.snapshotStore.List()
+	if err != nil {
+		return 0, err
+	}
+	var idx uint64
+	for _, snap := range snaps {
+		if snap.Index > idx {
+			idx = snap.Index
+		}
+	}
+	return idx, nil
+}
+
 // runWALSnapshotting runs the periodic check to see if a snapshot should be
 // triggered due to WAL size.
 func (s *Store) runWALSnapshotting() (closeCh, doneCh chan struct{}) {
@@ -2201,6 +2780,190 @@ func (s *Store) runWALSnapshotting() (closeCh, doneCh chan struct{}) {
 	return closeCh, doneCh
 }
 
+// runVacuumScheduling runs the periodic check to see if a VACUUM should be
+// performed. Only the Leader performs the check.
+func (s *Store) runVacuumScheduling() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	ticker := time.NewTicker(time.Hour) // Just need an initialized ticker to start with.
+	ticker.Stop()
+	if s.VacSchedInterval > 0 {
+		ticker.Reset(s.VacSchedInterval)
+	}
+
+	go func() {
+		defer close(doneCh)
+		defer ticker.Stop()
+		lastIdx := s.raft.LastIndex()
+		lastT := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				idx, now := s.raft.LastIndex(), time.Now()
+				rate := float64(idx-lastIdx) / now.Sub(lastT).Seconds()
+				lastIdx, lastT = idx, now
+				if !s.IsLeader() {
+					continue
+				}
+				if err := s.scheduledVacuum(rate); err != nil {
+					stats.Add(numScheduledVacuumsFailed, 1)
+					s.logger.Printf("scheduled VACUUM failed: %s", err.Error())
+				}
+			case <-closeCh:
+				return
+			}
+		}
+	}()
+	return closeCh, doneCh
+}
+
+// runDBFileStats reads the database file stats, and then rereads them
+// every DBFileStatsInterval.
+func (s *Store) runDBFileStats() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	ticker := time.NewTicker(time.Hour) // Just need an initialized ticker to start with.
+	ticker.Stop()
+	if s.DBFileStatsInterval > 0 {
+		ticker.Reset(s.DBFileStatsInterval)
+	}
+
+	s.refreshDBFileStats()
+	go func() {
+		defer close(doneCh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshDBFileStats()
+			case <-closeCh:
+				return
+			}
+		}
+	}()
+	return closeCh, doneCh
+}
+
+// refreshDBFileStats reads the database file stats, caching them for Stats.
+func (s *Store) refreshDBFileStats() {
+	fs, err := s.db.FileStats()
+	if err != nil {
+		stats.Add(numDBStatsErrors, 1)
+		s.logger.Printf("failed to get database file stats: %s", err.Error())
+		return
+	}
+	s.dbFileStatsMu.Lock()
+	defer s.dbFileStatsMu.Unlock()
+	s.dbFileStats = fs
+	s.dbFileStatsTime = time.Now()
+}
+
+// VacuumReplicated performs a VACUUM, through the Raft log, so every node
+// rewrites its database. In WAL mode the rewritten pages are written to the
+// WAL, so this node is then snapshotted, checkpointing them into the database
+// file and truncating it. Other nodes are truncated at their next snapshot.
+// It returns the database file stats from before the VACUUM and after the
+// snapshot. A failed snapshot is not an error, as the VACUUM has been
+// applied, but the stats afterwards then include the WAL.
+func (s *Store) VacuumReplicated() (before, after *sql.FileStats, err error) {
+	if !s.open.Is() {
+		return nil, nil, ErrNotOpen
+	}
+	if !s.IsLeader() {
+		return nil, nil, ErrNotLeader
+	}
+
+	before, err = s.db.FileStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	startT := time.Now()
+	results, err := s.execute(&proto.ExecuteRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{{Sql: "VACUUM"}},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) == 1 && results[0].Error != "" {
+		return nil, nil, errors.New(results[0].Error)
+	}
+	stats.Add(numReplicatedVacuums, 1)
+	s.logger.Printf("replicated VACUUM completed in %s", time.Since(startT))
+
+	if err := s.Snapshot(0); err != nil {
+		s.logger.Printf("failed to snapshot after replicated VACUUM: %s", err.Error())
+	}
+	s.refreshDBFileStats()
+	after, err = s.db.FileStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// scheduledVacuum performs a VACUUM, through the Raft log, unless the
+// database has too few free pages or the write rate is too high.
+func (s *Store) scheduledVacuum(writeRate float64) (retErr error) {
+	run := map[string]interface{}{
+		"time":       time.Now(),
+		"write_rate": writeRate,
+	}
+	defer func() {
+		if retErr != nil {
+			run["error"] = retErr.Error()
+		}
+		s.vacSchedMu.Lock()
+		defer s.vacSchedMu.Unlock()
+		s.vacSchedLast = run
+	}()
+
+	if s.VacSchedMaxWriteRate > 0 && writeRate > s.VacSchedMaxWriteRate {
+		stats.Add(numScheduledVacuumsSkipped, 1)
+		run["skipped"] = "write rate too high"
+		return nil
+	}
+
+	rows, err := s.db.QueryStringStmt(
+		"SELECT freelist_count, page_count FROM pragma_freelist_count(), pragma_page_count()")
+	if err != nil {
+		return err
+	}
+	if rows[0].Error != "" {
+		return errors.New(rows[0].Error)
+	}
+	var ratio float64
+	vals := rows[0].Values[0].Parameters
+	if pc := vals[1].GetI(); pc > 0 {
+		ratio = float64(vals[0].GetI()) / float64(pc)
+	}
+	run["free_ratio"] = ratio
+	if ratio <= s.VacSchedFreeRatio && s.VacSchedFreeRatio > 0 {
+		stats.Add(numScheduledVacuumsSkipped, 1)
+		run["skipped"] = "free-page ratio below threshold"
+		return nil
+	}
+
+	startT := time.Now()
+	results, err := s.execute(&proto.ExecuteRequest{
+		Request: &proto.Request{
+			Statements: []*proto.Statement{{Sql: "VACUUM"}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(results) == 1 && results[0].Error != "" {
+		return errors.New(results[0].Error)
+	}
+	run["duration"] = time.Since(startT).String()
+	stats.Add(numScheduledVacuums, 1)
+	s.numScheduledVacuums.Add(1)
+	s.logger.Printf("scheduled VACUUM completed in %s", time.Since(startT))
+	return nil
+}
+
 // selfLeaderChange is called when this node detects that its leadership
 // status has changed.
 func (s *Store) selfLeaderChange(leader bool) {
@@ -2294,11 +3057,11 @@ func (s *Store) autoVacNeeded(t time.Time) (bool, error) {
 
 // createOnDisk opens an on-disk database file at the configured path. Any
 // preexisting file will be removed before the database is opened.
-func createOnDisk(path string, fkConstraints, wal bool) (*sql.SwappableDB, error) {
+func createOnDisk(path string, fkConstraints, wal bool, roPragmas map[string]string) (*sql.SwappableDB, error) {
 	if err := sql.RemoveFiles(path); err != nil {
 		return nil, err
 	}
-	return sql.OpenSwappable(path, fkConstraints, wal)
+	return sql.OpenSwappable(path, fkConstraints, wal, roPragmas)
 }
 
 func createTemp(dir, pattern string) (*os.File, error) {
@@ -2407,6 +3170,14 @@ func resolvableAddress(addr string) (string, error) {
 	return h, err
 }
 
+// lag returns how far behind the given index b is of index a.
+func lag(a, b uint64) uint64 {
+	if b >= a {
+		return 0
+	}
+	return a - b
+}
+
 func friendlyBytes(n uint64) string {
 	return humanize.Bytes(n)
 }
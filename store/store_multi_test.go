@@ -153,6 +153,47 @@ func Test_MultiNodeSimple(t *testing.T) {
 // Test_MultiNodeNode_CommitIndexes tests that the commit indexes are
 // correctly updated as nodes join and leave the cluster, and as
 // commands are committed through the Raft log.
+// Test_MultiNodeScheduledVacuum tests that only the Leader performs
+// scheduled VACUUMs.
+func Test_MultiNodeScheduledVacuum(t *testing.T) {
+	s0, ln := mustNewStore(t)
+	defer ln.Close()
+	s0.VacSchedInterval = 100 * time.Millisecond
+
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	s1.VacSchedInterval = 100 * time.Millisecond
+
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join single-node store: %s", err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	testPoll(t, func() bool {
+		return s0.numScheduledVacuums.Load() > 1
+	}, 100*time.Millisecond, 5*time.Second)
+	if n := s1.numScheduledVacuums.Load(); n != 0 {
+		t.Fatalf("follower performed %d scheduled VACUUMs", n)
+	}
+}
+
 func Test_MultiNodeNode_CommitIndexes(t *testing.T) {
 	s0, ln0 := mustNewStore(t)
 	defer s0.Close(true)
@@ -442,6 +483,23 @@ func Test_MultiNodeJoinRemove(t *testing.T) {
 	if storeNodes[0] != nodes[0].ID || storeNodes[1] != nodes[1].ID {
 		t.Fatalf("cluster does not have correct nodes")
 	}
+	for _, n := range nodes {
+		if n.Suffrage != "Voter" {
+			t.Fatalf("wrong suffrage for node %s, exp Voter, got %s", n.ID, n.Suffrage)
+		}
+		if isLeader := n.ID == s0.ID(); isLeader != n.LastContact.IsZero() {
+			t.Fatalf("wrong last contact for node %s: %s", n.ID, n.LastContact)
+		}
+	}
+	nodes, err = s1.Nodes()
+	if err != nil {
+		t.Fatalf("failed to get nodes on follower: %s", err.Error())
+	}
+	for _, n := range nodes {
+		if !n.LastContact.IsZero() {
+			t.Fatalf("last contact reported by follower for node %s", n.ID)
+		}
+	}
 
 	// Should timeout waiting for removal of other node
 	err = s0.WaitForRemoval(s1.ID(), time.Second)
@@ -1151,3 +1209,281 @@ func Test_MultiNodeStoreLogTruncation(t *testing.T) {
 		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
 	}
 }
+
+// Test_MultiNodeLeaderlessFor tests that a node reports how long it has been
+// without a Leader, once its Leader is lost.
+func Test_MultiNodeLeaderlessFor(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+	if d := s1.LeaderlessFor(); d != 0 {
+		t.Fatalf("follower with Leader reports being leaderless for %s", d)
+	}
+
+	// With only two voters, the follower cannot elect a new Leader.
+	if err := s0.Close(true); err != nil {
+		t.Fatalf("failed to close Leader: %s", err.Error())
+	}
+	testPoll(t, func() bool {
+		return s1.LeaderlessFor() > 0
+	}, 100*time.Millisecond, 10*time.Second)
+}
+
+// Test_MultiNodeLastContact tests that the Leader reports when each node
+// last responded to it, and nothing for nodes which never have.
+func Test_MultiNodeLastContact(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+
+	lastContact := func(s *Store, id string) time.Time {
+		nodes, err := s.Nodes()
+		if err != nil {
+			t.Fatalf("failed to get nodes: %s", err.Error())
+		}
+		for _, n := range nodes {
+			if n.ID == id {
+				return n.LastContact
+			}
+		}
+		t.Fatalf("node %s not found", id)
+		return time.Time{}
+	}
+	testPoll(t, func() bool {
+		return time.Since(lastContact(s0, s1.ID())) < 5*time.Second
+	}, 100*time.Millisecond, 10*time.Second)
+	if !lastContact(s0, s0.ID()).IsZero() {
+		t.Fatalf("Leader reported last contact with itself")
+	}
+	if !lastContact(s1, s0.ID()).IsZero() {
+		t.Fatalf("follower reported last contact")
+	}
+
+	// A node which has never responded has no last contact.
+	if err := s0.Join(joinRequest("2", "127.0.0.1:1", false)); err != nil {
+		t.Fatalf("failed to add unreachable node: %s", err.Error())
+	}
+	if !lastContact(s0, "2").IsZero() {
+		t.Fatalf("last contact reported for node which never responded")
+	}
+}
+
+// mockFeaturesGetter returns the features of the store at each Raft address.
+type mockFeaturesGetter struct {
+	features map[string][]string
+}
+
+func (m *mockFeaturesGetter) GetNodeFeatures(addr string, timeout time.Duration) ([]string, error) {
+	fs, ok := m.features[addr]
+	if !ok {
+		return nil, errors.New("no node at " + addr)
+	}
+	return fs, nil
+}
+
+// Test_MultiNodeClusterSupports tests that a feature is only used once every
+// node in the cluster reports supporting it.
+func Test_MultiNodeClusterSupports(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if !s0.clusterSupports(featureExecuteBatch) {
+		t.Fatalf("single-node cluster does not support its own feature")
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+
+	// Membership changed, and the new node can't be asked.
+	if s0.clusterSupports(featureExecuteBatch) {
+		t.Fatalf("feature supported with no way to ask other nodes")
+	}
+
+	// checked asks whether the feature is supported, once the other nodes
+	// have been asked in the background.
+	checked := func() bool {
+		s0.clusterSupports(featureExecuteBatch)
+		testPoll(t, func() bool {
+			fc := &s0.featureChecker
+			fc.mu.Lock()
+			defer fc.mu.Unlock()
+			_, checking := fc.checking[featureExecuteBatch]
+			return !checking
+		}, 10*time.Millisecond, 5*time.Second)
+		return s0.clusterSupports(featureExecuteBatch)
+	}
+
+	fg := &mockFeaturesGetter{features: map[string][]string{s1.Addr(): nil}}
+	s0.SetFeaturesGetter(fg)
+	if checked() {
+		t.Fatalf("feature supported while a node does not report it")
+	}
+
+	fg.features[s1.Addr()] = s1.Features()
+	s0.SetFeaturesGetter(fg)
+	if !checked() {
+		t.Fatalf("feature not supported while every node reports it")
+	}
+
+	// A slow node does not block callers.
+	slow := &slowFeaturesGetter{FeaturesGetter: fg, delay: time.Second}
+	s0.SetFeaturesGetter(slow)
+	start := time.Now()
+	if s0.clusterSupports(featureExecuteBatch) {
+		t.Fatalf("feature supported before any node has answered")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("caller waited on a slow node for %s", d)
+	}
+}
+
+// Test_MultiNodeExecuteIdempotent tests that a request carrying an
+// idempotency key is applied once by every node, and is rejected until every
+// node supports idempotency keys.
+func Test_MultiNodeExecuteIdempotent(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	if _, err := s0.Execute(er); err != nil {
+		t.Fatalf("failed to execute on leader: %s", err.Error())
+	}
+
+	insert := func() ([]*proto.ExecuteResult, error) {
+		er := executeRequestFromStrings([]string{
+			`INSERT INTO foo(name) VALUES("fiona")`,
+		}, false, false)
+		er.IdempotencyKey = "key1"
+		er.IdempotencyDigest = []byte("body")
+		er.IdempotencyWindow = int64(time.Minute)
+		er.IdempotencyMaxKeys = 10
+		return s0.Execute(er)
+	}
+	if _, err := insert(); err != ErrIdempotencyUnsupported {
+		t.Fatalf("wrong error before follower reports support, exp %v, got %v", ErrIdempotencyUnsupported, err)
+	}
+
+	// The first request after the getter is set waits for the follower to
+	// report support, rather than failing.
+	s0.SetFeaturesGetter(&mockFeaturesGetter{features: map[string][]string{s1.Addr(): s1.Features()}})
+	for i, replayed := range []bool{false, true} {
+		r, err := insert()
+		if err != nil {
+			t.Fatalf("failed to execute idempotent request: %s", err.Error())
+		}
+		if len(r) != 1 || r[0].Replayed != replayed {
+			t.Fatalf("wrong results for request %d: %v", i, r)
+		}
+	}
+
+	if _, err := s1.WaitForFSMIndex(s0.fsmIdx.Load(), 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	}
+	qr := queryRequestFromString("SELECT COUNT(*) FROM foo", false, false)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s1.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query follower: %s", err.Error())
+	}
+	if exp, got := `[[1]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+// slowFeaturesGetter delays each answer of the wrapped FeaturesGetter.
+type slowFeaturesGetter struct {
+	FeaturesGetter
+	delay time.Duration
+}
+
+func (s *slowFeaturesGetter) GetNodeFeatures(addr string, timeout time.Duration) ([]string, error) {
+	time.Sleep(s.delay)
+	return s.FeaturesGetter.GetNodeFeatures(addr, timeout)
+}
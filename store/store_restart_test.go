@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"testing"
@@ -302,3 +303,39 @@ func Test_OpenStoreCloseUserSnapshot(t *testing.T) {
 		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
 	}
 }
+
+// Test_StoreReadOnlyVoter tests that a read-only node refuses to bootstrap,
+// and refuses to open if it was previously a voter.
+func Test_StoreReadOnlyVoter(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.ReadOnly = true
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open read-only store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != ErrReadOnly {
+		t.Fatalf("wrong error bootstrapping read-only store, exp %v, got %v", ErrReadOnly, err)
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close read-only store: %s", err.Error())
+	}
+
+	s.ReadOnly = false
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+
+	s.ReadOnly = true
+	if err := s.Open(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("wrong error opening voter as read-only, exp %v, got %v", ErrReadOnly, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"crypto/rand"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net"
@@ -15,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rqlite/rqlite/v8/command/chunking"
 	"github.com/rqlite/rqlite/v8/command/encoding"
 	"github.com/rqlite/rqlite/v8/command/proto"
 	"github.com/rqlite/rqlite/v8/db"
@@ -433,6 +435,18 @@ func Test_SingleNodeSnapshot(t *testing.T) {
 		t.Fatalf("failed to query single node: %s", err.Error())
 	}
 
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatalf("failed to get store stats: %s", err.Error())
+	}
+	raftStats := st["raft"].(map[string]interface{})
+	if n, ok := raftStats["log_entries"].(uint64); !ok || n == 0 {
+		t.Fatalf("expected non-zero Raft log entries, got %v", raftStats["log_entries"])
+	}
+	if _, ok := st["fsm_lag"].(uint64); !ok {
+		t.Fatalf("FSM lag missing from stats")
+	}
+
 	// Snap the node and write to disk.
 	fsm := NewFSM(s)
 	f, err := fsm.Snapshot()
@@ -727,6 +741,256 @@ func Test_SingleNodeExecuteQuery(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeExecuteIdempotent(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	_, err := s.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	insert := func(key, digest string) ([]*proto.ExecuteResult, error) {
+		er := executeRequestFromStrings([]string{
+			`INSERT INTO foo(name) VALUES("fiona")`,
+		}, false, false)
+		er.IdempotencyKey = key
+		er.IdempotencyDigest = []byte(digest)
+		er.IdempotencyWindow = int64(time.Minute)
+		er.IdempotencyMaxKeys = 10
+		return s.Execute(er)
+	}
+
+	// The same request, written to the log twice, is only applied once.
+	for i, replayed := range []bool{false, true} {
+		r, err := insert("key1", "body")
+		if err != nil {
+			t.Fatalf("failed to execute idempotent request: %s", err.Error())
+		}
+		if len(r) != 1 || r[0].LastInsertId != 1 || r[0].Replayed != replayed {
+			t.Fatalf("wrong results for request %d: %v", i, r)
+		}
+	}
+	if _, err := insert("key1", "other"); err != ErrIdempotencyKeyConflict {
+		t.Fatalf("wrong error for reused key, exp %v, got %v", ErrIdempotencyKeyConflict, err)
+	}
+	if r, err := insert("key2", "body"); err != nil || r[0].LastInsertId != 2 || r[0].Replayed {
+		t.Fatalf("request with different key not applied: %v, %v", r, err)
+	}
+
+	qr := queryRequestFromString("SELECT COUNT(*) FROM foo", false, false)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[2]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+// Test_SingleNodeExecuteIdempotentRestore tests that a node restored from a
+// snapshot recognizes the idempotency keys recorded before the snapshot.
+func Test_SingleNodeExecuteIdempotentRestore(t *testing.T) {
+	open := func() *Store {
+		s, ln := mustNewStore(t)
+		t.Cleanup(func() { ln.Close() })
+		if err := s.Open(); err != nil {
+			t.Fatalf("failed to open single-node store: %s", err.Error())
+		}
+		t.Cleanup(func() { s.Close(true) })
+		if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+			t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+		}
+		if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+			t.Fatalf("Error waiting for leader: %s", err)
+		}
+		return s
+	}
+	insert := func(s *Store) []*proto.ExecuteResult {
+		er := executeRequestFromStrings([]string{
+			`INSERT INTO foo(name) VALUES("fiona")`,
+		}, false, false)
+		er.IdempotencyKey = "key1"
+		er.IdempotencyDigest = []byte("body")
+		er.IdempotencyWindow = int64(time.Hour)
+		er.IdempotencyMaxKeys = 10
+		r, err := s.Execute(er)
+		if err != nil {
+			t.Fatalf("failed to execute idempotent request: %s", err.Error())
+		}
+		return r
+	}
+
+	s0 := open()
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	if _, err := s0.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if r := insert(s0); r[0].Replayed {
+		t.Fatalf("first request replayed")
+	}
+
+	f, err := NewFSM(s0).Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot node: %s", err.Error())
+	}
+	snapFile, err := os.Create(filepath.Join(t.TempDir(), "snapshot"))
+	if err != nil {
+		t.Fatalf("failed to create snapshot file: %s", err.Error())
+	}
+	defer snapFile.Close()
+	if err := f.Persist(&mockSnapshotSink{snapFile}); err != nil {
+		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
+	}
+
+	// A node which has never applied the request, restored from the
+	// snapshot, replays the recorded results to a retry.
+	s1 := open()
+	snapFile, err = os.Open(snapFile.Name())
+	if err != nil {
+		t.Fatalf("failed to open snapshot file: %s", err.Error())
+	}
+	defer snapFile.Close()
+	if err := NewFSM(s1).Restore(snapFile); err != nil {
+		t.Fatalf("failed to restore snapshot from disk: %s", err.Error())
+	}
+	if r := insert(s1); len(r) != 1 || !r[0].Replayed || r[0].LastInsertId != 1 {
+		t.Fatalf("retry not replayed on restored node: %v", r)
+	}
+
+	qr := queryRequestFromString("SELECT COUNT(*) FROM foo", false, false)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s1.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeSubscribe(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if _, err := s.Subscribe([]string{"foo"}); err != ErrNotOpen {
+		t.Fatalf("wrong error subscribing to closed store, exp %v, got %v", ErrNotOpen, err)
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	sub, err := s.Subscribe([]string{"foo"})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	defer sub.Close()
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO bar(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	select {
+	case c := <-sub.Changes():
+		if exp := (db.Change{Op: db.ChangeInsert, Table: "foo", RowID: 2}); c != exp {
+			t.Fatalf("wrong change, exp %v, got %v", exp, c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	}
+	if exp, got := 1, s.changes.Len(); exp != got {
+		t.Fatalf("wrong number of subscriptions, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_SingleNodeSubscribeEvents(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if _, err := s.SubscribeEvents(false, 0); err != ErrNotOpen {
+		t.Fatalf("wrong error subscribing to closed store, exp %v, got %v", ErrNotOpen, err)
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	// Replaying from before the store opened receives the election.
+	sub, err := s.SubscribeEvents(true, 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe to events: %s", err.Error())
+	}
+	defer sub.Close()
+	if !sub.Missed() {
+		t.Fatalf("subscription from unknown event does not report missed events")
+	}
+	nextEvent := func(typ EventType) Event {
+		t.Helper()
+		for {
+			select {
+			case e := <-sub.Events():
+				if e.Type == typ {
+					return e
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %s event", typ)
+			}
+		}
+	}
+	if e := nextEvent(EventLeaderChange); e.Node != s.ID() {
+		t.Fatalf("wrong leader in event, exp %s, got %s", s.ID(), e.Node)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if err := s.Snapshot(0); err != nil {
+		t.Fatalf("failed to snapshot store: %s", err.Error())
+	}
+	if e := nextEvent(EventSnapshot); e.Index == 0 {
+		t.Fatalf("snapshot event has no index")
+	}
+}
+
 // Test_SingleNodeExecuteQueryFail ensures database level errors are presented by the store.
 func Test_SingleNodeExecuteQueryFail(t *testing.T) {
 	s, ln := mustNewStore(t)
@@ -1228,6 +1492,55 @@ func Test_SingleNodeExecuteQueryFreshness(t *testing.T) {
 
 // Test_SingleNodeBackup tests that a Store correctly backs up its data
 // in text format.
+func Test_SingleNodeCopyFile(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "rqlite-copytest-")
+	if err != nil {
+		t.Fatalf("unable to create temp file, %s", err.Error())
+	}
+	defer f.Close()
+	if err := s.CopyFile(f); err != nil {
+		t.Fatalf("CopyFile failed %s", err.Error())
+	}
+
+	if !db.IsValidSQLiteFile(f.Name()) {
+		t.Fatalf("copied file is not a valid SQLite file")
+	}
+	dstDB, err := db.Open(f.Name(), false, false)
+	if err != nil {
+		t.Fatalf("unable to open copied database, %s", err.Error())
+	}
+	defer dstDB.Close()
+	rows, err := dstDB.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query copied database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_SingleNodeBackupText(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -1573,6 +1886,89 @@ COMMIT;
 	}
 }
 
+func Test_SingleNodeLoadChunked(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	f, err := os.Open(filepath.Join("testdata", "load.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to open SQLite file: %s", err.Error())
+	}
+	defer f.Close()
+
+	// An aborted stream must leave the database unchanged.
+	chunker := chunking.NewChunker(f, 512)
+	lcr, err := chunker.Next()
+	if err != nil {
+		t.Fatalf("failed to read chunk: %s", err.Error())
+	}
+	if err := s.LoadChunk(lcr); err != nil {
+		t.Fatalf("failed to load chunk: %s", err.Error())
+	}
+	if err := s.LoadChunk(chunker.Abort()); err != nil {
+		t.Fatalf("failed to abort chunked load: %s", err.Error())
+	}
+	qr := queryRequestFromString("SELECT count(*) FROM foo", false, true)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `{"error":"no such table: foo"}`, asJSON(r[0]); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek SQLite file: %s", err.Error())
+	}
+	chunker = chunking.NewChunker(f, 512)
+	for {
+		lcr, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read chunk: %s", err.Error())
+		}
+		if err := s.LoadChunk(lcr); err != nil {
+			t.Fatalf("failed to load chunk: %s", err.Error())
+		}
+		if lcr.IsLast {
+			break
+		}
+	}
+	if n, _, _ := chunker.Counts(); n < 2 {
+		t.Fatalf("expected SQLite file to be loaded in multiple chunks, got %d", n)
+	}
+
+	fn, err := s.snapshotStore.FullNeeded()
+	if err != nil {
+		t.Fatalf("failed to check if snapshot store needs a full snapshot: %s", err.Error())
+	}
+	if !fn {
+		t.Fatalf("expected snapshot store to need a full snapshot")
+	}
+
+	r, err = s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[3]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_SingleNodeAutoRestore(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -1820,14 +2216,21 @@ func Test_SingleNodeUserSnapshot_CAS(t *testing.T) {
 		t.Fatalf("failed to begin snapshot CAS: %s", err.Error())
 	}
 	mustNoop(s, "2")
-	if err := s.Snapshot(0); err == nil {
-		t.Fatalf("expected error snapshotting single-node store with CAS")
+	if err := s.Snapshot(0); err != ErrSnapshotInProgress {
+		t.Fatalf("expected snapshot in progress error snapshotting single-node store with CAS, got %v", err)
 	}
 	s.snapshotCAS.End()
 	mustNoop(s, "3")
 	if err := s.Snapshot(0); err != nil {
 		t.Fatalf("failed to snapshot single-node store: %s", err.Error())
 	}
+	idx, err := s.LastSnapshotIndex()
+	if err != nil {
+		t.Fatalf("failed to get last snapshot index: %s", err.Error())
+	}
+	if exp := s.raft.LastIndex(); idx != exp {
+		t.Fatalf("wrong last snapshot index, exp %d, got %d", exp, idx)
+	}
 }
 
 func Test_SingleNode_WALTriggeredSnapshot(t *testing.T) {
@@ -1978,6 +2381,228 @@ func Test_OpenStoreSingleNode_VacuumFullNeeded(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeScheduledVacuum(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.VacSchedInterval = 100 * time.Millisecond
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	testPoll(t, func() bool {
+		return s.numScheduledVacuums.Load() > 0
+	}, 100*time.Millisecond, 5*time.Second)
+
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatalf("failed to get store stats: %s", err.Error())
+	}
+	svm, ok := st["scheduled_vacuum"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("scheduled vacuum missing from stats")
+	}
+	if _, ok := svm["last_run"]; !ok {
+		t.Fatalf("last run missing from scheduled vacuum stats")
+	}
+
+	// Check the VACUUM didn't disturb the data.
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeSlowRequest(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.SlowRequestThreshold = time.Nanosecond
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	nSlow := stats.Get(numSlowRequests).(*expvar.Int).Value()
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	er.Request.RequestId = "abc-123"
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	if _, err := s.Query(qr); err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := nSlow+2, stats.Get(numSlowRequests).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of slow requests, exp %d, got %d", exp, got)
+	}
+
+	s.SlowRequestThreshold = time.Hour
+	if _, err := s.Query(qr); err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := nSlow+2, stats.Get(numSlowRequests).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of slow requests, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_SingleNodeDBFileStats(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.DBFileStatsInterval = 100 * time.Millisecond
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	dbFileStats := func() map[string]interface{} {
+		st, err := s.Stats()
+		if err != nil {
+			t.Fatalf("failed to get store stats: %s", err.Error())
+		}
+		dfm, ok := st["db_file"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("database file stats missing from stats")
+		}
+		return dfm
+	}
+	dfm := dbFileStats()
+	for _, k := range []string{"file_size", "page_size", "page_count", "freelist_count", "wal_size"} {
+		if _, ok := dfm[k]; !ok {
+			t.Fatalf("%s missing from database file stats", k)
+		}
+	}
+	pageCount := dfm["page_count"].(int64)
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	// The cached stats are refreshed.
+	testPoll(t, func() bool {
+		return dbFileStats()["page_count"].(int64) > pageCount
+	}, 100*time.Millisecond, 5*time.Second)
+}
+
+func Test_SingleNodeVacuumReplicated(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	stmts := []string{`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`}
+	for i := 0; i < 100; i++ {
+		stmts = append(stmts, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 1000)))
+	}
+	stmts = append(stmts, `INSERT INTO bar(name) VALUES("fiona")`)
+	if _, err := s.Execute(executeRequestFromStrings(stmts, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if err := s.Snapshot(0); err != nil {
+		t.Fatalf("failed to snapshot: %s", err.Error())
+	}
+	if _, err := s.Execute(executeRequestFromStrings([]string{`DROP TABLE foo`}, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	before, after, err := s.VacuumReplicated()
+	if err != nil {
+		t.Fatalf("failed to vacuum: %s", err.Error())
+	}
+	if before.FreelistCount == 0 {
+		t.Fatalf("expected free pages before VACUUM")
+	}
+	if after.FreelistCount != 0 {
+		t.Fatalf("expected no free pages after VACUUM, got %d", after.FreelistCount)
+	}
+	if after.FileSize+after.WALSize >= before.FileSize+before.WALSize {
+		t.Fatalf("VACUUM reclaimed no space, before %+v, after %+v", before, after)
+	}
+
+	qr := queryRequestFromString("SELECT * FROM bar", false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeScheduledVacuumFreeRatio(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.VacSchedInterval = 100 * time.Millisecond
+	s.VacSchedFreeRatio = 0.5
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	// A new database has no free pages, so VACUUM should be skipped.
+	testPoll(t, func() bool {
+		s.vacSchedMu.Lock()
+		defer s.vacSchedMu.Unlock()
+		return s.vacSchedLast != nil && s.vacSchedLast["skipped"] != nil
+	}, 100*time.Millisecond, 5*time.Second)
+	if n := s.numScheduledVacuums.Load(); n != 0 {
+		t.Fatalf("VACUUM performed despite low free-page ratio, %d performed", n)
+	}
+}
+
 func Test_SingleNodeExplicitVacuumOK(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -2963,3 +3588,147 @@ func testPoll(t *testing.T, f func() bool, checkPeriod time.Duration, timeout ti
 		}
 	}
 }
+
+func Test_SingleNodeExecuteBatching(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.ExecuteBatchWindow = 200 * time.Millisecond
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	idx := s.raft.LastIndex()
+	const n = 10
+	var wg sync.WaitGroup
+	ids := make([]int64, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			er := executeRequestFromString(`INSERT INTO foo(name) VALUES("fiona")`, false, false)
+			res, err := s.Execute(er)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			ids[i] = res[0].LastInsertId
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("failed to execute batched request: %s", errs[i].Error())
+		}
+		if seen[ids[i]] {
+			t.Fatalf("duplicate last insert ID %d returned", ids[i])
+		}
+		seen[ids[i]] = true
+	}
+	if got := s.raft.LastIndex() - idx; got != 1 {
+		t.Fatalf("expected batched writes to use 1 log entry, used %d", got)
+	}
+
+	qr := queryRequestFromString("SELECT COUNT(*) FROM foo", false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[10]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// An error in one request must not affect the others in the batch.
+	idx = s.raft.LastIndex()
+	errs = make([]error, 2)
+	for i, stmt := range []string{`INSERT INTO bar(name) VALUES("fiona")`, `INSERT INTO foo(name) VALUES("declan")`} {
+		wg.Add(1)
+		go func(i int, stmt string) {
+			defer wg.Done()
+			res, err := s.Execute(executeRequestFromString(stmt, false, false))
+			if err == nil && res[0].Error != "" {
+				err = errors.New(res[0].Error)
+			}
+			errs[i] = err
+		}(i, stmt)
+	}
+	wg.Wait()
+	if errs[0] == nil {
+		t.Fatalf("expected error for insert into nonexistent table")
+	}
+	if errs[1] != nil {
+		t.Fatalf("unexpected error for valid insert: %s", errs[1].Error())
+	}
+	if got := s.raft.LastIndex() - idx; got != 1 {
+		t.Fatalf("expected batched writes to use 1 log entry, used %d", got)
+	}
+}
+
+func Test_SingleNodeQueryStream(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	for _, lvl := range []proto.QueryRequest_Level{
+		proto.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+		proto.QueryRequest_QUERY_REQUEST_LEVEL_WEAK,
+	} {
+		qr := queryRequestFromString("SELECT * FROM foo ORDER BY id", false, false)
+		qr.Level = lvl
+		var names []string
+		err := s.QueryStream(qr, func(columns, types []string, values *proto.Values) error {
+			names = append(names, values.Parameters[1].GetS())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to stream query at level %s: %s", lvl, err.Error())
+		}
+		if exp, got := `["fiona","declan"]`, asJSON(names); exp != got {
+			t.Fatalf("unexpected rows at level %s\nexp: %s\ngot: %s", lvl, exp, got)
+		}
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	if err := s.QueryStream(qr, nil); err != ErrStreamUnsupported {
+		t.Fatalf("expected ErrStreamUnsupported for strong read, got %v", err)
+	}
+	qr = queryRequestFromStrings([]string{"SELECT * FROM foo", "SELECT * FROM foo"}, false, false)
+	if err := s.QueryStream(qr, nil); err != ErrStreamUnsupported {
+		t.Fatalf("expected ErrStreamUnsupported for multiple queries, got %v", err)
+	}
+}
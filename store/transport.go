@@ -3,6 +3,7 @@ package store
 import (
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -57,6 +58,9 @@ type NodeTransport struct {
 	leaderCommitIndex  *atomic.Uint64
 	done               chan struct{}
 	closed             bool
+
+	lastContactMu sync.Mutex
+	lastContact   map[raft.ServerID]time.Time
 }
 
 // NewNodeTransport returns an initialized NodeTransport.
@@ -66,6 +70,7 @@ func NewNodeTransport(transport *raft.NetworkTransport) *NodeTransport {
 		commandCommitIndex: &atomic.Uint64{},
 		leaderCommitIndex:  &atomic.Uint64{},
 		done:               make(chan struct{}),
+		lastContact:        make(map[raft.ServerID]time.Time),
 	}
 }
 
@@ -81,6 +86,35 @@ func (n *NodeTransport) LeaderCommitIndex() uint64 {
 	return n.leaderCommitIndex.Load()
 }
 
+// LastContact returns the time the node with the given ID last responded to
+// an AppendEntries or InstallSnapshot RPC sent by this node, and whether it
+// has ever responded. The Leader heartbeats every node with AppendEntries,
+// so on the Leader this is when each node was last known to be reachable.
+func (n *NodeTransport) LastContact(id raft.ServerID) (time.Time, bool) {
+	n.lastContactMu.Lock()
+	defer n.lastContactMu.Unlock()
+	t, ok := n.lastContact[id]
+	return t, ok
+}
+
+// AppendEntries sends the appropriate RPC to the target node, recording
+// when the node responded.
+func (n *NodeTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+	if err := n.NetworkTransport.AppendEntries(id, target, args, resp); err != nil {
+		return err
+	}
+	n.contacted(id)
+	return nil
+}
+
+// contacted records that the node with the given ID responded just now.
+func (n *NodeTransport) contacted(id raft.ServerID) {
+	n.lastContactMu.Lock()
+	defer n.lastContactMu.Unlock()
+	n.lastContact[id] = time.Now()
+}
+
 // Close closes the transport
 func (n *NodeTransport) Close() error {
 	if n.closed {
@@ -104,7 +138,11 @@ func (n *NodeTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddr
 		return err
 	}
 	defer gzipData.Close()
-	return n.NetworkTransport.InstallSnapshot(id, target, args, resp, gzipData)
+	if err := n.NetworkTransport.InstallSnapshot(id, target, args, resp, gzipData); err != nil {
+		return err
+	}
+	n.contacted(id)
+	return nil
 }
 
 // Consumer returns a channel of RPC requests to be consumed.
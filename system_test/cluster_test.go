@@ -750,6 +750,61 @@ func Test_MultiNodeClusterNodes(t *testing.T) {
 	}
 }
 
+// Test_MultiNodeClusterAdvertise tests that changing the Leader's advertised
+// API address is reflected in nodes/ output and redirects.
+func Test_MultiNodeClusterAdvertise(t *testing.T) {
+	node1 := mustNewLeaderNode("leader1")
+	defer node1.Deprovision()
+
+	node2 := mustNewNode("node2", false)
+	defer node2.Deprovision()
+	if err := node2.Join(node1); err != nil {
+		t.Fatalf("node failed to join leader: %s", err.Error())
+	}
+	_, err := node2.WaitForLeader()
+	if err != nil {
+		t.Fatalf("failed waiting for leader: %s", err.Error())
+	}
+
+	c := Cluster{node1, node2}
+	leader, err := c.Leader()
+	if err != nil {
+		t.Fatalf("failed to find cluster leader: %s", err.Error())
+	}
+	followers, err := c.Followers()
+	if err != nil {
+		t.Fatalf("failed to get followers: %s", err.Error())
+	}
+	f := followers[0]
+
+	if err := f.Advertise("new-host:4001"); err == nil {
+		t.Fatalf("follower accepted advertised address change")
+	}
+	if err := leader.Advertise("new-host:4001"); err != nil {
+		t.Fatalf("failed to change advertised address: %s", err.Error())
+	}
+
+	nodes, err := f.Nodes(false)
+	if err != nil {
+		t.Fatalf("failed to get nodes status: %s", err.Error())
+	}
+	ns := nodes.GetNode(leader.ID)
+	if ns == nil {
+		t.Fatalf("failed to find leader with ID %s in node status", leader.ID)
+	}
+	if exp, got := "http://new-host:4001", ns.APIAddr; exp != got {
+		t.Fatalf("node has wrong API address for leader, got %s, exp %s", got, exp)
+	}
+
+	loc, err := f.RedirectLocation()
+	if err != nil {
+		t.Fatalf("failed to get redirect location: %s", err.Error())
+	}
+	if exp := "http://new-host:4001/db/query?q=SELECT%201&redirect"; loc != exp {
+		t.Fatalf("follower redirected to wrong location, got %s, exp %s", loc, exp)
+	}
+}
+
 // Test_MultiNodeClusterQueuedWrites tests writing to a cluster using
 // normal and queued writes.
 func Test_MultiNodeClusterQueuedWrites(t *testing.T) {
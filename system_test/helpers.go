@@ -419,6 +419,38 @@ func (n *Node) ConfirmRedirect(host string) bool {
 	return true
 }
 
+// Advertise changes the API address the node advertises to the cluster.
+func (n *Node) Advertise(addr string) error {
+	resp, err := http.Post("http://"+n.APIAddr+"/node/advertise", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"addr": %q}`, addr)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("advertise endpoint returned: %s", resp.Status)
+	}
+	return nil
+}
+
+// RedirectLocation returns the location to which the node redirects a query.
+func (n *Node) RedirectLocation() (string, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + n.APIAddr + "/db/query?q=SELECT%201&redirect")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		return "", fmt.Errorf("query endpoint returned: %s", resp.Status)
+	}
+	return resp.Header.Get("location"), nil
+}
+
 func (n *Node) postExecute(stmt string) (string, error) {
 	resp, err := http.Post("http://"+n.APIAddr+"/db/execute", "application/json", strings.NewReader(stmt))
 	if err != nil {
@@ -743,6 +775,7 @@ func mustNodeEncrypted(id, dir string, enableSingle, httpEncrypt bool, mux *tcp.
 	clstrClient := cluster.NewClient(clstrDialer, 30*time.Second)
 	node.Client = clstrClient
 	node.Service = httpd.New("localhost:0", node.Store, clstrClient, nil)
+	node.Service.Advertiser = clstr
 	if httpEncrypt {
 		node.Service.CertFile = node.HTTPCertPath
 		node.Service.KeyFile = node.HTTPKeyPath
@@ -0,0 +1,89 @@
+// Package tracing configures the export of OpenTelemetry traces.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ServiceName is the name under which rqlite reports its spans.
+	ServiceName = "rqlite"
+
+	// instrumentationName names the tracer used throughout rqlite.
+	instrumentationName = "github.com/rqlite/rqlite/v8"
+)
+
+// Config is the configuration of trace export.
+type Config struct {
+	// Endpoint is the URL of the OTLP/HTTP collector to which spans are
+	// exported, for example http://localhost:4318. Spans are sent to the
+	// /v1/traces path of the URL, unless it has a path of its own.
+	Endpoint string
+
+	// NodeID is the ID of this node, attached to every span.
+	NodeID string
+
+	// Version is the version of rqlite, attached to every span.
+	Version string
+
+	// SampleRatio is the fraction of traces started by this node which are
+	// sampled. Traces started elsewhere are sampled if their parent was.
+	SampleRatio float64
+}
+
+// Setup configures the global tracer provider to export spans as set out
+// by cfg, and the global propagator to read and write W3C Trace Context
+// headers. The returned function flushes any spans not yet exported, and
+// stops export. If no endpoint is configured, spans are not recorded and
+// the returned function does nothing.
+func Setup(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", cfg.Endpoint)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %s", err.Error())
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", ServiceName),
+		attribute.String("service.version", cfg.Version),
+		attribute.String("service.instance.id", cfg.NodeID),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer with which rqlite creates spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func Test_SetupNoEndpoint(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	stop, err := Setup(context.Background(), &Config{})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %s", err.Error())
+	}
+	if otel.GetTracerProvider() != tp {
+		t.Fatalf("tracer provider changed with no endpoint")
+	}
+	_, span := Tracer().Start(context.Background(), "test")
+	if span.IsRecording() {
+		t.Fatalf("span recording with no endpoint")
+	}
+	span.End()
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop tracing: %s", err.Error())
+	}
+}
+
+func Test_SetupInvalidEndpoint(t *testing.T) {
+	for _, ep := range []string{
+		"localhost:4318",
+		"ftp://localhost:4318",
+		"http://",
+		"://bad",
+	} {
+		if _, err := Setup(context.Background(), &Config{Endpoint: ep}); err == nil {
+			t.Fatalf("expected error for endpoint %s", ep)
+		}
+	}
+}
+
+func Test_SetupEndpoint(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	stop, err := Setup(context.Background(), &Config{
+		Endpoint:    "http://127.0.0.1:4318",
+		NodeID:      "node1",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %s", err.Error())
+	}
+	_, span := Tracer().Start(context.Background(), "test")
+	if !span.IsRecording() {
+		t.Fatalf("span not recording with endpoint")
+	}
+	if !span.SpanContext().IsSampled() {
+		t.Fatalf("span not sampled with sample ratio of 1")
+	}
+
+	// Don't end the span, so shutdown has nothing to export.
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop tracing: %s", err.Error())
+	}
+}